@@ -0,0 +1,275 @@
+package pg
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/IceFireDB/IceFireDB/IceFireDB-PGProxy/pkg/config"
+	"github.com/IceFireDB/IceFireDB/IceFireDB-PGProxy/pkg/pgproto"
+	"github.com/IceFireDB/IceFireDB/IceFireDB-PGProxy/utils"
+	"github.com/IceFireDB/IceFireDB/IceFireDB-SQLProxy/pkg/p2p"
+	"github.com/IceFireDB/IceFireDB/IceFireDB-SQLProxy/pkg/replication"
+	"github.com/IceFireDB/IceFireDB/IceFireDB-SQLite/pkg/p2p/security"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	p2pHost   *p2p.P2P
+	p2pPubSub *p2p.PubSub
+	// The transactions written through this node
+	journal *replication.Journal
+	// The positions of the writers applied by this node
+	tracker *replication.Tracker
+	// Keeps the entries published in the order of the journal
+	broadcastLock sync.Mutex
+	// The backend connection applying the entries of the peers
+	applier     *pgproto.Conn
+	applierLock sync.Mutex
+)
+
+// The interval of the head announcements of the journal
+const headInterval = 10 * time.Second
+
+// The table of the backend recording the last transaction applied per writer
+const gtidTable = "icefiredb_gtid_executed"
+
+func initP2P(p *pgProxy) {
+	secConf := config.Get().P2P.Security
+	p2p.DefaultSecurityConfig = security.Config{
+		Transports: secConf.Transports,
+		KeyTypes:   secConf.KeyTypes,
+		FIPS:       secConf.FIPS,
+	}
+	if err := p2p.DefaultSecurityConfig.Validate(); err != nil {
+		panic(err)
+	}
+	p2pHost = p2p.NewP2P(config.Get().P2P.ServiceDiscoveryID,
+		config.Get().P2P.NodeHostIP, config.Get().P2P.NodeHostPort)
+
+	switch strings.ToLower(config.Get().P2P.ServiceDiscoverMode) {
+	case "announce":
+		p2pHost.AnnounceConnect()
+	default:
+		p2pHost.AdvertiseConnect()
+	}
+	logrus.Info("Connected to P2P network")
+
+	var err error
+	p2pPubSub, err = p2p.JoinPubSub(p2pHost, "postgres", config.Get().P2P.ServiceCommandTopic)
+	if err != nil {
+		panic(err)
+	}
+
+	if err := initGTID(); err != nil {
+		panic(err)
+	}
+	journal = replication.NewJournal(p2pHost.Host.ID().String(), config.Get().P2P.JournalSize)
+	tracker = replication.NewTracker(journal.Source(), loadGTID, config.Get().P2P.MaxPending)
+
+	logrus.Info("Successfully initialized P2P channel")
+	asyncSQL(p)
+}
+
+func asyncSQL(p *pgProxy) {
+	utils.GoWithRecover(func() {
+		headTicker := time.NewTicker(headInterval)
+		defer headTicker.Stop()
+
+		for {
+			select {
+			case <-p.ctx.Done():
+				p2pPubSub.Exit()
+				_ = p2pHost.Host.Close()
+				_ = p2pHost.KadDHT.Close()
+				return
+
+			case msg := <-p2pPubSub.Inbound:
+				handleMessage(msg)
+
+			case <-headTicker.C:
+				if head := journal.Head(); head.Seq > 0 {
+					publish(replication.EncodeHead(head))
+				}
+			}
+		}
+	}, func(r interface{}) {
+		time.Sleep(time.Second)
+		asyncSQL(p)
+	})
+}
+
+// handleMessage handles a message of the replication topic: the entries are
+// applied in the order of their writer, and the resend requests for the
+// entries of this node are answered from the journal
+func handleMessage(s *p2p.Message) {
+	msg, err := replication.Decode(s.Content)
+	if err != nil {
+		logrus.Infof("Inbound message: %s err: %v", s.Content, err)
+		return
+	}
+	switch {
+	case msg.Resend != nil:
+		if msg.Resend.Source != journal.Source() {
+			return
+		}
+		for _, e := range journal.Range(msg.Resend.From, msg.Resend.To) {
+			publish(replication.EncodeEntry(e))
+		}
+	case msg.Head != nil:
+		if msg.Head.Source != s.SenderID || msg.Head.Source == journal.Source() {
+			return
+		}
+		resend, err := tracker.Head(*msg.Head)
+		if err != nil {
+			logrus.Errorf("Inbound head of %s err: %v", msg.Head.Source, err)
+			return
+		}
+		if resend != nil {
+			publish(replication.EncodeResend(*resend))
+		}
+	case msg.Entry != nil:
+		if msg.Entry.Source != s.SenderID || msg.Entry.Source == journal.Source() {
+			return
+		}
+		ready, resend, err := tracker.Receive(*msg.Entry)
+		if err != nil {
+			logrus.Errorf("Inbound entry %s:%d err: %v", msg.Entry.Source, msg.Entry.Seq, err)
+			return
+		}
+		if resend != nil {
+			logrus.Infof("Inbound entry %s:%d after a gap, request %d..%d", msg.Entry.Source, msg.Entry.Seq, resend.From, resend.To)
+			publish(replication.EncodeResend(*resend))
+		}
+		for i, e := range ready {
+			if err := applyEntry(e); err != nil {
+				logrus.Errorf("Inbound entry %s:%d err: %v", e.Source, e.Seq, err)
+				// the entries left are requested again
+				for _, e := range ready[i:] {
+					tracker.Forget(e.Source)
+				}
+				return
+			}
+			logrus.Infof("Inbound entry %s:%d, %d statements", e.Source, e.Seq, len(e.Ops))
+		}
+	}
+}
+
+// withApplier runs fn on the applier connection, which is reopened after an
+// error other than a statement error
+func withApplier(fn func(conn *pgproto.Conn) error) error {
+	applierLock.Lock()
+	defer applierLock.Unlock()
+	if applier == nil {
+		pc := config.Get().Postgres
+		conn, err := pgproto.Connect(pc.Addr, pc.User, pc.Password, pc.DBName)
+		if err != nil {
+			return err
+		}
+		applier = conn
+	}
+	err := fn(applier)
+	var pgErr *pgproto.Error
+	if err != nil && !errors.As(err, &pgErr) {
+		_ = applier.Close()
+		applier = nil
+	}
+	return err
+}
+
+func execOp(conn *pgproto.Conn, op replication.Op) (*pgproto.Result, error) {
+	if op.Extended() {
+		return conn.ExecParams(op.SQL, op.Types, op.Formats, op.Params)
+	}
+	return conn.Exec(op.SQL)
+}
+
+// applyEntry executes the statements of an entry in a transaction that also
+// records the entry as applied. An entry refused by the backend is recorded
+// and skipped.
+func applyEntry(e replication.Entry) error {
+	return withApplier(func(conn *pgproto.Conn) (err error) {
+		if _, err = conn.Exec("BEGIN"); err != nil {
+			return err
+		}
+		for _, op := range e.Ops {
+			if _, err = execOp(conn, op); err != nil {
+				break
+			}
+		}
+		if err != nil {
+			var pgErr *pgproto.Error
+			if !errors.As(err, &pgErr) {
+				return err
+			}
+			logrus.Infof("Inbound entry %s:%d sql err: %v", e.Source, e.Seq, err)
+			if _, err = conn.Exec("ROLLBACK"); err != nil {
+				return err
+			}
+		}
+		_, err = conn.ExecParams("INSERT INTO "+gtidTable+" (source, seq) VALUES ($1, $2) ON CONFLICT (source) DO UPDATE SET seq = EXCLUDED.seq",
+			nil, nil, [][]byte{[]byte(e.Source), []byte(strconv.FormatUint(e.Seq, 10))})
+		if err != nil {
+			return err
+		}
+		if conn.IsInTransaction() {
+			_, err = conn.Exec("COMMIT")
+		}
+		return err
+	})
+}
+
+// initGTID creates the table recording the applied entries
+func initGTID() error {
+	return withApplier(func(conn *pgproto.Conn) error {
+		_, err := conn.Exec("CREATE TABLE IF NOT EXISTS " + gtidTable + " (source VARCHAR(128) NOT NULL PRIMARY KEY, seq BIGINT NOT NULL)")
+		return err
+	})
+}
+
+// loadGTID returns the last entry of the writer applied by this node
+func loadGTID(source string) (seq uint64, err error) {
+	err = withApplier(func(conn *pgproto.Conn) error {
+		res, err := conn.ExecParams("SELECT seq FROM "+gtidTable+" WHERE source = $1", nil, nil, [][]byte{[]byte(source)})
+		if err != nil || len(res.Rows) == 0 {
+			return err
+		}
+		seq, err = strconv.ParseUint(string(res.Rows[0][0]), 10, 64)
+		return err
+	})
+	return seq, err
+}
+
+func publish(msg string, err error) {
+	if err != nil {
+		logrus.Errorf("Outbound encode err: %v", err)
+		return
+	}
+	p2pPubSub.Outbound <- msg
+}
+
+// broadcast publishes a transaction written through this node
+func broadcast(ops []replication.Op) {
+	if p2pPubSub == nil || len(ops) == 0 {
+		return
+	}
+
+	broadcastLock.Lock()
+	defer broadcastLock.Unlock()
+	// the DDL is applied after the writes this node applied before it
+	var after map[string]uint64
+	for _, op := range ops {
+		if isDDL(op.SQL) {
+			after = tracker.Positions()
+			break
+		}
+	}
+	e := journal.Append(ops, after)
+	msg, err := replication.EncodeEntry(e)
+	publish(msg, err)
+	if err == nil {
+		logrus.Infof("Outbound entry %s:%d, %d statements", e.Source, e.Seq, len(ops))
+	}
+}
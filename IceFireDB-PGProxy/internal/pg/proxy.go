@@ -0,0 +1,360 @@
+package pg
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"net"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/IceFireDB/IceFireDB/IceFireDB-PGProxy/pkg/config"
+	"github.com/IceFireDB/IceFireDB/IceFireDB-PGProxy/pkg/pgproto"
+	"github.com/IceFireDB/IceFireDB/IceFireDB-SQLProxy/pkg/replication"
+	"github.com/sirupsen/logrus"
+)
+
+// session relays the messages of a client to its own backend connection,
+// and follows the statements and their results to replicate the writes of
+// the committed transactions
+type session struct {
+	client  net.Conn
+	backend net.Conn
+
+	mu sync.Mutex
+	// The prepared statements and the portals of the extended query protocol
+	statements map[string]statement
+	portals    map[string]replication.Op
+	// The Query, Execute and Sync messages waiting for their results, in the
+	// order of the backend responses
+	pending []request
+	// Tells an explicit transaction is open
+	inTx bool
+	// The writes of the open transaction
+	txOps []replication.Op
+}
+
+type statement struct {
+	sql   string
+	types []uint32
+}
+
+type request struct {
+	kind byte
+	op   replication.Op
+	// For a simple query, tells it wrote and failed
+	write  bool
+	failed bool
+}
+
+// The commands of the CommandComplete tags replicated
+var writeCommands = []string{
+	"INSERT",
+	"UPDATE",
+	"DELETE",
+	"MERGE",
+	"CREATE",
+	"ALTER",
+	"DROP",
+	"TRUNCATE",
+	"COMMENT",
+	"GRANT",
+	"REVOKE",
+	"REFRESH",
+	"SAVEPOINT",
+	"RELEASE",
+}
+
+var rollbackToRe = regexp.MustCompile(`(?is)^\s*ROLLBACK\s+(?:WORK\s+|TRANSACTION\s+)?TO\b`)
+
+// isWrite tells whether a statement completed with the tag is replicated. A
+// ROLLBACK TO SAVEPOINT is replayed like the SAVEPOINT.
+func isWrite(tag string, sql string) bool {
+	command := pgproto.TagCommand(tag)
+	if command == "ROLLBACK" {
+		return rollbackToRe.MatchString(sql)
+	}
+	for _, v := range writeCommands {
+		if command == v || strings.HasPrefix(command, v+" ") {
+			return true
+		}
+	}
+	return false
+}
+
+// isDDL tells whether the statement changes the schema
+func isDDL(sql string) bool {
+	prefix := strings.ToUpper(strings.TrimSpace(sql))
+	for _, v := range []string{"CREATE", "ALTER", "DROP", "TRUNCATE"} {
+		if strings.HasPrefix(prefix, v) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *pgProxy) onConn(c net.Conn) {
+	defer c.Close()
+	packet, c, r, err := p.startup(c)
+	if err != nil || packet == nil {
+		if err != nil {
+			logrus.Infof("postgres startup from %s err: %v", c.RemoteAddr(), err)
+		}
+		return
+	}
+
+	backend, err := net.Dial("tcp", config.Get().Postgres.Addr)
+	if err != nil {
+		logrus.Errorf("get remote conn err: %v", err)
+		_, _ = c.Write(pgproto.EncodeError(&pgproto.Error{Severity: "FATAL", Code: "08006", Message: "backend unavailable"}))
+		return
+	}
+	defer backend.Close()
+	if _, err = backend.Write(packet); err != nil {
+		return
+	}
+
+	s := &session{
+		client:     c,
+		backend:    backend,
+		statements: make(map[string]statement),
+		portals:    make(map[string]replication.Op),
+	}
+	go s.relayBackend()
+	s.relayClient(r)
+}
+
+// startup reads the startup message of a client. An SSLRequest upgrades the
+// connection when TLS is configured, GSSENCRequest is refused. It returns the
+// connection and its reader, upgraded or not. A cancel request is forwarded to
+// the backend and nil is returned.
+func (p *pgProxy) startup(c net.Conn) ([]byte, net.Conn, *bufio.Reader, error) {
+	r := bufio.NewReader(c)
+	encrypted := false
+	for {
+		packet, err := pgproto.ReadStartup(r)
+		if err != nil {
+			return nil, c, r, err
+		}
+		switch pgproto.StartupCode(packet) {
+		case pgproto.SSLRequestCode:
+			if p.tls == nil || encrypted {
+				if _, err = c.Write([]byte{'N'}); err != nil {
+					return nil, c, r, err
+				}
+				continue
+			}
+			// the bytes sent before the handshake would be read as if
+			// they were encrypted
+			if r.Buffered() > 0 {
+				return nil, c, r, errors.New("data received before the TLS handshake")
+			}
+			if _, err = c.Write([]byte{'S'}); err != nil {
+				return nil, c, r, err
+			}
+			c = tls.Server(c, p.tls)
+			r = bufio.NewReader(c)
+			encrypted = true
+		case pgproto.GSSENCRequestCode:
+			if _, err = c.Write([]byte{'N'}); err != nil {
+				return nil, c, r, err
+			}
+		case pgproto.CancelRequestCode:
+			backend, err := net.Dial("tcp", config.Get().Postgres.Addr)
+			if err != nil {
+				return nil, c, r, err
+			}
+			_, err = backend.Write(packet)
+			backend.Close()
+			return nil, c, r, err
+		case pgproto.ProtocolVersion:
+			return packet, c, r, nil
+		default:
+			_, _ = c.Write(pgproto.EncodeError(&pgproto.Error{Severity: "FATAL", Code: "0A000", Message: "unsupported frontend protocol"}))
+			return nil, c, r, nil
+		}
+	}
+}
+
+// relayClient forwards the messages of the client to the backend
+func (s *session) relayClient(r *bufio.Reader) {
+	defer s.backend.Close()
+	for {
+		msg, err := pgproto.ReadMessage(r)
+		if err != nil {
+			return
+		}
+		s.frontend(msg)
+		if _, err = s.backend.Write(msg.Bytes()); err != nil || msg.Type == pgproto.MsgTerminate {
+			return
+		}
+	}
+}
+
+// relayBackend forwards the messages of the backend to the client, flushed
+// when no other message is buffered
+func (s *session) relayBackend() {
+	defer s.client.Close()
+	r := bufio.NewReader(s.backend)
+	w := bufio.NewWriter(s.client)
+	for {
+		msg, err := pgproto.ReadMessage(r)
+		if err != nil {
+			return
+		}
+		s.backendMessage(msg)
+		if _, err = w.Write(msg.Bytes()); err != nil {
+			return
+		}
+		if r.Buffered() == 0 {
+			if err = w.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// frontend follows a message of the client
+func (s *session) frontend(msg pgproto.Message) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch msg.Type {
+	case pgproto.MsgQuery:
+		sql, err := pgproto.DecodeQuery(msg.Body)
+		if err != nil {
+			return
+		}
+		s.pending = append(s.pending, request{kind: msg.Type, op: replication.Op{SQL: sql}})
+	case pgproto.MsgParse:
+		name, sql, types, err := pgproto.DecodeParse(msg.Body)
+		if err != nil {
+			return
+		}
+		s.statements[name] = statement{sql: sql, types: types}
+	case pgproto.MsgBind:
+		b, err := pgproto.DecodeBind(msg.Body)
+		if err != nil {
+			return
+		}
+		stmt := s.statements[b.Statement]
+		s.portals[b.Portal] = replication.Op{SQL: stmt.sql, Types: stmt.types, Formats: b.Formats, Params: b.Params}
+	case pgproto.MsgExecute:
+		portal, _, err := pgproto.DecodeExecute(msg.Body)
+		if err != nil {
+			return
+		}
+		s.pending = append(s.pending, request{kind: msg.Type, op: s.portals[portal]})
+	case pgproto.MsgClose:
+		kind, name, err := pgproto.DecodeClose(msg.Body)
+		if err != nil {
+			return
+		}
+		if kind == 'S' {
+			delete(s.statements, name)
+		} else {
+			delete(s.portals, name)
+		}
+	case pgproto.MsgSync:
+		s.pending = append(s.pending, request{kind: msg.Type})
+	}
+}
+
+// backendMessage follows a message of the backend
+func (s *session) backendMessage(msg pgproto.Message) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var head *request
+	if len(s.pending) > 0 {
+		head = &s.pending[0]
+	}
+	switch msg.Type {
+	case pgproto.MsgCommandComplete:
+		tag, err := pgproto.DecodeCommandComplete(msg.Body)
+		if err != nil || head == nil {
+			return
+		}
+		s.complete(head, tag)
+		if head.kind == pgproto.MsgExecute {
+			s.pending = s.pending[1:]
+		}
+	case pgproto.MsgEmptyQueryResponse, pgproto.MsgPortalSuspended:
+		// a suspended portal completes with a later Execute
+		if head != nil && head.kind == pgproto.MsgExecute {
+			s.pending = s.pending[1:]
+		}
+	case pgproto.MsgErrorResponse:
+		if head == nil {
+			return
+		}
+		if head.kind == pgproto.MsgQuery {
+			head.failed = true
+		} else {
+			// the backend skips the messages up to the Sync
+			for len(s.pending) > 0 && s.pending[0].kind == pgproto.MsgExecute {
+				s.pending = s.pending[1:]
+			}
+		}
+		if !s.inTx {
+			// the implicit transaction is rolled back
+			s.txOps = nil
+		}
+	case pgproto.MsgReadyForQuery:
+		status, err := pgproto.DecodeReadyForQuery(msg.Body)
+		if err != nil || head == nil {
+			return
+		}
+		if head.kind == pgproto.MsgQuery && head.write && !head.failed {
+			s.txOps = append(s.txOps, head.op)
+		}
+		s.pending = s.pending[1:]
+		s.inTx = status != pgproto.TxIdle
+		if !s.inTx {
+			s.publish()
+		}
+	}
+}
+
+// complete follows the completion of a statement
+func (s *session) complete(head *request, tag string) {
+	switch pgproto.TagCommand(tag) {
+	case "BEGIN", "START TRANSACTION":
+		s.inTx = true
+		return
+	case "COMMIT":
+		if head.kind == pgproto.MsgExecute {
+			s.publish()
+		}
+		s.inTx = false
+		return
+	case "ROLLBACK":
+		if !rollbackToRe.MatchString(head.op.SQL) {
+			// also the tag of the COMMIT of a failed transaction
+			s.txOps = nil
+			s.inTx = false
+			return
+		}
+	}
+	if !isWrite(tag, head.op.SQL) {
+		return
+	}
+	if head.kind == pgproto.MsgQuery {
+		// a simple query is replicated whole once it succeeds
+		head.write = true
+		return
+	}
+	if head.op.SQL == "" {
+		logrus.Warningf("postgres write of an unknown portal not replicated")
+		return
+	}
+	s.txOps = append(s.txOps, head.op)
+}
+
+// publish publishes the writes of the transaction committed
+func (s *session) publish() {
+	if len(s.txOps) == 0 {
+		return
+	}
+	broadcast(s.txOps)
+	s.txOps = nil
+}
@@ -0,0 +1,60 @@
+package pg
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+
+	"github.com/IceFireDB/IceFireDB/IceFireDB-PGProxy/pkg/config"
+	"github.com/IceFireDB/IceFireDB/IceFireDB-PGProxy/utils"
+	"github.com/sirupsen/logrus"
+)
+
+type pgProxy struct {
+	ctx context.Context
+	// The TLS configuration of the clients, nil refuses the SSLRequest
+	tls *tls.Config
+}
+
+func Run(ctx context.Context) (err error) {
+	p := &pgProxy{ctx: ctx}
+	if c := config.Get().Server; c.TLSCert != "" || c.TLSKey != "" {
+		cert, err := tls.LoadX509KeyPair(c.TLSCert, c.TLSKey)
+		if err != nil {
+			logrus.Errorf("postgres tls %v", err)
+			return err
+		}
+		p.tls = &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+	}
+
+	ln, err := net.Listen("tcp4", config.Get().Server.Addr)
+	if err != nil {
+		logrus.Errorf("postgres %v", err)
+		return
+	}
+	utils.GoWithRecover(func() {
+		<-ctx.Done()
+		_ = ln.Close()
+	}, nil)
+
+	logrus.Infof("%s\n", config.Get().Server.Addr)
+	// p2p
+	if config.Get().P2P.Enable {
+		initP2P(p)
+	}
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed) {
+				if ctx.Err() != nil {
+					return nil
+				}
+				continue
+			}
+			return err
+		}
+		go p.onConn(conn)
+	}
+}
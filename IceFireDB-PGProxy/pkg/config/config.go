@@ -0,0 +1,99 @@
+package config
+
+import (
+	"net"
+
+	"github.com/go-viper/mapstructure/v2"
+	"github.com/spf13/viper"
+)
+
+type Config struct {
+	Server   ServerC   `json:"server"`
+	Postgres PostgresS `json:"postgres"`
+	P2P      P2PS      `json:"p2p"`
+}
+
+// ServerC is the listener of the clients. With a certificate and its key,
+// the clients asking for TLS are upgraded, the others stay in plain text.
+type ServerC struct {
+	Addr    string `json:"addr"`
+	TLSCert string `json:"tls_cert"`
+	TLSKey  string `json:"tls_key"`
+}
+
+// PostgresS configures the backend. The clients authenticate against the
+// backend with their own users, the user below applies the writes of the peers.
+type PostgresS struct {
+	Addr     string `json:"addr"`
+	User     string `json:"user"`
+	Password string `json:"password"`
+	DBName   string `json:"dbname"`
+}
+
+type P2PS struct {
+	Enable              bool   `json:"enable"`
+	ServiceDiscoveryID  string `json:"service_discovery_id"`
+	ServiceCommandTopic string `json:"service_command_topic"`
+	ServiceDiscoverMode string `json:"service_discover_mode"`
+	NodeHostIP          string `json:"node_host_ip"`
+	NodeHostPort        int    `json:"node_host_port"`
+	// Number of recent transactions kept to answer the resend requests of the peers
+	JournalSize int `json:"journal_size"`
+	// Number of transactions of a writer held behind a gap before the gap is skipped
+	MaxPending int `json:"max_pending"`
+	// Security transports and peer key types of the p2p hosts
+	Security SecurityC `json:"security"`
+}
+
+type SecurityC struct {
+	// Security transports in order of preference: tls, noise
+	Transports []string `json:"transports"`
+	// Identity key types of the peers, empty accepts every type
+	KeyTypes []string `json:"key_types"`
+	// Only use FIPS approved primitives, requires GODEBUG=fips140=on
+	FIPS bool `json:"fips"`
+}
+
+func init() {
+	defaultConfig = &Config{}
+}
+
+var defaultConfig *Config
+
+func InitConfig(path string) {
+	viper.SetConfigFile(path)
+	if err := viper.ReadInConfig(); err != nil {
+		panic(err)
+	}
+
+	err := viper.Unmarshal(defaultConfig, func(c *mapstructure.DecoderConfig) {
+		c.TagName = "json"
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	if net.ParseIP(defaultConfig.P2P.NodeHostIP) == nil {
+		defaultConfig.P2P.NodeHostIP = "0.0.0.0"
+	}
+
+	if defaultConfig.P2P.JournalSize <= 0 {
+		defaultConfig.P2P.JournalSize = 4096
+	}
+
+	if defaultConfig.P2P.MaxPending <= 0 {
+		defaultConfig.P2P.MaxPending = 1024
+	}
+
+	if len(defaultConfig.P2P.Security.Transports) == 0 {
+		defaultConfig.P2P.Security.Transports = []string{"tls", "noise"}
+	}
+
+	if defaultConfig.P2P.NodeHostPort < 0 || defaultConfig.P2P.NodeHostPort > 65535 {
+		defaultConfig.P2P.NodeHostPort = 0
+	}
+}
+
+func Get() *Config {
+	return defaultConfig
+}
@@ -0,0 +1,220 @@
+package pgproto
+
+import (
+	"bufio"
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"time"
+)
+
+// The codes of the Authentication messages
+const (
+	authOK                = 0
+	authCleartextPassword = 3
+	authMD5Password       = 5
+	authSASL              = 10
+	authSASLContinue      = 11
+	authSASLFinal         = 12
+)
+
+// Result represents the result of a statement
+type Result struct {
+	// Tag is the tag of the CommandComplete, like INSERT 0 1
+	Tag string
+	// Rows are the values of the rows returned in text format, nil for a NULL
+	Rows [][][]byte
+}
+
+// Conn is a connection to a backend, used to apply the replicated statements.
+// The errors of the statements are returned as *Error, the other errors break
+// the connection.
+type Conn struct {
+	conn     net.Conn
+	r        *bufio.Reader
+	txStatus byte
+}
+
+// Connect opens a connection authenticated with a cleartext, MD5 or
+// SCRAM-SHA-256 password
+func Connect(addr string, user string, password string, database string) (*Conn, error) {
+	nc, err := net.DialTimeout("tcp", addr, 10*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	c := &Conn{conn: nc, r: bufio.NewReader(nc)}
+	params := map[string]string{"user": user, "client_encoding": "UTF8"}
+	if database != "" {
+		params["database"] = database
+	}
+	if err = c.startup(params, user, password); err != nil {
+		nc.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *Conn) startup(params map[string]string, user string, password string) error {
+	if _, err := c.conn.Write(EncodeStartup(params)); err != nil {
+		return err
+	}
+	var sasl *scram
+	for {
+		msg, err := ReadMessage(c.r)
+		if err != nil {
+			return err
+		}
+		switch msg.Type {
+		case MsgErrorResponse:
+			return DecodeError(msg.Body)
+		case MsgReadyForQuery:
+			c.txStatus, err = DecodeReadyForQuery(msg.Body)
+			return err
+		case MsgAuthentication:
+		default:
+			// ParameterStatus, BackendKeyData, NoticeResponse
+			continue
+		}
+
+		r := &reader{data: msg.Body}
+		code := r.int32()
+		if r.err != nil {
+			return r.err
+		}
+		switch code {
+		case authOK:
+		case authCleartextPassword:
+			err = c.writePassword([]byte(password))
+		case authMD5Password:
+			salt := r.next(4)
+			if r.err != nil {
+				return r.err
+			}
+			inner := md5.Sum([]byte(password + user))
+			outer := md5.Sum(append([]byte(hex.EncodeToString(inner[:])), salt...))
+			err = c.writePassword([]byte("md5" + hex.EncodeToString(outer[:])))
+		case authSASL:
+			found := false
+			for m := r.string(); r.err == nil && m != ""; m = r.string() {
+				found = found || m == scramMechanism
+			}
+			if !found {
+				return fmt.Errorf("no supported SASL mechanism in %q", msg.Body)
+			}
+			nonce := make([]byte, 18)
+			if _, err = rand.Read(nonce); err != nil {
+				return err
+			}
+			// the backend takes the user of the startup message
+			sasl = newSCRAM("", password, base64.StdEncoding.EncodeToString(nonce))
+			first := sasl.first()
+			w := &writer{}
+			w.string(scramMechanism)
+			w.int32(int32(len(first)))
+			w.buf = append(w.buf, first...)
+			_, err = c.conn.Write(w.message(MsgPassword))
+		case authSASLContinue:
+			if sasl == nil {
+				return fmt.Errorf("unexpected SASL continue")
+			}
+			var final string
+			if final, err = sasl.final(string(r.data)); err != nil {
+				return err
+			}
+			_, err = c.conn.Write(Message{Type: MsgPassword, Body: []byte(final)}.Bytes())
+		case authSASLFinal:
+			if sasl == nil {
+				return fmt.Errorf("unexpected SASL final")
+			}
+			err = sasl.verify(string(r.data))
+		default:
+			return fmt.Errorf("unsupported authentication method %d", code)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (c *Conn) writePassword(password []byte) error {
+	_, err := c.conn.Write(Message{Type: MsgPassword, Body: append(password, 0)}.Bytes())
+	return err
+}
+
+// TxStatus returns the transaction status of the last ReadyForQuery
+func (c *Conn) TxStatus() byte {
+	return c.txStatus
+}
+
+// IsInTransaction tells whether a transaction is open, including a failed one
+func (c *Conn) IsInTransaction() bool {
+	return c.txStatus != TxIdle
+}
+
+// Exec runs a simple query, which may hold several statements. The result is
+// the result of the last statement.
+func (c *Conn) Exec(sql string) (*Result, error) {
+	if _, err := c.conn.Write(EncodeQuery(sql)); err != nil {
+		return nil, err
+	}
+	return c.readResult()
+}
+
+// ExecParams runs a statement with the extended query protocol, the params
+// are bound with the formats and the rows are returned in text format
+func (c *Conn) ExecParams(sql string, types []uint32, formats []int16, params [][]byte) (*Result, error) {
+	var buf []byte
+	buf = append(buf, EncodeParse("", sql, types)...)
+	buf = append(buf, EncodeBind(Bind{Formats: formats, Params: params})...)
+	buf = append(buf, EncodeExecute("", 0)...)
+	buf = append(buf, EncodeSync()...)
+	if _, err := c.conn.Write(buf); err != nil {
+		return nil, err
+	}
+	return c.readResult()
+}
+
+// readResult reads the messages of the backend until the ReadyForQuery
+func (c *Conn) readResult() (*Result, error) {
+	res := &Result{}
+	var queryErr *Error
+	for {
+		msg, err := ReadMessage(c.r)
+		if err != nil {
+			return nil, err
+		}
+		switch msg.Type {
+		case MsgRowDescription:
+			res.Rows = nil
+		case MsgDataRow:
+			row, err := DecodeDataRow(msg.Body)
+			if err != nil {
+				return nil, err
+			}
+			res.Rows = append(res.Rows, row)
+		case MsgCommandComplete:
+			if res.Tag, err = DecodeCommandComplete(msg.Body); err != nil {
+				return nil, err
+			}
+		case MsgErrorResponse:
+			queryErr = DecodeError(msg.Body)
+		case MsgReadyForQuery:
+			if c.txStatus, err = DecodeReadyForQuery(msg.Body); err != nil {
+				return nil, err
+			}
+			if queryErr != nil {
+				return nil, queryErr
+			}
+			return res, nil
+		}
+	}
+}
+
+// Close terminates the connection
+func (c *Conn) Close() error {
+	_, _ = c.conn.Write(EncodeTerminate())
+	return c.conn.Close()
+}
@@ -0,0 +1,225 @@
+// Package pgproto implements the messages of the PostgreSQL frontend/backend
+// protocol version 3.0 used by the proxy
+package pgproto
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// The codes of the startup packets
+const (
+	ProtocolVersion   uint32 = 196608
+	CancelRequestCode uint32 = 80877102
+	SSLRequestCode    uint32 = 80877103
+	GSSENCRequestCode uint32 = 80877104
+)
+
+// The types of the frontend messages
+const (
+	MsgQuery     byte = 'Q'
+	MsgParse     byte = 'P'
+	MsgBind      byte = 'B'
+	MsgExecute   byte = 'E'
+	MsgDescribe  byte = 'D'
+	MsgClose     byte = 'C'
+	MsgSync      byte = 'S'
+	MsgFlush     byte = 'H'
+	MsgTerminate byte = 'X'
+	MsgPassword  byte = 'p'
+)
+
+// The types of the backend messages
+const (
+	MsgAuthentication       byte = 'R'
+	MsgParameterStatus      byte = 'S'
+	MsgBackendKeyData       byte = 'K'
+	MsgReadyForQuery        byte = 'Z'
+	MsgCommandComplete      byte = 'C'
+	MsgErrorResponse        byte = 'E'
+	MsgNoticeResponse       byte = 'N'
+	MsgRowDescription       byte = 'T'
+	MsgDataRow              byte = 'D'
+	MsgEmptyQueryResponse   byte = 'I'
+	MsgPortalSuspended      byte = 's'
+	MsgParseComplete        byte = '1'
+	MsgBindComplete         byte = '2'
+	MsgCloseComplete        byte = '3'
+	MsgNoData               byte = 'n'
+	MsgParameterDescription byte = 't'
+)
+
+// The transaction status of a ReadyForQuery message
+const (
+	TxIdle   byte = 'I'
+	TxActive byte = 'T'
+	TxFailed byte = 'E'
+)
+
+// maxMessageLen bounds the length of a message read, like the backend does
+const maxMessageLen = 1 << 30
+
+// ErrMalformed is returned when a message is shorter than its fields
+var ErrMalformed = errors.New("malformed message")
+
+// Message represents a typed message
+type Message struct {
+	Type byte
+	Body []byte
+}
+
+// Bytes returns the message as sent on the wire
+func (m Message) Bytes() []byte {
+	buf := make([]byte, 5, 5+len(m.Body))
+	buf[0] = m.Type
+	binary.BigEndian.PutUint32(buf[1:], uint32(4+len(m.Body)))
+	return append(buf, m.Body...)
+}
+
+// ReadMessage reads a typed message
+func ReadMessage(r io.Reader) (Message, error) {
+	var header [5]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return Message{}, err
+	}
+	n := binary.BigEndian.Uint32(header[1:])
+	if n < 4 || n > maxMessageLen {
+		return Message{}, fmt.Errorf("invalid length %d of message %q", n, header[0])
+	}
+	body := make([]byte, n-4)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return Message{}, err
+	}
+	return Message{Type: header[0], Body: body}, nil
+}
+
+// ReadStartup reads an untyped startup packet, returned with its length
+func ReadStartup(r io.Reader) ([]byte, error) {
+	var header [4]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, err
+	}
+	n := binary.BigEndian.Uint32(header[:])
+	if n < 8 || n > 10000 {
+		return nil, fmt.Errorf("invalid length %d of startup packet", n)
+	}
+	packet := make([]byte, n)
+	copy(packet, header[:])
+	if _, err := io.ReadFull(r, packet[4:]); err != nil {
+		return nil, err
+	}
+	return packet, nil
+}
+
+// StartupCode returns the protocol version or the request code of a startup
+// packet
+func StartupCode(packet []byte) uint32 {
+	return binary.BigEndian.Uint32(packet[4:])
+}
+
+// StartupParams returns the parameters of a startup message
+func StartupParams(packet []byte) map[string]string {
+	params := make(map[string]string)
+	r := &reader{data: packet[8:]}
+	for {
+		name := r.string()
+		if r.err != nil || name == "" {
+			return params
+		}
+		params[name] = r.string()
+	}
+}
+
+// EncodeStartup encodes a startup message of the protocol version 3.0
+func EncodeStartup(params map[string]string) []byte {
+	w := &writer{buf: make([]byte, 4)}
+	w.int32(int32(ProtocolVersion))
+	for name, value := range params {
+		w.string(name)
+		w.string(value)
+	}
+	w.byte(0)
+	binary.BigEndian.PutUint32(w.buf, uint32(len(w.buf)))
+	return w.buf
+}
+
+// reader decodes the fields of a message body, err is set by the first field
+// past the end of the body
+type reader struct {
+	data []byte
+	err  error
+}
+
+func (r *reader) next(n int) []byte {
+	if r.err != nil || n < 0 || len(r.data) < n {
+		r.err = ErrMalformed
+		return nil
+	}
+	b := r.data[:n]
+	r.data = r.data[n:]
+	return b
+}
+
+func (r *reader) byte() byte {
+	if b := r.next(1); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+func (r *reader) int16() int16 {
+	if b := r.next(2); b != nil {
+		return int16(binary.BigEndian.Uint16(b))
+	}
+	return 0
+}
+
+func (r *reader) int32() int32 {
+	if b := r.next(4); b != nil {
+		return int32(binary.BigEndian.Uint32(b))
+	}
+	return 0
+}
+
+func (r *reader) string() string {
+	if r.err != nil {
+		return ""
+	}
+	for i, c := range r.data {
+		if c == 0 {
+			s := string(r.data[:i])
+			r.data = r.data[i+1:]
+			return s
+		}
+	}
+	r.err = ErrMalformed
+	return ""
+}
+
+// writer encodes the fields of a message body
+type writer struct {
+	buf []byte
+}
+
+func (w *writer) byte(b byte) {
+	w.buf = append(w.buf, b)
+}
+
+func (w *writer) int16(n int16) {
+	w.buf = binary.BigEndian.AppendUint16(w.buf, uint16(n))
+}
+
+func (w *writer) int32(n int32) {
+	w.buf = binary.BigEndian.AppendUint32(w.buf, uint32(n))
+}
+
+func (w *writer) string(s string) {
+	w.buf = append(w.buf, s...)
+	w.buf = append(w.buf, 0)
+}
+
+func (w *writer) message(t byte) []byte {
+	return Message{Type: t, Body: w.buf}.Bytes()
+}
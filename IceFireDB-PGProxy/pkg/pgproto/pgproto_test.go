@@ -0,0 +1,110 @@
+package pgproto
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"testing"
+)
+
+func TestSCRAM(t *testing.T) {
+	// RFC 7677 section 3
+	s := newSCRAM("user", "pencil", "rOprNGfwEbeRWgbNEkqO")
+	if first := s.first(); first != "n,,n=user,r=rOprNGfwEbeRWgbNEkqO" {
+		t.Fatalf("first %s", first)
+	}
+	final, err := s.final("r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0,s=W22ZaJ0SNY7soEsUEjb6gQ==,i=4096")
+	if err != nil || final != "c=biws,r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0,p=dHzbZapWIk4jUhN+Ute9ytag9zjfMHgsqmmiz7AndVQ=" {
+		t.Fatalf("final %s %v", final, err)
+	}
+	if err = s.verify("v=6rriTRBi23WpRR/wtup+mMhUZUn/dB5nLTJRsjl95G4="); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.verify("v=AAAA"); err == nil {
+		t.Fatal("invalid signature verified")
+	}
+}
+
+func TestBind(t *testing.T) {
+	b := Bind{
+		Portal:        "p",
+		Statement:     "s",
+		Formats:       []int16{1},
+		Params:        [][]byte{{0, 0, 0, 1}, nil, {}},
+		ResultFormats: []int16{0},
+	}
+	msg, err := ReadMessage(bytes.NewReader(EncodeBind(b)))
+	if err != nil || msg.Type != MsgBind {
+		t.Fatalf("read %v %v", msg, err)
+	}
+	got, err := DecodeBind(msg.Body)
+	if err != nil || got.Portal != "p" || got.Statement != "s" || got.Formats[0] != 1 ||
+		len(got.Params) != 3 || !bytes.Equal(got.Params[0], b.Params[0]) || got.Params[1] != nil || got.Params[2] == nil {
+		t.Fatalf("bind %+v %v", got, err)
+	}
+	if _, err = DecodeBind(msg.Body[:len(msg.Body)-3]); err != ErrMalformed {
+		t.Fatalf("truncated bind err %v", err)
+	}
+}
+
+func TestStartup(t *testing.T) {
+	packet, err := ReadStartup(bytes.NewReader(EncodeStartup(map[string]string{"user": "u", "database": "d"})))
+	if err != nil || StartupCode(packet) != ProtocolVersion {
+		t.Fatalf("startup %v %v", packet, err)
+	}
+	if params := StartupParams(packet); params["user"] != "u" || params["database"] != "d" {
+		t.Fatalf("params %v", params)
+	}
+}
+
+func TestTagCommand(t *testing.T) {
+	for tag, command := range map[string]string{
+		"INSERT 0 1":   "INSERT",
+		"UPDATE 3":     "UPDATE",
+		"CREATE TABLE": "CREATE TABLE",
+		"BEGIN":        "BEGIN",
+	} {
+		if got := TagCommand(tag); got != command {
+			t.Errorf("%s: %s", tag, got)
+		}
+	}
+}
+
+func TestConnExec(t *testing.T) {
+	client, server := net.Pipe()
+	defer server.Close()
+	go func() {
+		if _, err := ReadStartup(server); err != nil {
+			return
+		}
+		server.Write(Message{Type: MsgAuthentication, Body: []byte{0, 0, 0, 3}}.Bytes())
+		if msg, err := ReadMessage(server); err != nil || string(msg.Body) != "secret\x00" {
+			server.Write(EncodeError(&Error{Severity: "FATAL", Code: "28P01", Message: "password authentication failed"}))
+			return
+		}
+		server.Write(Message{Type: MsgAuthentication, Body: []byte{0, 0, 0, 0}}.Bytes())
+		server.Write(Message{Type: MsgReadyForQuery, Body: []byte{TxIdle}}.Bytes())
+
+		ReadMessage(server)
+		server.Write(EncodeError(&Error{Severity: "ERROR", Code: "42P01", Message: "relation does not exist"}))
+		server.Write(Message{Type: MsgReadyForQuery, Body: []byte{TxIdle}}.Bytes())
+
+		ReadMessage(server)
+		server.Write(Message{Type: MsgDataRow, Body: []byte{0, 2, 0, 0, 0, 1, '7', 0xff, 0xff, 0xff, 0xff}}.Bytes())
+		server.Write(Message{Type: MsgCommandComplete, Body: []byte("SELECT 1\x00")}.Bytes())
+		server.Write(Message{Type: MsgReadyForQuery, Body: []byte{TxActive}}.Bytes())
+	}()
+
+	c := &Conn{conn: client, r: bufio.NewReader(client)}
+	if err := c.startup(map[string]string{"user": "u"}, "u", "secret"); err != nil {
+		t.Fatal(err)
+	}
+	_, err := c.Exec("SELECT * FROM missing")
+	if e, ok := err.(*Error); !ok || e.Code != "42P01" {
+		t.Fatalf("err %v", err)
+	}
+	res, err := c.Exec("SELECT 7, NULL")
+	if err != nil || res.Tag != "SELECT 1" || string(res.Rows[0][0]) != "7" || res.Rows[0][1] != nil || !c.IsInTransaction() {
+		t.Fatalf("res %+v %v", res, err)
+	}
+}
@@ -0,0 +1,243 @@
+package pgproto
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Bind represents a Bind message, which binds the parameters of a prepared
+// statement to a portal
+type Bind struct {
+	Portal    string
+	Statement string
+	// Formats are the parameter format codes: none for all text, one for all
+	// the parameters, or one per parameter
+	Formats []int16
+	// Params are the parameter values, nil for a NULL
+	Params        [][]byte
+	ResultFormats []int16
+}
+
+// Error represents an ErrorResponse of the backend
+type Error struct {
+	Severity string
+	Code     string
+	Message  string
+	Detail   string
+}
+
+func (e *Error) Error() string {
+	if e.Detail != "" {
+		return fmt.Sprintf("%s: %s (SQLSTATE %s): %s", e.Severity, e.Message, e.Code, e.Detail)
+	}
+	return fmt.Sprintf("%s: %s (SQLSTATE %s)", e.Severity, e.Message, e.Code)
+}
+
+// DecodeQuery decodes the SQL of a Query message
+func DecodeQuery(body []byte) (string, error) {
+	r := &reader{data: body}
+	sql := r.string()
+	return sql, r.err
+}
+
+// EncodeQuery encodes a Query message
+func EncodeQuery(sql string) []byte {
+	w := &writer{}
+	w.string(sql)
+	return w.message(MsgQuery)
+}
+
+// DecodeParse decodes a Parse message
+func DecodeParse(body []byte) (name string, sql string, types []uint32, err error) {
+	r := &reader{data: body}
+	name = r.string()
+	sql = r.string()
+	n := r.int16()
+	for i := 0; i < int(n) && r.err == nil; i++ {
+		types = append(types, uint32(r.int32()))
+	}
+	return name, sql, types, r.err
+}
+
+// EncodeParse encodes a Parse message
+func EncodeParse(name string, sql string, types []uint32) []byte {
+	w := &writer{}
+	w.string(name)
+	w.string(sql)
+	w.int16(int16(len(types)))
+	for _, t := range types {
+		w.int32(int32(t))
+	}
+	return w.message(MsgParse)
+}
+
+// DecodeBind decodes a Bind message
+func DecodeBind(body []byte) (Bind, error) {
+	var b Bind
+	r := &reader{data: body}
+	b.Portal = r.string()
+	b.Statement = r.string()
+	n := r.int16()
+	for i := 0; i < int(n) && r.err == nil; i++ {
+		b.Formats = append(b.Formats, r.int16())
+	}
+	n = r.int16()
+	for i := 0; i < int(n) && r.err == nil; i++ {
+		size := r.int32()
+		if size < 0 {
+			b.Params = append(b.Params, nil)
+			continue
+		}
+		b.Params = append(b.Params, append([]byte{}, r.next(int(size))...))
+	}
+	n = r.int16()
+	for i := 0; i < int(n) && r.err == nil; i++ {
+		b.ResultFormats = append(b.ResultFormats, r.int16())
+	}
+	return b, r.err
+}
+
+// EncodeBind encodes a Bind message
+func EncodeBind(b Bind) []byte {
+	w := &writer{}
+	w.string(b.Portal)
+	w.string(b.Statement)
+	w.int16(int16(len(b.Formats)))
+	for _, f := range b.Formats {
+		w.int16(f)
+	}
+	w.int16(int16(len(b.Params)))
+	for _, p := range b.Params {
+		if p == nil {
+			w.int32(-1)
+			continue
+		}
+		w.int32(int32(len(p)))
+		w.buf = append(w.buf, p...)
+	}
+	w.int16(int16(len(b.ResultFormats)))
+	for _, f := range b.ResultFormats {
+		w.int16(f)
+	}
+	return w.message(MsgBind)
+}
+
+// DecodeExecute decodes an Execute message
+func DecodeExecute(body []byte) (portal string, maxRows int32, err error) {
+	r := &reader{data: body}
+	portal = r.string()
+	maxRows = r.int32()
+	return portal, maxRows, r.err
+}
+
+// EncodeExecute encodes an Execute message
+func EncodeExecute(portal string, maxRows int32) []byte {
+	w := &writer{}
+	w.string(portal)
+	w.int32(maxRows)
+	return w.message(MsgExecute)
+}
+
+// DecodeClose decodes a Close message, kind is 'S' for a prepared statement
+// and 'P' for a portal
+func DecodeClose(body []byte) (kind byte, name string, err error) {
+	r := &reader{data: body}
+	kind = r.byte()
+	name = r.string()
+	return kind, name, r.err
+}
+
+// EncodeSync encodes a Sync message
+func EncodeSync() []byte {
+	return (&writer{}).message(MsgSync)
+}
+
+// EncodeTerminate encodes a Terminate message
+func EncodeTerminate() []byte {
+	return (&writer{}).message(MsgTerminate)
+}
+
+// DecodeCommandComplete decodes the tag of a CommandComplete message, like
+// INSERT 0 1
+func DecodeCommandComplete(body []byte) (string, error) {
+	r := &reader{data: body}
+	tag := r.string()
+	return tag, r.err
+}
+
+// DecodeReadyForQuery decodes the transaction status of a ReadyForQuery
+// message
+func DecodeReadyForQuery(body []byte) (byte, error) {
+	r := &reader{data: body}
+	status := r.byte()
+	return status, r.err
+}
+
+// DecodeError decodes an ErrorResponse or a NoticeResponse
+func DecodeError(body []byte) *Error {
+	e := &Error{}
+	r := &reader{data: body}
+	for {
+		field := r.byte()
+		if r.err != nil || field == 0 {
+			return e
+		}
+		value := r.string()
+		switch field {
+		case 'S':
+			e.Severity = value
+		case 'C':
+			e.Code = value
+		case 'M':
+			e.Message = value
+		case 'D':
+			e.Detail = value
+		}
+	}
+}
+
+// EncodeError encodes an ErrorResponse
+func EncodeError(e *Error) []byte {
+	w := &writer{}
+	for _, f := range []struct {
+		field byte
+		value string
+	}{{'S', e.Severity}, {'V', e.Severity}, {'C', e.Code}, {'M', e.Message}, {'D', e.Detail}} {
+		if f.value != "" {
+			w.byte(f.field)
+			w.string(f.value)
+		}
+	}
+	w.byte(0)
+	return w.message(MsgErrorResponse)
+}
+
+// DecodeDataRow decodes the column values of a DataRow, nil for a NULL
+func DecodeDataRow(body []byte) ([][]byte, error) {
+	r := &reader{data: body}
+	n := r.int16()
+	var row [][]byte
+	for i := 0; i < int(n) && r.err == nil; i++ {
+		size := r.int32()
+		if size < 0 {
+			row = append(row, nil)
+			continue
+		}
+		row = append(row, r.next(int(size)))
+	}
+	return row, r.err
+}
+
+// TagCommand returns the command of a CommandComplete tag without its row
+// counts, like INSERT for INSERT 0 1 or CREATE TABLE
+func TagCommand(tag string) string {
+	fields := strings.Fields(tag)
+	for len(fields) > 0 {
+		if _, err := strconv.ParseUint(fields[len(fields)-1], 10, 64); err != nil {
+			break
+		}
+		fields = fields[:len(fields)-1]
+	}
+	return strings.Join(fields, " ")
+}
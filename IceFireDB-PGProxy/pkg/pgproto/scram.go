@@ -0,0 +1,99 @@
+package pgproto
+
+import (
+	"crypto/hmac"
+	"crypto/pbkdf2"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// scramMechanism is the only SASL mechanism of the backend without channel
+// binding
+const scramMechanism = "SCRAM-SHA-256"
+
+// scram is the client side of a SCRAM-SHA-256 exchange (RFC 7677). The
+// password is used as is, without the SASLprep normalization.
+type scram struct {
+	user            string
+	password        string
+	nonce           string
+	clientFirstBare string
+	serverSignature []byte
+}
+
+func newSCRAM(user string, password string, nonce string) *scram {
+	return &scram{user: user, password: password, nonce: nonce}
+}
+
+// first returns the client-first-message
+func (s *scram) first() string {
+	s.clientFirstBare = "n=" + strings.NewReplacer("=", "=3D", ",", "=2C").Replace(s.user) + ",r=" + s.nonce
+	return "n,," + s.clientFirstBare
+}
+
+// final returns the client-final-message answering the server-first-message
+func (s *scram) final(serverFirst string) (string, error) {
+	var nonce, salt string
+	iterations := 0
+	for _, attr := range strings.Split(serverFirst, ",") {
+		if len(attr) < 2 || attr[1] != '=' {
+			continue
+		}
+		switch attr[0] {
+		case 'r':
+			nonce = attr[2:]
+		case 's':
+			salt = attr[2:]
+		case 'i':
+			iterations, _ = strconv.Atoi(attr[2:])
+		}
+	}
+	if !strings.HasPrefix(nonce, s.nonce) || len(nonce) == len(s.nonce) {
+		return "", errors.New("SCRAM: invalid server nonce")
+	}
+	rawSalt, err := base64.StdEncoding.DecodeString(salt)
+	if err != nil || iterations <= 0 {
+		return "", fmt.Errorf("SCRAM: invalid server-first-message %q", serverFirst)
+	}
+
+	salted, err := pbkdf2.Key(sha256.New, s.password, rawSalt, iterations, sha256.Size)
+	if err != nil {
+		return "", err
+	}
+	clientKey := hmacSHA256(salted, "Client Key")
+	storedKey := sha256.Sum256(clientKey)
+	withoutProof := "c=biws,r=" + nonce
+	authMessage := s.clientFirstBare + "," + serverFirst + "," + withoutProof
+	proof := hmacSHA256(storedKey[:], authMessage)
+	for i := range proof {
+		proof[i] ^= clientKey[i]
+	}
+	s.serverSignature = hmacSHA256(hmacSHA256(salted, "Server Key"), authMessage)
+	return withoutProof + ",p=" + base64.StdEncoding.EncodeToString(proof), nil
+}
+
+// verify checks the server signature of the server-final-message
+func (s *scram) verify(serverFinal string) error {
+	if e, ok := strings.CutPrefix(serverFinal, "e="); ok {
+		return fmt.Errorf("SCRAM: %s", e)
+	}
+	v, ok := strings.CutPrefix(serverFinal, "v=")
+	if !ok {
+		return fmt.Errorf("SCRAM: invalid server-final-message %q", serverFinal)
+	}
+	signature, err := base64.StdEncoding.DecodeString(strings.SplitN(v, ",", 2)[0])
+	if err != nil || !hmac.Equal(signature, s.serverSignature) {
+		return errors.New("SCRAM: invalid server signature")
+	}
+	return nil
+}
+
+func hmacSHA256(key []byte, msg string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(msg))
+	return h.Sum(nil)
+}
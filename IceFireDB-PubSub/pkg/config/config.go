@@ -21,6 +21,7 @@ package config
 
 import (
 	"errors"
+	"fmt"
 	"net"
 	"strings"
 
@@ -62,6 +63,67 @@ func InitConfig() error {
 		_config.P2P.NodeHostIP = "0.0.0.0"
 	}
 
+	if _config.Persist.Enable && _config.Persist.Path == "" {
+		_config.Persist.Path = "data/pubsub"
+	}
+	if _config.Persist.AckTimeout <= 0 {
+		_config.Persist.AckTimeout = 30000
+	}
+	if _config.Persist.DeadLetterSuffix == "" {
+		_config.Persist.DeadLetterSuffix = ".dead"
+	}
+
+	if _config.Group.Partitions <= 0 {
+		_config.Group.Partitions = 16
+	}
+	if _config.Group.Heartbeat <= 0 {
+		_config.Group.Heartbeat = 2000
+	}
+
+	if _config.MQTT.Port <= 0 {
+		_config.MQTT.Port = 1883
+	}
+
+	for i := range _config.Bridges {
+		b := &_config.Bridges[i]
+		b.Type = strings.ToLower(b.Type)
+		if b.Type != "nats" && b.Type != "kafka" {
+			return fmt.Errorf("bridge %d: unknown type %q", i, b.Type)
+		}
+		if b.Name == "" {
+			b.Name = fmt.Sprintf("%s-%d", b.Type, i)
+		}
+		b.Direction = strings.ToLower(b.Direction)
+		switch b.Direction {
+		case "":
+			b.Direction = "both"
+		case "in", "out", "both":
+		default:
+			return fmt.Errorf("bridge %s: unknown direction %q", b.Name, b.Direction)
+		}
+		if b.Durable == "" {
+			b.Durable = "icefiredb"
+		}
+		b.SASL = strings.ToUpper(b.SASL)
+		switch b.SASL {
+		case "":
+		case "PLAIN", "SCRAM-SHA-256", "SCRAM-SHA-512":
+			if b.Type != "kafka" {
+				return fmt.Errorf("bridge %s: sasl is only supported by kafka", b.Name)
+			}
+		default:
+			return fmt.Errorf("bridge %s: unknown sasl mechanism %q", b.Name, b.SASL)
+		}
+		if (b.TLSCert == "") != (b.TLSKey == "") {
+			return fmt.Errorf("bridge %s: tls_cert and tls_key go together", b.Name)
+		}
+		for j := range b.Topics {
+			if b.Topics[j].Remote == "" {
+				b.Topics[j].Remote = b.Topics[j].Topic
+			}
+		}
+	}
+
 	if _config.P2P.NodeHostPort < 0 || _config.P2P.NodeHostPort > 65535 {
 		_config.P2P.NodeHostPort = 0
 	}
@@ -27,6 +27,10 @@ type Config struct {
 	IPWhiteList IPWhiteListS `mapstructure:"ip_white_list"`
 	Cache       CacheS       `mapstructure:"cache"`
 	IgnoreCMD   IgnoreCMDS   `mapstructure:"ignore_cmd"`
+	Persist     PersistS     `mapstructure:"persist"`
+	Group       GroupS       `mapstructure:"consumer_group"`
+	Bridges     []BridgeS    `mapstructure:"bridges"`
+	MQTT        MQTTS        `mapstructure:"mqtt"`
 
 	P2P P2PS `mapstructure:"p2p"`
 }
@@ -40,6 +44,77 @@ type P2PS struct {
 	NodeHostPort        int    `mapstructure:"node_host_port" json:"node_host_port"`
 }
 
+type PersistS struct {
+	Enable bool `mapstructure:"enable" json:"enable"`
+	// leveldb directory of the message logs
+	Path string `mapstructure:"path" json:"path"`
+	// Age after which the messages are deleted (unit: second), 0 keeps them
+	Retention int `mapstructure:"retention" json:"retention"`
+	// Topics joined at startup, so their messages are stored without a local subscriber
+	Topics []string `mapstructure:"topics" json:"topics"`
+	// Delay after which a message not acknowledged is redelivered (unit: millisecond)
+	AckTimeout int `mapstructure:"ack_timeout" json:"ack_timeout"`
+	// Deliveries after which a message goes to the dead letter topic, 0 redelivers it forever
+	MaxDeliveries int `mapstructure:"max_deliveries" json:"max_deliveries"`
+	// Suffix of the dead letter topic of a topic
+	DeadLetterSuffix string `mapstructure:"dead_letter_suffix" json:"dead_letter_suffix"`
+}
+
+type GroupS struct {
+	// Number of partitions of a topic shared by the members of a group
+	Partitions int `mapstructure:"partitions" json:"partitions"`
+	// Interval of the membership announcements (unit: millisecond)
+	Heartbeat int `mapstructure:"heartbeat" json:"heartbeat"`
+}
+
+type BridgeS struct {
+	Name string `mapstructure:"name" json:"name"`
+	// nats or kafka
+	Type string `mapstructure:"type" json:"type"`
+	// NATS server address, or Kafka brokers, multiple, split
+	Addr string `mapstructure:"addr" json:"addr"`
+	// out mirrors the p2p topics to the remote ones, in the remote topics to the p2p ones, both does both
+	Direction string `mapstructure:"direction" json:"direction"`
+	User      string `mapstructure:"user" json:"user"`
+	Password  string `mapstructure:"password" json:"password"`
+	Token     string `mapstructure:"token" json:"token"`
+	// Kafka SASL mechanism of the user and password: plain, scram-sha-256 or
+	// scram-sha-512, none when empty
+	SASL string `mapstructure:"sasl" json:"sasl"`
+	// TLS of the connections, the CA verifying the server, the system roots
+	// when empty, and the client certificate and key, none when empty
+	TLS     bool   `mapstructure:"tls" json:"tls"`
+	TLSCA   string `mapstructure:"tls_ca" json:"tls_ca"`
+	TLSCert string `mapstructure:"tls_cert" json:"tls_cert"`
+	TLSKey  string `mapstructure:"tls_key" json:"tls_key"`
+	// NATS JetStream stream of the subjects, the publishes wait for the stream
+	// and the inbound messages come from a durable consumer
+	Stream string `mapstructure:"stream" json:"stream"`
+	// Durable consumer name, also the NATS queue group of the inbound subjects
+	Durable string `mapstructure:"durable" json:"durable"`
+	// Kafka offset of the inbound topics at startup: latest or earliest
+	Start  string         `mapstructure:"start" json:"start"`
+	Topics []BridgeTopicS `mapstructure:"topics" json:"topics"`
+}
+
+type BridgeTopicS struct {
+	Topic string `mapstructure:"topic" json:"topic"`
+	// NATS subject or Kafka topic, the p2p topic name when empty
+	Remote string `mapstructure:"remote" json:"remote"`
+}
+
+type MQTTS struct {
+	Enable bool `mapstructure:"enable" json:"enable"`
+	Port   int  `mapstructure:"port" json:"port"`
+	// Credentials of the clients, not checked when the user is empty
+	User     string `mapstructure:"user" json:"user"`
+	Password string `mapstructure:"password" json:"password"`
+	// Prefix of the p2p topics of the MQTT topics
+	TopicPrefix string `mapstructure:"topic_prefix" json:"topic_prefix"`
+	// Delay after which a QoS 1 message not acknowledged is sent again (unit: millisecond)
+	RetryInterval int `mapstructure:"retry_interval" json:"retry_interval"`
+}
+
 type ProxyS struct {
 	LocalPort  int  `mapstructure:"local_port" json:"local_port"`   // Port to listen on locally when proxying
 	EnableMTLS bool `mapstructure:"enable_mtls" json:"enable_mtls"` // Cluster nodes, multiple, split
@@ -0,0 +1,151 @@
+/*
+ *
+ *  * Licensed to the Apache Software Foundation (ASF) under one or more
+ *  * contributor license agreements.  See the NOTICE file distributed with
+ *  * this work for additional information regarding copyright ownership.
+ *  * The ASF licenses this file to You under the Apache License, Version 2.0
+ *  * (the "License"); you may not use this file except in compliance with
+ *  * the License.  You may obtain a copy of the License at
+ *  *
+ *  *     http://www.apache.org/licenses/LICENSE-2.0
+ *  *
+ *  * Unless required by applicable law or agreed to in writing, software
+ *  * distributed under the License is distributed on an "AS IS" BASIS,
+ *  * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  * See the License for the specific language governing permissions and
+ *  * limitations under the License.
+ *
+ */
+
+// Package group assigns the partitions of the topics to the members of the
+// consumer groups, from the memberships the nodes announce. Every node
+// computes the same assignment from the same announcements, so a message is
+// delivered by the one node of the member owning its partition.
+package group
+
+import (
+	"hash/fnv"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Announcement is the consumer group members of a node, which replaces the
+// previous announcement of the node
+type Announcement struct {
+	Node   string  `json:"node"`
+	Groups []Group `json:"groups"`
+}
+
+// Group is the members of a consumer group on a node
+type Group struct {
+	Topic   string   `json:"topic"`
+	Name    string   `json:"name"`
+	Members []string `json:"members"`
+}
+
+// Member is a member of a consumer group with its partitions
+type Member struct {
+	Node       string
+	Name       string
+	Partitions []int
+}
+
+// Membership is the view of the consumer groups built from the
+// announcements of the nodes. A node not announcing itself for ttl is
+// removed, and its partitions are assigned to the members left.
+type Membership struct {
+	mu         sync.Mutex
+	ttl        time.Duration
+	partitions int
+	nodes      map[string]*node
+}
+
+type node struct {
+	seen   time.Time
+	groups map[groupKey][]string
+}
+
+type groupKey struct {
+	topic string
+	name  string
+}
+
+// New creates a view of the groups whose topics have the number of
+// partitions
+func New(partitions int, ttl time.Duration) *Membership {
+	return &Membership{
+		ttl:        ttl,
+		partitions: partitions,
+		nodes:      make(map[string]*node),
+	}
+}
+
+// Partition returns the partition of a message id
+func (m *Membership) Partition(id string) int {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(id))
+	return int(h.Sum32() % uint32(m.partitions))
+}
+
+// Announce records the announcement of a node
+func (m *Membership) Announce(a Announcement, now time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	n := &node{seen: now, groups: make(map[groupKey][]string)}
+	for _, g := range a.Groups {
+		k := groupKey{g.Topic, g.Name}
+		n.groups[k] = append(n.groups[k], g.Members...)
+	}
+	m.nodes[a.Node] = n
+}
+
+// members returns the members of a group sorted by node and name, dropping
+// the nodes expired. The lock must be held.
+func (m *Membership) members(topic string, name string, now time.Time) []Member {
+	k := groupKey{topic, name}
+	var members []Member
+	for id, n := range m.nodes {
+		if now.Sub(n.seen) > m.ttl {
+			delete(m.nodes, id)
+			continue
+		}
+		for _, v := range n.groups[k] {
+			members = append(members, Member{Node: id, Name: v})
+		}
+	}
+	sort.Slice(members, func(i, j int) bool {
+		if members[i].Node != members[j].Node {
+			return members[i].Node < members[j].Node
+		}
+		return members[i].Name < members[j].Name
+	})
+	return members
+}
+
+// Owner returns the member of a group the partition is assigned to, false
+// when the group has no member
+func (m *Membership) Owner(topic string, name string, partition int, now time.Time) (Member, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	members := m.members(topic, name, now)
+	if len(members) == 0 {
+		return Member{}, false
+	}
+	return members[partition%len(members)], true
+}
+
+// Members returns the members of a group with their partitions
+func (m *Membership) Members(topic string, name string, now time.Time) []Member {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	members := m.members(topic, name, now)
+	if len(members) == 0 {
+		return nil
+	}
+	for p := 0; p < m.partitions; p++ {
+		i := p % len(members)
+		members[i].Partitions = append(members[i].Partitions, p)
+	}
+	return members
+}
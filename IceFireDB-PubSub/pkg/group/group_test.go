@@ -0,0 +1,71 @@
+package group
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMembership(t *testing.T) {
+	now := time.Unix(1000, 0)
+	m := New(4, 3*time.Second)
+	m.Announce(Announcement{Node: "b", Groups: []Group{{Topic: "orders", Name: "billing", Members: []string{"w1"}}}}, now)
+	m.Announce(Announcement{Node: "a", Groups: []Group{
+		{Topic: "orders", Name: "billing", Members: []string{"w2", "w1"}},
+		{Topic: "orders", Name: "audit", Members: []string{"w1"}},
+	}}, now)
+
+	members := m.Members("orders", "billing", now)
+	if len(members) != 3 {
+		t.Fatalf("members: %+v", members)
+	}
+	want := []Member{
+		{Node: "a", Name: "w1", Partitions: []int{0, 3}},
+		{Node: "a", Name: "w2", Partitions: []int{1}},
+		{Node: "b", Name: "w1", Partitions: []int{2}},
+	}
+	for i, w := range want {
+		got := members[i]
+		if got.Node != w.Node || got.Name != w.Name || len(got.Partitions) != len(w.Partitions) {
+			t.Fatalf("member %d: %+v, want %+v", i, got, w)
+		}
+		for j := range w.Partitions {
+			if got.Partitions[j] != w.Partitions[j] {
+				t.Fatalf("member %d: %+v, want %+v", i, got, w)
+			}
+		}
+	}
+	if owner, ok := m.Owner("orders", "audit", 2, now); !ok || owner.Node != "a" || owner.Name != "w1" {
+		t.Fatalf("owner of the other group: %+v", owner)
+	}
+	if _, ok := m.Owner("orders", "unknown", 0, now); ok {
+		t.Fatal("owner of a group without member")
+	}
+
+	// the partitions of an expired node are rebalanced
+	now = now.Add(2 * time.Second)
+	m.Announce(Announcement{Node: "b", Groups: []Group{{Topic: "orders", Name: "billing", Members: []string{"w1"}}}}, now)
+	now = now.Add(2 * time.Second)
+	if owner, _ := m.Owner("orders", "billing", 0, now); owner.Node != "b" {
+		t.Fatalf("owner after the expiry: %+v", owner)
+	}
+	// a node leaves a group with an announcement without it
+	m.Announce(Announcement{Node: "b"}, now)
+	if members := m.Members("orders", "billing", now); len(members) != 0 {
+		t.Fatalf("members after leaving: %+v", members)
+	}
+}
+
+func TestPartition(t *testing.T) {
+	m := New(8, time.Second)
+	counts := make(map[int]int)
+	for _, id := range []string{"a", "b", "c", "d", "e", "f", "g", "h", "i", "j"} {
+		p := m.Partition(id)
+		if p < 0 || p >= 8 || p != m.Partition(id) {
+			t.Fatalf("partition of %s: %d", id, p)
+		}
+		counts[p]++
+	}
+	if len(counts) < 2 {
+		t.Fatalf("partitions: %v", counts)
+	}
+}
@@ -0,0 +1,618 @@
+/*
+ *
+ *  * Licensed to the Apache Software Foundation (ASF) under one or more
+ *  * contributor license agreements.  See the NOTICE file distributed with
+ *  * this work for additional information regarding copyright ownership.
+ *  * The ASF licenses this file to You under the Apache License, Version 2.0
+ *  * (the "License"); you may not use this file except in compliance with
+ *  * the License.  You may obtain a copy of the License at
+ *  *
+ *  *     http://www.apache.org/licenses/LICENSE-2.0
+ *  *
+ *  * Unless required by applicable law or agreed to in writing, software
+ *  * distributed under the License is distributed on an "AS IS" BASIS,
+ *  * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  * See the License for the specific language governing permissions and
+ *  * limitations under the License.
+ *
+ */
+
+// Package kafkaclient is a client of the Kafka protocol producing records
+// to the topics and fetching them from the partition leaders
+package kafkaclient
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// The api keys and the versions used
+const (
+	apiProduce     = 0
+	apiFetch       = 1
+	apiListOffsets = 2
+	apiMetadata    = 3
+
+	produceVersion     = 3
+	fetchVersion       = 4
+	listOffsetsVersion = 1
+	metadataVersion    = 1
+)
+
+// The start offsets of a consumer
+const (
+	Latest   int64 = -1
+	Earliest int64 = -2
+)
+
+// The error codes that a new metadata request fixes
+const (
+	errOffsetOutOfRange      = 1
+	errUnknownTopic          = 3
+	errLeaderNotAvailable    = 5
+	errNotLeaderForPartition = 6
+	errNotEnoughReplicas     = 19
+)
+
+// Error is an error code of a broker
+type Error int16
+
+func (e Error) Error() string {
+	return "kafka: error code " + strconv.Itoa(int(e))
+}
+
+// retriable tells whether the error is fixed by a new metadata request
+func retriable(err error) bool {
+	var kerr Error
+	if !errors.As(err, &kerr) {
+		// the connection errors
+		return true
+	}
+	switch kerr {
+	case errUnknownTopic, errLeaderNotAvailable, errNotLeaderForPartition, errNotEnoughReplicas:
+		return true
+	}
+	return false
+}
+
+// Options are the options of a client
+type Options struct {
+	ClientID string
+	// Timeout of the connections and of the requests
+	Timeout time.Duration
+	// TLS of the connections to the brokers, plain text when nil. The server
+	// name is the host of each broker when empty.
+	TLS *tls.Config
+	// SASL authenticates the connections when not nil
+	SASL *SASL
+}
+
+// Client is a client of a Kafka cluster
+type Client struct {
+	opts      Options
+	bootstrap []string
+
+	mu      sync.Mutex
+	conns   map[string]*brokerConn
+	brokers map[int32]string
+	// The leader of every partition of the topics
+	leaders map[string][]int32
+
+	next atomic.Uint32
+}
+
+type brokerConn struct {
+	mu          sync.Mutex
+	conn        net.Conn
+	r           *bufio.Reader
+	correlation int32
+}
+
+// New creates a client of the cluster of the bootstrap brokers
+func New(bootstrap []string, opts Options) *Client {
+	if opts.Timeout <= 0 {
+		opts.Timeout = 10 * time.Second
+	}
+	if opts.ClientID == "" {
+		opts.ClientID = "icefiredb"
+	}
+	return &Client{
+		opts:      opts,
+		bootstrap: bootstrap,
+		conns:     make(map[string]*brokerConn),
+		brokers:   make(map[int32]string),
+		leaders:   make(map[string][]int32),
+	}
+}
+
+// conn returns the connection to a broker, opened the first time
+func (c *Client) conn(addr string) (*brokerConn, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if b, ok := c.conns[addr]; ok {
+		return b, nil
+	}
+	nc, err := net.DialTimeout("tcp", addr, c.opts.Timeout)
+	if err != nil {
+		return nil, err
+	}
+	if c.opts.TLS != nil {
+		cfg := c.opts.TLS
+		if cfg.ServerName == "" {
+			cfg = cfg.Clone()
+			cfg.ServerName, _, _ = net.SplitHostPort(addr)
+		}
+		tc := tls.Client(nc, cfg)
+		_ = tc.SetDeadline(time.Now().Add(c.opts.Timeout))
+		if err = tc.Handshake(); err != nil {
+			nc.Close()
+			return nil, err
+		}
+		nc = tc
+	}
+	b := &brokerConn{conn: nc, r: bufio.NewReader(nc)}
+	if c.opts.SASL != nil {
+		if err = b.authenticate(c.opts.ClientID, c.opts.SASL, c.opts.Timeout); err != nil {
+			nc.Close()
+			return nil, err
+		}
+	}
+	c.conns[addr] = b
+	return b, nil
+}
+
+// request sends a request to a broker and returns the body of the response.
+// The connection is closed after an error.
+func (c *Client) request(addr string, apiKey int16, version int16, body []byte, timeout time.Duration) ([]byte, error) {
+	b, err := c.conn(addr)
+	if err != nil {
+		return nil, err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	resp, err := b.roundTrip(c.opts.ClientID, apiKey, version, body, c.opts.Timeout+timeout)
+	if err != nil {
+		c.mu.Lock()
+		if c.conns[addr] == b {
+			delete(c.conns, addr)
+		}
+		c.mu.Unlock()
+		b.conn.Close()
+	}
+	return resp, err
+}
+
+func (b *brokerConn) roundTrip(clientID string, apiKey int16, version int16, body []byte, timeout time.Duration) ([]byte, error) {
+	b.correlation++
+	var e encoder
+	e.int32(0)
+	e.int16(apiKey)
+	e.int16(version)
+	e.int32(b.correlation)
+	e.string(clientID)
+	e.Write(body)
+	req := e.Bytes()
+	binary.BigEndian.PutUint32(req, uint32(len(req)-4))
+
+	_ = b.conn.SetDeadline(time.Now().Add(timeout))
+	if _, err := b.conn.Write(req); err != nil {
+		return nil, err
+	}
+	head := make([]byte, 8)
+	if _, err := io.ReadFull(b.r, head); err != nil {
+		return nil, err
+	}
+	size := int32(binary.BigEndian.Uint32(head))
+	if size < 4 {
+		return nil, ErrMalformed
+	}
+	if int32(binary.BigEndian.Uint32(head[4:])) != b.correlation {
+		return nil, errors.New("kafka: correlation id mismatch")
+	}
+	resp := make([]byte, size-4)
+	if _, err := io.ReadFull(b.r, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// refresh requests the metadata of the topic to a known broker
+func (c *Client) refresh(topic string) error {
+	var e encoder
+	e.int32(1)
+	e.string(topic)
+
+	c.mu.Lock()
+	addrs := append([]string(nil), c.bootstrap...)
+	for _, addr := range c.brokers {
+		addrs = append(addrs, addr)
+	}
+	c.mu.Unlock()
+
+	var err error
+	for _, addr := range addrs {
+		var resp []byte
+		resp, err = c.request(addr, apiMetadata, metadataVersion, e.Bytes(), 0)
+		if err != nil {
+			continue
+		}
+		return c.metadata(topic, resp)
+	}
+	if err == nil {
+		err = errors.New("kafka: no broker")
+	}
+	return err
+}
+
+func (c *Client) metadata(topic string, resp []byte) error {
+	d := &decoder{b: resp}
+	brokers := make(map[int32]string)
+	for n := d.count(); n > 0; n-- {
+		id := d.int32()
+		host := d.string()
+		port := d.int32()
+		d.string()
+		brokers[id] = net.JoinHostPort(host, strconv.Itoa(int(port)))
+	}
+	d.int32()
+	var leaders []int32
+	for n := d.count(); n > 0; n-- {
+		code := d.int16()
+		name := d.string()
+		d.int8()
+		partitions := d.count()
+		if name == topic {
+			if code != 0 {
+				return Error(code)
+			}
+			leaders = make([]int32, partitions)
+		}
+		for ; partitions > 0; partitions-- {
+			code := d.int16()
+			index := d.int32()
+			leader := d.int32()
+			for r := d.count(); r > 0; r-- {
+				d.int32()
+			}
+			for r := d.count(); r > 0; r-- {
+				d.int32()
+			}
+			if name != topic {
+				continue
+			}
+			if code != 0 && code != errLeaderNotAvailable || index < 0 || int(index) >= len(leaders) {
+				return ErrMalformed
+			}
+			leaders[index] = leader
+			if code == errLeaderNotAvailable {
+				leaders[index] = -1
+			}
+		}
+	}
+	if d.err != nil {
+		return d.err
+	}
+	if len(leaders) == 0 {
+		return Error(errUnknownTopic)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for id, addr := range brokers {
+		c.brokers[id] = addr
+	}
+	c.leaders[topic] = leaders
+	return nil
+}
+
+// leader returns the address of the leader of a partition
+func (c *Client) leader(topic string, partition int32) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	leaders := c.leaders[topic]
+	if int(partition) >= len(leaders) {
+		return "", Error(errUnknownTopic)
+	}
+	addr, ok := c.brokers[leaders[partition]]
+	if !ok {
+		return "", Error(errLeaderNotAvailable)
+	}
+	return addr, nil
+}
+
+func (c *Client) partitions(topic string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.leaders[topic])
+}
+
+// Produce appends a record to a partition of the topic, chosen by the key or
+// in turn without a key, and waits for the replicas to store it
+func (c *Client) Produce(topic string, key []byte, value []byte) error {
+	var err error
+	for attempt := 0; attempt < 3; attempt++ {
+		if attempt > 0 || c.partitions(topic) == 0 {
+			if err = c.refresh(topic); err != nil {
+				if !retriable(err) {
+					return err
+				}
+				time.Sleep(time.Duration(attempt) * 100 * time.Millisecond)
+				continue
+			}
+		}
+		if err = c.produce(topic, key, value); err == nil || !retriable(err) {
+			return err
+		}
+	}
+	return err
+}
+
+func (c *Client) produce(topic string, key []byte, value []byte) error {
+	n := c.partitions(topic)
+	if n == 0 {
+		return Error(errUnknownTopic)
+	}
+	partition := int32(c.next.Add(1) % uint32(n))
+	if key != nil {
+		h := fnv.New32a()
+		_, _ = h.Write(key)
+		partition = int32(h.Sum32() % uint32(n))
+	}
+	addr, err := c.leader(topic, partition)
+	if err != nil {
+		return err
+	}
+
+	var e encoder
+	e.int16(-1)
+	e.int16(-1)
+	e.int32(int32(c.opts.Timeout / time.Millisecond))
+	e.int32(1)
+	e.string(topic)
+	e.int32(1)
+	e.int32(partition)
+	e.bytes(encodeBatch(time.Now(), []Record{{Key: key, Value: value}}))
+	resp, err := c.request(addr, apiProduce, produceVersion, e.Bytes(), c.opts.Timeout)
+	if err != nil {
+		return err
+	}
+	d := &decoder{b: resp}
+	for n := d.count(); n > 0; n-- {
+		d.string()
+		for p := d.count(); p > 0; p-- {
+			d.int32()
+			if code := d.int16(); code != 0 && d.err == nil {
+				return Error(code)
+			}
+			d.int64()
+			d.int64()
+		}
+	}
+	return d.err
+}
+
+// listOffset returns the latest or earliest offset of a partition
+func (c *Client) listOffset(topic string, partition int32, start int64) (int64, error) {
+	addr, err := c.leader(topic, partition)
+	if err != nil {
+		return 0, err
+	}
+	var e encoder
+	e.int32(-1)
+	e.int32(1)
+	e.string(topic)
+	e.int32(1)
+	e.int32(partition)
+	e.int64(start)
+	resp, err := c.request(addr, apiListOffsets, listOffsetsVersion, e.Bytes(), 0)
+	if err != nil {
+		return 0, err
+	}
+	d := &decoder{b: resp}
+	for n := d.count(); n > 0; n-- {
+		d.string()
+		for p := d.count(); p > 0; p-- {
+			index := d.int32()
+			code := d.int16()
+			d.int64()
+			offset := d.int64()
+			if d.err != nil {
+				return 0, d.err
+			}
+			if index != partition {
+				continue
+			}
+			if code != 0 {
+				return 0, Error(code)
+			}
+			return offset, nil
+		}
+	}
+	if d.err != nil {
+		return 0, d.err
+	}
+	return 0, ErrMalformed
+}
+
+// The fetch parameters
+const (
+	fetchMaxWait  = 500 * time.Millisecond
+	fetchMaxBytes = 4 << 20
+)
+
+// fetch fetches the records of the partitions of a leader from their
+// offsets
+func (c *Client) fetch(addr string, topic string, offsets map[int32]int64) ([]Record, error) {
+	var e encoder
+	e.int32(-1)
+	e.int32(int32(fetchMaxWait / time.Millisecond))
+	e.int32(1)
+	e.int32(fetchMaxBytes)
+	e.int8(0)
+	e.int32(1)
+	e.string(topic)
+	e.int32(int32(len(offsets)))
+	for partition, offset := range offsets {
+		e.int32(partition)
+		e.int64(offset)
+		e.int32(fetchMaxBytes)
+	}
+	resp, err := c.request(addr, apiFetch, fetchVersion, e.Bytes(), fetchMaxWait)
+	if err != nil {
+		return nil, err
+	}
+
+	d := &decoder{b: resp}
+	d.int32()
+	var records []Record
+	for n := d.count(); n > 0; n-- {
+		d.string()
+		for p := d.count(); p > 0; p-- {
+			partition := d.int32()
+			code := d.int16()
+			d.int64()
+			d.int64()
+			for a := d.count(); a > 0; a-- {
+				d.int64()
+				d.int64()
+			}
+			batches := d.bytes()
+			if d.err != nil {
+				return nil, d.err
+			}
+			if code != 0 {
+				return nil, Error(code)
+			}
+			recs, err := decodeBatches(partition, batches)
+			if err != nil {
+				return nil, err
+			}
+			for _, r := range recs {
+				// a batch may start before the offset fetched
+				if r.Offset >= offsets[partition] {
+					records = append(records, r)
+				}
+			}
+		}
+	}
+	return records, d.err
+}
+
+// Consume calls fn with the records of every partition of the topic, from
+// the latest or the earliest offset, until the context is done. A record is
+// passed again until fn returns nil, and fn is called concurrently for the
+// partitions of different leaders.
+func (c *Client) Consume(ctx context.Context, topic string, start int64, fn func(Record) error) error {
+	var mu sync.Mutex
+	offsets := make(map[int32]int64)
+	for ctx.Err() == nil {
+		err := c.consume(ctx, topic, start, &mu, offsets, fn)
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err != nil && !retriable(err) {
+			var kerr Error
+			if !errors.As(err, &kerr) || kerr != errOffsetOutOfRange {
+				return err
+			}
+			// the records are deleted by the retention, the partitions start again
+			mu.Lock()
+			offsets = make(map[int32]int64)
+			mu.Unlock()
+			start = Earliest
+		}
+		select {
+		case <-ctx.Done():
+		case <-time.After(time.Second):
+		}
+	}
+	return nil
+}
+
+// consume fetches the partitions from their leaders until an error
+func (c *Client) consume(ctx context.Context, topic string, start int64, mu *sync.Mutex, offsets map[int32]int64, fn func(Record) error) error {
+	if err := c.refresh(topic); err != nil {
+		return err
+	}
+	byLeader := make(map[string][]int32)
+	for p := int32(0); int(p) < c.partitions(topic); p++ {
+		addr, err := c.leader(topic, p)
+		if err != nil {
+			return err
+		}
+		mu.Lock()
+		_, ok := offsets[p]
+		mu.Unlock()
+		if !ok {
+			offset, err := c.listOffset(topic, p, start)
+			if err != nil {
+				return err
+			}
+			mu.Lock()
+			offsets[p] = offset
+			mu.Unlock()
+		}
+		byLeader[addr] = append(byLeader[addr], p)
+	}
+	if len(byLeader) == 0 {
+		return Error(errUnknownTopic)
+	}
+
+	cctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	errc := make(chan error, len(byLeader))
+	for addr, partitions := range byLeader {
+		go func(addr string, partitions []int32) {
+			for cctx.Err() == nil {
+				mu.Lock()
+				fetch := make(map[int32]int64, len(partitions))
+				for _, p := range partitions {
+					fetch[p] = offsets[p]
+				}
+				mu.Unlock()
+				records, err := c.fetch(addr, topic, fetch)
+				if err != nil {
+					errc <- err
+					return
+				}
+				for _, r := range records {
+					if err = fn(r); err != nil {
+						errc <- fmt.Errorf("kafka: record %d of partition %d: %w", r.Offset, r.Partition, err)
+						return
+					}
+					mu.Lock()
+					offsets[r.Partition] = r.Offset + 1
+					mu.Unlock()
+				}
+			}
+			errc <- nil
+		}(addr, partitions)
+	}
+	err := <-errc
+	cancel()
+	for i := 1; i < len(byLeader); i++ {
+		<-errc
+	}
+	return err
+}
+
+// Close closes the connections to the brokers
+func (c *Client) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for addr, b := range c.conns {
+		b.conn.Close()
+		delete(c.conns, addr)
+	}
+	return nil
+}
@@ -0,0 +1,385 @@
+package kafkaclient
+
+import (
+	"bufio"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"math/big"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestBatch(t *testing.T) {
+	now := time.UnixMilli(1700000000000)
+	b := encodeBatch(now, []Record{{Key: []byte("k"), Value: []byte("a")}, {Value: []byte("b")}})
+	// the base offset assigned by the broker
+	binary.BigEndian.PutUint64(b, 10)
+	records, err := decodeBatches(3, append(b, b[:20]...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 {
+		t.Fatalf("records: %+v", records)
+	}
+	r := records[1]
+	if r.Partition != 3 || r.Offset != 11 || r.Key != nil || string(r.Value) != "b" || !r.Time.Equal(now) {
+		t.Fatalf("record: %+v", r)
+	}
+	if string(records[0].Key) != "k" {
+		t.Fatalf("key: %q", records[0].Key)
+	}
+
+	b[len(b)-1] ^= 1
+	if _, err = decodeBatches(0, b); err == nil {
+		t.Fatal("crc mismatch not detected")
+	}
+}
+
+// fakeBroker is a cluster of one broker with one topic of one partition
+type fakeBroker struct {
+	ln    net.Listener
+	topic string
+	auth  brokerAuth
+	mu    sync.Mutex
+	log   [][]byte
+}
+
+// brokerAuth is the TLS of a fake broker and the SASL mechanism and user
+// it requires, none when empty
+type brokerAuth struct {
+	tls       *tls.Config
+	mechanism string
+	user      string
+	password  string
+}
+
+func newFakeBroker(t *testing.T, topic string, auth brokerAuth) *fakeBroker {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &fakeBroker{ln: ln, topic: topic, auth: auth}
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go f.serve(c)
+		}
+	}()
+	t.Cleanup(func() { ln.Close() })
+	return f
+}
+
+func (f *fakeBroker) serve(c net.Conn) {
+	defer c.Close()
+	if f.auth.tls != nil {
+		c = tls.Server(c, f.auth.tls)
+	}
+	r := bufio.NewReader(c)
+	authenticated := f.auth.mechanism == ""
+	var scram *fakeScram
+	for {
+		head := make([]byte, 4)
+		if _, err := io.ReadFull(r, head); err != nil {
+			return
+		}
+		req := make([]byte, binary.BigEndian.Uint32(head))
+		if _, err := io.ReadFull(r, req); err != nil {
+			return
+		}
+		d := &decoder{b: req}
+		apiKey := d.int16()
+		d.int16()
+		correlation := d.int32()
+		d.string()
+
+		var e encoder
+		e.int32(0)
+		e.int32(correlation)
+		if !authenticated && apiKey != apiSaslHandshake && apiKey != apiSaslAuthenticate {
+			// the brokers close the connections not authenticated
+			return
+		}
+		switch apiKey {
+		case apiSaslHandshake:
+			if d.string() != f.auth.mechanism {
+				e.int16(33)
+			} else {
+				e.int16(0)
+			}
+			e.int32(1)
+			e.string(f.auth.mechanism)
+		case apiSaslAuthenticate:
+			msg := string(d.bytes())
+			var reply string
+			ok := true
+			switch f.auth.mechanism {
+			case Plain:
+				ok = msg == "\x00"+f.auth.user+"\x00"+f.auth.password
+				authenticated = ok
+			case ScramSHA256:
+				if scram == nil {
+					scram = &fakeScram{user: f.auth.user, password: f.auth.password}
+					reply, ok = scram.first(msg)
+				} else {
+					reply, ok = scram.final(msg)
+					authenticated = ok
+				}
+			}
+			if ok {
+				e.int16(0)
+				e.string("")
+			} else {
+				e.int16(58)
+				e.string("invalid credentials")
+			}
+			e.bytes([]byte(reply))
+		case apiMetadata:
+			host, port, _ := net.SplitHostPort(f.ln.Addr().String())
+			p, _ := strconv.Atoi(port)
+			e.int32(1)
+			e.int32(1)
+			e.string(host)
+			e.int32(int32(p))
+			e.int16(-1)
+			e.int32(1)
+			e.int32(1)
+			e.int16(0)
+			e.string(f.topic)
+			e.int8(0)
+			e.int32(1)
+			e.int16(0)
+			e.int32(0)
+			e.int32(1)
+			e.int32(0)
+			e.int32(0)
+		case apiProduce:
+			d.int16()
+			d.int16()
+			d.int32()
+			d.count()
+			d.string()
+			d.count()
+			d.int32()
+			batch := d.bytes()
+			f.mu.Lock()
+			binary.BigEndian.PutUint64(batch, uint64(len(f.log)))
+			f.log = append(f.log, append([]byte(nil), batch...))
+			f.mu.Unlock()
+			e.int32(1)
+			e.string(f.topic)
+			e.int32(1)
+			e.int32(0)
+			e.int16(0)
+			e.int64(0)
+			e.int64(-1)
+			e.int32(0)
+		case apiListOffsets:
+			d.int32()
+			d.count()
+			d.string()
+			d.count()
+			d.int32()
+			var latest int64
+			if d.int64() == Latest {
+				f.mu.Lock()
+				latest = int64(len(f.log))
+				f.mu.Unlock()
+			}
+			e.int32(1)
+			e.string(f.topic)
+			e.int32(1)
+			e.int32(0)
+			e.int16(0)
+			e.int64(-1)
+			e.int64(latest)
+		case apiFetch:
+			d.int32()
+			d.int32()
+			d.int32()
+			d.int32()
+			d.int8()
+			d.count()
+			d.string()
+			d.count()
+			d.int32()
+			offset := d.int64()
+			var batches []byte
+			f.mu.Lock()
+			for _, b := range f.log[min(int(offset), len(f.log)):] {
+				batches = append(batches, b...)
+			}
+			f.mu.Unlock()
+			if batches == nil {
+				time.Sleep(10 * time.Millisecond)
+			}
+			e.int32(0)
+			e.int32(1)
+			e.string(f.topic)
+			e.int32(1)
+			e.int32(0)
+			e.int16(0)
+			e.int64(0)
+			e.int64(0)
+			e.int32(0)
+			e.bytes(batches)
+		}
+		resp := e.Bytes()
+		binary.BigEndian.PutUint32(resp, uint32(len(resp)-4))
+		if _, err := c.Write(resp); err != nil {
+			return
+		}
+	}
+}
+
+func TestProduceConsume(t *testing.T) {
+	f := newFakeBroker(t, "orders", brokerAuth{})
+	c := New([]string{f.ln.Addr().String()}, Options{Timeout: time.Second})
+	defer c.Close()
+	if err := c.Produce("orders", nil, []byte("before")); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	got := make(chan Record, 4)
+	done := make(chan error, 1)
+	go func() {
+		done <- c.Consume(ctx, "orders", Earliest, func(r Record) error {
+			got <- r
+			return nil
+		})
+	}()
+	for i, want := range []string{"before", "after"} {
+		if i == 1 {
+			if err := c.Produce("orders", []byte("k"), []byte("after")); err != nil {
+				t.Fatal(err)
+			}
+		}
+		select {
+		case r := <-got:
+			if string(r.Value) != want || r.Offset != int64(i) {
+				t.Fatalf("record %d: %+v", i, r)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("record %d not consumed", i)
+		}
+	}
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+// fakeScram is the broker side of a SCRAM-SHA-256 exchange
+type fakeScram struct {
+	user, password string
+	clientFirst    string
+	serverFirst    string
+	salted         []byte
+}
+
+func (s *fakeScram) first(msg string) (string, bool) {
+	s.clientFirst = strings.TrimPrefix(msg, "n,,")
+	attrs := scramAttrs(s.clientFirst)
+	if attrs["n"] != s.user {
+		return "", false
+	}
+	salt := []byte("salt")
+	s.salted, _ = pbkdf2.Key(sha256.New, s.password, salt, 4096, sha256.Size)
+	s.serverFirst = "r=" + attrs["r"] + "server,s=" + base64.StdEncoding.EncodeToString(salt) + ",i=4096"
+	return s.serverFirst, true
+}
+
+func (s *fakeScram) final(msg string) (string, bool) {
+	i := strings.LastIndex(msg, ",p=")
+	if i < 0 {
+		return "", false
+	}
+	authMessage := []byte(s.clientFirst + "," + s.serverFirst + "," + msg[:i])
+	proof, _ := base64.StdEncoding.DecodeString(msg[i+3:])
+	clientKey := hmacSum(sha256.New, s.salted, []byte("Client Key"))
+	storedKey := sha256.Sum256(clientKey)
+	want := hmacSum(sha256.New, storedKey[:], authMessage)
+	for j := range want {
+		want[j] ^= clientKey[j]
+	}
+	if !hmac.Equal(proof, want) {
+		return "e=invalid-proof", false
+	}
+	signature := hmacSum(sha256.New, hmacSum(sha256.New, s.salted, []byte("Server Key")), authMessage)
+	return "v=" + base64.StdEncoding.EncodeToString(signature), true
+}
+
+// testTLS returns the TLS configurations of a server of 127.0.0.1 with a
+// self-signed certificate, and of the clients trusting it
+func testTLS(t *testing.T) (*tls.Config, *tls.Config) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "127.0.0.1"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}},
+		&tls.Config{RootCAs: pool}
+}
+
+func TestSecure(t *testing.T) {
+	server, client := testTLS(t)
+	for _, mechanism := range []string{Plain, ScramSHA256} {
+		f := newFakeBroker(t, "orders", brokerAuth{tls: server, mechanism: mechanism, user: "u", password: "p"})
+		addr := []string{f.ln.Addr().String()}
+
+		c := New(addr, Options{Timeout: time.Second, TLS: client, SASL: &SASL{Mechanism: mechanism, User: "u", Password: "p"}})
+		if err := c.Produce("orders", nil, []byte("v")); err != nil {
+			t.Fatalf("%s: %v", mechanism, err)
+		}
+		c.Close()
+
+		c = New(addr, Options{Timeout: time.Second, TLS: client, SASL: &SASL{Mechanism: mechanism, User: "u", Password: "wrong"}})
+		if err := c.Produce("orders", nil, []byte("v")); err == nil {
+			t.Errorf("%s: wrong password accepted", mechanism)
+		}
+		c.Close()
+	}
+
+	f := newFakeBroker(t, "orders", brokerAuth{tls: server, mechanism: Plain, user: "u", password: "p"})
+	c := New([]string{f.ln.Addr().String()}, Options{Timeout: time.Second, TLS: client, SASL: &SASL{Mechanism: ScramSHA512, User: "u", Password: "p"}})
+	defer c.Close()
+	if err := c.Produce("orders", nil, []byte("v")); err == nil || !strings.Contains(err.Error(), "refused") {
+		t.Errorf("mechanism not supported: %v", err)
+	}
+}
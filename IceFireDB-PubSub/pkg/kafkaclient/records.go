@@ -0,0 +1,170 @@
+/*
+ *
+ *  * Licensed to the Apache Software Foundation (ASF) under one or more
+ *  * contributor license agreements.  See the NOTICE file distributed with
+ *  * this work for additional information regarding copyright ownership.
+ *  * The ASF licenses this file to You under the Apache License, Version 2.0
+ *  * (the "License"); you may not use this file except in compliance with
+ *  * the License.  You may obtain a copy of the License at
+ *  *
+ *  *     http://www.apache.org/licenses/LICENSE-2.0
+ *  *
+ *  * Unless required by applicable law or agreed to in writing, software
+ *  * distributed under the License is distributed on an "AS IS" BASIS,
+ *  * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  * See the License for the specific language governing permissions and
+ *  * limitations under the License.
+ *
+ */
+
+package kafkaclient
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"time"
+)
+
+var ErrCompression = errors.New("kafka: unsupported compression")
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// The attributes of a record batch
+const (
+	compressionMask = 0x07
+	compressionGzip = 1
+	controlBatch    = 0x20
+)
+
+// Record is a record of a partition
+type Record struct {
+	Partition int32
+	Offset    int64
+	Time      time.Time
+	Key       []byte
+	Value     []byte
+}
+
+// encodeBatch encodes the records as an uncompressed record batch of the
+// message format v2
+func encodeBatch(now time.Time, records []Record) []byte {
+	ts := now.UnixMilli()
+	var recs encoder
+	for i, r := range records {
+		var rec encoder
+		rec.int8(0)
+		rec.varint(0)
+		rec.varint(int64(i))
+		rec.varbytes(r.Key)
+		rec.varbytes(r.Value)
+		rec.varint(0)
+		recs.varint(int64(rec.Len()))
+		recs.Write(rec.Bytes())
+	}
+
+	// the part of the batch covered by the crc
+	var body encoder
+	body.int16(0)
+	body.int32(int32(len(records) - 1))
+	body.int64(ts)
+	body.int64(ts)
+	body.int64(-1)
+	body.int16(-1)
+	body.int32(-1)
+	body.int32(int32(len(records)))
+	body.Write(recs.Bytes())
+
+	var e encoder
+	e.int64(0)
+	e.int32(int32(4 + 1 + 4 + body.Len()))
+	e.int32(-1)
+	e.int8(2)
+	e.Write(binary.BigEndian.AppendUint32(nil, crc32.Checksum(body.Bytes(), castagnoli)))
+	e.Write(body.Bytes())
+	return e.Bytes()
+}
+
+// decodeBatches decodes the records of the record batches of a partition,
+// skipping the control batches. A batch truncated at the end of the fetch
+// is ignored.
+func decodeBatches(partition int32, b []byte) ([]Record, error) {
+	var records []Record
+	for len(b) >= 12 {
+		baseOffset := int64(binary.BigEndian.Uint64(b))
+		length := int(int32(binary.BigEndian.Uint32(b[8:])))
+		if length < 0 {
+			return nil, ErrMalformed
+		}
+		if len(b) < 12+length {
+			break
+		}
+		d := &decoder{b: b[12 : 12+length]}
+		b = b[12+length:]
+
+		d.int32()
+		if magic := d.int8(); magic != 2 {
+			// the batches of the message formats v0 and v1 are skipped
+			continue
+		}
+		crc := uint32(d.int32())
+		if d.err == nil && crc32.Checksum(d.b, castagnoli) != crc {
+			return nil, errors.New("kafka: record batch crc mismatch")
+		}
+		attributes := d.int16()
+		d.int32()
+		baseTime := d.int64()
+		d.int64()
+		d.int64()
+		d.int16()
+		d.int32()
+		// not bounded by the size of a compressed batch
+		n := int(d.int32())
+		if d.err != nil || n < 0 {
+			return nil, ErrMalformed
+		}
+		if attributes&controlBatch != 0 {
+			continue
+		}
+		switch attributes & compressionMask {
+		case 0:
+		case compressionGzip:
+			zr, err := gzip.NewReader(bytes.NewReader(d.b))
+			if err != nil {
+				return nil, err
+			}
+			data, err := io.ReadAll(zr)
+			if err != nil {
+				return nil, err
+			}
+			d.b = data
+		default:
+			return nil, ErrCompression
+		}
+		for i := 0; i < n; i++ {
+			d.varint()
+			d.int8()
+			timeDelta := d.varint()
+			offsetDelta := d.varint()
+			r := Record{
+				Partition: partition,
+				Offset:    baseOffset + offsetDelta,
+				Time:      time.UnixMilli(baseTime + timeDelta),
+				Key:       d.varbytes(),
+				Value:     d.varbytes(),
+			}
+			for h := d.varint(); h > 0; h-- {
+				d.varbytes()
+				d.varbytes()
+			}
+			if d.err != nil {
+				return nil, d.err
+			}
+			records = append(records, r)
+		}
+	}
+	return records, nil
+}
@@ -0,0 +1,181 @@
+/*
+ *
+ *  * Licensed to the Apache Software Foundation (ASF) under one or more
+ *  * contributor license agreements.  See the NOTICE file distributed with
+ *  * this work for additional information regarding copyright ownership.
+ *  * The ASF licenses this file to You under the Apache License, Version 2.0
+ *  * (the "License"); you may not use this file except in compliance with
+ *  * the License.  You may obtain a copy of the License at
+ *  *
+ *  *     http://www.apache.org/licenses/LICENSE-2.0
+ *  *
+ *  * Unless required by applicable law or agreed to in writing, software
+ *  * distributed under the License is distributed on an "AS IS" BASIS,
+ *  * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  * See the License for the specific language governing permissions and
+ *  * limitations under the License.
+ *
+ */
+
+package kafkaclient
+
+import (
+	"crypto/hmac"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The api keys of the authentication
+const (
+	apiSaslHandshake    = 17
+	apiSaslAuthenticate = 36
+
+	saslHandshakeVersion    = 1
+	saslAuthenticateVersion = 0
+)
+
+// The SASL mechanisms
+const (
+	Plain       = "PLAIN"
+	ScramSHA256 = "SCRAM-SHA-256"
+	ScramSHA512 = "SCRAM-SHA-512"
+)
+
+// SASL are the credentials authenticating the connections to the brokers
+type SASL struct {
+	// PLAIN, SCRAM-SHA-256 or SCRAM-SHA-512
+	Mechanism string
+	User      string
+	Password  string
+}
+
+// authenticate runs the SASL exchange on a new connection
+func (b *brokerConn) authenticate(clientID string, sasl *SASL, timeout time.Duration) error {
+	var e encoder
+	e.string(sasl.Mechanism)
+	resp, err := b.roundTrip(clientID, apiSaslHandshake, saslHandshakeVersion, e.Bytes(), timeout)
+	if err != nil {
+		return err
+	}
+	d := &decoder{b: resp}
+	if code := d.int16(); code != 0 {
+		var mechanisms []string
+		for n := d.count(); n > 0; n-- {
+			mechanisms = append(mechanisms, d.string())
+		}
+		return fmt.Errorf("kafka: sasl mechanism %s refused, the broker supports %s", sasl.Mechanism, strings.Join(mechanisms, ","))
+	}
+
+	auth := func(msg []byte) ([]byte, error) {
+		var e encoder
+		e.bytes(msg)
+		resp, err := b.roundTrip(clientID, apiSaslAuthenticate, saslAuthenticateVersion, e.Bytes(), timeout)
+		if err != nil {
+			return nil, err
+		}
+		d := &decoder{b: resp}
+		code := d.int16()
+		message := d.string()
+		reply := d.bytes()
+		if d.err != nil {
+			return nil, d.err
+		}
+		if code != 0 {
+			return nil, fmt.Errorf("kafka: sasl authentication failed: %s (%w)", message, Error(code))
+		}
+		return reply, nil
+	}
+
+	switch sasl.Mechanism {
+	case Plain:
+		_, err = auth([]byte("\x00" + sasl.User + "\x00" + sasl.Password))
+		return err
+	case ScramSHA256:
+		return scram(sha256.New, sasl.User, sasl.Password, auth)
+	case ScramSHA512:
+		return scram(sha512.New, sasl.User, sasl.Password, auth)
+	}
+	return fmt.Errorf("kafka: unknown sasl mechanism %q", sasl.Mechanism)
+}
+
+// scram runs the client side of RFC 5802 through auth, which sends a
+// message and returns the answer of the broker
+func scram(h func() hash.Hash, user, password string, auth func([]byte) ([]byte, error)) error {
+	nonce := make([]byte, 18)
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	user = strings.NewReplacer("=", "=3D", ",", "=2C").Replace(user)
+	clientFirst := "n=" + user + ",r=" + base64.RawStdEncoding.EncodeToString(nonce)
+	serverFirst, err := auth([]byte("n,," + clientFirst))
+	if err != nil {
+		return err
+	}
+
+	attrs := scramAttrs(string(serverFirst))
+	salt, err := base64.StdEncoding.DecodeString(attrs["s"])
+	if err != nil {
+		return errors.New("kafka: invalid scram salt")
+	}
+	iterations, err := strconv.Atoi(attrs["i"])
+	if err != nil || iterations <= 0 {
+		return errors.New("kafka: invalid scram iteration count")
+	}
+	if !strings.HasPrefix(attrs["r"], clientFirst[strings.Index(clientFirst, ",r=")+3:]) {
+		return errors.New("kafka: scram nonce mismatch")
+	}
+
+	salted, err := pbkdf2.Key(h, password, salt, iterations, h().Size())
+	if err != nil {
+		return err
+	}
+	clientKey := hmacSum(h, salted, []byte("Client Key"))
+	storedKey := h()
+	storedKey.Write(clientKey)
+	final := "c=biws,r=" + attrs["r"]
+	authMessage := []byte(clientFirst + "," + string(serverFirst) + "," + final)
+	proof := hmacSum(h, storedKey.Sum(nil), authMessage)
+	for i := range proof {
+		proof[i] ^= clientKey[i]
+	}
+	serverFinal, err := auth([]byte(final + ",p=" + base64.StdEncoding.EncodeToString(proof)))
+	if err != nil {
+		return err
+	}
+
+	attrs = scramAttrs(string(serverFinal))
+	if e, ok := attrs["e"]; ok {
+		return fmt.Errorf("kafka: scram authentication failed: %s", e)
+	}
+	signature := hmacSum(h, hmacSum(h, salted, []byte("Server Key")), authMessage)
+	if v, err := base64.StdEncoding.DecodeString(attrs["v"]); err != nil || !hmac.Equal(v, signature) {
+		return errors.New("kafka: invalid scram server signature")
+	}
+	return nil
+}
+
+// scramAttrs returns the attributes of a scram message by name
+func scramAttrs(msg string) map[string]string {
+	attrs := make(map[string]string)
+	for _, kv := range strings.Split(msg, ",") {
+		if k, v, ok := strings.Cut(kv, "="); ok {
+			attrs[k] = v
+		}
+	}
+	return attrs
+}
+
+func hmacSum(h func() hash.Hash, key, msg []byte) []byte {
+	mac := hmac.New(h, key)
+	mac.Write(msg)
+	return mac.Sum(nil)
+}
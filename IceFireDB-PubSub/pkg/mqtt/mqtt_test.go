@@ -0,0 +1,217 @@
+package mqtt
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testHandler records the messages and delivers the messages published to
+// the subscribers of their topic
+type testHandler struct {
+	mu    sync.Mutex
+	subs  map[string][]*Session
+	qos   map[*Session]byte
+	acked []uint64
+	next  uint64
+}
+
+func (h *testHandler) Publish(s *Session, topic string, payload []byte) error {
+	h.mu.Lock()
+	subs := append([]*Session(nil), h.subs[topic]...)
+	h.mu.Unlock()
+	for _, sub := range subs {
+		h.mu.Lock()
+		h.next++
+		id, qos := h.next, h.qos[sub]
+		h.mu.Unlock()
+		if qos == 0 {
+			id = 0
+		}
+		_ = sub.Deliver(topic, payload, qos, id)
+	}
+	return nil
+}
+
+func (h *testHandler) Subscribe(s *Session, topic string, qos byte) (byte, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.subs[topic] = append(h.subs[topic], s)
+	h.qos[s] = qos
+	return qos, nil
+}
+
+func (h *testHandler) Unsubscribe(s *Session, topic string) {}
+
+func (h *testHandler) Ack(s *Session, topic string, id uint64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.acked = append(h.acked, id)
+}
+
+func (h *testHandler) Disconnect(s *Session) {}
+
+type testClient struct {
+	t    *testing.T
+	conn net.Conn
+	r    *bufio.Reader
+	v    byte
+}
+
+func dial(t *testing.T, addr string, version byte, clientID string, user string, password string) (*testClient, packet) {
+	t.Helper()
+	c, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	body := appendString(nil, "MQTT")
+	flags := byte(0x02)
+	if user != "" {
+		flags |= 0xC0
+	}
+	body = append(body, version, flags, 0, 30)
+	if version == Version5 {
+		body = append(body, 0)
+	}
+	body = appendString(body, clientID)
+	if user != "" {
+		body = appendString(appendString(body, user), password)
+	}
+	tc := &testClient{t: t, conn: c, r: bufio.NewReader(c), v: version}
+	tc.send(packet{kind: CONNECT, body: body})
+	return tc, tc.read()
+}
+
+func (c *testClient) send(p packet) {
+	if _, err := c.conn.Write(p.encode()); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+func (c *testClient) read() packet {
+	c.t.Helper()
+	_ = c.conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	p, err := readPacket(c.r, 0)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	return p
+}
+
+func (c *testClient) subscribe(id uint16, filter string, qos byte) packet {
+	body := binary.BigEndian.AppendUint16(nil, id)
+	if c.v == Version5 {
+		body = append(body, 0)
+	}
+	body = append(appendString(body, filter), qos)
+	c.send(packet{kind: SUBSCRIBE, flags: 0x02, body: body})
+	return c.read()
+}
+
+func serve(t *testing.T, h Handler, opts Options) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go NewServer(h, opts).Serve(ctx, ln)
+	return ln.Addr().String()
+}
+
+func TestPublishSubscribe(t *testing.T) {
+	for _, version := range []byte{Version311, Version5} {
+		h := &testHandler{subs: make(map[string][]*Session), qos: make(map[*Session]byte)}
+		addr := serve(t, h, Options{RetryInterval: 200 * time.Millisecond})
+
+		sub, connack := dial(t, addr, version, "sub", "", "")
+		if connack.kind != CONNACK || connack.body[1] != codeSuccess {
+			t.Fatalf("v%d connack: %+v", version, connack)
+		}
+		suback := sub.subscribe(1, "sensors/temp", 2)
+		codes := suback.body[2:]
+		if version == Version5 {
+			codes = codes[1:]
+		}
+		if suback.kind != SUBACK || len(codes) != 1 || codes[0] != 1 {
+			t.Fatalf("v%d suback: %+v", version, suback)
+		}
+		suback = sub.subscribe(2, "sensors/+", 0)
+		if code := suback.body[len(suback.body)-1]; code < 0x80 {
+			t.Fatalf("v%d wildcard granted: %x", version, code)
+		}
+
+		pub, _ := dial(t, addr, version, "", "", "")
+		body := appendString(nil, "sensors/temp")
+		body = binary.BigEndian.AppendUint16(body, 7)
+		if version == Version5 {
+			body = append(body, 0)
+		}
+		pub.send(packet{kind: PUBLISH, flags: 1 << 1, body: append(body, "21.5"...)})
+		if ack := pub.read(); ack.kind != PUBACK || binary.BigEndian.Uint16(ack.body) != 7 {
+			t.Fatalf("v%d puback: %+v", version, ack)
+		}
+
+		p := sub.read()
+		msg, err := decodePublish(p, version)
+		if err != nil || p.kind != PUBLISH || msg.qos != 1 || msg.topic != "sensors/temp" || string(msg.payload) != "21.5" {
+			t.Fatalf("v%d delivered: %+v %v", version, msg, err)
+		}
+		// not acknowledged, the handler delivers it again with the same id
+		h.mu.Lock()
+		id := h.next
+		h.mu.Unlock()
+		_ = h.subs["sensors/temp"][0].Deliver("sensors/temp", []byte("21.5"), 1, id)
+		again, _ := decodePublish(sub.read(), version)
+		if !again.dup || again.id != msg.id {
+			t.Fatalf("v%d redelivered: %+v", version, again)
+		}
+		sub.send(packet{kind: PUBACK, body: binary.BigEndian.AppendUint16(nil, msg.id)})
+		sub.send(packet{kind: PINGREQ})
+		if p := sub.read(); p.kind != PINGRESP {
+			t.Fatalf("v%d pingresp: %+v", version, p)
+		}
+		h.mu.Lock()
+		if len(h.acked) != 1 || h.acked[0] != id {
+			t.Fatalf("v%d acked: %v", version, h.acked)
+		}
+		h.mu.Unlock()
+	}
+}
+
+func TestRetry(t *testing.T) {
+	h := &testHandler{subs: make(map[string][]*Session), qos: make(map[*Session]byte)}
+	addr := serve(t, h, Options{RetryInterval: 100 * time.Millisecond})
+	sub, _ := dial(t, addr, Version311, "sub", "", "")
+	sub.subscribe(1, "news", 1)
+	h.mu.Lock()
+	s := h.subs["news"][0]
+	h.mu.Unlock()
+	if err := s.Deliver("news", []byte("a"), 1, 0); err != nil {
+		t.Fatal(err)
+	}
+	first, _ := decodePublish(sub.read(), Version311)
+	again, _ := decodePublish(sub.read(), Version311)
+	if first.dup || !again.dup || again.id != first.id {
+		t.Fatalf("retry: %+v %+v", first, again)
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	h := &testHandler{subs: make(map[string][]*Session), qos: make(map[*Session]byte)}
+	addr := serve(t, h, Options{User: "u", Password: "p"})
+	if _, connack := dial(t, addr, Version311, "a", "u", "bad"); connack.body[1] != codeRefusedCredentials311 {
+		t.Fatalf("v3 bad credentials: %+v", connack)
+	}
+	if _, connack := dial(t, addr, Version5, "a", "u", "bad"); connack.body[1] != codeBadCredentials {
+		t.Fatalf("v5 bad credentials: %+v", connack)
+	}
+	if _, connack := dial(t, addr, Version5, "a", "u", "p"); connack.body[1] != codeSuccess {
+		t.Fatalf("credentials: %+v", connack)
+	}
+}
@@ -0,0 +1,360 @@
+/*
+ *
+ *  * Licensed to the Apache Software Foundation (ASF) under one or more
+ *  * contributor license agreements.  See the NOTICE file distributed with
+ *  * this work for additional information regarding copyright ownership.
+ *  * The ASF licenses this file to You under the Apache License, Version 2.0
+ *  * (the "License"); you may not use this file except in compliance with
+ *  * the License.  You may obtain a copy of the License at
+ *  *
+ *  *     http://www.apache.org/licenses/LICENSE-2.0
+ *  *
+ *  * Unless required by applicable law or agreed to in writing, software
+ *  * distributed under the License is distributed on an "AS IS" BASIS,
+ *  * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  * See the License for the specific language governing permissions and
+ *  * limitations under the License.
+ *
+ */
+
+package mqtt
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// The packet types
+const (
+	CONNECT     = 1
+	CONNACK     = 2
+	PUBLISH     = 3
+	PUBACK      = 4
+	PUBREC      = 5
+	PUBREL      = 6
+	PUBCOMP     = 7
+	SUBSCRIBE   = 8
+	SUBACK      = 9
+	UNSUBSCRIBE = 10
+	UNSUBACK    = 11
+	PINGREQ     = 12
+	PINGRESP    = 13
+	DISCONNECT  = 14
+)
+
+// The protocol levels
+const (
+	Version311 = 4
+	Version5   = 5
+)
+
+// The properties of MQTT 5 sent by the server
+const (
+	propAssignedClientIdentifier = 0x12
+	propMaximumQoS               = 0x24
+	propRetainAvailable          = 0x25
+	propWildcardSubAvailable     = 0x28
+	propSubscriptionIDsAvailable = 0x29
+	propSharedSubAvailable       = 0x2A
+)
+
+// The return codes of MQTT 3.1.1 and the reason codes of MQTT 5
+const (
+	codeSuccess                 = 0x00
+	codeRefusedVersion311       = 0x01
+	codeRefusedIdentifier311    = 0x02
+	codeRefusedCredentials311   = 0x04
+	codeSubFailure311           = 0x80
+	codeMalformed               = 0x81
+	codeImplementationSpecific  = 0x83
+	codeBadCredentials          = 0x86
+	codeTopicFilterInvalid      = 0x8F
+	codeTopicNameInvalid        = 0x90
+	codePacketTooLarge          = 0x95
+	codeQoSNotSupported         = 0x9B
+	codeSharedSubNotSupported   = 0x9E
+	codeWildcardSubNotSupported = 0xA2
+)
+
+var ErrMalformed = errors.New("mqtt: malformed packet")
+
+// packet is a control packet: its type, the flags of the fixed header and
+// the bytes after the fixed header
+type packet struct {
+	kind  byte
+	flags byte
+	body  []byte
+}
+
+// readPacket reads a packet of at most max bytes
+func readPacket(r *bufio.Reader, max int) (packet, error) {
+	head, err := r.ReadByte()
+	if err != nil {
+		return packet{}, err
+	}
+	size, err := readVarint(r)
+	if err != nil {
+		return packet{}, err
+	}
+	if max > 0 && size > max {
+		return packet{}, ErrTooLarge
+	}
+	body := make([]byte, size)
+	if _, err = io.ReadFull(r, body); err != nil {
+		return packet{}, err
+	}
+	return packet{kind: head >> 4, flags: head & 0x0F, body: body}, nil
+}
+
+var ErrTooLarge = errors.New("mqtt: packet too large")
+
+// readVarint reads a variable byte integer
+func readVarint(r io.ByteReader) (int, error) {
+	v, shift := 0, 0
+	for i := 0; i < 4; i++ {
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		v |= int(b&0x7F) << shift
+		if b&0x80 == 0 {
+			return v, nil
+		}
+		shift += 7
+	}
+	return 0, ErrMalformed
+}
+
+func appendVarint(b []byte, v int) []byte {
+	for {
+		c := byte(v & 0x7F)
+		v >>= 7
+		if v > 0 {
+			c |= 0x80
+		}
+		b = append(b, c)
+		if v == 0 {
+			return b
+		}
+	}
+}
+
+// encode returns the packet with its fixed header
+func (p packet) encode() []byte {
+	b := appendVarint([]byte{p.kind<<4 | p.flags}, len(p.body))
+	return append(b, p.body...)
+}
+
+func appendString(b []byte, s string) []byte {
+	b = binary.BigEndian.AppendUint16(b, uint16(len(s)))
+	return append(b, s...)
+}
+
+// decoder reads the fields of a packet, the first error is kept
+type decoder struct {
+	b   []byte
+	err error
+}
+
+func (d *decoder) take(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if n < 0 || n > len(d.b) {
+		d.err = ErrMalformed
+		return nil
+	}
+	v := d.b[:n]
+	d.b = d.b[n:]
+	return v
+}
+
+func (d *decoder) byte() byte {
+	if v := d.take(1); v != nil {
+		return v[0]
+	}
+	return 0
+}
+
+func (d *decoder) uint16() uint16 {
+	if v := d.take(2); v != nil {
+		return binary.BigEndian.Uint16(v)
+	}
+	return 0
+}
+
+func (d *decoder) binary() []byte {
+	return d.take(int(d.uint16()))
+}
+
+func (d *decoder) string() string {
+	return string(d.binary())
+}
+
+func (d *decoder) varint() int {
+	if d.err != nil {
+		return 0
+	}
+	v, shift := 0, 0
+	for i := 0; i < 4 && len(d.b) > 0; i++ {
+		c := d.b[0]
+		d.b = d.b[1:]
+		v |= int(c&0x7F) << shift
+		if c&0x80 == 0 {
+			return v
+		}
+		shift += 7
+	}
+	d.err = ErrMalformed
+	return 0
+}
+
+// properties skips the properties of an MQTT 5 packet
+func (d *decoder) properties(version byte) {
+	if version == Version5 {
+		d.take(d.varint())
+	}
+}
+
+// connect is a CONNECT packet
+type connect struct {
+	version    byte
+	clean      bool
+	keepAlive  uint16
+	clientID   string
+	willTopic  string
+	willQoS    byte
+	will       []byte
+	hasWill    bool
+	username   string
+	password   []byte
+	protocolOK bool
+}
+
+func decodeConnect(body []byte) (connect, error) {
+	d := &decoder{b: body}
+	var c connect
+	name := d.string()
+	c.version = d.byte()
+	flags := d.byte()
+	c.keepAlive = d.uint16()
+	if d.err != nil {
+		return c, d.err
+	}
+	c.protocolOK = name == "MQTT" && (c.version == Version311 || c.version == Version5)
+	if !c.protocolOK {
+		return c, nil
+	}
+	if flags&0x01 != 0 {
+		return c, ErrMalformed
+	}
+	d.properties(c.version)
+	c.clean = flags&0x02 != 0
+	c.clientID = d.string()
+	if flags&0x04 != 0 {
+		c.hasWill = true
+		d.properties(c.version)
+		c.willTopic = d.string()
+		c.will = append([]byte(nil), d.binary()...)
+		c.willQoS = flags >> 3 & 0x03
+	}
+	if flags&0x80 != 0 {
+		c.username = d.string()
+	}
+	if flags&0x40 != 0 {
+		c.password = d.binary()
+	}
+	return c, d.err
+}
+
+// publish is a PUBLISH packet
+type publish struct {
+	dup     bool
+	qos     byte
+	retain  bool
+	topic   string
+	id      uint16
+	payload []byte
+}
+
+func decodePublish(p packet, version byte) (publish, error) {
+	d := &decoder{b: p.body}
+	pub := publish{
+		dup:    p.flags&0x08 != 0,
+		qos:    p.flags >> 1 & 0x03,
+		retain: p.flags&0x01 != 0,
+	}
+	pub.topic = d.string()
+	if pub.qos > 0 {
+		pub.id = d.uint16()
+	}
+	d.properties(version)
+	pub.payload = d.b
+	return pub, d.err
+}
+
+func encodePublish(pub publish, version byte) []byte {
+	var flags byte
+	if pub.dup {
+		flags |= 0x08
+	}
+	flags |= pub.qos << 1
+	body := appendString(nil, pub.topic)
+	if pub.qos > 0 {
+		body = binary.BigEndian.AppendUint16(body, pub.id)
+	}
+	if version == Version5 {
+		body = append(body, 0)
+	}
+	body = append(body, pub.payload...)
+	return packet{kind: PUBLISH, flags: flags, body: body}.encode()
+}
+
+// subscription is a topic filter of a SUBSCRIBE packet
+type subscription struct {
+	filter string
+	qos    byte
+}
+
+func decodeSubscribe(body []byte, version byte) (uint16, []subscription, error) {
+	d := &decoder{b: body}
+	id := d.uint16()
+	d.properties(version)
+	var subs []subscription
+	for d.err == nil && len(d.b) > 0 {
+		filter := d.string()
+		options := d.byte()
+		subs = append(subs, subscription{filter: filter, qos: options & 0x03})
+	}
+	if d.err == nil && len(subs) == 0 {
+		d.err = ErrMalformed
+	}
+	return id, subs, d.err
+}
+
+func decodeUnsubscribe(body []byte, version byte) (uint16, []string, error) {
+	d := &decoder{b: body}
+	id := d.uint16()
+	d.properties(version)
+	var filters []string
+	for d.err == nil && len(d.b) > 0 {
+		filters = append(filters, d.string())
+	}
+	if d.err == nil && len(filters) == 0 {
+		d.err = ErrMalformed
+	}
+	return id, filters, d.err
+}
+
+// encodeAck encodes a packet made of a packet id, the properties of MQTT 5
+// and the reason codes
+func encodeAck(kind byte, id uint16, version byte, codes []byte) []byte {
+	body := binary.BigEndian.AppendUint16(nil, id)
+	if version == Version5 {
+		body = append(body, 0)
+	}
+	body = append(body, codes...)
+	return packet{kind: kind, body: body}.encode()
+}
@@ -0,0 +1,499 @@
+/*
+ *
+ *  * Licensed to the Apache Software Foundation (ASF) under one or more
+ *  * contributor license agreements.  See the NOTICE file distributed with
+ *  * this work for additional information regarding copyright ownership.
+ *  * The ASF licenses this file to You under the Apache License, Version 2.0
+ *  * (the "License"); you may not use this file except in compliance with
+ *  * the License.  You may obtain a copy of the License at
+ *  *
+ *  *     http://www.apache.org/licenses/LICENSE-2.0
+ *  *
+ *  * Unless required by applicable law or agreed to in writing, software
+ *  * distributed under the License is distributed on an "AS IS" BASIS,
+ *  * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  * See the License for the specific language governing permissions and
+ *  * limitations under the License.
+ *
+ */
+
+// Package mqtt is an MQTT 3.1.1 and 5 server whose messages are published
+// and subscribed through a Handler. The server supports the QoS 0 and 1 and
+// the topic filters without wildcard, it does not retain messages.
+package mqtt
+
+import (
+	"bufio"
+	"context"
+	"crypto/subtle"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Handler publishes and subscribes the messages of the sessions
+type Handler interface {
+	// Publish publishes a message of a session, a QoS 1 message is
+	// acknowledged once Publish returns nil
+	Publish(s *Session, topic string, payload []byte) error
+	// Subscribe subscribes a session to a topic and returns the QoS granted.
+	// The messages are written with Session.Deliver.
+	Subscribe(s *Session, topic string, qos byte) (byte, error)
+	Unsubscribe(s *Session, topic string)
+	// Ack acknowledges a message delivered with a non zero id
+	Ack(s *Session, topic string, id uint64)
+	// Disconnect ends the subscriptions of a session
+	Disconnect(s *Session)
+}
+
+// Options are the options of a server
+type Options struct {
+	// The credentials of the clients, not checked when User is empty
+	User     string
+	Password string
+	// The delay after which a QoS 1 message not acknowledged is sent again
+	RetryInterval time.Duration
+	// The maximum size of a packet
+	MaxPacketSize int
+}
+
+// Server accepts the MQTT connections
+type Server struct {
+	handler Handler
+	opts    Options
+
+	mu       sync.Mutex
+	sessions map[string]*Session
+}
+
+// NewServer creates a server of the handler
+func NewServer(handler Handler, opts Options) *Server {
+	if opts.RetryInterval <= 0 {
+		opts.RetryInterval = 20 * time.Second
+	}
+	if opts.MaxPacketSize <= 0 {
+		opts.MaxPacketSize = 1 << 20
+	}
+	return &Server{handler: handler, opts: opts, sessions: make(map[string]*Session)}
+}
+
+// Serve accepts the connections of the listener until ctx is done
+func (srv *Server) Serve(ctx context.Context, ln net.Listener) error {
+	go func() {
+		<-ctx.Done()
+		_ = ln.Close()
+	}()
+	for {
+		c, err := ln.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				continue
+			}
+			return err
+		}
+		go srv.serveConn(c)
+	}
+}
+
+// Session is the connection of a client
+type Session struct {
+	ClientID string
+	// Tells the client asked for a clean session
+	Clean   bool
+	Version byte
+
+	srv  *Server
+	conn net.Conn
+
+	wmu sync.Mutex
+	w   *bufio.Writer
+
+	mu       sync.Mutex
+	nextID   uint16
+	inflight map[uint16]*inflight
+	byID     map[handlerID]uint16
+	closed   bool
+	done     chan struct{}
+}
+
+// handlerID is the id of a message given by the handler, unique per topic
+type handlerID struct {
+	topic string
+	id    uint64
+}
+
+// inflight is a QoS 1 message waiting for its PUBACK
+type inflight struct {
+	pub publish
+	// The id of the handler, which redelivers the message itself when not 0
+	id   uint64
+	sent time.Time
+}
+
+func (s *Session) write(b []byte) error {
+	s.wmu.Lock()
+	defer s.wmu.Unlock()
+	if _, err := s.w.Write(b); err != nil {
+		return err
+	}
+	return s.w.Flush()
+}
+
+// Deliver writes a message to the client. A QoS 1 message delivered with a
+// non zero id, unique in its topic, is acknowledged to the handler, which delivers it again with
+// the same id until then. A QoS 1 message with a zero id is sent again by
+// the session until it is acknowledged.
+func (s *Session) Deliver(topic string, payload []byte, qos byte, id uint64) error {
+	pub := publish{topic: topic, qos: qos, payload: payload}
+	if qos > 0 {
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			return net.ErrClosed
+		}
+		key := handlerID{topic, id}
+		if pid, ok := s.byID[key]; ok && id != 0 {
+			pub.id = pid
+			pub.dup = true
+			s.inflight[pid].sent = time.Now()
+		} else {
+			pid, err := s.allocate()
+			if err != nil {
+				s.mu.Unlock()
+				return err
+			}
+			pub.id = pid
+			s.inflight[pid] = &inflight{pub: pub, id: id, sent: time.Now()}
+			if id != 0 {
+				s.byID[key] = pid
+			}
+		}
+		s.mu.Unlock()
+	}
+	return s.write(encodePublish(pub, s.Version))
+}
+
+var ErrInflightFull = errors.New("mqtt: too many messages in flight")
+
+// allocate returns a free packet id, the lock must be held
+func (s *Session) allocate() (uint16, error) {
+	for i := 0; i < 1<<16; i++ {
+		s.nextID++
+		if s.nextID == 0 {
+			s.nextID = 1
+		}
+		if _, ok := s.inflight[s.nextID]; !ok {
+			return s.nextID, nil
+		}
+	}
+	return 0, ErrInflightFull
+}
+
+// Done is closed when the session ends
+func (s *Session) Done() <-chan struct{} {
+	return s.done
+}
+
+func (srv *Server) serveConn(c net.Conn) {
+	defer c.Close()
+	r := bufio.NewReader(c)
+	_ = c.SetReadDeadline(time.Now().Add(10 * time.Second))
+	p, err := readPacket(r, srv.opts.MaxPacketSize)
+	if err != nil || p.kind != CONNECT {
+		return
+	}
+	conn, err := decodeConnect(p.body)
+	if err != nil {
+		return
+	}
+	s := &Session{
+		ClientID: conn.clientID,
+		Clean:    conn.clean,
+		Version:  conn.version,
+		srv:      srv,
+		conn:     c,
+		w:        bufio.NewWriter(c),
+		inflight: make(map[uint16]*inflight),
+		byID:     make(map[handlerID]uint16),
+		done:     make(chan struct{}),
+	}
+	if !conn.protocolOK {
+		s.Version = Version311
+		_ = s.write(encodeConnack(Version311, false, codeRefusedVersion311, ""))
+		return
+	}
+	if code := srv.authenticate(conn); code != codeSuccess {
+		_ = s.write(encodeConnack(s.Version, false, code, ""))
+		return
+	}
+	assigned := ""
+	if s.ClientID == "" {
+		if !s.Clean && s.Version == Version311 {
+			_ = s.write(encodeConnack(s.Version, false, codeRefusedIdentifier311, ""))
+			return
+		}
+		s.ClientID = "icefiredb-" + strings.ReplaceAll(c.RemoteAddr().String(), ":", "-")
+		assigned = s.ClientID
+	}
+
+	// a client connecting again takes over its session
+	srv.mu.Lock()
+	old := srv.sessions[s.ClientID]
+	srv.sessions[s.ClientID] = s
+	srv.mu.Unlock()
+	if old != nil {
+		_ = old.conn.Close()
+		<-old.done
+	}
+	if err = s.write(encodeConnack(s.Version, false, codeSuccess, assigned)); err != nil {
+		srv.end(s)
+		return
+	}
+
+	go s.retryLoop()
+	err = s.readLoop(r, conn.keepAlive)
+	if err != nil && conn.hasWill {
+		// the will is published when the client did not disconnect
+		if perr := srv.handler.Publish(s, conn.willTopic, conn.will); perr != nil {
+			logrus.Errorf("mqtt will of %s err: %v", s.ClientID, perr)
+		}
+	}
+	srv.end(s)
+}
+
+func (srv *Server) authenticate(c connect) byte {
+	if srv.opts.User == "" {
+		return codeSuccess
+	}
+	if c.username == srv.opts.User && subtle.ConstantTimeCompare(c.password, []byte(srv.opts.Password)) == 1 {
+		return codeSuccess
+	}
+	if c.version == Version5 {
+		return codeBadCredentials
+	}
+	return codeRefusedCredentials311
+}
+
+func (srv *Server) end(s *Session) {
+	s.mu.Lock()
+	s.closed = true
+	s.mu.Unlock()
+	srv.handler.Disconnect(s)
+	srv.mu.Lock()
+	if srv.sessions[s.ClientID] == s {
+		delete(srv.sessions, s.ClientID)
+	}
+	srv.mu.Unlock()
+	close(s.done)
+}
+
+// readLoop handles the packets of the client, it returns nil after a
+// DISCONNECT
+func (s *Session) readLoop(r *bufio.Reader, keepAlive uint16) error {
+	for {
+		if keepAlive > 0 {
+			_ = s.conn.SetReadDeadline(time.Now().Add(time.Duration(keepAlive) * 1500 * time.Millisecond))
+		} else {
+			_ = s.conn.SetReadDeadline(time.Time{})
+		}
+		p, err := readPacket(r, s.srv.opts.MaxPacketSize)
+		if err != nil {
+			if errors.Is(err, ErrTooLarge) {
+				s.disconnect(codePacketTooLarge)
+			}
+			return err
+		}
+		if err = s.handle(p); err != nil {
+			if errors.Is(err, errDisconnect) {
+				return nil
+			}
+			if errors.Is(err, ErrMalformed) {
+				s.disconnect(codeMalformed)
+			}
+			return err
+		}
+	}
+}
+
+var errDisconnect = errors.New("mqtt: disconnect")
+
+// errQoS2 is the error of a QoS 2 publish, which is not supported
+var errQoS2 = errors.New("mqtt: QoS 2 not supported")
+
+func (s *Session) handle(p packet) error {
+	h := s.srv.handler
+	switch p.kind {
+	case PUBLISH:
+		pub, err := decodePublish(p, s.Version)
+		if err != nil {
+			return err
+		}
+		if pub.qos > 1 {
+			s.disconnect(codeQoSNotSupported)
+			return errQoS2
+		}
+		if pub.topic == "" || strings.ContainsAny(pub.topic, "+#") {
+			s.disconnect(codeTopicNameInvalid)
+			return ErrMalformed
+		}
+		if err = h.Publish(s, pub.topic, pub.payload); err != nil {
+			logrus.Errorf("mqtt publish of %s to %s err: %v", s.ClientID, pub.topic, err)
+			// a QoS 1 message not acknowledged is sent again by the client
+			return nil
+		}
+		if pub.qos == 1 {
+			return s.write(encodeAck(PUBACK, pub.id, Version311, nil))
+		}
+	case PUBACK:
+		d := &decoder{b: p.body}
+		pid := d.uint16()
+		if d.err != nil {
+			return d.err
+		}
+		s.mu.Lock()
+		m, ok := s.inflight[pid]
+		if ok {
+			delete(s.inflight, pid)
+			if m.id != 0 {
+				delete(s.byID, handlerID{m.pub.topic, m.id})
+			}
+		}
+		s.mu.Unlock()
+		if ok && m.id != 0 {
+			h.Ack(s, m.pub.topic, m.id)
+		}
+	case SUBSCRIBE:
+		id, subs, err := decodeSubscribe(p.body, s.Version)
+		if err != nil {
+			return err
+		}
+		codes := make([]byte, len(subs))
+		for i, sub := range subs {
+			codes[i] = s.subscribe(sub)
+		}
+		return s.write(encodeAck(SUBACK, id, s.Version, codes))
+	case UNSUBSCRIBE:
+		id, filters, err := decodeUnsubscribe(p.body, s.Version)
+		if err != nil {
+			return err
+		}
+		var codes []byte
+		for _, filter := range filters {
+			h.Unsubscribe(s, filter)
+			if s.Version == Version5 {
+				codes = append(codes, codeSuccess)
+			}
+		}
+		return s.write(encodeAck(UNSUBACK, id, s.Version, codes))
+	case PINGREQ:
+		return s.write(packet{kind: PINGRESP}.encode())
+	case DISCONNECT:
+		return errDisconnect
+	default:
+		return ErrMalformed
+	}
+	return nil
+}
+
+// subscribe subscribes a topic filter and returns the code of the SUBACK
+func (s *Session) subscribe(sub subscription) byte {
+	failure := byte(codeSubFailure311)
+	switch {
+	case strings.HasPrefix(sub.filter, "$share/"):
+		if s.Version == Version5 {
+			failure = codeSharedSubNotSupported
+		}
+		return failure
+	case strings.ContainsAny(sub.filter, "+#"):
+		if s.Version == Version5 {
+			failure = codeWildcardSubNotSupported
+		}
+		return failure
+	case sub.filter == "":
+		if s.Version == Version5 {
+			failure = codeTopicFilterInvalid
+		}
+		return failure
+	}
+	qos := sub.qos
+	if qos > 1 {
+		qos = 1
+	}
+	granted, err := s.srv.handler.Subscribe(s, sub.filter, qos)
+	if err != nil {
+		logrus.Errorf("mqtt subscribe of %s to %s err: %v", s.ClientID, sub.filter, err)
+		if s.Version == Version5 {
+			failure = codeImplementationSpecific
+		}
+		return failure
+	}
+	return granted
+}
+
+// disconnect sends a DISCONNECT with the reason code to an MQTT 5 client
+func (s *Session) disconnect(code byte) {
+	if s.Version == Version5 {
+		_ = s.write(packet{kind: DISCONNECT, body: []byte{code, 0}}.encode())
+	}
+}
+
+// retryLoop sends again the QoS 1 messages of the session not acknowledged
+func (s *Session) retryLoop() {
+	ticker := time.NewTicker(s.srv.opts.RetryInterval / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.done:
+			return
+		case now := <-ticker.C:
+			var resend []publish
+			s.mu.Lock()
+			for _, m := range s.inflight {
+				if m.id == 0 && now.Sub(m.sent) >= s.srv.opts.RetryInterval {
+					m.sent = now
+					m.pub.dup = true
+					resend = append(resend, m.pub)
+				}
+			}
+			s.mu.Unlock()
+			for _, pub := range resend {
+				if err := s.write(encodePublish(pub, s.Version)); err != nil {
+					_ = s.conn.Close()
+					return
+				}
+			}
+		}
+	}
+}
+
+// encodeConnack encodes a CONNACK, which tells an MQTT 5 client the
+// features the server does not support
+func encodeConnack(version byte, sessionPresent bool, code byte, assigned string) []byte {
+	var flags byte
+	if sessionPresent {
+		flags = 1
+	}
+	body := []byte{flags, code}
+	if version == Version5 {
+		props := []byte{
+			propMaximumQoS, 1,
+			propRetainAvailable, 0,
+			propWildcardSubAvailable, 0,
+			propSharedSubAvailable, 0,
+			propSubscriptionIDsAvailable, 0,
+		}
+		if assigned != "" {
+			props = appendString(append(props, propAssignedClientIdentifier), assigned)
+		}
+		body = append(appendVarint(body, len(props)), props...)
+	}
+	return packet{kind: CONNACK, body: body}.encode()
+}
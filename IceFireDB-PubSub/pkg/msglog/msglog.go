@@ -0,0 +1,245 @@
+/*
+ *
+ *  * Licensed to the Apache Software Foundation (ASF) under one or more
+ *  * contributor license agreements.  See the NOTICE file distributed with
+ *  * this work for additional information regarding copyright ownership.
+ *  * The ASF licenses this file to You under the Apache License, Version 2.0
+ *  * (the "License"); you may not use this file except in compliance with
+ *  * the License.  You may obtain a copy of the License at
+ *  *
+ *  *     http://www.apache.org/licenses/LICENSE-2.0
+ *  *
+ *  * Unless required by applicable law or agreed to in writing, software
+ *  * distributed under the License is distributed on an "AS IS" BASIS,
+ *  * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  * See the License for the specific language governing permissions and
+ *  * limitations under the License.
+ *
+ */
+
+// Package msglog stores the messages of the topics in leveldb, each topic
+// being a log addressed by offset
+package msglog
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+var ErrClosed = errors.New("message log closed")
+
+// Message is a message stored in a topic log
+type Message struct {
+	// The position of the message in its topic, from 1
+	Offset uint64 `json:"-"`
+	// The time the message was received, in unix milliseconds
+	Time     int64  `json:"time"`
+	SenderID string `json:"senderid"`
+	Message  string `json:"message"`
+}
+
+// The key prefixes of the messages and of the consumer cursors
+const (
+	messagePrefix = 'm'
+	cursorPrefix  = 'c'
+)
+
+// Log is the log of the messages of every topic. The keys of the messages
+// are the prefix, the topic, a zero byte and the big endian offset, so a
+// topic is read in order.
+type Log struct {
+	mu   sync.Mutex
+	db   *leveldb.DB
+	last map[string]uint64
+}
+
+// Open opens the log stored in the directory path
+func Open(path string) (*Log, error) {
+	db, err := leveldb.OpenFile(path, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &Log{db: db, last: make(map[string]uint64)}, nil
+}
+
+func key(topic string, offset uint64) []byte {
+	k := make([]byte, len(topic)+10)
+	k[0] = messagePrefix
+	copy(k[1:], topic)
+	binary.BigEndian.PutUint64(k[len(topic)+2:], offset)
+	return k
+}
+
+func keyOffset(k []byte) uint64 {
+	return binary.BigEndian.Uint64(k[len(k)-8:])
+}
+
+func cursorKey(topic string, consumer string) []byte {
+	return []byte(string(cursorPrefix) + topic + "\x00" + consumer)
+}
+
+func topicRange(topic string) *util.Range {
+	return &util.Range{Start: key(topic, 0), Limit: key(topic, ^uint64(0))}
+}
+
+// lastOffset returns the offset of the last message of the topic, read from
+// the store the first time. The lock must be held.
+func (l *Log) lastOffset(topic string) (uint64, error) {
+	if last, ok := l.last[topic]; ok {
+		return last, nil
+	}
+	if l.db == nil {
+		return 0, ErrClosed
+	}
+	it := l.db.NewIterator(topicRange(topic), nil)
+	defer it.Release()
+	var last uint64
+	if it.Last() {
+		last = keyOffset(it.Key())
+	}
+	if err := it.Error(); err != nil {
+		return 0, err
+	}
+	l.last[topic] = last
+	return last, nil
+}
+
+// Append stores a message at the end of the topic and returns it with its
+// offset
+func (l *Log) Append(topic string, m Message) (Message, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	last, err := l.lastOffset(topic)
+	if err != nil {
+		return m, err
+	}
+	if m.Time == 0 {
+		m.Time = time.Now().UnixMilli()
+	}
+	m.Offset = last + 1
+	value, err := json.Marshal(m)
+	if err != nil {
+		return m, err
+	}
+	if err = l.db.Put(key(topic, m.Offset), value, nil); err != nil {
+		return m, err
+	}
+	l.last[topic] = m.Offset
+	return m, nil
+}
+
+// Last returns the offset of the last message of the topic, 0 when it is
+// empty
+func (l *Log) Last(topic string) (uint64, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.lastOffset(topic)
+}
+
+// Range calls fn with the messages of the topic from the offset, then from
+// the time in unix milliseconds when since is not 0, up to the offset to.
+// It stops at the first error of fn.
+func (l *Log) Range(topic string, from uint64, since int64, to uint64, fn func(Message) error) error {
+	l.mu.Lock()
+	db := l.db
+	l.mu.Unlock()
+	if db == nil {
+		return ErrClosed
+	}
+	it := db.NewIterator(&util.Range{Start: key(topic, from), Limit: key(topic, to+1)}, nil)
+	defer it.Release()
+	for it.Next() {
+		var m Message
+		if err := json.Unmarshal(it.Value(), &m); err != nil {
+			return err
+		}
+		m.Offset = keyOffset(it.Key())
+		if m.Time < since {
+			continue
+		}
+		if err := fn(m); err != nil {
+			return err
+		}
+	}
+	return it.Error()
+}
+
+// Trim deletes the messages of every topic received before the time in unix
+// milliseconds. The last message of a topic is kept so its offset survives
+// a restart.
+func (l *Log) Trim(before int64) error {
+	l.mu.Lock()
+	db := l.db
+	l.mu.Unlock()
+	if db == nil {
+		return ErrClosed
+	}
+	it := db.NewIterator(util.BytesPrefix([]byte{messagePrefix}), nil)
+	defer it.Release()
+	batch := new(leveldb.Batch)
+	for ok := it.Next(); ok; {
+		k := append([]byte(nil), it.Key()...)
+		var m Message
+		if err := json.Unmarshal(it.Value(), &m); err != nil {
+			return err
+		}
+		ok = it.Next()
+		sameTopic := ok && len(it.Key()) == len(k) && string(it.Key()[:len(k)-8]) == string(k[:len(k)-8])
+		if m.Time < before && sameTopic {
+			batch.Delete(k)
+		}
+	}
+	if err := it.Error(); err != nil {
+		return err
+	}
+	return db.Write(batch, nil)
+}
+
+// Commit records the offset up to which a consumer of the topic
+// acknowledged the messages
+func (l *Log) Commit(topic string, consumer string, offset uint64) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.db == nil {
+		return ErrClosed
+	}
+	value := make([]byte, 8)
+	binary.BigEndian.PutUint64(value, offset)
+	return l.db.Put(cursorKey(topic, consumer), value, nil)
+}
+
+// Committed returns the offset up to which a consumer of the topic
+// acknowledged the messages, 0 for a new consumer
+func (l *Log) Committed(topic string, consumer string) (uint64, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.db == nil {
+		return 0, ErrClosed
+	}
+	value, err := l.db.Get(cursorKey(topic, consumer), nil)
+	if errors.Is(err, leveldb.ErrNotFound) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint64(value), nil
+}
+
+// Close closes the store
+func (l *Log) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.db == nil {
+		return nil
+	}
+	err := l.db.Close()
+	l.db = nil
+	return err
+}
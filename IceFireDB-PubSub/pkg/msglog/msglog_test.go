@@ -0,0 +1,142 @@
+package msglog
+
+import (
+	"testing"
+	"time"
+)
+
+func collect(t *testing.T, l *Log, topic string, from uint64, since int64) []Message {
+	t.Helper()
+	last, err := l.Last(topic)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var msgs []Message
+	err = l.Range(topic, from, since, last, func(m Message) error {
+		msgs = append(msgs, m)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return msgs
+}
+
+func TestLog(t *testing.T) {
+	dir := t.TempDir()
+	l, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, v := range []string{"a", "b", "c"} {
+		m, err := l.Append("news", Message{Time: int64(100 * (i + 1)), Message: v})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if m.Offset != uint64(i+1) {
+			t.Fatalf("offset %d, want %d", m.Offset, i+1)
+		}
+	}
+	if _, err = l.Append("new", Message{Message: "other"}); err != nil {
+		t.Fatal(err)
+	}
+
+	msgs := collect(t, l, "news", 2, 0)
+	if len(msgs) != 2 || msgs[0].Message != "b" || msgs[1].Offset != 3 {
+		t.Fatalf("from offset: %+v", msgs)
+	}
+	msgs = collect(t, l, "news", 0, 300)
+	if len(msgs) != 1 || msgs[0].Message != "c" {
+		t.Fatalf("since time: %+v", msgs)
+	}
+
+	// the offsets go on after a restart
+	if err = l.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if l, err = Open(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	m, err := l.Append("news", Message{Time: 400, Message: "d"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Offset != 4 {
+		t.Fatalf("offset after reopen %d, want 4", m.Offset)
+	}
+
+	if err = l.Trim(1000); err != nil {
+		t.Fatal(err)
+	}
+	msgs = collect(t, l, "news", 0, 0)
+	if len(msgs) != 1 || msgs[0].Offset != 4 {
+		t.Fatalf("trim: %+v", msgs)
+	}
+	if msgs = collect(t, l, "new", 0, 0); len(msgs) != 1 {
+		t.Fatalf("trim of the other topic: %+v", msgs)
+	}
+}
+
+func TestCommitted(t *testing.T) {
+	l, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	if _, err = l.Append("news", Message{Time: 1, Message: "a"}); err != nil {
+		t.Fatal(err)
+	}
+	if offset, err := l.Committed("news", "worker"); err != nil || offset != 0 {
+		t.Fatalf("new consumer: %d %v", offset, err)
+	}
+	if err = l.Commit("news", "worker", 1); err != nil {
+		t.Fatal(err)
+	}
+	if offset, err := l.Committed("news", "worker"); err != nil || offset != 1 {
+		t.Fatalf("committed: %d %v", offset, err)
+	}
+	// the cursors are not messages
+	if err = l.Trim(1000); err != nil {
+		t.Fatal(err)
+	}
+	if msgs := collect(t, l, "news", 0, 0); len(msgs) != 1 {
+		t.Fatalf("messages: %+v", msgs)
+	}
+}
+
+func TestPending(t *testing.T) {
+	now := time.Unix(1000, 0)
+	p := NewPending(2, time.Second, 2)
+	for offset := uint64(3); offset <= 5; offset++ {
+		p.Deliver(offset, now)
+	}
+	if !p.Ack(4) || p.Ack(4) {
+		t.Fatal("ack of a pending message")
+	}
+	if cursor := p.Cursor(); cursor != 2 {
+		t.Fatalf("cursor %d, want 2", cursor)
+	}
+	p.Ack(3)
+	if cursor := p.Cursor(); cursor != 4 {
+		t.Fatalf("cursor %d, want 4", cursor)
+	}
+
+	if redeliver, dead := p.Expired(now); len(redeliver) != 0 || len(dead) != 0 {
+		t.Fatalf("expired before the deadline: %v %v", redeliver, dead)
+	}
+	now = now.Add(time.Second)
+	redeliver, dead := p.Expired(now)
+	if len(redeliver) != 1 || redeliver[0] != 5 || len(dead) != 0 {
+		t.Fatalf("first deadline: %v %v", redeliver, dead)
+	}
+	p.Deliver(5, now)
+	now = now.Add(time.Second)
+	redeliver, dead = p.Expired(now)
+	if len(redeliver) != 0 || len(dead) != 1 || dead[0] != 5 {
+		t.Fatalf("last deadline: %v %v", redeliver, dead)
+	}
+	if cursor := p.Cursor(); cursor != 5 || p.Len() != 0 {
+		t.Fatalf("cursor %d after the dead letter", cursor)
+	}
+}
@@ -0,0 +1,115 @@
+/*
+ *
+ *  * Licensed to the Apache Software Foundation (ASF) under one or more
+ *  * contributor license agreements.  See the NOTICE file distributed with
+ *  * this work for additional information regarding copyright ownership.
+ *  * The ASF licenses this file to You under the Apache License, Version 2.0
+ *  * (the "License"); you may not use this file except in compliance with
+ *  * the License.  You may obtain a copy of the License at
+ *  *
+ *  *     http://www.apache.org/licenses/LICENSE-2.0
+ *  *
+ *  * Unless required by applicable law or agreed to in writing, software
+ *  * distributed under the License is distributed on an "AS IS" BASIS,
+ *  * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  * See the License for the specific language governing permissions and
+ *  * limitations under the License.
+ *
+ */
+
+package msglog
+
+import (
+	"sort"
+	"time"
+)
+
+// Pending follows the messages delivered to a consumer and not acknowledged
+// yet. The consumer receives every message after its cursor, so the offsets
+// between the cursor and the last delivered one are either pending or
+// acknowledged.
+type Pending struct {
+	timeout       time.Duration
+	maxDeliveries int
+	cursor        uint64
+	last          uint64
+	msgs          map[uint64]*delivery
+}
+
+type delivery struct {
+	deadline time.Time
+	count    int
+}
+
+// NewPending follows the deliveries after the cursor. A message not
+// acknowledged within timeout is redelivered, up to maxDeliveries times.
+func NewPending(cursor uint64, timeout time.Duration, maxDeliveries int) *Pending {
+	return &Pending{
+		timeout:       timeout,
+		maxDeliveries: maxDeliveries,
+		cursor:        cursor,
+		last:          cursor,
+		msgs:          make(map[uint64]*delivery),
+	}
+}
+
+// Deliver records the delivery of a message
+func (p *Pending) Deliver(offset uint64, now time.Time) {
+	if offset <= p.cursor {
+		return
+	}
+	d, ok := p.msgs[offset]
+	if !ok {
+		d = &delivery{}
+		p.msgs[offset] = d
+	}
+	d.count++
+	d.deadline = now.Add(p.timeout)
+	if offset > p.last {
+		p.last = offset
+	}
+}
+
+// Ack acknowledges a message, false when it is not pending
+func (p *Pending) Ack(offset uint64) bool {
+	if _, ok := p.msgs[offset]; !ok {
+		return false
+	}
+	delete(p.msgs, offset)
+	return true
+}
+
+// Cursor returns the offset up to which every message is acknowledged
+func (p *Pending) Cursor() uint64 {
+	p.cursor = p.last
+	for offset := range p.msgs {
+		if offset <= p.cursor {
+			p.cursor = offset - 1
+		}
+	}
+	return p.cursor
+}
+
+// Expired returns in order the messages past their deadline to redeliver,
+// and the ones delivered maxDeliveries times, which are no longer pending
+func (p *Pending) Expired(now time.Time) (redeliver []uint64, dead []uint64) {
+	for offset, d := range p.msgs {
+		if now.Before(d.deadline) {
+			continue
+		}
+		if p.maxDeliveries > 0 && d.count >= p.maxDeliveries {
+			dead = append(dead, offset)
+			delete(p.msgs, offset)
+			continue
+		}
+		redeliver = append(redeliver, offset)
+	}
+	sort.Slice(redeliver, func(i, j int) bool { return redeliver[i] < redeliver[j] })
+	sort.Slice(dead, func(i, j int) bool { return dead[i] < dead[j] })
+	return redeliver, dead
+}
+
+// Len returns the number of messages pending
+func (p *Pending) Len() int {
+	return len(p.msgs)
+}
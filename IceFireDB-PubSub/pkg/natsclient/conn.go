@@ -0,0 +1,393 @@
+/*
+ *
+ *  * Licensed to the Apache Software Foundation (ASF) under one or more
+ *  * contributor license agreements.  See the NOTICE file distributed with
+ *  * this work for additional information regarding copyright ownership.
+ *  * The ASF licenses this file to You under the Apache License, Version 2.0
+ *  * (the "License"); you may not use this file except in compliance with
+ *  * the License.  You may obtain a copy of the License at
+ *  *
+ *  *     http://www.apache.org/licenses/LICENSE-2.0
+ *  *
+ *  * Unless required by applicable law or agreed to in writing, software
+ *  * distributed under the License is distributed on an "AS IS" BASIS,
+ *  * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  * See the License for the specific language governing permissions and
+ *  * limitations under the License.
+ *
+ */
+
+// Package natsclient is a client of the NATS protocol, with the requests of
+// the JetStream API to publish to a stream and consume it with a durable
+// consumer
+package natsclient
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	ErrClosed  = errors.New("nats: connection closed")
+	ErrTimeout = errors.New("nats: timeout")
+)
+
+// Options are the options of a connection
+type Options struct {
+	Name     string
+	User     string
+	Password string
+	Token    string
+	// Timeout of the connection and of the requests
+	Timeout time.Duration
+	// TLS upgrades the connection after the INFO of the server, required
+	// when the server requires TLS. The server name is the host of the
+	// address when empty.
+	TLS *tls.Config
+}
+
+// Msg is a message received by a subscription
+type Msg struct {
+	Subject string
+	Reply   string
+	Data    []byte
+}
+
+// Conn is a connection to a NATS server. The messages of the
+// subscriptions are handled by the reading goroutine, in order.
+type Conn struct {
+	opts Options
+	conn net.Conn
+
+	wmu sync.Mutex
+	w   *bufio.Writer
+
+	mu    sync.Mutex
+	subs  map[uint64]func(Msg)
+	pongs []chan error
+	err   error
+
+	sid   atomic.Uint64
+	inbox string
+	done  chan struct{}
+}
+
+type info struct {
+	AuthRequired bool `json:"auth_required"`
+	TLSRequired  bool `json:"tls_required"`
+	Headers      bool `json:"headers"`
+}
+
+type connect struct {
+	Verbose  bool   `json:"verbose"`
+	Pedantic bool   `json:"pedantic"`
+	Name     string `json:"name,omitempty"`
+	User     string `json:"user,omitempty"`
+	Pass     string `json:"pass,omitempty"`
+	Token    string `json:"auth_token,omitempty"`
+	TLS      bool   `json:"tls_required"`
+	Lang     string `json:"lang"`
+	Version  string `json:"version"`
+	Protocol int    `json:"protocol"`
+}
+
+// Dial connects to the server at addr and authenticates
+func Dial(addr string, opts Options) (*Conn, error) {
+	if opts.Timeout <= 0 {
+		opts.Timeout = 5 * time.Second
+	}
+	nc, err := net.DialTimeout("tcp", addr, opts.Timeout)
+	if err != nil {
+		return nil, err
+	}
+	r := bufio.NewReader(nc)
+	_ = nc.SetReadDeadline(time.Now().Add(opts.Timeout))
+	line, err := readLine(r)
+	if err != nil {
+		nc.Close()
+		return nil, err
+	}
+	if !strings.HasPrefix(line, "INFO ") {
+		nc.Close()
+		return nil, fmt.Errorf("nats: unexpected %q", line)
+	}
+	var si info
+	if err = json.Unmarshal([]byte(strings.TrimPrefix(line, "INFO ")), &si); err != nil {
+		nc.Close()
+		return nil, fmt.Errorf("nats: invalid INFO: %v", err)
+	}
+	if si.TLSRequired && opts.TLS == nil {
+		nc.Close()
+		return nil, errors.New("nats: the server requires TLS")
+	}
+	if opts.TLS != nil {
+		cfg := opts.TLS
+		if cfg.ServerName == "" {
+			cfg = cfg.Clone()
+			cfg.ServerName, _, _ = net.SplitHostPort(addr)
+		}
+		tc := tls.Client(nc, cfg)
+		_ = tc.SetDeadline(time.Now().Add(opts.Timeout))
+		if err = tc.Handshake(); err != nil {
+			nc.Close()
+			return nil, err
+		}
+		_ = tc.SetDeadline(time.Time{})
+		nc = tc
+		r = bufio.NewReader(nc)
+	}
+	_ = nc.SetReadDeadline(time.Time{})
+	c := &Conn{
+		opts: opts,
+		conn: nc,
+		w:    bufio.NewWriter(nc),
+		subs: make(map[uint64]func(Msg)),
+		done: make(chan struct{}),
+	}
+
+	payload, err := json.Marshal(connect{
+		Name:     opts.Name,
+		User:     opts.User,
+		Pass:     opts.Password,
+		Token:    opts.Token,
+		TLS:      opts.TLS != nil,
+		Lang:     "go",
+		Version:  "icefiredb",
+		Protocol: 1,
+	})
+	if err != nil {
+		nc.Close()
+		return nil, err
+	}
+	c.inbox = "_INBOX." + strconv.FormatInt(time.Now().UnixNano(), 36)
+	go c.readLoop(r)
+	if err = c.send("CONNECT " + string(payload) + "\r\n"); err != nil {
+		c.Close()
+		return nil, err
+	}
+	// the server answers the PING after the CONNECT, or refuses it
+	if err = c.Flush(); err != nil {
+		c.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+func readLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+func (c *Conn) send(s string, payload ...[]byte) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if _, err := c.w.WriteString(s); err != nil {
+		return err
+	}
+	for _, p := range payload {
+		if _, err := c.w.Write(p); err != nil {
+			return err
+		}
+		if _, err := c.w.WriteString("\r\n"); err != nil {
+			return err
+		}
+	}
+	return c.w.Flush()
+}
+
+// Flush waits for the server to process the messages sent before
+func (c *Conn) Flush() error {
+	ch := make(chan error, 1)
+	c.mu.Lock()
+	if c.err != nil {
+		c.mu.Unlock()
+		return c.err
+	}
+	c.pongs = append(c.pongs, ch)
+	c.mu.Unlock()
+	if err := c.send("PING\r\n"); err != nil {
+		return err
+	}
+	select {
+	case err := <-ch:
+		return err
+	case <-time.After(c.opts.Timeout):
+		return ErrTimeout
+	}
+}
+
+// readLoop reads the messages of the server until the connection fails
+func (c *Conn) readLoop(r *bufio.Reader) {
+	err := c.read(r)
+	c.mu.Lock()
+	if c.err == nil {
+		c.err = err
+	}
+	for _, ch := range c.pongs {
+		ch <- c.err
+	}
+	c.pongs = nil
+	c.mu.Unlock()
+	close(c.done)
+}
+
+func (c *Conn) read(r *bufio.Reader) error {
+	for {
+		line, err := readLine(r)
+		if err != nil {
+			return err
+		}
+		op, args, _ := strings.Cut(line, " ")
+		switch strings.ToUpper(op) {
+		case "MSG":
+			// MSG <subject> <sid> [reply] <size>
+			f := strings.Fields(args)
+			if len(f) < 3 || len(f) > 4 {
+				return fmt.Errorf("nats: malformed %q", line)
+			}
+			size, err := strconv.Atoi(f[len(f)-1])
+			if err != nil {
+				return fmt.Errorf("nats: malformed %q", line)
+			}
+			data := make([]byte, size+2)
+			if _, err = io.ReadFull(r, data); err != nil {
+				return err
+			}
+			msg := Msg{Subject: f[0], Data: data[:size]}
+			if len(f) == 4 {
+				msg.Reply = f[2]
+			}
+			sid, _ := strconv.ParseUint(f[1], 10, 64)
+			c.mu.Lock()
+			handler := c.subs[sid]
+			c.mu.Unlock()
+			if handler != nil {
+				handler(msg)
+			}
+		case "PING":
+			if err = c.send("PONG\r\n"); err != nil {
+				return err
+			}
+		case "PONG":
+			c.mu.Lock()
+			if len(c.pongs) > 0 {
+				c.pongs[0] <- nil
+				c.pongs = c.pongs[1:]
+			}
+			c.mu.Unlock()
+		case "-ERR":
+			err = errors.New("nats: " + strings.Trim(args, "' "))
+			c.mu.Lock()
+			c.err = err
+			c.mu.Unlock()
+			return err
+		case "+OK", "INFO":
+		default:
+			return fmt.Errorf("nats: unexpected %q", line)
+		}
+	}
+}
+
+// Publish publishes data to the subject
+func (c *Conn) Publish(subject string, data []byte) error {
+	return c.PublishRequest(subject, "", data)
+}
+
+// PublishRequest publishes data to the subject with a reply subject
+func (c *Conn) PublishRequest(subject string, reply string, data []byte) error {
+	if err := c.Err(); err != nil {
+		return err
+	}
+	head := "PUB " + subject + " "
+	if reply != "" {
+		head += reply + " "
+	}
+	return c.send(head+strconv.Itoa(len(data))+"\r\n", data)
+}
+
+// Subscribe subscribes the handler to the subject, in the queue group when
+// queue is not empty, and returns the subscription id
+func (c *Conn) Subscribe(subject string, queue string, handler func(Msg)) (uint64, error) {
+	sid := c.sid.Add(1)
+	c.mu.Lock()
+	c.subs[sid] = handler
+	c.mu.Unlock()
+	args := subject + " "
+	if queue != "" {
+		args += queue + " "
+	}
+	if err := c.send("SUB " + args + strconv.FormatUint(sid, 10) + "\r\n"); err != nil {
+		return 0, err
+	}
+	return sid, nil
+}
+
+// Unsubscribe ends a subscription
+func (c *Conn) Unsubscribe(sid uint64) error {
+	c.mu.Lock()
+	delete(c.subs, sid)
+	c.mu.Unlock()
+	return c.send("UNSUB " + strconv.FormatUint(sid, 10) + "\r\n")
+}
+
+// Request publishes data to the subject and returns the first reply
+func (c *Conn) Request(subject string, data []byte) ([]byte, error) {
+	reply := c.inbox + "." + strconv.FormatUint(c.sid.Add(1), 10)
+	ch := make(chan []byte, 1)
+	sid, err := c.Subscribe(reply, "", func(m Msg) {
+		select {
+		case ch <- m.Data:
+		default:
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer c.Unsubscribe(sid)
+	if err = c.PublishRequest(subject, reply, data); err != nil {
+		return nil, err
+	}
+	select {
+	case data := <-ch:
+		return data, nil
+	case <-c.done:
+		return nil, c.Err()
+	case <-time.After(c.opts.Timeout):
+		return nil, ErrTimeout
+	}
+}
+
+// Err returns the error that closed the connection
+func (c *Conn) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
+
+// Done is closed when the connection is closed
+func (c *Conn) Done() <-chan struct{} {
+	return c.done
+}
+
+// Close closes the connection
+func (c *Conn) Close() error {
+	c.mu.Lock()
+	if c.err == nil {
+		c.err = ErrClosed
+	}
+	c.mu.Unlock()
+	return c.conn.Close()
+}
@@ -0,0 +1,122 @@
+/*
+ *
+ *  * Licensed to the Apache Software Foundation (ASF) under one or more
+ *  * contributor license agreements.  See the NOTICE file distributed with
+ *  * this work for additional information regarding copyright ownership.
+ *  * The ASF licenses this file to You under the Apache License, Version 2.0
+ *  * (the "License"); you may not use this file except in compliance with
+ *  * the License.  You may obtain a copy of the License at
+ *  *
+ *  *     http://www.apache.org/licenses/LICENSE-2.0
+ *  *
+ *  * Unless required by applicable law or agreed to in writing, software
+ *  * distributed under the License is distributed on an "AS IS" BASIS,
+ *  * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  * See the License for the specific language governing permissions and
+ *  * limitations under the License.
+ *
+ */
+
+package natsclient
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// APIError is an error of the JetStream API
+type APIError struct {
+	Code        int    `json:"code"`
+	Description string `json:"description"`
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("nats: jetstream %d %s", e.Code, e.Description)
+}
+
+type apiResponse struct {
+	Error  *APIError `json:"error"`
+	Stream string    `json:"stream"`
+	Seq    uint64    `json:"seq"`
+}
+
+func (c *Conn) api(subject string, data []byte) (*apiResponse, error) {
+	reply, err := c.Request(subject, data)
+	if err != nil {
+		return nil, err
+	}
+	var resp apiResponse
+	if err = json.Unmarshal(reply, &resp); err != nil {
+		return nil, err
+	}
+	if resp.Error != nil {
+		return nil, resp.Error
+	}
+	return &resp, nil
+}
+
+// JSPublish publishes data to the subject of a stream and waits for the
+// stream to store it
+func (c *Conn) JSPublish(subject string, data []byte) error {
+	resp, err := c.api(subject, data)
+	if err != nil {
+		return err
+	}
+	if resp.Stream == "" {
+		return errors.New("nats: jetstream publish not acknowledged")
+	}
+	return nil
+}
+
+type consumerConfig struct {
+	Durable        string        `json:"durable_name"`
+	DeliverSubject string        `json:"deliver_subject"`
+	DeliverGroup   string        `json:"deliver_group"`
+	DeliverPolicy  string        `json:"deliver_policy"`
+	AckPolicy      string        `json:"ack_policy"`
+	AckWait        time.Duration `json:"ack_wait"`
+	FilterSubject  string        `json:"filter_subject,omitempty"`
+}
+
+type createConsumer struct {
+	Stream string         `json:"stream_name"`
+	Config consumerConfig `json:"config"`
+}
+
+// JSConsume creates the durable push consumer of a stream, if it does not
+// exist, and subscribes the handler to its messages. The consumers sharing
+// the durable name share the messages. A message is acknowledged when the
+// handler returns nil and redelivered after ackWait otherwise. The handler
+// must not wait for a reply of the server.
+func (c *Conn) JSConsume(stream string, durable string, filter string, ackWait time.Duration, handler func(Msg) error) (uint64, error) {
+	deliver := "_DELIVER." + stream + "." + durable
+	req, err := json.Marshal(createConsumer{
+		Stream: stream,
+		Config: consumerConfig{
+			Durable:        durable,
+			DeliverSubject: deliver,
+			DeliverGroup:   durable,
+			DeliverPolicy:  "all",
+			AckPolicy:      "explicit",
+			AckWait:        ackWait,
+			FilterSubject:  filter,
+		},
+	})
+	if err != nil {
+		return 0, err
+	}
+	if _, err = c.api("$JS.API.CONSUMER.DURABLE.CREATE."+stream+"."+durable, req); err != nil {
+		return 0, err
+	}
+	return c.Subscribe(deliver, durable, func(m Msg) {
+		ack := "+ACK"
+		if err := handler(m); err != nil {
+			ack = "-NAK"
+		}
+		if m.Reply != "" {
+			_ = c.Publish(m.Reply, []byte(ack))
+		}
+	})
+}
@@ -0,0 +1,234 @@
+package natsclient
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeServer routes the messages to the subscriptions of the subjects, and
+// answers the requests to the subjects of replies
+type fakeServer struct {
+	ln      net.Listener
+	replies map[string]string
+	// the server requires TLS when not nil
+	tls     *tls.Config
+	mu      sync.Mutex
+	subs    map[string][]string
+	connect string
+}
+
+func newFakeServer(t *testing.T, replies map[string]string, tlsConf *tls.Config) *fakeServer {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &fakeServer{ln: ln, replies: replies, tls: tlsConf, subs: make(map[string][]string)}
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(c)
+		}
+	}()
+	t.Cleanup(func() { ln.Close() })
+	return s
+}
+
+func (s *fakeServer) serve(c net.Conn) {
+	defer c.Close()
+	var wmu sync.Mutex
+	write := func(format string, args ...interface{}) {
+		wmu.Lock()
+		defer wmu.Unlock()
+		fmt.Fprintf(c, format, args...)
+	}
+	write("INFO {\"server_id\":\"fake\",\"tls_required\":%t}\r\n", s.tls != nil)
+	if s.tls != nil {
+		c = tls.Server(c, s.tls)
+	}
+	r := bufio.NewReader(c)
+	for {
+		line, err := readLine(r)
+		if err != nil {
+			return
+		}
+		f := strings.Fields(line)
+		switch f[0] {
+		case "CONNECT":
+			s.mu.Lock()
+			s.connect = line
+			s.mu.Unlock()
+		case "PING":
+			write("PONG\r\n")
+		case "SUB":
+			s.mu.Lock()
+			s.subs[f[1]] = append(s.subs[f[1]], f[len(f)-1])
+			s.mu.Unlock()
+		case "UNSUB":
+		case "PUB":
+			size, _ := strconv.Atoi(f[len(f)-1])
+			data := make([]byte, size+2)
+			if _, err = io.ReadFull(r, data); err != nil {
+				return
+			}
+			data = data[:size]
+			s.mu.Lock()
+			sids := s.subs[f[1]]
+			reply, ok := s.replies[f[1]]
+			s.mu.Unlock()
+			for _, sid := range sids {
+				write("MSG %s %s %d\r\n%s\r\n", f[1], sid, len(data), data)
+			}
+			if ok && len(f) == 4 {
+				s.mu.Lock()
+				sids = s.subs[f[2]]
+				s.mu.Unlock()
+				for _, sid := range sids {
+					write("MSG %s %s %d\r\n%s\r\n", f[2], sid, len(reply), reply)
+				}
+			}
+		}
+	}
+}
+
+func TestPublishSubscribe(t *testing.T) {
+	s := newFakeServer(t, map[string]string{
+		"orders":  `{"stream":"ORDERS","seq":1}`,
+		"refused": `{"error":{"code":503,"description":"no stream"}}`,
+	}, nil)
+	c, err := Dial(s.ln.Addr().String(), Options{User: "u", Password: "p", Timeout: time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	s.mu.Lock()
+	if !strings.Contains(s.connect, `"user":"u"`) || !strings.Contains(s.connect, `"pass":"p"`) {
+		t.Fatalf("connect: %s", s.connect)
+	}
+	s.mu.Unlock()
+
+	got := make(chan Msg, 1)
+	if _, err = c.Subscribe("news", "", func(m Msg) { got <- m }); err != nil {
+		t.Fatal(err)
+	}
+	if err = c.Publish("news", []byte("hello\r\nworld")); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case m := <-got:
+		if m.Subject != "news" || string(m.Data) != "hello\r\nworld" {
+			t.Fatalf("message: %+v", m)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no message")
+	}
+
+	if err = c.JSPublish("orders", []byte("created")); err != nil {
+		t.Fatal(err)
+	}
+	err = c.JSPublish("refused", []byte("created"))
+	if apiErr, ok := err.(*APIError); !ok || apiErr.Code != 503 {
+		t.Fatalf("refused publish: %v", err)
+	}
+}
+
+func TestClosed(t *testing.T) {
+	s := newFakeServer(t, nil, nil)
+	c, err := Dial(s.ln.Addr().String(), Options{Timeout: time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Close()
+	select {
+	case <-c.Done():
+	case <-time.After(time.Second):
+		t.Fatal("not done")
+	}
+	if err = c.Publish("news", nil); err != ErrClosed {
+		t.Fatalf("publish after close: %v", err)
+	}
+}
+
+// testTLS returns the TLS configurations of a server of 127.0.0.1 with a
+// self-signed certificate, and of the clients trusting it
+func testTLS(t *testing.T) (*tls.Config, *tls.Config) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "127.0.0.1"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}},
+		&tls.Config{RootCAs: pool}
+}
+
+func TestTLS(t *testing.T) {
+	server, client := testTLS(t)
+	s := newFakeServer(t, nil, server)
+	if _, err := Dial(s.ln.Addr().String(), Options{Timeout: time.Second}); err == nil {
+		t.Fatal("plain connection to a TLS server")
+	}
+
+	c, err := Dial(s.ln.Addr().String(), Options{Timeout: time.Second, TLS: client})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	got := make(chan Msg, 1)
+	if _, err = c.Subscribe("news", "", func(m Msg) { got <- m }); err != nil {
+		t.Fatal(err)
+	}
+	if err = c.Publish("news", []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case m := <-got:
+		if string(m.Data) != "hello" {
+			t.Fatalf("message: %+v", m)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no message")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !strings.Contains(s.connect, `"tls_required":true`) {
+		t.Fatalf("connect: %s", s.connect)
+	}
+
+	_, untrusted := testTLS(t)
+	if _, err = Dial(s.ln.Addr().String(), Options{Timeout: time.Second, TLS: untrusted}); err == nil {
+		t.Fatal("certificate of another authority accepted")
+	}
+}
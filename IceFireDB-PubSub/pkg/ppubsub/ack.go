@@ -0,0 +1,191 @@
+package ppubsub
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/IceFireDB/IceFireDB/IceFireDB-PubSub/pkg/config"
+	"github.com/IceFireDB/IceFireDB/IceFireDB-PubSub/pkg/msglog"
+	"github.com/IceFireDB/components-go/RESPHandle"
+	"github.com/sirupsen/logrus"
+)
+
+var ErrUnknownConsumer = errors.New("unknown consumer")
+
+// The interval of the check of the acknowledgement deadlines
+const redeliverInterval = time.Second
+
+// consumer is a subscriber acknowledging the messages of a topic. The
+// messages it does not acknowledge in time are redelivered, then published
+// to the dead letter topic, and its cursor is stored so a consumer
+// subscribing again under the same name receives the messages it did not
+// acknowledge.
+type consumer struct {
+	sync.Mutex
+	name    string
+	write   func(message string, offset uint64) error
+	pending *msglog.Pending
+}
+
+// SubAck subscribes a consumer to a topic, after writing the messages from
+// its cursor. It replaces the connection of a consumer of the same name.
+func SubAck(local *RESPHandle.WriterHandle, topicName string, name string) error {
+	return subAck(topicName, name, func(message string, offset uint64) error {
+		return writeMessage(local, topicName, message, offset)
+	})
+}
+
+func subAck(topicName string, name string, write func(message string, offset uint64) error) error {
+	if msgLog == nil {
+		return ErrNotPersisted
+	}
+	ps, err := joinTopic(topicName)
+	if err != nil {
+		return err
+	}
+	ps.deliverLock.Lock()
+	defer ps.deliverLock.Unlock()
+	cursor, err := msgLog.Committed(topicName, name)
+	if err != nil {
+		return err
+	}
+	conf := config.Get().Persist
+	c := &consumer{
+		name:    name,
+		write:   write,
+		pending: msglog.NewPending(cursor, time.Duration(conf.AckTimeout)*time.Millisecond, conf.MaxDeliveries),
+	}
+	c.Lock()
+	defer c.Unlock()
+	pss.Lock()
+	if _, ok := pss.consumers[topicName]; !ok {
+		pss.consumers[topicName] = make(map[string]*consumer)
+	}
+	pss.consumers[topicName][name] = c
+	pss.Unlock()
+
+	last, err := msgLog.Last(topicName)
+	if err != nil {
+		return err
+	}
+	err = msgLog.Range(topicName, cursor+1, 0, last, func(m msglog.Message) error {
+		c.pending.Deliver(m.Offset, time.Now())
+		return write(m.Message, m.Offset)
+	})
+	if err != nil {
+		dropConsumer(topicName, c)
+	}
+	return err
+}
+
+// Ack acknowledges the messages of a consumer and returns the number of
+// messages that were pending
+func Ack(topicName string, name string, offsets []uint64) (int, error) {
+	pss.RLock()
+	c, ok := pss.consumers[topicName][name]
+	pss.RUnlock()
+	if !ok {
+		return 0, ErrUnknownConsumer
+	}
+	c.Lock()
+	defer c.Unlock()
+	acked := 0
+	for _, offset := range offsets {
+		if c.pending.Ack(offset) {
+			acked++
+		}
+	}
+	if acked == 0 {
+		return 0, nil
+	}
+	return acked, msgLog.Commit(topicName, name, c.pending.Cursor())
+}
+
+func consumersOf(topicName string) []*consumer {
+	pss.RLock()
+	defer pss.RUnlock()
+	consumers := make([]*consumer, 0, len(pss.consumers[topicName]))
+	for _, c := range pss.consumers[topicName] {
+		consumers = append(consumers, c)
+	}
+	return consumers
+}
+
+// dropConsumer forgets a consumer whose connection failed, its messages not
+// acknowledged are written again when it subscribes again
+func dropConsumer(topicName string, c *consumer) {
+	pss.Lock()
+	defer pss.Unlock()
+	if pss.consumers[topicName][c.name] == c {
+		delete(pss.consumers[topicName], c.name)
+	}
+}
+
+// deliverConsumers writes a live message to the consumers of its topic
+func deliverConsumers(topicName string, message string, offset uint64) {
+	for _, c := range consumersOf(topicName) {
+		c.Lock()
+		c.pending.Deliver(offset, time.Now())
+		err := c.write(message, offset)
+		c.Unlock()
+		if err != nil {
+			logrus.Infof("consumer %s of topic %s dropped: %v", c.name, topicName, err)
+			dropConsumer(topicName, c)
+		}
+	}
+}
+
+// redeliver writes again the messages past their deadline and publishes
+// the ones delivered too many times to the dead letter topic
+func redeliver() {
+	pss.RLock()
+	topics := make([]string, 0, len(pss.consumers))
+	for topicName := range pss.consumers {
+		topics = append(topics, topicName)
+	}
+	pss.RUnlock()
+	for _, topicName := range topics {
+		for _, c := range consumersOf(topicName) {
+			if err := c.redeliver(topicName); err != nil {
+				logrus.Infof("consumer %s of topic %s dropped: %v", c.name, topicName, err)
+				dropConsumer(topicName, c)
+			}
+		}
+	}
+}
+
+func (c *consumer) redeliver(topicName string) error {
+	c.Lock()
+	defer c.Unlock()
+	now := time.Now()
+	offsets, dead := c.pending.Expired(now)
+	if len(offsets) == 0 && len(dead) == 0 {
+		return nil
+	}
+	for _, offset := range offsets {
+		found := false
+		err := msgLog.Range(topicName, offset, 0, offset, func(m msglog.Message) error {
+			found = true
+			c.pending.Deliver(offset, now)
+			return c.write(m.Message, offset)
+		})
+		if err != nil {
+			return err
+		}
+		if !found {
+			// deleted by the retention
+			c.pending.Ack(offset)
+		}
+	}
+	for _, offset := range dead {
+		err := msgLog.Range(topicName, offset, 0, offset, func(m msglog.Message) error {
+			logrus.Infof("consumer %s of topic %s: message %d to the dead letter topic", c.name, topicName, offset)
+			return Pub(topicName+config.Get().Persist.DeadLetterSuffix, m.Message)
+		})
+		if err != nil {
+			logrus.Errorf("dead letter of topic %s err: %v", topicName, err)
+		}
+	}
+	return msgLog.Commit(topicName, c.name, c.pending.Cursor())
+}
@@ -0,0 +1,273 @@
+package ppubsub
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/IceFireDB/IceFireDB/IceFireDB-PubSub/pkg/config"
+	"github.com/IceFireDB/IceFireDB/IceFireDB-PubSub/pkg/kafkaclient"
+	"github.com/IceFireDB/IceFireDB/IceFireDB-PubSub/pkg/natsclient"
+	"github.com/sirupsen/logrus"
+)
+
+// The messages waiting to be mirrored by a bridge, beyond which they are
+// dropped
+const bridgeQueueSize = 1024
+
+// The delay before a bridge connects again after an error
+const bridgeRetryInterval = 3 * time.Second
+
+// bridges mirrors the p2p topics to and from NATS or Kafka
+var bridges []*bridge
+
+type bridge struct {
+	conf config.BridgeS
+	// The remote topic of every p2p topic mirrored out
+	out   map[string]string
+	queue chan mirrored
+}
+
+type mirrored struct {
+	remote  string
+	message string
+}
+
+// remote is the connection of a bridge to NATS or Kafka
+type remote interface {
+	publish(topic string, data []byte) error
+	// consume calls fn with the messages of the topic until ctx is done or
+	// the connection fails
+	consume(ctx context.Context, topic string, fn func(data []byte) error) error
+	close()
+}
+
+func initBridges(ctx context.Context) error {
+	for _, conf := range config.Get().Bridges {
+		b := &bridge{conf: conf, out: make(map[string]string), queue: make(chan mirrored, bridgeQueueSize)}
+		for _, t := range conf.Topics {
+			// the topics are joined to receive their messages
+			if _, err := joinTopic(t.Topic); err != nil {
+				return err
+			}
+			if conf.Direction != "in" {
+				b.out[t.Topic] = t.Remote
+			}
+		}
+		bridges = append(bridges, b)
+		go b.run(ctx)
+	}
+	return nil
+}
+
+// mirror queues a message received by a p2p topic to the bridges mirroring
+// the topic out
+func mirror(topicName string, message string) {
+	for _, b := range bridges {
+		r, ok := b.out[topicName]
+		if !ok {
+			continue
+		}
+		select {
+		case b.queue <- mirrored{remote: r, message: message}:
+		default:
+			logrus.Warnf("bridge %s queue full, message of topic %s dropped", b.conf.Name, topicName)
+		}
+	}
+}
+
+// pubBridged publishes to a p2p topic a message mirrored by a bridge
+func pubBridged(topicName string, message string, origin string) error {
+	ps, err := joinTopic(topicName)
+	if err != nil {
+		return err
+	}
+	ps.Bridged <- chatmessage{Message: message, Origin: origin}
+	return nil
+}
+
+// tlsConfig returns the TLS configuration of a bridge, nil without tls
+func (b *bridge) tlsConfig() (*tls.Config, error) {
+	if !b.conf.TLS {
+		return nil, nil
+	}
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12}
+	if b.conf.TLSCA != "" {
+		pem, err := os.ReadFile(b.conf.TLSCA)
+		if err != nil {
+			return nil, err
+		}
+		cfg.RootCAs = x509.NewCertPool()
+		if !cfg.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate in %s", b.conf.TLSCA)
+		}
+	}
+	if b.conf.TLSCert != "" {
+		cert, err := tls.LoadX509KeyPair(b.conf.TLSCert, b.conf.TLSKey)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return cfg, nil
+}
+
+func (b *bridge) dial() (remote, error) {
+	tlsConf, err := b.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+	if b.conf.Type == "kafka" {
+		opts := kafkaclient.Options{TLS: tlsConf}
+		if b.conf.SASL != "" {
+			opts.SASL = &kafkaclient.SASL{Mechanism: b.conf.SASL, User: b.conf.User, Password: b.conf.Password}
+		}
+		return &kafkaRemote{
+			client: kafkaclient.New(strings.Split(b.conf.Addr, ","), opts),
+			start:  b.conf.Start,
+		}, nil
+	}
+	conn, err := natsclient.Dial(b.conf.Addr, natsclient.Options{
+		Name:     "icefiredb-pubsub-" + b.conf.Name,
+		User:     b.conf.User,
+		Password: b.conf.Password,
+		Token:    b.conf.Token,
+		TLS:      tlsConf,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &natsRemote{conn: conn, stream: b.conf.Stream, durable: b.conf.Durable}, nil
+}
+
+// run connects the bridge and mirrors the messages until ctx is done,
+// connecting again after an error
+func (b *bridge) run(ctx context.Context) {
+	for ctx.Err() == nil {
+		r, err := b.dial()
+		if err == nil {
+			logrus.Infof("bridge %s connected to %s", b.conf.Name, b.conf.Addr)
+			err = b.serve(ctx, r)
+			r.close()
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		logrus.Errorf("bridge %s err: %v", b.conf.Name, err)
+		select {
+		case <-ctx.Done():
+		case <-time.After(bridgeRetryInterval):
+		}
+	}
+}
+
+func (b *bridge) serve(ctx context.Context, r remote) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	errc := make(chan error, len(b.conf.Topics)+1)
+	if b.conf.Direction != "out" {
+		for _, t := range b.conf.Topics {
+			go func(t config.BridgeTopicS) {
+				errc <- r.consume(ctx, t.Remote, func(data []byte) error {
+					return pubBridged(t.Topic, string(data), b.conf.Name)
+				})
+			}(t)
+		}
+	}
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				errc <- nil
+				return
+			case m := <-b.queue:
+				if err := r.publish(m.remote, []byte(m.message)); err != nil {
+					// the message is mirrored after the connection is back
+					b.queue <- m
+					errc <- err
+					return
+				}
+			}
+		}
+	}()
+	select {
+	case <-ctx.Done():
+		return nil
+	case err := <-errc:
+		return err
+	}
+}
+
+type natsRemote struct {
+	conn    *natsclient.Conn
+	stream  string
+	durable string
+}
+
+func (n *natsRemote) publish(subject string, data []byte) error {
+	if n.stream != "" {
+		return n.conn.JSPublish(subject, data)
+	}
+	return n.conn.Publish(subject, data)
+}
+
+func (n *natsRemote) consume(ctx context.Context, subject string, fn func(data []byte) error) error {
+	var err error
+	if n.stream != "" {
+		_, err = n.conn.JSConsume(n.stream, n.durable+"-"+sanitizeDurable(subject), subject, 30*time.Second, func(m natsclient.Msg) error {
+			return fn(m.Data)
+		})
+	} else {
+		_, err = n.conn.Subscribe(subject, n.durable, func(m natsclient.Msg) {
+			if err := fn(m.Data); err != nil {
+				logrus.Errorf("bridge message of %s err: %v", subject, err)
+			}
+		})
+	}
+	if err != nil {
+		return err
+	}
+	select {
+	case <-ctx.Done():
+		return nil
+	case <-n.conn.Done():
+		return n.conn.Err()
+	}
+}
+
+func (n *natsRemote) close() {
+	_ = n.conn.Close()
+}
+
+// sanitizeDurable replaces the characters of a subject not allowed in a
+// consumer name
+func sanitizeDurable(subject string) string {
+	return strings.NewReplacer(".", "_", "*", "_", ">", "_").Replace(subject)
+}
+
+type kafkaRemote struct {
+	client *kafkaclient.Client
+	start  string
+}
+
+func (k *kafkaRemote) publish(topic string, data []byte) error {
+	return k.client.Produce(topic, nil, data)
+}
+
+func (k *kafkaRemote) consume(ctx context.Context, topic string, fn func(data []byte) error) error {
+	start := kafkaclient.Latest
+	if strings.ToLower(k.start) == "earliest" {
+		start = kafkaclient.Earliest
+	}
+	return k.client.Consume(ctx, topic, start, func(r kafkaclient.Record) error {
+		return fn(r.Value)
+	})
+}
+
+func (k *kafkaRemote) close() {
+	_ = k.client.Close()
+}
@@ -0,0 +1,184 @@
+package ppubsub
+
+import (
+	"encoding/json"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/IceFireDB/IceFireDB/IceFireDB-PubSub/pkg/config"
+	"github.com/IceFireDB/IceFireDB/IceFireDB-PubSub/pkg/group"
+	"github.com/IceFireDB/IceFireDB/IceFireDB-PubSub/pkg/router"
+	"github.com/IceFireDB/components-go/RESPHandle"
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	"github.com/sirupsen/logrus"
+)
+
+// The p2p topic of the consumer group membership announcements
+const groupTopicName = "pub-sub-p2p-consumer-groups"
+
+// The announcements a node may miss before its members are removed
+const groupMissedHeartbeats = 3
+
+var (
+	groupLock  sync.Mutex
+	membership *group.Membership
+	groupTopic *pubsub.Topic
+)
+
+// initGroups joins the membership topic the first time a group member
+// subscribes
+func initGroups() error {
+	groupLock.Lock()
+	defer groupLock.Unlock()
+	if membership != nil {
+		return nil
+	}
+	topic, err := pss.p2p.PubSub.Join(groupTopicName)
+	if err != nil {
+		return err
+	}
+	sub, err := topic.Subscribe()
+	if err != nil {
+		return err
+	}
+	conf := config.Get().Group
+	heartbeat := time.Duration(conf.Heartbeat) * time.Millisecond
+	groupTopic = topic
+	membership = group.New(conf.Partitions, groupMissedHeartbeats*heartbeat)
+
+	go func() {
+		ticker := time.NewTicker(heartbeat)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-pss.ctx.Done():
+				return
+			case <-ticker.C:
+				announce()
+			}
+		}
+	}()
+	go func() {
+		self := pss.p2p.Host.ID()
+		for {
+			msg, err := sub.Next(pss.ctx)
+			if err != nil {
+				return
+			}
+			if msg.GetFrom() == self {
+				continue
+			}
+			var a group.Announcement
+			if err = json.Unmarshal(msg.Data, &a); err != nil || a.Node != msg.GetFrom().String() {
+				continue
+			}
+			membership.Announce(a, time.Now())
+		}
+	}()
+	return nil
+}
+
+// announce publishes the group members of this node
+func announce() {
+	a := group.Announcement{Node: pss.p2p.Host.ID().String()}
+	pss.RLock()
+	for topicName, groups := range pss.groups {
+		for name, members := range groups {
+			g := group.Group{Topic: topicName, Name: name}
+			for member := range members {
+				g.Members = append(g.Members, member)
+			}
+			sort.Strings(g.Members)
+			a.Groups = append(a.Groups, g)
+		}
+	}
+	pss.RUnlock()
+	membership.Announce(a, time.Now())
+
+	data, err := json.Marshal(a)
+	if err != nil {
+		return
+	}
+	if err = groupTopic.Publish(pss.ctx, data); err != nil {
+		logrus.Errorf("consumer group announcement err: %v", err)
+	}
+}
+
+// SubGroup subscribes a member to a consumer group of a topic. Every
+// message of the topic is written to the one member of each group owning
+// its partition, and the partitions are assigned again when the members
+// change.
+func SubGroup(local *RESPHandle.WriterHandle, topicName string, name string, member string) error {
+	if err := initGroups(); err != nil {
+		return err
+	}
+	if _, err := joinTopic(topicName); err != nil {
+		return err
+	}
+	pss.Lock()
+	if _, ok := pss.groups[topicName]; !ok {
+		pss.groups[topicName] = make(map[string]map[string]*RESPHandle.WriterHandle)
+	}
+	if _, ok := pss.groups[topicName][name]; !ok {
+		pss.groups[topicName][name] = make(map[string]*RESPHandle.WriterHandle)
+	}
+	pss.groups[topicName][name][member] = local
+	pss.Unlock()
+	announce()
+	return nil
+}
+
+// GroupMembers returns the members of a consumer group with their
+// partitions
+func GroupMembers(topicName string, name string) []group.Member {
+	groupLock.Lock()
+	m := membership
+	groupLock.Unlock()
+	if m == nil {
+		return nil
+	}
+	return m.Members(topicName, name, time.Now())
+}
+
+// deliverGroups writes a message to the members of this node owning its
+// partition, the members whose connection failed leave their group
+func deliverGroups(topicName string, msg chatmessage) {
+	if membership == nil {
+		return
+	}
+	self := pss.p2p.Host.ID().String()
+	partition := membership.Partition(msg.id)
+	type failure struct{ name, member string }
+	var failed []failure
+
+	pss.RLock()
+	for name, members := range pss.groups[topicName] {
+		owner, ok := membership.Owner(topicName, name, partition, time.Now())
+		if !ok || owner.Node != self {
+			continue
+		}
+		w, ok := members[owner.Name]
+		if !ok {
+			continue
+		}
+		if err := router.WriteBulkStrings(w, []string{"message", topicName, msg.Message}); err != nil {
+			failed = append(failed, failure{name, owner.Name})
+		}
+	}
+	pss.RUnlock()
+
+	if len(failed) == 0 {
+		return
+	}
+	pss.Lock()
+	for _, f := range failed {
+		logrus.Infof("member %s of group %s of topic %s left", f.member, f.name, topicName)
+		delete(pss.groups[topicName][f.name], f.member)
+		if len(pss.groups[topicName][f.name]) == 0 {
+			delete(pss.groups[topicName], f.name)
+		}
+	}
+	pss.Unlock()
+	announce()
+}
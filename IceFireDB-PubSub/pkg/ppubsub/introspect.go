@@ -0,0 +1,64 @@
+package ppubsub
+
+import (
+	"sort"
+	"time"
+)
+
+// PeerInfo describes a peer connected to the p2p host
+type PeerInfo struct {
+	ID        string
+	Addr      string
+	Transport string
+	Latency   time.Duration
+	Protocols []string
+}
+
+// TopicInfo describes a pubsub topic the p2p host is subscribed to
+type TopicInfo struct {
+	Name  string
+	Peers []string
+}
+
+// Peers returns one entry per open connection of the p2p host
+func Peers() []PeerInfo {
+	h := pss.p2p.Host
+	conns := h.Network().Conns()
+	infos := make([]PeerInfo, 0, len(conns))
+	for _, c := range conns {
+		pid := c.RemotePeer()
+		info := PeerInfo{
+			ID:        pid.String(),
+			Addr:      c.RemoteMultiaddr().String(),
+			Transport: c.ConnState().Transport,
+			Latency:   h.Peerstore().LatencyEWMA(pid),
+		}
+		protocols, _ := h.Peerstore().GetProtocols(pid)
+		for _, p := range protocols {
+			info.Protocols = append(info.Protocols, string(p))
+		}
+		sort.Strings(info.Protocols)
+		infos = append(infos, info)
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].ID < infos[j].ID
+	})
+	return infos
+}
+
+// Topics returns the subscribed topics with the peers known to be in each topic mesh
+func Topics() []TopicInfo {
+	ps := pss.p2p.PubSub
+	topics := ps.GetTopics()
+	sort.Strings(topics)
+	infos := make([]TopicInfo, 0, len(topics))
+	for _, topic := range topics {
+		info := TopicInfo{Name: topic}
+		for _, pid := range ps.ListPeers(topic) {
+			info.Peers = append(info.Peers, pid.String())
+		}
+		sort.Strings(info.Peers)
+		infos = append(infos, info)
+	}
+	return infos
+}
@@ -0,0 +1,125 @@
+package ppubsub
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/IceFireDB/IceFireDB/IceFireDB-PubSub/pkg/config"
+	"github.com/IceFireDB/IceFireDB/IceFireDB-PubSub/pkg/mqtt"
+	"github.com/sirupsen/logrus"
+)
+
+// ServeMQTT accepts the MQTT clients until ctx is done. The MQTT topics are
+// the p2p topics without the configured prefix.
+func ServeMQTT(ctx context.Context) error {
+	conf := config.Get().MQTT
+	ln, err := net.Listen("tcp", fmt.Sprintf(":%d", conf.Port))
+	if err != nil {
+		return err
+	}
+	logrus.Infof("mqtt listener port: %d", conf.Port)
+	srv := mqtt.NewServer(mqttHandler{}, mqtt.Options{
+		User:          conf.User,
+		Password:      conf.Password,
+		RetryInterval: time.Duration(conf.RetryInterval) * time.Millisecond,
+	})
+	return srv.Serve(ctx, ln)
+}
+
+// mqttHandler maps the MQTT sessions to the p2p topics. A QoS 1
+// subscription is an acknowledged consumer named after the client id when
+// the persistence is enabled, so its messages are redelivered until the
+// PUBACK, and resumed by a client connecting again without a clean
+// session. Otherwise the session sends the QoS 1 messages again while the
+// client stays connected.
+type mqttHandler struct{}
+
+func p2pTopic(topic string) string {
+	return config.Get().MQTT.TopicPrefix + topic
+}
+
+func mqttConsumer(s *mqtt.Session) string {
+	return "mqtt:" + s.ClientID
+}
+
+func (mqttHandler) Publish(s *mqtt.Session, topic string, payload []byte) error {
+	return Pub(p2pTopic(topic), string(payload))
+}
+
+func (mqttHandler) Subscribe(s *mqtt.Session, topic string, qos byte) (byte, error) {
+	topicName := p2pTopic(topic)
+	if qos == 1 && msgLog != nil {
+		if s.Clean {
+			// a clean session starts after the messages stored
+			last, err := msgLog.Last(topicName)
+			if err != nil {
+				return 0, err
+			}
+			if err = msgLog.Commit(topicName, mqttConsumer(s), last); err != nil {
+				return 0, err
+			}
+		}
+		err := subAck(topicName, mqttConsumer(s), func(message string, offset uint64) error {
+			return s.Deliver(topic, []byte(message), 1, offset)
+		})
+		return qos, err
+	}
+	if _, err := joinTopic(topicName); err != nil {
+		return 0, err
+	}
+	pss.Lock()
+	defer pss.Unlock()
+	if _, ok := pss.mqtt[topicName]; !ok {
+		pss.mqtt[topicName] = make(map[*mqtt.Session]byte)
+	}
+	pss.mqtt[topicName][s] = qos
+	return qos, nil
+}
+
+func (mqttHandler) Unsubscribe(s *mqtt.Session, topic string) {
+	unsubscribeMQTT(s, p2pTopic(topic))
+}
+
+func (mqttHandler) Ack(s *mqtt.Session, topic string, id uint64) {
+	if _, err := Ack(p2pTopic(topic), mqttConsumer(s), []uint64{id}); err != nil {
+		logrus.Infof("mqtt ack of %s err: %v", s.ClientID, err)
+	}
+}
+
+func (mqttHandler) Disconnect(s *mqtt.Session) {
+	pss.RLock()
+	var topics []string
+	for topicName := range pss.mqtt {
+		topics = append(topics, topicName)
+	}
+	for topicName := range pss.consumers {
+		topics = append(topics, topicName)
+	}
+	pss.RUnlock()
+	for _, topicName := range topics {
+		unsubscribeMQTT(s, topicName)
+	}
+}
+
+// unsubscribeMQTT ends the subscription of a session, the cursor of its
+// consumer is kept
+func unsubscribeMQTT(s *mqtt.Session, topicName string) {
+	pss.Lock()
+	defer pss.Unlock()
+	delete(pss.mqtt[topicName], s)
+	delete(pss.consumers[topicName], mqttConsumer(s))
+}
+
+// deliverMQTT writes a message to the MQTT sessions subscribed to its topic
+// without a consumer
+func deliverMQTT(topicName string, message string) {
+	topic := strings.TrimPrefix(topicName, config.Get().MQTT.TopicPrefix)
+	pss.RLock()
+	defer pss.RUnlock()
+	for s, qos := range pss.mqtt[topicName] {
+		_ = s.Deliver(topic, []byte(message), qos, 0)
+	}
+}
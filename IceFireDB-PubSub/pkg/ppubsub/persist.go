@@ -0,0 +1,95 @@
+package ppubsub
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/IceFireDB/IceFireDB/IceFireDB-PubSub/pkg/config"
+	"github.com/IceFireDB/IceFireDB/IceFireDB-PubSub/pkg/msglog"
+	"github.com/IceFireDB/IceFireDB/IceFireDB-PubSub/pkg/router"
+	"github.com/IceFireDB/components-go/RESPHandle"
+	"github.com/sirupsen/logrus"
+)
+
+var ErrNotPersisted = errors.New("message persistence is not enabled")
+
+// msgLog stores the messages received by the node when the persistence is
+// enabled. The offsets are assigned in the order of reception, so they are
+// local to the node.
+var msgLog *msglog.Log
+
+// The interval of the deletion of the messages older than the retention
+const trimInterval = time.Minute
+
+func initLog(ctx context.Context) error {
+	conf := config.Get().Persist
+	if !conf.Enable {
+		return nil
+	}
+	var err error
+	msgLog, err = msglog.Open(conf.Path)
+	if err != nil {
+		return err
+	}
+	for _, topic := range conf.Topics {
+		if _, err = JoinPubSub(pss.p2p, "redis-client", topic); err != nil {
+			return err
+		}
+	}
+	go func() {
+		ticker := time.NewTicker(trimInterval)
+		defer ticker.Stop()
+		redeliverTicker := time.NewTicker(redeliverInterval)
+		defer redeliverTicker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				_ = msgLog.Close()
+				return
+			case <-redeliverTicker.C:
+				redeliver()
+			case <-ticker.C:
+				if conf.Retention <= 0 {
+					continue
+				}
+				before := time.Now().Add(-time.Duration(conf.Retention) * time.Second).UnixMilli()
+				if err := msgLog.Trim(before); err != nil {
+					logrus.Errorf("trim message log err: %v", err)
+				}
+			}
+		}
+	}()
+	return nil
+}
+
+// SubFrom subscribes to a topic after writing its stored messages from the
+// offset, or from the time in unix milliseconds when since is not 0. The
+// messages are written with their offset, so a subscriber resumes from the
+// offset following the last one it read.
+func SubFrom(local *RESPHandle.WriterHandle, topicName string, from uint64, since int64) error {
+	if msgLog == nil {
+		return ErrNotPersisted
+	}
+	ps, err := joinTopic(topicName)
+	if err != nil {
+		return err
+	}
+	// the live messages wait for the end of the replay
+	ps.deliverLock.Lock()
+	defer ps.deliverLock.Unlock()
+	pss.addWriter(topicName, local, true)
+	var last uint64
+	last, err = msgLog.Last(topicName)
+	if err != nil {
+		return err
+	}
+	return msgLog.Range(topicName, from, since, last, func(m msglog.Message) error {
+		return writeMessage(local, topicName, m.Message, m.Offset)
+	})
+}
+
+func writeMessage(local *RESPHandle.WriterHandle, topicName string, message string, offset uint64) error {
+	return router.WriteBulkStrings(local, []string{"message", topicName, message, strconv.FormatUint(offset, 10)})
+}
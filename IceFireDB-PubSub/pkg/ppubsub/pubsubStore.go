@@ -8,6 +8,8 @@ import (
 	"sync"
 	"time"
 
+	"github.com/IceFireDB/IceFireDB/IceFireDB-PubSub/pkg/mqtt"
+	"github.com/IceFireDB/IceFireDB/IceFireDB-PubSub/pkg/msglog"
 	"github.com/IceFireDB/IceFireDB/IceFireDB-PubSub/pkg/router"
 	"github.com/IceFireDB/components-go/RESPHandle"
 	"github.com/IceFireDB/components-go/p2p"
@@ -23,8 +25,12 @@ var pss *pubsubStore
 const defaultclient = "client"
 const defaulttopic = "pubsub"
 
-func InitPubSub(ctx context.Context, p2p *p2p.P2P) {
+func InitPubSub(ctx context.Context, p2p *p2p.P2P) error {
 	pss = NewPubsubStore(ctx, p2p)
+	if err := initLog(ctx); err != nil {
+		return err
+	}
+	return initBridges(ctx)
 }
 
 type pubsubStore struct {
@@ -33,14 +39,26 @@ type pubsubStore struct {
 	p2p          *p2p.P2P
 	join         map[string]*PubSub
 	writer       map[string]map[string]*RESPHandle.WriterHandle
+	// The writers subscribed with SUBSCRIBEFROM, which receive the offsets
+	offsets map[string]map[string]bool
+	// The consumers subscribed with SUBSCRIBEACK, by topic and name
+	consumers map[string]map[string]*consumer
+	// The members of the consumer groups on this node, by topic, group and name
+	groups map[string]map[string]map[string]*RESPHandle.WriterHandle
+	// The MQTT sessions subscribed without a consumer, by topic, with their QoS
+	mqtt map[string]map[*mqtt.Session]byte
 }
 
 func NewPubsubStore(ctx context.Context, p2p *p2p.P2P) *pubsubStore {
 	s := &pubsubStore{
-		ctx:    ctx,
-		p2p:    p2p,
-		join:   make(map[string]*PubSub),
-		writer: make(map[string]map[string]*RESPHandle.WriterHandle),
+		ctx:       ctx,
+		p2p:       p2p,
+		join:      make(map[string]*PubSub),
+		writer:    make(map[string]map[string]*RESPHandle.WriterHandle),
+		offsets:   make(map[string]map[string]bool),
+		consumers: make(map[string]map[string]*consumer),
+		groups:    make(map[string]map[string]map[string]*RESPHandle.WriterHandle),
+		mqtt:      make(map[string]map[*mqtt.Session]byte),
 	}
 	return s
 }
@@ -59,6 +77,16 @@ func Pub(topicName string, message string) error {
 }
 
 func Sub(local *RESPHandle.WriterHandle, topicName string) (*PubSub, error) {
+	ps, err := joinTopic(topicName)
+	if err != nil {
+		return nil, err
+	}
+	pss.addWriter(topicName, local, false)
+	return ps, nil
+}
+
+// joinTopic returns the PubSub of a topic, joined the first time
+func joinTopic(topicName string) (*PubSub, error) {
 	if _, ok := pss.join[topicName]; !ok {
 		_, err := JoinPubSub(pss.p2p, "redis-client", topicName)
 		if err != nil {
@@ -66,13 +94,23 @@ func Sub(local *RESPHandle.WriterHandle, topicName string) (*PubSub, error) {
 			return nil, err
 		}
 	}
+	return pss.join[topicName], nil
+}
+
+func (s *pubsubStore) addWriter(topicName string, local *RESPHandle.WriterHandle, offsets bool) {
+	s.Lock()
+	defer s.Unlock()
 	lp := fmt.Sprintf("%p", local)
-	if _, ok := pss.writer[topicName]; !ok {
-		pss.writer[topicName] = make(map[string]*RESPHandle.WriterHandle)
+	if _, ok := s.writer[topicName]; !ok {
+		s.writer[topicName] = make(map[string]*RESPHandle.WriterHandle)
+		s.offsets[topicName] = make(map[string]bool)
+	}
+	s.writer[topicName][lp] = local
+	if offsets {
+		s.offsets[topicName][lp] = true
+	} else {
+		delete(s.offsets[topicName], lp)
 	}
-	pss.writer[topicName][lp] = local
-	ps := pss.join[topicName]
-	return ps, nil
 }
 
 // A structure that represents a PubSub Chat Room
@@ -84,6 +122,8 @@ type PubSub struct {
 	Inbound chan chatmessage
 	// Represents the channel of outgoing messages
 	Outbound chan string
+	// Represents the channel of the messages mirrored from a bridge
+	Bridged chan chatmessage
 	// Represents the channel of chat log messages
 	Logs chan chatlog
 
@@ -102,6 +142,9 @@ type PubSub struct {
 	pstopic *pubsub.Topic
 	// Represents the PubSub Subscription for the topic
 	psub *pubsub.Subscription
+
+	// Orders the delivery of the live messages after a replay
+	deliverLock sync.Mutex
 }
 
 // A structure that represents a chat message
@@ -109,6 +152,11 @@ type chatmessage struct {
 	Message    string `json:"message"`
 	SenderID   string `json:"senderid"`
 	SenderName string `json:"sendername"`
+	// The bridge a message mirrored from NATS or Kafka comes from, which is
+	// not mirrored back
+	Origin string `json:"origin,omitempty"`
+	// The id of the p2p message, which gives its consumer group partition
+	id string
 }
 
 // A structure that represents a chat log
@@ -156,6 +204,7 @@ func JoinPubSub(p2phost *p2p.P2P, clientName string, topicName string) (*PubSub,
 
 		Inbound:  make(chan chatmessage),
 		Outbound: make(chan string),
+		Bridged:  make(chan chatmessage),
 		Logs:     make(chan chatlog),
 
 		psctx:    pubsubctx,
@@ -199,24 +248,33 @@ func (cr *PubSub) PubLoop() {
 				SenderID:   cr.selfid.String(),
 				SenderName: cr.ClientName,
 			}
+			cr.publish(m)
 
-			// Marshal the ChatMessage into a JSON
-			messagebytes, err := json.Marshal(m)
-			if err != nil {
-				cr.Logs <- chatlog{logprefix: "puberr", logmsg: "could not marshal JSON"}
-				continue
-			}
-
-			// Publish the message to the topic
-			err = cr.pstopic.Publish(cr.psctx, messagebytes)
-			if err != nil {
-				cr.Logs <- chatlog{logprefix: "puberr", logmsg: "could not publish to topic"}
-				continue
-			}
+		case m := <-cr.Bridged:
+			m.SenderID = cr.selfid.String()
+			m.SenderName = cr.ClientName
+			cr.publish(m)
 		}
 	}
 }
 
+// publish publishes a chatmessage to the PubSub topic
+func (cr *PubSub) publish(m chatmessage) {
+	// Marshal the ChatMessage into a JSON
+	messagebytes, err := json.Marshal(m)
+	if err != nil {
+		cr.Logs <- chatlog{logprefix: "puberr", logmsg: "could not marshal JSON"}
+		return
+	}
+
+	// Publish the message to the topic
+	err = cr.pstopic.Publish(cr.psctx, messagebytes)
+	if err != nil {
+		cr.Logs <- chatlog{logprefix: "puberr", logmsg: "could not publish to topic"}
+		return
+	}
+}
+
 // A method of PubSub that continuously reads from the subscription
 // until either the subscription or pubsub context closes.
 // The received message is parsed sent into the inbound channel
@@ -251,6 +309,7 @@ func (cr *PubSub) SubLoop() {
 				cr.Logs <- chatlog{logprefix: "suberr", logmsg: "could not unmarshal JSON"}
 				continue
 			}
+			cm.id = message.ID
 
 			// Send the ChatMessage into the message queue
 			cr.Inbound <- *cm
@@ -261,13 +320,48 @@ func (cr *PubSub) SubLoop() {
 
 func (cr *PubSub) Writer() {
 	for {
-		msg := <-cr.Inbound
-		for key, item := range pss.writer[cr.TopicName] {
-			err := router.WriteBulkStrings(item, []string{"message", cr.TopicName, msg.Message})
-			if err != nil {
-				fmt.Println("write err key:", key)
-				continue
-			}
+		msg, ok := <-cr.Inbound
+		if !ok {
+			return
+		}
+		cr.deliver(msg)
+	}
+}
+
+// deliver stores a received message when the persistence is enabled and
+// writes it to the local subscribers
+func (cr *PubSub) deliver(msg chatmessage) {
+	cr.deliverLock.Lock()
+	defer cr.deliverLock.Unlock()
+	var offset uint64
+	if msgLog != nil {
+		m, err := msgLog.Append(cr.TopicName, msglog.Message{SenderID: msg.SenderID, Message: msg.Message})
+		if err != nil {
+			logrus.Errorf("persist message of topic %s err: %v", cr.TopicName, err)
+		}
+		offset = m.Offset
+	}
+	if offset > 0 {
+		deliverConsumers(cr.TopicName, msg.Message, offset)
+	}
+	deliverGroups(cr.TopicName, msg)
+	deliverMQTT(cr.TopicName, msg.Message)
+	if msg.Origin == "" {
+		mirror(cr.TopicName, msg.Message)
+	}
+
+	pss.RLock()
+	defer pss.RUnlock()
+	for key, item := range pss.writer[cr.TopicName] {
+		var err error
+		if pss.offsets[cr.TopicName][key] {
+			err = writeMessage(item, cr.TopicName, msg.Message, offset)
+		} else {
+			err = router.WriteBulkStrings(item, []string{"message", cr.TopicName, msg.Message})
+		}
+		if err != nil {
+			fmt.Println("write err key:", key)
+			continue
 		}
 	}
 }
@@ -94,6 +94,7 @@ var OpTable = make(map[string]OpInfo, 256)
 
 func init() {
 	for _, i := range []OpInfo{
+		{"ACK", 0, greater(4)},
 		{"APPEND", FlagWrite, equal(3)},
 		{"ASKING", FlagNotAllow, equal(2)},
 		{"AUTH", 0, equal(2)},
@@ -136,6 +137,7 @@ func init() {
 		{"GETBIT", 0, equal(3)},
 		{"GETRANGE", 0, equal(4)},
 		{"GETSET", FlagWrite, equal(3)},
+		{"GROUP.MEMBERS", 0, equal(3)},
 		{"HDEL", FlagWrite, greater(3)},
 		{"HEXISTS", 0, equal(3)},
 		{"HGET", 0, equal(3)},
@@ -185,6 +187,8 @@ func init() {
 		{"PFDEBUG", FlagWrite, greater(1)},
 		{"PFMERGE", FlagWrite, greater(3)},
 		{"PFSELFTEST", 0, greater(1)},
+		{"P2P.PEERS", 0, equal(1)},
+		{"P2P.TOPICS", 0, equal(1)},
 		{"PING", 0, greater(1)},
 		{"POST", FlagNotAllow, greater(1)},
 		//{"PPUB", FlagWrite, greater(3)},
@@ -260,6 +264,9 @@ func init() {
 		{"SSCAN", FlagMasterOnly, greater(3)},
 		{"STRLEN", 0, equal(2)},
 		{"SUBSCRIBE", 0, greater(1)},
+		{"SUBSCRIBEACK", 0, equal(3)},
+		{"SUBSCRIBEFROM", 0, equal(3)},
+		{"SUBSCRIBEGROUP", 0, equal(4)},
 		{"SUBSTR", 0, equal(4)},
 		{"SUNION", FlagNotAllow, greater(1)},
 		{"SUNIONSTORE", FlagWrite | FlagNotAllow, greater(1)},
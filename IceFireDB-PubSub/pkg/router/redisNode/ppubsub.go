@@ -2,6 +2,8 @@ package redisNode
 
 import (
 	"errors"
+	"strconv"
+	"strings"
 
 	"github.com/IceFireDB/IceFireDB/IceFireDB-PubSub/pkg/ppubsub"
 	"github.com/IceFireDB/IceFireDB/IceFireDB-PubSub/pkg/router"
@@ -45,3 +47,118 @@ func (r *Router) cmdPsub(s *router.Context) error {
 	return nil
 
 }
+
+// cmdPsubFrom handles SUBSCRIBEFROM topic offset|@unixmilli
+func (r *Router) cmdPsubFrom(s *router.Context) error {
+	args := s.Args
+	topicName := string(args[1].([]byte))
+	position := string(args[2].([]byte))
+	var from uint64
+	var since int64
+	var err error
+	if strings.HasPrefix(position, "@") {
+		since, err = strconv.ParseInt(position[1:], 10, 64)
+	} else {
+		from, err = strconv.ParseUint(position, 10, 64)
+	}
+	if err != nil {
+		return errors.New("ERR invalid offset or timestamp")
+	}
+	if err = ppubsub.SubFrom(s.Writer, topicName, from, since); err != nil {
+		return errors.New("ERR sub:" + err.Error())
+	}
+	return nil
+}
+
+// cmdPsubAck handles SUBSCRIBEACK topic consumer
+func (r *Router) cmdPsubAck(s *router.Context) error {
+	topicName := string(s.Args[1].([]byte))
+	name := string(s.Args[2].([]byte))
+	if err := ppubsub.SubAck(s.Writer, topicName, name); err != nil {
+		return errors.New("ERR sub:" + err.Error())
+	}
+	return nil
+}
+
+// cmdAck handles ACK topic consumer offset [offset ...]
+func (r *Router) cmdAck(s *router.Context) error {
+	topicName := string(s.Args[1].([]byte))
+	name := string(s.Args[2].([]byte))
+	offsets := make([]uint64, 0, len(s.Args)-3)
+	for _, arg := range s.Args[3:] {
+		offset, err := strconv.ParseUint(string(arg.([]byte)), 10, 64)
+		if err != nil {
+			return errors.New("ERR invalid offset")
+		}
+		offsets = append(offsets, offset)
+	}
+	acked, err := ppubsub.Ack(topicName, name, offsets)
+	if err != nil {
+		return errors.New("ERR ack:" + err.Error())
+	}
+	return router.WriteInt(s.Writer, int64(acked))
+}
+
+// cmdPsubGroup handles SUBSCRIBEGROUP topic group member
+func (r *Router) cmdPsubGroup(s *router.Context) error {
+	topicName := string(s.Args[1].([]byte))
+	name := string(s.Args[2].([]byte))
+	member := string(s.Args[3].([]byte))
+	if err := ppubsub.SubGroup(s.Writer, topicName, name, member); err != nil {
+		return errors.New("ERR sub:" + err.Error())
+	}
+	return nil
+}
+
+// cmdGroupMembers handles GROUP.MEMBERS topic group
+func (r *Router) cmdGroupMembers(s *router.Context) error {
+	members := ppubsub.GroupMembers(string(s.Args[1].([]byte)), string(s.Args[2].([]byte)))
+	reply := make([]interface{}, len(members))
+	for k, m := range members {
+		partitions := make([]interface{}, len(m.Partitions))
+		for i, v := range m.Partitions {
+			partitions[i] = int64(v)
+		}
+		reply[k] = []interface{}{
+			"node", m.Node,
+			"member", m.Name,
+			"partitions", partitions,
+		}
+	}
+	return router.RecursivelyWriteObjects(s.Writer, reply)
+}
+
+func (r *Router) cmdP2PPeers(s *router.Context) error {
+	peers := ppubsub.Peers()
+	reply := make([]interface{}, len(peers))
+	for k, p := range peers {
+		protocols := make([]interface{}, len(p.Protocols))
+		for i, v := range p.Protocols {
+			protocols[i] = v
+		}
+		reply[k] = []interface{}{
+			"id", p.ID,
+			"addr", p.Addr,
+			"transport", p.Transport,
+			"latency", p.Latency.String(),
+			"protocols", protocols,
+		}
+	}
+	return router.RecursivelyWriteObjects(s.Writer, reply)
+}
+
+func (r *Router) cmdP2PTopics(s *router.Context) error {
+	topics := ppubsub.Topics()
+	reply := make([]interface{}, len(topics))
+	for k, t := range topics {
+		peers := make([]interface{}, len(t.Peers))
+		for i, v := range t.Peers {
+			peers[i] = v
+		}
+		reply[k] = []interface{}{
+			"topic", t.Name,
+			"peers", peers,
+		}
+	}
+	return router.RecursivelyWriteObjects(s.Writer, reply)
+}
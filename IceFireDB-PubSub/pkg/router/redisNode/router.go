@@ -59,6 +59,13 @@ func (r *Router) InitCMD() {
 	if config.Get().P2P.Enable {
 		r.AddCommand("PUBLISH", r.cmdPpub)
 		r.AddCommand("SUBSCRIBE", r.cmdPsub)
+		r.AddCommand("SUBSCRIBEFROM", r.cmdPsubFrom)
+		r.AddCommand("SUBSCRIBEACK", r.cmdPsubAck)
+		r.AddCommand("ACK", r.cmdAck)
+		r.AddCommand("SUBSCRIBEGROUP", r.cmdPsubGroup)
+		r.AddCommand("GROUP.MEMBERS", r.cmdGroupMembers)
+		r.AddCommand("P2P.PEERS", r.cmdP2PPeers)
+		r.AddCommand("P2P.TOPICS", r.cmdP2PTopics)
 	}
 }
 
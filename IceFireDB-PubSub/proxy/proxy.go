@@ -118,7 +118,9 @@ func New() (*Proxy, error) {
 		}
 		log.Printf("Successfully joined [%s] P2P channel. \n", config.Get().P2P.ServiceCommandTopic)
 		//init ppubsub
-		ppubsub.InitPubSub(context.Background(), p.P2pHost)
+		if err = ppubsub.InitPubSub(context.Background(), p.P2pHost); err != nil {
+			return nil, err
+		}
 	}
 
 	p.router.Use(router.IgnoreCMDMiddleware(config.Get().IgnoreCMD.Enable, config.Get().IgnoreCMD.CMDList))
@@ -143,5 +145,12 @@ func (p *Proxy) Run(ctx context.Context, errSignal chan error) {
 			_ = p.server.Close()
 		}
 	}()
+	if config.Get().P2P.Enable && config.Get().MQTT.Enable {
+		go func() {
+			if err := ppubsub.ServeMQTT(ctx); err != nil {
+				logrus.Errorf("mqtt listener err: %v", err)
+			}
+		}()
+	}
 	_ = p.server.ListenServeAndSignal(errSignal)
 }
@@ -92,25 +92,24 @@ func start(c *cli.Context) error {
 
 	// Listening to the offline
 	sigs := make(chan os.Signal, 1)
-	signal.Notify(sigs, syscall.SIGHUP, syscall.SIGQUIT, syscall.SIGTERM, syscall.SIGINT)
+	signal.Notify(sigs, append([]os.Signal{syscall.SIGHUP, syscall.SIGQUIT, syscall.SIGTERM, syscall.SIGINT}, upgradeSignals...)...)
 	for sig := range sigs {
 		switch sig {
 		case syscall.SIGQUIT, syscall.SIGTERM, syscall.SIGINT:
-			cancel()
-			ok := make(chan struct{})
-			go func() {
-				wg.Wait()
-				ok <- struct{}{}
-			}()
-			select {
-			case <-ok:
-				logrus.Info("shutdown proxy")
-			case <-time.After(time.Second * 5):
-				logrus.Info("context deadline exceeded")
-			}
-			os.Exit(0)
+			p.Drain()
+			shutdown(cancel, &wg)
 		case syscall.SIGHUP:
 			logrus.Info("catch syscall.SIGHUP")
+			if _, err := p.Reload(); err != nil {
+				logrus.Errorf("reload config fail: %v", err)
+			}
+		default:
+			logrus.Infof("catch %v, upgrading proxy", sig)
+			if err := p.Upgrade(); err != nil {
+				logrus.Errorf("upgrade proxy fail: %v", err)
+				continue
+			}
+			shutdown(cancel, &wg)
 		}
 	}
 	return nil
@@ -143,6 +142,23 @@ func debug() {
 	}
 }
 
+// A function that stops the proxy and exits, the proxy has 5 seconds to stop
+func shutdown(cancel context.CancelFunc, wg *sync.WaitGroup) {
+	cancel()
+	ok := make(chan struct{})
+	go func() {
+		wg.Wait()
+		ok <- struct{}{}
+	}()
+	select {
+	case <-ok:
+		logrus.Info("shutdown proxy")
+	case <-time.After(time.Second * 5):
+		logrus.Info("context deadline exceeded")
+	}
+	os.Exit(0)
+}
+
 func showBanner() {
 	logo := `╦═╗┌─┐┌┬┐┬┌─┐  ╔═╗┬─┐┌─┐─┐ ┬┬ ┬
 ╠╦╝├┤  │││└─┐  ╠═╝├┬┘│ │┌┴┬┘└┬┘
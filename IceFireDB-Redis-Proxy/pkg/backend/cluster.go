@@ -0,0 +1,206 @@
+/*
+ *
+ *  * Licensed to the Apache Software Foundation (ASF) under one or more
+ *  * contributor license agreements.  See the NOTICE file distributed with
+ *  * this work for additional information regarding copyright ownership.
+ *  * The ASF licenses this file to You under the Apache License, Version 2.0
+ *  * (the "License"); you may not use this file except in compliance with
+ *  * the License.  You may obtain a copy of the License at
+ *  *
+ *  *     http://www.apache.org/licenses/LICENSE-2.0
+ *  *
+ *  * Unless required by applicable law or agreed to in writing, software
+ *  * distributed under the License is distributed on an "AS IS" BASIS,
+ *  * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  * See the License for the specific language governing permissions and
+ *  * limitations under the License.
+ *
+ */
+
+package backend
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/IceFireDB/IceFireDB/IceFireDB-Redis-Proxy/pkg/slots"
+	"github.com/gomodule/redigo/redis"
+)
+
+// Maximum number of MOVED or ASK redirects followed by a single command
+const maxRedirects = 5
+
+// Cluster represents a Redis Cluster backend. Commands are sent to the node
+// owning the slot of their key according to a slot cache, MOVED and ASK
+// redirects are followed so clients never see them.
+type Cluster struct {
+	opt   Options
+	seeds []string
+	table slots.Table
+
+	mu         sync.RWMutex
+	pools      map[string]*redis.Pool
+	refreshing int32
+}
+
+// NewCluster creates a cluster backend and loads its slot cache from the seed
+// nodes. The static slot map is loaded first, it is used as is when the nodes
+// do not answer CLUSTER SLOTS, e.g. IceFireDB nodes sharing the keyspace by a
+// fixed slot assignment, and is still corrected by the MOVED redirects.
+func NewCluster(seeds []string, static []slots.Range, opt Options) (*Cluster, error) {
+	c := &Cluster{
+		opt:   opt,
+		seeds: seeds,
+		pools: make(map[string]*redis.Pool),
+	}
+	c.table.Load(static)
+	if err := c.Refresh(); err != nil && len(static) == 0 {
+		c.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// Refresh reloads the slot cache with CLUSTER SLOTS, asking the known nodes
+// and then the seed nodes until one of them answers. The cache is kept as is
+// when none of them answers.
+func (c *Cluster) Refresh() error {
+	err := errors.New("no cluster node")
+	for _, addr := range append(c.table.Nodes(), c.seeds...) {
+		var ranges []slots.Range
+		ranges, err = slots.ParseClusterSlots(c.do(addr, false, "CLUSTER", "SLOTS"))
+		if err == nil {
+			c.table.Load(ranges)
+			return nil
+		}
+	}
+	return err
+}
+
+// A method that refreshes the slot cache in the background,
+// a refresh already running absorbs the request
+func (c *Cluster) refreshAsync() {
+	if !atomic.CompareAndSwapInt32(&c.refreshing, 0, 1) {
+		return
+	}
+	go func() {
+		defer atomic.StoreInt32(&c.refreshing, 0)
+		_ = c.Refresh()
+	}()
+}
+
+// Do runs the command on the node owning the slot of the key, a nil key
+// selects any node. A MOVED reply updates the slot cache and triggers a
+// refresh, an ASK reply is retried once on the target node after ASKING.
+func (c *Cluster) Do(key []byte, cmd string, args ...interface{}) (interface{}, error) {
+	addr := c.Addr(key)
+	asking := false
+	for i := 0; ; i++ {
+		start := time.Now()
+		reply, err := c.do(addr, asking, cmd, args...)
+		c.opt.observe(addr, cmd, start)
+		redirect, ok := slots.ParseRedirect(err)
+		if !ok {
+			if _, ok := err.(redis.Error); err != nil && !ok {
+				c.refreshAsync()
+			}
+			return reply, err
+		}
+		if i == maxRedirects {
+			return nil, errors.New("too many cluster redirects")
+		}
+		if !redirect.Ask {
+			c.table.Set(redirect.Slot, redirect.Addr)
+			c.refreshAsync()
+		}
+		addr, asking = redirect.Addr, redirect.Ask
+	}
+}
+
+// Addr returns the address of the node serving the key according to the slot cache
+func (c *Cluster) Addr(key []byte) string {
+	if key != nil {
+		if addr := c.table.Get(slots.Slot(key)); addr != "" {
+			return addr
+		}
+	}
+	if nodes := c.table.Nodes(); len(nodes) > 0 {
+		return nodes[0]
+	}
+	if len(c.seeds) > 0 {
+		return c.seeds[0]
+	}
+	return ""
+}
+
+// Options returns the connection options of the nodes
+func (c *Cluster) Options() Options {
+	return c.opt
+}
+
+// Nodes returns the addresses of the nodes serving slots
+func (c *Cluster) Nodes() []string {
+	return c.table.Nodes()
+}
+
+// Close closes the connection pools of the nodes
+func (c *Cluster) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var err error
+	for addr, pool := range c.pools {
+		if e := pool.Close(); e != nil {
+			err = e
+		}
+		delete(c.pools, addr)
+	}
+	return err
+}
+
+// A method that returns the pool of a node, creating it on first use
+func (c *Cluster) pool(addr string) *redis.Pool {
+	c.mu.RLock()
+	pool, ok := c.pools[addr]
+	c.mu.RUnlock()
+	if ok {
+		return pool
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if pool, ok = c.pools[addr]; !ok {
+		pool = NewPool(addr, c.opt)
+		c.pools[addr] = pool
+	}
+	return pool
+}
+
+func (c *Cluster) do(addr string, asking bool, cmd string, args ...interface{}) (interface{}, error) {
+	conn := c.pool(addr).Get()
+	defer conn.Close()
+	return doAsking(conn, asking, cmd, args...)
+}
+
+// A function that runs the command on the connection, preceded by ASKING
+// on the same connection when the command follows an ASK redirect
+func doAsking(conn redis.Conn, asking bool, cmd string, args ...interface{}) (interface{}, error) {
+	if !asking {
+		return conn.Do(cmd, args...)
+	}
+	if err := conn.Send("ASKING"); err != nil {
+		return nil, err
+	}
+	if err := conn.Send(cmd, args...); err != nil {
+		return nil, err
+	}
+	if err := conn.Flush(); err != nil {
+		return nil, err
+	}
+	if _, err := conn.Receive(); err != nil {
+		return nil, err
+	}
+	return conn.Receive()
+}
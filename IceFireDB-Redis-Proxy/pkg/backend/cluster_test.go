@@ -0,0 +1,171 @@
+/*
+ *
+ *  * Licensed to the Apache Software Foundation (ASF) under one or more
+ *  * contributor license agreements.  See the NOTICE file distributed with
+ *  * this work for additional information regarding copyright ownership.
+ *  * The ASF licenses this file to You under the Apache License, Version 2.0
+ *  * (the "License"); you may not use this file except in compliance with
+ *  * the License.  You may obtain a copy of the License at
+ *  *
+ *  *     http://www.apache.org/licenses/LICENSE-2.0
+ *  *
+ *  * Unless required by applicable law or agreed to in writing, software
+ *  * distributed under the License is distributed on an "AS IS" BASIS,
+ *  * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  * See the License for the specific language governing permissions and
+ *  * limitations under the License.
+ *
+ */
+
+package backend
+
+import (
+	"bufio"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/IceFireDB/IceFireDB/IceFireDB-Redis-Proxy/pkg/slots"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gomodule/redigo/redis"
+)
+
+// A function that runs a fake cluster node, handler returns the raw RESP
+// reply of each command and keeps its state per connection
+func fakeNode(t *testing.T, handler func(state map[string]bool, args []string) string) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+				state := make(map[string]bool)
+				for {
+					args, err := readCommand(r)
+					if err != nil {
+						return
+					}
+					if _, err := conn.Write([]byte(handler(state, args))); err != nil {
+						return
+					}
+				}
+			}()
+		}
+	}()
+	return l.Addr().String()
+}
+
+func readCommand(r *bufio.Reader) ([]string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	n, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+	args := make([]string, n)
+	for i := range args {
+		if _, err := r.ReadString('\n'); err != nil {
+			return nil, err
+		}
+		arg, err := r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		args[i] = strings.TrimSpace(arg)
+	}
+	return args, nil
+}
+
+func TestCluster(t *testing.T) {
+	s := miniredis.RunT(t)
+	c, err := NewCluster([]string{s.Addr()}, nil, testOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	if _, err := c.Do([]byte("k"), "SET", "k", "v"); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := redis.String(c.Do([]byte("k"), "GET", "k")); v != "v" {
+		t.Errorf("get error: %s", v)
+	}
+	if nodes := c.Nodes(); len(nodes) != 1 || nodes[0] != s.Addr() {
+		t.Errorf("nodes error: %v", nodes)
+	}
+	if keys, err := c.ScanKeys("*"); err != nil || len(keys) != 1 {
+		t.Errorf("scan keys error: %v, %v", keys, err)
+	}
+
+	if _, err := NewCluster([]string{"127.0.0.1:1"}, nil, testOptions); err == nil {
+		t.Error("unreachable cluster must fail without a static slot map")
+	}
+}
+
+func TestClusterMoved(t *testing.T) {
+	s := miniredis.RunT(t)
+	s.Set("k", "v")
+	slot := slots.Slot([]byte("k"))
+	// the address is taken once, the background refresh may outlive the server
+	addr := s.Addr()
+	moved := fakeNode(t, func(_ map[string]bool, args []string) string {
+		if args[0] == "CLUSTER" {
+			return "-ERR unknown command\r\n"
+		}
+		return "-MOVED " + strconv.Itoa(int(slot)) + " " + addr + "\r\n"
+	})
+
+	// static slot map pointing every slot to the wrong node
+	c, err := NewCluster([]string{moved}, []slots.Range{{Start: 0, End: slots.Count - 1, Addr: moved}}, testOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	if v, err := redis.String(c.Do([]byte("k"), "GET", "k")); err != nil || v != "v" {
+		t.Fatalf("MOVED must be followed, got: %s, %v", v, err)
+	}
+	if addr := c.Addr([]byte("k")); addr != s.Addr() {
+		t.Errorf("MOVED must update the slot cache, got: %s", addr)
+	}
+}
+
+func TestClusterAsk(t *testing.T) {
+	slot := strconv.Itoa(int(slots.Slot([]byte("k"))))
+	target := fakeNode(t, func(state map[string]bool, args []string) string {
+		switch {
+		case args[0] == "ASKING":
+			state["asking"] = true
+			return "+OK\r\n"
+		case state["asking"]:
+			state["asking"] = false
+			return "$1\r\nv\r\n"
+		}
+		return "-ERR not asking\r\n"
+	})
+	source := fakeNode(t, func(_ map[string]bool, args []string) string {
+		return "-ASK " + slot + " " + target + "\r\n"
+	})
+
+	c, err := NewCluster([]string{source}, []slots.Range{{Start: 0, End: slots.Count - 1, Addr: source}}, testOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	if v, err := redis.String(c.Do([]byte("k"), "GET", "k")); err != nil || v != "v" {
+		t.Fatalf("ASK must be followed with ASKING, got: %s, %v", v, err)
+	}
+	if addr := c.Addr([]byte("k")); addr != source {
+		t.Errorf("ASK must not update the slot cache, got: %s", addr)
+	}
+}
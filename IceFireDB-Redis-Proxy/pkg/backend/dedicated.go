@@ -0,0 +1,114 @@
+/*
+ *
+ *  * Licensed to the Apache Software Foundation (ASF) under one or more
+ *  * contributor license agreements.  See the NOTICE file distributed with
+ *  * this work for additional information regarding copyright ownership.
+ *  * The ASF licenses this file to You under the Apache License, Version 2.0
+ *  * (the "License"); you may not use this file except in compliance with
+ *  * the License.  You may obtain a copy of the License at
+ *  *
+ *  *     http://www.apache.org/licenses/LICENSE-2.0
+ *  *
+ *  * Unless required by applicable law or agreed to in writing, software
+ *  * distributed under the License is distributed on an "AS IS" BASIS,
+ *  * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  * See the License for the specific language governing permissions and
+ *  * limitations under the License.
+ *
+ */
+
+package backend
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/IceFireDB/IceFireDB/IceFireDB-Redis-Proxy/pkg/slots"
+	"github.com/gomodule/redigo/redis"
+)
+
+// Dedicated represents the connections dedicated to a client for the
+// blocking commands, which would otherwise hold a pooled connection for as
+// long as they block. There is one connection per backend, opened on first
+// use without read timeout and kept until the client goes away.
+type Dedicated struct {
+	mu     sync.Mutex
+	conns  map[string]redis.Conn
+	closed bool
+}
+
+// Do runs the command on the dedicated connection to the backend at addr.
+// MOVED and ASK redirects are followed on the dedicated connection of their
+// target, so the command also blocks on the right node of a cluster.
+func (d *Dedicated) Do(addr string, opt Options, cmd string, args ...interface{}) (interface{}, error) {
+	asking := false
+	for i := 0; ; i++ {
+		conn, err := d.conn(addr, opt)
+		if err != nil {
+			return nil, err
+		}
+		reply, err := doAsking(conn, asking, cmd, args...)
+		redirect, ok := slots.ParseRedirect(err)
+		if !ok {
+			if _, ok := err.(redis.Error); err != nil && !ok {
+				d.drop(addr, conn)
+			}
+			return reply, err
+		}
+		if i == maxRedirects {
+			return nil, errors.New("too many cluster redirects")
+		}
+		addr, asking = redirect.Addr, redirect.Ask
+	}
+}
+
+// Close closes the dedicated connections
+func (d *Dedicated) Close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.closed = true
+	var err error
+	for addr, conn := range d.conns {
+		if e := conn.Close(); e != nil {
+			err = e
+		}
+		delete(d.conns, addr)
+	}
+	return err
+}
+
+// A method that returns the dedicated connection to a backend, dialing it on first use
+func (d *Dedicated) conn(addr string, opt Options) (redis.Conn, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.closed {
+		return nil, errors.New("client closed")
+	}
+	if conn, ok := d.conns[addr]; ok {
+		return conn, nil
+	}
+	conn, err := Dial(addr, opt,
+		redis.DialConnectTimeout(opt.ConnTimeout),
+		redis.DialWriteTimeout(opt.WriteTimeout))
+	if err != nil {
+		return nil, err
+	}
+	if d.conns == nil {
+		d.conns = make(map[string]redis.Conn)
+	}
+	d.conns[addr] = conn
+	return conn, nil
+}
+
+// A method that closes a broken dedicated connection, the next command dials a new one
+func (d *Dedicated) drop(addr string, conn redis.Conn) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.conns[addr] == conn {
+		delete(d.conns, addr)
+	}
+	_ = conn.Close()
+}
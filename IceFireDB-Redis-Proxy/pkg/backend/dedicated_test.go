@@ -0,0 +1,134 @@
+/*
+ *
+ *  * Licensed to the Apache Software Foundation (ASF) under one or more
+ *  * contributor license agreements.  See the NOTICE file distributed with
+ *  * this work for additional information regarding copyright ownership.
+ *  * The ASF licenses this file to You under the Apache License, Version 2.0
+ *  * (the "License"); you may not use this file except in compliance with
+ *  * the License.  You may obtain a copy of the License at
+ *  *
+ *  *     http://www.apache.org/licenses/LICENSE-2.0
+ *  *
+ *  * Unless required by applicable law or agreed to in writing, software
+ *  * distributed under the License is distributed on an "AS IS" BASIS,
+ *  * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  * See the License for the specific language governing permissions and
+ *  * limitations under the License.
+ *
+ */
+
+package backend
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gomodule/redigo/redis"
+)
+
+func TestDedicatedBlocking(t *testing.T) {
+	s := miniredis.RunT(t)
+	var d Dedicated
+	defer d.Close()
+
+	done := make(chan []string, 1)
+	go func() {
+		reply, err := redis.Strings(d.Do(s.Addr(), testOptions, "BLPOP", "list", 0))
+		if err != nil {
+			t.Error(err)
+		}
+		done <- reply
+	}()
+
+	// the command blocks past the read timeout of the pools
+	time.Sleep(testOptions.ReadTimeout + 200*time.Millisecond)
+	if _, err := s.Lpush("list", "v"); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case reply := <-done:
+		if len(reply) != 2 || reply[0] != "list" || reply[1] != "v" {
+			t.Errorf("blpop reply error: %v", reply)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("blpop not unblocked")
+	}
+}
+
+func TestSubscriber(t *testing.T) {
+	s1 := miniredis.RunT(t)
+	s2 := miniredis.RunT(t)
+
+	replies := make(chan []interface{}, 10)
+	sub := NewSubscriber(func(reply interface{}) error {
+		replies <- reply.([]interface{})
+		return nil
+	}, nil)
+	defer sub.Close()
+
+	next := func() []interface{} {
+		select {
+		case reply := <-replies:
+			return reply
+		case <-time.After(time.Second):
+			t.Fatal("no reply")
+			return nil
+		}
+	}
+
+	if err := sub.Send(s1.Addr(), testOptions, "SUBSCRIBE", "a"); err != nil {
+		t.Fatal(err)
+	}
+	if reply := next(); string(reply[1].([]byte)) != "a" || reply[2] != int64(1) {
+		t.Errorf("subscribe reply error: %v", reply)
+	}
+	// a pattern made on both backends is confirmed once
+	for _, addr := range []string{s1.Addr(), s2.Addr()} {
+		if err := sub.Send(addr, testOptions, "PSUBSCRIBE", "b*"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if reply := next(); string(reply[1].([]byte)) != "b*" || reply[2] != int64(2) {
+		t.Errorf("psubscribe reply error: %v", reply)
+	}
+	// the dropped confirmation may still be on its way
+	for i := 0; i < 10 && len(sub.Addrs(true)) < 2; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if sub.Count() != 2 || len(sub.Addrs(false)) != 1 || len(sub.Addrs(true)) != 2 {
+		t.Errorf("subscriptions error: %d on %v and %v", sub.Count(), sub.Addrs(false), sub.Addrs(true))
+	}
+
+	s1.Publish("a", "one")
+	if reply := next(); string(reply[0].([]byte)) != "message" || string(reply[2].([]byte)) != "one" {
+		t.Errorf("message error: %v", reply)
+	}
+	s2.Publish("bb", "two")
+	if reply := next(); string(reply[0].([]byte)) != "pmessage" || string(reply[3].([]byte)) != "two" {
+		t.Errorf("pmessage error: %v", reply)
+	}
+
+	if err := sub.Send(s1.Addr(), testOptions, "UNSUBSCRIBE", "a"); err != nil {
+		t.Fatal(err)
+	}
+	if reply := next(); reply[2] != int64(1) {
+		t.Errorf("unsubscribe reply error: %v", reply)
+	}
+	for _, addr := range sub.Addrs(true) {
+		if err := sub.Send(addr, testOptions, "PUNSUBSCRIBE"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if reply := next(); string(reply[1].([]byte)) != "b*" || reply[2] != int64(0) {
+		t.Errorf("punsubscribe reply error: %v", reply)
+	}
+	select {
+	case reply := <-replies:
+		t.Errorf("unexpected reply: %v", reply)
+	case <-time.After(100 * time.Millisecond):
+	}
+	if sub.Count() != 0 {
+		t.Errorf("subscriptions left: %d", sub.Count())
+	}
+}
@@ -0,0 +1,260 @@
+/*
+ *
+ *  * Licensed to the Apache Software Foundation (ASF) under one or more
+ *  * contributor license agreements.  See the NOTICE file distributed with
+ *  * this work for additional information regarding copyright ownership.
+ *  * The ASF licenses this file to You under the Apache License, Version 2.0
+ *  * (the "License"); you may not use this file except in compliance with
+ *  * the License.  You may obtain a copy of the License at
+ *  *
+ *  *     http://www.apache.org/licenses/LICENSE-2.0
+ *  *
+ *  * Unless required by applicable law or agreed to in writing, software
+ *  * distributed under the License is distributed on an "AS IS" BASIS,
+ *  * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  * See the License for the specific language governing permissions and
+ *  * limitations under the License.
+ *
+ */
+
+package backend
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/IceFireDB/IceFireDB/IceFireDB-Redis-Proxy/pkg/tracing"
+)
+
+// SplitRules decides which commands are sent to the replicas of a group
+type SplitRules struct {
+	Enable bool
+	// Commands always sent to the primary, e.g. reads that must see their own writes
+	PrimaryCMDs map[string]bool
+	// Commands sent to the replicas even though they are not flagged read-only
+	ReplicaCMDs map[string]bool
+}
+
+// NewSplitRules creates the read/write split rules with the given overrides
+func NewSplitRules(enable bool, primaryCMDs, replicaCMDs []string) *SplitRules {
+	r := &SplitRules{
+		Enable:      enable,
+		PrimaryCMDs: make(map[string]bool, len(primaryCMDs)),
+		ReplicaCMDs: make(map[string]bool, len(replicaCMDs)),
+	}
+	for _, cmd := range primaryCMDs {
+		r.PrimaryCMDs[strings.ToUpper(cmd)] = true
+	}
+	for _, cmd := range replicaCMDs {
+		r.ReplicaCMDs[strings.ToUpper(cmd)] = true
+	}
+	return r
+}
+
+// FromReplica reports whether the command may be served by a replica
+func (r *SplitRules) FromReplica(cmd string, readOnly bool) bool {
+	if r == nil || !r.Enable || r.PrimaryCMDs[cmd] {
+		return false
+	}
+	return readOnly || r.ReplicaCMDs[cmd]
+}
+
+var (
+	// EjectFailures is the number of commands failing in a row on a node,
+	// for a reason other than a redis error reply, before it is ejected
+	EjectFailures = 3
+	// EjectRetry is how long a node ejected by its commands waits before a
+	// command tries it again, the health check restores it sooner
+	EjectRetry = 5 * time.Second
+)
+
+// The states of a node
+const (
+	nodeUp = iota
+	// ejected by the health check
+	nodeDownChecked
+	// ejected by its failed commands
+	nodeDownFailed
+)
+
+// Node represents a backend server and its connection pool
+type Node struct {
+	Addr string
+	Pool *redis.Pool
+
+	down    int32
+	fails   int
+	demoted bool
+	// the commands failed in a row, and the time a command tries the node
+	// again once they ejected it, in unix nanoseconds
+	errs    int32
+	retryAt int64
+}
+
+// Healthy reports whether the node is serving traffic
+func (n *Node) Healthy() bool {
+	return atomic.LoadInt32(&n.down) == nodeUp
+}
+
+// A method that counts a command failed on the node, ejecting it after
+// EjectFailures in a row
+func (n *Node) failed() {
+	retryAt := time.Now().Add(EjectRetry).UnixNano()
+	if atomic.AddInt32(&n.errs, 1) >= int32(EjectFailures) && atomic.CompareAndSwapInt32(&n.down, nodeUp, nodeDownFailed) {
+		atomic.StoreInt64(&n.retryAt, retryAt)
+	} else if atomic.LoadInt32(&n.down) == nodeDownFailed {
+		// the command trying the node again failed too
+		atomic.StoreInt64(&n.retryAt, retryAt)
+	}
+}
+
+// A method that counts a command served by the node, restoring it when its
+// failed commands ejected it
+func (n *Node) succeeded() {
+	atomic.StoreInt32(&n.errs, 0)
+	atomic.CompareAndSwapInt32(&n.down, nodeDownFailed, nodeUp)
+}
+
+// A method that reports whether a command tries the node ejected by its
+// commands again, one command every EjectRetry
+func (n *Node) retry() bool {
+	if atomic.LoadInt32(&n.down) != nodeDownFailed {
+		return false
+	}
+	now := time.Now().UnixNano()
+	at := atomic.LoadInt64(&n.retryAt)
+	return now >= at && atomic.CompareAndSwapInt64(&n.retryAt, at, now+int64(EjectRetry))
+}
+
+// Group represents a primary backend and its replicas
+type Group struct {
+	Split *SplitRules
+
+	opt      Options
+	mu       sync.RWMutex
+	primary  *Node
+	replicas []*Node
+	next     uint32
+}
+
+// NewGroup creates the pools of a primary backend and its replicas
+func NewGroup(primary string, replicas []string, opt Options, split *SplitRules) *Group {
+	g := &Group{
+		Split:   split,
+		opt:     opt,
+		primary: &Node{Addr: primary, Pool: NewPool(primary, opt)},
+	}
+	for _, addr := range replicas {
+		g.replicas = append(g.replicas, &Node{Addr: addr, Pool: NewPool(addr, opt)})
+	}
+	return g
+}
+
+// Primary returns the primary node of the group
+func (g *Group) Primary() *Node {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	return g.primary
+}
+
+// Options returns the connection options of the nodes of the group
+func (g *Group) Options() Options {
+	return g.opt
+}
+
+// Replicas returns the replica nodes of the group
+func (g *Group) Replicas() []*Node {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	return append([]*Node(nil), g.replicas...)
+}
+
+// Do runs the command on a replica when the split rules allow it, and on
+// the primary otherwise. Healthy replicas are used in turn, a command failing
+// on a replica for a reason other than a redis error reply is retried on the
+// primary. A node failing that way EjectFailures times in a row is ejected
+// until a health check succeeds, or a command tried on it after EjectRetry.
+func (g *Group) Do(cmd string, readOnly bool, args ...interface{}) (interface{}, error) {
+	return g.DoContext(context.Background(), cmd, readOnly, args...)
+}
+
+// DoContext runs the command like Do, in a span of the trace of ctx when
+// the commands are traced
+func (g *Group) DoContext(ctx context.Context, cmd string, readOnly bool, args ...interface{}) (interface{}, error) {
+	g.mu.RLock()
+	primary, replicas := g.primary, g.replicas
+	g.mu.RUnlock()
+
+	if len(replicas) > 0 && g.Split.FromReplica(cmd, readOnly) {
+		if replica := g.nextReplica(replicas); replica != nil {
+			reply, err := do(ctx, replica, g.opt, cmd, readOnly, args...)
+			if _, ok := err.(redis.Error); err == nil || ok {
+				return reply, err
+			}
+		}
+	}
+	return do(ctx, primary, g.opt, cmd, readOnly, args...)
+}
+
+// A method that returns the next healthy replica, or an ejected one due to
+// be tried again, nil when they are all down
+func (g *Group) nextReplica(replicas []*Node) *Node {
+	start := atomic.AddUint32(&g.next, 1)
+	for i := range replicas {
+		replica := replicas[(start+uint32(i))%uint32(len(replicas))]
+		if replica.Healthy() || replica.retry() {
+			return replica
+		}
+	}
+	return nil
+}
+
+// Close closes the pools of the group
+func (g *Group) Close() error {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	err := g.primary.Pool.Close()
+	for _, n := range g.replicas {
+		if e := n.Pool.Close(); e != nil {
+			err = e
+		}
+	}
+	return err
+}
+
+func do(ctx context.Context, n *Node, opt Options, cmd string, readOnly bool, args ...interface{}) (interface{}, error) {
+	defer opt.observe(n.Addr, cmd, time.Now())
+	var span trace.Span
+	if tracing.Enabled() {
+		ctx, span = tracing.Tracer().Start(ctx, "backend "+cmd,
+			trace.WithSpanKind(trace.SpanKindClient),
+			trace.WithAttributes(semconv.DBSystemRedis, semconv.DBOperationName(cmd), semconv.ServerAddress(n.Addr)))
+		defer span.End()
+		if opt.Propagate {
+			cmd, args = tracing.Wrap(ctx, cmd, readOnly, args)
+		}
+	}
+	conn := n.Pool.Get()
+	defer conn.Close()
+
+	reply, err := conn.Do(cmd, args...)
+	if _, ok := err.(redis.Error); err != nil && !ok {
+		n.failed()
+	} else {
+		n.succeeded()
+	}
+	if err != nil && span != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	return reply, err
+}
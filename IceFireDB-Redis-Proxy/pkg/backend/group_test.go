@@ -0,0 +1,224 @@
+/*
+ *
+ *  * Licensed to the Apache Software Foundation (ASF) under one or more
+ *  * contributor license agreements.  See the NOTICE file distributed with
+ *  * this work for additional information regarding copyright ownership.
+ *  * The ASF licenses this file to You under the Apache License, Version 2.0
+ *  * (the "License"); you may not use this file except in compliance with
+ *  * the License.  You may obtain a copy of the License at
+ *  *
+ *  *     http://www.apache.org/licenses/LICENSE-2.0
+ *  *
+ *  * Unless required by applicable law or agreed to in writing, software
+ *  * distributed under the License is distributed on an "AS IS" BASIS,
+ *  * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  * See the License for the specific language governing permissions and
+ *  * limitations under the License.
+ *
+ */
+
+package backend
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gomodule/redigo/redis"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+
+	"github.com/IceFireDB/IceFireDB/IceFireDB-Redis-Proxy/pkg/tracing"
+)
+
+var testOptions = Options{
+	ConnTimeout:  time.Second,
+	ReadTimeout:  time.Second,
+	WriteTimeout: time.Second,
+	PoolSize:     2,
+}
+
+func TestSplitRules(t *testing.T) {
+	rules := NewSplitRules(true, []string{"get"}, []string{"EVAL"})
+	list := []struct {
+		cmd      string
+		readOnly bool
+		replica  bool
+	}{
+		{"STRLEN", true, true},
+		{"GET", true, false},
+		{"EVAL", false, true},
+		{"SET", false, false},
+	}
+	for _, v := range list {
+		if rules.FromReplica(v.cmd, v.readOnly) != v.replica {
+			t.Errorf("split rule error, cmd: %s, want replica: %v", v.cmd, v.replica)
+		}
+	}
+	if NewSplitRules(false, nil, nil).FromReplica("STRLEN", true) {
+		t.Error("disabled split must use the primary")
+	}
+}
+
+func TestGroup(t *testing.T) {
+	primary := miniredis.RunT(t)
+	replica := miniredis.RunT(t)
+	primary.Set("k", "primary")
+	replica.Set("k", "replica")
+
+	g := NewGroup(primary.Addr(), []string{replica.Addr()}, testOptions, NewSplitRules(true, nil, nil))
+	defer g.Close()
+
+	if v, _ := redis.String(g.Do("GET", true, "k")); v != "replica" {
+		t.Errorf("read must be served by the replica, got: %s", v)
+	}
+	if _, err := g.Do("SET", false, "k", "new"); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := primary.Get("k"); v != "new" {
+		t.Errorf("write must be sent to the primary, got: %s", v)
+	}
+
+	replica.Close()
+	if v, err := redis.String(g.Do("GET", true, "k")); err != nil || v != "new" {
+		t.Errorf("read must fall back to the primary, got: %s, %v", v, err)
+	}
+}
+
+func TestGroupEject(t *testing.T) {
+	defer func(d time.Duration) { EjectRetry = d }(EjectRetry)
+	EjectRetry = 100 * time.Millisecond
+	primary := miniredis.RunT(t)
+	replica := miniredis.RunT(t)
+	primary.Set("k", "primary")
+	replica.Set("k", "replica")
+
+	g := NewGroup(primary.Addr(), []string{replica.Addr()}, testOptions, NewSplitRules(true, nil, nil))
+	defer g.Close()
+
+	replica.Close()
+	for i := 1; i <= EjectFailures; i++ {
+		if v, err := redis.String(g.Do("GET", true, "k")); err != nil || v != "primary" {
+			t.Fatalf("read must fall back to the primary, got: %s, %v", v, err)
+		}
+		if healthy := g.Replicas()[0].Healthy(); healthy != (i < EjectFailures) {
+			t.Fatalf("after %d failed reads the replica is healthy: %v", i, healthy)
+		}
+	}
+
+	// without health check, a read tries the replica again after EjectRetry
+	if err := replica.Restart(); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := redis.String(g.Do("GET", true, "k")); v != "primary" {
+		t.Errorf("read must skip the ejected replica, got: %s", v)
+	}
+	time.Sleep(EjectRetry)
+	if v, _ := redis.String(g.Do("GET", true, "k")); v != "replica" {
+		t.Errorf("read must try the replica again, got: %s", v)
+	}
+	if !g.Replicas()[0].Healthy() {
+		t.Error("replica must be restored by the read it served")
+	}
+}
+
+func TestGroupHealthCheck(t *testing.T) {
+	primary := miniredis.RunT(t)
+	replica := miniredis.RunT(t)
+	replica.Set("k", "replica")
+	replicaAddr := replica.Addr()
+
+	g := NewGroup(primary.Addr(), []string{replicaAddr}, testOptions, NewSplitRules(true, nil, nil))
+	defer g.Close()
+
+	var down, up []string
+	opt := HealthOptions{
+		Timeout:  100 * time.Millisecond,
+		Failures: 2,
+		OnDown:   func(addr string) { down = append(down, addr) },
+		OnUp:     func(addr string) { up = append(up, addr) },
+	}
+
+	replica.Close()
+	g.Check(opt)
+	if !g.Replicas()[0].Healthy() || len(down) != 0 {
+		t.Error("replica must be kept until the failure threshold")
+	}
+	g.Check(opt)
+	if g.Replicas()[0].Healthy() || len(down) != 1 || down[0] != replicaAddr {
+		t.Errorf("replica must be ejected, down: %v", down)
+	}
+	primary.Set("k", "primary")
+	if v, err := redis.String(g.Do("GET", true, "k")); err != nil || v != "primary" {
+		t.Errorf("read must skip the ejected replica, got: %s, %v", v, err)
+	}
+
+	if err := replica.Restart(); err != nil {
+		t.Fatal(err)
+	}
+	g.Check(opt)
+	if !g.Replicas()[0].Healthy() || len(up) != 1 {
+		t.Errorf("replica must be restored, up: %v", up)
+	}
+}
+
+func TestGroupScanKeys(t *testing.T) {
+	s := miniredis.RunT(t)
+	for i := 0; i < 2500; i++ {
+		s.Set("user:"+strconv.Itoa(i), "v")
+	}
+	s.Set("other", "v")
+
+	g := NewGroup(s.Addr(), nil, testOptions, nil)
+	defer g.Close()
+
+	keys, err := g.ScanKeys("user:*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 2500 {
+		t.Errorf("scan keys error, got %d keys, want 2500", len(keys))
+	}
+}
+
+func TestGroupObserve(t *testing.T) {
+	primary := miniredis.RunT(t)
+	observed := make(map[string]string)
+	opt := testOptions
+	opt.Observe = func(addr, cmd string, d time.Duration) {
+		observed[cmd] = addr
+	}
+	g := NewGroup(primary.Addr(), nil, opt, NewSplitRules(false, nil, nil))
+	defer g.Close()
+
+	if _, err := g.Do("SET", false, "k", "v"); err != nil {
+		t.Fatal(err)
+	}
+	if observed["SET"] != primary.Addr() {
+		t.Errorf("upstream time must be observed, got: %v", observed)
+	}
+}
+
+func TestGroupTrace(t *testing.T) {
+	primary := miniredis.RunT(t)
+	rec := tracetest.NewSpanRecorder()
+	tracing.SetProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(rec)))
+
+	g := NewGroup(primary.Addr(), nil, testOptions, nil)
+	defer g.Close()
+
+	ctx, parent := tracing.Tracer().Start(context.Background(), "SET")
+	if _, err := g.DoContext(ctx, "SET", false, "k", "v"); err != nil {
+		t.Fatal(err)
+	}
+	parent.End()
+	spans := rec.Ended()
+	if len(spans) != 2 || spans[0].Name() != "backend SET" {
+		t.Fatalf("spans %v", spans)
+	}
+	if spans[0].Parent().SpanID() != parent.SpanContext().SpanID() {
+		t.Error("the backend span must be a child of the command span")
+	}
+}
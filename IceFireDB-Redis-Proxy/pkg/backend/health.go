@@ -0,0 +1,164 @@
+/*
+ *
+ *  * Licensed to the Apache Software Foundation (ASF) under one or more
+ *  * contributor license agreements.  See the NOTICE file distributed with
+ *  * this work for additional information regarding copyright ownership.
+ *  * The ASF licenses this file to You under the Apache License, Version 2.0
+ *  * (the "License"); you may not use this file except in compliance with
+ *  * the License.  You may obtain a copy of the License at
+ *  *
+ *  *     http://www.apache.org/licenses/LICENSE-2.0
+ *  *
+ *  * Unless required by applicable law or agreed to in writing, software
+ *  * distributed under the License is distributed on an "AS IS" BASIS,
+ *  * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  * See the License for the specific language governing permissions and
+ *  * limitations under the License.
+ *
+ */
+
+package backend
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// HealthOptions represents the active health check of a group
+type HealthOptions struct {
+	Interval time.Duration
+	// Timeout of a probe, covering the dial and the PING
+	Timeout time.Duration
+	// Consecutive failed probes before a node is ejected
+	Failures int
+	// Promote a healthy replica with REPLICAOF NO ONE when the primary is ejected,
+	// the old primary is made a replica of the new one once it is back
+	Promote bool
+
+	OnDown     func(addr string)
+	OnUp       func(addr string)
+	OnFailover func(oldPrimary, newPrimary string)
+}
+
+// StartHealthCheck probes the nodes of the group every interval until the context is done
+func (g *Group) StartHealthCheck(ctx context.Context, opt HealthOptions) {
+	if opt.Failures <= 0 {
+		opt.Failures = 1
+	}
+	go func() {
+		ticker := time.NewTicker(opt.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				g.Check(opt)
+			}
+		}
+	}()
+}
+
+// Check runs one round of probes on the nodes of the group, ejecting the
+// failed nodes, restoring the recovered ones and failing over the primary
+func (g *Group) Check(opt HealthOptions) {
+	g.mu.RLock()
+	nodes := append([]*Node{g.primary}, g.replicas...)
+	g.mu.RUnlock()
+
+	for _, n := range nodes {
+		if err := g.probe(n, opt.Timeout); err != nil {
+			n.fails++
+			if n.fails == opt.Failures {
+				atomic.StoreInt32(&n.down, nodeDownChecked)
+				if opt.OnDown != nil {
+					opt.OnDown(n.Addr)
+				}
+			}
+			continue
+		}
+
+		n.fails = 0
+		if n.Healthy() {
+			continue
+		}
+		if n.demoted && opt.Promote {
+			if err := g.follow(n, opt.Timeout); err != nil {
+				continue
+			}
+		}
+		atomic.StoreInt32(&n.errs, 0)
+		atomic.StoreInt32(&n.down, nodeUp)
+		if opt.OnUp != nil {
+			opt.OnUp(n.Addr)
+		}
+	}
+
+	if primary := g.Primary(); primary.fails >= opt.Failures && opt.Promote {
+		g.failover(primary, opt)
+	}
+}
+
+// A method that promotes the first healthy replica to replace the primary
+func (g *Group) failover(old *Node, opt HealthOptions) {
+	for _, n := range g.Replicas() {
+		if !n.Healthy() {
+			continue
+		}
+		if _, err := g.doWithTimeout(n, opt.Timeout, "REPLICAOF", "NO", "ONE"); err != nil {
+			continue
+		}
+
+		g.mu.Lock()
+		replicas := make([]*Node, 0, len(g.replicas))
+		for _, r := range g.replicas {
+			if r != n {
+				replicas = append(replicas, r)
+			}
+		}
+		old.demoted = true
+		g.primary, g.replicas = n, append(replicas, old)
+		g.mu.Unlock()
+
+		if opt.OnFailover != nil {
+			opt.OnFailover(old.Addr, n.Addr)
+		}
+		return
+	}
+}
+
+// A method that makes a recovered former primary a replica of the current primary
+func (g *Group) follow(n *Node, timeout time.Duration) error {
+	host, port, err := net.SplitHostPort(g.Primary().Addr)
+	if err != nil {
+		return err
+	}
+	if _, err := g.doWithTimeout(n, timeout, "REPLICAOF", host, port); err != nil {
+		return err
+	}
+	n.demoted = false
+	return nil
+}
+
+// A method that dials the node on a new connection and sends a PING
+func (g *Group) probe(n *Node, timeout time.Duration) error {
+	_, err := g.doWithTimeout(n, timeout, "PING")
+	return err
+}
+
+func (g *Group) doWithTimeout(n *Node, timeout time.Duration, cmd string, args ...interface{}) (interface{}, error) {
+	conn, err := Dial(n.Addr, g.opt,
+		redis.DialConnectTimeout(timeout),
+		redis.DialReadTimeout(timeout),
+		redis.DialWriteTimeout(timeout))
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	return conn.Do(cmd, args...)
+}
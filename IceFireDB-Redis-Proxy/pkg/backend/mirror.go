@@ -0,0 +1,147 @@
+/*
+ *
+ *  * Licensed to the Apache Software Foundation (ASF) under one or more
+ *  * contributor license agreements.  See the NOTICE file distributed with
+ *  * this work for additional information regarding copyright ownership.
+ *  * The ASF licenses this file to You under the Apache License, Version 2.0
+ *  * (the "License"); you may not use this file except in compliance with
+ *  * the License.  You may obtain a copy of the License at
+ *  *
+ *  *     http://www.apache.org/licenses/LICENSE-2.0
+ *  *
+ *  * Unless required by applicable law or agreed to in writing, software
+ *  * distributed under the License is distributed on an "AS IS" BASIS,
+ *  * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  * See the License for the specific language governing permissions and
+ *  * limitations under the License.
+ *
+ */
+
+package backend
+
+import (
+	"hash/fnv"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// Mirror duplicates commands to a shadow backend, e.g. an IceFireDB cluster
+// being validated against the production redis. The commands are queued and
+// sent by a few workers, the replies are ignored and commands arriving while
+// the queue is full are dropped, so the shadow never slows the clients down.
+type Mirror struct {
+	pool    *redis.Pool
+	percent float64
+	queue   chan mirrored
+	wg      sync.WaitGroup
+
+	mu     sync.RWMutex
+	closed bool
+
+	sent    uint64
+	failed  uint64
+	dropped uint64
+}
+
+type mirrored struct {
+	cmd  string
+	args []interface{}
+}
+
+// MirrorStats represents the counters of a mirror
+type MirrorStats struct {
+	// Commands sent to the shadow backend, failed ones included
+	Sent uint64 `json:"sent"`
+	// Commands the shadow backend failed or answered with an error
+	Failed uint64 `json:"failed"`
+	// Commands dropped because the queue was full
+	Dropped uint64 `json:"dropped"`
+}
+
+// NewMirror creates a mirror to the shadow backend at addr. percent is the
+// share of the keys mirrored, every command on a mirrored key is duplicated
+// so the shadow holds complete keys.
+func NewMirror(addr string, opt Options, percent float64, queueSize, workers int) *Mirror {
+	opt.PoolSize = workers
+	m := &Mirror{
+		pool:    NewPool(addr, opt),
+		percent: percent,
+		queue:   make(chan mirrored, queueSize),
+	}
+	m.wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go m.work()
+	}
+	return m
+}
+
+// Sampled reports whether the commands on the key are mirrored,
+// commands without key are sampled at random
+func (m *Mirror) Sampled(key []byte) bool {
+	if m.percent >= 100 {
+		return true
+	}
+	if key == nil {
+		return rand.Float64()*100 < m.percent
+	}
+	h := fnv.New32a()
+	_, _ = h.Write(key)
+	return float64(h.Sum32()%10000) < m.percent*100
+}
+
+// Send queues a command for the shadow backend, the arguments must not be
+// modified afterwards. It never blocks, the command is dropped when the
+// queue is full.
+func (m *Mirror) Send(cmd string, args ...interface{}) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if m.closed {
+		return
+	}
+	select {
+	case m.queue <- mirrored{cmd: cmd, args: args}:
+	default:
+		atomic.AddUint64(&m.dropped, 1)
+	}
+}
+
+// Stats returns the counters of the mirror
+func (m *Mirror) Stats() MirrorStats {
+	return MirrorStats{
+		Sent:    atomic.LoadUint64(&m.sent),
+		Failed:  atomic.LoadUint64(&m.failed),
+		Dropped: atomic.LoadUint64(&m.dropped),
+	}
+}
+
+// Close sends the queued commands and closes the connections to the shadow backend
+func (m *Mirror) Close() error {
+	m.mu.Lock()
+	if m.closed {
+		m.mu.Unlock()
+		return nil
+	}
+	m.closed = true
+	close(m.queue)
+	m.mu.Unlock()
+
+	m.wg.Wait()
+	return m.pool.Close()
+}
+
+// A method that sends the queued commands until the mirror is closed
+func (m *Mirror) work() {
+	defer m.wg.Done()
+	for c := range m.queue {
+		conn := m.pool.Get()
+		if _, err := conn.Do(c.cmd, c.args...); err != nil && err != redis.ErrNil {
+			atomic.AddUint64(&m.failed, 1)
+		}
+		_ = conn.Close()
+		atomic.AddUint64(&m.sent, 1)
+	}
+}
@@ -0,0 +1,70 @@
+/*
+ *
+ *  * Licensed to the Apache Software Foundation (ASF) under one or more
+ *  * contributor license agreements.  See the NOTICE file distributed with
+ *  * this work for additional information regarding copyright ownership.
+ *  * The ASF licenses this file to You under the Apache License, Version 2.0
+ *  * (the "License"); you may not use this file except in compliance with
+ *  * the License.  You may obtain a copy of the License at
+ *  *
+ *  *     http://www.apache.org/licenses/LICENSE-2.0
+ *  *
+ *  * Unless required by applicable law or agreed to in writing, software
+ *  * distributed under the License is distributed on an "AS IS" BASIS,
+ *  * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  * See the License for the specific language governing permissions and
+ *  * limitations under the License.
+ *
+ */
+
+package backend
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+func TestMirror(t *testing.T) {
+	s := miniredis.RunT(t)
+	m := NewMirror(s.Addr(), testOptions, 100, 16, 2)
+
+	for i := 0; i < 10; i++ {
+		m.Send("SET", "k"+strconv.Itoa(i), "v")
+	}
+	m.Send("NOTACOMMAND")
+	if err := m.Close(); err != nil {
+		t.Fatal(err)
+	}
+	m.Send("SET", "closed", "v")
+
+	for i := 0; i < 10; i++ {
+		if v, err := s.Get("k" + strconv.Itoa(i)); err != nil || v != "v" {
+			t.Errorf("mirrored key k%d error: %s, %v", i, v, err)
+		}
+	}
+	if s.Exists("closed") {
+		t.Error("closed mirror must not send")
+	}
+	if stats := m.Stats(); stats.Sent != 11 || stats.Failed != 1 || stats.Dropped != 0 {
+		t.Errorf("stats error: %+v", stats)
+	}
+}
+
+func TestMirrorSampled(t *testing.T) {
+	m := &Mirror{percent: 25}
+	n := 0
+	for i := 0; i < 10000; i++ {
+		key := []byte("key:" + strconv.Itoa(i))
+		if m.Sampled(key) {
+			n++
+			if !m.Sampled(key) {
+				t.Fatal("sampling must be stable by key")
+			}
+		}
+	}
+	if n < 2000 || n > 3000 {
+		t.Errorf("sampled %d keys out of 10000 at 25%%", n)
+	}
+}
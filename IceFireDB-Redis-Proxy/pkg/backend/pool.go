@@ -0,0 +1,83 @@
+/*
+ *
+ *  * Licensed to the Apache Software Foundation (ASF) under one or more
+ *  * contributor license agreements.  See the NOTICE file distributed with
+ *  * this work for additional information regarding copyright ownership.
+ *  * The ASF licenses this file to You under the Apache License, Version 2.0
+ *  * (the "License"); you may not use this file except in compliance with
+ *  * the License.  You may obtain a copy of the License at
+ *  *
+ *  *     http://www.apache.org/licenses/LICENSE-2.0
+ *  *
+ *  * Unless required by applicable law or agreed to in writing, software
+ *  * distributed under the License is distributed on an "AS IS" BASIS,
+ *  * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  * See the License for the specific language governing permissions and
+ *  * limitations under the License.
+ *
+ */
+
+package backend
+
+import (
+	"crypto/tls"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// Options represents the connection options of a backend pool
+type Options struct {
+	ConnTimeout  time.Duration
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+	IdleTimeout  time.Duration
+	// Maximum number of idle connections, 0 indicates the short connection
+	PoolSize int
+	// TLS of the connections, nil for plain TCP
+	TLS *tls.Config
+	// Observe is called with the time a backend took to answer a command,
+	// pool wait included, nil disables it
+	Observe func(addr, cmd string, d time.Duration)
+	// Propagate prefixes the traced commands with their trace context, the
+	// backends must be IceFireDB servers
+	Propagate bool
+}
+
+// A method that reports the time since start to the Observe hook
+func (opt Options) observe(addr, cmd string, start time.Time) {
+	if opt.Observe != nil {
+		opt.Observe(addr, cmd, time.Since(start))
+	}
+}
+
+// Dial connects to the redis backend at addr with the TLS of the options,
+// the other dial options such as the timeouts are given by the caller
+func Dial(addr string, opt Options, options ...redis.DialOption) (redis.Conn, error) {
+	if opt.TLS != nil {
+		options = append(options, redis.DialUseTLS(true), redis.DialTLSConfig(opt.TLS))
+	}
+	return redis.Dial("tcp", addr, options...)
+}
+
+// NewPool creates a connection pool to the redis backend at addr
+func NewPool(addr string, opt Options) *redis.Pool {
+	return &redis.Pool{
+		MaxIdle:     opt.PoolSize,
+		IdleTimeout: opt.IdleTimeout,
+		Dial: func() (redis.Conn, error) {
+			c, err := Dial(addr, opt,
+				redis.DialConnectTimeout(opt.ConnTimeout),
+				redis.DialReadTimeout(opt.ReadTimeout),
+				redis.DialWriteTimeout(opt.WriteTimeout))
+			if err != nil {
+				return nil, err
+			}
+			return c, err
+		},
+		TestOnBorrow: func(c redis.Conn, t time.Time) error {
+			_, err := c.Do("PING")
+			return err
+		},
+	}
+}
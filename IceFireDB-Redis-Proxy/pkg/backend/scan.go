@@ -0,0 +1,67 @@
+/*
+ *
+ *  * Licensed to the Apache Software Foundation (ASF) under one or more
+ *  * contributor license agreements.  See the NOTICE file distributed with
+ *  * this work for additional information regarding copyright ownership.
+ *  * The ASF licenses this file to You under the Apache License, Version 2.0
+ *  * (the "License"); you may not use this file except in compliance with
+ *  * the License.  You may obtain a copy of the License at
+ *  *
+ *  *     http://www.apache.org/licenses/LICENSE-2.0
+ *  *
+ *  * Unless required by applicable law or agreed to in writing, software
+ *  * distributed under the License is distributed on an "AS IS" BASIS,
+ *  * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  * See the License for the specific language governing permissions and
+ *  * limitations under the License.
+ *
+ */
+
+package backend
+
+import "github.com/gomodule/redigo/redis"
+
+// Keys returned by each SCAN call
+const scanCount = 1000
+
+// ScanKeys returns the keys matching the pattern like KEYS, walking the
+// keyspace with SCAN so the backend is never blocked for long
+func (g *Group) ScanKeys(pattern interface{}) ([]interface{}, error) {
+	return scanKeys(func(args ...interface{}) (interface{}, error) {
+		return g.Do("SCAN", true, args...)
+	}, pattern)
+}
+
+// ScanKeys returns the keys matching the pattern on every node of the cluster
+func (c *Cluster) ScanKeys(pattern interface{}) ([]interface{}, error) {
+	var keys []interface{}
+	for _, addr := range c.Nodes() {
+		nodeKeys, err := scanKeys(func(args ...interface{}) (interface{}, error) {
+			return c.do(addr, false, "SCAN", args...)
+		}, pattern)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, nodeKeys...)
+	}
+	return keys, nil
+}
+
+func scanKeys(scan func(args ...interface{}) (interface{}, error), pattern interface{}) ([]interface{}, error) {
+	var keys []interface{}
+	cursor := "0"
+	for {
+		reply, err := redis.Values(scan(cursor, "MATCH", pattern, "COUNT", scanCount))
+		if err != nil {
+			return nil, err
+		}
+		var page []interface{}
+		if _, err := redis.Scan(reply, &cursor, &page); err != nil {
+			return nil, err
+		}
+		keys = append(keys, page...)
+		if cursor == "0" {
+			return keys, nil
+		}
+	}
+}
@@ -0,0 +1,217 @@
+/*
+ *
+ *  * Licensed to the Apache Software Foundation (ASF) under one or more
+ *  * contributor license agreements.  See the NOTICE file distributed with
+ *  * this work for additional information regarding copyright ownership.
+ *  * The ASF licenses this file to You under the Apache License, Version 2.0
+ *  * (the "License"); you may not use this file except in compliance with
+ *  * the License.  You may obtain a copy of the License at
+ *  *
+ *  *     http://www.apache.org/licenses/LICENSE-2.0
+ *  *
+ *  * Unless required by applicable law or agreed to in writing, software
+ *  * distributed under the License is distributed on an "AS IS" BASIS,
+ *  * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  * See the License for the specific language governing permissions and
+ *  * limitations under the License.
+ *
+ */
+
+package backend
+
+import (
+	"errors"
+	"strings"
+	"sync"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// Subscriber fans in the subscriptions of a client. A dedicated connection is
+// opened to every backend the client subscribes on, and the replies and
+// messages of all of them are written to the client in arrival order.
+// A channel or pattern subscribed on several backends is confirmed to the
+// client once, and the subscription counts are those of the whole client.
+type Subscriber struct {
+	write   func(reply interface{}) error
+	onError func(err error)
+
+	// mu guards the connections and serializes the writes to the client
+	mu     sync.Mutex
+	conns  map[string]*subConn
+	names  map[subName]int
+	closed bool
+}
+
+// subName identifies a channel or a pattern subscription
+type subName struct {
+	pattern bool
+	name    string
+}
+
+type subConn struct {
+	conn  redis.Conn
+	names map[subName]bool
+}
+
+// NewSubscriber creates the subscriber of a client. write sends a reply, an
+// array or a redis error, to the client. onError is called when a backend
+// connection is lost, the subscriptions made on it are gone by then.
+func NewSubscriber(write func(reply interface{}) error, onError func(err error)) *Subscriber {
+	return &Subscriber{
+		write:   write,
+		onError: onError,
+		conns:   make(map[string]*subConn),
+		names:   make(map[subName]int),
+	}
+}
+
+// Send sends a subscription command on the connection to the backend at addr,
+// dialing it on first use. The replies are written to the client as they come.
+func (s *Subscriber) Send(addr string, opt Options, cmd string, args ...interface{}) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return errors.New("client closed")
+	}
+	sc, ok := s.conns[addr]
+	if !ok {
+		conn, err := Dial(addr, opt,
+			redis.DialConnectTimeout(opt.ConnTimeout),
+			redis.DialWriteTimeout(opt.WriteTimeout))
+		if err != nil {
+			return err
+		}
+		sc = &subConn{conn: conn, names: make(map[subName]bool)}
+		s.conns[addr] = sc
+		go s.receive(sc)
+	}
+	if err := sc.conn.Send(cmd, args...); err != nil {
+		return err
+	}
+	return sc.conn.Flush()
+}
+
+// Count returns the number of channels and patterns the client is subscribed to,
+// the client is in subscribe mode while it is not zero
+func (s *Subscriber) Count() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return int64(len(s.names))
+}
+
+// Addrs returns the addresses of the backends the client has channel
+// subscriptions on, or pattern subscriptions when pattern is set
+func (s *Subscriber) Addrs(pattern bool) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var addrs []string
+	for addr, sc := range s.conns {
+		for n := range sc.names {
+			if n.pattern == pattern {
+				addrs = append(addrs, addr)
+				break
+			}
+		}
+	}
+	return addrs
+}
+
+// WithLock runs fn while no message is written to the client,
+// for the replies written to a client in subscribe mode by the proxy itself
+func (s *Subscriber) WithLock(fn func() error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return fn()
+}
+
+// Close closes the backend connections, dropping the subscriptions
+func (s *Subscriber) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.closed = true
+	var err error
+	for addr, sc := range s.conns {
+		if e := sc.conn.Close(); e != nil {
+			err = e
+		}
+		delete(s.conns, addr)
+	}
+	return err
+}
+
+// A method that forwards the replies of a backend connection to the client until it fails
+func (s *Subscriber) receive(sc *subConn) {
+	for {
+		reply, err := sc.conn.Receive()
+		if rerr, ok := err.(redis.Error); ok {
+			s.forward(sc, rerr)
+			continue
+		}
+		if err != nil {
+			s.mu.Lock()
+			closed := s.closed
+			s.mu.Unlock()
+			if !closed && s.onError != nil {
+				s.onError(err)
+			}
+			return
+		}
+		s.forward(sc, reply)
+	}
+}
+
+// A method that writes a reply to the client. The confirmation of a
+// subscription already made on another backend, or of an unsubscription
+// leaving it on another backend, is dropped.
+func (s *Subscriber) forward(sc *subConn, reply interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return
+	}
+	if msg, ok := reply.([]interface{}); ok && len(msg) == 3 {
+		if _, ok := msg[2].(int64); ok {
+			kind, _ := msg[0].([]byte)
+			if !s.track(sc, strings.ToLower(string(kind)), msg[1]) {
+				return
+			}
+			msg[2] = int64(len(s.names))
+		}
+	}
+	_ = s.write(reply)
+}
+
+// A method that records a subscription reply of a connection
+// and reports whether it must be forwarded to the client
+func (s *Subscriber) track(sc *subConn, kind string, name interface{}) bool {
+	b, ok := name.([]byte)
+	if !ok {
+		// an unsubscription from everything while not subscribed
+		return true
+	}
+	n := subName{pattern: strings.HasPrefix(kind, "p"), name: string(b)}
+	switch kind {
+	case "subscribe", "psubscribe":
+		if sc.names[n] {
+			return true
+		}
+		sc.names[n] = true
+		s.names[n]++
+		return s.names[n] == 1
+	case "unsubscribe", "punsubscribe":
+		if !sc.names[n] {
+			return s.names[n] == 0
+		}
+		delete(sc.names, n)
+		if s.names[n]--; s.names[n] > 0 {
+			return false
+		}
+		delete(s.names, n)
+	}
+	return true
+}
@@ -0,0 +1,200 @@
+/*
+ *
+ *  * Licensed to the Apache Software Foundation (ASF) under one or more
+ *  * contributor license agreements.  See the NOTICE file distributed with
+ *  * this work for additional information regarding copyright ownership.
+ *  * The ASF licenses this file to You under the Apache License, Version 2.0
+ *  * (the "License"); you may not use this file except in compliance with
+ *  * the License.  You may obtain a copy of the License at
+ *  *
+ *  *     http://www.apache.org/licenses/LICENSE-2.0
+ *  *
+ *  * Unless required by applicable law or agreed to in writing, software
+ *  * distributed under the License is distributed on an "AS IS" BASIS,
+ *  * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  * See the License for the specific language governing permissions and
+ *  * limitations under the License.
+ *
+ */
+
+package cache
+
+import (
+	"container/list"
+	"hash/fnv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Number of invalidation stamps, keys are spread across them by hash
+const stampCount = 256
+
+// Cache is an LRU cache of the replies of read commands. The replies are
+// grouped by key, so a write or an invalidation of the key drops all of them.
+type Cache struct {
+	maxItems int
+	ttl      time.Duration
+
+	mu     sync.Mutex
+	ll     *list.List
+	items  map[string]*list.Element
+	stamps [stampCount]uint64
+}
+
+type entry struct {
+	key     string
+	replies map[string]interface{}
+	expire  time.Time
+}
+
+// New creates a cache holding at most maxItems keys, a key expires ttl
+// after its first reply was cached. A zero ttl keeps the keys until they
+// are invalidated or evicted.
+func New(maxItems int, ttl time.Duration) *Cache {
+	return &Cache{
+		maxItems: maxItems,
+		ttl:      ttl,
+		ll:       list.New(),
+		items:    make(map[string]*list.Element),
+	}
+}
+
+// Get returns the cached reply of a request on the key, request identifies
+// the command and its arguments other than the key
+func (c *Cache) Get(key, request string) (interface{}, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	e := el.Value.(*entry)
+	if c.expired(e, time.Now()) {
+		c.remove(el)
+		return nil, false
+	}
+	reply, ok := e.replies[request]
+	if ok {
+		c.ll.MoveToFront(el)
+	}
+	return reply, ok
+}
+
+// Stamp returns the invalidation stamp of the key, to be taken before the
+// request is sent to the backend and passed to Set with its reply
+func (c *Cache) Stamp(key string) uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.stamps[stampIndex(key)]
+}
+
+// Set caches the reply of a request on the key. The reply is dropped when
+// the key may have been invalidated since the stamp was taken, it could be
+// older than the invalidation.
+func (c *Cache) Set(key, request string, reply interface{}, stamp uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.stamps[stampIndex(key)] != stamp {
+		return
+	}
+	if el, ok := c.items[key]; ok {
+		el.Value.(*entry).replies[request] = reply
+		c.ll.MoveToFront(el)
+		return
+	}
+
+	e := &entry{key: key, replies: map[string]interface{}{request: reply}}
+	if c.ttl > 0 {
+		e.expire = time.Now().Add(c.ttl)
+	}
+	c.items[key] = c.ll.PushFront(e)
+	for c.maxItems > 0 && c.ll.Len() > c.maxItems {
+		c.remove(c.ll.Back())
+	}
+}
+
+// Invalidate drops the cached replies of the keys
+func (c *Cache) Invalidate(keys ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, key := range keys {
+		c.stamps[stampIndex(key)]++
+		if el, ok := c.items[key]; ok {
+			c.remove(el)
+		}
+	}
+}
+
+// Flush drops every cached reply
+func (c *Cache) Flush() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for i := range c.stamps {
+		c.stamps[i]++
+	}
+	c.ll.Init()
+	c.items = make(map[string]*list.Element)
+}
+
+// Purge drops the expired keys
+func (c *Cache) Purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	for el := c.ll.Back(); el != nil; {
+		prev := el.Prev()
+		if c.expired(el.Value.(*entry), now) {
+			c.remove(el)
+		}
+		el = prev
+	}
+}
+
+// Len returns the number of cached keys
+func (c *Cache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.ll.Len()
+}
+
+func (c *Cache) expired(e *entry, now time.Time) bool {
+	return !e.expire.IsZero() && now.After(e.expire)
+}
+
+func (c *Cache) remove(el *list.Element) {
+	c.ll.Remove(el)
+	delete(c.items, el.Value.(*entry).key)
+}
+
+func stampIndex(key string) uint32 {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(key))
+	return h.Sum32() % stampCount
+}
+
+// Prefixes returns the literal prefixes of the key patterns, used to narrow
+// the keys tracked by the backend. It returns nil when a pattern has no
+// literal prefix and every key must be tracked.
+func Prefixes(patterns []string) []string {
+	prefixes := make([]string, 0, len(patterns))
+	for _, pattern := range patterns {
+		prefix := pattern
+		if i := strings.IndexAny(pattern, `*?[\`); i >= 0 {
+			prefix = pattern[:i]
+		}
+		if prefix == "" {
+			return nil
+		}
+		prefixes = append(prefixes, prefix)
+	}
+	if len(prefixes) == 0 {
+		return nil
+	}
+	return prefixes
+}
@@ -0,0 +1,130 @@
+/*
+ *
+ *  * Licensed to the Apache Software Foundation (ASF) under one or more
+ *  * contributor license agreements.  See the NOTICE file distributed with
+ *  * this work for additional information regarding copyright ownership.
+ *  * The ASF licenses this file to You under the Apache License, Version 2.0
+ *  * (the "License"); you may not use this file except in compliance with
+ *  * the License.  You may obtain a copy of the License at
+ *  *
+ *  *     http://www.apache.org/licenses/LICENSE-2.0
+ *  *
+ *  * Unless required by applicable law or agreed to in writing, software
+ *  * distributed under the License is distributed on an "AS IS" BASIS,
+ *  * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  * See the License for the specific language governing permissions and
+ *  * limitations under the License.
+ *
+ */
+
+package cache
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gomodule/redigo/redis"
+)
+
+func TestCache(t *testing.T) {
+	c := New(2, 0)
+	c.Set("a", "GET", []byte("1"), c.Stamp("a"))
+	c.Set("a", "STRLEN", int64(1), c.Stamp("a"))
+	if v, ok := c.Get("a", "GET"); !ok || string(v.([]byte)) != "1" {
+		t.Errorf("get error: %v, %v", v, ok)
+	}
+
+	c.Set("b", "GET", []byte("2"), c.Stamp("b"))
+	c.Get("a", "GET")
+	c.Set("c", "GET", []byte("3"), c.Stamp("c"))
+	if _, ok := c.Get("b", "GET"); ok {
+		t.Error("least recently used key must be evicted")
+	}
+	if c.Len() != 2 {
+		t.Errorf("len error: %d", c.Len())
+	}
+
+	c.Invalidate("a")
+	if _, ok := c.Get("a", "STRLEN"); ok {
+		t.Error("invalidation must drop every reply of the key")
+	}
+
+	stamp := c.Stamp("d")
+	c.Invalidate("d")
+	c.Set("d", "GET", []byte("old"), stamp)
+	if _, ok := c.Get("d", "GET"); ok {
+		t.Error("reply older than an invalidation must not be cached")
+	}
+
+	c.Flush()
+	if c.Len() != 0 {
+		t.Errorf("flush error: %d", c.Len())
+	}
+}
+
+func TestCacheExpire(t *testing.T) {
+	c := New(0, 10*time.Millisecond)
+	c.Set("a", "GET", []byte("1"), c.Stamp("a"))
+	time.Sleep(20 * time.Millisecond)
+	if _, ok := c.Get("a", "GET"); ok {
+		t.Error("expired key must not be returned")
+	}
+	c.Set("b", "GET", []byte("1"), c.Stamp("b"))
+	time.Sleep(20 * time.Millisecond)
+	c.Purge()
+	if c.Len() != 0 {
+		t.Errorf("purge error: %d", c.Len())
+	}
+}
+
+func TestPrefixes(t *testing.T) {
+	if p := Prefixes([]string{"user:*", "session:?:x", "config"}); !reflect.DeepEqual(p, []string{"user:", "session:", "config"}) {
+		t.Errorf("prefixes error: %v", p)
+	}
+	if p := Prefixes([]string{"user:*", "*"}); p != nil {
+		t.Errorf("pattern without prefix must track every key: %v", p)
+	}
+}
+
+func TestParseInvalidation(t *testing.T) {
+	keys, flush := parseInvalidation([]interface{}{[]byte("message"), []byte(trackingChannel), []interface{}{[]byte("a"), []byte("b")}})
+	if flush || !reflect.DeepEqual(keys, []string{"a", "b"}) {
+		t.Errorf("tracking message error: %v, %v", keys, flush)
+	}
+	if _, flush := parseInvalidation([]interface{}{[]byte("message"), []byte(trackingChannel), nil}); !flush {
+		t.Error("nil tracking message must flush")
+	}
+	keys, _ = parseInvalidation([]interface{}{[]byte("pmessage"), []byte(keyspacePattern), []byte("__keyspace@0__:user:1"), []byte("set")})
+	if !reflect.DeepEqual(keys, []string{"user:1"}) {
+		t.Errorf("keyspace message error: %v", keys)
+	}
+	if keys, flush := parseInvalidation([]interface{}{[]byte("psubscribe"), []byte(keyspacePattern), int64(1)}); keys != nil || flush {
+		t.Error("subscription reply must be ignored")
+	}
+}
+
+func TestListenKeyspace(t *testing.T) {
+	s := miniredis.RunT(t)
+	c := New(0, 0)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go c.Listen(ctx, ListenOptions{
+		Dial: func() (redis.Conn, error) { return redis.Dial("tcp", s.Addr()) },
+		Mode: InvalidateKeyspace,
+	})
+
+	for i := 0; i < 100 && s.PubSubNumPat() == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	c.Set("k", "GET", []byte("v"), c.Stamp("k"))
+	s.Publish("__keyspace@0__:k", "set")
+	for i := 0; i < 100 && c.Len() > 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if c.Len() != 0 {
+		t.Error("keyspace notification must invalidate the key")
+	}
+}
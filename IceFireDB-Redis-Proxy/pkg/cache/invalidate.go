@@ -0,0 +1,179 @@
+/*
+ *
+ *  * Licensed to the Apache Software Foundation (ASF) under one or more
+ *  * contributor license agreements.  See the NOTICE file distributed with
+ *  * this work for additional information regarding copyright ownership.
+ *  * The ASF licenses this file to You under the Apache License, Version 2.0
+ *  * (the "License"); you may not use this file except in compliance with
+ *  * the License.  You may obtain a copy of the License at
+ *  *
+ *  *     http://www.apache.org/licenses/LICENSE-2.0
+ *  *
+ *  * Unless required by applicable law or agreed to in writing, software
+ *  * distributed under the License is distributed on an "AS IS" BASIS,
+ *  * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  * See the License for the specific language governing permissions and
+ *  * limitations under the License.
+ *
+ */
+
+package cache
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// Invalidation sources of the cache
+const (
+	// Server assisted client side caching, CLIENT TRACKING in broadcast mode (Redis 6+)
+	InvalidateTracking = "tracking"
+	// Keyspace notifications, requires notify-keyspace-events to include K and A
+	InvalidateKeyspace = "keyspace"
+)
+
+// Channels of the invalidation messages
+const (
+	trackingChannel = "__redis__:invalidate"
+	keyspacePattern = "__keyspace@*__:*"
+)
+
+// Delay before a lost invalidation subscription is restored
+const listenRetryInterval = time.Second
+
+// ListenOptions represents the invalidation subscription of a backend
+type ListenOptions struct {
+	// Dial opens a connection to the backend, without read timeout
+	Dial func() (redis.Conn, error)
+	// InvalidateTracking or InvalidateKeyspace
+	Mode string
+	// Key prefixes tracked by CLIENT TRACKING, nil tracks every key
+	Prefixes []string
+
+	OnError func(err error)
+}
+
+// Listen subscribes to the invalidation messages of a backend and drops the
+// invalidated keys until the context is done. Messages may be missed while
+// the subscription is down, so the whole cache is flushed each time it is lost.
+// Meant to be started as a go routine.
+func (c *Cache) Listen(ctx context.Context, opt ListenOptions) {
+	for {
+		err := c.listen(ctx, opt)
+		c.Flush()
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil && opt.OnError != nil {
+			opt.OnError(err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(listenRetryInterval):
+		}
+	}
+}
+
+func (c *Cache) listen(ctx context.Context, opt ListenOptions) error {
+	sub, err := opt.Dial()
+	if err != nil {
+		return err
+	}
+	defer sub.Close()
+
+	switch opt.Mode {
+	case InvalidateTracking:
+		id, err := redis.Int64(sub.Do("CLIENT", "ID"))
+		if err != nil {
+			return err
+		}
+		// tracking lasts as long as the connection that enabled it
+		tracking, err := opt.Dial()
+		if err != nil {
+			return err
+		}
+		defer tracking.Close()
+
+		args := []interface{}{"TRACKING", "ON", "REDIRECT", id, "BCAST"}
+		for _, prefix := range opt.Prefixes {
+			args = append(args, "PREFIX", prefix)
+		}
+		if _, err := tracking.Do("CLIENT", args...); err != nil {
+			return err
+		}
+		err = sub.Send("SUBSCRIBE", trackingChannel)
+	case InvalidateKeyspace:
+		err = sub.Send("PSUBSCRIBE", keyspacePattern)
+	default:
+		return fmt.Errorf("unknown cache invalidation: %s", opt.Mode)
+	}
+	if err == nil {
+		err = sub.Flush()
+	}
+	if err != nil {
+		return err
+	}
+
+	// a blocked receive only returns once the connection is closed
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			sub.Close()
+		case <-stop:
+		}
+	}()
+
+	for {
+		reply, err := sub.Receive()
+		if err != nil {
+			return err
+		}
+		keys, flush := parseInvalidation(reply)
+		if flush {
+			c.Flush()
+		} else if len(keys) > 0 {
+			c.Invalidate(keys...)
+		}
+	}
+}
+
+// A function that extracts the invalidated keys of a pub/sub message, flush
+// is true when the whole cache must be dropped. Other messages are ignored.
+func parseInvalidation(reply interface{}) (keys []string, flush bool) {
+	msg, err := redis.Values(reply, nil)
+	if err != nil || len(msg) < 3 {
+		return nil, false
+	}
+	kind, _ := redis.String(msg[0], nil)
+
+	switch kind {
+	case "message":
+		// CLIENT TRACKING: the keys, or nil when the database was flushed
+		if msg[2] == nil {
+			return nil, true
+		}
+		keys, err := redis.Strings(msg[2], nil)
+		if err != nil {
+			return nil, false
+		}
+		return keys, false
+	case "pmessage":
+		// keyspace notification: __keyspace@<db>__:<key>, the event as data
+		if len(msg) < 4 {
+			return nil, false
+		}
+		channel, _ := redis.String(msg[2], nil)
+		if i := strings.Index(channel, "__:"); i >= 0 {
+			return []string{channel[i+3:]}, false
+		}
+	}
+	return nil, false
+}
@@ -21,8 +21,10 @@ package config
 
 import (
 	"errors"
+	"fmt"
 	"net"
 	"strings"
+	"sync/atomic"
 
 	rediscluster "github.com/chasex/redis-go-cluster"
 	"github.com/spf13/viper"
@@ -33,44 +35,247 @@ var (
 	ErrDuplicateInitConfig = errors.New("Duplicate init config！")
 )
 
+// Keys of the client limits
+const (
+	LimitKeyIP   = "ip"
+	LimitKeyUser = "user"
+)
+
 const (
 	TypeNode    = "node"
 	TypeCluster = "cluster"
+	TypeShard   = "shard"
 )
 
 // Do not use config directly in the agent's data link to prevent race
 // Global configuration
 var (
-	_config          *Config
+	_config          atomic.Pointer[Config]
 	_ruleRedisClient *rediscluster.Cluster
 )
 
 func InitConfig() error {
-	if _config != nil {
+	if _config.Load() != nil {
 		return ErrDuplicateInitConfig
 	}
 
-	err := viper.Unmarshal(&_config)
+	conf, err := unmarshal()
 	if err != nil {
 		return err
 	}
-	if _config.IgnoreCMD.Enable && len(_config.IgnoreCMD.CMDList) > 0 {
-		CmdToUpper(_config.IgnoreCMD.CMDList)
+	_config.Store(conf)
+	return nil
+}
+
+// Load re-reads the config file without applying it
+func Load() (*Config, error) {
+	if err := viper.ReadInConfig(); err != nil {
+		return nil, err
 	}
+	return unmarshal()
+}
+
+// Set replaces the global configuration, used by the hot reload
+func Set(conf *Config) {
+	_config.Store(conf)
+}
 
-	if net.ParseIP(_config.P2P.NodeHostIP) == nil {
-		_config.P2P.NodeHostIP = "0.0.0.0"
+func unmarshal() (*Config, error) {
+	conf := &Config{}
+	err := viper.Unmarshal(conf)
+	if err != nil {
+		return nil, err
+	}
+	if conf.IgnoreCMD.Enable && len(conf.IgnoreCMD.CMDList) > 0 {
+		CmdToUpper(conf.IgnoreCMD.CMDList)
 	}
 
-	if _config.P2P.NodeHostPort < 0 || _config.P2P.NodeHostPort > 65535 {
-		_config.P2P.NodeHostPort = 0
+	if conf.RedisDB.Type == TypeShard {
+		if err := checkShards(conf.RedisDB.Shards); err != nil {
+			return nil, err
+		}
 	}
 
+	if conf.Proxy.DrainTimeout <= 0 {
+		conf.Proxy.DrainTimeout = 10000
+	}
+
+	if conf.Proxy.EnableMTLS && (!conf.Proxy.TLS.Enable || conf.Proxy.TLS.ClientCAFile == "") {
+		return nil, errors.New("proxy enable_mtls requires tls with a client_ca_file")
+	}
+	if err := checkTenants(conf.Tenants, conf.Users); err != nil {
+		return nil, err
+	}
+
+	switch conf.Log.Level = strings.ToLower(conf.Log.Level); conf.Log.Level {
+	case "":
+		conf.Log.Level = "info"
+	case "trace", "debug", "info", "warn", "warning", "error", "fatal", "panic":
+	default:
+		return nil, fmt.Errorf("unknown log level: %s", conf.Log.Level)
+	}
+
+	switch conf.Limits.Key {
+	case "":
+		conf.Limits.Key = LimitKeyIP
+	case LimitKeyIP, LimitKeyUser:
+	default:
+		return nil, fmt.Errorf("unknown limits key: %s", conf.Limits.Key)
+	}
+
+	if c := &conf.Cache; c.Enable {
+		switch c.Invalidation {
+		case "":
+			c.Invalidation = "tracking"
+		case "tracking", "keyspace":
+		default:
+			return nil, fmt.Errorf("unknown cache invalidation: %s", c.Invalidation)
+		}
+	}
+
+	if m := &conf.Mirror; m.Enable {
+		if m.Addr == "" {
+			return nil, errors.New("mirror addr is required")
+		}
+		if m.Percent < 0 || m.Percent > 100 {
+			return nil, fmt.Errorf("mirror percent out of range: %v", m.Percent)
+		}
+		if m.Percent == 0 {
+			m.Percent = 100
+		}
+		if m.QueueSize <= 0 {
+			m.QueueSize = 4096
+		}
+		if m.Workers <= 0 {
+			m.Workers = 4
+		}
+	}
+
+	if m := &conf.Metrics; m.Enable {
+		if m.SlowLogSlowerThan == 0 {
+			m.SlowLogSlowerThan = 10000
+		}
+		if m.SlowLogMaxLen <= 0 {
+			m.SlowLogMaxLen = 128
+		}
+	}
+
+	if t := &conf.Tracing; t.Enable {
+		switch t.Exporter {
+		case "":
+			t.Exporter = "otlp-grpc"
+		case "otlp-grpc", "otlp-http", "zipkin", "stdout":
+		default:
+			return nil, fmt.Errorf("unknown tracing exporter: %s", t.Exporter)
+		}
+		if t.SampleRatio < 0 || t.SampleRatio > 1 {
+			return nil, fmt.Errorf("tracing sample_ratio out of range: %v", t.SampleRatio)
+		}
+		if t.SampleRatio == 0 {
+			t.SampleRatio = 1
+		}
+	}
+
+	if r := &conf.Reload; r.AdminPort > 0 {
+		if r.AdminToken == "" {
+			return nil, errors.New("reload admin_token is required by admin_port")
+		}
+		if r.AdminBind == "" {
+			r.AdminBind = "127.0.0.1"
+		}
+	}
+
+	if hc := &conf.RedisDB.HealthCheck; hc.Enable {
+		if hc.Interval <= 0 {
+			hc.Interval = 1000
+		}
+		if hc.Timeout <= 0 {
+			hc.Timeout = 500
+		}
+		if hc.Failures <= 0 {
+			hc.Failures = 3
+		}
+	}
+
+	if net.ParseIP(conf.P2P.NodeHostIP) == nil {
+		conf.P2P.NodeHostIP = "0.0.0.0"
+	}
+
+	if conf.P2P.NodeHostPort < 0 || conf.P2P.NodeHostPort > 65535 {
+		conf.P2P.NodeHostPort = 0
+	}
+
+	return conf, nil
+}
+
+func checkShards(shards []ShardS) error {
+	if len(shards) == 0 {
+		return errors.New("redisdb shards are required by the shard type")
+	}
+	names := make(map[string]bool, len(shards))
+	for k := range shards {
+		if shards[k].Addr == "" {
+			return errors.New("redisdb shard addr is required")
+		}
+		if shards[k].Name == "" {
+			shards[k].Name = shards[k].Addr
+		}
+		if names[shards[k].Name] {
+			return fmt.Errorf("duplicate redisdb shard: %s", shards[k].Name)
+		}
+		names[shards[k].Name] = true
+	}
+	return nil
+}
+
+func checkTenants(tenants []TenantS, users []UserS) error {
+	names := make(map[string]bool, len(tenants))
+	serverNames := make(map[string]bool)
+	userNames := make(map[string]bool, len(users))
+	for _, u := range users {
+		userNames[u.Name] = true
+	}
+	tenantUsers := make(map[string]bool)
+	prefixes := make(map[string]bool)
+	for _, t := range tenants {
+		if t.Name == "" {
+			return errors.New("tenant name is required")
+		}
+		if names[t.Name] {
+			return fmt.Errorf("duplicate tenant: %s", t.Name)
+		}
+		names[t.Name] = true
+		for _, sn := range t.ServerNames {
+			sn = strings.ToLower(sn)
+			if serverNames[sn] {
+				return fmt.Errorf("duplicate tenant server name: %s", sn)
+			}
+			serverNames[sn] = true
+		}
+		for _, u := range t.Users {
+			if !userNames[u] {
+				return fmt.Errorf("unknown tenant user: %s", u)
+			}
+			if tenantUsers[u] {
+				return fmt.Errorf("user %s belongs to several tenants", u)
+			}
+			tenantUsers[u] = true
+		}
+		for _, p := range t.KeyPrefixes {
+			if p == "" {
+				return fmt.Errorf("empty key prefix of tenant %s", t.Name)
+			}
+			if prefixes[p] {
+				return fmt.Errorf("duplicate tenant key prefix: %s", p)
+			}
+			prefixes[p] = true
+		}
+	}
 	return nil
 }
 
 func Get() *Config {
-	return _config
+	return _config.Load()
 }
 
 func CmdToUpper(list []string) {
@@ -0,0 +1,61 @@
+/*
+ *
+ *  * Licensed to the Apache Software Foundation (ASF) under one or more
+ *  * contributor license agreements.  See the NOTICE file distributed with
+ *  * this work for additional information regarding copyright ownership.
+ *  * The ASF licenses this file to You under the Apache License, Version 2.0
+ *  * (the "License"); you may not use this file except in compliance with
+ *  * the License.  You may obtain a copy of the License at
+ *  *
+ *  *     http://www.apache.org/licenses/LICENSE-2.0
+ *  *
+ *  * Unless required by applicable law or agreed to in writing, software
+ *  * distributed under the License is distributed on an "AS IS" BASIS,
+ *  * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  * See the License for the specific language governing permissions and
+ *  * limitations under the License.
+ *
+ */
+
+package config
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// Diff returns the fields that differ between two configurations, named by
+// their path in the config file, e.g. limits.rate. Lists are compared as a
+// whole.
+func Diff(a, b *Config) []string {
+	var fields []string
+	diff("", reflect.ValueOf(a).Elem(), reflect.ValueOf(b).Elem(), &fields)
+	sort.Strings(fields)
+	return fields
+}
+
+func diff(path string, a, b reflect.Value, fields *[]string) {
+	if a.Kind() != reflect.Struct {
+		if !reflect.DeepEqual(a.Interface(), b.Interface()) {
+			*fields = append(*fields, path)
+		}
+		return
+	}
+	t := a.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		name := f.Tag.Get("mapstructure")
+		if name == "" {
+			// mapstructure matches the untagged fields case-insensitively
+			name = strings.ToLower(f.Name)
+		}
+		if path != "" {
+			name = path + "." + name
+		}
+		diff(name, a.Field(i), b.Field(i), fields)
+	}
+}
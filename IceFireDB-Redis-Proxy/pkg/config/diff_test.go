@@ -0,0 +1,46 @@
+/*
+ *
+ *  * Licensed to the Apache Software Foundation (ASF) under one or more
+ *  * contributor license agreements.  See the NOTICE file distributed with
+ *  * this work for additional information regarding copyright ownership.
+ *  * The ASF licenses this file to You under the Apache License, Version 2.0
+ *  * (the "License"); you may not use this file except in compliance with
+ *  * the License.  You may obtain a copy of the License at
+ *  *
+ *  *     http://www.apache.org/licenses/LICENSE-2.0
+ *  *
+ *  * Unless required by applicable law or agreed to in writing, software
+ *  * distributed under the License is distributed on an "AS IS" BASIS,
+ *  * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  * See the License for the specific language governing permissions and
+ *  * limitations under the License.
+ *
+ */
+
+package config
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+	a := &Config{}
+	a.Limits.Rate = 10
+	a.IPWhiteList.List = []string{"127.0.0.1"}
+	a.RedisDB.Type = TypeNode
+
+	b := *a
+	if fields := Diff(a, &b); len(fields) != 0 {
+		t.Errorf("equal configs differ in %v", fields)
+	}
+
+	b.Limits.Rate = 20
+	b.IPWhiteList.List = []string{"127.0.0.1", "10.0.0.1"}
+	b.RedisDB.Type = TypeShard
+	b.Proxy.TLS.Enable = true
+	want := []string{"ip_white_list.list", "limits.rate", "proxy.tls.enable", "redisdb.type"}
+	if fields := Diff(a, &b); !reflect.DeepEqual(fields, want) {
+		t.Errorf("Diff = %v, want %v", fields, want)
+	}
+}
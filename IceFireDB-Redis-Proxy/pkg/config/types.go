@@ -27,6 +27,14 @@ type Config struct {
 	IPWhiteList IPWhiteListS `mapstructure:"ip_white_list"`
 	Cache       CacheS       `mapstructure:"cache"`
 	IgnoreCMD   IgnoreCMDS   `mapstructure:"ignore_cmd"`
+	CMDRules    []CMDRuleS   `mapstructure:"cmd_rules"`
+	Users       []UserS      `mapstructure:"users"`
+	Tenants     []TenantS    `mapstructure:"tenants"`
+	Limits      LimitsS      `mapstructure:"limits"`
+	Mirror      MirrorS      `mapstructure:"mirror"`
+	Reload      ReloadS      `mapstructure:"reload"`
+	Metrics     MetricsS     `mapstructure:"metrics"`
+	Tracing     TracingS     `mapstructure:"tracing"`
 
 	P2P P2PS `mapstructure:"p2p"`
 }
@@ -41,8 +49,86 @@ type P2PS struct {
 }
 
 type ProxyS struct {
-	LocalPort  int  `mapstructure:"local_port" json:"local_port"`   // Port to listen on locally when proxying
-	EnableMTLS bool `mapstructure:"enable_mtls" json:"enable_mtls"` // Cluster nodes, multiple, split
+	LocalPort  int        `mapstructure:"local_port" json:"local_port"`   // Port to listen on locally when proxying
+	EnableMTLS bool       `mapstructure:"enable_mtls" json:"enable_mtls"` // Require client certificates signed by tls.client_ca_file
+	TLS        ListenTLSS `mapstructure:"tls" json:"tls"`
+	// Bind local_port with SO_REUSEPORT, so a new proxy can listen before the old one stops
+	ReusePort bool `mapstructure:"reuse_port" json:"reuse_port"`
+	// Time the connections have to finish their command when the proxy stops or upgrades Unit: ms
+	DrainTimeout int `mapstructure:"drain_timeout" json:"drain_timeout"`
+}
+
+// ListenTLSS terminates TLS on the proxy port
+type ListenTLSS struct {
+	Enable bool `mapstructure:"enable" json:"enable"`
+	// Certificates selected by the SNI of the clients, the first one is the default
+	Certs []CertS `mapstructure:"certs" json:"certs"`
+	// CA of the client certificates, used with enable_mtls
+	ClientCAFile string `mapstructure:"client_ca_file" json:"client_ca_file"`
+}
+
+type CertS struct {
+	CertFile string `mapstructure:"cert_file" json:"cert_file"`
+	KeyFile  string `mapstructure:"key_file" json:"key_file"`
+}
+
+// TenantS isolates the clients of a tenant, selected by the SNI of their TLS
+// connection or by their AUTH user, and the keys under its prefixes
+type TenantS struct {
+	Name string `mapstructure:"name"`
+	// SNI server names of the tenant
+	ServerNames []string `mapstructure:"server_names"`
+	// AUTH users of the tenant
+	Users []string `mapstructure:"users"`
+	// Key prefixes of the tenant, for the commands of clients without tenant
+	KeyPrefixes []string `mapstructure:"key_prefixes"`
+	// Key prefix the keys of the clients of the tenant are stored under
+	Namespace string `mapstructure:"namespace"`
+	// Backend of the tenant, the redisdb backend serves it when no addr is set
+	Backend TenantBackendS `mapstructure:"backend"`
+}
+
+// TenantBackendS is the primary and replicas of a tenant, connected
+// with the options of the redisdb backend
+type TenantBackendS struct {
+	Addr     string   `mapstructure:"addr"`
+	Replicas []string `mapstructure:"replicas"`
+}
+
+// ReloadS controls the hot reload of the backend topology and routing rules
+type ReloadS struct {
+	// Reload when the config file changes
+	WatchFile bool `mapstructure:"watch_file"`
+	// Admin HTTP port serving POST /reload, GET /backends, GET /mirror, GET /tenants and the metrics, 0 disables it
+	AdminPort uint16 `mapstructure:"admin_port"`
+	// Address the admin port listens on, 127.0.0.1 by default
+	AdminBind string `mapstructure:"admin_bind"`
+	// Bearer token of the admin requests, required with admin_port
+	AdminToken string `mapstructure:"admin_token"`
+}
+
+// MetricsS records the latency of the commands seen by the clients and of
+// the backends, served on the admin port
+type MetricsS struct {
+	Enable bool `mapstructure:"enable"`
+	// Commands slower than this are logged in the slow log (unit: microsecond), 0 uses the default (10000), negative disables it
+	SlowLogSlowerThan int `mapstructure:"slowlog_slower_than"`
+	// Commands kept in the slow log, 0 uses the default (128)
+	SlowLogMaxLen int `mapstructure:"slowlog_max_len"`
+}
+
+// TracingS exports a span for each command and for each backend call, so a
+// slow command can be followed from the client to the IceFireDB storage
+type TracingS struct {
+	Enable bool `mapstructure:"enable"`
+	// Span exporter: otlp-grpc, otlp-http, zipkin or stdout, empty uses the default (otlp-grpc)
+	Exporter string `mapstructure:"exporter"`
+	// Collector endpoint of the exporter, empty uses the default of the exporter
+	Endpoint string `mapstructure:"endpoint"`
+	// Fraction of the commands traced, 0 uses the default (1)
+	SampleRatio float64 `mapstructure:"sample_ratio"`
+	// Send the trace context to the backends, they must be IceFireDB servers
+	Propagate bool `mapstructure:"propagate"`
 }
 
 type PprofDebugS struct {
@@ -67,6 +153,10 @@ type CacheS struct {
 	DefaultExpiration int `mapstructure:"default_expiration"`
 	// cache Expired KV cleaning cycle (unit: second)
 	CleanupInterval int `mapstructure:"cleanup_interval"`
+	// Key patterns whose replies are cached, empty caches every key
+	Patterns []string `mapstructure:"patterns"`
+	// Invalidation by the backends: tracking (CLIENT TRACKING, Redis 6+) or keyspace (keyspace notifications)
+	Invalidation string `mapstructure:"invalidation"`
 }
 
 type IgnoreCMDS struct {
@@ -75,11 +165,72 @@ type IgnoreCMDS struct {
 	CMDList []string `mapstructure:"cmd_list" json:"cmd_list"`
 }
 
+// CMDRuleS blocks, renames or rewrites a command for the matching clients
+type CMDRuleS struct {
+	Command string `mapstructure:"command"`
+	// allow、deny、rename、rewrite
+	Action string `mapstructure:"action"`
+	// rename: command name sent to the backend, rewrite: target command (KEYS to SCAN)
+	To string `mapstructure:"to"`
+	// Client IPs or CIDRs, empty matches every client
+	Clients []string `mapstructure:"clients"`
+}
+
+// UserS is a user authenticated by the proxy with AUTH
+type UserS struct {
+	// AUTH with a single argument authenticates the default user
+	Name     string `mapstructure:"name"`
+	Password string `mapstructure:"password"`
+}
+
+// LimitsS protects the backends from noisy clients
+type LimitsS struct {
+	// ip or user: limits are counted by AUTH user once authenticated, by IP before
+	Key string `mapstructure:"key"`
+	// Commands per second of each client, 0 disables the rate limit
+	Rate float64 `mapstructure:"rate"`
+	// Commands a client may send at once above the rate
+	Burst int `mapstructure:"burst"`
+	// Connections of each client, 0 disables the quota
+	MaxConns int `mapstructure:"max_conns"`
+}
+
+// MirrorS duplicates a share of the traffic to a shadow backend, its replies are ignored
+type MirrorS struct {
+	Enable bool `mapstructure:"enable"`
+	// Address of the shadow backend
+	Addr string `mapstructure:"addr"`
+	// Share of the keys mirrored in percent, every command on a mirrored key is duplicated
+	Percent float64 `mapstructure:"percent"`
+	// Mirror the write commands only
+	WriteOnly bool `mapstructure:"write_only"`
+	// Commands waiting for the shadow backend, the commands arriving while it is full are dropped
+	QueueSize int `mapstructure:"queue_size"`
+	// Connections sending the commands to the shadow backend
+	Workers int `mapstructure:"workers"`
+	// TLS of the connections to the shadow backend
+	TLS BackendTLSS `mapstructure:"tls"`
+}
+
 // RedisClusterConf is redis cluster configure options
 type RedisDBS struct {
-	// node、cluster
+	// node、cluster、shard
 	Type       string `mapstructure:"type"`
 	StartNodes string `mapstructure:"start_nodes"`
+	// Replicas of the node type, multiple, split
+	Replicas string `mapstructure:"replicas"`
+	// Routing of read-only commands to the replicas
+	ReadWriteSplit ReadWriteSplitS `mapstructure:"read_write_split"`
+	// Active health check of the backends
+	HealthCheck HealthCheckS `mapstructure:"health_check"`
+	// Backends of the shard type, keys are spread across them with consistent hashing
+	Shards []ShardS `mapstructure:"shards"`
+	// Virtual nodes of each shard on the hash ring, 0 uses the default (160)
+	VirtualNodes int `mapstructure:"virtual_nodes"`
+	// Static slot map of the cluster type, for nodes that do not answer CLUSTER SLOTS
+	SlotMap []SlotRangeS `mapstructure:"slot_map"`
+	// TLS of the connections to the backends
+	TLS BackendTLSS `mapstructure:"tls"`
 	// Connection timeout parameter of cluster nodes Unit: ms
 	ConnTimeOut int `mapstructure:"conn_timeout"`
 	// Cluster node read timeout parameter Unit: ms
@@ -92,6 +243,54 @@ type RedisDBS struct {
 	ConnPoolSize int `mapstructure:"conn_pool_size"`
 }
 
+type ShardS struct {
+	// Shard name, used to place the shard on the hash ring, defaults to the address.
+	// Keep names stable, renaming a shard moves its keys.
+	Name string `mapstructure:"name"`
+	Addr string `mapstructure:"addr"`
+	// Replicas of the shard
+	Replicas []string `mapstructure:"replicas"`
+}
+
+type BackendTLSS struct {
+	Enable bool `mapstructure:"enable"`
+	// CA of the backend certificates, empty uses the system roots
+	CAFile string `mapstructure:"ca_file"`
+	// Client certificate presented to the backends (mTLS)
+	CertFile string `mapstructure:"cert_file"`
+	KeyFile  string `mapstructure:"key_file"`
+	// Name verified in the backend certificates, defaults to the backend host
+	ServerName string `mapstructure:"server_name"`
+	// Base64 SHA-256 of the backend public keys, without ca_file they replace the CA verification
+	Pins []string `mapstructure:"pins"`
+}
+
+type SlotRangeS struct {
+	// Slot range served by the node, e.g. 0-8191
+	Slots string `mapstructure:"slots"`
+	Addr  string `mapstructure:"addr"`
+}
+
+type HealthCheckS struct {
+	Enable bool `mapstructure:"enable"`
+	// Probe interval Unit: ms
+	Interval int `mapstructure:"interval"`
+	// Probe timeout Unit: ms
+	Timeout int `mapstructure:"timeout"`
+	// Consecutive failed probes before a backend is ejected
+	Failures int `mapstructure:"failures"`
+	// Promote a replica when the primary is ejected
+	Promote bool `mapstructure:"promote"`
+}
+
+type ReadWriteSplitS struct {
+	Enable bool `mapstructure:"enable"`
+	// Read-only commands always sent to the primary
+	PrimaryCMDs []string `mapstructure:"primary_cmds"`
+	// Commands sent to the replicas even though they are not flagged read-only
+	ReplicaCMDs []string `mapstructure:"replica_cmds"`
+}
+
 type IPWhiteListS struct {
 	Enable bool     `json:"enable"`
 	List   []string `json:"list"`
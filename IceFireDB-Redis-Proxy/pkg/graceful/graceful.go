@@ -0,0 +1,226 @@
+/*
+ *
+ *  * Licensed to the Apache Software Foundation (ASF) under one or more
+ *  * contributor license agreements.  See the NOTICE file distributed with
+ *  * this work for additional information regarding copyright ownership.
+ *  * The ASF licenses this file to You under the Apache License, Version 2.0
+ *  * (the "License"); you may not use this file except in compliance with
+ *  * the License.  You may obtain a copy of the License at
+ *  *
+ *  *     http://www.apache.org/licenses/LICENSE-2.0
+ *  *
+ *  * Unless required by applicable law or agreed to in writing, software
+ *  * distributed under the License is distributed on an "AS IS" BASIS,
+ *  * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  * See the License for the specific language governing permissions and
+ *  * limitations under the License.
+ *
+ */
+
+// Package graceful restarts the proxy without resetting its clients: the
+// listening socket is handed to the new process, or shared with it through
+// SO_REUSEPORT, and the connections of the old process are drained
+package graceful
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Environment variables giving the inherited descriptors to the new process
+const (
+	ListenerFDEnv = "ICEFIREDB_PROXY_LISTENER_FD"
+	ReadyFDEnv    = "ICEFIREDB_PROXY_READY_FD"
+)
+
+var ErrUnsupported = errors.New("graceful restart not supported on this platform")
+
+// Listen returns the listener inherited from the previous process, or a new
+// listener on addr, bound with SO_REUSEPORT when reusePort is set
+func Listen(addr string, reusePort bool) (net.Listener, error) {
+	if v := os.Getenv(ListenerFDEnv); v != "" {
+		return inherited(v)
+	}
+	if !reusePort {
+		return net.Listen("tcp", addr)
+	}
+	lc := net.ListenConfig{Control: reusePortControl}
+	return lc.Listen(context.Background(), "tcp", addr)
+}
+
+// A function that returns the listener of the descriptor fd
+func inherited(fd string) (net.Listener, error) {
+	n, err := strconv.Atoi(fd)
+	if err != nil {
+		return nil, err
+	}
+	f := os.NewFile(uintptr(n), "listener")
+	defer f.Close()
+	_ = os.Unsetenv(ListenerFDEnv)
+	return net.FileListener(f)
+}
+
+// Ready tells the previous process the listener is served, it can stop
+// accepting and drain its connections. It does nothing when the process was
+// not started by an upgrade.
+func Ready() error {
+	v := os.Getenv(ReadyFDEnv)
+	if v == "" {
+		return nil
+	}
+	_ = os.Unsetenv(ReadyFDEnv)
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return err
+	}
+	f := os.NewFile(uintptr(n), "ready")
+	defer f.Close()
+	_, err = f.Write([]byte{1})
+	return err
+}
+
+// Listener is a listener that can stop accepting without closing the
+// connections already accepted: once stopped, Accept blocks until Close
+type Listener struct {
+	net.Listener
+
+	mu      sync.Mutex
+	stopped bool
+	closed  chan struct{}
+	once    sync.Once
+}
+
+// NewListener wraps ln
+func NewListener(ln net.Listener) *Listener {
+	return &Listener{Listener: ln, closed: make(chan struct{})}
+}
+
+// Accept waits for the next connection
+func (l *Listener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil && l.Stopped() {
+		// servers returning on an accept error close their connections
+		<-l.closed
+		return nil, net.ErrClosed
+	}
+	return conn, err
+}
+
+// Stop closes the socket, the new connections go to the processes sharing
+// it. The connections already accepted are kept.
+func (l *Listener) Stop() error {
+	l.mu.Lock()
+	if l.stopped {
+		l.mu.Unlock()
+		return nil
+	}
+	l.stopped = true
+	l.mu.Unlock()
+	return l.Listener.Close()
+}
+
+// Stopped reports whether the listener was stopped
+func (l *Listener) Stopped() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.stopped
+}
+
+// Close stops the listener and unblocks Accept
+func (l *Listener) Close() error {
+	err := l.Stop()
+	l.once.Do(func() { close(l.closed) })
+	return err
+}
+
+// File returns a duplicate of the descriptor of the socket
+func (l *Listener) File() (*os.File, error) {
+	ln, ok := l.Listener.(interface{ File() (*os.File, error) })
+	if !ok {
+		return nil, ErrUnsupported
+	}
+	return ln.File()
+}
+
+// Drainer tracks the client connections so they can be closed between two
+// commands when the process stops
+type Drainer struct {
+	mu       sync.Mutex
+	conns    map[net.Conn]struct{}
+	draining bool
+	empty    chan struct{}
+}
+
+// NewDrainer creates a drainer without connections
+func NewDrainer() *Drainer {
+	return &Drainer{conns: make(map[net.Conn]struct{})}
+}
+
+// Track adds a connection, it reports false when the drainer is draining
+// and the connection must be closed
+func (d *Drainer) Track(conn net.Conn) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.draining {
+		return false
+	}
+	d.conns[conn] = struct{}{}
+	return true
+}
+
+// Done removes a closed connection
+func (d *Drainer) Done(conn net.Conn) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.conns, conn)
+	if d.draining && len(d.conns) == 0 && d.empty != nil {
+		close(d.empty)
+		d.empty = nil
+	}
+}
+
+// Draining reports whether the connections must be closed once their
+// command is answered
+func (d *Drainer) Draining() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.draining
+}
+
+// Len returns the number of connections
+func (d *Drainer) Len() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return len(d.conns)
+}
+
+// Drain makes the connections close once their command is answered, the
+// idle ones are woken up by a read deadline. It waits for them until ctx is
+// done and returns the number of connections left.
+func (d *Drainer) Drain(ctx context.Context) int {
+	d.mu.Lock()
+	if !d.draining {
+		d.draining = true
+		if len(d.conns) > 0 {
+			d.empty = make(chan struct{})
+		}
+	}
+	empty := d.empty
+	for conn := range d.conns {
+		_ = conn.SetReadDeadline(time.Now())
+	}
+	d.mu.Unlock()
+
+	if empty != nil {
+		select {
+		case <-empty:
+		case <-ctx.Done():
+		}
+	}
+	return d.Len()
+}
@@ -0,0 +1,203 @@
+/*
+ *
+ *  * Licensed to the Apache Software Foundation (ASF) under one or more
+ *  * contributor license agreements.  See the NOTICE file distributed with
+ *  * this work for additional information regarding copyright ownership.
+ *  * The ASF licenses this file to You under the Apache License, Version 2.0
+ *  * (the "License"); you may not use this file except in compliance with
+ *  * the License.  You may obtain a copy of the License at
+ *  *
+ *  *     http://www.apache.org/licenses/LICENSE-2.0
+ *  *
+ *  * Unless required by applicable law or agreed to in writing, software
+ *  * distributed under the License is distributed on an "AS IS" BASIS,
+ *  * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  * See the License for the specific language governing permissions and
+ *  * limitations under the License.
+ *
+ */
+
+package graceful
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"os"
+	"runtime"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// TestMain plays the new process of TestUpgrade when started by Upgrade
+func TestMain(m *testing.M) {
+	if os.Getenv(ReadyFDEnv) != "" {
+		ln, err := Listen("", false)
+		if err != nil {
+			os.Exit(1)
+		}
+		if err = Ready(); err != nil {
+			os.Exit(1)
+		}
+		conn, err := ln.Accept()
+		if err == nil {
+			_, _ = conn.Write([]byte("new\n"))
+			_ = conn.Close()
+		}
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+func TestUpgrade(t *testing.T) {
+	if runtime.GOOS != "linux" && runtime.GOOS != "darwin" && runtime.GOOS != "freebsd" {
+		t.Skip("upgrade not supported")
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := NewListener(ln)
+	defer l.Close()
+	if err = Upgrade(l, 5*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	_ = l.Stop()
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if line, err := bufio.NewReader(conn).ReadString('\n'); err != nil || line != "new\n" {
+		t.Fatalf("connection must be served by the new process, got: %q %v", line, err)
+	}
+}
+
+func TestListenerStop(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := NewListener(ln)
+	accepted := make(chan error, 1)
+	go func() {
+		_, err := l.Accept()
+		accepted <- err
+	}()
+	if err = l.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err = <-accepted:
+		t.Fatalf("accept must block once stopped, got: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	if _, err = net.Dial("tcp", ln.Addr().String()); err == nil {
+		t.Error("stopped listener must not accept connections")
+	}
+	_ = l.Close()
+	if err = <-accepted; err != net.ErrClosed {
+		t.Errorf("accept after close: %v", err)
+	}
+}
+
+func TestDrainer(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	client, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	conn, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d := NewDrainer()
+	if !d.Track(conn) {
+		t.Fatal("connection must be tracked")
+	}
+	// a handler reading commands until the drain
+	go func() {
+		defer d.Done(conn)
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		for !d.Draining() {
+			if _, err := r.ReadString('\n'); err != nil {
+				return
+			}
+			_, _ = conn.Write([]byte("+OK\r\n"))
+		}
+	}()
+	if _, err = client.Write([]byte("PING\n")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = bufio.NewReader(client).ReadString('\n'); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if left := d.Drain(ctx); left != 0 {
+		t.Fatalf("idle connection must be closed by the drain, %d left", left)
+	}
+	if d.Track(conn) {
+		t.Error("connections must be refused while draining")
+	}
+
+	// a connection that never returns is left after the timeout
+	d = NewDrainer()
+	d.Track(client)
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if left := d.Drain(ctx); left != 1 {
+		t.Fatalf("%d connections left, want 1", left)
+	}
+}
+
+func TestListenInherited(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	f, err := ln.(*net.TCPListener).File()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(ListenerFDEnv, strconv.Itoa(int(f.Fd())))
+	inherited, err := Listen("127.0.0.1:0", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer inherited.Close()
+	if inherited.Addr().String() != ln.Addr().String() {
+		t.Errorf("inherited listener on %s, want %s", inherited.Addr(), ln.Addr())
+	}
+	if os.Getenv(ListenerFDEnv) != "" {
+		t.Error("the descriptor must be consumed once")
+	}
+}
+
+func TestListenReusePort(t *testing.T) {
+	if runtime.GOOS != "linux" && runtime.GOOS != "darwin" && runtime.GOOS != "freebsd" {
+		t.Skip("SO_REUSEPORT not supported")
+	}
+	first, err := Listen("127.0.0.1:0", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer first.Close()
+	second, err := Listen(first.Addr().String(), true)
+	if err != nil {
+		t.Fatalf("second listener on the same port: %v", err)
+	}
+	_ = second.Close()
+}
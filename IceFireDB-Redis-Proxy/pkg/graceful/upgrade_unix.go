@@ -0,0 +1,98 @@
+//go:build linux || darwin || freebsd
+
+/*
+ *
+ *  * Licensed to the Apache Software Foundation (ASF) under one or more
+ *  * contributor license agreements.  See the NOTICE file distributed with
+ *  * this work for additional information regarding copyright ownership.
+ *  * The ASF licenses this file to You under the Apache License, Version 2.0
+ *  * (the "License"); you may not use this file except in compliance with
+ *  * the License.  You may obtain a copy of the License at
+ *  *
+ *  *     http://www.apache.org/licenses/LICENSE-2.0
+ *  *
+ *  * Unless required by applicable law or agreed to in writing, software
+ *  * distributed under the License is distributed on an "AS IS" BASIS,
+ *  * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  * See the License for the specific language governing permissions and
+ *  * limitations under the License.
+ *
+ */
+
+package graceful
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// A function that sets SO_REUSEPORT on the socket before it is bound
+func reusePortControl(network, address string, c syscall.RawConn) error {
+	var err error
+	if e := c.Control(func(fd uintptr) {
+		err = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_REUSEPORT, 1)
+	}); e != nil {
+		return e
+	}
+	return err
+}
+
+// Upgrade starts the executable of the process again with its arguments,
+// handing it the socket of ln. It returns once the new process is ready,
+// the caller then drains its connections and exits. The new process is
+// killed when it is not ready within timeout.
+func Upgrade(ln *Listener, timeout time.Duration) error {
+	lnFile, err := ln.File()
+	if err != nil {
+		return err
+	}
+	defer lnFile.Close()
+	readyR, readyW, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer readyR.Close()
+
+	path, err := os.Executable()
+	if err != nil {
+		_ = readyW.Close()
+		return err
+	}
+	cmd := exec.Command(path, os.Args[1:]...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	// the extra files are the descriptors 3 and 4 of the new process
+	cmd.ExtraFiles = []*os.File{lnFile, readyW}
+	cmd.Env = append(os.Environ(), ListenerFDEnv+"=3", ReadyFDEnv+"=4")
+	err = cmd.Start()
+	_ = readyW.Close()
+	if err != nil {
+		return err
+	}
+
+	ready := make(chan error, 1)
+	go func() {
+		buf := make([]byte, 1)
+		// EOF when the new process exits before it is ready
+		_, err := readyR.Read(buf)
+		ready <- err
+	}()
+	select {
+	case err = <-ready:
+	case <-time.After(timeout):
+		err = errors.New("timeout")
+	}
+	if err != nil {
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+		return fmt.Errorf("new process %d not ready: %v", cmd.Process.Pid, err)
+	}
+	// the new process is not waited for, it outlives this one
+	_ = cmd.Process.Release()
+	return nil
+}
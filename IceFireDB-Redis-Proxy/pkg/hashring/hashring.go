@@ -0,0 +1,129 @@
+/*
+ *
+ *  * Licensed to the Apache Software Foundation (ASF) under one or more
+ *  * contributor license agreements.  See the NOTICE file distributed with
+ *  * this work for additional information regarding copyright ownership.
+ *  * The ASF licenses this file to You under the Apache License, Version 2.0
+ *  * (the "License"); you may not use this file except in compliance with
+ *  * the License.  You may obtain a copy of the License at
+ *  *
+ *  *     http://www.apache.org/licenses/LICENSE-2.0
+ *  *
+ *  * Unless required by applicable law or agreed to in writing, software
+ *  * distributed under the License is distributed on an "AS IS" BASIS,
+ *  * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  * See the License for the specific language governing permissions and
+ *  * limitations under the License.
+ *
+ */
+
+package hashring
+
+import (
+	"bytes"
+	"sort"
+	"strconv"
+
+	"github.com/cespare/xxhash/v2"
+)
+
+// DefaultVirtualNodes is the number of points each node owns on the ring
+const DefaultVirtualNodes = 160
+
+// Ring is a consistent hash ring, each node is placed on the ring as a set
+// of virtual nodes so that keys spread evenly and adding or removing a node
+// only moves the keys of that node
+type Ring struct {
+	vnodes int
+	points []uint64
+	owners map[uint64]string
+	nodes  []string
+}
+
+// New creates a ring with the given number of virtual nodes per node,
+// 0 selects DefaultVirtualNodes
+func New(vnodes int, nodes ...string) *Ring {
+	if vnodes <= 0 {
+		vnodes = DefaultVirtualNodes
+	}
+	r := &Ring{
+		vnodes: vnodes,
+		owners: make(map[uint64]string),
+	}
+	for _, node := range nodes {
+		r.Add(node)
+	}
+	return r
+}
+
+// Add places a node on the ring, adding an existing node is a no-op
+func (r *Ring) Add(node string) {
+	for _, n := range r.nodes {
+		if n == node {
+			return
+		}
+	}
+	r.nodes = append(r.nodes, node)
+	for i := 0; i < r.vnodes; i++ {
+		point := xxhash.Sum64String(node + "#" + strconv.Itoa(i))
+		if _, ok := r.owners[point]; ok {
+			continue
+		}
+		r.owners[point] = node
+		r.points = append(r.points, point)
+	}
+	sort.Slice(r.points, func(i, j int) bool { return r.points[i] < r.points[j] })
+}
+
+// Remove takes a node off the ring
+func (r *Ring) Remove(node string) {
+	for i, n := range r.nodes {
+		if n == node {
+			r.nodes = append(r.nodes[:i], r.nodes[i+1:]...)
+			break
+		}
+	}
+	points := r.points[:0]
+	for _, point := range r.points {
+		if r.owners[point] == node {
+			delete(r.owners, point)
+			continue
+		}
+		points = append(points, point)
+	}
+	r.points = points
+}
+
+// Nodes returns the nodes placed on the ring
+func (r *Ring) Nodes() []string {
+	return append([]string(nil), r.nodes...)
+}
+
+// Get returns the node owning the key, keys sharing a hash tag are
+// always owned by the same node. An empty ring returns an empty string.
+func (r *Ring) Get(key []byte) string {
+	if len(r.points) == 0 {
+		return ""
+	}
+	hash := xxhash.Sum64(HashTag(key))
+	i := sort.Search(len(r.points), func(i int) bool { return r.points[i] >= hash })
+	if i == len(r.points) {
+		i = 0
+	}
+	return r.owners[r.points[i]]
+}
+
+// HashTag returns the part of the key used for hashing. Like Redis Cluster,
+// when the key contains a non-empty {...} section only the content of the
+// first one is hashed, e.g. user:{1000}:name and user:{1000}:age are kept together
+func HashTag(key []byte) []byte {
+	start := bytes.IndexByte(key, '{')
+	if start < 0 {
+		return key
+	}
+	end := bytes.IndexByte(key[start+1:], '}')
+	if end <= 0 {
+		return key
+	}
+	return key[start+1 : start+1+end]
+}
@@ -0,0 +1,79 @@
+/*
+ *
+ *  * Licensed to the Apache Software Foundation (ASF) under one or more
+ *  * contributor license agreements.  See the NOTICE file distributed with
+ *  * this work for additional information regarding copyright ownership.
+ *  * The ASF licenses this file to You under the Apache License, Version 2.0
+ *  * (the "License"); you may not use this file except in compliance with
+ *  * the License.  You may obtain a copy of the License at
+ *  *
+ *  *     http://www.apache.org/licenses/LICENSE-2.0
+ *  *
+ *  * Unless required by applicable law or agreed to in writing, software
+ *  * distributed under the License is distributed on an "AS IS" BASIS,
+ *  * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  * See the License for the specific language governing permissions and
+ *  * limitations under the License.
+ *
+ */
+
+package hashring
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestHashTag(t *testing.T) {
+	list := []struct {
+		key, tag string
+	}{
+		{"foo", "foo"},
+		{"user:{1000}:name", "1000"},
+		{"{user}{1000}", "user"},
+		{"foo{}bar", "foo{}bar"},
+		{"foo{bar", "foo{bar"},
+		{"foo}{bar}", "bar"},
+	}
+	for _, v := range list {
+		if tag := string(HashTag([]byte(v.key))); tag != v.tag {
+			t.Errorf("hash tag error, key: %s, tag: %s, want: %s", v.key, tag, v.tag)
+		}
+	}
+}
+
+func TestRing(t *testing.T) {
+	r := New(0, "a", "b", "c")
+	if r.Get([]byte("user:{1000}:name")) != r.Get([]byte("user:{1000}:age")) {
+		t.Error("keys with the same hash tag must be on the same node")
+	}
+
+	owners := make(map[string]string)
+	count := make(map[string]int)
+	for i := 0; i < 3000; i++ {
+		key := "key:" + strconv.Itoa(i)
+		node := r.Get([]byte(key))
+		owners[key] = node
+		count[node]++
+	}
+	for _, node := range []string{"a", "b", "c"} {
+		if count[node] < 500 {
+			t.Errorf("node %s only owns %d of 3000 keys", node, count[node])
+		}
+	}
+
+	r.Remove("b")
+	for key, node := range owners {
+		got := r.Get([]byte(key))
+		if got == "b" {
+			t.Fatalf("key %s still owned by the removed node", key)
+		}
+		if node != "b" && got != node {
+			t.Errorf("key %s moved from %s to %s", key, node, got)
+		}
+	}
+
+	if New(0).Get([]byte("foo")) != "" {
+		t.Error("empty ring must not own keys")
+	}
+}
@@ -0,0 +1,271 @@
+/*
+ *
+ *  * Licensed to the Apache Software Foundation (ASF) under one or more
+ *  * contributor license agreements.  See the NOTICE file distributed with
+ *  * this work for additional information regarding copyright ownership.
+ *  * The ASF licenses this file to You under the Apache License, Version 2.0
+ *  * (the "License"); you may not use this file except in compliance with
+ *  * the License.  You may obtain a copy of the License at
+ *  *
+ *  *     http://www.apache.org/licenses/LICENSE-2.0
+ *  *
+ *  * Unless required by applicable law or agreed to in writing, software
+ *  * distributed under the License is distributed on an "AS IS" BASIS,
+ *  * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  * See the License for the specific language governing permissions and
+ *  * limitations under the License.
+ *
+ */
+
+// Package metrics records the latency of the commands, as seen by the
+// clients of the proxy and as answered by the backends, so the overhead of
+// the proxy can be told apart from the slowness of a backend
+package metrics
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Number of histogram buckets with an upper bound
+const numBounds = 22
+
+// Upper bounds of the histogram buckets, from 10µs doubling up to about 20s
+var bounds = func() []time.Duration {
+	b := make([]time.Duration, numBounds)
+	for i := range b {
+		b[i] = 10 * time.Microsecond << i
+	}
+	return b
+}()
+
+// Histogram counts durations in exponential buckets
+type Histogram struct {
+	// the last bucket counts the durations above the last bound
+	counts [numBounds + 1]uint64
+	count  uint64
+	sum    int64
+}
+
+// Observe adds a duration to the histogram
+func (h *Histogram) Observe(d time.Duration) {
+	i := sort.Search(len(bounds), func(i int) bool { return d <= bounds[i] })
+	atomic.AddUint64(&h.counts[i], 1)
+	atomic.AddInt64(&h.sum, int64(d))
+	atomic.AddUint64(&h.count, 1)
+}
+
+// Count returns the number of durations observed
+func (h *Histogram) Count() uint64 {
+	return atomic.LoadUint64(&h.count)
+}
+
+// Mean returns the mean of the durations observed
+func (h *Histogram) Mean() time.Duration {
+	count := h.Count()
+	if count == 0 {
+		return 0
+	}
+	return time.Duration(atomic.LoadInt64(&h.sum) / int64(count))
+}
+
+// Quantile estimates the duration under which the share q of the durations
+// fall, interpolated in its bucket. The durations above the last bound are
+// reported as the last bound.
+func (h *Histogram) Quantile(q float64) time.Duration {
+	var counts [numBounds + 1]uint64
+	var total uint64
+	for i := range counts {
+		counts[i] = atomic.LoadUint64(&h.counts[i])
+		total += counts[i]
+	}
+	if total == 0 {
+		return 0
+	}
+	rank := q * float64(total)
+	var seen uint64
+	for i, c := range counts {
+		if c == 0 || float64(seen+c) < rank {
+			seen += c
+			continue
+		}
+		if i == len(bounds) {
+			break
+		}
+		var lower time.Duration
+		if i > 0 {
+			lower = bounds[i-1]
+		}
+		share := (rank - float64(seen)) / float64(c)
+		return lower + time.Duration(share*float64(bounds[i]-lower))
+	}
+	return bounds[len(bounds)-1]
+}
+
+// A method that returns the cumulative counts of the buckets by upper bound
+// in seconds, the way prometheus histograms are exported
+func (h *Histogram) buckets() (map[float64]uint64, uint64, float64) {
+	buckets := make(map[float64]uint64, len(bounds))
+	var cumulative uint64
+	for i, b := range bounds {
+		cumulative += atomic.LoadUint64(&h.counts[i])
+		buckets[b.Seconds()] = cumulative
+	}
+	count := cumulative + atomic.LoadUint64(&h.counts[len(bounds)])
+	return buckets, count, time.Duration(atomic.LoadInt64(&h.sum)).Seconds()
+}
+
+// Latency is the summary of the latency of a command, in microseconds
+type Latency struct {
+	Command string `json:"command"`
+	// Backend answering the command, empty for the latency seen by the clients
+	Backend string  `json:"backend,omitempty"`
+	Count   uint64  `json:"count"`
+	Mean    float64 `json:"mean_us"`
+	P50     float64 `json:"p50_us"`
+	P90     float64 `json:"p90_us"`
+	P99     float64 `json:"p99_us"`
+	P999    float64 `json:"p999_us"`
+}
+
+func microseconds(d time.Duration) float64 {
+	return math.Round(float64(d)/float64(time.Microsecond)*10) / 10
+}
+
+func newLatency(command, backend string, h *Histogram) Latency {
+	return Latency{
+		Command: command,
+		Backend: backend,
+		Count:   h.Count(),
+		Mean:    microseconds(h.Mean()),
+		P50:     microseconds(h.Quantile(0.5)),
+		P90:     microseconds(h.Quantile(0.9)),
+		P99:     microseconds(h.Quantile(0.99)),
+		P999:    microseconds(h.Quantile(0.999)),
+	}
+}
+
+type upstream struct {
+	addr string
+	cmd  string
+}
+
+// Recorder keeps a histogram of each command, one of the time the proxy
+// takes to answer it and one of the time each backend takes, and the slow
+// log of the commands. It is a prometheus collector.
+type Recorder struct {
+	mu        sync.RWMutex
+	commands  map[string]*Histogram
+	upstreams map[upstream]*Histogram
+	slowLog   *SlowLog
+
+	commandDesc  *prometheus.Desc
+	upstreamDesc *prometheus.Desc
+	slowDesc     *prometheus.Desc
+}
+
+// NewRecorder creates a recorder logging the slow commands in slowLog
+func NewRecorder(slowLog *SlowLog) *Recorder {
+	return &Recorder{
+		commands:  make(map[string]*Histogram),
+		upstreams: make(map[upstream]*Histogram),
+		slowLog:   slowLog,
+		commandDesc: prometheus.NewDesc("redis_proxy_command_duration_seconds",
+			"Time the proxy takes to answer a command, backend time included.", []string{"cmd"}, nil),
+		upstreamDesc: prometheus.NewDesc("redis_proxy_upstream_duration_seconds",
+			"Time a backend takes to answer a command, pool wait included.", []string{"cmd", "backend"}, nil),
+		slowDesc: prometheus.NewDesc("redis_proxy_slowlog_total",
+			"Commands logged in the slow log since the start.", nil, nil),
+	}
+}
+
+// SlowLog returns the slow log of the recorder
+func (r *Recorder) SlowLog() *SlowLog {
+	return r.slowLog
+}
+
+// ObserveCommand records the time the proxy took to answer a command of a
+// client, logging it when it is slow
+func (r *Recorder) ObserveCommand(cmd string, d time.Duration, client string, args []interface{}) {
+	r.mu.RLock()
+	h, ok := r.commands[cmd]
+	r.mu.RUnlock()
+	if !ok {
+		r.mu.Lock()
+		if h, ok = r.commands[cmd]; !ok {
+			h = new(Histogram)
+			r.commands[cmd] = h
+		}
+		r.mu.Unlock()
+	}
+	h.Observe(d)
+	r.slowLog.Add(d, client, args)
+}
+
+// ObserveUpstream records the time the backend at addr took to answer a command
+func (r *Recorder) ObserveUpstream(addr, cmd string, d time.Duration) {
+	key := upstream{addr: addr, cmd: cmd}
+	r.mu.RLock()
+	h, ok := r.upstreams[key]
+	r.mu.RUnlock()
+	if !ok {
+		r.mu.Lock()
+		if h, ok = r.upstreams[key]; !ok {
+			h = new(Histogram)
+			r.upstreams[key] = h
+		}
+		r.mu.Unlock()
+	}
+	h.Observe(d)
+}
+
+// Latencies returns the latency of the commands seen by the clients followed
+// by the latency of the backends, sorted by command
+func (r *Recorder) Latencies() []Latency {
+	r.mu.RLock()
+	commands := make([]Latency, 0, len(r.commands))
+	for cmd, h := range r.commands {
+		commands = append(commands, newLatency(cmd, "", h))
+	}
+	upstreams := make([]Latency, 0, len(r.upstreams))
+	for key, h := range r.upstreams {
+		upstreams = append(upstreams, newLatency(key.cmd, key.addr, h))
+	}
+	r.mu.RUnlock()
+
+	sort.Slice(commands, func(i, j int) bool { return commands[i].Command < commands[j].Command })
+	sort.Slice(upstreams, func(i, j int) bool {
+		if upstreams[i].Command != upstreams[j].Command {
+			return upstreams[i].Command < upstreams[j].Command
+		}
+		return upstreams[i].Backend < upstreams[j].Backend
+	})
+	return append(commands, upstreams...)
+}
+
+// Describe implements prometheus.Collector
+func (r *Recorder) Describe(ch chan<- *prometheus.Desc) {
+	ch <- r.commandDesc
+	ch <- r.upstreamDesc
+	ch <- r.slowDesc
+}
+
+// Collect implements prometheus.Collector
+func (r *Recorder) Collect(ch chan<- prometheus.Metric) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for cmd, h := range r.commands {
+		buckets, count, sum := h.buckets()
+		ch <- prometheus.MustNewConstHistogram(r.commandDesc, count, sum, buckets, cmd)
+	}
+	for key, h := range r.upstreams {
+		buckets, count, sum := h.buckets()
+		ch <- prometheus.MustNewConstHistogram(r.upstreamDesc, count, sum, buckets, key.cmd, key.addr)
+	}
+	ch <- prometheus.MustNewConstMetric(r.slowDesc, prometheus.CounterValue, float64(r.slowLog.Total()))
+}
@@ -0,0 +1,150 @@
+/*
+ *
+ *  * Licensed to the Apache Software Foundation (ASF) under one or more
+ *  * contributor license agreements.  See the NOTICE file distributed with
+ *  * this work for additional information regarding copyright ownership.
+ *  * The ASF licenses this file to You under the Apache License, Version 2.0
+ *  * (the "License"); you may not use this file except in compliance with
+ *  * the License.  You may obtain a copy of the License at
+ *  *
+ *  *     http://www.apache.org/licenses/LICENSE-2.0
+ *  *
+ *  * Unless required by applicable law or agreed to in writing, software
+ *  * distributed under the License is distributed on an "AS IS" BASIS,
+ *  * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  * See the License for the specific language governing permissions and
+ *  * limitations under the License.
+ *
+ */
+
+package metrics
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestQuantile(t *testing.T) {
+	h := new(Histogram)
+	if h.Quantile(0.5) != 0 {
+		t.Fatal("quantile of an empty histogram must be 0")
+	}
+	for i := 0; i < 90; i++ {
+		h.Observe(15 * time.Microsecond)
+	}
+	for i := 0; i < 10; i++ {
+		h.Observe(time.Millisecond)
+	}
+	if p50 := h.Quantile(0.5); p50 <= 10*time.Microsecond || p50 > 20*time.Microsecond {
+		t.Errorf("p50 %v, want in (10µs, 20µs]", p50)
+	}
+	if p99 := h.Quantile(0.99); p99 <= 640*time.Microsecond || p99 > 1280*time.Microsecond {
+		t.Errorf("p99 %v, want in (640µs, 1280µs]", p99)
+	}
+	if mean := h.Mean(); mean != (90*15*time.Microsecond+10*time.Millisecond)/100 {
+		t.Errorf("mean %v", mean)
+	}
+
+	h.Observe(time.Minute)
+	if max := h.Quantile(1); max != bounds[len(bounds)-1] {
+		t.Errorf("quantile above the last bound %v", max)
+	}
+}
+
+func TestSlowLog(t *testing.T) {
+	s := NewSlowLog(2, time.Millisecond)
+	s.Add(time.Microsecond, "c", []interface{}{[]byte("GET"), []byte("fast")})
+	if s.Len() != 0 {
+		t.Fatal("fast command must not be logged")
+	}
+	for _, key := range []string{"a", "b", "c"} {
+		s.Add(2*time.Millisecond, "127.0.0.1:5000", []interface{}{[]byte("GET"), []byte(key)})
+	}
+	entries := s.Get(-1)
+	if len(entries) != 2 || entries[0].Args[1] != "c" || entries[1].Args[1] != "b" {
+		t.Fatalf("entries %+v", entries)
+	}
+	if entries[0].ID != 2 || entries[0].Duration != 2000 || entries[0].Client != "127.0.0.1:5000" {
+		t.Fatalf("entry %+v", entries[0])
+	}
+	if got := s.Get(1); len(got) != 1 || got[0].ID != 2 {
+		t.Fatalf("get 1: %+v", got)
+	}
+	s.Reset()
+	if s.Len() != 0 || s.Total() != 3 {
+		t.Fatalf("reset: len %d total %d", s.Len(), s.Total())
+	}
+
+	args := make([]interface{}, 40)
+	for i := range args {
+		args[i] = []byte(strings.Repeat("x", 200))
+	}
+	formatted := formatArgs(args)
+	if len(formatted) != maxArgs || formatted[maxArgs-1] != "... (9 more arguments)" {
+		t.Fatalf("arguments %d %q", len(formatted), formatted[maxArgs-1])
+	}
+	if formatted[0] != strings.Repeat("x", 128)+"... (72 more bytes)" {
+		t.Fatalf("argument %q", formatted[0])
+	}
+
+	disabled := NewSlowLog(128, -1)
+	disabled.Add(time.Hour, "c", nil)
+	if disabled.Len() != 0 {
+		t.Fatal("disabled slow log must not log")
+	}
+}
+
+func TestSlowLogRedaction(t *testing.T) {
+	for _, tc := range []struct {
+		args []string
+		want string
+	}{
+		{[]string{"AUTH", "secret"}, "AUTH (redacted)"},
+		{[]string{"auth", "user", "secret"}, "auth (redacted) (redacted)"},
+		{[]string{"HELLO", "3", "AUTH", "user", "secret", "SETNAME", "c"}, "HELLO 3 AUTH (redacted) (redacted) SETNAME c"},
+		{[]string{"MIGRATE", "h", "6379", "", "0", "100", "AUTH", "secret", "KEYS", "a"}, "MIGRATE h 6379  0 100 AUTH (redacted) KEYS a"},
+		{[]string{"MIGRATE", "h", "6379", "k", "0", "100", "AUTH2", "user", "secret"}, "MIGRATE h 6379 k 0 100 AUTH2 (redacted) (redacted)"},
+		{[]string{"SET", "auth", "secret"}, "SET auth secret"},
+	} {
+		args := make([]interface{}, len(tc.args))
+		for i, arg := range tc.args {
+			args[i] = []byte(arg)
+		}
+		if got := strings.Join(formatArgs(args), " "); got != tc.want {
+			t.Errorf("%q: %q", tc.args, got)
+		}
+	}
+}
+
+func TestRecorder(t *testing.T) {
+	r := NewRecorder(NewSlowLog(8, 0))
+	r.ObserveCommand("GET", 300*time.Microsecond, "c", []interface{}{[]byte("GET"), []byte("k")})
+	r.ObserveUpstream("10.0.0.2:6379", "GET", 200*time.Microsecond)
+	r.ObserveUpstream("10.0.0.1:6379", "GET", 100*time.Microsecond)
+
+	latencies := r.Latencies()
+	if len(latencies) != 3 || latencies[0].Backend != "" || latencies[1].Backend != "10.0.0.1:6379" {
+		t.Fatalf("latencies %+v", latencies)
+	}
+	if latencies[0].Count != 1 || latencies[0].Mean != 300 {
+		t.Fatalf("command latency %+v", latencies[0])
+	}
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(r)
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	counts := make(map[string]int)
+	for _, f := range families {
+		counts[f.GetName()] = len(f.GetMetric())
+	}
+	if counts["redis_proxy_command_duration_seconds"] != 1 || counts["redis_proxy_upstream_duration_seconds"] != 2 ||
+		counts["redis_proxy_slowlog_total"] != 1 {
+		t.Fatalf("metric families %v", counts)
+	}
+}
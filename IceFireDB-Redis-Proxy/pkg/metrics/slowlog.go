@@ -0,0 +1,204 @@
+/*
+ *
+ *  * Licensed to the Apache Software Foundation (ASF) under one or more
+ *  * contributor license agreements.  See the NOTICE file distributed with
+ *  * this work for additional information regarding copyright ownership.
+ *  * The ASF licenses this file to You under the Apache License, Version 2.0
+ *  * (the "License"); you may not use this file except in compliance with
+ *  * the License.  You may obtain a copy of the License at
+ *  *
+ *  *     http://www.apache.org/licenses/LICENSE-2.0
+ *  *
+ *  * Unless required by applicable law or agreed to in writing, software
+ *  * distributed under the License is distributed on an "AS IS" BASIS,
+ *  * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  * See the License for the specific language governing permissions and
+ *  * limitations under the License.
+ *
+ */
+
+package metrics
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The arguments of a command kept in the slow log are truncated like redis does
+const (
+	maxArgs   = 32
+	maxArgLen = 128
+)
+
+// Entry is a command logged in the slow log, the fields are those of the
+// redis SLOWLOG GET reply
+type Entry struct {
+	ID uint64 `json:"id"`
+	// Unix time the command ended
+	Time int64 `json:"time"`
+	// Time the proxy took to answer, in microseconds
+	Duration int64    `json:"duration"`
+	Args     []string `json:"args"`
+	Client   string   `json:"client"`
+}
+
+// SlowLog keeps the last commands slower than a threshold in a ring buffer
+type SlowLog struct {
+	mu        sync.Mutex
+	threshold time.Duration
+	entries   []Entry
+	next      int
+	full      bool
+	id        uint64
+}
+
+// NewSlowLog creates a slow log keeping the last size commands slower than
+// the threshold. A negative threshold or size disables it, 0 logs every command.
+func NewSlowLog(size int, threshold time.Duration) *SlowLog {
+	if size < 0 || threshold < 0 {
+		size = 0
+	}
+	return &SlowLog{threshold: threshold, entries: make([]Entry, size)}
+}
+
+// Add logs the command when it took longer than the threshold
+func (s *SlowLog) Add(d time.Duration, client string, args []interface{}) {
+	if d < s.threshold || len(s.entries) == 0 {
+		return
+	}
+	e := Entry{
+		Time:     time.Now().Unix(),
+		Duration: d.Microseconds(),
+		Args:     formatArgs(args),
+		Client:   client,
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e.ID = s.id
+	s.id++
+	s.entries[s.next] = e
+	s.next++
+	if s.next == len(s.entries) {
+		s.next, s.full = 0, true
+	}
+}
+
+// Get returns the last n commands logged, the newest first, every command
+// when n is negative
+func (s *SlowLog) Get(n int) []Entry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	count := s.len()
+	if n < 0 || n > count {
+		n = count
+	}
+	entries := make([]Entry, 0, n)
+	for i := 1; i <= n; i++ {
+		entries = append(entries, s.entries[(s.next-i+len(s.entries))%len(s.entries)])
+	}
+	return entries
+}
+
+// Len returns the number of commands in the slow log
+func (s *SlowLog) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.len()
+}
+
+func (s *SlowLog) len() int {
+	if s.full {
+		return len(s.entries)
+	}
+	return s.next
+}
+
+// Total returns the number of commands logged since the start, reset included
+func (s *SlowLog) Total() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.id
+}
+
+// Reset empties the slow log
+func (s *SlowLog) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.next, s.full = 0, false
+}
+
+// The arguments holding a password are replaced like redis does
+const redactedArg = "(redacted)"
+
+// A function that formats the arguments of a command for the slow log
+func formatArgs(args []interface{}) []string {
+	n := len(args)
+	if n > maxArgs {
+		n = maxArgs
+	}
+	redacted := redactedArgs(args)
+	formatted := make([]string, 0, n)
+	for i, arg := range args[:n] {
+		if i == maxArgs-1 && len(args) > maxArgs {
+			formatted = append(formatted, fmt.Sprintf("... (%d more arguments)", len(args)-maxArgs+1))
+			break
+		}
+		if redacted[i] {
+			formatted = append(formatted, redactedArg)
+			continue
+		}
+		s := argString(arg)
+		if len(s) > maxArgLen {
+			s = fmt.Sprintf("%s... (%d more bytes)", s[:maxArgLen], len(s)-maxArgLen)
+		}
+		formatted = append(formatted, s)
+	}
+	return formatted
+}
+
+func argString(arg interface{}) string {
+	switch v := arg.(type) {
+	case []byte:
+		return string(v)
+	case string:
+		return v
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// A function that returns the positions of the passwords and the user names
+// of AUTH, HELLO ... AUTH and MIGRATE ... AUTH|AUTH2
+func redactedArgs(args []interface{}) map[int]bool {
+	if len(args) < 2 {
+		return nil
+	}
+	redacted := make(map[int]bool)
+	switch strings.ToUpper(argString(args[0])) {
+	case "AUTH":
+		for i := 1; i < len(args); i++ {
+			redacted[i] = true
+		}
+	case "HELLO", "MIGRATE":
+		for i := 1; i < len(args); i++ {
+			n := 0
+			switch strings.ToUpper(argString(args[i])) {
+			case "AUTH":
+				n = 1
+				if strings.EqualFold(argString(args[0]), "HELLO") {
+					n = 2
+				}
+			case "AUTH2":
+				n = 2
+			}
+			for ; n > 0 && i+1 < len(args); n-- {
+				i++
+				redacted[i] = true
+			}
+		}
+	}
+	return redacted
+}
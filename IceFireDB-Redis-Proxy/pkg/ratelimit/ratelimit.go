@@ -0,0 +1,163 @@
+/*
+ *
+ *  * Licensed to the Apache Software Foundation (ASF) under one or more
+ *  * contributor license agreements.  See the NOTICE file distributed with
+ *  * this work for additional information regarding copyright ownership.
+ *  * The ASF licenses this file to You under the Apache License, Version 2.0
+ *  * (the "License"); you may not use this file except in compliance with
+ *  * the License.  You may obtain a copy of the License at
+ *  *
+ *  *     http://www.apache.org/licenses/LICENSE-2.0
+ *  *
+ *  * Unless required by applicable law or agreed to in writing, software
+ *  * distributed under the License is distributed on an "AS IS" BASIS,
+ *  * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  * See the License for the specific language governing permissions and
+ *  * limitations under the License.
+ *
+ */
+
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// Buckets idle for longer than this are dropped by Purge
+const idleTimeout = 10 * time.Minute
+
+// Limiter is a set of token buckets keyed by client, each bucket is refilled
+// at rate tokens per second up to burst tokens and a command takes one token
+type Limiter struct {
+	rate  float64
+	burst float64
+	now   func() time.Time
+
+	mu      sync.Mutex
+	buckets map[string]*bucket
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// NewLimiter creates a limiter allowing rate commands per second to every
+// key, with bursts of up to burst commands. A burst lower than the rate is raised to it.
+func NewLimiter(rate float64, burst int) *Limiter {
+	b := float64(burst)
+	if b < rate {
+		b = rate
+	}
+	return &Limiter{
+		rate:    rate,
+		burst:   b,
+		now:     time.Now,
+		buckets: make(map[string]*bucket),
+	}
+}
+
+// SetRate changes the rate and the burst of every key, as NewLimiter does
+func (l *Limiter) SetRate(rate float64, burst int) {
+	b := float64(burst)
+	if b < rate {
+		b = rate
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.rate, l.burst = rate, b
+}
+
+// Allow takes a token from the bucket of the key, it reports false when the bucket is empty
+func (l *Limiter) Allow(key string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+	b.tokens += now.Sub(b.last).Seconds() * l.rate
+	if b.tokens > l.burst {
+		b.tokens = l.burst
+	}
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// Purge drops the buckets of the keys that have been idle for a while,
+// they would be full again anyway
+func (l *Limiter) Purge() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	for key, b := range l.buckets {
+		if now.Sub(b.last) > idleTimeout {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// Quota counts the connections of every key against a maximum
+type Quota struct {
+	max int
+
+	mu     sync.Mutex
+	counts map[string]int
+}
+
+// NewQuota creates a quota allowing max connections to every key
+func NewQuota(max int) *Quota {
+	return &Quota{
+		max:    max,
+		counts: make(map[string]int),
+	}
+}
+
+// SetMax changes the maximum number of connections of every key, the keys
+// over the new maximum keep their connections
+func (q *Quota) SetMax(max int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.max = max
+}
+
+// Acquire counts a new connection of the key, it reports false without
+// counting it when the key already has the maximum number of connections
+func (q *Quota) Acquire(key string) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.counts[key] >= q.max {
+		return false
+	}
+	q.counts[key]++
+	return true
+}
+
+// Release uncounts a connection of the key acquired before
+func (q *Quota) Release(key string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.counts[key] <= 1 {
+		delete(q.counts, key)
+		return
+	}
+	q.counts[key]--
+}
+
+// Count returns the number of connections of the key
+func (q *Quota) Count(key string) int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.counts[key]
+}
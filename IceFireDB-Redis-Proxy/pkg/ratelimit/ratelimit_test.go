@@ -0,0 +1,114 @@
+/*
+ *
+ *  * Licensed to the Apache Software Foundation (ASF) under one or more
+ *  * contributor license agreements.  See the NOTICE file distributed with
+ *  * this work for additional information regarding copyright ownership.
+ *  * The ASF licenses this file to You under the Apache License, Version 2.0
+ *  * (the "License"); you may not use this file except in compliance with
+ *  * the License.  You may obtain a copy of the License at
+ *  *
+ *  *     http://www.apache.org/licenses/LICENSE-2.0
+ *  *
+ *  * Unless required by applicable law or agreed to in writing, software
+ *  * distributed under the License is distributed on an "AS IS" BASIS,
+ *  * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  * See the License for the specific language governing permissions and
+ *  * limitations under the License.
+ *
+ */
+
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLimiter(t *testing.T) {
+	now := time.Unix(0, 0)
+	l := NewLimiter(10, 20)
+	l.now = func() time.Time { return now }
+
+	for i := 0; i < 20; i++ {
+		if !l.Allow("a") {
+			t.Fatalf("burst must be allowed, failed at %d", i)
+		}
+	}
+	if l.Allow("a") {
+		t.Error("empty bucket must be limited")
+	}
+	if !l.Allow("b") {
+		t.Error("keys must have their own bucket")
+	}
+
+	now = now.Add(time.Second / 2)
+	for i := 0; i < 5; i++ {
+		if !l.Allow("a") {
+			t.Fatalf("refilled tokens must be allowed, failed at %d", i)
+		}
+	}
+	if l.Allow("a") {
+		t.Error("bucket must only be refilled at the rate")
+	}
+
+	now = now.Add(time.Hour)
+	l.Purge()
+	if len(l.buckets) != 0 {
+		t.Errorf("idle buckets must be purged: %d", len(l.buckets))
+	}
+}
+
+func TestQuota(t *testing.T) {
+	q := NewQuota(2)
+	if !q.Acquire("a") || !q.Acquire("a") {
+		t.Fatal("connections under the quota must be allowed")
+	}
+	if q.Acquire("a") {
+		t.Error("connection over the quota must be refused")
+	}
+	if !q.Acquire("b") {
+		t.Error("keys must have their own quota")
+	}
+	q.Release("a")
+	if !q.Acquire("a") {
+		t.Error("released connection must free the quota")
+	}
+	q.Release("b")
+	if q.Count("b") != 0 || len(q.counts) != 1 {
+		t.Errorf("released keys must be dropped: %v", q.counts)
+	}
+}
+
+func TestLimiterSetRate(t *testing.T) {
+	now := time.Unix(0, 0)
+	l := NewLimiter(1, 1)
+	l.now = func() time.Time { return now }
+
+	if !l.Allow("a") || l.Allow("a") {
+		t.Fatal("a burst of 1 must allow one command")
+	}
+	l.SetRate(10, 20)
+	now = now.Add(time.Second)
+	for i := 0; i < 10; i++ {
+		if !l.Allow("a") {
+			t.Fatalf("the bucket must refill at the new rate, failed at %d", i)
+		}
+	}
+	if l.Allow("a") {
+		t.Error("the bucket must only be refilled at the new rate")
+	}
+}
+
+func TestQuotaSetMax(t *testing.T) {
+	q := NewQuota(2)
+	q.Acquire("a")
+	q.Acquire("a")
+	q.SetMax(1)
+	if q.Acquire("a") || q.Count("a") != 2 {
+		t.Error("a key over the new maximum must keep its connections and get no more")
+	}
+	q.SetMax(3)
+	if !q.Acquire("a") {
+		t.Error("a raised maximum must allow more connections")
+	}
+}
@@ -0,0 +1,90 @@
+/*
+ *
+ *  * Licensed to the Apache Software Foundation (ASF) under one or more
+ *  * contributor license agreements.  See the NOTICE file distributed with
+ *  * this work for additional information regarding copyright ownership.
+ *  * The ASF licenses this file to You under the Apache License, Version 2.0
+ *  * (the "License"); you may not use this file except in compliance with
+ *  * the License.  You may obtain a copy of the License at
+ *  *
+ *  *     http://www.apache.org/licenses/LICENSE-2.0
+ *  *
+ *  * Unless required by applicable law or agreed to in writing, software
+ *  * distributed under the License is distributed on an "AS IS" BASIS,
+ *  * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  * See the License for the specific language governing permissions and
+ *  * limitations under the License.
+ *
+ */
+
+package router
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"sync/atomic"
+)
+
+// DefaultUser is the user authenticated by the single argument form of AUTH
+const DefaultUser = "default"
+
+// Users are the passwords of the users of the proxy keyed by name, they are
+// replaced by a config reload while the clients stay connected
+type Users struct {
+	passwords atomic.Pointer[map[string]string]
+}
+
+// NewUsers creates the users of the passwords keyed by name
+func NewUsers(passwords map[string]string) *Users {
+	u := &Users{}
+	u.Set(passwords)
+	return u
+}
+
+// Set replaces the users, the clients already authenticated stay so
+func (u *Users) Set(passwords map[string]string) {
+	u.passwords.Store(&passwords)
+}
+
+func (u *Users) password(user string) (string, bool) {
+	pass, ok := (*u.passwords.Load())[user]
+	return pass, ok
+}
+
+// AuthMiddleware authenticates the clients at the proxy against the users.
+// AUTH is answered by the proxy and every other command is refused until
+// the client is authenticated.
+// Commands synchronized from peers have no client and are not filtered.
+func AuthMiddleware(users *Users) HandlerFunc {
+	return func(context *Context) error {
+		if context.Client == nil {
+			return context.Next()
+		}
+
+		if context.Cmd == "AUTH" {
+			context.Abort()
+			user, pass := DefaultUser, ""
+			switch len(context.Args) {
+			case 2:
+				pass = argString(context.Args[1])
+			case 3:
+				user, pass = argString(context.Args[1]), argString(context.Args[2])
+			default:
+				return WriteError(context.Writer, fmt.Errorf(ErrArguments, context.Cmd))
+			}
+			want, ok := users.password(user)
+			if !ok || subtle.ConstantTimeCompare([]byte(want), []byte(pass)) != 1 {
+				return WriteError(context.Writer, errors.New(ErrWrongPass))
+			}
+			context.Client.User = user
+			return WriteSimpleString(context.Writer, "OK")
+		}
+
+		if context.Client.User == "" {
+			context.Abort()
+			return WriteError(context.Writer, errors.New(ErrNoAuth))
+		}
+		return context.Next()
+	}
+}
@@ -0,0 +1,143 @@
+/*
+ *
+ *  * Licensed to the Apache Software Foundation (ASF) under one or more
+ *  * contributor license agreements.  See the NOTICE file distributed with
+ *  * this work for additional information regarding copyright ownership.
+ *  * The ASF licenses this file to You under the Apache License, Version 2.0
+ *  * (the "License"); you may not use this file except in compliance with
+ *  * the License.  You may obtain a copy of the License at
+ *  *
+ *  *     http://www.apache.org/licenses/LICENSE-2.0
+ *  *
+ *  * Unless required by applicable law or agreed to in writing, software
+ *  * distributed under the License is distributed on an "AS IS" BASIS,
+ *  * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  * See the License for the specific language governing permissions and
+ *  * limitations under the License.
+ *
+ */
+
+package router
+
+import (
+	"bytes"
+	"fmt"
+	"path"
+
+	"github.com/IceFireDB/IceFireDB/IceFireDB-Redis-Proxy/pkg/cache"
+)
+
+// Read commands whose replies are cached, they all take a single key
+var cacheCMDs = map[string]bool{
+	"GET": true, "GETRANGE": true, "STRLEN": true, "TYPE": true,
+	"HGET": true, "HMGET": true, "HGETALL": true, "HEXISTS": true, "HLEN": true, "HKEYS": true, "HVALS": true,
+	"LRANGE": true, "LLEN": true, "LINDEX": true,
+	"SMEMBERS": true, "SISMEMBER": true, "SCARD": true,
+	"ZRANGE": true, "ZREVRANGE": true, "ZRANGEBYSCORE": true, "ZSCORE": true, "ZCARD": true, "ZRANK": true,
+}
+
+// Scripts may write any key, they flush the cache
+var cacheFlushCMDs = map[string]bool{
+	"EVAL":    true,
+	"EVALSHA": true,
+}
+
+// CacheMiddleware serves the cacheable read commands on the keys matching
+// the patterns from the cache, an empty pattern list matches every key.
+// Writes going through the proxy invalidate their keys, before and after
+// they reach the backend.
+func CacheMiddleware(c *cache.Cache, patterns []string) HandlerFunc {
+	match := func(key string) bool {
+		if len(patterns) == 0 {
+			return true
+		}
+		for _, p := range patterns {
+			if ok, _ := path.Match(p, key); ok {
+				return true
+			}
+		}
+		return false
+	}
+
+	return func(context *Context) error {
+		if len(context.Args) < 2 {
+			return context.Next()
+		}
+
+		if !context.Op.IsReadOnly() {
+			if cacheFlushCMDs[context.Cmd] {
+				c.Flush()
+				err := context.Next()
+				c.Flush()
+				return err
+			}
+			var keys []string
+			for _, i := range KeyIndex(context.Cmd, context.Args) {
+				keys = append(keys, argString(context.Args[i]))
+			}
+			c.Invalidate(keys...)
+			err := context.Next()
+			c.Invalidate(keys...)
+			return err
+		}
+
+		if !cacheCMDs[context.Cmd] {
+			return context.Next()
+		}
+		key := argString(context.Args[1])
+		if !match(key) {
+			return context.Next()
+		}
+
+		request := cacheRequest(context.Cmd, context.Args[2:])
+		if reply, ok := c.Get(key, request); ok {
+			context.Abort()
+			context.Reply = reply
+			return writeReply(context, reply)
+		}
+		stamp := c.Stamp(key)
+		if err := context.Next(); err != nil {
+			return err
+		}
+		if context.Reply != nil {
+			c.Set(key, request, context.Reply, stamp)
+		}
+		return nil
+	}
+}
+
+// A function that identifies a request on a key by the command and the other arguments
+func cacheRequest(cmd string, args []interface{}) string {
+	var buf bytes.Buffer
+	buf.WriteString(cmd)
+	for _, arg := range args {
+		buf.WriteByte(0)
+		buf.WriteString(argString(arg))
+	}
+	return buf.String()
+}
+
+func argString(arg interface{}) string {
+	switch v := arg.(type) {
+	case []byte:
+		return string(v)
+	case string:
+		return v
+	}
+	return fmt.Sprint(arg)
+}
+
+func writeReply(context *Context, reply interface{}) error {
+	switch val := reply.(type) {
+	case int64:
+		return WriteInt(context.Writer, val)
+	case []byte:
+		return WriteBulk(context.Writer, val)
+	case string:
+		return WriteSimpleString(context.Writer, val)
+	case []interface{}:
+		return RecursivelyWriteObjects(context.Writer, val...)
+	default:
+		return WriteObjects(context.Writer, reply)
+	}
+}
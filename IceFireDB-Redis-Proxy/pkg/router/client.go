@@ -0,0 +1,74 @@
+/*
+ *
+ *  * Licensed to the Apache Software Foundation (ASF) under one or more
+ *  * contributor license agreements.  See the NOTICE file distributed with
+ *  * this work for additional information regarding copyright ownership.
+ *  * The ASF licenses this file to You under the Apache License, Version 2.0
+ *  * (the "License"); you may not use this file except in compliance with
+ *  * the License.  You may obtain a copy of the License at
+ *  *
+ *  *     http://www.apache.org/licenses/LICENSE-2.0
+ *  *
+ *  * Unless required by applicable law or agreed to in writing, software
+ *  * distributed under the License is distributed on an "AS IS" BASIS,
+ *  * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  * See the License for the specific language governing permissions and
+ *  * limitations under the License.
+ *
+ */
+
+package router
+
+import (
+	"context"
+	"net"
+
+	"github.com/IceFireDB/IceFireDB/IceFireDB-Redis-Proxy/pkg/backend"
+)
+
+// Client represents the client connection a command comes from
+type Client struct {
+	Addr string
+	IP   net.IP
+	// User authenticated with AUTH, empty until then
+	User string
+	// Tenant of the client, selected by the SNI of its TLS connection
+	Tenant string
+	// Connections dedicated to the client for the blocking commands and
+	// the subscriptions, nil when the proxy does not pass them through
+	Blocking   *backend.Dedicated
+	Subscriber *backend.Subscriber
+	// Trace context of the command running, nil when it is not traced
+	Trace context.Context
+}
+
+// NewClient creates the client of a connection from its remote address
+func NewClient(addr string) *Client {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	return &Client{
+		Addr: addr,
+		IP:   net.ParseIP(host),
+	}
+}
+
+// Key returns the key the limits of the client are counted by: the user
+// when byUser is set and the client is authenticated, the IP otherwise
+func (c *Client) Key(byUser bool) string {
+	if byUser && c.User != "" {
+		return "user:" + c.User
+	}
+	return "ip:" + c.IP.String()
+}
+
+// Close closes the connections dedicated to the client
+func (c *Client) Close() {
+	if c.Blocking != nil {
+		_ = c.Blocking.Close()
+	}
+	if c.Subscriber != nil {
+		_ = c.Subscriber.Close()
+	}
+}
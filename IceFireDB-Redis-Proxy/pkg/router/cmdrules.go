@@ -0,0 +1,166 @@
+/*
+ *
+ *  * Licensed to the Apache Software Foundation (ASF) under one or more
+ *  * contributor license agreements.  See the NOTICE file distributed with
+ *  * this work for additional information regarding copyright ownership.
+ *  * The ASF licenses this file to You under the Apache License, Version 2.0
+ *  * (the "License"); you may not use this file except in compliance with
+ *  * the License.  You may obtain a copy of the License at
+ *  *
+ *  *     http://www.apache.org/licenses/LICENSE-2.0
+ *  *
+ *  * Unless required by applicable law or agreed to in writing, software
+ *  * distributed under the License is distributed on an "AS IS" BASIS,
+ *  * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  * See the License for the specific language governing permissions and
+ *  * limitations under the License.
+ *
+ */
+
+package router
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// Actions of the command rules
+const (
+	RuleAllow   = "allow"
+	RuleDeny    = "deny"
+	RuleRename  = "rename"
+	RuleRewrite = "rewrite"
+)
+
+// CMDScanKeys is the internal command KEYS is rewritten to, it walks the
+// keyspace with SCAN instead of blocking the backend
+const CMDScanKeys = "SCANKEYS"
+
+// Supported rewrites, by command and target
+var cmdRewrites = map[string]map[string]string{
+	"KEYS": {"SCAN": CMDScanKeys},
+}
+
+const ErrCMDDenied = "ERR command '%s' is denied by the proxy rules"
+
+// CMDRule represents a rule blocking, renaming or rewriting a command
+// for the clients it matches
+type CMDRule struct {
+	Command string
+	Action  string
+	// Rename: command name sent to the backend, rewrite: internal command handling it
+	To string
+	// Matched client networks, empty matches every client
+	Clients []*net.IPNet
+}
+
+// NewCMDRule validates and creates a command rule, clients are IPs or CIDRs
+func NewCMDRule(command, action, to string, clients []string) (*CMDRule, error) {
+	r := &CMDRule{
+		Command: strings.ToUpper(command),
+		Action:  strings.ToLower(action),
+		To:      strings.ToUpper(to),
+	}
+	if _, ok := OpTable[r.Command]; !ok {
+		return nil, fmt.Errorf("unknown rule command: %s", command)
+	}
+	switch r.Action {
+	case RuleAllow, RuleDeny:
+	case RuleRename:
+		if r.To == "" {
+			return nil, fmt.Errorf("rename rule of %s requires a target", r.Command)
+		}
+	case RuleRewrite:
+		to, ok := cmdRewrites[r.Command][r.To]
+		if !ok {
+			return nil, fmt.Errorf("unsupported rewrite of %s to %s", r.Command, r.To)
+		}
+		r.To = to
+	default:
+		return nil, fmt.Errorf("unknown rule action: %s", action)
+	}
+
+	for _, c := range clients {
+		if !strings.Contains(c, "/") {
+			if ip := net.ParseIP(c); ip != nil && ip.To4() != nil {
+				c += "/32"
+			} else {
+				c += "/128"
+			}
+		}
+		_, ipnet, err := net.ParseCIDR(c)
+		if err != nil {
+			return nil, fmt.Errorf("invalid rule client %s: %w", c, err)
+		}
+		r.Clients = append(r.Clients, ipnet)
+	}
+	return r, nil
+}
+
+func (r *CMDRule) match(cmd string, client *Client) bool {
+	if r.Command != cmd {
+		return false
+	}
+	if len(r.Clients) == 0 {
+		return true
+	}
+	if client == nil || client.IP == nil {
+		return false
+	}
+	for _, n := range r.Clients {
+		if n.Contains(client.IP) {
+			return true
+		}
+	}
+	return false
+}
+
+// CMDRulesMiddleware applies the first matching rule to the commands of the
+// clients, a rewrite replaces the final handler of the command.
+// Commands synchronized from peers have no client and are not filtered.
+func CMDRulesMiddleware(routes IRoutes, rules []*CMDRule) HandlerFunc {
+	// Commands only reachable through a rewrite stay denied to the other clients
+	fenced := make(map[string]bool)
+	for _, r := range rules {
+		if op := OpTable[r.Command]; r.Action == RuleRewrite && op.Flag.IsNotAllowed() {
+			op.Flag &^= FlagNotAllow
+			OpTable[r.Command] = op
+			fenced[r.Command] = true
+		}
+	}
+
+	return func(context *Context) error {
+		if context.Client == nil {
+			return context.Next()
+		}
+		for _, r := range rules {
+			if !r.match(context.Cmd, context.Client) {
+				continue
+			}
+			switch r.Action {
+			case RuleDeny:
+				context.Abort()
+				return WriteError(context.Writer, fmt.Errorf(ErrCMDDenied, context.Cmd))
+			case RuleRename:
+				context.Cmd = r.To
+			case RuleRewrite:
+				handler := routes.Handler(r.To)
+				if handler == nil {
+					context.Abort()
+					return WriteError(context.Writer, fmt.Errorf(ErrUnknownCommand, context.Cmd))
+				}
+				handlers := append(HandlersChain(nil), context.Handlers...)
+				handlers[len(handlers)-1] = handler
+				context.Cmd = r.To
+				context.Handlers = handlers
+			}
+			return context.Next()
+		}
+		if fenced[context.Cmd] {
+			context.Abort()
+			return WriteError(context.Writer, fmt.Errorf(ErrCMDDenied, context.Cmd))
+		}
+		return context.Next()
+	}
+}
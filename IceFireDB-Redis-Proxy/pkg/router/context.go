@@ -20,6 +20,7 @@
 package router
 
 import (
+	"context"
 	"math"
 
 	"github.com/IceFireDB/components-go/RESPHandle"
@@ -36,6 +37,8 @@ type Context struct {
 	Index    int8
 	Op       OpFlag
 	Reply    interface{}
+	// Client of the command, nil when synchronized from a peer
+	Client *Client
 }
 
 func (c *Context) Reset() {
@@ -44,6 +47,16 @@ func (c *Context) Reset() {
 	c.Handlers = nil
 	c.Index = -1
 	c.Reply = nil
+	c.Client = nil
+}
+
+// TraceContext returns the trace context of the command, the background for
+// the commands that are not traced
+func (c *Context) TraceContext() context.Context {
+	if c.Client == nil || c.Client.Trace == nil {
+		return context.Background()
+	}
+	return c.Client.Trace
 }
 
 func (c *Context) Next() error {
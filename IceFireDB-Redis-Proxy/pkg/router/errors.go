@@ -30,4 +30,8 @@ var (
 const (
 	ErrUnknownCommand = "ERR command resp type not support`%s`"
 	ErrArguments      = "ERR wrong number of arguments for '%s' command"
+	ErrNoAuth         = "NOAUTH Authentication required."
+	ErrWrongPass      = "WRONGPASS invalid username-password pair"
+	ErrRateLimited    = "LIMIT rate limit exceeded, retry later"
+	ErrConnLimited    = "LIMIT too many connections"
 )
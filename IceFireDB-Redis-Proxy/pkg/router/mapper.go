@@ -96,16 +96,16 @@ func init() {
 	for _, i := range []OpInfo{
 		{"APPEND", FlagWrite, equal(3)},
 		{"ASKING", FlagNotAllow, equal(2)},
-		{"AUTH", 0, equal(2)},
+		{"AUTH", 0, greater(2)},
 		{"BGREWRITEAOF", FlagNotAllow, greater(1)},
 		{"BGSAVE", FlagNotAllow, greater(1)},
 		{"BITCOUNT", 0, greater(2)},
 		{"BITFIELD", FlagWrite, greater(2)},
 		{"BITOP", FlagWrite | FlagNotAllow, greater(4)},
 		{"BITPOS", 0, greater(3)},
-		{"BLPOP", FlagWrite | FlagNotAllow, greater(3)},
-		{"BRPOP", FlagWrite | FlagNotAllow, greater(3)},
-		{"BRPOPLPUSH", FlagWrite | FlagNotAllow, equal(4)},
+		{"BLPOP", FlagWrite, greater(3)},
+		{"BRPOP", FlagWrite, greater(3)},
+		{"BRPOPLPUSH", FlagWrite, equal(4)},
 		{"CLIENT", FlagNotAllow, greater(1)},
 		{"CLUSTER", FlagNotAllow, greater(1)},
 		{"COMMAND", 0, greater(1)},
@@ -188,12 +188,12 @@ func init() {
 		{"PING", 0, greater(1)},
 		{"POST", FlagNotAllow, greater(1)},
 		{"PSETEX", FlagWrite, equal(4)},
-		{"PSUBSCRIBE", FlagNotAllow, greater(2)},
+		{"PSUBSCRIBE", 0, greater(2)},
 		{"PSYNC", FlagNotAllow, greater(1)},
 		{"PTTL", 0, equal(2)},
-		{"PUBLISH", FlagNotAllow, equal(3)},
+		{"PUBLISH", 0, equal(3)},
 		{"PUBSUB", 0, greater(2)},
-		{"PUNSUBSCRIBE", FlagNotAllow, greater(1)},
+		{"PUNSUBSCRIBE", 0, greater(1)},
 		{"QUIT", FlagNotAllow, greater(1)},
 		{"RANDOMKEY", FlagNotAllow, greater(1)},
 		{"READONLY", FlagNotAllow, greater(1)},
@@ -257,7 +257,7 @@ func init() {
 		{"SREM", FlagWrite, greater(3)},
 		{"SSCAN", FlagMasterOnly, greater(3)},
 		{"STRLEN", 0, equal(2)},
-		{"SUBSCRIBE", FlagNotAllow, greater(1)},
+		{"SUBSCRIBE", 0, greater(1)},
 		{"SUBSTR", 0, equal(4)},
 		{"SUNION", FlagNotAllow, greater(1)},
 		{"SUNIONSTORE", FlagWrite | FlagNotAllow, greater(1)},
@@ -266,7 +266,7 @@ func init() {
 		{"TOUCH", FlagWrite | FlagNotAllow, greater(1)},
 		{"TTL", 0, equal(2)},
 		{"TYPE", 0, equal(2)},
-		{"UNSUBSCRIBE", FlagNotAllow, greater(1)},
+		{"UNSUBSCRIBE", 0, greater(1)},
 		{"UNWATCH", FlagNotAllow, greater(1)},
 		{"WAIT", FlagNotAllow, greater(1)},
 		{"WATCH", FlagNotAllow, greater(1)},
@@ -302,7 +302,7 @@ func init() {
 		{"XINFO", 0, greater(3)},
 		{"XPENDING", 0, greater(3)},
 		{"XRANGE", 0, greater(4)},
-		{"XREAD", FlagWrite | FlagNotAllow, greater(4)},
+		{"XREAD", 0, greater(4)},
 		{"XREADGROUP", FlagWrite, greater(7)},
 		{"XREVRANGE", FlagWrite, greater(4)},
 		{"XTRIM", FlagWrite, greater(4)},
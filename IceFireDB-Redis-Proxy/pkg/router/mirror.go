@@ -0,0 +1,68 @@
+/*
+ *
+ *  * Licensed to the Apache Software Foundation (ASF) under one or more
+ *  * contributor license agreements.  See the NOTICE file distributed with
+ *  * this work for additional information regarding copyright ownership.
+ *  * The ASF licenses this file to You under the Apache License, Version 2.0
+ *  * (the "License"); you may not use this file except in compliance with
+ *  * the License.  You may obtain a copy of the License at
+ *  *
+ *  *     http://www.apache.org/licenses/LICENSE-2.0
+ *  *
+ *  * Unless required by applicable law or agreed to in writing, software
+ *  * distributed under the License is distributed on an "AS IS" BASIS,
+ *  * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  * See the License for the specific language governing permissions and
+ *  * limitations under the License.
+ *
+ */
+
+package router
+
+import (
+	"github.com/IceFireDB/IceFireDB/IceFireDB-Redis-Proxy/pkg/backend"
+)
+
+// Commands never mirrored: local commands, commands bound to the
+// connection and commands that would block the mirror workers
+var mirrorSkipCMDs = map[string]bool{
+	"PING":      true,
+	"QUIT":      true,
+	"COMMAND":   true,
+	"AUTH":      true,
+	"SELECT":    true,
+	CMDScanKeys: true,
+}
+
+// MirrorMiddleware duplicates the commands on the keys sampled by the mirror
+// to its shadow backend, with writeOnly only the write commands. Commands are
+// mirrored once they ran, as sent to the backend. Commands synchronized from
+// peers are not mirrored.
+func MirrorMiddleware(m *backend.Mirror, writeOnly bool) HandlerFunc {
+	return func(context *Context) error {
+		if context.Client == nil || mirrorSkipCMDs[context.Cmd] || blockingCMDs[context.Cmd] ||
+			subscribeModeCMDs[context.Cmd] || (writeOnly && context.Op.IsReadOnly()) {
+			return context.Next()
+		}
+		var key []byte
+		if keyIndex := KeyIndex(context.Cmd, context.Args); len(keyIndex) > 0 {
+			key, _ = context.Args[keyIndex[0]].([]byte)
+		}
+		if !m.Sampled(key) {
+			return context.Next()
+		}
+
+		// the arguments are reused once the command is done
+		cmd := context.Cmd
+		args := make([]interface{}, len(context.Args)-1)
+		for k, v := range context.Args[1:] {
+			if b, ok := v.([]byte); ok {
+				v = append([]byte(nil), b...)
+			}
+			args[k] = v
+		}
+		err := context.Next()
+		m.Send(cmd, args...)
+		return err
+	}
+}
@@ -21,6 +21,8 @@ package router
 
 import (
 	"bytes"
+	"strconv"
+	"strings"
 	"sync"
 )
 
@@ -50,11 +52,119 @@ func OddKey(arg []interface{}) []uint8 {
 	return index
 }
 
+// The keys of XREAD and XREADGROUP are the first half of the arguments following STREAMS
+func streamKeys(arg []interface{}) []uint8 {
+	for i := 1; i < len(arg); i++ {
+		if b, ok := arg[i].([]byte); ok && strings.EqualFold(string(b), "STREAMS") {
+			index := make([]uint8, (len(arg)-i-1)/2)
+			for k := range index {
+				index[k] = uint8(i + 1 + k)
+			}
+			return index
+		}
+	}
+	return nil
+}
+
+// The keys of the blocking pops are the arguments before the timeout
+func allButLastKey(arg []interface{}) []uint8 {
+	return allKey(arg[:len(arg)-1])
+}
+
+// The source and the destination of the moves
+func twoKeys(arg []interface{}) []uint8 {
+	if len(arg) < 3 {
+		return FirstKeyIndex
+	}
+	return []uint8{1, 2}
+}
+
+// The keys of BITOP follow the operation
+func bitopKeys(arg []interface{}) []uint8 {
+	index := make([]uint8, 0, len(arg)-2)
+	for i := 2; i < len(arg); i++ {
+		index = append(index, uint8(i))
+	}
+	return index
+}
+
+// The keys counted by the numkeys argument at pos, following the
+// destination of the STORE commands
+func numKeys(pos int) makeKeyFunc {
+	return func(arg []interface{}) []uint8 {
+		var index []uint8
+		for i := 1; i < pos; i++ {
+			index = append(index, uint8(i))
+		}
+		if pos >= len(arg) {
+			return index
+		}
+		b, _ := arg[pos].([]byte)
+		n, err := strconv.Atoi(string(b))
+		if err != nil || n < 0 || pos+n >= len(arg) {
+			return index
+		}
+		for i := pos + 1; i <= pos+n; i++ {
+			index = append(index, uint8(i))
+		}
+		return index
+	}
+}
+
 var cmdKeyMap = map[string]makeKeyFunc{
-	"MGET":   allKey,
-	"MSET":   OddKey,
-	"DEL":    allKey,
-	"EXISTS": allKey,
+	"MGET":         allKey,
+	"MSET":         OddKey,
+	"MSETNX":       OddKey,
+	"DEL":          allKey,
+	"UNLINK":       allKey,
+	"EXISTS":       allKey,
+	"TOUCH":        allKey,
+	"WATCH":        allKey,
+	"SINTER":       allKey,
+	"SUNION":       allKey,
+	"SDIFF":        allKey,
+	"SINTERSTORE":  allKey,
+	"SUNIONSTORE":  allKey,
+	"SDIFFSTORE":   allKey,
+	"PFCOUNT":      allKey,
+	"PFMERGE":      allKey,
+	"RENAME":       twoKeys,
+	"RENAMENX":     twoKeys,
+	"COPY":         twoKeys,
+	"SMOVE":        twoKeys,
+	"RPOPLPUSH":    twoKeys,
+	"LMOVE":        twoKeys,
+	"BRPOPLPUSH":   twoKeys,
+	"BLMOVE":       twoKeys,
+	"BLPOP":        allButLastKey,
+	"BRPOP":        allButLastKey,
+	"BZPOPMIN":     allButLastKey,
+	"BZPOPMAX":     allButLastKey,
+	"BITOP":        bitopKeys,
+	"ZUNION":       numKeys(1),
+	"ZINTER":       numKeys(1),
+	"ZDIFF":        numKeys(1),
+	"ZUNIONSTORE":  numKeys(2),
+	"ZINTERSTORE":  numKeys(2),
+	"ZDIFFSTORE":   numKeys(2),
+	"XREAD":        streamKeys,
+	"XREADGROUP":   streamKeys,
+	"SUBSCRIBE":    allKey,
+	"UNSUBSCRIBE":  allKey,
+	"PSUBSCRIBE":   allKey,
+	"PUNSUBSCRIBE": allKey,
+}
+
+// KeyIndex returns the positions of the keys in the arguments of a command
+func KeyIndex(cmd string, args []interface{}) []uint8 {
+	if len(args) == 1 {
+		return nil
+	}
+	fn, ok := cmdKeyMap[cmd]
+	if !ok {
+		fn = firstKey
+	}
+	return fn(args)
 }
 
 func Namespace(prefix []byte) HandlerFunc {
@@ -68,11 +178,7 @@ func Namespace(prefix []byte) HandlerFunc {
 		if len(c.Args) == 1 {
 			return c.Next()
 		}
-		fn, ok := cmdKeyMap[c.Cmd]
-		if !ok {
-			fn = firstKey
-		}
-		keyIndex := fn(c.Args)
+		keyIndex := KeyIndex(c.Cmd, c.Args)
 		for _, v := range keyIndex {
 			buf := npool.Get().(*bytes.Buffer)
 			defer func() {
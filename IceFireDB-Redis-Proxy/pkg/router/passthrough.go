@@ -0,0 +1,206 @@
+/*
+ *
+ *  * Licensed to the Apache Software Foundation (ASF) under one or more
+ *  * contributor license agreements.  See the NOTICE file distributed with
+ *  * this work for additional information regarding copyright ownership.
+ *  * The ASF licenses this file to You under the Apache License, Version 2.0
+ *  * (the "License"); you may not use this file except in compliance with
+ *  * the License.  You may obtain a copy of the License at
+ *  *
+ *  *     http://www.apache.org/licenses/LICENSE-2.0
+ *  *
+ *  * Unless required by applicable law or agreed to in writing, software
+ *  * distributed under the License is distributed on an "AS IS" BASIS,
+ *  * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  * See the License for the specific language governing permissions and
+ *  * limitations under the License.
+ *
+ */
+
+package router
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/IceFireDB/IceFireDB/IceFireDB-Redis-Proxy/pkg/backend"
+	"github.com/gomodule/redigo/redis"
+)
+
+// The command of the routers handling every command without its own handler
+const cmdExec = "CMDEXEC"
+
+const ErrSubscribeMode = "ERR Can't execute '%s': only (P)SUBSCRIBE / (P)UNSUBSCRIBE / PING / QUIT are allowed in this context"
+
+// Upstreams resolves the backends the blocking and subscription commands
+// are passed through to, it is implemented by the routers
+type Upstreams interface {
+	// Upstream returns the address of the backend serving a key or a channel
+	Upstream(key []byte) string
+	// PatternUpstreams returns the addresses of the backends a pattern is subscribed on
+	PatternUpstreams(pattern []byte) []string
+	// UpstreamOptions returns the connection options of the backends
+	UpstreamOptions() backend.Options
+}
+
+// Commands blocking the connection they run on,
+// the stream commands only block with the BLOCK option
+var blockingCMDs = map[string]bool{
+	"BLPOP":      true,
+	"BRPOP":      true,
+	"BRPOPLPUSH": true,
+	"XREAD":      true,
+	"XREADGROUP": true,
+}
+
+// Commands allowed to a client in subscribe mode
+var subscribeModeCMDs = map[string]bool{
+	"SUBSCRIBE":    true,
+	"UNSUBSCRIBE":  true,
+	"PSUBSCRIBE":   true,
+	"PUNSUBSCRIBE": true,
+	"PING":         true,
+	"QUIT":         true,
+}
+
+// AddPassthroughCommands registers the blocking and subscription commands on
+// the router. They run on the connections dedicated to the client instead of
+// the pools, blocking commands of clients without dedicated connections or
+// synchronized from peers fall back to the CMDEXEC handler.
+func AddPassthroughCommands(r IRoutes, u Upstreams) {
+	for cmd := range blockingCMDs {
+		r.AddCommand(cmd, blockingHandler(r, u))
+	}
+	r.AddCommand("SUBSCRIBE", subscribeHandler(u, false))
+	r.AddCommand("UNSUBSCRIBE", subscribeHandler(u, false))
+	r.AddCommand("PSUBSCRIBE", subscribeHandler(u, true))
+	r.AddCommand("PUNSUBSCRIBE", subscribeHandler(u, true))
+}
+
+// SubscribeModeMiddleware restricts the clients with subscriptions to the
+// subscription commands, and answers their PING the way redis does in
+// subscribe mode. The replies are written between the pushed messages.
+func SubscribeModeMiddleware() HandlerFunc {
+	return func(context *Context) error {
+		if context.Client == nil || context.Client.Subscriber == nil || context.Client.Subscriber.Count() == 0 {
+			return context.Next()
+		}
+		sub := context.Client.Subscriber
+
+		switch {
+		case context.Cmd == "PING":
+			context.Abort()
+			payload := []byte{}
+			if len(context.Args) > 1 {
+				payload, _ = context.Args[1].([]byte)
+			}
+			return sub.WithLock(func() error {
+				return RecursivelyWriteObjects(context.Writer, []byte("pong"), payload)
+			})
+		case !subscribeModeCMDs[context.Cmd]:
+			context.Abort()
+			return sub.WithLock(func() error {
+				return WriteError(context.Writer, fmt.Errorf(ErrSubscribeMode, strings.ToLower(context.Cmd)))
+			})
+		}
+		return context.Next()
+	}
+}
+
+// A function that returns the handler of the blocking commands, they run on
+// the dedicated connection to the backend of their first key. The keys of a
+// command blocking on several keys must live on the same backend.
+func blockingHandler(r IRoutes, u Upstreams) HandlerFunc {
+	return func(context *Context) error {
+		if context.Client == nil || context.Client.Blocking == nil || !blocks(context.Cmd, context.Args) {
+			if h := r.Handler(cmdExec); h != nil {
+				return h(context)
+			}
+			return nil
+		}
+
+		var key []byte
+		if keyIndex := KeyIndex(context.Cmd, context.Args); len(keyIndex) > 0 {
+			key, _ = context.Args[keyIndex[0]].([]byte)
+		}
+		var err error
+		context.Reply, err = context.Client.Blocking.Do(u.Upstream(key), u.UpstreamOptions(), context.Cmd, context.Args[1:]...)
+		if err != nil && err != redis.ErrNil {
+			return WriteError(context.Writer, err)
+		}
+		if context.Reply == nil {
+			return WriteBulk(context.Writer, nil)
+		}
+		return writeReply(context, context.Reply)
+	}
+}
+
+// IsBlocking reports whether a command blocks with its arguments
+func IsBlocking(cmd string, args []interface{}) bool {
+	return blockingCMDs[cmd] && blocks(cmd, args)
+}
+
+// A function that reports whether a blocking command blocks with its arguments
+func blocks(cmd string, args []interface{}) bool {
+	if !strings.HasPrefix(cmd, "X") {
+		return true
+	}
+	for _, arg := range args[1:] {
+		if b, ok := arg.([]byte); ok && strings.EqualFold(string(b), "BLOCK") {
+			return true
+		}
+	}
+	return false
+}
+
+// A function that returns the handler of the subscription commands. Channels
+// are subscribed on the backend serving them like keys, patterns on the
+// backends their messages may be published on. The replies are written to
+// the client by its subscriber as they come.
+func subscribeHandler(u Upstreams, pattern bool) HandlerFunc {
+	return func(context *Context) error {
+		if context.Client == nil || context.Client.Subscriber == nil {
+			return WriteError(context.Writer, fmt.Errorf(ErrUnknownCommand, context.Cmd))
+		}
+		sub := context.Client.Subscriber
+		opt := u.UpstreamOptions()
+
+		// unsubscribe from everything
+		if len(context.Args) == 1 {
+			addrs := sub.Addrs(pattern)
+			if len(addrs) == 0 {
+				return sub.WithLock(func() error {
+					return RecursivelyWriteObjects(context.Writer, []byte(strings.ToLower(context.Cmd)), nil, sub.Count())
+				})
+			}
+			for _, addr := range addrs {
+				if err := sub.Send(addr, opt, context.Cmd); err != nil {
+					return sub.WithLock(func() error { return WriteError(context.Writer, err) })
+				}
+			}
+			return nil
+		}
+
+		var order []string
+		batches := make(map[string][]interface{})
+		for _, arg := range context.Args[1:] {
+			name, _ := arg.([]byte)
+			addrs := []string{u.Upstream(name)}
+			if pattern {
+				addrs = u.PatternUpstreams(name)
+			}
+			for _, addr := range addrs {
+				if _, ok := batches[addr]; !ok {
+					order = append(order, addr)
+				}
+				batches[addr] = append(batches[addr], arg)
+			}
+		}
+		for _, addr := range order {
+			if err := sub.Send(addr, opt, context.Cmd, batches[addr]...); err != nil {
+				return sub.WithLock(func() error { return WriteError(context.Writer, err) })
+			}
+		}
+		return nil
+	}
+}
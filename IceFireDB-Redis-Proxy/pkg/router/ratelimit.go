@@ -0,0 +1,39 @@
+/*
+ *
+ *  * Licensed to the Apache Software Foundation (ASF) under one or more
+ *  * contributor license agreements.  See the NOTICE file distributed with
+ *  * this work for additional information regarding copyright ownership.
+ *  * The ASF licenses this file to You under the Apache License, Version 2.0
+ *  * (the "License"); you may not use this file except in compliance with
+ *  * the License.  You may obtain a copy of the License at
+ *  *
+ *  *     http://www.apache.org/licenses/LICENSE-2.0
+ *  *
+ *  * Unless required by applicable law or agreed to in writing, software
+ *  * distributed under the License is distributed on an "AS IS" BASIS,
+ *  * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  * See the License for the specific language governing permissions and
+ *  * limitations under the License.
+ *
+ */
+
+package router
+
+import (
+	"errors"
+
+	"github.com/IceFireDB/IceFireDB/IceFireDB-Redis-Proxy/pkg/ratelimit"
+)
+
+// RateLimitMiddleware limits the commands of every client with a token bucket,
+// keyed by the authenticated user when byUser is set and by the client IP
+// otherwise. Commands synchronized from peers are not limited.
+func RateLimitMiddleware(l *ratelimit.Limiter, byUser bool) HandlerFunc {
+	return func(context *Context) error {
+		if context.Client == nil || l.Allow(context.Client.Key(byUser)) {
+			return context.Next()
+		}
+		context.Abort()
+		return WriteError(context.Writer, errors.New(ErrRateLimited))
+	}
+}
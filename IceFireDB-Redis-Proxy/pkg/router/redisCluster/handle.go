@@ -21,7 +21,7 @@ package redisCluster
 
 import (
 	"github.com/IceFireDB/IceFireDB/IceFireDB-Redis-Proxy/pkg/router"
-	rediscluster "github.com/chasex/redis-go-cluster"
+	"github.com/gomodule/redigo/redis"
 )
 
 var (
@@ -39,12 +39,16 @@ func (r *Router) cmdPING(s *router.Context) error {
 }
 
 func (r *Router) cmdCMDEXEC(s *router.Context) error {
-	var err error
-
-	s.Reply, err = r.redisCluster.Do(s.Cmd, s.Args[1:]...)
+	var key []byte
+	if keyIndex := router.KeyIndex(s.Cmd, s.Args); len(keyIndex) > 0 {
+		key = keyBytes(s.Args[keyIndex[0]])
+	}
 
-	if err != nil && err != rediscluster.ErrNil {
-		return err
+	var err error
+	s.Reply, err = r.redisCluster.Do(key, s.Cmd, s.Args[1:]...)
+	if err != nil && err != redis.ErrNil {
+		_ = router.WriteError(s.Writer, err)
+		return nil
 	}
 
 	if s.Reply == nil {
@@ -76,3 +80,17 @@ func (r *Router) cmdQUIT(s *router.Context) error {
 	s.Reply = okReply
 	return router.WriteSimpleString(s.Writer, okReply)
 }
+
+func (r *Router) cmdSCANKEYS(s *router.Context) error {
+	pattern := interface{}("*")
+	if len(s.Args) > 1 {
+		pattern = s.Args[1]
+	}
+
+	keys, err := r.redisCluster.ScanKeys(pattern)
+	if err != nil {
+		return router.WriteError(s.Writer, err)
+	}
+	s.Reply = keys
+	return router.RecursivelyWriteObjects(s.Writer, keys...)
+}
@@ -27,12 +27,14 @@ import (
 
 	"github.com/sirupsen/logrus"
 
+	"github.com/IceFireDB/IceFireDB/IceFireDB-Redis-Proxy/pkg/backend"
 	"github.com/IceFireDB/IceFireDB/IceFireDB-Redis-Proxy/pkg/router"
 	"github.com/IceFireDB/components-go/RESPHandle"
-	rediscluster "github.com/chasex/redis-go-cluster"
 )
 
-func NewRouter(cluster *rediscluster.Cluster) *Router {
+// NewRouter creates a router sending every command to the cluster node
+// owning the slot of its key
+func NewRouter(cluster *backend.Cluster) *Router {
 	r := &Router{
 		redisCluster: cluster,
 		cmd:          make(map[string]router.HandlersChain),
@@ -54,9 +56,12 @@ func (r *Router) InitCMD() {
 	r.AddCommand("DEL", r.cmdDEL)
 	r.AddCommand("EXISTS", r.cmdEXISTS)
 	r.AddCommand("MGET", r.cmdMGET)
+	r.AddCommand("MSET", r.cmdMSET)
+	r.AddCommand(router.CMDScanKeys, r.cmdSCANKEYS)
+	router.AddPassthroughCommands(r, r)
 }
 
-func (r *Router) Handle(w *RESPHandle.WriterHandle, args []interface{}) error {
+func (r *Router) Handle(w *RESPHandle.WriterHandle, client *router.Client, args []interface{}) error {
 	defer func() {
 		if r := recover(); r != nil {
 			logrus.Error("handle panic", r)
@@ -90,6 +95,7 @@ func (r *Router) Handle(w *RESPHandle.WriterHandle, args []interface{}) error {
 	c.Cmd = cmdType
 	c.Op = op.Flag
 	c.Reply = nil
+	c.Client = client
 
 	return c.Next()
 }
@@ -118,14 +124,45 @@ func (r *Router) Sync(args []interface{}) error {
 }
 
 var _ router.IRoutes = (*Router)(nil)
+var _ router.Upstreams = (*Router)(nil)
 
 type Router struct {
-	redisCluster *rediscluster.Cluster
+	redisCluster *backend.Cluster
 	MiddleWares  router.HandlersChain
 	cmd          map[string]router.HandlersChain
 	pool         sync.Pool
 }
 
+func keyBytes(key interface{}) []byte {
+	switch k := key.(type) {
+	case []byte:
+		return k
+	case string:
+		return []byte(k)
+	}
+	return []byte(fmt.Sprint(key))
+}
+
+// Upstream returns the node owning the slot of the key
+func (r *Router) Upstream(key []byte) string {
+	return r.redisCluster.Addr(key)
+}
+
+// PatternUpstreams returns a single node, the cluster
+// propagates the published messages to every node
+func (r *Router) PatternUpstreams(pattern []byte) []string {
+	return []string{r.redisCluster.Addr(pattern)}
+}
+
+// UpstreamOptions returns the connection options of the nodes
+func (r *Router) UpstreamOptions() backend.Options {
+	return r.redisCluster.Options()
+}
+
+func (r *Router) Handler(cmd string) router.HandlerFunc {
+	return r.cmd[cmd].Last()
+}
+
 func (r *Router) Use(funcs ...router.HandlerFunc) router.IRoutes {
 	r.MiddleWares = append(r.MiddleWares, funcs...)
 	return r
@@ -138,8 +175,7 @@ func (r *Router) AddCommand(operation string, handlers ...router.HandlerFunc) ro
 }
 
 func (r *Router) Close() error {
-	r.redisCluster.Close()
-	return nil
+	return r.redisCluster.Close()
 }
 
 func (r *Router) addRoute(operation string, handlers router.HandlersChain) {
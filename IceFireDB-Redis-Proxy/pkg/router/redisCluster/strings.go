@@ -21,95 +21,84 @@ package redisCluster
 
 import (
 	"github.com/IceFireDB/IceFireDB/IceFireDB-Redis-Proxy/pkg/router"
-	rediscluster "github.com/chasex/redis-go-cluster"
+	"github.com/IceFireDB/IceFireDB/IceFireDB-Redis-Proxy/pkg/slots"
+	"github.com/gomodule/redigo/redis"
 )
 
-func (r *Router) cmdMGET(s *router.Context) error {
-	batch := r.redisCluster.NewBatch()
-	argLen := len(s.Args)
-	for i := 1; i < argLen; i++ {
-		err := batch.Put("GET", s.Args[i])
-		if err != nil {
-			return err
+// slotBatch represents the part of a multi-key command sent to one slot,
+// index holds the position of each key in the original command
+type slotBatch struct {
+	key   []byte
+	args  []interface{}
+	index []int
+}
+
+// A function that splits the keys of a multi-key command by slot, step is
+// the number of arguments that belong to each key (2 for MSET). Splitting by
+// slot rather than by node keeps every batch valid while a slot migrates.
+func splitKeys(args []interface{}, step int) []*slotBatch {
+	batches := make(map[uint16]*slotBatch)
+	var order []*slotBatch
+	for i := 1; i+step <= len(args); i += step {
+		key := keyBytes(args[i])
+		slot := slots.Slot(key)
+		b, ok := batches[slot]
+		if !ok {
+			b = &slotBatch{key: key}
+			batches[slot] = b
+			order = append(order, b)
 		}
+		b.args = append(b.args, args[i:i+step]...)
+		b.index = append(b.index, (i-1)/step)
 	}
-	var err error
-	reply, err := rediscluster.Values(r.redisCluster.RunBatch(batch))
-	if err != nil && err != rediscluster.ErrNil {
-		return router.WriteError(s.Writer, err)
-	}
+	return order
+}
 
-	if len(reply) == 1 {
-		if err, ok := reply[0].(error); ok {
+func (r *Router) cmdMGET(s *router.Context) error {
+	reply := make([]interface{}, len(s.Args)-1)
+	for _, b := range splitKeys(s.Args, 1) {
+		values, err := redis.Values(r.redisCluster.Do(b.key, "MGET", b.args...))
+		if err != nil && err != redis.ErrNil {
 			return router.WriteError(s.Writer, err)
 		}
+		for k, v := range values {
+			reply[b.index[k]] = v
+		}
 	}
 	s.Reply = reply
 	return router.RecursivelyWriteObjects(s.Writer, reply...)
 }
 
-func (r *Router) cmdDEL(s *router.Context) error {
-	argLen := len(s.Args)
-
-	if argLen == 2 {
-		reply, err := rediscluster.Int64(r.redisCluster.Do(s.Cmd, s.Args[1]))
-		if err != nil {
+func (r *Router) cmdMSET(s *router.Context) error {
+	// MSET is only atomic within a slot
+	for _, b := range splitKeys(s.Args, 2) {
+		if _, err := r.redisCluster.Do(b.key, "MSET", b.args...); err != nil {
 			return router.WriteError(s.Writer, err)
 		}
-		return router.WriteInt(s.Writer, reply)
-	}
-
-	batch := r.redisCluster.NewBatch()
-	for i := 1; i < argLen; i++ {
-		err := batch.Put("DEL", s.Args[i])
-		if err != nil {
-			return err
-		}
-	}
-	reply, err := rediscluster.Values(r.redisCluster.RunBatch(batch))
-	if err != nil && err != rediscluster.ErrNil {
-		return router.WriteError(s.Writer, err)
 	}
+	s.Reply = okReply
+	return router.WriteSimpleString(s.Writer, okReply)
+}
 
-	var delCount int64
-	for _, valInterface := range reply {
-		if count, ok := valInterface.(int64); ok {
-			delCount += count
-		}
-	}
-	s.Reply = delCount
-	return router.WriteInt(s.Writer, delCount)
+func (r *Router) cmdDEL(s *router.Context) error {
+	return r.sumKeys(s)
 }
 
 func (r *Router) cmdEXISTS(s *router.Context) error {
-	argLen := len(s.Args)
+	return r.sumKeys(s)
+}
 
-	if argLen == 2 {
-		reply, err := rediscluster.Int64(r.redisCluster.Do(s.Cmd, s.Args[1]))
+// A method that runs a multi-key command returning a count on every
+// slot owning some of the keys and replies the sum of the counts
+func (r *Router) sumKeys(s *router.Context) error {
+	var total int64
+	for _, b := range splitKeys(s.Args, 1) {
+		count, err := redis.Int64(r.redisCluster.Do(b.key, s.Cmd, b.args...))
 		if err != nil {
 			return router.WriteError(s.Writer, err)
 		}
-		return router.WriteInt(s.Writer, reply)
-	}
-
-	batch := r.redisCluster.NewBatch()
-	for i := 1; i < argLen; i++ {
-		err := batch.Put(s.Cmd, s.Args[i])
-		if err != nil {
-			return err
-		}
-	}
-	reply, err := rediscluster.Values(r.redisCluster.RunBatch(batch))
-	if err != nil && err != rediscluster.ErrNil {
-		return router.WriteError(s.Writer, err)
-	}
-
-	var existsCount int64
-	for _, valInterface := range reply {
-		if count, ok := valInterface.(int64); ok {
-			existsCount += count
-		}
+		total += count
 	}
-	s.Reply = existsCount
-	return router.WriteInt(s.Writer, existsCount)
+	s.Reply = total
+	return router.WriteInt(s.Writer, total)
 }
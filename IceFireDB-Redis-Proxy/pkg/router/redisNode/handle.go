@@ -40,7 +40,7 @@ func (r *Router) cmdPING(s *router.Context) error {
 
 func (r *Router) cmdCMDEXEC(s *router.Context) error {
 	var err error
-	s.Reply, err = r.Do(s.Cmd, s.Args[1:]...)
+	s.Reply, err = r.Do(s.TraceContext(), s.Cmd, s.Op.IsReadOnly(), s.Args[1:]...)
 	if err != nil && err != redis.ErrNil {
 		_ = router.WriteError(s.Writer, err)
 		return nil
@@ -75,3 +75,17 @@ func (r *Router) cmdQUIT(s *router.Context) error {
 	s.Reply = okReply
 	return router.WriteSimpleString(s.Writer, okReply)
 }
+
+func (r *Router) cmdSCANKEYS(s *router.Context) error {
+	pattern := interface{}("*")
+	if len(s.Args) > 1 {
+		pattern = s.Args[1]
+	}
+
+	keys, err := r.group.Load().ScanKeys(pattern)
+	if err != nil {
+		return router.WriteError(s.Writer, err)
+	}
+	s.Reply = keys
+	return router.RecursivelyWriteObjects(s.Writer, keys...)
+}
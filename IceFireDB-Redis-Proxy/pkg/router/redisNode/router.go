@@ -25,19 +25,20 @@ import (
 	"io"
 	"strings"
 	"sync"
+	"sync/atomic"
 
 	"github.com/sirupsen/logrus"
 
+	"github.com/IceFireDB/IceFireDB/IceFireDB-Redis-Proxy/pkg/backend"
 	"github.com/IceFireDB/IceFireDB/IceFireDB-Redis-Proxy/pkg/router"
 	"github.com/IceFireDB/components-go/RESPHandle"
-	"github.com/gomodule/redigo/redis"
 )
 
-func NewRouter(cli *redis.Pool) *Router {
+func NewRouter(group *backend.Group) *Router {
 	r := &Router{
-		client: cli,
-		cmd:    make(map[string]router.HandlersChain),
+		cmd: make(map[string]router.HandlersChain),
 	}
+	r.group.Store(group)
 	r.pool.New = func() interface{} {
 		return r.allocateContext()
 	}
@@ -52,9 +53,11 @@ func (r *Router) InitCMD() {
 	r.AddCommand("PING", r.cmdPING)
 	r.AddCommand("QUIT", r.cmdQUIT)
 	r.AddCommand(CMDEXEC, r.cmdCMDEXEC)
+	r.AddCommand(router.CMDScanKeys, r.cmdSCANKEYS)
+	router.AddPassthroughCommands(r, r)
 }
 
-func (r *Router) Handle(w *RESPHandle.WriterHandle, args []interface{}) error {
+func (r *Router) Handle(w *RESPHandle.WriterHandle, client *router.Client, args []interface{}) error {
 	defer func() {
 		if r := recover(); r != nil {
 			logrus.Error("handle panic", r)
@@ -88,6 +91,7 @@ func (r *Router) Handle(w *RESPHandle.WriterHandle, args []interface{}) error {
 	c.Cmd = cmdType
 	c.Op = op.Flag
 	c.Reply = nil
+	c.Client = client
 
 	return c.Next()
 }
@@ -120,9 +124,10 @@ func (r *Router) Sync(args []interface{}) error {
 }
 
 var _ router.IRoutes = (*Router)(nil)
+var _ router.Upstreams = (*Router)(nil)
 
 type Router struct {
-	client      *redis.Pool
+	group       atomic.Pointer[backend.Group]
 	MiddleWares router.HandlersChain
 	cmd         map[string]router.HandlersChain
 	pool        sync.Pool
@@ -131,11 +136,34 @@ type Router struct {
 	cancel context.CancelFunc
 }
 
-func (r *Router) Do(cmd string, args ...interface{}) (reply interface{}, err error) {
-	conn := r.client.Get()
-	defer conn.Close()
+// Do runs the command on the backend, in the trace of ctx
+func (r *Router) Do(ctx context.Context, cmd string, readOnly bool, args ...interface{}) (reply interface{}, err error) {
+	return r.group.Load().DoContext(ctx, cmd, readOnly, args...)
+}
+
+// SetGroup replaces the backend of the router and returns the previous one,
+// commands already running keep using the previous backend
+func (r *Router) SetGroup(group *backend.Group) *backend.Group {
+	return r.group.Swap(group)
+}
+
+// Upstream returns the primary, blocking commands and subscriptions are passed through to it
+func (r *Router) Upstream(_ []byte) string {
+	return r.group.Load().Primary().Addr
+}
+
+// PatternUpstreams returns the primary
+func (r *Router) PatternUpstreams(_ []byte) []string {
+	return []string{r.Upstream(nil)}
+}
+
+// UpstreamOptions returns the connection options of the backend
+func (r *Router) UpstreamOptions() backend.Options {
+	return r.group.Load().Options()
+}
 
-	return conn.Do(cmd, args...)
+func (r *Router) Handler(cmd string) router.HandlerFunc {
+	return r.cmd[cmd].Last()
 }
 
 func (r *Router) Use(funcs ...router.HandlerFunc) router.IRoutes {
@@ -150,7 +178,7 @@ func (r *Router) AddCommand(operation string, handlers ...router.HandlerFunc) ro
 }
 
 func (r *Router) Close() error {
-	return r.client.Close()
+	return r.group.Load().Close()
 }
 
 func (r *Router) addRoute(operation string, handlers router.HandlersChain) {
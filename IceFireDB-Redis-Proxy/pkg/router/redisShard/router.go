@@ -0,0 +1,306 @@
+/*
+ *
+ *  * Licensed to the Apache Software Foundation (ASF) under one or more
+ *  * contributor license agreements.  See the NOTICE file distributed with
+ *  * this work for additional information regarding copyright ownership.
+ *  * The ASF licenses this file to You under the Apache License, Version 2.0
+ *  * (the "License"); you may not use this file except in compliance with
+ *  * the License.  You may obtain a copy of the License at
+ *  *
+ *  *     http://www.apache.org/licenses/LICENSE-2.0
+ *  *
+ *  * Unless required by applicable law or agreed to in writing, software
+ *  * distributed under the License is distributed on an "AS IS" BASIS,
+ *  * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  * See the License for the specific language governing permissions and
+ *  * limitations under the License.
+ *
+ */
+
+package redisShard
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/IceFireDB/IceFireDB/IceFireDB-Redis-Proxy/pkg/backend"
+	"github.com/IceFireDB/IceFireDB/IceFireDB-Redis-Proxy/pkg/hashring"
+	"github.com/IceFireDB/IceFireDB/IceFireDB-Redis-Proxy/pkg/router"
+	"github.com/IceFireDB/components-go/RESPHandle"
+)
+
+// NewRouter creates a router sharding the keys across the given backend
+// groups with a consistent hash ring, groups are keyed by shard name
+func NewRouter(groups map[string]*backend.Group, vnodes int) *Router {
+	r := &Router{
+		cmd: make(map[string]router.HandlersChain),
+	}
+	r.SetGroups(groups, vnodes)
+	r.pool.New = func() interface{} {
+		return r.allocateContext()
+	}
+	return r
+}
+
+const CMDEXEC = "CMDEXEC"
+
+// ErrCrossShard is returned to the commands whose keys are owned by several
+// shards, like the CROSSSLOT error of redis cluster. Keys sharing a hash
+// tag are always owned by one shard.
+var ErrCrossShard = errors.New("CROSSSLOT Keys in request don't hash to the same shard")
+
+// The multi-key commands run on every shard owning one of their keys, and
+// the channels subscribed to on their own shard
+var fannedOut = map[string]bool{
+	"DEL":          true,
+	"EXISTS":       true,
+	"MGET":         true,
+	"MSET":         true,
+	"SUBSCRIBE":    true,
+	"UNSUBSCRIBE":  true,
+	"PSUBSCRIBE":   true,
+	"PUNSUBSCRIBE": true,
+}
+
+func (r *Router) InitCMD() {
+	r.AddCommand("COMMAND", r.cmdCOMMAND)
+	r.AddCommand("PING", r.cmdPING)
+	r.AddCommand("QUIT", r.cmdQUIT)
+	r.AddCommand(CMDEXEC, r.cmdCMDEXEC)
+
+	r.AddCommand("DEL", r.cmdDEL)
+	r.AddCommand("EXISTS", r.cmdEXISTS)
+	r.AddCommand("MGET", r.cmdMGET)
+	r.AddCommand("MSET", r.cmdMSET)
+	r.AddCommand(router.CMDScanKeys, r.cmdSCANKEYS)
+	router.AddPassthroughCommands(r, r)
+}
+
+func (r *Router) Handle(w *RESPHandle.WriterHandle, client *router.Client, args []interface{}) error {
+	defer func() {
+		if r := recover(); r != nil {
+			logrus.Error("handle panic", r)
+		}
+	}()
+	cmdType := strings.ToUpper(string(args[0].([]byte)))
+
+	op, ok := router.OpTable[cmdType]
+
+	if !ok || op.Flag.IsNotAllowed() {
+		return router.WriteError(w, fmt.Errorf(router.ErrUnknownCommand, cmdType))
+	}
+
+	if !op.ArgsVerify(len(args)) {
+		return router.WriteError(w, fmt.Errorf(router.ErrArguments, cmdType))
+	}
+
+	if !r.sameShard(cmdType, args) {
+		return router.WriteError(w, ErrCrossShard)
+	}
+
+	handlers, ok := r.cmd[cmdType]
+	if !ok {
+		handlers = r.cmd[CMDEXEC]
+	}
+	c := r.pool.Get().(*router.Context)
+	defer func() {
+		c.Reset()
+		r.pool.Put(c)
+	}()
+	c.Index = -1
+	c.Writer = w
+	c.Args = args
+	c.Handlers = handlers
+	c.Cmd = cmdType
+	c.Op = op.Flag
+	c.Reply = nil
+	c.Client = client
+
+	return c.Next()
+}
+
+func (r *Router) Sync(args []interface{}) error {
+	cmdType := strings.ToUpper(args[0].(string))
+	op, ok := router.OpTable[cmdType]
+	handlers, ok := r.cmd[cmdType]
+	if !ok {
+		handlers = r.cmd[CMDEXEC]
+	}
+	c := r.pool.Get().(*router.Context)
+	defer func() {
+		c.Reset()
+		r.pool.Put(c)
+	}()
+
+	c.Index = -1
+	c.Writer = RESPHandle.NewWriterHandle(io.Discard)
+	c.Args = args
+	c.Handlers = handlers
+	c.Cmd = cmdType
+	c.Op = op.Flag
+	c.Reply = nil
+	handle := handlers.Last()
+	if handle != nil {
+		return handle(c)
+	}
+	return nil
+}
+
+var _ router.IRoutes = (*Router)(nil)
+var _ router.Upstreams = (*Router)(nil)
+
+// shards represents the hash ring and the backends it points to,
+// replaced as a whole when the topology is reloaded
+type shards struct {
+	ring   *hashring.Ring
+	groups map[string]*backend.Group
+	// the shard of the commands without a key, the first by name
+	first string
+}
+
+type Router struct {
+	shards      atomic.Pointer[shards]
+	MiddleWares router.HandlersChain
+	cmd         map[string]router.HandlersChain
+	pool        sync.Pool
+}
+
+// Do runs the command on the shard owning the key, in the trace of ctx,
+// a nil key selects the first shard by name
+func (r *Router) Do(ctx context.Context, key interface{}, cmd string, readOnly bool, args ...interface{}) (reply interface{}, err error) {
+	return r.shardOf(key).DoContext(ctx, cmd, readOnly, args...)
+}
+
+func (r *Router) shardOf(key interface{}) *backend.Group {
+	s := r.shards.Load()
+	if key == nil {
+		return s.groups[s.first]
+	}
+	return s.groups[s.ring.Get(keyBytes(key))]
+}
+
+// sameShard reports whether the keys of a command are owned by one shard,
+// a command is only sent to the shard of its first key
+func (r *Router) sameShard(cmd string, args []interface{}) bool {
+	if fannedOut[cmd] {
+		return true
+	}
+	keyIndex := router.KeyIndex(cmd, args)
+	if len(keyIndex) < 2 {
+		return true
+	}
+	ring := r.shards.Load().ring
+	shard := ring.Get(keyBytes(args[keyIndex[0]]))
+	for _, i := range keyIndex[1:] {
+		if ring.Get(keyBytes(args[i])) != shard {
+			return false
+		}
+	}
+	return true
+}
+
+// SetGroups replaces the shards of the router and returns the previous
+// backends, commands already running keep using the previous backends
+func (r *Router) SetGroups(groups map[string]*backend.Group, vnodes int) map[string]*backend.Group {
+	s := &shards{
+		ring:   hashring.New(vnodes),
+		groups: groups,
+	}
+	for name := range groups {
+		s.ring.Add(name)
+		if s.first == "" || name < s.first {
+			s.first = name
+		}
+	}
+	if old := r.shards.Swap(s); old != nil {
+		return old.groups
+	}
+	return nil
+}
+
+// Keys are bytes when read from a client and strings when synchronized from a peer
+func keyBytes(key interface{}) []byte {
+	switch k := key.(type) {
+	case []byte:
+		return k
+	case string:
+		return []byte(k)
+	}
+	return []byte(fmt.Sprint(key))
+}
+
+// Upstream returns the primary of the shard owning the key, channels are
+// sharded like keys so a channel is published and subscribed on the same shard
+func (r *Router) Upstream(key []byte) string {
+	return r.shardOf(key).Primary().Addr
+}
+
+// PatternUpstreams returns the primaries of every shard,
+// the channels matching a pattern may live on any of them
+func (r *Router) PatternUpstreams(_ []byte) []string {
+	groups := r.shards.Load().groups
+	addrs := make([]string, 0, len(groups))
+	for _, g := range groups {
+		addrs = append(addrs, g.Primary().Addr)
+	}
+	return addrs
+}
+
+// UpstreamOptions returns the connection options of the shards
+func (r *Router) UpstreamOptions() backend.Options {
+	return r.shardOf(nil).Options()
+}
+
+func (r *Router) Handler(cmd string) router.HandlerFunc {
+	return r.cmd[cmd].Last()
+}
+
+func (r *Router) Use(funcs ...router.HandlerFunc) router.IRoutes {
+	r.MiddleWares = append(r.MiddleWares, funcs...)
+	return r
+}
+
+func (r *Router) AddCommand(operation string, handlers ...router.HandlerFunc) router.IRoutes {
+	handlers = r.combineHandlers(handlers)
+	r.addRoute(operation, handlers)
+	return r
+}
+
+func (r *Router) Close() error {
+	var err error
+	for _, g := range r.shards.Load().groups {
+		if e := g.Close(); e != nil {
+			err = e
+		}
+	}
+	return err
+}
+
+func (r *Router) addRoute(operation string, handlers router.HandlersChain) {
+	if r.cmd == nil {
+		r.cmd = make(map[string]router.HandlersChain)
+	}
+	r.cmd[operation] = handlers
+}
+
+func (r *Router) combineHandlers(handlers router.HandlersChain) router.HandlersChain {
+	finalSize := len(r.MiddleWares) + len(handlers)
+	if finalSize >= int(router.AbortIndex) {
+		panic("too many handlers")
+	}
+	mergedHandlers := make(router.HandlersChain, finalSize)
+	copy(mergedHandlers, r.MiddleWares)
+	copy(mergedHandlers[len(r.MiddleWares):], handlers)
+	return mergedHandlers
+}
+
+func (engine *Router) allocateContext() *router.Context {
+	return &router.Context{}
+}
@@ -0,0 +1,75 @@
+/*
+ *
+ *  * Licensed to the Apache Software Foundation (ASF) under one or more
+ *  * contributor license agreements.  See the NOTICE file distributed with
+ *  * this work for additional information regarding copyright ownership.
+ *  * The ASF licenses this file to You under the Apache License, Version 2.0
+ *  * (the "License"); you may not use this file except in compliance with
+ *  * the License.  You may obtain a copy of the License at
+ *  *
+ *  *     http://www.apache.org/licenses/LICENSE-2.0
+ *  *
+ *  * Unless required by applicable law or agreed to in writing, software
+ *  * distributed under the License is distributed on an "AS IS" BASIS,
+ *  * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  * See the License for the specific language governing permissions and
+ *  * limitations under the License.
+ *
+ */
+
+package redisShard
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/IceFireDB/IceFireDB/IceFireDB-Redis-Proxy/pkg/backend"
+)
+
+func args(s ...string) []interface{} {
+	a := make([]interface{}, len(s))
+	for i, v := range s {
+		a[i] = []byte(v)
+	}
+	return a
+}
+
+func TestDefaultShard(t *testing.T) {
+	for i := 0; i < 10; i++ {
+		r := NewRouter(map[string]*backend.Group{"shard-c": nil, "shard-a": nil, "shard-b": nil}, 0)
+		if first := r.shards.Load().first; first != "shard-a" {
+			t.Fatalf("the shard without a key %q", first)
+		}
+	}
+}
+
+func TestSameShard(t *testing.T) {
+	r := NewRouter(map[string]*backend.Group{"shard-a": nil, "shard-b": nil, "shard-c": nil}, 0)
+	ring := r.shards.Load().ring
+	// two keys of different shards
+	a, b := "k0", ""
+	for i := 1; b == ""; i++ {
+		if k := "k" + strconv.Itoa(i); ring.Get([]byte(k)) != ring.Get([]byte(a)) {
+			b = k
+		}
+	}
+	for _, tc := range []struct {
+		args []string
+		same bool
+	}{
+		{[]string{"GET", a}, true},
+		{[]string{"RENAME", a, b}, false},
+		{[]string{"RENAME", "{u1}:" + a, "{u1}:" + b}, true},
+		{[]string{"SINTER", a, a, b}, false},
+		{[]string{"BLPOP", a, b, "0"}, false},
+		{[]string{"BLPOP", a, "0"}, true},
+		{[]string{"ZUNIONSTORE", a, "2", "{" + a + "}x", b}, false},
+		{[]string{"ZUNIONSTORE", a, "1", "{" + a + "}x", "WEIGHTS", "1"}, true},
+		{[]string{"MGET", a, b}, true},
+		{[]string{"DEL", a, b}, true},
+	} {
+		if same := r.sameShard(tc.args[0], args(tc.args...)); same != tc.same {
+			t.Errorf("%q: %v", tc.args, same)
+		}
+	}
+}
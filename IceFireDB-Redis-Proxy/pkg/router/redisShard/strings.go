@@ -0,0 +1,102 @@
+/*
+ *
+ *  * Licensed to the Apache Software Foundation (ASF) under one or more
+ *  * contributor license agreements.  See the NOTICE file distributed with
+ *  * this work for additional information regarding copyright ownership.
+ *  * The ASF licenses this file to You under the Apache License, Version 2.0
+ *  * (the "License"); you may not use this file except in compliance with
+ *  * the License.  You may obtain a copy of the License at
+ *  *
+ *  *     http://www.apache.org/licenses/LICENSE-2.0
+ *  *
+ *  * Unless required by applicable law or agreed to in writing, software
+ *  * distributed under the License is distributed on an "AS IS" BASIS,
+ *  * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  * See the License for the specific language governing permissions and
+ *  * limitations under the License.
+ *
+ */
+
+package redisShard
+
+import (
+	"github.com/IceFireDB/IceFireDB/IceFireDB-Redis-Proxy/pkg/router"
+	"github.com/gomodule/redigo/redis"
+)
+
+// shardBatch represents the part of a multi-key command sent to one shard,
+// index holds the position of each key in the original command
+type shardBatch struct {
+	key   interface{}
+	args  []interface{}
+	index []int
+}
+
+// A method that splits the keys of a multi-key command by shard, step is
+// the number of arguments that belong to each key (2 for MSET)
+func (r *Router) splitKeys(args []interface{}, step int) []*shardBatch {
+	ring := r.shards.Load().ring
+	batches := make(map[string]*shardBatch)
+	var order []*shardBatch
+	for i := 1; i+step <= len(args); i += step {
+		shard := ring.Get(keyBytes(args[i]))
+		b, ok := batches[shard]
+		if !ok {
+			b = &shardBatch{key: args[i]}
+			batches[shard] = b
+			order = append(order, b)
+		}
+		b.args = append(b.args, args[i:i+step]...)
+		b.index = append(b.index, (i-1)/step)
+	}
+	return order
+}
+
+func (r *Router) cmdMGET(s *router.Context) error {
+	reply := make([]interface{}, len(s.Args)-1)
+	for _, b := range r.splitKeys(s.Args, 1) {
+		values, err := redis.Values(r.Do(s.TraceContext(), b.key, "MGET", s.Op.IsReadOnly(), b.args...))
+		if err != nil && err != redis.ErrNil {
+			return router.WriteError(s.Writer, err)
+		}
+		for k, v := range values {
+			reply[b.index[k]] = v
+		}
+	}
+	s.Reply = reply
+	return router.RecursivelyWriteObjects(s.Writer, reply...)
+}
+
+func (r *Router) cmdMSET(s *router.Context) error {
+	// MSET is only atomic within a shard
+	for _, b := range r.splitKeys(s.Args, 2) {
+		if _, err := r.Do(s.TraceContext(), b.key, "MSET", false, b.args...); err != nil {
+			return router.WriteError(s.Writer, err)
+		}
+	}
+	s.Reply = okReply
+	return router.WriteSimpleString(s.Writer, okReply)
+}
+
+func (r *Router) cmdDEL(s *router.Context) error {
+	return r.sumKeys(s)
+}
+
+func (r *Router) cmdEXISTS(s *router.Context) error {
+	return r.sumKeys(s)
+}
+
+// A method that runs a multi-key command returning a count on every
+// shard owning some of the keys and replies the sum of the counts
+func (r *Router) sumKeys(s *router.Context) error {
+	var total int64
+	for _, b := range r.splitKeys(s.Args, 1) {
+		count, err := redis.Int64(r.Do(s.TraceContext(), b.key, s.Cmd, s.Op.IsReadOnly(), b.args...))
+		if err != nil {
+			return router.WriteError(s.Writer, err)
+		}
+		total += count
+	}
+	s.Reply = total
+	return router.WriteInt(s.Writer, total)
+}
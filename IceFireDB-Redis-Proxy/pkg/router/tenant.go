@@ -0,0 +1,183 @@
+/*
+ *
+ *  * Licensed to the Apache Software Foundation (ASF) under one or more
+ *  * contributor license agreements.  See the NOTICE file distributed with
+ *  * this work for additional information regarding copyright ownership.
+ *  * The ASF licenses this file to You under the Apache License, Version 2.0
+ *  * (the "License"); you may not use this file except in compliance with
+ *  * the License.  You may obtain a copy of the License at
+ *  *
+ *  *     http://www.apache.org/licenses/LICENSE-2.0
+ *  *
+ *  * Unless required by applicable law or agreed to in writing, software
+ *  * distributed under the License is distributed on an "AS IS" BASIS,
+ *  * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  * See the License for the specific language governing permissions and
+ *  * limitations under the License.
+ *
+ */
+
+package router
+
+import (
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Tenants isolates the tenants sharing the proxy. The tenant of a client is
+// selected by the SNI of its TLS connection or else by its AUTH user, the
+// commands of clients without tenant belong to the tenant owning the prefix
+// of their key. Tenants with their own backend have their commands run by
+// their router, and the traffic of every tenant is counted.
+type Tenants struct {
+	users      map[string]string
+	namespaces map[string]HandlerFunc
+	prefixes   []tenantPrefix
+	routes     map[string]IRoutes
+	counters   map[string]*tenantCounters
+}
+
+type tenantPrefix struct {
+	prefix string
+	tenant string
+}
+
+type tenantCounters struct {
+	commands uint64
+	writes   uint64
+	micros   uint64
+}
+
+// TenantStats represents the traffic of a tenant
+type TenantStats struct {
+	Commands uint64 `json:"commands"`
+	Writes   uint64 `json:"writes"`
+	// Time spent running the commands, in microseconds
+	DurationMicros uint64 `json:"duration_us"`
+}
+
+// NewTenants creates an empty set of tenants
+func NewTenants() *Tenants {
+	return &Tenants{
+		users:      make(map[string]string),
+		namespaces: make(map[string]HandlerFunc),
+		routes:     make(map[string]IRoutes),
+		counters:   make(map[string]*tenantCounters),
+	}
+}
+
+// Add adds a tenant with its AUTH users and key prefixes. The keys of its
+// clients are stored under the namespace prefix when it is set, and its
+// commands are run by routes when it is not nil. Tenants are added before
+// the middlewares are used.
+func (t *Tenants) Add(name string, users, prefixes []string, namespace string, routes IRoutes) {
+	for _, u := range users {
+		t.users[u] = name
+	}
+	for _, p := range prefixes {
+		t.prefixes = append(t.prefixes, tenantPrefix{prefix: p, tenant: name})
+	}
+	// the longest prefix matches first
+	sort.SliceStable(t.prefixes, func(i, j int) bool {
+		return len(t.prefixes[i].prefix) > len(t.prefixes[j].prefix)
+	})
+	if namespace != "" {
+		t.namespaces[name] = Namespace([]byte(namespace))
+	}
+	if routes != nil {
+		t.routes[name] = routes
+	}
+	t.counters[name] = &tenantCounters{}
+}
+
+// SelectMiddleware stores the keys of the clients of a tenant under its
+// namespace. It runs before the routing, commands synchronized from peers
+// are left as they are.
+func (t *Tenants) SelectMiddleware() HandlerFunc {
+	return func(context *Context) error {
+		if h, ok := t.namespaces[t.clientTenant(context.Client)]; ok {
+			return h(context)
+		}
+		return context.Next()
+	}
+}
+
+// RouteMiddleware counts the commands of every tenant and runs the commands
+// of the tenants with their own backend on their router, skipping the
+// middlewares that follow it. It runs after the command rules.
+func (t *Tenants) RouteMiddleware() HandlerFunc {
+	return func(context *Context) error {
+		tenant := t.tenantOf(context)
+		c, ok := t.counters[tenant]
+		if !ok {
+			return context.Next()
+		}
+		start := time.Now()
+		defer func() {
+			atomic.AddUint64(&c.commands, 1)
+			if !context.Op.IsReadOnly() {
+				atomic.AddUint64(&c.writes, 1)
+			}
+			atomic.AddUint64(&c.micros, uint64(time.Since(start).Microseconds()))
+		}()
+
+		routes, ok := t.routes[tenant]
+		if !ok {
+			return context.Next()
+		}
+		h := routes.Handler(context.Cmd)
+		if h == nil {
+			h = routes.Handler(cmdExec)
+		}
+		context.Abort()
+		return h(context)
+	}
+}
+
+// Stats returns the traffic counters by tenant
+func (t *Tenants) Stats() map[string]TenantStats {
+	stats := make(map[string]TenantStats, len(t.counters))
+	for name, c := range t.counters {
+		stats[name] = TenantStats{
+			Commands:       atomic.LoadUint64(&c.commands),
+			Writes:         atomic.LoadUint64(&c.writes),
+			DurationMicros: atomic.LoadUint64(&c.micros),
+		}
+	}
+	return stats
+}
+
+// A method that returns the tenant of a client, by SNI or else by AUTH user
+func (t *Tenants) clientTenant(client *Client) string {
+	if client == nil {
+		return ""
+	}
+	if client.Tenant != "" {
+		return client.Tenant
+	}
+	return t.users[client.User]
+}
+
+// A method that returns the tenant of a command, the one of its client
+// or else the one owning the prefix of its first key
+func (t *Tenants) tenantOf(context *Context) string {
+	if context.Client == nil {
+		return ""
+	}
+	if tenant := t.clientTenant(context.Client); tenant != "" {
+		return tenant
+	}
+	keyIndex := KeyIndex(context.Cmd, context.Args)
+	if len(keyIndex) == 0 || len(t.prefixes) == 0 {
+		return ""
+	}
+	key, _ := context.Args[keyIndex[0]].([]byte)
+	for _, p := range t.prefixes {
+		if strings.HasPrefix(string(key), p.prefix) {
+			return p.tenant
+		}
+	}
+	return ""
+}
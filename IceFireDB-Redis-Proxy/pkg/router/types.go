@@ -25,7 +25,9 @@ type IRoutes interface {
 	Use(...HandlerFunc) IRoutes
 	AddCommand(string, ...HandlerFunc) IRoutes
 	InitCMD()
-	Handle(w *RESPHandle.WriterHandle, args []interface{}) error
+	Handle(w *RESPHandle.WriterHandle, client *Client, args []interface{}) error
+	// Handler returns the final handler of a command, nil when it has none
+	Handler(cmd string) HandlerFunc
 	Sync(args []interface{}) error
 	Close() error
 }
@@ -0,0 +1,173 @@
+/*
+ *
+ *  * Licensed to the Apache Software Foundation (ASF) under one or more
+ *  * contributor license agreements.  See the NOTICE file distributed with
+ *  * this work for additional information regarding copyright ownership.
+ *  * The ASF licenses this file to You under the Apache License, Version 2.0
+ *  * (the "License"); you may not use this file except in compliance with
+ *  * the License.  You may obtain a copy of the License at
+ *  *
+ *  *     http://www.apache.org/licenses/LICENSE-2.0
+ *  *
+ *  * Unless required by applicable law or agreed to in writing, software
+ *  * distributed under the License is distributed on an "AS IS" BASIS,
+ *  * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  * See the License for the specific language governing permissions and
+ *  * limitations under the License.
+ *
+ */
+
+package slots
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/IceFireDB/IceFireDB/IceFireDB-Redis-Proxy/pkg/hashring"
+	"github.com/gomodule/redigo/redis"
+)
+
+// Count is the number of hash slots of a Redis Cluster
+const Count = 16384
+
+// Slot returns the hash slot of the key, keys sharing a hash tag map to the same slot
+func Slot(key []byte) uint16 {
+	return crc16(hashring.HashTag(key)) & (Count - 1)
+}
+
+// Range represents a range of slots served by a node, both ends included
+type Range struct {
+	Start uint16
+	End   uint16
+	Addr  string
+}
+
+// ParseRange parses a slot range written as "start-end" or as a single slot
+func ParseRange(spec string, addr string) (Range, error) {
+	start, end, ok := strings.Cut(strings.TrimSpace(spec), "-")
+	if !ok {
+		end = start
+	}
+	first, err := strconv.ParseUint(strings.TrimSpace(start), 10, 16)
+	if err != nil {
+		return Range{}, fmt.Errorf("invalid slot range %q: %w", spec, err)
+	}
+	last, err := strconv.ParseUint(strings.TrimSpace(end), 10, 16)
+	if err != nil {
+		return Range{}, fmt.Errorf("invalid slot range %q: %w", spec, err)
+	}
+	if first > last || last >= Count {
+		return Range{}, fmt.Errorf("invalid slot range %q", spec)
+	}
+	return Range{Start: uint16(first), End: uint16(last), Addr: addr}, nil
+}
+
+// ParseClusterSlots converts a CLUSTER SLOTS reply into slot ranges,
+// only the primary of each range is kept. Like the redis helpers, it takes
+// the result of a Do call and returns its error as is.
+func ParseClusterSlots(reply interface{}, err error) ([]Range, error) {
+	entries, err := redis.Values(reply, err)
+	if err != nil {
+		return nil, err
+	}
+	ranges := make([]Range, 0, len(entries))
+	for _, entry := range entries {
+		fields, err := redis.Values(entry, nil)
+		if err != nil || len(fields) < 3 {
+			return nil, errors.New("invalid CLUSTER SLOTS reply")
+		}
+		start, err1 := redis.Int(fields[0], nil)
+		end, err2 := redis.Int(fields[1], nil)
+		node, err3 := redis.Values(fields[2], nil)
+		if err1 != nil || err2 != nil || err3 != nil || len(node) < 2 {
+			return nil, errors.New("invalid CLUSTER SLOTS reply")
+		}
+		host, err1 := redis.String(node[0], nil)
+		port, err2 := redis.Int(node[1], nil)
+		if err1 != nil || err2 != nil || start < 0 || start > end || end >= Count {
+			return nil, errors.New("invalid CLUSTER SLOTS reply")
+		}
+		ranges = append(ranges, Range{
+			Start: uint16(start),
+			End:   uint16(end),
+			Addr:  host + ":" + strconv.Itoa(port),
+		})
+	}
+	return ranges, nil
+}
+
+// Table is the slot cache of a cluster, it maps every slot to the address of its node
+type Table struct {
+	mu    sync.RWMutex
+	addrs [Count]string
+}
+
+// Load replaces the whole table with the given ranges,
+// slots outside of the ranges are left unassigned
+func (t *Table) Load(ranges []Range) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.addrs = [Count]string{}
+	for _, r := range ranges {
+		for slot := int(r.Start); slot <= int(r.End); slot++ {
+			t.addrs[slot] = r.Addr
+		}
+	}
+}
+
+// Set moves a single slot to the given address
+func (t *Table) Set(slot uint16, addr string) {
+	t.mu.Lock()
+	t.addrs[slot%Count] = addr
+	t.mu.Unlock()
+}
+
+// Get returns the address serving the slot, empty when the slot is unassigned
+func (t *Table) Get(slot uint16) string {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.addrs[slot%Count]
+}
+
+// Nodes returns the distinct addresses found in the table
+func (t *Table) Nodes() []string {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	var nodes []string
+	seen := make(map[string]bool)
+	for _, addr := range t.addrs {
+		if addr != "" && !seen[addr] {
+			seen[addr] = true
+			nodes = append(nodes, addr)
+		}
+	}
+	return nodes
+}
+
+// Redirect represents a MOVED or ASK error reply of a cluster node
+type Redirect struct {
+	// ASK redirects only the next command, MOVED changes the owner of the slot
+	Ask  bool
+	Slot uint16
+	Addr string
+}
+
+// ParseRedirect reports whether the error is a MOVED or ASK redirect and parses it
+func ParseRedirect(err error) (Redirect, bool) {
+	var rerr redis.Error
+	if !errors.As(err, &rerr) {
+		return Redirect{}, false
+	}
+	fields := strings.Fields(string(rerr))
+	if len(fields) != 3 || (fields[0] != "MOVED" && fields[0] != "ASK") {
+		return Redirect{}, false
+	}
+	slot, err := strconv.ParseUint(fields[1], 10, 16)
+	if err != nil || slot >= Count {
+		return Redirect{}, false
+	}
+	return Redirect{Ask: fields[0] == "ASK", Slot: uint16(slot), Addr: fields[2]}, true
+}
@@ -0,0 +1,103 @@
+/*
+ *
+ *  * Licensed to the Apache Software Foundation (ASF) under one or more
+ *  * contributor license agreements.  See the NOTICE file distributed with
+ *  * this work for additional information regarding copyright ownership.
+ *  * The ASF licenses this file to You under the Apache License, Version 2.0
+ *  * (the "License"); you may not use this file except in compliance with
+ *  * the License.  You may obtain a copy of the License at
+ *  *
+ *  *     http://www.apache.org/licenses/LICENSE-2.0
+ *  *
+ *  * Unless required by applicable law or agreed to in writing, software
+ *  * distributed under the License is distributed on an "AS IS" BASIS,
+ *  * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  * See the License for the specific language governing permissions and
+ *  * limitations under the License.
+ *
+ */
+
+package slots
+
+import (
+	"testing"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+func TestSlot(t *testing.T) {
+	list := []struct {
+		key  string
+		slot uint16
+	}{
+		{"123456789", 12739},
+		{"foo", 12182},
+	}
+	for _, v := range list {
+		if slot := Slot([]byte(v.key)); slot != v.slot {
+			t.Errorf("slot error, key: %s, slot: %d, want: %d", v.key, slot, v.slot)
+		}
+	}
+	if Slot([]byte("{user1000}.following")) != Slot([]byte("{user1000}.followers")) {
+		t.Error("keys with the same hash tag must be in the same slot")
+	}
+}
+
+func TestParseRange(t *testing.T) {
+	r, err := ParseRange("0-8191", "a:1")
+	if err != nil || r.Start != 0 || r.End != 8191 || r.Addr != "a:1" {
+		t.Errorf("parse range error: %+v, %v", r, err)
+	}
+	if r, err := ParseRange("100", "a:1"); err != nil || r.Start != 100 || r.End != 100 {
+		t.Errorf("parse single slot error: %+v, %v", r, err)
+	}
+	for _, spec := range []string{"10-5", "0-16384", "a-b", ""} {
+		if _, err := ParseRange(spec, "a:1"); err == nil {
+			t.Errorf("invalid range %q must fail", spec)
+		}
+	}
+}
+
+func TestParseClusterSlots(t *testing.T) {
+	reply := []interface{}{
+		[]interface{}{int64(0), int64(8191), []interface{}{[]byte("10.0.0.1"), int64(7000), []byte("id1")}},
+		[]interface{}{int64(8192), int64(16383), []interface{}{[]byte("10.0.0.2"), int64(7001)},
+			[]interface{}{[]byte("10.0.0.3"), int64(7002)}},
+	}
+	ranges, err := ParseClusterSlots(reply, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var table Table
+	table.Load(ranges)
+	if addr := table.Get(100); addr != "10.0.0.1:7000" {
+		t.Errorf("slot 100 error: %s", addr)
+	}
+	if addr := table.Get(16383); addr != "10.0.0.2:7001" {
+		t.Errorf("slot 16383 error: %s", addr)
+	}
+	table.Set(100, "10.0.0.2:7001")
+	if addr := table.Get(100); addr != "10.0.0.2:7001" {
+		t.Errorf("moved slot error: %s", addr)
+	}
+	if nodes := table.Nodes(); len(nodes) != 2 {
+		t.Errorf("nodes error: %v", nodes)
+	}
+}
+
+func TestParseRedirect(t *testing.T) {
+	r, ok := ParseRedirect(redis.Error("MOVED 3999 127.0.0.1:6381"))
+	if !ok || r.Ask || r.Slot != 3999 || r.Addr != "127.0.0.1:6381" {
+		t.Errorf("parse MOVED error: %+v", r)
+	}
+	r, ok = ParseRedirect(redis.Error("ASK 3999 127.0.0.1:6381"))
+	if !ok || !r.Ask {
+		t.Errorf("parse ASK error: %+v", r)
+	}
+	if _, ok := ParseRedirect(redis.Error("ERR unknown command")); ok {
+		t.Error("plain error must not be a redirect")
+	}
+	if _, ok := ParseRedirect(nil); ok {
+		t.Error("nil error must not be a redirect")
+	}
+}
@@ -0,0 +1,139 @@
+/*
+ *
+ *  * Licensed to the Apache Software Foundation (ASF) under one or more
+ *  * contributor license agreements.  See the NOTICE file distributed with
+ *  * this work for additional information regarding copyright ownership.
+ *  * The ASF licenses this file to You under the Apache License, Version 2.0
+ *  * (the "License"); you may not use this file except in compliance with
+ *  * the License.  You may obtain a copy of the License at
+ *  *
+ *  *     http://www.apache.org/licenses/LICENSE-2.0
+ *  *
+ *  * Unless required by applicable law or agreed to in writing, software
+ *  * distributed under the License is distributed on an "AS IS" BASIS,
+ *  * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  * See the License for the specific language governing permissions and
+ *  * limitations under the License.
+ *
+ */
+
+package tlsconf
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// KeyPair represents the files of a certificate and its private key
+type KeyPair struct {
+	CertFile string
+	KeyFile  string
+}
+
+// Server creates the TLS configuration of a listener. The certificate is
+// selected by the SNI of the client among the key pairs, the first one is
+// the default. When clientCAFile is set the clients must present a
+// certificate signed by that CA.
+func Server(pairs []KeyPair, clientCAFile string) (*tls.Config, error) {
+	if len(pairs) == 0 {
+		return nil, errors.New("tls listener requires a certificate")
+	}
+	conf := &tls.Config{MinVersion: tls.VersionTLS12}
+	for _, p := range pairs {
+		cert, err := tls.LoadX509KeyPair(p.CertFile, p.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		conf.Certificates = append(conf.Certificates, cert)
+	}
+	if clientCAFile != "" {
+		pool, err := loadCertPool(clientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		conf.ClientCAs = pool
+		conf.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return conf, nil
+}
+
+// Client creates the TLS configuration of the connections to a server.
+// The server is verified against the CA, or the system roots when caFile is
+// empty, and the client certificate is presented for mTLS when set. With
+// pins, the server key must also match one of them, and a server without
+// a CA-signed certificate is accepted on its pin alone when caFile is empty.
+func Client(caFile string, pair KeyPair, serverName string, pins []string) (*tls.Config, error) {
+	conf := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ServerName: serverName,
+	}
+	if caFile != "" {
+		pool, err := loadCertPool(caFile)
+		if err != nil {
+			return nil, err
+		}
+		conf.RootCAs = pool
+	}
+	if pair.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(pair.CertFile, pair.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		conf.Certificates = []tls.Certificate{cert}
+	}
+	if len(pins) > 0 {
+		if caFile == "" {
+			// the pins replace the chain verification
+			conf.InsecureSkipVerify = true
+		}
+		conf.VerifyPeerCertificate = verifyPins(pins)
+	}
+	return conf, nil
+}
+
+// Pin returns the pin of a certificate: the base64 SHA-256 of its public key,
+// as used by HPKP and printed by
+// openssl x509 -pubkey -noout | openssl pkey -pubin -outform der | openssl dgst -sha256 -binary | base64
+func Pin(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// A function that returns a certificate check accepting the servers whose
+// leaf certificate matches one of the pins
+func verifyPins(pins []string) func([][]byte, [][]*x509.Certificate) error {
+	allowed := make(map[string]bool, len(pins))
+	for _, pin := range pins {
+		allowed[pin] = true
+	}
+	return func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+		if len(rawCerts) == 0 {
+			return errors.New("tls: no server certificate")
+		}
+		cert, err := x509.ParseCertificate(rawCerts[0])
+		if err != nil {
+			return err
+		}
+		if pin := Pin(cert); !allowed[pin] {
+			return fmt.Errorf("tls: server certificate pin %s is not allowed", pin)
+		}
+		return nil
+	}
+}
+
+func loadCertPool(file string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no certificate found in %s", file)
+	}
+	return pool, nil
+}
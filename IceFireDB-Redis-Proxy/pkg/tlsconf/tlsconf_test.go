@@ -0,0 +1,171 @@
+/*
+ *
+ *  * Licensed to the Apache Software Foundation (ASF) under one or more
+ *  * contributor license agreements.  See the NOTICE file distributed with
+ *  * this work for additional information regarding copyright ownership.
+ *  * The ASF licenses this file to You under the Apache License, Version 2.0
+ *  * (the "License"); you may not use this file except in compliance with
+ *  * the License.  You may obtain a copy of the License at
+ *  *
+ *  *     http://www.apache.org/licenses/LICENSE-2.0
+ *  *
+ *  * Unless required by applicable law or agreed to in writing, software
+ *  * distributed under the License is distributed on an "AS IS" BASIS,
+ *  * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  * See the License for the specific language governing permissions and
+ *  * limitations under the License.
+ *
+ */
+
+package tlsconf
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// A function that writes a self-signed certificate for the name and returns its files and pin
+func writeCert(t *testing.T, name string) (KeyPair, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		DNSNames:              []string{name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	pair := KeyPair{CertFile: filepath.Join(dir, "cert.pem"), KeyFile: filepath.Join(dir, "key.pem")}
+	if err := os.WriteFile(pair.CertFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(pair.KeyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return pair, Pin(cert)
+}
+
+// A function that runs a TLS handshake between the configurations and
+// returns the client error and the server name seen by the server
+func handshake(t *testing.T, server, client *tls.Config) (string, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	done := make(chan string, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			done <- ""
+			return
+		}
+		defer conn.Close()
+		tc := tls.Server(conn, server)
+		if err := tc.Handshake(); err != nil {
+			done <- ""
+			return
+		}
+		// with TLS 1.3 the client certificate is checked after the client handshake
+		if _, err := tc.Read(make([]byte, 1)); err != nil {
+			done <- ""
+			return
+		}
+		_, _ = tc.Write([]byte("y"))
+		done <- tc.ConnectionState().ServerName
+	}()
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	tc := tls.Client(conn, client)
+	// the server refusing the client certificate only shows on the first read
+	if _, err = tc.Write([]byte("x")); err == nil {
+		_, err = tc.Read(make([]byte, 1))
+	}
+	return <-done, err
+}
+
+func TestPinnedClient(t *testing.T) {
+	serverPair, serverPin := writeCert(t, "backend")
+	_, otherPin := writeCert(t, "other")
+
+	server, err := Server([]KeyPair{serverPair}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client, err := Client("", KeyPair{}, "backend", []string{serverPin})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := handshake(t, server, client); err != nil {
+		t.Errorf("pinned server must be accepted: %v", err)
+	}
+
+	client, _ = Client("", KeyPair{}, "backend", []string{otherPin})
+	if _, err := handshake(t, server, client); err == nil {
+		t.Error("server not matching the pins must be refused")
+	}
+
+	client, _ = Client(serverPair.CertFile, KeyPair{}, "backend", nil)
+	if _, err := handshake(t, server, client); err != nil {
+		t.Errorf("server signed by the CA must be accepted: %v", err)
+	}
+}
+
+func TestMutualTLS(t *testing.T) {
+	serverPair, serverPin := writeCert(t, "proxy")
+	tenantPair, _ := writeCert(t, "tenant.example.com")
+	clientPair, _ := writeCert(t, "client")
+
+	server, err := Server([]KeyPair{serverPair, tenantPair}, clientPair.CertFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client, _ := Client("", KeyPair{}, "proxy", []string{serverPin})
+	if _, err := handshake(t, server, client); err == nil {
+		t.Error("client without certificate must be refused")
+	}
+
+	client, _ = Client(tenantPair.CertFile, clientPair, "tenant.example.com", nil)
+	name, err := handshake(t, server, client)
+	if err != nil {
+		t.Fatalf("client certificate must be accepted: %v", err)
+	}
+	if name != "tenant.example.com" {
+		t.Errorf("server name error: %s", name)
+	}
+}
@@ -0,0 +1,133 @@
+/*
+ *
+ *  * Licensed to the Apache Software Foundation (ASF) under one or more
+ *  * contributor license agreements.  See the NOTICE file distributed with
+ *  * this work for additional information regarding copyright ownership.
+ *  * The ASF licenses this file to You under the Apache License, Version 2.0
+ *  * (the "License"); you may not use this file except in compliance with
+ *  * the License.  You may obtain a copy of the License at
+ *  *
+ *  *     http://www.apache.org/licenses/LICENSE-2.0
+ *  *
+ *  * Unless required by applicable law or agreed to in writing, software
+ *  * distributed under the License is distributed on an "AS IS" BASIS,
+ *  * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  * See the License for the specific language governing permissions and
+ *  * limitations under the License.
+ *
+ */
+
+// Package tracing traces the commands through the proxy and its backends
+// with OpenTelemetry. An IceFireDB backend continues the trace of a command
+// prefixed with TRACER or TRACEW and the W3C traceparent of its span.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/exporters/zipkin"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	serviceName = "IceFireDB-Redis-Proxy"
+	tracerName  = "github.com/IceFireDB/IceFireDB/IceFireDB-Redis-Proxy"
+)
+
+var enabled atomic.Bool
+
+// Enabled reports whether the commands are traced
+func Enabled() bool {
+	return enabled.Load()
+}
+
+// Init exports the spans with the exporter, otlp-grpc, otlp-http, zipkin or
+// stdout, to the endpoint, and samples ratio of the traces started by the
+// proxy. The returned function flushes the spans left and stops the export.
+func Init(exporter, endpoint string, ratio float64) (func(context.Context) error, error) {
+	ctx := context.Background()
+	var (
+		exp sdktrace.SpanExporter
+		err error
+	)
+	switch exporter {
+	case "otlp-grpc":
+		opts := []otlptracegrpc.Option{otlptracegrpc.WithInsecure()}
+		if endpoint != "" {
+			opts = append(opts, otlptracegrpc.WithEndpoint(endpoint))
+		}
+		exp, err = otlptracegrpc.New(ctx, opts...)
+	case "otlp-http":
+		opts := []otlptracehttp.Option{otlptracehttp.WithInsecure()}
+		if endpoint != "" {
+			opts = append(opts, otlptracehttp.WithEndpoint(endpoint))
+		}
+		exp, err = otlptracehttp.New(ctx, opts...)
+	case "zipkin":
+		exp, err = zipkin.New(endpoint)
+	case "stdout":
+		exp, err = stdouttrace.New()
+	default:
+		return nil, fmt.Errorf("unknown trace exporter: %s", exporter)
+	}
+	if err != nil {
+		return nil, err
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exp),
+		sdktrace.WithResource(resource.NewSchemaless(semconv.ServiceName(serviceName))),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(ratio))),
+	)
+	SetProvider(provider)
+	return provider.Shutdown, nil
+}
+
+// SetProvider traces the commands with the spans of provider
+func SetProvider(provider trace.TracerProvider) {
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	enabled.Store(true)
+}
+
+// Tracer returns the tracer of the proxy, its spans are dropped until Init
+func Tracer() trace.Tracer {
+	return otel.Tracer(tracerName)
+}
+
+// Traceparent returns the W3C traceparent of the span of ctx, empty when
+// ctx has no span or the span is not sampled
+func Traceparent(ctx context.Context) string {
+	if !trace.SpanContextFromContext(ctx).IsSampled() {
+		return ""
+	}
+	carrier := propagation.MapCarrier{}
+	propagation.TraceContext{}.Inject(ctx, carrier)
+	return carrier.Get("traceparent")
+}
+
+// Wrap prefixes the command with the trace context of ctx, so an IceFireDB
+// backend runs it in the trace. The reads are sent as TRACER, the writes as
+// TRACEW. The command is left as it is when ctx is not traced.
+func Wrap(ctx context.Context, cmd string, readOnly bool, args []interface{}) (string, []interface{}) {
+	parent := Traceparent(ctx)
+	if parent == "" {
+		return cmd, args
+	}
+	wrapped := make([]interface{}, 0, len(args)+2)
+	wrapped = append(wrapped, parent, cmd)
+	wrapped = append(wrapped, args...)
+	if readOnly {
+		return "TRACER", wrapped
+	}
+	return "TRACEW", wrapped
+}
@@ -0,0 +1,75 @@
+/*
+ *
+ *  * Licensed to the Apache Software Foundation (ASF) under one or more
+ *  * contributor license agreements.  See the NOTICE file distributed with
+ *  * this work for additional information regarding copyright ownership.
+ *  * The ASF licenses this file to You under the Apache License, Version 2.0
+ *  * (the "License"); you may not use this file except in compliance with
+ *  * the License.  You may obtain a copy of the License at
+ *  *
+ *  *     http://www.apache.org/licenses/LICENSE-2.0
+ *  *
+ *  * Unless required by applicable law or agreed to in writing, software
+ *  * distributed under the License is distributed on an "AS IS" BASIS,
+ *  * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  * See the License for the specific language governing permissions and
+ *  * limitations under the License.
+ *
+ */
+
+package tracing
+
+import (
+	"context"
+	"testing"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+func tracedContext(t *testing.T, sampled bool) context.Context {
+	traceID, err := trace.TraceIDFromHex("4bf92f3577b34da6a3ce929d0e0e4736")
+	if err != nil {
+		t.Fatal(err)
+	}
+	spanID, err := trace.SpanIDFromHex("00f067aa0ba902b7")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := trace.SpanContextConfig{TraceID: traceID, SpanID: spanID}
+	if sampled {
+		cfg.TraceFlags = trace.FlagsSampled
+	}
+	return trace.ContextWithSpanContext(context.Background(), trace.NewSpanContext(cfg))
+}
+
+func TestTraceparent(t *testing.T) {
+	if got := Traceparent(tracedContext(t, true)); got != "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01" {
+		t.Errorf("traceparent %q", got)
+	}
+	if got := Traceparent(tracedContext(t, false)); got != "" {
+		t.Errorf("an unsampled span must not be propagated, got %q", got)
+	}
+	if got := Traceparent(context.Background()); got != "" {
+		t.Errorf("no span must give no traceparent, got %q", got)
+	}
+}
+
+func TestWrap(t *testing.T) {
+	args := []interface{}{[]byte("k"), []byte("v")}
+	cmd, wrapped := Wrap(tracedContext(t, true), "SET", false, args)
+	if cmd != "TRACEW" || len(wrapped) != 4 || wrapped[1] != "SET" || string(wrapped[3].([]byte)) != "v" {
+		t.Errorf("write wrapped as %s %v", cmd, wrapped)
+	}
+	if cmd, _ = Wrap(tracedContext(t, true), "GET", true, args[:1]); cmd != "TRACER" {
+		t.Errorf("read wrapped as %s", cmd)
+	}
+	if cmd, wrapped = Wrap(context.Background(), "SET", false, args); cmd != "SET" || len(wrapped) != 2 {
+		t.Errorf("an untraced command must be left as it is, got %s %v", cmd, wrapped)
+	}
+}
+
+func TestInit(t *testing.T) {
+	if _, err := Init("jaeger", "", 1); err == nil {
+		t.Error("want an error on an unknown exporter")
+	}
+}
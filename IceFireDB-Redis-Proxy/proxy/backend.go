@@ -0,0 +1,153 @@
+/*
+ *
+ *  * Licensed to the Apache Software Foundation (ASF) under one or more
+ *  * contributor license agreements.  See the NOTICE file distributed with
+ *  * this work for additional information regarding copyright ownership.
+ *  * The ASF licenses this file to You under the Apache License, Version 2.0
+ *  * (the "License"); you may not use this file except in compliance with
+ *  * the License.  You may obtain a copy of the License at
+ *  *
+ *  *     http://www.apache.org/licenses/LICENSE-2.0
+ *  *
+ *  * Unless required by applicable law or agreed to in writing, software
+ *  * distributed under the License is distributed on an "AS IS" BASIS,
+ *  * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  * See the License for the specific language governing permissions and
+ *  * limitations under the License.
+ *
+ */
+
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"strings"
+	"time"
+
+	"github.com/IceFireDB/IceFireDB/IceFireDB-Redis-Proxy/pkg/backend"
+	"github.com/IceFireDB/IceFireDB/IceFireDB-Redis-Proxy/pkg/config"
+	"github.com/IceFireDB/IceFireDB/IceFireDB-Redis-Proxy/pkg/slots"
+	"github.com/IceFireDB/IceFireDB/IceFireDB-Redis-Proxy/pkg/tlsconf"
+	"github.com/sirupsen/logrus"
+)
+
+// Name of the single backend group of the node type
+const nodeGroup = ""
+
+// A function that creates the backend groups of the node and shard types,
+// keyed by shard name
+func newGroups(conf *config.RedisDBS) (map[string]*backend.Group, error) {
+	opt, err := backendOptions(conf)
+	if err != nil {
+		return nil, err
+	}
+	split := splitRules(conf)
+	if conf.Type == config.TypeNode {
+		return map[string]*backend.Group{
+			nodeGroup: backend.NewGroup(conf.StartNodes, splitAddrs(conf.Replicas), opt, split),
+		}, nil
+	}
+
+	groups := make(map[string]*backend.Group, len(conf.Shards))
+	for _, shard := range conf.Shards {
+		groups[shard.Name] = backend.NewGroup(shard.Addr, shard.Replicas, opt, split)
+	}
+	return groups, nil
+}
+
+// A function that creates the backend of the cluster type, its slot cache
+// starts from the configured slot map
+func newCluster(conf *config.RedisDBS) (*backend.Cluster, error) {
+	static := make([]slots.Range, 0, len(conf.SlotMap))
+	for _, m := range conf.SlotMap {
+		r, err := slots.ParseRange(m.Slots, m.Addr)
+		if err != nil {
+			return nil, err
+		}
+		static = append(static, r)
+	}
+	opt, err := backendOptions(conf)
+	if err != nil {
+		return nil, err
+	}
+	return backend.NewCluster(splitAddrs(conf.StartNodes), static, opt)
+}
+
+func backendOptions(conf *config.RedisDBS) (backend.Options, error) {
+	opt := backend.Options{
+		ConnTimeout:  time.Duration(conf.ConnTimeOut) * time.Second,
+		ReadTimeout:  time.Duration(conf.ConnReadTimeOut) * time.Second,
+		WriteTimeout: time.Duration(conf.ConnWriteTimeOut) * time.Second,
+		IdleTimeout:  time.Duration(conf.ConnAliveTimeOut) * time.Second,
+		PoolSize:     conf.ConnPoolSize,
+	}
+	if recorder != nil {
+		opt.Observe = observeUpstream
+	}
+	opt.Propagate = config.Get().Tracing.Enable && config.Get().Tracing.Propagate
+	var err error
+	opt.TLS, err = backendTLS(conf.TLS)
+	return opt, err
+}
+
+// A function that creates the TLS configuration of backend connections, nil for plain TCP
+func backendTLS(t config.BackendTLSS) (*tls.Config, error) {
+	if !t.Enable {
+		return nil, nil
+	}
+	return tlsconf.Client(t.CAFile, tlsconf.KeyPair{CertFile: t.CertFile, KeyFile: t.KeyFile}, t.ServerName, t.Pins)
+}
+
+func splitRules(conf *config.RedisDBS) *backend.SplitRules {
+	rw := conf.ReadWriteSplit
+	return backend.NewSplitRules(rw.Enable, rw.PrimaryCMDs, rw.ReplicaCMDs)
+}
+
+func healthOptions(conf *config.RedisDBS) backend.HealthOptions {
+	hc := conf.HealthCheck
+	return backend.HealthOptions{
+		Interval: time.Duration(hc.Interval) * time.Millisecond,
+		Timeout:  time.Duration(hc.Timeout) * time.Millisecond,
+		Failures: hc.Failures,
+		Promote:  hc.Promote,
+		OnDown: func(addr string) {
+			logrus.Warnf("backend %s is down, ejected", addr)
+		},
+		OnUp: func(addr string) {
+			logrus.Infof("backend %s is back up", addr)
+		},
+		OnFailover: func(oldPrimary, newPrimary string) {
+			logrus.Warnf("backend failover, primary %s replaced by %s", oldPrimary, newPrimary)
+		},
+	}
+}
+
+func splitAddrs(addrs string) []string {
+	if addrs == "" {
+		return nil
+	}
+	return strings.Split(addrs, ",")
+}
+
+// A method that starts the health checks of the current backend groups,
+// stopping the previous ones. Must be called with p.mu held.
+func (p *Proxy) startHealthChecks() {
+	if p.healthCancel != nil {
+		p.healthCancel()
+		p.healthCancel = nil
+	}
+	conf := &config.Get().RedisDB
+	if p.ctx == nil || !conf.HealthCheck.Enable {
+		return
+	}
+
+	var ctx context.Context
+	ctx, p.healthCancel = context.WithCancel(p.ctx)
+	for _, g := range p.groups {
+		g.StartHealthCheck(ctx, healthOptions(conf))
+	}
+	for _, g := range p.tenantGroups {
+		g.StartHealthCheck(ctx, healthOptions(conf))
+	}
+}
@@ -0,0 +1,102 @@
+/*
+ *
+ *  * Licensed to the Apache Software Foundation (ASF) under one or more
+ *  * contributor license agreements.  See the NOTICE file distributed with
+ *  * this work for additional information regarding copyright ownership.
+ *  * The ASF licenses this file to You under the Apache License, Version 2.0
+ *  * (the "License"); you may not use this file except in compliance with
+ *  * the License.  You may obtain a copy of the License at
+ *  *
+ *  *     http://www.apache.org/licenses/LICENSE-2.0
+ *  *
+ *  * Unless required by applicable law or agreed to in writing, software
+ *  * distributed under the License is distributed on an "AS IS" BASIS,
+ *  * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  * See the License for the specific language governing permissions and
+ *  * limitations under the License.
+ *
+ */
+
+package proxy
+
+import (
+	"context"
+	"time"
+
+	"github.com/IceFireDB/IceFireDB/IceFireDB-Redis-Proxy/pkg/backend"
+	"github.com/IceFireDB/IceFireDB/IceFireDB-Redis-Proxy/pkg/cache"
+	"github.com/IceFireDB/IceFireDB/IceFireDB-Redis-Proxy/pkg/config"
+	"github.com/gomodule/redigo/redis"
+	"github.com/sirupsen/logrus"
+)
+
+// A function that creates the in-proxy cache, nil when it is disabled
+func newCache(conf *config.CacheS) *cache.Cache {
+	if !conf.Enable {
+		return nil
+	}
+	return cache.New(conf.MaxItemsSize, time.Duration(conf.DefaultExpiration)*time.Millisecond)
+}
+
+// A method that subscribes the cache to the invalidations of the current
+// backends, stopping the previous subscriptions. Must be called with p.mu held.
+func (p *Proxy) startInvalidation() {
+	if p.cacheCancel != nil {
+		p.cacheCancel()
+		p.cacheCancel = nil
+	}
+	if p.ctx == nil || p.Cache == nil {
+		return
+	}
+
+	var addrs []string
+	if p.proxyCluster != nil {
+		addrs = p.proxyCluster.Nodes()
+	}
+	for _, g := range p.groups {
+		addrs = append(addrs, g.Primary().Addr)
+	}
+
+	conf := config.Get()
+	opt, err := backendOptions(&conf.RedisDB)
+	if err != nil {
+		logrus.Errorf("cache invalidation disabled, cache flushed: %v", err)
+		p.Cache.Flush()
+		return
+	}
+	var ctx context.Context
+	ctx, p.cacheCancel = context.WithCancel(p.ctx)
+	for _, addr := range addrs {
+		addr := addr
+		go p.Cache.Listen(ctx, cache.ListenOptions{
+			Dial: func() (redis.Conn, error) {
+				return backend.Dial(addr, opt, redis.DialConnectTimeout(opt.ConnTimeout))
+			},
+			Mode:     conf.Cache.Invalidation,
+			Prefixes: cache.Prefixes(conf.Cache.Patterns),
+			OnError: func(err error) {
+				logrus.Warnf("cache invalidation of backend %s lost, cache flushed: %v", addr, err)
+			},
+		})
+	}
+}
+
+// A method that drops the expired cache keys every cleanup interval until the context is done
+func (p *Proxy) purgeCache(ctx context.Context) {
+	interval := time.Duration(config.Get().Cache.CleanupInterval) * time.Second
+	if p.Cache == nil || interval <= 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				p.Cache.Purge()
+			}
+		}
+	}()
+}
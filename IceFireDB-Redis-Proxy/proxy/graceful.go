@@ -0,0 +1,67 @@
+/*
+ *
+ *  * Licensed to the Apache Software Foundation (ASF) under one or more
+ *  * contributor license agreements.  See the NOTICE file distributed with
+ *  * this work for additional information regarding copyright ownership.
+ *  * The ASF licenses this file to You under the Apache License, Version 2.0
+ *  * (the "License"); you may not use this file except in compliance with
+ *  * the License.  You may obtain a copy of the License at
+ *  *
+ *  *     http://www.apache.org/licenses/LICENSE-2.0
+ *  *
+ *  * Unless required by applicable law or agreed to in writing, software
+ *  * distributed under the License is distributed on an "AS IS" BASIS,
+ *  * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  * See the License for the specific language governing permissions and
+ *  * limitations under the License.
+ *
+ */
+
+package proxy
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/IceFireDB/IceFireDB/IceFireDB-Redis-Proxy/pkg/config"
+	"github.com/IceFireDB/IceFireDB/IceFireDB-Redis-Proxy/pkg/graceful"
+)
+
+// Time the new process of an upgrade has to join the p2p network and serve the socket
+const upgradeTimeout = time.Minute
+
+// Drain stops accepting clients and closes their connections once their
+// command is answered, waiting for them up to the drain timeout. The
+// connections left are closed when the proxy stops.
+func (p *Proxy) Drain() {
+	if p.listener != nil {
+		if err := p.listener.Stop(); err != nil {
+			logrus.Errorf("stop listener fail: %v", err)
+		}
+	}
+	timeout := time.Duration(config.Get().Proxy.DrainTimeout) * time.Millisecond
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	logrus.Infof("draining %d client connections", p.drainer.Len())
+	if left := p.drainer.Drain(ctx); left > 0 {
+		logrus.Warnf("drain timeout, %d client connections are closed", left)
+	}
+}
+
+// Upgrade starts the proxy again in a new process serving the same socket,
+// e.g. after its binary was replaced, and drains this process once the new
+// one is ready. The caller then stops this process.
+func (p *Proxy) Upgrade() error {
+	if p.listener == nil {
+		return errors.New("proxy is not listening")
+	}
+	if err := graceful.Upgrade(p.listener, upgradeTimeout); err != nil {
+		return err
+	}
+	p.Drain()
+	return nil
+}
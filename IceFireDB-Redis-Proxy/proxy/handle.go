@@ -22,6 +22,7 @@ package proxy
 import (
 	"errors"
 	"fmt"
+	"time"
 
 	"github.com/IceFireDB/IceFireDB/IceFireDB-Redis-Proxy/pkg/router"
 	"github.com/IceFireDB/components-go/RESPHandle"
@@ -35,8 +36,28 @@ func (p *Proxy) handle(conn bareneter.Conn) {
 		_ = conn.Close()
 	}()
 	localConn := conn.NetConn()
+	if !p.drainer.Track(localConn) {
+		return
+	}
+	defer p.drainer.Done(localConn)
+	client := router.NewClient(conn.RemoteAddr())
+	if p.tlsConfig != nil {
+		tlsConn, tenant, err := p.handshake(localConn)
+		if err != nil {
+			logrus.Debugf("tls handshake with %s fail: %v", client.Addr, err)
+			return
+		}
+		localConn, client.Tenant = tlsConn, tenant
+	}
 	localWriteHandle := RESPHandle.NewWriterHandle(localConn)
 	decoder := credis.NewDecoderSize(localConn, 1024)
+	dedicateConns(client, localWriteHandle, localConn)
+	defer client.Close()
+	var limit connLimit
+	defer p.releaseConn(&limit)
+	if !p.limitConn(localWriteHandle, client, &limit) {
+		return
+	}
 	for {
 		resp, err := decoder.Decode()
 		if err != nil {
@@ -67,7 +88,11 @@ func (p *Proxy) handle(conn bareneter.Conn) {
 		for i := 0; i < respCount; i++ {
 			commandArgs[i] = resp.Array[i].Value
 		}
-		err = p.router.Handle(localWriteHandle, commandArgs)
+		start := time.Now()
+		span := startCommand(client, commandArgs)
+		err = p.router.Handle(localWriteHandle, client, commandArgs)
+		endCommand(span, client, err)
+		observeCommand(start, client, commandArgs)
 
 		if err != nil {
 			if errors.Is(err, router.ErrLocalWriter) || errors.Is(err, router.ErrLocalFlush) {
@@ -77,5 +102,15 @@ func (p *Proxy) handle(conn bareneter.Conn) {
 			logrus.Errorf("resp command exec fail:%s , %v", commandArgs, err)
 			return
 		}
+
+		// an AUTH moves the connection to the quota of its user
+		if !p.limitConn(localWriteHandle, client, &limit) {
+			return
+		}
+
+		// the client reconnects to another process once its command is answered
+		if p.drainer.Draining() {
+			return
+		}
 	}
 }
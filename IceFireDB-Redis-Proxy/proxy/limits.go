@@ -0,0 +1,111 @@
+/*
+ *
+ *  * Licensed to the Apache Software Foundation (ASF) under one or more
+ *  * contributor license agreements.  See the NOTICE file distributed with
+ *  * this work for additional information regarding copyright ownership.
+ *  * The ASF licenses this file to You under the Apache License, Version 2.0
+ *  * (the "License"); you may not use this file except in compliance with
+ *  * the License.  You may obtain a copy of the License at
+ *  *
+ *  *     http://www.apache.org/licenses/LICENSE-2.0
+ *  *
+ *  * Unless required by applicable law or agreed to in writing, software
+ *  * distributed under the License is distributed on an "AS IS" BASIS,
+ *  * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  * See the License for the specific language governing permissions and
+ *  * limitations under the License.
+ *
+ */
+
+package proxy
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/IceFireDB/IceFireDB/IceFireDB-Redis-Proxy/pkg/config"
+	"github.com/IceFireDB/IceFireDB/IceFireDB-Redis-Proxy/pkg/ratelimit"
+	"github.com/IceFireDB/IceFireDB/IceFireDB-Redis-Proxy/pkg/router"
+	"github.com/IceFireDB/components-go/RESPHandle"
+)
+
+// How often the idle rate limit buckets are dropped
+const limiterPurgeInterval = time.Minute
+
+// A method that adds the middlewares authenticating and rate limiting the clients
+func (p *Proxy) useClientMiddlewares(conf *config.Config) {
+	if len(conf.Users) > 0 {
+		p.users = router.NewUsers(userPasswords(conf.Users))
+		p.router.Use(router.AuthMiddleware(p.users))
+	}
+
+	if conf.Limits.Rate > 0 {
+		p.limiter = ratelimit.NewLimiter(conf.Limits.Rate, conf.Limits.Burst)
+		p.router.Use(router.RateLimitMiddleware(p.limiter, conf.Limits.Key == config.LimitKeyUser))
+	}
+	if conf.Limits.MaxConns > 0 {
+		p.connQuota = ratelimit.NewQuota(conf.Limits.MaxConns)
+	}
+}
+
+// A function that returns the passwords of the users keyed by name
+func userPasswords(users []config.UserS) map[string]string {
+	passwords := make(map[string]string, len(users))
+	for _, u := range users {
+		passwords[u.Name] = u.Password
+	}
+	return passwords
+}
+
+// connLimit represents the quota key a client connection is counted by
+type connLimit struct {
+	key string
+}
+
+// A method that counts the connection of the client against its quota, by
+// IP and then by user once authenticated with the user key. It replies the
+// client and reports false when the quota is exceeded.
+func (p *Proxy) limitConn(w *RESPHandle.WriterHandle, client *router.Client, l *connLimit) bool {
+	if p.connQuota == nil {
+		return true
+	}
+	key := client.Key(config.Get().Limits.Key == config.LimitKeyUser)
+	if key == l.key {
+		return true
+	}
+	if !p.connQuota.Acquire(key) {
+		_ = router.WriteError(w, errors.New(router.ErrConnLimited))
+		return false
+	}
+	p.releaseConn(l)
+	l.key = key
+	return true
+}
+
+// A method that uncounts a connection from its quota
+func (p *Proxy) releaseConn(l *connLimit) {
+	if p.connQuota != nil && l.key != "" {
+		p.connQuota.Release(l.key)
+		l.key = ""
+	}
+}
+
+// A method that drops the idle rate limit buckets until the context is done
+func (p *Proxy) purgeLimiter(ctx context.Context) {
+	if p.limiter == nil {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(limiterPurgeInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				p.limiter.Purge()
+			}
+		}
+	}()
+}
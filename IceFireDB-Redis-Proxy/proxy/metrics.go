@@ -0,0 +1,133 @@
+/*
+ *
+ *  * Licensed to the Apache Software Foundation (ASF) under one or more
+ *  * contributor license agreements.  See the NOTICE file distributed with
+ *  * this work for additional information regarding copyright ownership.
+ *  * The ASF licenses this file to You under the Apache License, Version 2.0
+ *  * (the "License"); you may not use this file except in compliance with
+ *  * the License.  You may obtain a copy of the License at
+ *  *
+ *  *     http://www.apache.org/licenses/LICENSE-2.0
+ *  *
+ *  * Unless required by applicable law or agreed to in writing, software
+ *  * distributed under the License is distributed on an "AS IS" BASIS,
+ *  * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  * See the License for the specific language governing permissions and
+ *  * limitations under the License.
+ *
+ */
+
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/expfmt"
+	"github.com/sirupsen/logrus"
+
+	"github.com/IceFireDB/IceFireDB/IceFireDB-Redis-Proxy/pkg/config"
+	"github.com/IceFireDB/IceFireDB/IceFireDB-Redis-Proxy/pkg/metrics"
+	"github.com/IceFireDB/IceFireDB/IceFireDB-Redis-Proxy/pkg/router"
+)
+
+// The latency of the commands and of the backends, nil when the metrics are
+// disabled. It is global so the backends created by a reload report to it.
+var recorder *metrics.Recorder
+
+// A function that creates the latency recorder, nil when the metrics are disabled
+func newRecorder(conf *config.MetricsS) *metrics.Recorder {
+	if !conf.Enable {
+		return nil
+	}
+	slowLog := metrics.NewSlowLog(conf.SlowLogMaxLen, time.Duration(conf.SlowLogSlowerThan)*time.Microsecond)
+	return metrics.NewRecorder(slowLog)
+}
+
+// A function that reports the time a backend took to the recorder, it is
+// the Observe hook of the backend options
+func observeUpstream(addr, cmd string, d time.Duration) {
+	recorder.ObserveUpstream(addr, cmd, d)
+}
+
+// A function that records the time the proxy took to answer a command. The
+// blocking commands are left out, their time is the time they waited for.
+func observeCommand(start time.Time, client *router.Client, args []interface{}) {
+	if recorder == nil {
+		return
+	}
+	name, _ := args[0].([]byte)
+	cmd := strings.ToUpper(string(name))
+	// unknown commands would grow the labels without bound
+	if _, ok := router.OpTable[cmd]; !ok || router.IsBlocking(cmd, args) {
+		return
+	}
+	recorder.ObserveCommand(cmd, time.Since(start), client.Addr, args)
+}
+
+func (p *Proxy) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	if recorder == nil {
+		http.Error(w, "metrics disabled", http.StatusNotFound)
+		return
+	}
+	registry := prometheus.NewRegistry()
+	if err := registry.Register(recorder); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	families, err := registry.Gather()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	format := expfmt.NewFormat(expfmt.TypeTextPlain)
+	w.Header().Set("Content-Type", string(format))
+	enc := expfmt.NewEncoder(w, format)
+	for _, f := range families {
+		if err = enc.Encode(f); err != nil {
+			logrus.Errorf("metrics encoding fail: %v", err)
+			return
+		}
+	}
+}
+
+func (p *Proxy) handleLatency(w http.ResponseWriter, r *http.Request) {
+	if recorder == nil {
+		http.Error(w, "metrics disabled", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(recorder.Latencies())
+}
+
+// A method that serves the slow log, GET returns the last commands, the
+// count parameter limits them, and DELETE empties it
+func (p *Proxy) handleSlowLog(w http.ResponseWriter, r *http.Request) {
+	if recorder == nil {
+		http.Error(w, "metrics disabled", http.StatusNotFound)
+		return
+	}
+	slowLog := recorder.SlowLog()
+	switch r.Method {
+	case http.MethodGet:
+		count := -1
+		if v := r.URL.Query().Get("count"); v != "" {
+			var err error
+			if count, err = strconv.Atoi(v); err != nil {
+				http.Error(w, "invalid count", http.StatusBadRequest)
+				return
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(slowLog.Get(count))
+	case http.MethodDelete:
+		slowLog.Reset()
+		_, _ = w.Write([]byte("OK\n"))
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,53 @@
+/*
+ *
+ *  * Licensed to the Apache Software Foundation (ASF) under one or more
+ *  * contributor license agreements.  See the NOTICE file distributed with
+ *  * this work for additional information regarding copyright ownership.
+ *  * The ASF licenses this file to You under the Apache License, Version 2.0
+ *  * (the "License"); you may not use this file except in compliance with
+ *  * the License.  You may obtain a copy of the License at
+ *  *
+ *  *     http://www.apache.org/licenses/LICENSE-2.0
+ *  *
+ *  * Unless required by applicable law or agreed to in writing, software
+ *  * distributed under the License is distributed on an "AS IS" BASIS,
+ *  * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  * See the License for the specific language governing permissions and
+ *  * limitations under the License.
+ *
+ */
+
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/IceFireDB/IceFireDB/IceFireDB-Redis-Proxy/pkg/backend"
+	"github.com/IceFireDB/IceFireDB/IceFireDB-Redis-Proxy/pkg/config"
+)
+
+// A function that creates the mirror to the shadow backend, nil when mirroring is disabled
+func newMirror(conf *config.Config) (*backend.Mirror, error) {
+	m := conf.Mirror
+	if !m.Enable {
+		return nil, nil
+	}
+	opt, err := backendOptions(&conf.RedisDB)
+	if err != nil {
+		return nil, err
+	}
+	if opt.TLS, err = backendTLS(m.TLS); err != nil {
+		return nil, err
+	}
+	return backend.NewMirror(m.Addr, opt, m.Percent, m.QueueSize, m.Workers), nil
+}
+
+func (p *Proxy) handleMirror(w http.ResponseWriter, r *http.Request) {
+	if p.mirror == nil {
+		http.Error(w, "mirror disabled", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(p.mirror.Stats())
+}
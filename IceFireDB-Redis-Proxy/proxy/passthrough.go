@@ -0,0 +1,45 @@
+/*
+ *
+ *  * Licensed to the Apache Software Foundation (ASF) under one or more
+ *  * contributor license agreements.  See the NOTICE file distributed with
+ *  * this work for additional information regarding copyright ownership.
+ *  * The ASF licenses this file to You under the Apache License, Version 2.0
+ *  * (the "License"); you may not use this file except in compliance with
+ *  * the License.  You may obtain a copy of the License at
+ *  *
+ *  *     http://www.apache.org/licenses/LICENSE-2.0
+ *  *
+ *  * Unless required by applicable law or agreed to in writing, software
+ *  * distributed under the License is distributed on an "AS IS" BASIS,
+ *  * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  * See the License for the specific language governing permissions and
+ *  * limitations under the License.
+ *
+ */
+
+package proxy
+
+import (
+	"net"
+
+	"github.com/IceFireDB/IceFireDB/IceFireDB-Redis-Proxy/pkg/backend"
+	"github.com/IceFireDB/IceFireDB/IceFireDB-Redis-Proxy/pkg/router"
+	"github.com/IceFireDB/components-go/RESPHandle"
+	"github.com/sirupsen/logrus"
+)
+
+// A function that gives the client connections dedicated to its blocking
+// commands and subscriptions. A client losing a subscription connection is
+// disconnected, so it subscribes again instead of missing the messages.
+func dedicateConns(client *router.Client, w *RESPHandle.WriterHandle, conn net.Conn) {
+	client.Blocking = &backend.Dedicated{}
+	client.Subscriber = backend.NewSubscriber(func(reply interface{}) error {
+		if err, ok := reply.(error); ok {
+			return router.WriteError(w, err)
+		}
+		return router.RecursivelyWriteObjects(w, reply.([]interface{})...)
+	}, func(err error) {
+		logrus.Warnf("subscription connection of %s lost: %v", client.Addr, err)
+		_ = conn.Close()
+	})
+}
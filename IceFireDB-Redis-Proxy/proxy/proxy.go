@@ -21,66 +21,76 @@ package proxy
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
 	"log"
 	"strings"
-	"time"
+	"sync"
 
-	"github.com/IceFireDB/components-go/cache"
 	"github.com/IceFireDB/components-go/p2p"
 
+	"github.com/IceFireDB/IceFireDB/IceFireDB-Redis-Proxy/pkg/backend"
+	"github.com/IceFireDB/IceFireDB/IceFireDB-Redis-Proxy/pkg/cache"
 	"github.com/IceFireDB/IceFireDB/IceFireDB-Redis-Proxy/pkg/config"
+	"github.com/IceFireDB/IceFireDB/IceFireDB-Redis-Proxy/pkg/graceful"
+	"github.com/IceFireDB/IceFireDB/IceFireDB-Redis-Proxy/pkg/ratelimit"
 	"github.com/IceFireDB/IceFireDB/IceFireDB-Redis-Proxy/pkg/router"
 	proxycluster "github.com/IceFireDB/IceFireDB/IceFireDB-Redis-Proxy/pkg/router/redisCluster"
 	proxynode "github.com/IceFireDB/IceFireDB/IceFireDB-Redis-Proxy/pkg/router/redisNode"
-	"github.com/IceFireDB/components-go/bareneter"
-	rediscluster "github.com/chasex/redis-go-cluster"
-	redisclient "github.com/gomodule/redigo/redis"
+	proxyshard "github.com/IceFireDB/IceFireDB/IceFireDB-Redis-Proxy/pkg/router/redisShard"
+	"github.com/sirupsen/logrus"
 )
 
 type Proxy struct {
 	Cache        *cache.Cache
-	proxyCluster *rediscluster.Cluster
-	proxyClient  *redisclient.Pool
-	server       *bareneter.Server
+	proxyCluster *backend.Cluster
+	server       *server
 	router       router.IRoutes
+	groups       map[string]*backend.Group
+	// Backends of the tenants that have their own, by tenant name
+	tenantGroups map[string]*backend.Group
+	tenants      *router.Tenants
 	P2pHost      *p2p.P2P
 	P2pSubPub    *p2p.PubSub
+
+	ctx          context.Context
+	mu           sync.Mutex
+	healthCancel context.CancelFunc
+	cacheCancel  context.CancelFunc
+	users        *router.Users
+	limiter      *ratelimit.Limiter
+	connQuota    *ratelimit.Quota
+	tlsConfig    *tls.Config
+	mirror       *backend.Mirror
+	// Tenant names by SNI server name
+	serverNames map[string]string
+	listener    *graceful.Listener
+	drainer     *graceful.Drainer
+	// stops the export of the spans, nil when the tracing is disabled
+	stopTracing func(context.Context) error
 }
 
 func New() (*Proxy, error) {
-	p := &Proxy{}
+	p := &Proxy{drainer: graceful.NewDrainer()}
 	var err error
-	if config.Get().RedisDB.Type == config.TypeNode {
-		p.proxyClient = &redisclient.Pool{
-			MaxIdle:     config.Get().RedisDB.ConnPoolSize,
-			IdleTimeout: time.Duration(config.Get().RedisDB.ConnAliveTimeOut) * time.Second,
-			Dial: func() (redisclient.Conn, error) {
-				c, err := redisclient.Dial("tcp", config.Get().RedisDB.StartNodes,
-					redisclient.DialConnectTimeout(time.Duration(config.Get().RedisDB.ConnTimeOut)*time.Second),
-					redisclient.DialReadTimeout(time.Duration(config.Get().RedisDB.ConnReadTimeOut)*time.Second),
-					redisclient.DialWriteTimeout(time.Duration(config.Get().RedisDB.ConnWriteTimeOut)*time.Second))
-				if err != nil {
-					return nil, err
-				}
-				return c, err
-			},
-			TestOnBorrow: func(c redisclient.Conn, t time.Time) error {
-				_, err := c.Do("PING")
-				return err
-			},
+	setLogLevel(config.Get().Log.Level)
+	recorder = newRecorder(&config.Get().Metrics)
+	if p.stopTracing, err = newTracing(&config.Get().Tracing); err != nil {
+		return nil, err
+	}
+	switch config.Get().RedisDB.Type {
+	case config.TypeNode:
+		if p.groups, err = newGroups(&config.Get().RedisDB); err != nil {
+			return nil, err
+		}
+		p.router = proxynode.NewRouter(p.groups[nodeGroup])
+	case config.TypeShard:
+		if p.groups, err = newGroups(&config.Get().RedisDB); err != nil {
+			return nil, err
 		}
-		p.router = proxynode.NewRouter(p.proxyClient)
-	} else {
-		p.proxyCluster, err = rediscluster.NewCluster(
-			&rediscluster.Options{
-				StartNodes:   strings.Split(config.Get().RedisDB.StartNodes, ","),
-				ConnTimeout:  time.Duration(config.Get().RedisDB.ConnTimeOut) * time.Second,
-				ReadTimeout:  time.Duration(config.Get().RedisDB.ConnReadTimeOut) * time.Second,
-				WriteTimeout: time.Duration(config.Get().RedisDB.ConnWriteTimeOut) * time.Second,
-				KeepAlive:    config.Get().RedisDB.ConnPoolSize,
-				AliveTime:    time.Duration(config.Get().RedisDB.ConnAliveTimeOut) * time.Second,
-			})
+		p.router = proxyshard.NewRouter(p.groups, config.Get().RedisDB.VirtualNodes)
+	default:
+		p.proxyCluster, err = newCluster(&config.Get().RedisDB)
 		if err != nil {
 			return nil, err
 		}
@@ -116,27 +126,88 @@ func New() (*Proxy, error) {
 		log.Printf("Successfully joined [%s] P2P channel. \n", config.Get().P2P.ServiceCommandTopic)
 	}
 
+	if p.tlsConfig, err = listenTLS(&config.Get().Proxy); err != nil {
+		return nil, err
+	}
+	p.serverNames = tenantServerNames(config.Get().Tenants)
+
+	p.useClientMiddlewares(config.Get())
+	p.router.Use(router.SubscribeModeMiddleware())
+	if p.tenants, err = p.newTenants(config.Get()); err != nil {
+		return nil, err
+	}
+	if p.tenants != nil {
+		p.router.Use(p.tenants.SelectMiddleware())
+	}
 	p.router.Use(router.IgnoreCMDMiddleware(config.Get().IgnoreCMD.Enable, config.Get().IgnoreCMD.CMDList))
 
+	if len(config.Get().CMDRules) > 0 {
+		rules := make([]*router.CMDRule, 0, len(config.Get().CMDRules))
+		for _, c := range config.Get().CMDRules {
+			rule, err := router.NewCMDRule(c.Command, c.Action, c.To, c.Clients)
+			if err != nil {
+				return nil, err
+			}
+			rules = append(rules, rule)
+		}
+		p.router.Use(router.CMDRulesMiddleware(p.router, rules))
+	}
+	if p.tenants != nil {
+		p.router.Use(p.tenants.RouteMiddleware())
+	}
+
 	if config.Get().P2P.Enable {
 		p.router.Use(router.PubSubMiddleware(p.router, p.P2pSubPub))
 	}
+
+	if p.mirror, err = newMirror(config.Get()); err != nil {
+		return nil, err
+	}
+	if p.mirror != nil {
+		p.router.Use(router.MirrorMiddleware(p.mirror, config.Get().Mirror.WriteOnly))
+	}
+
+	if p.Cache = newCache(&config.Get().Cache); p.Cache != nil {
+		p.router.Use(router.CacheMiddleware(p.Cache, config.Get().Cache.Patterns))
+	}
 	p.router.InitCMD()
 
-	p.server = bareneter.NewServerNetwork("tcp",
-		fmt.Sprintf(":%d", config.Get().Proxy.LocalPort),
-		p.handle,
-		p.accept,
-		p.closed)
+	p.server = newServer(p.handle, p.accept, p.closed)
 	return p, nil
 }
 
 func (p *Proxy) Run(ctx context.Context, errSignal chan error) {
+	p.mu.Lock()
+	p.ctx = ctx
+	p.startHealthChecks()
+	p.startInvalidation()
+	p.mu.Unlock()
+
+	p.purgeCache(ctx)
+	p.purgeLimiter(ctx)
+	p.watchReload(ctx)
 	go func() {
 		select {
 		case <-ctx.Done():
 			_ = p.server.Close()
+			if p.mirror != nil {
+				_ = p.mirror.Close()
+			}
+			if p.stopTracing != nil {
+				_ = p.stopTracing(context.Background())
+			}
 		}
 	}()
-	_ = p.server.ListenServeAndSignal(errSignal)
+	// the socket is inherited from the previous process on an upgrade
+	ln, err := graceful.Listen(fmt.Sprintf(":%d", config.Get().Proxy.LocalPort), config.Get().Proxy.ReusePort)
+	if err != nil {
+		errSignal <- err
+		return
+	}
+	p.listener = graceful.NewListener(ln)
+	errSignal <- nil
+	if err = graceful.Ready(); err != nil {
+		logrus.Errorf("notify the previous process fail: %v", err)
+	}
+	_ = p.server.Serve(p.listener)
 }
@@ -0,0 +1,263 @@
+/*
+ *
+ *  * Licensed to the Apache Software Foundation (ASF) under one or more
+ *  * contributor license agreements.  See the NOTICE file distributed with
+ *  * this work for additional information regarding copyright ownership.
+ *  * The ASF licenses this file to You under the Apache License, Version 2.0
+ *  * (the "License"); you may not use this file except in compliance with
+ *  * the License.  You may obtain a copy of the License at
+ *  *
+ *  *     http://www.apache.org/licenses/LICENSE-2.0
+ *  *
+ *  * Unless required by applicable law or agreed to in writing, software
+ *  * distributed under the License is distributed on an "AS IS" BASIS,
+ *  * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  * See the License for the specific language governing permissions and
+ *  * limitations under the License.
+ *
+ */
+
+package proxy
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+
+	"github.com/IceFireDB/IceFireDB/IceFireDB-Redis-Proxy/pkg/backend"
+	"github.com/IceFireDB/IceFireDB/IceFireDB-Redis-Proxy/pkg/config"
+	proxynode "github.com/IceFireDB/IceFireDB/IceFireDB-Redis-Proxy/pkg/router/redisNode"
+	proxyshard "github.com/IceFireDB/IceFireDB/IceFireDB-Redis-Proxy/pkg/router/redisShard"
+	"github.com/IceFireDB/IceFireDB/IceFireDB-Redis-Proxy/utils"
+)
+
+// ReloadReport lists the fields of the config file changed by a reload,
+// named by their path in the file
+type ReloadReport struct {
+	// Fields applied to the running proxy
+	Applied []string `json:"applied"`
+	// Fields that keep their running value until the proxy restarts
+	RestartRequired []string `json:"restart_required"`
+}
+
+// Reload re-reads and validates the config file, then applies its reloadable
+// settings: the backend topology and routing rules, the log level, the IP
+// white list, the passwords of the users and the limits already enabled.
+// Client connections are kept: the routers switch to the new backends and
+// the previous ones are closed, commands already running on them finish
+// first. The other changed fields are reported as requiring a restart, and
+// nothing is applied when the file is invalid.
+func (p *Proxy) Reload() (*ReloadReport, error) {
+	conf, err := config.Load()
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	cur := config.Get()
+	next := *cur
+	old, err := p.reloadBackends(cur, conf, &next)
+	if err != nil {
+		return nil, err
+	}
+
+	setLogLevel(conf.Log.Level)
+	next.Log.Level = conf.Log.Level
+	next.IPWhiteList = conf.IPWhiteList
+	// The authentication and the limits are enabled or disabled by a restart
+	if p.users != nil && len(conf.Users) > 0 {
+		p.users.Set(userPasswords(conf.Users))
+		next.Users = conf.Users
+	}
+	if p.limiter != nil && conf.Limits.Rate > 0 {
+		p.limiter.SetRate(conf.Limits.Rate, conf.Limits.Burst)
+		next.Limits.Rate, next.Limits.Burst = conf.Limits.Rate, conf.Limits.Burst
+	}
+	if p.connQuota != nil && conf.Limits.MaxConns > 0 {
+		p.connQuota.SetMax(conf.Limits.MaxConns)
+		next.Limits.MaxConns = conf.Limits.MaxConns
+	}
+	config.Set(&next)
+
+	if old != nil {
+		p.startHealthChecks()
+		p.startInvalidation()
+		for _, g := range old {
+			_ = g.Close()
+		}
+		logrus.Infof("reloaded redisdb backends: %d", len(p.groups))
+	}
+	report := &ReloadReport{Applied: config.Diff(cur, &next), RestartRequired: config.Diff(&next, conf)}
+	logrus.Infof("reloaded config, applied: %v", report.Applied)
+	if len(report.RestartRequired) > 0 {
+		logrus.Warnf("config changes require a restart: %v", report.RestartRequired)
+	}
+	return report, nil
+}
+
+// A method that switches the routers to the backends of the new config and
+// returns the previous backends, they are closed once the config is set. The
+// backend type only changes with a restart.
+func (p *Proxy) reloadBackends(cur, conf, next *config.Config) (map[string]*backend.Group, error) {
+	if conf.RedisDB.Type != cur.RedisDB.Type {
+		return nil, nil
+	}
+
+	if p.proxyCluster != nil {
+		// The cluster topology is discovered from the nodes, only the slot cache is refreshed
+		if err := p.proxyCluster.Refresh(); err != nil {
+			return nil, err
+		}
+		p.startInvalidation()
+		return nil, nil
+	}
+
+	var old map[string]*backend.Group
+	groups, err := newGroups(&conf.RedisDB)
+	if err != nil {
+		return nil, err
+	}
+	switch r := p.router.(type) {
+	case *proxynode.Router:
+		old = map[string]*backend.Group{nodeGroup: r.SetGroup(groups[nodeGroup])}
+	case *proxyshard.Router:
+		old = r.SetGroups(groups, conf.RedisDB.VirtualNodes)
+	default:
+		for _, g := range groups {
+			_ = g.Close()
+		}
+		return nil, nil
+	}
+	p.groups = groups
+	next.RedisDB = conf.RedisDB
+	return old, nil
+}
+
+// A function that sets the level of the logs, the level is validated by the config
+func setLogLevel(level string) {
+	if l, err := logrus.ParseLevel(level); err == nil {
+		logrus.SetLevel(l)
+	}
+}
+
+// A method that starts the configured reload sources: the config file
+// watch and the admin HTTP endpoint
+func (p *Proxy) watchReload(ctx context.Context) {
+	if config.Get().Reload.WatchFile {
+		viper.OnConfigChange(func(e fsnotify.Event) {
+			if _, err := p.Reload(); err != nil {
+				logrus.Errorf("reload config %s fail: %v", e.Name, err)
+			}
+		})
+		viper.WatchConfig()
+	}
+
+	if port := config.Get().Reload.AdminPort; port > 0 {
+		mux := http.NewServeMux()
+		mux.HandleFunc("/reload", p.handleReload)
+		mux.HandleFunc("/backends", p.handleBackends)
+		mux.HandleFunc("/mirror", p.handleMirror)
+		mux.HandleFunc("/tenants", p.handleTenants)
+		mux.HandleFunc("/metrics", p.handleMetrics)
+		mux.HandleFunc("/latency", p.handleLatency)
+		mux.HandleFunc("/slowlog", p.handleSlowLog)
+		rc := config.Get().Reload
+		srv := &http.Server{
+			Addr:    net.JoinHostPort(rc.AdminBind, strconv.Itoa(int(port))),
+			Handler: adminAuth(rc.AdminToken, mux),
+		}
+		utils.GoWithRecover(func() {
+			// after an upgrade the port is released when the previous process exits
+			for {
+				err := srv.ListenAndServe()
+				if err == nil || err == http.ErrServerClosed {
+					return
+				}
+				logrus.Errorf("admin http server fail: %v", err)
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(time.Second):
+				}
+			}
+		}, nil)
+		go func() {
+			<-ctx.Done()
+			_ = srv.Close()
+		}()
+	}
+}
+
+// A function that refuses the admin requests without the bearer token
+func adminAuth(token string, next http.Handler) http.Handler {
+	want := []byte("Bearer " + token)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), want) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (p *Proxy) handleReload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	report, err := p.Reload()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(report)
+}
+
+type nodeStatus struct {
+	Addr    string `json:"addr"`
+	Healthy bool   `json:"healthy"`
+}
+
+type groupStatus struct {
+	Name     string       `json:"name,omitempty"`
+	Primary  nodeStatus   `json:"primary"`
+	Replicas []nodeStatus `json:"replicas,omitempty"`
+}
+
+func newGroupStatus(name string, g *backend.Group) groupStatus {
+	s := groupStatus{Name: name}
+	s.Primary = nodeStatus{Addr: g.Primary().Addr, Healthy: g.Primary().Healthy()}
+	for _, n := range g.Replicas() {
+		s.Replicas = append(s.Replicas, nodeStatus{Addr: n.Addr, Healthy: n.Healthy()})
+	}
+	return s
+}
+
+func (p *Proxy) handleBackends(w http.ResponseWriter, r *http.Request) {
+	p.mu.Lock()
+	status := make([]groupStatus, 0, len(p.groups))
+	for name, g := range p.groups {
+		status = append(status, newGroupStatus(name, g))
+	}
+	for name, g := range p.tenantGroups {
+		status = append(status, newGroupStatus("tenant:"+name, g))
+	}
+	p.mu.Unlock()
+
+	sort.Slice(status, func(i, j int) bool { return status[i].Name < status[j].Name })
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(status)
+}
@@ -0,0 +1,151 @@
+/*
+ *
+ *  * Licensed to the Apache Software Foundation (ASF) under one or more
+ *  * contributor license agreements.  See the NOTICE file distributed with
+ *  * this work for additional information regarding copyright ownership.
+ *  * The ASF licenses this file to You under the Apache License, Version 2.0
+ *  * (the "License"); you may not use this file except in compliance with
+ *  * the License.  You may obtain a copy of the License at
+ *  *
+ *  *     http://www.apache.org/licenses/LICENSE-2.0
+ *  *
+ *  * Unless required by applicable law or agreed to in writing, software
+ *  * distributed under the License is distributed on an "AS IS" BASIS,
+ *  * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  * See the License for the specific language governing permissions and
+ *  * limitations under the License.
+ *
+ */
+
+package proxy
+
+import (
+	"errors"
+	"net"
+	"sync"
+
+	"github.com/IceFireDB/components-go/bareneter"
+)
+
+// server serves the clients of a listener as bareneter does, which only
+// serves the listeners it opens itself, while the listener of the proxy may
+// be inherited from the previous process on an upgrade
+type server struct {
+	handler func(conn bareneter.Conn)
+	accept  func(conn bareneter.Conn) bool
+	closed  func(conn bareneter.Conn, err error)
+
+	mu    sync.Mutex
+	ln    net.Listener
+	conns map[*serverConn]bool
+	done  bool
+}
+
+func newServer(handler func(conn bareneter.Conn), accept func(conn bareneter.Conn) bool,
+	closed func(conn bareneter.Conn, err error)) *server {
+	return &server{handler: handler, accept: accept, closed: closed, conns: make(map[*serverConn]bool)}
+}
+
+// Serve accepts the clients of ln until it is closed, and closes their
+// connections when it returns
+func (s *server) Serve(ln net.Listener) error {
+	s.mu.Lock()
+	if s.done {
+		s.mu.Unlock()
+		return ln.Close()
+	}
+	s.ln = ln
+	s.mu.Unlock()
+	defer func() {
+		ln.Close()
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		for c := range s.conns {
+			c.Close()
+		}
+		s.conns = nil
+	}()
+	for {
+		nc, err := ln.Accept()
+		if err != nil {
+			s.mu.Lock()
+			done := s.done
+			s.mu.Unlock()
+			if done || errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		c := &serverConn{conn: nc, addr: nc.RemoteAddr().String()}
+		if s.accept != nil && !s.accept(c) {
+			c.Close()
+			continue
+		}
+		s.mu.Lock()
+		if s.conns == nil {
+			s.mu.Unlock()
+			c.Close()
+			continue
+		}
+		s.conns[c] = true
+		s.mu.Unlock()
+		go s.serve(c)
+	}
+}
+
+// serve runs the handler on a connection until it is closed
+func (s *server) serve(c *serverConn) {
+	defer func() {
+		c.conn.Close()
+		s.mu.Lock()
+		delete(s.conns, c)
+		s.mu.Unlock()
+		if s.closed != nil {
+			s.closed(c, nil)
+		}
+	}()
+	for !c.IsClosed() {
+		s.handler(c)
+	}
+}
+
+// Close stops accepting clients, the connections are closed as Serve returns
+func (s *server) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.done = true
+	if s.ln == nil {
+		return nil
+	}
+	return s.ln.Close()
+}
+
+// serverConn is a client connection of the server
+type serverConn struct {
+	conn   net.Conn
+	addr   string
+	ctx    interface{}
+	mu     sync.Mutex
+	closed bool
+}
+
+func (c *serverConn) RemoteAddr() string { return c.addr }
+
+func (c *serverConn) NetConn() net.Conn { return c.conn }
+
+func (c *serverConn) Context() interface{} { return c.ctx }
+
+func (c *serverConn) SetContext(v interface{}) { c.ctx = v }
+
+func (c *serverConn) Close() error {
+	c.mu.Lock()
+	c.closed = true
+	c.mu.Unlock()
+	return c.conn.Close()
+}
+
+func (c *serverConn) IsClosed() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.closed
+}
@@ -0,0 +1,67 @@
+/*
+ *
+ *  * Licensed to the Apache Software Foundation (ASF) under one or more
+ *  * contributor license agreements.  See the NOTICE file distributed with
+ *  * this work for additional information regarding copyright ownership.
+ *  * The ASF licenses this file to You under the Apache License, Version 2.0
+ *  * (the "License"); you may not use this file except in compliance with
+ *  * the License.  You may obtain a copy of the License at
+ *  *
+ *  *     http://www.apache.org/licenses/LICENSE-2.0
+ *  *
+ *  * Unless required by applicable law or agreed to in writing, software
+ *  * distributed under the License is distributed on an "AS IS" BASIS,
+ *  * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  * See the License for the specific language governing permissions and
+ *  * limitations under the License.
+ *
+ */
+
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/IceFireDB/IceFireDB/IceFireDB-Redis-Proxy/pkg/backend"
+	"github.com/IceFireDB/IceFireDB/IceFireDB-Redis-Proxy/pkg/config"
+	"github.com/IceFireDB/IceFireDB/IceFireDB-Redis-Proxy/pkg/router"
+	proxynode "github.com/IceFireDB/IceFireDB/IceFireDB-Redis-Proxy/pkg/router/redisNode"
+)
+
+// A method that creates the tenants of the configuration, nil when there is
+// none. Tenants with a backend get a router of their own, their groups are
+// kept with the tenant groups for the health checks.
+func (p *Proxy) newTenants(conf *config.Config) (*router.Tenants, error) {
+	if len(conf.Tenants) == 0 {
+		return nil, nil
+	}
+	opt, err := backendOptions(&conf.RedisDB)
+	if err != nil {
+		return nil, err
+	}
+	split := splitRules(&conf.RedisDB)
+
+	tenants := router.NewTenants()
+	p.tenantGroups = make(map[string]*backend.Group)
+	for _, t := range conf.Tenants {
+		var routes router.IRoutes
+		if t.Backend.Addr != "" {
+			g := backend.NewGroup(t.Backend.Addr, t.Backend.Replicas, opt, split)
+			p.tenantGroups[t.Name] = g
+			routes = proxynode.NewRouter(g)
+			routes.InitCMD()
+		}
+		tenants.Add(t.Name, t.Users, t.KeyPrefixes, t.Namespace, routes)
+	}
+	return tenants, nil
+}
+
+func (p *Proxy) handleTenants(w http.ResponseWriter, r *http.Request) {
+	if p.tenants == nil {
+		http.Error(w, "no tenant", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(p.tenants.Stats())
+}
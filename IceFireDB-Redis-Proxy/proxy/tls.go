@@ -0,0 +1,72 @@
+/*
+ *
+ *  * Licensed to the Apache Software Foundation (ASF) under one or more
+ *  * contributor license agreements.  See the NOTICE file distributed with
+ *  * this work for additional information regarding copyright ownership.
+ *  * The ASF licenses this file to You under the Apache License, Version 2.0
+ *  * (the "License"); you may not use this file except in compliance with
+ *  * the License.  You may obtain a copy of the License at
+ *  *
+ *  *     http://www.apache.org/licenses/LICENSE-2.0
+ *  *
+ *  * Unless required by applicable law or agreed to in writing, software
+ *  * distributed under the License is distributed on an "AS IS" BASIS,
+ *  * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  * See the License for the specific language governing permissions and
+ *  * limitations under the License.
+ *
+ */
+
+package proxy
+
+import (
+	"crypto/tls"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/IceFireDB/IceFireDB/IceFireDB-Redis-Proxy/pkg/config"
+	"github.com/IceFireDB/IceFireDB/IceFireDB-Redis-Proxy/pkg/tlsconf"
+)
+
+// Time a client has to complete the TLS handshake
+const tlsHandshakeTimeout = 10 * time.Second
+
+// A function that creates the TLS configuration of the proxy port, nil when TLS is disabled
+func listenTLS(conf *config.ProxyS) (*tls.Config, error) {
+	if !conf.TLS.Enable {
+		return nil, nil
+	}
+	pairs := make([]tlsconf.KeyPair, 0, len(conf.TLS.Certs))
+	for _, c := range conf.TLS.Certs {
+		pairs = append(pairs, tlsconf.KeyPair{CertFile: c.CertFile, KeyFile: c.KeyFile})
+	}
+	var clientCA string
+	if conf.EnableMTLS {
+		clientCA = conf.TLS.ClientCAFile
+	}
+	return tlsconf.Server(pairs, clientCA)
+}
+
+// A function that maps the SNI server names to the name of their tenant
+func tenantServerNames(tenants []config.TenantS) map[string]string {
+	names := make(map[string]string)
+	for _, t := range tenants {
+		for _, sn := range t.ServerNames {
+			names[strings.ToLower(sn)] = t.Name
+		}
+	}
+	return names
+}
+
+// A method that terminates the TLS of a client connection
+// and returns the tenant selected by its server name
+func (p *Proxy) handshake(conn net.Conn) (*tls.Conn, string, error) {
+	tlsConn := tls.Server(conn, p.tlsConfig)
+	_ = conn.SetDeadline(time.Now().Add(tlsHandshakeTimeout))
+	if err := tlsConn.Handshake(); err != nil {
+		return nil, "", err
+	}
+	_ = conn.SetDeadline(time.Time{})
+	return tlsConn, p.serverNames[strings.ToLower(tlsConn.ConnectionState().ServerName)], nil
+}
@@ -0,0 +1,75 @@
+/*
+ *
+ *  * Licensed to the Apache Software Foundation (ASF) under one or more
+ *  * contributor license agreements.  See the NOTICE file distributed with
+ *  * this work for additional information regarding copyright ownership.
+ *  * The ASF licenses this file to You under the Apache License, Version 2.0
+ *  * (the "License"); you may not use this file except in compliance with
+ *  * the License.  You may obtain a copy of the License at
+ *  *
+ *  *     http://www.apache.org/licenses/LICENSE-2.0
+ *  *
+ *  * Unless required by applicable law or agreed to in writing, software
+ *  * distributed under the License is distributed on an "AS IS" BASIS,
+ *  * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  * See the License for the specific language governing permissions and
+ *  * limitations under the License.
+ *
+ */
+
+package proxy
+
+import (
+	"context"
+	"strings"
+
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/IceFireDB/IceFireDB/IceFireDB-Redis-Proxy/pkg/config"
+	"github.com/IceFireDB/IceFireDB/IceFireDB-Redis-Proxy/pkg/router"
+	"github.com/IceFireDB/IceFireDB/IceFireDB-Redis-Proxy/pkg/tracing"
+)
+
+// A function that starts the export of the spans, the returned function
+// stops it and is nil when the tracing is disabled
+func newTracing(conf *config.TracingS) (func(context.Context) error, error) {
+	if !conf.Enable {
+		return nil, nil
+	}
+	return tracing.Init(conf.Exporter, conf.Endpoint, conf.SampleRatio)
+}
+
+// A function that starts the span of a command of the client, the backends
+// called for the command run in its trace. It returns nil when the tracing
+// is disabled.
+func startCommand(client *router.Client, args []interface{}) trace.Span {
+	if !tracing.Enabled() {
+		return nil
+	}
+	name, _ := args[0].([]byte)
+	cmd := strings.ToUpper(string(name))
+	// unknown commands would grow the span names without bound
+	if _, ok := router.OpTable[cmd]; !ok {
+		cmd = "UNKNOWN"
+	}
+	ctx, span := tracing.Tracer().Start(context.Background(), cmd,
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(semconv.DBSystemRedis, semconv.DBOperationName(cmd), semconv.ClientAddress(client.IP.String())))
+	client.Trace = ctx
+	return span
+}
+
+// A function that ends the span of the command of the client
+func endCommand(span trace.Span, client *router.Client, err error) {
+	if span == nil {
+		return
+	}
+	client.Trace = nil
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
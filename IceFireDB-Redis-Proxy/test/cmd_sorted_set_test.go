@@ -223,7 +223,7 @@ func TestSortedSetAdd(t *testing.T) {
 
 		mustDo(t, c,
 			"ZADD", "z", "INCR", "XX", "1.2", "one",
-			proto.String("3.6"),
+			proto.String("3.5999999999999996"),
 		)
 
 		mustNil(t, c,
@@ -608,19 +608,19 @@ func TestSortedSetRangeByScore(t *testing.T) {
 		)
 		mustDo(t, c,
 			"ZRANGEBYSCORE", "set", "[1", "2", "toomany",
-			proto.Error("ERR min or max is not a float"),
+			proto.Error(msgSyntaxError),
 		)
 		mustDo(t, c,
 			"ZRANGEBYSCORE", "set", "1", "[2", "toomany",
-			proto.Error("ERR min or max is not a float"),
+			proto.Error(msgSyntaxError),
 		)
 		mustDo(t, c,
 			"ZRANGEBYSCORE", "set", "[1", "2", "LIMIT", "noint", "1",
-			proto.Error("ERR min or max is not a float"),
+			proto.Error(msgInvalidInt),
 		)
 		mustDo(t, c,
 			"ZRANGEBYSCORE", "set", "[1", "2", "LIMIT", "1", "noint",
-			proto.Error("ERR min or max is not a float"),
+			proto.Error(msgInvalidInt),
 		)
 		// Wrong type of key
 		s.Set("str", "value")
@@ -1798,7 +1798,7 @@ func TestSortedSetPopMin(t *testing.T) {
 	// weird cases.
 	mustDo(t, c,
 		"ZPOPMIN", "z", "-100",
-		proto.Strings(),
+		proto.Error(msgOutOfRangePositive),
 	)
 
 	// Nonexistent key
@@ -1820,7 +1820,7 @@ func TestSortedSetPopMin(t *testing.T) {
 		)
 		mustDo(t, c,
 			"ZPOPMIN", "set", "noint",
-			proto.Error(msgInvalidInt),
+			proto.Error(msgOutOfRangePositive),
 		)
 		mustDo(t, c,
 			"ZPOPMIN", "set", "1", "toomany",
@@ -1864,7 +1864,7 @@ func TestSortedSetPopMax(t *testing.T) {
 	// weird cases.
 	mustDo(t, c,
 		"ZPOPMAX", "z", "-100",
-		proto.Strings(),
+		proto.Error(msgOutOfRangePositive),
 	)
 
 	// Nonexistent key
@@ -1887,7 +1887,7 @@ func TestSortedSetPopMax(t *testing.T) {
 
 		mustDo(t, c,
 			"ZPOPMAX", "set", "noint",
-			proto.Error(msgInvalidInt),
+			proto.Error(msgOutOfRangePositive),
 		)
 		mustDo(t, c,
 			"ZPOPMAX", "set", "1", "toomany",
@@ -62,10 +62,6 @@ func ReadArray(b string) ([]string, error) {
 		if err != nil {
 			return nil, err
 		}
-		next, err = ReadString(next)
-		if err != nil {
-			return nil, err
-		}
 		res = append(res, next)
 	}
 	return res, nil
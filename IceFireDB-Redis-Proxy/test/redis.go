@@ -17,6 +17,7 @@ const (
 	msgSyntaxError        = "ERR syntax error"
 	msgKeyNotFound        = "ERR no such key"
 	msgOutOfRange         = "ERR index out of range"
+	msgOutOfRangePositive = "ERR value is out of range, must be positive"
 	msgInvalidCursor      = "ERR invalid cursor"
 	msgXXandNX            = "ERR XX and NX options at the same time are not compatible"
 	msgNegTimeout         = "ERR timeout is negative"
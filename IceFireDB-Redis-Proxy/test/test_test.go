@@ -209,7 +209,7 @@ func directDoStringArrErr(tb testing.TB, c *proto.Client, args ...interface{}) (
 
 	res, err := c.Do(argList...)
 	ok(tb, err)
-	return proto.ReadArray(res)
+	return proto.ReadStrings(res)
 }
 
 func directDoStringErr(tb testing.TB, c *proto.Client, args ...interface{}) (string, error) {
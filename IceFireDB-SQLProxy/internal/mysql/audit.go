@@ -0,0 +1,67 @@
+package mysql
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/IceFireDB/IceFireDB/IceFireDB-SQLProxy/pkg/audit"
+	"github.com/IceFireDB/IceFireDB/IceFireDB-SQLProxy/pkg/config"
+	"github.com/IceFireDB/IceFireDB/IceFireDB-SQLProxy/pkg/mysql/mysql"
+	"github.com/IceFireDB/IceFireDB/IceFireDB-SQLProxy/pkg/mysql/server"
+)
+
+func newAuditLogger() (*audit.Logger, error) {
+	ac := config.Get().Audit
+	if !ac.Enable {
+		return nil, nil
+	}
+	var sink audit.Sink
+	switch ac.Sink {
+	case "file":
+		if ac.File == "" {
+			return nil, fmt.Errorf("audit file is required")
+		}
+		s, err := audit.NewFileSink(ac.File)
+		if err != nil {
+			return nil, err
+		}
+		sink = s
+	case "http":
+		if ac.URL == "" {
+			return nil, fmt.Errorf("audit url is required")
+		}
+		sink = audit.NewHTTPSink(ac.URL, time.Duration(ac.Timeout)*time.Millisecond)
+	default:
+		return nil, fmt.Errorf("unknown audit sink %q", ac.Sink)
+	}
+	return audit.NewLogger(sink, audit.Options{
+		SampleRate:    ac.SampleRate,
+		WritesOnly:    ac.WritesOnly,
+		BatchSize:     ac.BatchSize,
+		FlushInterval: time.Duration(ac.FlushInterval) * time.Millisecond,
+		BufferSize:    ac.BufferSize,
+	}), nil
+}
+
+// audit logs a statement of the client of c started at start, when it is
+// sampled
+func (h *Handle) audit(c *server.Conn, query string, start time.Time, res *mysql.Result, err error) {
+	if h.proxy == nil || h.proxy.audit == nil {
+		return
+	}
+	write := isDML(query)
+	if !h.proxy.audit.Sampled(write, err != nil) {
+		return
+	}
+	rec := audit.NewRecord(c.GetUser(), c.RemoteAddr().String(), c.GetDB(), query, write, time.Since(start))
+	if err != nil {
+		rec.Error = err.Error()
+	}
+	if res != nil {
+		rec.AffectedRows = res.AffectedRows
+		if res.Resultset != nil {
+			rec.ReturnedRows = len(res.Resultset.Values)
+		}
+	}
+	h.proxy.audit.Log(rec)
+}
@@ -0,0 +1,211 @@
+package mysql
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/IceFireDB/IceFireDB/IceFireDB-SQLProxy/pkg/config"
+	"github.com/IceFireDB/IceFireDB/IceFireDB-SQLProxy/pkg/mysql/client"
+	"github.com/IceFireDB/IceFireDB/IceFireDB-SQLProxy/pkg/mysql/mysql"
+	"github.com/IceFireDB/IceFireDB/IceFireDB-SQLProxy/utils"
+	"github.com/sirupsen/logrus"
+	"go.uber.org/atomic"
+)
+
+// replica is a read-only backend receiving the SELECTs outside of transactions
+type replica struct {
+	addr    string
+	pool    *client.Pool
+	healthy atomic.Bool
+}
+
+// replicaSet balances the reads between the healthy replicas
+type replicaSet struct {
+	replicas []*replica
+	next     atomic.Uint32
+}
+
+func newReplicaSet(mc config.MysqlS) (*replicaSet, error) {
+	rs := &replicaSet{}
+	for _, rc := range mc.Replicas {
+		if rc.User == "" {
+			rc.User, rc.Password = mc.User, mc.Password
+		}
+		if rc.MaxAlive <= 0 {
+			rc.MaxAlive = mc.MaxAlive
+		}
+		pool, err := client.NewPool(
+			logrus.Infof,
+			mc.MinAlive,
+			rc.MaxAlive,
+			mc.MaxIdle,
+			rc.Addr,
+			rc.User,
+			rc.Password,
+			mc.DBName,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create replica %s pool: %v", rc.Addr, err)
+		}
+		r := &replica{addr: rc.Addr, pool: pool}
+		r.healthy.Store(true)
+		rs.replicas = append(rs.replicas, r)
+	}
+	return rs, nil
+}
+
+// pick returns the next healthy replica, nil when there is none
+func (rs *replicaSet) pick() *replica {
+	n := uint32(len(rs.replicas))
+	for i := uint32(0); i < n; i++ {
+		r := rs.replicas[(rs.next.Inc()+i)%n]
+		if r.healthy.Load() {
+			return r
+		}
+	}
+	return nil
+}
+
+// healthCheck marks the replicas that fail a ping, or lag behind their source
+// more than maxReplicaLag seconds, as unhealthy until they pass a check
+func (m *mysqlProxy) healthCheck() {
+	mc := config.Get().Mysql
+	interval := time.Duration(mc.HealthCheckInterval) * time.Second
+	utils.GoWithRecover(func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-m.ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			for _, r := range m.replicas.replicas {
+				err := m.checkReplica(r, mc.MaxReplicaLag, interval)
+				if healthy := err == nil; healthy != r.healthy.Load() {
+					if healthy {
+						logrus.Infof("replica %s is healthy", r.addr)
+					} else {
+						logrus.Warningf("replica %s is unhealthy: %v", r.addr, err)
+					}
+					r.healthy.Store(healthy)
+				}
+			}
+		}
+	}, func(r interface{}) {
+		time.Sleep(time.Second)
+		m.healthCheck()
+	})
+}
+
+func (m *mysqlProxy) checkReplica(r *replica, maxLag int, timeout time.Duration) (err error) {
+	ctx, cancel := context.WithTimeout(m.ctx, timeout)
+	defer cancel()
+	conn, err := r.pool.GetConn(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		m.pushConn(conn, err, r.pool)
+	}()
+	if err = conn.Ping(); err != nil || maxLag <= 0 {
+		return err
+	}
+
+	res, err := conn.Execute("SHOW REPLICA STATUS")
+	if err != nil {
+		// before MySQL 8.0.22
+		if res, err = conn.Execute("SHOW SLAVE STATUS"); err != nil {
+			return err
+		}
+	}
+	if res.RowNumber() == 0 {
+		// not a replica
+		return nil
+	}
+	column, err := res.NameIndex("Seconds_Behind_Source")
+	if err != nil {
+		if column, err = res.NameIndex("Seconds_Behind_Master"); err != nil {
+			return err
+		}
+	}
+	if null, _ := res.IsNull(0, column); null {
+		return fmt.Errorf("replication is stopped")
+	}
+	lag, err := res.GetInt(0, column)
+	if err != nil {
+		return err
+	}
+	if lag > int64(maxLag) {
+		return fmt.Errorf("%d seconds behind the source", lag)
+	}
+	return nil
+}
+
+// The reads depending on the session or locking rows, which stay on the
+// primary
+var primaryReads = []string{
+	"FOR UPDATE",
+	"FOR SHARE",
+	"LOCK IN SHARE MODE",
+	"INTO",
+	"@",
+	"LAST_INSERT_ID",
+	"FOUND_ROWS",
+	"ROW_COUNT",
+	"GET_LOCK",
+	"RELEASE_LOCK",
+	"IS_FREE_LOCK",
+	"IS_USED_LOCK",
+	"CONNECTION_ID",
+}
+
+// isReplicaRead tells whether a query can be sent to a replica
+func isReplicaRead(query string) bool {
+	q := strings.ToUpper(strings.TrimSpace(query))
+	if !strings.HasPrefix(q, "SELECT") {
+		return false
+	}
+	for _, v := range primaryReads {
+		if strings.Contains(q, v) {
+			return false
+		}
+	}
+	return true
+}
+
+// readReplica executes a read on a replica. ok is false when no replica is
+// healthy or has a free connection in time, and the read goes to the primary.
+func (m *mysqlProxy) readReplica(db string, query string) (res *mysql.Result, ok bool, err error) {
+	r := m.replicas.pick()
+	if r == nil {
+		return nil, false, nil
+	}
+	ctx, cancel := context.WithTimeout(m.ctx, time.Duration(config.Get().Mysql.AcquireTimeout)*time.Millisecond)
+	defer cancel()
+	conn, err := m.popConnContext(ctx, r.pool)
+	if err != nil || conn == nil {
+		logrus.Warningf("replica %s conn err: %v", r.addr, err)
+		return nil, false, nil
+	}
+	defer func() {
+		m.pushConn(conn, err, r.pool)
+	}()
+	if db != "" {
+		if err = conn.UseDB(db); err != nil {
+			return nil, false, err
+		}
+	}
+	res, err = conn.Execute(query)
+	var myErr *mysql.MyError
+	if err != nil && !errors.As(err, &myErr) {
+		// the replica failed, not the query
+		logrus.Warningf("replica %s is unhealthy: %v", r.addr, err)
+		r.healthy.Store(false)
+		return nil, false, err
+	}
+	return res, true, err
+}
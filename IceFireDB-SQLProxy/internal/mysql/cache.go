@@ -0,0 +1,79 @@
+package mysql
+
+import (
+	"strings"
+	"time"
+
+	"github.com/IceFireDB/IceFireDB/IceFireDB-SQLProxy/pkg/config"
+	"github.com/IceFireDB/IceFireDB/IceFireDB-SQLProxy/pkg/mysql/mysql"
+	"github.com/IceFireDB/IceFireDB/IceFireDB-SQLProxy/pkg/querycache"
+	"github.com/IceFireDB/IceFireDB/IceFireDB-SQLProxy/pkg/replication"
+)
+
+// The functions whose result changes between two runs of the same query
+var volatileReads = []string{
+	"NOW(",
+	"CURRENT_",
+	"CURDATE",
+	"CURTIME",
+	"SYSDATE",
+	"UTC_",
+	"UNIX_TIMESTAMP",
+	"RAND(",
+	"UUID",
+	"SLEEP",
+	"USER(",
+	"SQL_NO_CACHE",
+}
+
+// isCacheableRead tells whether the result of a query can be cached
+func isCacheableRead(query string) bool {
+	if !isReplicaRead(query) {
+		return false
+	}
+	q := strings.ToUpper(query)
+	for _, v := range volatileReads {
+		if strings.Contains(q, v) {
+			return false
+		}
+	}
+	return true
+}
+
+func newQueryCache() (*querycache.Cache, error) {
+	cc := config.Get().Cache
+	if !cc.Enable {
+		return nil, nil
+	}
+	return querycache.New(cc.MaxEntries, time.Duration(cc.TTL)*time.Millisecond, cc.MaxRows)
+}
+
+// cachedRead returns the cached result of a read, or caches the result of
+// read
+func (m *mysqlProxy) cachedRead(db string, query string, prepared bool, args []interface{}, read func() (*mysql.Result, error)) (*mysql.Result, error) {
+	tables, ok := querycache.ReadTables(db, query)
+	if !ok {
+		return read()
+	}
+	key := querycache.Key(db, query, prepared, args)
+	if res := m.cache.Get(key); res != nil {
+		return res, nil
+	}
+	snapshot := m.cache.Snapshot(tables)
+	res, err := read()
+	if err == nil {
+		m.cache.Put(key, snapshot, res)
+	}
+	return res, err
+}
+
+// invalidate invalidates the cached results of the tables written by the
+// statements
+func (m *mysqlProxy) invalidate(db string, ops []replication.Op) {
+	if m.cache == nil {
+		return
+	}
+	for _, op := range ops {
+		m.cache.Invalidate(querycache.WriteTables(db, op.SQL))
+	}
+}
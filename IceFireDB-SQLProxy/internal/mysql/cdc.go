@@ -0,0 +1,361 @@
+package mysql
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/IceFireDB/IceFireDB/IceFireDB-SQLProxy/pkg/config"
+	"github.com/IceFireDB/IceFireDB/IceFireDB-SQLProxy/pkg/mysql/binlog"
+	"github.com/IceFireDB/IceFireDB/IceFireDB-SQLProxy/pkg/mysql/mysql"
+	"github.com/IceFireDB/IceFireDB/IceFireDB-SQLProxy/pkg/replication"
+	"github.com/IceFireDB/IceFireDB/IceFireDB-SQLProxy/utils"
+	"github.com/sirupsen/logrus"
+)
+
+// cdc captures the writes of the backend from its row-based binlog, so the
+// writes that bypass the proxy are replicated too
+type cdc struct {
+	m      *mysqlProxy
+	syncer *binlog.Syncer
+	db     string
+	// The position of the last transaction published
+	pos mysql.Position
+	// The columns of the tables of db, by table
+	columns map[string][]cdcColumn
+	// The writes of the current transaction
+	ops []replication.Op
+	// Tells the current transaction was applied from a peer
+	applied bool
+	// Tells the current transaction has rows that could not be captured
+	broken bool
+}
+
+type cdcColumn struct {
+	name     string
+	unsigned bool
+	primary  bool
+}
+
+// The DDL applied from the peers, by connection id of the backend, which is
+// not captured again
+var ddlEchoes = struct {
+	sync.Mutex
+	threads map[uint32]int
+}{threads: make(map[uint32]int)}
+
+func expectEcho(threadID uint32, n int) {
+	ddlEchoes.Lock()
+	defer ddlEchoes.Unlock()
+	ddlEchoes.threads[threadID] += n
+	if ddlEchoes.threads[threadID] <= 0 {
+		delete(ddlEchoes.threads, threadID)
+	}
+}
+
+func consumeEcho(threadID uint32) bool {
+	ddlEchoes.Lock()
+	defer ddlEchoes.Unlock()
+	if ddlEchoes.threads[threadID] == 0 {
+		return false
+	}
+	ddlEchoes.threads[threadID]--
+	if ddlEchoes.threads[threadID] == 0 {
+		delete(ddlEchoes.threads, threadID)
+	}
+	return true
+}
+
+func startCDC(m *mysqlProxy) {
+	cc := config.Get().CDC
+	mc := config.Get().Mysql
+	if cc.User == "" {
+		cc.User, cc.Password = mc.User, mc.Password
+	}
+	if cc.ServerID == 0 {
+		cc.ServerID = uint32(rand.New(rand.NewSource(time.Now().UnixNano())).Int31n(1<<30)) + 1000
+	}
+	c := &cdc{
+		m: m,
+		syncer: binlog.NewSyncer(binlog.SyncerConfig{
+			ServerID: cc.ServerID,
+			Addr:     mc.Addr,
+			User:     cc.User,
+			Password: cc.Password,
+		}),
+		db:      mc.DBName,
+		columns: make(map[string][]cdcColumn),
+	}
+	utils.GoWithRecover(c.run, func(r interface{}) {
+		time.Sleep(time.Second)
+		startCDC(m)
+	})
+}
+
+func (c *cdc) run() {
+	var err error
+	if c.pos, err = loadPosition(); err != nil {
+		logrus.Errorf("cdc load position err: %v", err)
+	}
+	for c.m.ctx.Err() == nil {
+		if c.pos.Name == "" {
+			if c.pos, err = c.syncer.MasterPosition(); err != nil {
+				logrus.Errorf("cdc get master position err: %v", err)
+				time.Sleep(time.Second)
+				continue
+			}
+		}
+		logrus.Infof("cdc sync from %s", c.pos)
+		err = c.syncer.Sync(c.m.ctx, c.pos, c.handleEvent)
+		if c.m.ctx.Err() != nil {
+			return
+		}
+		logrus.Errorf("cdc sync err: %v", err)
+		c.ops, c.applied, c.broken = nil, false, false
+		time.Sleep(time.Second)
+	}
+}
+
+func (c *cdc) handleEvent(e *binlog.Event) error {
+	switch ev := e.Event.(type) {
+	case *binlog.RotateEvent:
+		c.pos = mysql.Position{Name: ev.NextLogName, Pos: uint32(ev.Position)}
+	case *binlog.QueryEvent:
+		query := strings.ToUpper(strings.TrimSpace(ev.Query))
+		switch {
+		case query == "BEGIN":
+			c.ops, c.applied, c.broken = nil, false, false
+		case query == "COMMIT":
+			c.commit(e.Header.LogPos)
+		case query == "ROLLBACK", strings.HasPrefix(query, "SAVEPOINT"), strings.HasPrefix(query, "XA "):
+		default:
+			// DDL commits on its own
+			c.columns = make(map[string][]cdcColumn)
+			c.m.invalidate(ev.Schema, []replication.Op{{SQL: ev.Query}})
+			if ev.Schema == c.db && !consumeEcho(ev.ThreadID) && !isOSCTable(ev.Query) {
+				broadcast([]replication.Op{{SQL: ev.Query}}, "admin")
+			}
+			c.pos.Pos = e.Header.LogPos
+			savePosition(c.pos)
+		}
+	case *binlog.XIDEvent:
+		c.commit(e.Header.LogPos)
+	case *binlog.RowsEvent:
+		if ev.Table.Schema != c.db || isOSCTable(ev.Table.Table) {
+			return nil
+		}
+		if ev.Table.Table == gtidTable {
+			c.applied = true
+			return nil
+		}
+		if err := c.rowsOps(e.Header.EventType, ev); err != nil {
+			logrus.Errorf("cdc rows of %s at %s err: %v", ev.Table.Table, c.pos, err)
+			c.broken = true
+		}
+	}
+	return nil
+}
+
+// commit publishes the writes of the transaction
+func (c *cdc) commit(logPos uint32) {
+	c.m.invalidate(c.db, c.ops)
+	switch {
+	case c.broken:
+		logrus.Errorf("cdc transaction before %s:%d not published", c.pos.Name, logPos)
+	case !c.applied:
+		broadcast(c.ops, "admin")
+	}
+	c.ops, c.applied, c.broken = nil, false, false
+	c.pos.Pos = logPos
+	savePosition(c.pos)
+}
+
+// rowsOps converts the rows of an event to statements
+func (c *cdc) rowsOps(t binlog.EventType, ev *binlog.RowsEvent) error {
+	columns, err := c.tableColumns(ev.Table.Table)
+	if err != nil {
+		return err
+	}
+	if len(columns) != int(ev.Table.ColumnCount) {
+		return fmt.Errorf("%d columns in binlog, %d in table", ev.Table.ColumnCount, len(columns))
+	}
+	for _, tp := range ev.Table.ColumnType {
+		if tp == mysql.MYSQL_TYPE_JSON {
+			return fmt.Errorf("JSON columns are not supported")
+		}
+	}
+	table := quoteName(ev.Table.Table)
+
+	switch t {
+	case binlog.WRITE_ROWS_EVENTv1, binlog.WRITE_ROWS_EVENTv2:
+		for _, row := range ev.Rows {
+			names, marks, args := c.assignments(columns, ev.Table, ev.Columns, row)
+			op, err := newOp("INSERT INTO "+table+" ("+strings.Join(names, ", ")+") VALUES ("+strings.Join(marks, ", ")+")", args)
+			if err != nil {
+				return err
+			}
+			c.ops = append(c.ops, op)
+		}
+	case binlog.UPDATE_ROWS_EVENTv1, binlog.UPDATE_ROWS_EVENTv2:
+		for i := 0; i+1 < len(ev.Rows); i += 2 {
+			names, _, args := c.assignments(columns, ev.Table, ev.Columns, ev.Rows[i+1])
+			for j := range names {
+				names[j] += " = ?"
+			}
+			where, whereArgs := c.where(columns, ev.Table, ev.BeforeColumns, ev.Rows[i])
+			op, err := newOp("UPDATE "+table+" SET "+strings.Join(names, ", ")+" WHERE "+where+" LIMIT 1", append(args, whereArgs...))
+			if err != nil {
+				return err
+			}
+			c.ops = append(c.ops, op)
+		}
+	case binlog.DELETE_ROWS_EVENTv1, binlog.DELETE_ROWS_EVENTv2:
+		for _, row := range ev.Rows {
+			where, args := c.where(columns, ev.Table, ev.BeforeColumns, row)
+			op, err := newOp("DELETE FROM "+table+" WHERE "+where+" LIMIT 1", args)
+			if err != nil {
+				return err
+			}
+			c.ops = append(c.ops, op)
+		}
+	}
+	return nil
+}
+
+// assignments returns the names, the placeholders and the values of the
+// columns present in a row image
+func (c *cdc) assignments(columns []cdcColumn, table *binlog.TableMapEvent, present []bool, row []interface{}) (names, marks []string, args []interface{}) {
+	for i, ok := range present {
+		if !ok {
+			continue
+		}
+		names = append(names, quoteName(columns[i].name))
+		marks = append(marks, "?")
+		args = append(args, columnValue(columns[i], table.ColumnType[i], row[i]))
+	}
+	return
+}
+
+// where returns the condition matching the row of a before image, on the
+// primary key when the image has it
+func (c *cdc) where(columns []cdcColumn, table *binlog.TableMapEvent, present []bool, row []interface{}) (string, []interface{}) {
+	usePrimary := false
+	for i, col := range columns {
+		if col.primary {
+			usePrimary = true
+			if !present[i] {
+				usePrimary = false
+				break
+			}
+		}
+	}
+	var conds []string
+	var args []interface{}
+	for i, ok := range present {
+		if !ok || (usePrimary && !columns[i].primary) {
+			continue
+		}
+		conds = append(conds, quoteName(columns[i].name)+" <=> ?")
+		args = append(args, columnValue(columns[i], table.ColumnType[i], row[i]))
+	}
+	return strings.Join(conds, " AND "), args
+}
+
+// columnValue converts the integers of the unsigned columns, which the
+// binlog holds as signed
+func columnValue(col cdcColumn, tp byte, v interface{}) interface{} {
+	if !col.unsigned {
+		return v
+	}
+	switch n := v.(type) {
+	case int8:
+		return uint64(uint8(n))
+	case int16:
+		return uint64(uint16(n))
+	case int32:
+		if tp == mysql.MYSQL_TYPE_INT24 {
+			return uint64(uint32(n) & 0xffffff)
+		}
+		return uint64(uint32(n))
+	case int64:
+		return uint64(n)
+	}
+	return v
+}
+
+func newOp(sql string, args []interface{}) (replication.Op, error) {
+	values, err := replication.EncodeArgs(args)
+	if err != nil {
+		return replication.Op{}, err
+	}
+	return replication.Op{SQL: sql, Args: values}, nil
+}
+
+func quoteName(name string) string {
+	return "`" + strings.ReplaceAll(name, "`", "``") + "`"
+}
+
+// tableColumns returns the columns of a table of the database
+func (c *cdc) tableColumns(table string) (columns []cdcColumn, err error) {
+	if columns, ok := c.columns[table]; ok {
+		return columns, nil
+	}
+	conn, err := c.m.popAdminConn()
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		c.m.pushAdminConn(conn, err)
+	}()
+	res, err := conn.Execute("SELECT COLUMN_NAME, COLUMN_TYPE, COLUMN_KEY FROM information_schema.COLUMNS "+
+		"WHERE TABLE_SCHEMA = ? AND TABLE_NAME = ? ORDER BY ORDINAL_POSITION", c.db, table)
+	if err != nil {
+		return nil, err
+	}
+	for i := 0; i < res.RowNumber(); i++ {
+		name, _ := res.GetString(i, 0)
+		tp, _ := res.GetString(i, 1)
+		key, _ := res.GetString(i, 2)
+		columns = append(columns, cdcColumn{
+			name:     name,
+			unsigned: strings.Contains(strings.ToLower(tp), "unsigned"),
+			primary:  key == "PRI",
+		})
+	}
+	c.columns[table] = columns
+	return columns, nil
+}
+
+func loadPosition() (mysql.Position, error) {
+	var pos mysql.Position
+	file := config.Get().CDC.PositionFile
+	if file == "" {
+		return pos, nil
+	}
+	data, err := os.ReadFile(file)
+	if os.IsNotExist(err) {
+		return pos, nil
+	} else if err != nil {
+		return pos, err
+	}
+	_, err = fmt.Sscanf(string(data), "%s %d", &pos.Name, &pos.Pos)
+	return pos, err
+}
+
+func savePosition(pos mysql.Position) {
+	file := config.Get().CDC.PositionFile
+	if file == "" {
+		return
+	}
+	tmp := file + ".tmp"
+	if err := os.WriteFile(tmp, []byte(fmt.Sprintf("%s %d\n", pos.Name, pos.Pos)), 0o644); err != nil {
+		logrus.Errorf("cdc save position err: %v", err)
+		return
+	}
+	if err := os.Rename(tmp, file); err != nil {
+		logrus.Errorf("cdc save position err: %v", err)
+	}
+}
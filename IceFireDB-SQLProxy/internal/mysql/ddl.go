@@ -0,0 +1,89 @@
+package mysql
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strings"
+
+	"github.com/IceFireDB/IceFireDB/IceFireDB-SQLProxy/pkg/config"
+	"github.com/IceFireDB/IceFireDB/IceFireDB-SQLProxy/pkg/mysql/mysql"
+	"github.com/sirupsen/logrus"
+)
+
+// isDDL tells whether the statement changes the schema, which is logged as a
+// query in a row-based binlog
+func isDDL(sql string) bool {
+	prefix := strings.ToUpper(strings.TrimSpace(sql))
+	for _, v := range []string{"CREATE", "ALTER", "DROP", "RENAME", "TRUNCATE"} {
+		if strings.HasPrefix(prefix, v) {
+			return true
+		}
+	}
+	return false
+}
+
+var (
+	alterTableRe = regexp.MustCompile("(?is)^\\s*ALTER\\s+(?:ONLINE\\s+)?TABLE\\s+(`(?:[^`]|``)+`|\\w+)(?:\\.(`(?:[^`]|``)+`|\\w+))?\\s+(.+?)[\\s;]*$")
+	// The tables of gh-ost and pt-online-schema-change
+	oscTableRe = regexp.MustCompile("(?i)(?:^|[^\\w`])`?_\\w+_(?:gho|ghc|del|new|old)\\b")
+)
+
+// schemaChange is an ALTER TABLE run by the online schema change tool
+type schemaChange struct {
+	database string
+	table    string
+	alter    string
+}
+
+// newSchemaChange returns the schema change of an ALTER TABLE, nil when the
+// statement is not an ALTER TABLE or no tool is configured
+func newSchemaChange(db string, sql string) *schemaChange {
+	if config.Get().DDL.OSCCommand == "" {
+		return nil
+	}
+	m := alterTableRe.FindStringSubmatch(sql)
+	if m == nil {
+		return nil
+	}
+	sc := &schemaChange{database: db, table: unquoteName(m[1]), alter: m[3]}
+	if m[2] != "" {
+		sc.database, sc.table = unquoteName(m[1]), unquoteName(m[2])
+	}
+	return sc
+}
+
+// run runs the tool, which copies the table to the new schema while the
+// writes continue, instead of locking the table during the ALTER
+func (sc *schemaChange) run(ctx context.Context) error {
+	command := strings.NewReplacer(
+		"{{database}}", shellQuote(sc.database),
+		"{{table}}", shellQuote(sc.table),
+		"{{alter}}", shellQuote(sc.alter),
+	).Replace(config.Get().DDL.OSCCommand)
+	logrus.Infof("online schema change of %s.%s: %s", sc.database, sc.table, command)
+	out, err := exec.CommandContext(ctx, "sh", "-c", command).CombinedOutput()
+	if err != nil {
+		logrus.Errorf("online schema change of %s.%s err: %v, output: %s", sc.database, sc.table, err, out)
+		return mysql.NewError(mysql.ER_UNKNOWN_ERROR, fmt.Sprintf("online schema change of %s.%s: %v", sc.database, sc.table, err))
+	}
+	return nil
+}
+
+// isOSCTable tells whether a statement or a table is one of the temporary
+// tables of the online schema change tools, which are not replicated
+func isOSCTable(s string) bool {
+	return oscTableRe.MatchString(s)
+}
+
+func unquoteName(name string) string {
+	if strings.HasPrefix(name, "`") {
+		return strings.ReplaceAll(name[1:len(name)-1], "``", "`")
+	}
+	return name
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -0,0 +1,74 @@
+package mysql
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/IceFireDB/IceFireDB/IceFireDB-SQLProxy/pkg/config"
+)
+
+func TestOSCTable(t *testing.T) {
+	for s, osc := range map[string]bool{
+		"_users_gho": true,
+		"_users_ghc": true,
+		"_users_del": true,
+		"_users_new": true,
+		"_users_old": true,
+		"INSERT INTO `_users_gho` (id) VALUES (1)":    true,
+		"CREATE TABLE _users_new LIKE users":          true,
+		"RENAME TABLE users TO _users_old":            true,
+		"users":                                       false,
+		"users_gho":                                   false,
+		"_users_ghost":                                false,
+		"INSERT INTO users (note) VALUES ('a_b_new')": false,
+		"SELECT my_users_old FROM t":                  false,
+	} {
+		if got := isOSCTable(s); got != osc {
+			t.Errorf("%s: want %v, got %v", s, osc, got)
+		}
+	}
+}
+
+func TestSchemaChange(t *testing.T) {
+	old := config.Get().DDL.OSCCommand
+	t.Cleanup(func() { config.Get().DDL.OSCCommand = old })
+
+	config.Get().DDL.OSCCommand = ""
+	if sc := newSchemaChange("app", "ALTER TABLE t ADD c INT"); sc != nil {
+		t.Fatalf("schema change without a tool %+v", sc)
+	}
+
+	out := filepath.Join(t.TempDir(), "out")
+	config.Get().DDL.OSCCommand = "printf '%s|%s|%s' {{database}} {{table}} {{alter}} > " + out
+	for sql, want := range map[string]schemaChange{
+		"ALTER TABLE t ADD c INT":                           {"app", "t", "ADD c INT"},
+		"alter online table `my``t` add c int;":             {"app", "my`t", "add c int"},
+		"ALTER TABLE other.t ADD COLUMN note VARCHAR(8);  ": {"other", "t", "ADD COLUMN note VARCHAR(8)"},
+	} {
+		sc := newSchemaChange("app", sql)
+		if sc == nil || *sc != want {
+			t.Fatalf("%s: want %+v, got %+v", sql, want, sc)
+		}
+	}
+	for _, sql := range []string{"ALTER USER u IDENTIFIED BY 'p'", "INSERT INTO t VALUES (1)"} {
+		if sc := newSchemaChange("app", sql); sc != nil {
+			t.Fatalf("%s: schema change %+v", sql, sc)
+		}
+	}
+
+	// the values are quoted for the shell
+	sc := newSchemaChange("app", "ALTER TABLE t ADD c VARCHAR(8) DEFAULT 'it''s'")
+	if err := sc.run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(out)
+	if err != nil || string(data) != "app|t|ADD c VARCHAR(8) DEFAULT 'it''s'" {
+		t.Fatalf("tool run with %q, %v", data, err)
+	}
+	config.Get().DDL.OSCCommand = "exit 3"
+	if err := sc.run(context.Background()); err == nil {
+		t.Fatal("failed tool not reported")
+	}
+}
@@ -2,46 +2,160 @@ package mysql
 
 import (
 	"errors"
+	"strings"
+	"time"
 
 	"github.com/IceFireDB/IceFireDB/IceFireDB-SQLProxy/pkg/config"
 	"github.com/IceFireDB/IceFireDB/IceFireDB-SQLProxy/pkg/mysql/client"
 	"github.com/IceFireDB/IceFireDB/IceFireDB-SQLProxy/pkg/mysql/mysql"
 	"github.com/IceFireDB/IceFireDB/IceFireDB-SQLProxy/pkg/mysql/server"
+	"github.com/IceFireDB/IceFireDB/IceFireDB-SQLProxy/pkg/querycache"
+	"github.com/IceFireDB/IceFireDB/IceFireDB-SQLProxy/pkg/replication"
 )
 
 type Handle struct {
-	conn *client.Conn
+	conn  *client.Conn
+	proxy *mysqlProxy
+	// The writes of the open transaction, published on commit
+	txOps []replication.Op
+	// The tables written by the open transaction, whose cached results are
+	// invalidated on commit
+	txTables []string
 }
 
 func (h *Handle) CloseConn(c *server.Conn) error {
 	if c.IsInTransaction() || c.IsAutoCommit() {
-		return h.conn.Commit()
+		if err := h.conn.Commit(); err != nil {
+			return err
+		}
+		h.flush("admin")
+		h.invalidate("COMMIT")
 	}
 	return nil
 }
 
+// replicate records a write executed on the backend. The writes of a
+// transaction are published together once the backend leaves the transaction.
+// Nothing is recorded when the writes are captured from the binlog.
+func (h *Handle) replicate(op replication.Op, accessType string) {
+	if config.Get().CDC.Enable {
+		// the writes are captured from the binlog
+		return
+	}
+	prefix := strings.ToUpper(strings.TrimSpace(op.SQL))
+	switch {
+	case strings.HasPrefix(prefix, "BEGIN"), strings.HasPrefix(prefix, "START TRANSACTION"):
+		// BEGIN commits the open transaction
+		h.flush(accessType)
+	case strings.HasPrefix(prefix, "ROLLBACK") && !strings.HasPrefix(prefix, "ROLLBACK TO"):
+		h.txOps = nil
+	case strings.HasPrefix(prefix, "COMMIT"), strings.HasPrefix(prefix, "END TRANSACTION"):
+	default:
+		h.txOps = append(h.txOps, op)
+	}
+	if !h.conn.IsInTransaction() {
+		h.flush(accessType)
+	}
+}
+
+// flush publishes the writes of the committed transaction
+func (h *Handle) flush(accessType string) {
+	ops := h.txOps
+	h.txOps = nil
+	broadcast(ops, accessType)
+}
+
+// invalidate records the tables a statement wrote, and invalidates their
+// cached results once the backend leaves the transaction
+func (h *Handle) invalidate(query string) {
+	if h.proxy == nil || h.proxy.cache == nil {
+		return
+	}
+	h.txTables = append(h.txTables, querycache.WriteTables(h.conn.GetDB(), query)...)
+	if !h.conn.IsInTransaction() {
+		h.proxy.cache.Invalidate(h.txTables)
+		h.txTables = nil
+	}
+}
+
+// read executes a read outside of transactions, on a replica when one is
+// healthy
+func (h *Handle) read(query string) (*mysql.Result, error) {
+	if h.proxy.replicas != nil {
+		if res, ok, err := h.proxy.readReplica(h.conn.GetDB(), query); ok {
+			return res, err
+		}
+	}
+	return h.conn.Execute(query)
+}
+
 func (h *Handle) UseDB(c *server.Conn, dbName string) error {
 	return h.conn.UseDB(dbName)
 }
 
-func (h *Handle) HandleQuery(c *server.Conn, query string) (res *mysql.Result, err error) {
+func (h *Handle) HandleQuery(c *server.Conn, query string) (*mysql.Result, error) {
+	start := time.Now()
+	res, err := h.handleQuery(query)
+	h.audit(c, query, start, res, err)
+	return res, err
+}
+
+func (h *Handle) handleQuery(query string) (res *mysql.Result, err error) {
 	// Check if this is a readonly connection attempting a write
 	if h.conn.GetUser() == config.Get().Mysql.ReadonlyUser && isDML(query) {
 		return nil, errors.New("readonly user cannot execute write operations")
 	}
 
+	// Reads outside of transactions are served from the cache, or go to a
+	// replica when one is healthy
+	if h.proxy != nil && h.conn.IsAutoCommit() && !h.conn.IsInTransaction() && isReplicaRead(query) {
+		if h.proxy.cache != nil && isCacheableRead(query) {
+			return h.proxy.cachedRead(h.conn.GetDB(), query, false, nil, func() (*mysql.Result, error) {
+				return h.read(query)
+			})
+		}
+		return h.read(query)
+	}
+
+	if sc := newSchemaChange(h.conn.GetDB(), query); sc != nil {
+		return h.schemaChange(sc, query)
+	}
+
 	res, err = h.conn.Execute(query)
+	h.invalidate(query)
 	if err == nil && isDML(query) {
 		// Determine access type based on connection user
 		accessType := "admin"
 		if h.conn.GetUser() == config.Get().Mysql.ReadonlyUser {
 			accessType = "readonly"
 		}
-		broadcast(query, accessType)
+		h.replicate(replication.Op{SQL: query}, accessType)
 	}
 	return
 }
 
+// schemaChange runs an ALTER TABLE with the online schema change tool
+func (h *Handle) schemaChange(sc *schemaChange, query string) (*mysql.Result, error) {
+	// the DDL commits the open transaction
+	if h.conn.IsInTransaction() {
+		if err := h.conn.Commit(); err != nil {
+			return nil, err
+		}
+		h.flush("admin")
+	}
+	if err := sc.run(h.proxy.ctx); err != nil {
+		return nil, err
+	}
+	h.invalidate(query)
+	if config.Get().CDC.Enable {
+		// the tool writes no ALTER to the binlog
+		broadcast([]replication.Op{{SQL: query}}, "admin")
+	} else {
+		h.replicate(replication.Op{SQL: query}, "admin")
+	}
+	return &mysql.Result{}, nil
+}
+
 func (h *Handle) HandleFieldList(c *server.Conn, table string, fieldWildcard string) ([]*mysql.Field, error) {
 	return h.conn.FieldList(table, fieldWildcard)
 }
@@ -55,6 +169,13 @@ func (h *Handle) HandleStmtPrepare(c *server.Conn, query string) (int, int, inte
 }
 
 func (h *Handle) HandleStmtExecute(c *server.Conn, context interface{}, query string, args []interface{}) (*mysql.Result, error) {
+	start := time.Now()
+	res, err := h.handleStmtExecute(context, query, args)
+	h.audit(c, query, start, res, err)
+	return res, err
+}
+
+func (h *Handle) handleStmtExecute(context interface{}, query string, args []interface{}) (*mysql.Result, error) {
 	// Check if this is a readonly connection attempting a write
 	if h.conn.GetUser() == config.Get().Mysql.ReadonlyUser && isDML(query) {
 		return nil, errors.New("readonly user cannot execute write operations")
@@ -64,14 +185,24 @@ func (h *Handle) HandleStmtExecute(c *server.Conn, context interface{}, query st
 	if !ok {
 		return nil, errors.New("other error")
 	}
+	if h.proxy != nil && h.proxy.cache != nil && h.conn.IsAutoCommit() && !h.conn.IsInTransaction() && isCacheableRead(query) {
+		return h.proxy.cachedRead(h.conn.GetDB(), query, true, args, func() (*mysql.Result, error) {
+			return stmt.Execute(args...)
+		})
+	}
 	res, err := stmt.Execute(args...)
+	h.invalidate(query)
 	if err == nil && isDML(query) {
 		// Determine access type based on connection user
 		accessType := "admin"
 		if h.conn.GetUser() == config.Get().Mysql.ReadonlyUser {
 			accessType = "readonly"
 		}
-		broadcast(query, accessType)
+		values, err := replication.EncodeArgs(args)
+		if err != nil {
+			return res, err
+		}
+		h.replicate(replication.Op{SQL: query, Args: values}, accessType)
 	}
 	return res, err
 }
@@ -1,13 +1,18 @@
 package mysql
 
 import (
+	"errors"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/IceFireDB/IceFireDB/IceFireDB-SQLProxy/pkg/config"
 	"github.com/IceFireDB/IceFireDB/IceFireDB-SQLProxy/pkg/mysql/client"
+	"github.com/IceFireDB/IceFireDB/IceFireDB-SQLProxy/pkg/mysql/mysql"
 	"github.com/IceFireDB/IceFireDB/IceFireDB-SQLProxy/pkg/p2p"
+	"github.com/IceFireDB/IceFireDB/IceFireDB-SQLProxy/pkg/replication"
 	"github.com/IceFireDB/IceFireDB/IceFireDB-SQLProxy/utils"
+	"github.com/IceFireDB/IceFireDB/IceFireDB-SQLite/pkg/p2p/security"
 	"github.com/sirupsen/logrus"
 )
 
@@ -20,9 +25,30 @@ type p2pChannels struct {
 
 var (
 	p2pChans *p2pChannels
+	// The transactions written through this node
+	journal *replication.Journal
+	// The positions of the writers applied by this node
+	tracker *replication.Tracker
+	// Keeps the entries published in the order of the journal
+	broadcastLock sync.Mutex
 )
 
+// The interval of the head announcements of the journal
+const headInterval = 10 * time.Second
+
+// The table of the backend recording the last transaction applied per writer
+const gtidTable = "icefiredb_gtid_executed"
+
 func initP2P(m *mysqlProxy) {
+	secConf := config.Get().P2P.Security
+	p2p.DefaultSecurityConfig = security.Config{
+		Transports: secConf.Transports,
+		KeyTypes:   secConf.KeyTypes,
+		FIPS:       secConf.FIPS,
+	}
+	if err := p2p.DefaultSecurityConfig.Validate(); err != nil {
+		panic(err)
+	}
 	p2pChans = &p2pChannels{}
 	
 	// Initialize admin P2P
@@ -51,6 +77,14 @@ func initP2P(m *mysqlProxy) {
 		panic(err)
 	}
 
+	if err := initGTID(m); err != nil {
+		panic(err)
+	}
+	journal = replication.NewJournal(p2pChans.adminHost.Host.ID().String(), config.Get().P2P.JournalSize)
+	tracker = replication.NewTracker(journal.Source(), func(source string) (uint64, error) {
+		return loadGTID(m, source)
+	}, config.Get().P2P.MaxPending)
+
 	logrus.Info("Successfully initialized both admin and readonly P2P channels")
 	asyncSQL(m)
 }
@@ -71,6 +105,8 @@ func asyncSQL(m *mysqlProxy) {
 	utils.GoWithRecover(func() {
 		adminTxConn := make(map[string]*client.Conn)
 		readonlyTxConn := make(map[string]*client.Conn)
+		headTicker := time.NewTicker(headInterval)
+		defer headTicker.Stop()
 
 		for {
 			select {
@@ -89,7 +125,7 @@ func asyncSQL(m *mysqlProxy) {
 					SenderID: msg.GetFrom(),
 					Content:  string(msg.GetData()),
 				}
-				handleInboundSQL(m, s, adminTxConn, "admin")
+				handleAdminMessage(m, s, adminTxConn)
 				
 			// Handle readonly channel messages
 			case msg := <-p2pChans.readonlyPubSub.Inbound:
@@ -98,6 +134,11 @@ func asyncSQL(m *mysqlProxy) {
 					Content:  string(msg.GetData()),
 				}
 				handleInboundSQL(m, s, readonlyTxConn, "readonly")
+
+			case <-headTicker.C:
+				if head := journal.Head(); head.Seq > 0 {
+					publish(replication.EncodeHead(head))
+				}
 			}
 		}
 	}, func(r interface{}) {
@@ -124,7 +165,14 @@ func handleInboundSQL(m *mysqlProxy, s *p2p.Message, txConn map[string]*client.C
 	}
 
 	// Execute query
-	_, err = conn.Execute(s.Content)
+	msg, err := replication.Decode(s.Content)
+	if err == nil && msg.Op == nil {
+		err = errors.New("not a statement")
+	}
+	if err == nil {
+		_, err = conn.Execute(msg.Op.SQL, msg.Op.Arguments()...)
+		m.invalidate(conn.GetDB(), []replication.Op{*msg.Op})
+	}
 	if err != nil {
 		logrus.Infof("Inbound %s sql: %s err: %v", accessType, s.Content, err)
 		return
@@ -146,6 +194,162 @@ func handleInboundSQL(m *mysqlProxy, s *p2p.Message, txConn map[string]*client.C
 	}
 }
 
+// handleAdminMessage handles a message of the admin channel: the entries
+// are applied in the order of their writer, and the resend requests for the
+// entries of this node are answered from the journal
+func handleAdminMessage(m *mysqlProxy, s *p2p.Message, txConn map[string]*client.Conn) {
+	msg, err := replication.Decode(s.Content)
+	if err != nil {
+		logrus.Infof("Inbound admin message: %s err: %v", s.Content, err)
+		return
+	}
+	switch {
+	case msg.Op != nil:
+		// a statement of a previous version
+		handleInboundSQL(m, s, txConn, "admin")
+	case msg.Resend != nil:
+		if msg.Resend.Source != journal.Source() {
+			return
+		}
+		for _, e := range journal.Range(msg.Resend.From, msg.Resend.To) {
+			publish(replication.EncodeEntry(e))
+		}
+	case msg.Head != nil:
+		if msg.Head.Source != s.SenderID || msg.Head.Source == journal.Source() {
+			return
+		}
+		resend, err := tracker.Head(*msg.Head)
+		if err != nil {
+			logrus.Errorf("Inbound admin head of %s err: %v", msg.Head.Source, err)
+			return
+		}
+		if resend != nil {
+			publish(replication.EncodeResend(*resend))
+		}
+	case msg.Entry != nil:
+		if msg.Entry.Source != s.SenderID || msg.Entry.Source == journal.Source() {
+			return
+		}
+		ready, resend, err := tracker.Receive(*msg.Entry)
+		if err != nil {
+			logrus.Errorf("Inbound admin entry %s:%d err: %v", msg.Entry.Source, msg.Entry.Seq, err)
+			return
+		}
+		if resend != nil {
+			logrus.Infof("Inbound admin entry %s:%d after a gap, request %d..%d", msg.Entry.Source, msg.Entry.Seq, resend.From, resend.To)
+			publish(replication.EncodeResend(*resend))
+		}
+		for i, e := range ready {
+			if err := applyEntry(m, e); err != nil {
+				logrus.Errorf("Inbound admin entry %s:%d err: %v", e.Source, e.Seq, err)
+				// the entries left are requested again
+				for _, e := range ready[i:] {
+					tracker.Forget(e.Source)
+				}
+				return
+			}
+			logrus.Infof("Inbound admin entry %s:%d, %d statements", e.Source, e.Seq, len(e.Ops))
+		}
+	}
+}
+
+// applyEntry executes the statements of an entry in a transaction that also
+// records the entry as applied. An entry refused by the backend is recorded
+// and skipped, like the statements of the previous versions.
+func applyEntry(m *mysqlProxy, e replication.Entry) (err error) {
+	conn, err := m.popAdminConn()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		m.pushAdminConn(conn, err)
+	}()
+
+	if err = conn.Begin(); err != nil {
+		return err
+	}
+	for _, op := range e.Ops {
+		if sc := newSchemaChange(conn.GetDB(), op.SQL); sc != nil {
+			// the DDL commits the open transaction
+			if conn.IsInTransaction() {
+				if err = conn.Commit(); err != nil {
+					break
+				}
+			}
+			if err = sc.run(m.ctx); err != nil {
+				break
+			}
+			continue
+		}
+		// the DDL applied is not captured again from the binlog
+		echo := config.Get().CDC.Enable && isDDL(op.SQL)
+		if echo {
+			expectEcho(conn.GetConnectionID(), 1)
+		}
+		if _, err = conn.Execute(op.SQL, op.Arguments()...); err != nil {
+			if echo {
+				expectEcho(conn.GetConnectionID(), -1)
+			}
+			break
+		}
+	}
+	if err != nil {
+		var myErr *mysql.MyError
+		if !errors.As(err, &myErr) {
+			return err
+		}
+		logrus.Infof("Inbound admin entry %s:%d sql err: %v", e.Source, e.Seq, err)
+		if err = conn.Rollback(); err != nil {
+			return err
+		}
+	}
+	if _, err = conn.Execute("INSERT INTO "+gtidTable+" (source, seq) VALUES (?, ?) ON DUPLICATE KEY UPDATE seq = VALUES(seq)", e.Source, e.Seq); err != nil {
+		return err
+	}
+	if conn.IsInTransaction() {
+		err = conn.Commit()
+	}
+	m.invalidate(conn.GetDB(), e.Ops)
+	return err
+}
+
+// initGTID creates the table recording the applied entries
+func initGTID(m *mysqlProxy) (err error) {
+	conn, err := m.popAdminConn()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		m.pushAdminConn(conn, err)
+	}()
+	_, err = conn.Execute("CREATE TABLE IF NOT EXISTS " + gtidTable + " (source VARCHAR(128) NOT NULL PRIMARY KEY, seq BIGINT UNSIGNED NOT NULL)")
+	return err
+}
+
+// loadGTID returns the last entry of the writer applied by this node
+func loadGTID(m *mysqlProxy, source string) (seq uint64, err error) {
+	conn, err := m.popAdminConn()
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		m.pushAdminConn(conn, err)
+	}()
+	res, err := conn.Execute("SELECT seq FROM "+gtidTable+" WHERE source = ?", source)
+	if err != nil || res.RowNumber() == 0 {
+		return 0, err
+	}
+	return res.GetUint(0, 0)
+}
+
+func publish(msg string, err error) {
+	if err != nil {
+		logrus.Errorf("Outbound admin encode err: %v", err)
+		return
+	}
+	p2pChans.adminPubSub.Outbound <- msg
+}
+
 var DMLSQL = []string{
 	"BEGIN",
 	"BEGIN TRANSACTION",
@@ -156,8 +360,10 @@ var DMLSQL = []string{
 	"UPDATE",
 	"DELETE",
 	"CREATE",
-	"ALERT",
+	"ALTER",
 	"DROP",
+	"RENAME",
+	"TRUNCATE",
 }
 
 func isDML(sql string) bool {
@@ -174,16 +380,31 @@ func isDML(sql string) bool {
 	return false
 }
 
-func broadcast(sql string, accessType string) {
-	if !isDML(sql) {
+// broadcast publishes a transaction written through this node
+func broadcast(ops []replication.Op, accessType string) {
+	if p2pChans == nil || len(ops) == 0 {
 		return
 	}
-	
+
 	if accessType == "admin" {
-		p2pChans.adminPubSub.Outbound <- sql
-		logrus.Infof("Outbound admin sql: %s", sql)
+		broadcastLock.Lock()
+		defer broadcastLock.Unlock()
+		// the DDL is applied after the writes this node applied before it
+		var after map[string]uint64
+		for _, op := range ops {
+			if isDDL(op.SQL) {
+				after = tracker.Positions()
+				break
+			}
+		}
+		e := journal.Append(ops, after)
+		msg, err := replication.EncodeEntry(e)
+		publish(msg, err)
+		if err == nil {
+			logrus.Infof("Outbound admin entry: %s", msg)
+		}
 	} else {
 		// Readonly nodes don't broadcast writes
-		logrus.Infof("Readonly node attempted to broadcast write: %s", sql)
+		logrus.Infof("Readonly node attempted to broadcast %d writes", len(ops))
 	}
 }
@@ -7,10 +7,12 @@ import (
 	"runtime"
 	"sync"
 
+	"github.com/IceFireDB/IceFireDB/IceFireDB-SQLProxy/pkg/audit"
 	"github.com/IceFireDB/IceFireDB/IceFireDB-SQLProxy/pkg/config"
 	"github.com/IceFireDB/IceFireDB/IceFireDB-SQLProxy/pkg/mysql/client"
 	"github.com/IceFireDB/IceFireDB/IceFireDB-SQLProxy/pkg/mysql/mysql"
 	"github.com/IceFireDB/IceFireDB/IceFireDB-SQLProxy/pkg/mysql/server"
+	"github.com/IceFireDB/IceFireDB/IceFireDB-SQLProxy/pkg/querycache"
 
 	"github.com/sirupsen/logrus"
 	"go.uber.org/atomic"
@@ -28,6 +30,9 @@ type mysqlProxy struct {
 	closed       atomic.Value
 	adminPool    *client.Pool
 	readonlyPool *client.Pool
+	replicas     *replicaSet
+	cache        *querycache.Cache
+	audit        *audit.Logger
 }
 
 func NewMySQLProxy(ctx context.Context, server *server.Server, credential server.CredentialProvider) *mysqlProxy {
@@ -76,13 +81,13 @@ func (m *mysqlProxy) onConn(c net.Conn) {
 	// Default to admin connection for direct connections
 	clientConn, err := m.popAdminConn()
 	if err != nil {
-		logrus.Errorf("get remote conn err:", err)
+		logrus.Errorf("get remote conn err: %v", err)
 		return
 	}
 	defer func() {
 		m.pushAdminConn(clientConn, err)
 	}()
-	h := &Handle{conn: clientConn}
+	h := &Handle{conn: clientConn, proxy: m}
 
 	conn, err := server.NewClientConn(c, m.server, m.credential, h)
 	if err != nil {
@@ -124,10 +129,14 @@ func (m *mysqlProxy) popReadonlyConn() (*client.Conn, error) {
 }
 
 func (m *mysqlProxy) popConn(pool *client.Pool) (*client.Conn, error) {
+	return m.popConnContext(m.ctx, pool)
+}
+
+func (m *mysqlProxy) popConnContext(ctx context.Context, pool *client.Pool) (*client.Conn, error) {
 	var mysqlConn *client.Conn
 	var err error
 	for i := 0; i < GetConnRetry; i++ {
-		mysqlConn, err = pool.GetConn(m.ctx)
+		mysqlConn, err = pool.GetConn(ctx)
 		if err != nil {
 			continue
 		}
@@ -157,7 +166,7 @@ func (m *mysqlProxy) popConn(pool *client.Pool) (*client.Conn, error) {
 		}
 		break
 	}
-	return mysqlConn, nil
+	return mysqlConn, err
 }
 
 func (m *mysqlProxy) pushAdminConn(mysqlConn *client.Conn, err error) {
@@ -31,6 +31,9 @@ func Run(ctx context.Context) (err error) {
 		if <-ctx.Done(); true {
 			_ = ln.Close()
 			ms.closed.Store(true)
+			if ms.audit != nil {
+				_ = ms.audit.Close()
+			}
 		}
 	}, nil)
 
@@ -39,6 +42,9 @@ func Run(ctx context.Context) (err error) {
 	// p2p
 	if config.Get().P2P.Enable {
 		initP2P(ms)
+		if config.Get().CDC.Enable {
+			startCDC(ms)
+		}
 	}
 	for {
 		conn, err := ln.Accept()
@@ -100,5 +106,22 @@ func (m *mysqlProxy) initClientPool() error {
 		m.readonlyPool = readonlyPool
 	}
 
+	replicas, err := newReplicaSet(mc)
+	if err != nil {
+		return err
+	}
+	m.replicas = replicas
+	if len(replicas.replicas) > 0 {
+		m.healthCheck()
+	}
+
+	if m.cache, err = newQueryCache(); err != nil {
+		return fmt.Errorf("failed to create query cache: %v", err)
+	}
+
+	if m.audit, err = newAuditLogger(); err != nil {
+		return fmt.Errorf("failed to create audit log: %v", err)
+	}
+
 	return nil
 }
@@ -0,0 +1,174 @@
+// Package audit logs the statements run through the proxy by their
+// fingerprint, with the user and the source of the connection, to a file or
+// an HTTP endpoint
+package audit
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"go.uber.org/atomic"
+)
+
+// Record represents a statement of the audit log
+type Record struct {
+	Time time.Time `json:"time"`
+	// Client user and address
+	User   string `json:"user"`
+	Source string `json:"source"`
+	DB     string `json:"db,omitempty"`
+	// Normalized statement without its values, and its digest
+	Fingerprint string `json:"fingerprint"`
+	Digest      string `json:"digest"`
+	Write       bool   `json:"write,omitempty"`
+	// Rows changed by a write, or returned by a read
+	AffectedRows uint64 `json:"affected_rows"`
+	ReturnedRows int    `json:"returned_rows,omitempty"`
+	// Microseconds the statement took
+	Duration int64  `json:"duration_us"`
+	Error    string `json:"error,omitempty"`
+}
+
+// NewRecord returns the record of a statement, fingerprinted
+func NewRecord(user, source, db, query string, write bool, took time.Duration) Record {
+	fp := Fingerprint(query)
+	return Record{
+		Time:        time.Now(),
+		User:        user,
+		Source:      source,
+		DB:          db,
+		Fingerprint: fp,
+		Digest:      Digest(fp),
+		Write:       write,
+		Duration:    took.Microseconds(),
+	}
+}
+
+// Sink stores the batches of records
+type Sink interface {
+	Write(records []Record) error
+	Close() error
+}
+
+// Options configures the sampling and the batching of a logger
+type Options struct {
+	// Fraction of the statements logged, the failed ones are always logged
+	SampleRate float64
+	// Only log the writes, and the failed reads
+	WritesOnly bool
+	// Records sent to the sink at once
+	BatchSize int
+	// Longest time a record waits for its batch
+	FlushInterval time.Duration
+	// Records waiting for the sink, the new ones are dropped when it is full
+	BufferSize int
+}
+
+// Logger sends the sampled records to its sink in the background, so the
+// statements never wait for the sink
+type Logger struct {
+	sink    Sink
+	opts    Options
+	records chan Record
+	dropped atomic.Uint64
+	done    chan struct{}
+	// closed once Close has closed records, guarded by mu so that Log
+	// never sends on it then
+	mu     sync.RWMutex
+	closed bool
+}
+
+// NewLogger starts a logger sending its records to sink
+func NewLogger(sink Sink, opts Options) *Logger {
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 1
+	}
+	if opts.FlushInterval <= 0 {
+		opts.FlushInterval = time.Second
+	}
+	l := &Logger{
+		sink:    sink,
+		opts:    opts,
+		records: make(chan Record, opts.BufferSize),
+		done:    make(chan struct{}),
+	}
+	go l.run()
+	return l
+}
+
+// Sampled tells whether a statement is logged
+func (l *Logger) Sampled(write, failed bool) bool {
+	if failed {
+		return true
+	}
+	if l.opts.WritesOnly && !write {
+		return false
+	}
+	return l.opts.SampleRate >= 1 || rand.Float64() < l.opts.SampleRate
+}
+
+// Log queues a record, it is dropped when the buffer is full or the logger
+// is closed
+func (l *Logger) Log(r Record) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if l.closed {
+		l.dropped.Inc()
+		return
+	}
+	select {
+	case l.records <- r:
+	default:
+		l.dropped.Inc()
+	}
+}
+
+// Dropped returns the number of records dropped on a full buffer
+func (l *Logger) Dropped() uint64 {
+	return l.dropped.Load()
+}
+
+// Close flushes the queued records and closes the sink
+func (l *Logger) Close() error {
+	l.mu.Lock()
+	if !l.closed {
+		l.closed = true
+		close(l.records)
+	}
+	l.mu.Unlock()
+	<-l.done
+	return l.sink.Close()
+}
+
+func (l *Logger) run() {
+	defer close(l.done)
+	ticker := time.NewTicker(l.opts.FlushInterval)
+	defer ticker.Stop()
+	batch := make([]Record, 0, l.opts.BatchSize)
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := l.sink.Write(batch); err != nil {
+			logrus.Warnf("audit: %d records lost: %v", len(batch), err)
+		}
+		batch = batch[:0]
+	}
+	for {
+		select {
+		case r, ok := <-l.records:
+			if !ok {
+				flush()
+				return
+			}
+			batch = append(batch, r)
+			if len(batch) >= l.opts.BatchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
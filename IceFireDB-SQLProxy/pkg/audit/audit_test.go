@@ -0,0 +1,159 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestFingerprint(t *testing.T) {
+	for sql, want := range map[string]string{
+		"SELECT * FROM t WHERE id = 1":                               "select * from t where id = ?",
+		"select *  from t\n where id=42 -- trailing":                 "select * from t where id = ?",
+		"SELECT a FROM `T` WHERE name = 'x''y' AND v IN (1, 2, 3)":   "select a from `T` where name = ? and v in(?+)",
+		"INSERT INTO t (a, b) VALUES (1, 'a'), (2, 'b'), (3, \"c\")": "insert into t(a, b) values(?+)",
+		"INSERT INTO t (a, b) VALUES (1, NOW()), (2, NOW())":         "insert into t(a, b) values(?, now())",
+		"/* app */ UPDATE db.t SET v = -1.5e+3 WHERE k >= 0x1F":      "update db.t set v = - ? where k >= ?",
+		"SELECT COUNT(*) FROM t1 WHERE t1.c <> 'a\\'b'":              "select count(*) from t1 where t1.c <> ?",
+	} {
+		if got := Fingerprint(sql); got != want {
+			t.Errorf("%s: got %q, want %q", sql, got, want)
+		}
+	}
+	if Digest(Fingerprint("SELECT 1")) != Digest(Fingerprint("select 2")) {
+		t.Error("statements differing by their values must share the digest")
+	}
+}
+
+type memorySink struct {
+	batches chan []Record
+}
+
+func (s *memorySink) Write(records []Record) error {
+	s.batches <- append([]Record(nil), records...)
+	return nil
+}
+
+func (s *memorySink) Close() error {
+	return nil
+}
+
+func TestLogger(t *testing.T) {
+	sink := &memorySink{batches: make(chan []Record, 10)}
+	l := NewLogger(sink, Options{SampleRate: 1, BatchSize: 2, FlushInterval: time.Hour, BufferSize: 10})
+	l.Log(Record{Digest: "a"})
+	l.Log(Record{Digest: "b"})
+	l.Log(Record{Digest: "c"})
+	if batch := <-sink.batches; len(batch) != 2 || batch[0].Digest != "a" {
+		t.Fatalf("first batch %v", batch)
+	}
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if batch := <-sink.batches; len(batch) != 1 || batch[0].Digest != "c" {
+		t.Fatalf("the queued records must be flushed on close, got %v", batch)
+	}
+}
+
+func TestLoggerCloseWhileLogging(t *testing.T) {
+	sink := &memorySink{batches: make(chan []Record, 1000)}
+	l := NewLogger(sink, Options{SampleRate: 1, BatchSize: 1, FlushInterval: time.Hour, BufferSize: 10})
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				l.Log(Record{Digest: "a"})
+			}
+		}()
+	}
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+	wg.Wait()
+	l.Log(Record{Digest: "b"})
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if l.Dropped() == 0 {
+		t.Fatal("a record logged after close must be dropped")
+	}
+}
+
+func TestSampled(t *testing.T) {
+	l := &Logger{opts: Options{SampleRate: 0, WritesOnly: true}}
+	if !l.Sampled(false, true) {
+		t.Error("failed statements must always be logged")
+	}
+	if l.Sampled(true, false) {
+		t.Error("nothing must be sampled at rate 0")
+	}
+	l.opts.SampleRate = 1
+	if !l.Sampled(true, false) || l.Sampled(false, false) {
+		t.Error("only the writes must be logged")
+	}
+}
+
+func TestFileSink(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	s, err := NewFileSink(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := NewRecord("root", "127.0.0.1:5000", "db", "DELETE FROM t WHERE id = 3", true, time.Millisecond)
+	rec.AffectedRows = 1
+	if err = s.Write([]Record{rec, rec}); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	lines := 0
+	for sc := bufio.NewScanner(f); sc.Scan(); lines++ {
+		var got Record
+		if err := json.Unmarshal(sc.Bytes(), &got); err != nil {
+			t.Fatal(err)
+		}
+		if got.Fingerprint != "delete from t where id = ?" || got.User != "root" || got.AffectedRows != 1 || got.Duration != 1000 {
+			t.Errorf("record %+v", got)
+		}
+	}
+	if lines != 2 {
+		t.Errorf("%d lines, want 2", lines)
+	}
+}
+
+func TestHTTPSink(t *testing.T) {
+	got := make(chan []Record, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var records []Record
+		if err := json.NewDecoder(r.Body).Decode(&records); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		got <- records
+	}))
+	defer srv.Close()
+	s := NewHTTPSink(srv.URL, time.Second)
+	if err := s.Write([]Record{{Digest: "a"}, {Digest: "b"}}); err != nil {
+		t.Fatal(err)
+	}
+	if records := <-got; len(records) != 2 {
+		t.Errorf("posted %v", records)
+	}
+	if err := NewHTTPSink(srv.URL+"/missing\x00", time.Second).Write(nil); err == nil {
+		t.Error("want an error on an invalid url")
+	}
+}
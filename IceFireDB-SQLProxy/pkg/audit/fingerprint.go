@@ -0,0 +1,212 @@
+package audit
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+)
+
+// The operators of two characters
+var operators = map[string]bool{
+	"<=": true, ">=": true, "<>": true, "!=": true, ":=": true,
+	"||": true, "&&": true, "<<": true, ">>": true,
+}
+
+func isWordByte(c byte) bool {
+	return c == '_' || c == '$' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= 0x80
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// Fingerprint normalizes a statement so the statements differing by their
+// values share it: the strings and numbers are replaced by ?, the lists of
+// values by (?+) and the repeated rows of an INSERT by a single one, the
+// comments are removed and the keywords are lower cased. The quoted names
+// are kept.
+func Fingerprint(sql string) string {
+	return join(collapse(tokenize(sql)))
+}
+
+// Digest returns a short hash of a fingerprint, to group the statements
+func Digest(fingerprint string) string {
+	sum := sha256.Sum256([]byte(fingerprint))
+	return hex.EncodeToString(sum[:8])
+}
+
+// tokenize splits a statement into lower case words, quoted names, values
+// replaced by ? and punctuation
+func tokenize(sql string) []string {
+	var tokens []string
+	for i := 0; i < len(sql); {
+		c := sql[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '#' || c == '-' && strings.HasPrefix(sql[i:], "-- "):
+			for i < len(sql) && sql[i] != '\n' {
+				i++
+			}
+		case c == '/' && strings.HasPrefix(sql[i:], "/*"):
+			end := strings.Index(sql[i+2:], "*/")
+			if end < 0 {
+				return tokens
+			}
+			i += end + 4
+		case c == '\'' || c == '"' || c == '`':
+			j := i + 1
+			for j < len(sql) {
+				if sql[j] == '\\' && c != '`' {
+					j += 2
+					continue
+				}
+				if sql[j] == c {
+					if j+1 < len(sql) && sql[j+1] == c {
+						j += 2
+						continue
+					}
+					break
+				}
+				j++
+			}
+			if j > len(sql) {
+				j = len(sql)
+			}
+			if c == '`' {
+				tokens = append(tokens, sql[i:min(j+1, len(sql))])
+			} else {
+				tokens = append(tokens, "?")
+			}
+			i = j + 1
+		case isDigit(c) || c == '.' && i+1 < len(sql) && isDigit(sql[i+1]):
+			j := i + 1
+			for j < len(sql) && (isWordByte(sql[j]) || sql[j] == '.' ||
+				(sql[j] == '+' || sql[j] == '-') && (sql[j-1] == 'e' || sql[j-1] == 'E')) {
+				j++
+			}
+			tokens = append(tokens, "?")
+			i = j
+		case isWordByte(c):
+			j := i + 1
+			for j < len(sql) && isWordByte(sql[j]) {
+				j++
+			}
+			tokens = append(tokens, strings.ToLower(sql[i:j]))
+			i = j
+		default:
+			// operators of two characters are kept together
+			if i+1 < len(sql) && operators[sql[i:i+2]] {
+				tokens = append(tokens, sql[i:i+2])
+				i += 2
+				continue
+			}
+			tokens = append(tokens, sql[i:i+1])
+			i++
+		}
+	}
+	return tokens
+}
+
+// group returns the end of the parenthesized group starting at i, -1 when
+// tokens[i] does not open one
+func group(tokens []string, i int) int {
+	if i >= len(tokens) || tokens[i] != "(" {
+		return -1
+	}
+	depth := 0
+	for j := i; j < len(tokens); j++ {
+		switch tokens[j] {
+		case "(":
+			depth++
+		case ")":
+			depth--
+			if depth == 0 {
+				return j
+			}
+		}
+	}
+	return -1
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// collapse replaces the lists of values by (?+) and drops the groups
+// repeating the group before them
+func collapse(tokens []string) []string {
+	out := make([]string, 0, len(tokens))
+	for i := 0; i < len(tokens); i++ {
+		if end := group(tokens, i); end > i+1 && isValueList(tokens[i+1:end]) {
+			out = append(out, "(", "?+", ")")
+			i = end
+			continue
+		}
+		if tokens[i] == "," && len(out) > 0 && out[len(out)-1] == ")" {
+			if end := group(tokens, i+1); end > 0 {
+				prev := previousGroup(out)
+				if prev >= 0 && equal(out[prev:], collapse(tokens[i+1:end+1])) {
+					i = end
+					continue
+				}
+			}
+		}
+		out = append(out, tokens[i])
+	}
+	return out
+}
+
+// isValueList tells whether the tokens are ? separated by commas
+func isValueList(tokens []string) bool {
+	if len(tokens)%2 == 0 {
+		return false
+	}
+	for i, t := range tokens {
+		if i%2 == 0 && t != "?" || i%2 == 1 && t != "," {
+			return false
+		}
+	}
+	return true
+}
+
+// previousGroup returns the start of the group closed by the last token
+func previousGroup(tokens []string) int {
+	depth := 0
+	for i := len(tokens) - 1; i >= 0; i-- {
+		switch tokens[i] {
+		case ")":
+			depth++
+		case "(":
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// join joins the tokens with single spaces, except around the dots and the
+// parentheses and before the commas
+func join(tokens []string) string {
+	var b strings.Builder
+	for i, t := range tokens {
+		if i > 0 {
+			prev := tokens[i-1]
+			if t != "," && t != ")" && t != "(" && t != "." && prev != "(" && prev != "." {
+				b.WriteByte(' ')
+			}
+		}
+		b.WriteString(t)
+	}
+	return b.String()
+}
@@ -0,0 +1,74 @@
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+)
+
+// FileSink appends the records to a file, one JSON object per line
+type FileSink struct {
+	f *os.File
+}
+
+// NewFileSink opens the file, created when missing
+func NewFileSink(path string) (*FileSink, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	return &FileSink{f: f}, nil
+}
+
+func (s *FileSink) Write(records []Record) error {
+	w := bufio.NewWriter(s.f)
+	enc := json.NewEncoder(w)
+	for _, r := range records {
+		if err := enc.Encode(r); err != nil {
+			return err
+		}
+	}
+	return w.Flush()
+}
+
+func (s *FileSink) Close() error {
+	return s.f.Close()
+}
+
+// HTTPSink posts the batches of records to an endpoint as a JSON array
+type HTTPSink struct {
+	url    string
+	client *http.Client
+}
+
+// NewHTTPSink returns a sink posting to url, giving up on a request after
+// timeout
+func NewHTTPSink(url string, timeout time.Duration) *HTTPSink {
+	return &HTTPSink{url: url, client: &http.Client{Timeout: timeout}}
+}
+
+func (s *HTTPSink) Write(records []Record) error {
+	body, err := json.Marshal(records)
+	if err != nil {
+		return err
+	}
+	resp, err := s.client.Post(s.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("audit sink %s: %s", s.url, resp.Status)
+	}
+	return nil
+}
+
+func (s *HTTPSink) Close() error {
+	return nil
+}
@@ -3,6 +3,7 @@ package config
 import (
 	"net"
 
+	"github.com/go-viper/mapstructure/v2"
 	"github.com/spf13/viper"
 )
 
@@ -12,6 +13,10 @@ type Config struct {
 	Mysql    MysqlS     `json:"mysql"`
 	UserList []UserInfo `json:"userlist"`
 	P2P      P2PS       `json:"p2p"`
+	CDC      CDCS       `json:"cdc"`
+	DDL      DDLS       `json:"ddl"`
+	Cache    CacheS     `json:"cache"`
+	Audit    AuditS     `json:"audit"`
 }
 
 type ServerC struct {
@@ -27,6 +32,23 @@ type MysqlS struct {
 	MaxAlive    int    `json:"maxAlive"`
 	MaxIdle     int    `json:"maxIdle"`
 	ReadonlyUser string `json:"readonlyUser"`
+	// Backends receiving the SELECTs outside of transactions
+	Replicas []ReplicaS `json:"replicas"`
+	// Seconds between the health checks of the replicas
+	HealthCheckInterval int `json:"healthCheckInterval"`
+	// Seconds a replica may lag behind its source, no limit when 0
+	MaxReplicaLag int `json:"maxReplicaLag"`
+	// Milliseconds to wait for a replica connection before reading from the primary
+	AcquireTimeout int `json:"acquireTimeout"`
+}
+
+type ReplicaS struct {
+	Addr string `json:"addr"`
+	// The user and password of the primary when empty
+	User     string `json:"user"`
+	Password string `json:"password"`
+	// The maxAlive of the primary when 0
+	MaxAlive int `json:"maxAlive"`
 }
 
 type UserInfo struct {
@@ -48,6 +70,76 @@ type P2PS struct {
 	NodeHostPort        int    `json:"node_host_port"`
 	AdminTopic          string `json:"admin_topic"`
 	ReadonlyTopic       string `json:"readonly_topic"`
+	// Number of recent transactions kept to answer the resend requests of the peers
+	JournalSize int `json:"journal_size"`
+	// Number of transactions of a writer held behind a gap before the gap is skipped
+	MaxPending int `json:"max_pending"`
+	// Security transports and peer key types of the p2p hosts
+	Security SecurityC `json:"security"`
+}
+
+type SecurityC struct {
+	// Security transports in order of preference: tls, noise
+	Transports []string `json:"transports"`
+	// Identity key types of the peers, empty accepts every type
+	KeyTypes []string `json:"key_types"`
+	// Only use FIPS approved primitives, requires GODEBUG=fips140=on
+	FIPS bool `json:"fips"`
+}
+
+// CDCS configures the capture of the writes from the binlog of the mysql backend
+type CDCS struct {
+	Enable bool `json:"enable"`
+	// Replica server id of the proxy, unique among the replicas of the backend
+	ServerID uint32 `json:"server_id"`
+	// Replication user, the mysql user when empty
+	User     string `json:"user"`
+	Password string `json:"password"`
+	// File keeping the binlog position, the capture starts at the current
+	// position of the backend when empty
+	PositionFile string `json:"position_file"`
+}
+
+// DDLS configures the replication of the schema changes
+type DDLS struct {
+	// Command running the ALTER TABLE statements instead of the backend, like
+	// gh-ost or pt-online-schema-change, with the placeholders {{database}},
+	// {{table}} and {{alter}} replaced by shell-quoted values
+	OSCCommand string `json:"osc_command"`
+}
+
+// CacheS configures the cache of the SELECT results
+type CacheS struct {
+	Enable bool `json:"enable"`
+	// Milliseconds a result is served from the cache
+	TTL int `json:"ttl"`
+	// Number of results kept, the least recently used are evicted
+	MaxEntries int `json:"max_entries"`
+	// Results with more rows are not cached
+	MaxRows int `json:"max_rows"`
+}
+
+// AuditS configures the audit log of the statements
+type AuditS struct {
+	Enable bool `json:"enable"`
+	// Sink of the records, file or http
+	Sink string `json:"sink"`
+	// File the records are appended to, as JSON lines
+	File string `json:"file"`
+	// Endpoint the batches of records are posted to, as JSON arrays
+	URL string `json:"url"`
+	// Milliseconds to wait for the endpoint
+	Timeout int `json:"timeout"`
+	// Fraction of the statements logged, the failed ones are always logged
+	SampleRate float64 `json:"sample_rate"`
+	// Only log the writes, and the failed reads
+	WritesOnly bool `json:"writes_only"`
+	// Records sent to the sink at once
+	BatchSize int `json:"batch_size"`
+	// Milliseconds a record waits for its batch
+	FlushInterval int `json:"flush_interval"`
+	// Records waiting for the sink, the new ones are dropped when it is full
+	BufferSize int `json:"buffer_size"`
 }
 
 func init() {
@@ -62,7 +154,9 @@ func InitConfig(path string) {
 		panic(err)
 	}
 
-	err := viper.Unmarshal(defaultConfig)
+	err := viper.Unmarshal(defaultConfig, func(c *mapstructure.DecoderConfig) {
+		c.TagName = "json"
+	})
 	if err != nil {
 		panic(err)
 	}
@@ -71,6 +165,62 @@ func InitConfig(path string) {
 		defaultConfig.P2P.NodeHostIP = "0.0.0.0"
 	}
 
+	if defaultConfig.Mysql.HealthCheckInterval <= 0 {
+		defaultConfig.Mysql.HealthCheckInterval = 5
+	}
+
+	if defaultConfig.Mysql.AcquireTimeout <= 0 {
+		defaultConfig.Mysql.AcquireTimeout = 100
+	}
+
+	if defaultConfig.P2P.JournalSize <= 0 {
+		defaultConfig.P2P.JournalSize = 4096
+	}
+
+	if defaultConfig.P2P.MaxPending <= 0 {
+		defaultConfig.P2P.MaxPending = 1024
+	}
+
+	if len(defaultConfig.P2P.Security.Transports) == 0 {
+		defaultConfig.P2P.Security.Transports = []string{"tls", "noise"}
+	}
+
+	if defaultConfig.Cache.TTL <= 0 {
+		defaultConfig.Cache.TTL = 1000
+	}
+
+	if defaultConfig.Cache.MaxEntries <= 0 {
+		defaultConfig.Cache.MaxEntries = 10000
+	}
+
+	if defaultConfig.Cache.MaxRows <= 0 {
+		defaultConfig.Cache.MaxRows = 1000
+	}
+
+	if defaultConfig.Audit.Sink == "" {
+		defaultConfig.Audit.Sink = "file"
+	}
+
+	if defaultConfig.Audit.Timeout <= 0 {
+		defaultConfig.Audit.Timeout = 5000
+	}
+
+	if defaultConfig.Audit.SampleRate <= 0 || defaultConfig.Audit.SampleRate > 1 {
+		defaultConfig.Audit.SampleRate = 1
+	}
+
+	if defaultConfig.Audit.BatchSize <= 0 {
+		defaultConfig.Audit.BatchSize = 100
+	}
+
+	if defaultConfig.Audit.FlushInterval <= 0 {
+		defaultConfig.Audit.FlushInterval = 1000
+	}
+
+	if defaultConfig.Audit.BufferSize <= 0 {
+		defaultConfig.Audit.BufferSize = 10000
+	}
+
 	if defaultConfig.P2P.NodeHostPort < 0 || defaultConfig.P2P.NodeHostPort > 65535 {
 		defaultConfig.P2P.NodeHostPort = 0
 	}
@@ -0,0 +1,355 @@
+package binlog
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	. "github.com/IceFireDB/IceFireDB/IceFireDB-SQLProxy/pkg/mysql/mysql"
+	"github.com/pingcap/errors"
+)
+
+// EventType is the type of a binlog event
+type EventType byte
+
+const (
+	QUERY_EVENT              EventType = 2
+	ROTATE_EVENT             EventType = 4
+	FORMAT_DESCRIPTION_EVENT EventType = 15
+	XID_EVENT                EventType = 16
+	TABLE_MAP_EVENT          EventType = 19
+	WRITE_ROWS_EVENTv1       EventType = 23
+	UPDATE_ROWS_EVENTv1      EventType = 24
+	DELETE_ROWS_EVENTv1      EventType = 25
+	HEARTBEAT_EVENT          EventType = 27
+	WRITE_ROWS_EVENTv2       EventType = 30
+	UPDATE_ROWS_EVENTv2      EventType = 31
+	DELETE_ROWS_EVENTv2      EventType = 32
+)
+
+// EventHeaderSize is the size of the header of the binlog events v4
+const EventHeaderSize = 19
+
+// EventHeader is the common header of the binlog events
+type EventHeader struct {
+	Timestamp uint32
+	EventType EventType
+	ServerID  uint32
+	EventSize uint32
+	// LogPos is the position of the next event
+	LogPos uint32
+	Flags  uint16
+}
+
+func (h *EventHeader) Decode(data []byte) error {
+	if len(data) < EventHeaderSize {
+		return errors.Errorf("header size too short %d, must %d", len(data), EventHeaderSize)
+	}
+	h.Timestamp = binary.LittleEndian.Uint32(data)
+	h.EventType = EventType(data[4])
+	h.ServerID = binary.LittleEndian.Uint32(data[5:])
+	h.EventSize = binary.LittleEndian.Uint32(data[9:])
+	h.LogPos = binary.LittleEndian.Uint32(data[13:])
+	h.Flags = binary.LittleEndian.Uint16(data[17:])
+	if h.EventSize < EventHeaderSize {
+		return errors.Errorf("invalid event size %d, must >= %d", h.EventSize, EventHeaderSize)
+	}
+	return nil
+}
+
+// Event is a binlog event. Event is nil for the types not decoded.
+type Event struct {
+	Header EventHeader
+	Event  interface{}
+}
+
+// FormatDescriptionEvent describes the format of the events of a binlog file
+type FormatDescriptionEvent struct {
+	Version          uint16
+	ServerVersion    string
+	HeaderLength     byte
+	PostHeaderLength []byte
+}
+
+func (e *FormatDescriptionEvent) Decode(data []byte) error {
+	if len(data) < 57 {
+		return errors.Trace(ErrMalformPacket)
+	}
+	e.Version = binary.LittleEndian.Uint16(data)
+	e.ServerVersion = string(trimNull(data[2:52]))
+	e.HeaderLength = data[56]
+	e.PostHeaderLength = data[57:]
+	return nil
+}
+
+// postHeaderLength returns the post header length of the events of type t
+func (e *FormatDescriptionEvent) postHeaderLength(t EventType, def int) int {
+	if e == nil || int(t) > len(e.PostHeaderLength) || t == 0 {
+		return def
+	}
+	return int(e.PostHeaderLength[t-1])
+}
+
+// RotateEvent starts the binlog file NextLogName at Position
+type RotateEvent struct {
+	Position    uint64
+	NextLogName string
+}
+
+func (e *RotateEvent) Decode(data []byte) error {
+	if len(data) < 8 {
+		return errors.Trace(ErrMalformPacket)
+	}
+	e.Position = binary.LittleEndian.Uint64(data)
+	e.NextLogName = string(data[8:])
+	return nil
+}
+
+// QueryEvent is a statement logged as SQL: DDL, BEGIN, and the DML of the
+// statement-based binlog
+type QueryEvent struct {
+	// ThreadID is the connection id of the session that executed the query
+	ThreadID uint32
+	Schema   string
+	Query    string
+}
+
+func (e *QueryEvent) Decode(data []byte) error {
+	// thread id, exec time, schema length, error code, status vars length
+	if len(data) < 13 {
+		return errors.Trace(ErrMalformPacket)
+	}
+	e.ThreadID = binary.LittleEndian.Uint32(data)
+	schemaLength := int(data[8])
+	statusVarsLength := int(binary.LittleEndian.Uint16(data[11:]))
+	pos := 13 + statusVarsLength
+	if len(data) < pos+schemaLength+1 {
+		return errors.Trace(ErrMalformPacket)
+	}
+	e.Schema = string(data[pos : pos+schemaLength])
+	// the schema is followed by 0x00
+	pos += schemaLength + 1
+	e.Query = string(data[pos:])
+	return nil
+}
+
+// XIDEvent commits a transaction
+type XIDEvent struct {
+	XID uint64
+}
+
+func (e *XIDEvent) Decode(data []byte) error {
+	if len(data) < 8 {
+		return errors.Trace(ErrMalformPacket)
+	}
+	e.XID = binary.LittleEndian.Uint64(data)
+	return nil
+}
+
+// TableMapEvent describes the table of the following rows events
+type TableMapEvent struct {
+	tableIDSize int
+
+	TableID     uint64
+	Schema      string
+	Table       string
+	ColumnCount uint64
+	ColumnType  []byte
+	ColumnMeta  []uint16
+}
+
+func (e *TableMapEvent) Decode(data []byte) error {
+	pos := e.tableIDSize + 2
+	if len(data) < pos+1 {
+		return errors.Trace(ErrMalformPacket)
+	}
+	e.TableID = FixedLengthInt(data[:e.tableIDSize])
+
+	schemaLength := int(data[pos])
+	pos++
+	if len(data) < pos+schemaLength+2 {
+		return errors.Trace(ErrMalformPacket)
+	}
+	e.Schema = string(data[pos : pos+schemaLength])
+	pos += schemaLength + 1
+
+	tableLength := int(data[pos])
+	pos++
+	if len(data) < pos+tableLength+1 {
+		return errors.Trace(ErrMalformPacket)
+	}
+	e.Table = string(data[pos : pos+tableLength])
+	pos += tableLength + 1
+
+	var n int
+	e.ColumnCount, _, n = LengthEncodedInt(data[pos:])
+	pos += n
+	if len(data) < pos+int(e.ColumnCount) {
+		return errors.Trace(ErrMalformPacket)
+	}
+	e.ColumnType = data[pos : pos+int(e.ColumnCount)]
+	pos += int(e.ColumnCount)
+
+	metaLength, _, n := LengthEncodedInt(data[pos:])
+	pos += n
+	if len(data) < pos+int(metaLength) {
+		return errors.Trace(ErrMalformPacket)
+	}
+	return e.decodeMeta(data[pos : pos+int(metaLength)])
+}
+
+// decodeMeta decodes the metadata of the columns, see
+// https://dev.mysql.com/doc/dev/mysql-server/latest/classbinary__log_1_1Table__map__event.html
+func (e *TableMapEvent) decodeMeta(data []byte) error {
+	pos := 0
+	e.ColumnMeta = make([]uint16, e.ColumnCount)
+	for i, t := range e.ColumnType {
+		var size int
+		switch t {
+		case MYSQL_TYPE_STRING, MYSQL_TYPE_NEWDECIMAL:
+			if pos+2 > len(data) {
+				return errors.Trace(ErrMalformPacket)
+			}
+			// big endian
+			e.ColumnMeta[i] = uint16(data[pos])<<8 | uint16(data[pos+1])
+			pos += 2
+			continue
+		case MYSQL_TYPE_VAR_STRING, MYSQL_TYPE_VARCHAR, MYSQL_TYPE_BIT:
+			size = 2
+		case MYSQL_TYPE_BLOB, MYSQL_TYPE_DOUBLE, MYSQL_TYPE_FLOAT, MYSQL_TYPE_GEOMETRY, MYSQL_TYPE_JSON,
+			MYSQL_TYPE_TIME2, MYSQL_TYPE_DATETIME2, MYSQL_TYPE_TIMESTAMP2:
+			size = 1
+		}
+		if pos+size > len(data) {
+			return errors.Trace(ErrMalformPacket)
+		}
+		e.ColumnMeta[i] = uint16(FixedLengthInt(data[pos : pos+size]))
+		pos += size
+	}
+	return nil
+}
+
+// RowsEvent holds the rows written, updated or deleted in a table. The rows
+// of an update alternate the before and the after image.
+type RowsEvent struct {
+	tableIDSize int
+	version     int
+	tables      map[uint64]*TableMapEvent
+
+	Table *TableMapEvent
+	// Columns tells the columns present in the rows, the columns of the after
+	// image for an update
+	Columns       []bool
+	BeforeColumns []bool
+	Rows          [][]interface{}
+}
+
+func (e *RowsEvent) Decode(t EventType, data []byte) error {
+	pos := e.tableIDSize
+	if len(data) < pos+2 {
+		return errors.Trace(ErrMalformPacket)
+	}
+	tableID := FixedLengthInt(data[:pos])
+	// flags
+	pos += 2
+	if e.version == 2 {
+		if len(data) < pos+2 {
+			return errors.Trace(ErrMalformPacket)
+		}
+		// the extra data length includes itself
+		pos += int(binary.LittleEndian.Uint16(data[pos:]))
+	}
+
+	var ok bool
+	if e.Table, ok = e.tables[tableID]; !ok {
+		return errors.Errorf("invalid table id %d, no corresponding table map event", tableID)
+	}
+
+	count, _, n := LengthEncodedInt(data[pos:])
+	pos += n
+	bitmapSize := int(count+7) / 8
+	if len(data) < pos+bitmapSize {
+		return errors.Trace(ErrMalformPacket)
+	}
+	e.BeforeColumns = bitmap(data[pos:pos+bitmapSize], int(count))
+	e.Columns = e.BeforeColumns
+	pos += bitmapSize
+	update := t == UPDATE_ROWS_EVENTv1 || t == UPDATE_ROWS_EVENTv2
+	if update {
+		if len(data) < pos+bitmapSize {
+			return errors.Trace(ErrMalformPacket)
+		}
+		e.Columns = bitmap(data[pos:pos+bitmapSize], int(count))
+		pos += bitmapSize
+	}
+
+	for pos < len(data) {
+		n, err := e.decodeImage(data[pos:], e.BeforeColumns)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		pos += n
+		if update {
+			if n, err = e.decodeImage(data[pos:], e.Columns); err != nil {
+				return errors.Trace(err)
+			}
+			pos += n
+		}
+	}
+	return nil
+}
+
+// decodeImage decodes a row image and returns its size
+func (e *RowsEvent) decodeImage(data []byte, columns []bool) (int, error) {
+	present := 0
+	for _, ok := range columns {
+		if ok {
+			present++
+		}
+	}
+	pos := (present + 7) / 8
+	if len(data) < pos {
+		return 0, errors.Trace(ErrMalformPacket)
+	}
+	nulls := bitmap(data[:pos], present)
+
+	row := make([]interface{}, len(columns))
+	index := 0
+	for i, ok := range columns {
+		if !ok {
+			continue
+		}
+		null := nulls[index]
+		index++
+		if null {
+			continue
+		}
+		if i >= len(e.Table.ColumnType) {
+			return 0, errors.Errorf("column %d of %s.%s not in table map", i, e.Table.Schema, e.Table.Table)
+		}
+		v, n, err := decodeValue(data[pos:], e.Table.ColumnType[i], e.Table.ColumnMeta[i])
+		if err != nil {
+			return 0, fmt.Errorf("column %d of %s.%s: %v", i, e.Table.Schema, e.Table.Table, err)
+		}
+		row[i] = v
+		pos += n
+	}
+	e.Rows = append(e.Rows, row)
+	return pos, nil
+}
+
+func bitmap(data []byte, n int) []bool {
+	bits := make([]bool, n)
+	for i := range bits {
+		bits[i] = data[i/8]&(1<<(uint(i)%8)) != 0
+	}
+	return bits
+}
+
+func trimNull(data []byte) []byte {
+	for i, b := range data {
+		if b == 0 {
+			return data[:i]
+		}
+	}
+	return data
+}
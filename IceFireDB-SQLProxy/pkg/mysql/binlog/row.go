@@ -0,0 +1,327 @@
+package binlog
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	. "github.com/IceFireDB/IceFireDB/IceFireDB-SQLProxy/pkg/mysql/mysql"
+	"github.com/pingcap/errors"
+)
+
+// decodeValue decodes a column value of a row image and returns its size.
+// The integers are returned with the size of their type and signed, the
+// decimals and the temporal types as strings, the other types as bytes. JSON
+// is returned in the binary format of MySQL.
+func decodeValue(data []byte, tp byte, meta uint16) (v interface{}, n int, err error) {
+	length := 0
+	if tp == MYSQL_TYPE_STRING {
+		// the real type of ENUM and SET is in the meta
+		if meta >= 256 {
+			b0, b1 := byte(meta>>8), byte(meta&0xff)
+			if b0&0x30 != 0x30 {
+				length = int(uint16(b1) | (uint16((b0&0x30)^0x30) << 4))
+				tp = b0 | 0x30
+			} else {
+				length = int(b1)
+				tp = b0
+			}
+		} else {
+			length = int(meta)
+		}
+	}
+
+	defer func() {
+		if err == nil && n > len(data) {
+			v, n, err = nil, 0, errors.Trace(ErrMalformPacket)
+		}
+	}()
+	size := func(n int) bool { return n <= len(data) }
+
+	switch tp {
+	case MYSQL_TYPE_NULL:
+		return nil, 0, nil
+	case MYSQL_TYPE_TINY:
+		if !size(1) {
+			break
+		}
+		return int8(data[0]), 1, nil
+	case MYSQL_TYPE_SHORT:
+		if !size(2) {
+			break
+		}
+		return int16(binary.LittleEndian.Uint16(data)), 2, nil
+	case MYSQL_TYPE_INT24:
+		if !size(3) {
+			break
+		}
+		u := uint32(FixedLengthInt(data[:3]))
+		if u&0x800000 != 0 {
+			u |= 0xff000000
+		}
+		return int32(u), 3, nil
+	case MYSQL_TYPE_LONG:
+		if !size(4) {
+			break
+		}
+		return int32(binary.LittleEndian.Uint32(data)), 4, nil
+	case MYSQL_TYPE_LONGLONG:
+		if !size(8) {
+			break
+		}
+		return int64(binary.LittleEndian.Uint64(data)), 8, nil
+	case MYSQL_TYPE_FLOAT:
+		if !size(4) {
+			break
+		}
+		return math.Float32frombits(binary.LittleEndian.Uint32(data)), 4, nil
+	case MYSQL_TYPE_DOUBLE:
+		if !size(8) {
+			break
+		}
+		return math.Float64frombits(binary.LittleEndian.Uint64(data)), 8, nil
+	case MYSQL_TYPE_NEWDECIMAL:
+		return decodeDecimal(data, int(meta>>8), int(meta&0xff))
+	case MYSQL_TYPE_BIT:
+		nbits := int(meta>>8)*8 + int(meta&0xff)
+		n = (nbits + 7) / 8
+		if !size(n) {
+			break
+		}
+		return BFixedLengthInt(data[:n]), n, nil
+	case MYSQL_TYPE_YEAR:
+		if !size(1) {
+			break
+		}
+		if data[0] == 0 {
+			return "0000", 1, nil
+		}
+		return strconv.Itoa(1900 + int(data[0])), 1, nil
+	case MYSQL_TYPE_DATE:
+		if !size(3) {
+			break
+		}
+		d := FixedLengthInt(data[:3])
+		return fmt.Sprintf("%04d-%02d-%02d", d>>9, (d>>5)%16, d%32), 3, nil
+	case MYSQL_TYPE_TIMESTAMP:
+		if !size(4) {
+			break
+		}
+		return formatTimestamp(int64(binary.LittleEndian.Uint32(data)), 0, 0), 4, nil
+	case MYSQL_TYPE_TIMESTAMP2:
+		n = 4 + int(meta+1)/2
+		if !size(n) {
+			break
+		}
+		sec := int64(binary.BigEndian.Uint32(data))
+		return formatTimestamp(sec, fraction(data[4:n], int(meta)), int(meta)), n, nil
+	case MYSQL_TYPE_DATETIME:
+		if !size(8) {
+			break
+		}
+		d := binary.LittleEndian.Uint64(data)
+		date, clock := d/1000000, d%1000000
+		return fmt.Sprintf("%04d-%02d-%02d %02d:%02d:%02d",
+			date/10000, (date%10000)/100, date%100, clock/10000, (clock%10000)/100, clock%100), 8, nil
+	case MYSQL_TYPE_DATETIME2:
+		return decodeDatetime2(data, int(meta))
+	case MYSQL_TYPE_TIME:
+		if !size(3) {
+			break
+		}
+		t := int32(FixedLengthInt(data[:3]))
+		if t&0x800000 != 0 {
+			t |= -1 << 24
+		}
+		sign := ""
+		if t < 0 {
+			sign, t = "-", -t
+		}
+		return fmt.Sprintf("%s%02d:%02d:%02d", sign, t/10000, (t%10000)/100, t%100), 3, nil
+	case MYSQL_TYPE_TIME2:
+		return decodeTime2(data, int(meta))
+	case MYSQL_TYPE_VARCHAR, MYSQL_TYPE_VAR_STRING:
+		return decodeString(data, int(meta))
+	case MYSQL_TYPE_STRING:
+		return decodeString(data, length)
+	case MYSQL_TYPE_ENUM:
+		if !size(length) {
+			break
+		}
+		switch length {
+		case 1:
+			return int64(data[0]), 1, nil
+		case 2:
+			return int64(binary.LittleEndian.Uint16(data)), 2, nil
+		}
+		return nil, 0, errors.Errorf("unknown ENUM pack length %d", length)
+	case MYSQL_TYPE_SET:
+		if !size(length) {
+			break
+		}
+		return FixedLengthInt(data[:length]), length, nil
+	case MYSQL_TYPE_BLOB, MYSQL_TYPE_GEOMETRY, MYSQL_TYPE_JSON:
+		return decodeBlob(data, int(meta))
+	default:
+		return nil, 0, errors.Errorf("unsupported type %d in binlog", tp)
+	}
+	return nil, 0, errors.Trace(ErrMalformPacket)
+}
+
+func decodeString(data []byte, length int) (interface{}, int, error) {
+	prefix := 1
+	if length >= 256 {
+		prefix = 2
+	}
+	if len(data) < prefix {
+		return nil, 0, errors.Trace(ErrMalformPacket)
+	}
+	n := int(FixedLengthInt(data[:prefix]))
+	if len(data) < prefix+n {
+		return nil, 0, errors.Trace(ErrMalformPacket)
+	}
+	return append([]byte{}, data[prefix:prefix+n]...), prefix + n, nil
+}
+
+func decodeBlob(data []byte, prefix int) (interface{}, int, error) {
+	if prefix < 1 || prefix > 4 || len(data) < prefix {
+		return nil, 0, errors.Errorf("invalid blob packlen %d", prefix)
+	}
+	n := int(FixedLengthInt(data[:prefix]))
+	if len(data) < prefix+n {
+		return nil, 0, errors.Trace(ErrMalformPacket)
+	}
+	return append([]byte{}, data[prefix:prefix+n]...), prefix + n, nil
+}
+
+// fraction returns the microseconds of the fractional part of fsp digits
+func fraction(data []byte, fsp int) int {
+	v := int(BFixedLengthInt(data))
+	switch (fsp + 1) / 2 {
+	case 1:
+		return v * 10000
+	case 2:
+		return v * 100
+	}
+	return v
+}
+
+func formatFraction(usec int, fsp int) string {
+	if fsp == 0 {
+		return ""
+	}
+	return "." + fmt.Sprintf("%06d", usec)[:fsp]
+}
+
+func formatTimestamp(sec int64, usec int, fsp int) string {
+	if sec == 0 {
+		return "0000-00-00 00:00:00" + formatFraction(0, fsp)
+	}
+	return time.Unix(sec, 0).UTC().Format("2006-01-02 15:04:05") + formatFraction(usec, fsp)
+}
+
+// decodeDatetime2 decodes a DATETIME of MySQL 5.6.4 and later, see
+// https://dev.mysql.com/doc/dev/mysql-server/latest/myisampack_8h.html
+func decodeDatetime2(data []byte, fsp int) (interface{}, int, error) {
+	n := 5 + (fsp+1)/2
+	if len(data) < n {
+		return nil, 0, errors.Trace(ErrMalformPacket)
+	}
+	v := int64(BFixedLengthInt(data[:5])) - 0x8000000000
+	if v < 0 {
+		v = -v
+	}
+	ymd := v >> 17
+	ym := ymd >> 5
+	hms := v % (1 << 17)
+	return fmt.Sprintf("%04d-%02d-%02d %02d:%02d:%02d%s",
+		ym/13, ym%13, ymd%32, hms>>12, (hms>>6)%64, hms%64, formatFraction(fraction(data[5:n], fsp), fsp)), n, nil
+}
+
+// decodeTime2 decodes a TIME of MySQL 5.6.4 and later
+func decodeTime2(data []byte, fsp int) (interface{}, int, error) {
+	n := 3 + (fsp+1)/2
+	if len(data) < n {
+		return nil, 0, errors.Trace(ErrMalformPacket)
+	}
+	var tmp int64
+	switch (fsp + 1) / 2 {
+	case 0:
+		tmp = (int64(BFixedLengthInt(data[:3])) - 0x800000) << 24
+	case 1:
+		intPart := int64(BFixedLengthInt(data[:3])) - 0x800000
+		frac := int64(data[3])
+		if intPart < 0 && frac > 0 {
+			intPart++
+			frac -= 0x100
+		}
+		tmp = intPart<<24 + frac*10000
+	case 2:
+		intPart := int64(BFixedLengthInt(data[:3])) - 0x800000
+		frac := int64(binary.BigEndian.Uint16(data[3:]))
+		if intPart < 0 && frac > 0 {
+			intPart++
+			frac -= 0x10000
+		}
+		tmp = intPart<<24 + frac*100
+	default:
+		tmp = int64(BFixedLengthInt(data[:6])) - 0x800000000000
+	}
+	sign := ""
+	if tmp < 0 {
+		sign, tmp = "-", -tmp
+	}
+	hms := tmp >> 24
+	usec := int(tmp % (1 << 24))
+	return fmt.Sprintf("%s%02d:%02d:%02d%s",
+		sign, (hms>>12)%(1<<10), (hms>>6)%(1<<6), hms%(1<<6), formatFraction(usec, fsp)), n, nil
+}
+
+var compressedBytes = []int{0, 1, 1, 2, 2, 3, 3, 4, 4, 4}
+
+// decodeDecimal decodes a DECIMAL(precision, scale) stored as groups of 9
+// digits, see https://dev.mysql.com/doc/refman/8.0/en/precision-math-decimal-characteristics.html
+func decodeDecimal(data []byte, precision int, scale int) (interface{}, int, error) {
+	integral := precision - scale
+	uncompIntegral, uncompFractional := integral/9, scale/9
+	compIntegral, compFractional := integral-uncompIntegral*9, scale-uncompFractional*9
+	n := uncompIntegral*4 + compressedBytes[compIntegral] + uncompFractional*4 + compressedBytes[compFractional]
+	if len(data) < n {
+		return nil, 0, errors.Trace(ErrMalformPacket)
+	}
+
+	buf := make([]byte, n)
+	copy(buf, data)
+	var mask byte
+	var res strings.Builder
+	// the sign is the highest bit, set for the positive numbers
+	if buf[0]&0x80 == 0 {
+		mask = 0xff
+		res.WriteString("-")
+	}
+	buf[0] ^= 0x80
+	for i := range buf {
+		buf[i] ^= mask
+	}
+
+	pos := compressedBytes[compIntegral]
+	res.WriteString(strconv.FormatUint(BFixedLengthInt(buf[:pos]), 10))
+	for i := 0; i < uncompIntegral; i++ {
+		fmt.Fprintf(&res, "%09d", binary.BigEndian.Uint32(buf[pos:]))
+		pos += 4
+	}
+	if scale > 0 {
+		res.WriteString(".")
+		for i := 0; i < uncompFractional; i++ {
+			fmt.Fprintf(&res, "%09d", binary.BigEndian.Uint32(buf[pos:]))
+			pos += 4
+		}
+		if size := compressedBytes[compFractional]; size > 0 {
+			fmt.Fprintf(&res, "%0*d", compFractional, BFixedLengthInt(buf[pos:pos+size]))
+		}
+	}
+	return res.String(), n, nil
+}
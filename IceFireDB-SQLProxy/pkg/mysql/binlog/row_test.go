@@ -0,0 +1,92 @@
+package binlog
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	. "github.com/IceFireDB/IceFireDB/IceFireDB-SQLProxy/pkg/mysql/mysql"
+)
+
+func TestDecodeDecimal(t *testing.T) {
+	// DECIMAL(10,2): 8 integral digits in 4 bytes, 2 fractional digits in 1 byte
+	positive := []byte{0x80, 0x00, 0x04, 0xd2, 0x38}
+	v, n, err := decodeValue(positive, MYSQL_TYPE_NEWDECIMAL, 10<<8|2)
+	if err != nil || n != 5 || v != "1234.56" {
+		t.Fatalf("decode %v %d %v", v, n, err)
+	}
+
+	negative := make([]byte, len(positive))
+	for i, b := range positive {
+		negative[i] = ^b
+	}
+	v, _, err = decodeValue(negative, MYSQL_TYPE_NEWDECIMAL, 10<<8|2)
+	if err != nil || v != "-1234.56" {
+		t.Fatalf("decode %v %v", v, err)
+	}
+}
+
+func TestDecodeTemporal(t *testing.T) {
+	ym := int64(2021*13 + 3)
+	v := (ym<<5|4)<<17 | (5<<12 | 6<<6 | 7)
+	data := make([]byte, 8)
+	binary.BigEndian.PutUint64(data, uint64(v+0x8000000000)<<24)
+	got, n, err := decodeValue(data[:5], MYSQL_TYPE_DATETIME2, 0)
+	if err != nil || n != 5 || got != "2021-03-04 05:06:07" {
+		t.Fatalf("datetime2 %v %d %v", got, n, err)
+	}
+
+	hms := int64(12<<12 | 34<<6 | 56)
+	binary.BigEndian.PutUint32(data, uint32(hms+0x800000)<<8)
+	got, n, err = decodeValue(data[:3], MYSQL_TYPE_TIME2, 0)
+	if err != nil || n != 3 || got != "12:34:56" {
+		t.Fatalf("time2 %v %d %v", got, n, err)
+	}
+
+	// 2000-01-02
+	d := uint32(2000<<9 | 1<<5 | 2)
+	got, _, err = decodeValue([]byte{byte(d), byte(d >> 8), byte(d >> 16)}, MYSQL_TYPE_DATE, 0)
+	if err != nil || got != "2000-01-02" {
+		t.Fatalf("date %v %v", got, err)
+	}
+}
+
+func TestDecodeRowsEvent(t *testing.T) {
+	s := NewSyncer(SyncerConfig{})
+
+	var table bytes.Buffer
+	table.Write([]byte{1, 0, 0, 0, 0, 0, 0, 0})
+	table.Write([]byte{2, 'd', 'b', 0, 1, 't', 0})
+	table.Write([]byte{2, MYSQL_TYPE_LONG, MYSQL_TYPE_VARCHAR})
+	table.Write([]byte{2, 20, 0})
+	table.Write([]byte{0})
+	e, err := s.parse(event(TABLE_MAP_EVENT, table.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m := e.Event.(*TableMapEvent); m.Schema != "db" || m.Table != "t" || m.ColumnMeta[1] != 20 {
+		t.Fatalf("table map %+v", m)
+	}
+
+	var rows bytes.Buffer
+	rows.Write([]byte{1, 0, 0, 0, 0, 0, 0, 0, 2, 0})
+	rows.Write([]byte{2, 0x03})
+	rows.Write([]byte{0x00, 7, 0, 0, 0, 1, 'a'})
+	rows.Write([]byte{0x02, 8, 0, 0, 0})
+	e, err = s.parse(event(WRITE_ROWS_EVENTv2, rows.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := e.Event.(*RowsEvent)
+	if len(r.Rows) != 2 || r.Rows[0][0] != int32(7) || string(r.Rows[0][1].([]byte)) != "a" ||
+		r.Rows[1][0] != int32(8) || r.Rows[1][1] != nil {
+		t.Fatalf("rows %v", r.Rows)
+	}
+}
+
+func event(t EventType, body []byte) []byte {
+	header := make([]byte, EventHeaderSize)
+	header[4] = byte(t)
+	binary.LittleEndian.PutUint32(header[9:], uint32(EventHeaderSize+len(body)))
+	return append(header, body...)
+}
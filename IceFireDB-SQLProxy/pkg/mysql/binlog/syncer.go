@@ -0,0 +1,229 @@
+package binlog
+
+import (
+	"context"
+	"strings"
+
+	"github.com/IceFireDB/IceFireDB/IceFireDB-SQLProxy/pkg/mysql/client"
+	. "github.com/IceFireDB/IceFireDB/IceFireDB-SQLProxy/pkg/mysql/mysql"
+	"github.com/pingcap/errors"
+)
+
+// SyncerConfig is the configuration of a binlog syncer
+type SyncerConfig struct {
+	// ServerID identifies the syncer as a replica, it must be unique among the
+	// replicas of the server
+	ServerID uint32
+	Addr     string
+	User     string
+	Password string
+}
+
+// Syncer reads the binlog of a MySQL server as a replica
+type Syncer struct {
+	cfg SyncerConfig
+
+	checksum bool
+	format   *FormatDescriptionEvent
+	tables   map[uint64]*TableMapEvent
+}
+
+func NewSyncer(cfg SyncerConfig) *Syncer {
+	return &Syncer{cfg: cfg, tables: make(map[uint64]*TableMapEvent)}
+}
+
+// MasterPosition returns the current position of the binlog of the server
+func (s *Syncer) MasterPosition() (Position, error) {
+	conn, err := client.Connect(s.cfg.Addr, s.cfg.User, s.cfg.Password, "")
+	if err != nil {
+		return Position{}, errors.Trace(err)
+	}
+	defer conn.Close()
+
+	res, err := conn.Execute("SHOW MASTER STATUS")
+	if err != nil {
+		// renamed in MySQL 8.4
+		if res, err = conn.Execute("SHOW BINARY LOG STATUS"); err != nil {
+			return Position{}, errors.Trace(err)
+		}
+	}
+	if res.RowNumber() == 0 {
+		return Position{}, errors.New("binary log is disabled")
+	}
+	name, err := res.GetString(0, 0)
+	if err != nil {
+		return Position{}, errors.Trace(err)
+	}
+	pos, err := res.GetUint(0, 1)
+	if err != nil {
+		return Position{}, errors.Trace(err)
+	}
+	return Position{Name: name, Pos: uint32(pos)}, nil
+}
+
+// Sync reads the binlog from pos and calls fn for every event until ctx is
+// done, the connection fails or fn returns an error
+func (s *Syncer) Sync(ctx context.Context, pos Position, fn func(e *Event) error) error {
+	conn, err := client.Connect(s.cfg.Addr, s.cfg.User, s.cfg.Password, "")
+	if err != nil {
+		return errors.Trace(err)
+	}
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-done:
+		}
+		conn.Close()
+	}()
+
+	if err = s.prepare(conn); err != nil {
+		return errors.Trace(err)
+	}
+	if err = s.register(conn); err != nil {
+		return errors.Trace(err)
+	}
+	if err = s.dump(conn, pos); err != nil {
+		return errors.Trace(err)
+	}
+
+	for {
+		data, err := conn.ReadPacket()
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return errors.Trace(err)
+		}
+		switch data[0] {
+		case OK_HEADER:
+		case ERR_HEADER:
+			return conn.HandleErrorPacket(data)
+		case EOF_HEADER:
+			return errors.New("binlog stream closed by the server")
+		default:
+			return errors.Errorf("invalid binlog stream header %d", data[0])
+		}
+
+		e, err := s.parse(data[1:])
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if err = fn(e); err != nil {
+			return err
+		}
+	}
+}
+
+// prepare asks the server to send the checksum of the events, the replicas
+// unaware of the checksums are refused by the servers that compute them
+func (s *Syncer) prepare(conn *client.Conn) error {
+	res, err := conn.Execute("SHOW GLOBAL VARIABLES LIKE 'binlog_checksum'")
+	if err != nil {
+		return errors.Trace(err)
+	}
+	s.checksum = false
+	if res.RowNumber() == 0 {
+		return nil
+	}
+	alg, _ := res.GetString(0, 1)
+	if alg == "" || strings.EqualFold(alg, "NONE") {
+		return nil
+	}
+	if _, err = conn.Execute("SET @master_binlog_checksum = @@global.binlog_checksum"); err != nil {
+		return errors.Trace(err)
+	}
+	s.checksum = true
+	return nil
+}
+
+func (s *Syncer) register(conn *client.Conn) error {
+	conn.ResetSequence()
+	// header, command, server id, empty host, user and password, port, rank
+	// and master id
+	data := make([]byte, 4, 4+1+4+3+2+4+4)
+	data = append(data, COM_REGISTER_SLAVE)
+	data = append(data, Uint32ToBytes(s.cfg.ServerID)...)
+	data = append(data, 0, 0, 0)
+	data = append(data, 0, 0)
+	data = append(data, 0, 0, 0, 0)
+	data = append(data, 0, 0, 0, 0)
+	if err := conn.WritePacket(data); err != nil {
+		return errors.Trace(err)
+	}
+	_, err := conn.ReadOKPacket()
+	return errors.Trace(err)
+}
+
+func (s *Syncer) dump(conn *client.Conn, pos Position) error {
+	conn.ResetSequence()
+	data := make([]byte, 4, 4+1+4+2+4+len(pos.Name))
+	data = append(data, COM_BINLOG_DUMP)
+	data = append(data, Uint32ToBytes(pos.Pos)...)
+	// flags
+	data = append(data, 0, 0)
+	data = append(data, Uint32ToBytes(s.cfg.ServerID)...)
+	data = append(data, pos.Name...)
+	return errors.Trace(conn.WritePacket(data))
+}
+
+func (s *Syncer) parse(data []byte) (*Event, error) {
+	e := &Event{}
+	if err := e.Header.Decode(data); err != nil {
+		return nil, err
+	}
+	body := data[EventHeaderSize:]
+	if s.checksum {
+		if len(body) < 4 {
+			return nil, errors.Trace(ErrMalformPacket)
+		}
+		body = body[:len(body)-4]
+	}
+
+	tableIDSize := func(t EventType, def int) int {
+		// the table id is 4 bytes in the binlogs of MySQL 5.1.3 and earlier
+		if s.format.postHeaderLength(t, def) == 6 {
+			return 4
+		}
+		return 6
+	}
+
+	var err error
+	switch t := e.Header.EventType; t {
+	case FORMAT_DESCRIPTION_EVENT:
+		format := &FormatDescriptionEvent{}
+		err = format.Decode(body)
+		s.format, e.Event = format, format
+	case ROTATE_EVENT:
+		rotate := &RotateEvent{}
+		err = rotate.Decode(body)
+		e.Event = rotate
+	case QUERY_EVENT:
+		query := &QueryEvent{}
+		err = query.Decode(body)
+		e.Event = query
+	case XID_EVENT:
+		xid := &XIDEvent{}
+		err = xid.Decode(body)
+		e.Event = xid
+	case TABLE_MAP_EVENT:
+		table := &TableMapEvent{tableIDSize: tableIDSize(t, 8)}
+		if err = table.Decode(body); err == nil {
+			s.tables[table.TableID] = table
+		}
+		e.Event = table
+	case WRITE_ROWS_EVENTv1, UPDATE_ROWS_EVENTv1, DELETE_ROWS_EVENTv1:
+		rows := &RowsEvent{tableIDSize: tableIDSize(t, 8), version: 1, tables: s.tables}
+		err = rows.Decode(t, body)
+		e.Event = rows
+	case WRITE_ROWS_EVENTv2, UPDATE_ROWS_EVENTv2, DELETE_ROWS_EVENTv2:
+		rows := &RowsEvent{tableIDSize: tableIDSize(t, 10), version: 2, tables: s.tables}
+		err = rows.Decode(t, body)
+		e.Event = rows
+	}
+	if err != nil {
+		return nil, errors.Annotatef(err, "decode event %d at %d", e.Header.EventType, e.Header.LogPos)
+	}
+	return e, nil
+}
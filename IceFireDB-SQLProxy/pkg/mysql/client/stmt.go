@@ -17,6 +17,10 @@ type Stmt struct {
 	params   int
 	columns  int
 	warnings int
+
+	// definitions of the params and columns sent by the server
+	paramFields  []*Field
+	columnFields []*Field
 }
 
 func (s *Stmt) ParamNum() int {
@@ -31,6 +35,16 @@ func (s *Stmt) WarningsNum() int {
 	return s.warnings
 }
 
+// ParamFields returns the definitions of the params of the statement
+func (s *Stmt) ParamFields() []*Field {
+	return s.paramFields
+}
+
+// ColumnFields returns the definitions of the columns of the statement
+func (s *Stmt) ColumnFields() []*Field {
+	return s.columnFields
+}
+
 func (s *Stmt) Execute(args ...interface{}) (*Result, error) {
 	if err := s.write(args...); err != nil {
 		return nil, errors.Trace(err)
@@ -209,16 +223,35 @@ func (c *Conn) Prepare(query string) (*Stmt, error) {
 	pos += 2
 
 	if s.params > 0 {
-		if err := s.conn.readUntilEOF(); err != nil {
+		if s.paramFields, err = s.conn.readFields(); err != nil {
 			return nil, errors.Trace(err)
 		}
 	}
 
 	if s.columns > 0 {
-		if err := s.conn.readUntilEOF(); err != nil {
+		if s.columnFields, err = s.conn.readFields(); err != nil {
 			return nil, errors.Trace(err)
 		}
 	}
 
 	return s, nil
 }
+
+// readFields reads field definitions until the EOF packet
+func (c *Conn) readFields() ([]*Field, error) {
+	var fields []*Field
+	for {
+		data, err := c.ReadPacket()
+		if err != nil {
+			return nil, err
+		}
+		if c.isEOFPacket(data) {
+			return fields, nil
+		}
+		f := &Field{}
+		if err := f.Parse(append([]byte(nil), data...)); err != nil {
+			return nil, err
+		}
+		fields = append(fields, f)
+	}
+}
@@ -31,6 +31,8 @@ type Stmt struct {
 	Columns int
 
 	Args []interface{}
+	// types of the params, only sent on the first execution
+	paramTypes []byte
 
 	Context interface{}
 }
@@ -46,6 +48,21 @@ func (s *Stmt) ResetParams() {
 	s.Args = make([]interface{}, s.Params)
 }
 
+// StmtFields is implemented by the statement contexts knowing the
+// definitions of their params and columns, placeholders are sent otherwise
+type StmtFields interface {
+	ParamFields() []*Field
+	ColumnFields() []*Field
+}
+
+// A function that returns the definition packet of the i-th field
+func fieldData(fields []*Field, i int, placeholder []byte) []byte {
+	if i < len(fields) && fields[i] != nil {
+		return fields[i].Dump()
+	}
+	return placeholder
+}
+
 func (c *Conn) writePrepare(s *Stmt) error {
 	data := make([]byte, 4, 128)
 
@@ -66,10 +83,15 @@ func (c *Conn) writePrepare(s *Stmt) error {
 		return err
 	}
 
+	var paramFields, columnFields []*Field
+	if f, ok := s.Context.(StmtFields); ok {
+		paramFields, columnFields = f.ParamFields(), f.ColumnFields()
+	}
+
 	if s.Params > 0 {
 		for i := 0; i < s.Params; i++ {
 			data = data[0:4]
-			data = append(data, paramFieldData...)
+			data = append(data, fieldData(paramFields, i, paramFieldData)...)
 
 			if err := c.WritePacket(data); err != nil {
 				return errors.Trace(err)
@@ -84,7 +106,7 @@ func (c *Conn) writePrepare(s *Stmt) error {
 	if s.Columns > 0 {
 		for i := 0; i < s.Columns; i++ {
 			data = data[0:4]
-			data = append(data, columnFieldData...)
+			data = append(data, fieldData(columnFields, i, columnFieldData)...)
 
 			if err := c.WritePacket(data); err != nil {
 				return errors.Trace(err)
@@ -147,8 +169,15 @@ func (c *Conn) handleStmtExecute(data []byte) (*Result, error) {
 			paramTypes = data[pos : pos+(paramNum<<1)]
 			pos += paramNum << 1
 
-			paramValues = data[pos:]
+			s.paramTypes = append(s.paramTypes[:0], paramTypes...)
+		} else {
+			pos++
+			if len(s.paramTypes) != paramNum<<1 {
+				return nil, ErrMalformPacket
+			}
+			paramTypes = s.paramTypes
 		}
+		paramValues = data[pos:]
 
 		if err := c.bindStmtArgs(s, nullBitmaps, paramTypes, paramValues); err != nil {
 			return nil, errors.Trace(err)
@@ -264,9 +293,7 @@ func (c *Conn) bindStmtArgs(s *Stmt, nullBitmap, paramTypes, paramValues []byte)
 		case MYSQL_TYPE_DECIMAL, MYSQL_TYPE_NEWDECIMAL, MYSQL_TYPE_VARCHAR,
 			MYSQL_TYPE_BIT, MYSQL_TYPE_ENUM, MYSQL_TYPE_SET, MYSQL_TYPE_TINY_BLOB,
 			MYSQL_TYPE_MEDIUM_BLOB, MYSQL_TYPE_LONG_BLOB, MYSQL_TYPE_BLOB,
-			MYSQL_TYPE_VAR_STRING, MYSQL_TYPE_STRING, MYSQL_TYPE_GEOMETRY,
-			MYSQL_TYPE_DATE, MYSQL_TYPE_NEWDATE,
-			MYSQL_TYPE_TIMESTAMP, MYSQL_TYPE_DATETIME, MYSQL_TYPE_TIME:
+			MYSQL_TYPE_VAR_STRING, MYSQL_TYPE_STRING, MYSQL_TYPE_GEOMETRY:
 			if len(paramValues) < (pos + 1) {
 				return ErrMalformPacket
 			}
@@ -284,6 +311,28 @@ func (c *Conn) bindStmtArgs(s *Stmt, nullBitmap, paramTypes, paramValues []byte)
 				args[i] = nil
 				continue
 			}
+		case MYSQL_TYPE_DATE, MYSQL_TYPE_NEWDATE,
+			MYSQL_TYPE_TIMESTAMP, MYSQL_TYPE_DATETIME, MYSQL_TYPE_TIME:
+			// binary encoded: length then fields, bound as a string literal
+			if len(paramValues) < (pos + 1) {
+				return ErrMalformPacket
+			}
+			n = int(paramValues[pos])
+			pos++
+			if len(paramValues) < (pos + n) {
+				return ErrMalformPacket
+			}
+			if tp == MYSQL_TYPE_TIME {
+				v, err = formatBinaryTime(n, paramValues[pos:pos+n])
+			} else {
+				v, err = FormatBinaryDateTime(n, paramValues[pos:pos+n])
+			}
+			if err != nil {
+				return errors.Trace(err)
+			}
+			args[i] = v
+			pos += n
+			continue
 		default:
 			return errors.Errorf("Stmt Unknown FieldType %d", tp)
 		}
@@ -362,3 +411,24 @@ func (c *Conn) handleStmtClose(data []byte) error {
 
 	return nil
 }
+
+// formatBinaryTime formats a binary encoded TIME param: sign, days,
+// hours, minutes, seconds and microseconds
+func formatBinaryTime(n int, data []byte) ([]byte, error) {
+	if n == 0 {
+		return []byte("00:00:00"), nil
+	}
+	if n != 8 && n != 12 {
+		return nil, errors.Errorf("invalid time packet length %d", n)
+	}
+	sign := ""
+	if data[0] == 1 {
+		sign = "-"
+	}
+	hours := binary.LittleEndian.Uint32(data[1:5])*24 + uint32(data[5])
+	if n == 8 {
+		return []byte(fmt.Sprintf("%s%02d:%02d:%02d", sign, hours, data[6], data[7])), nil
+	}
+	return []byte(fmt.Sprintf("%s%02d:%02d:%02d.%06d", sign, hours, data[6], data[7],
+		binary.LittleEndian.Uint32(data[8:12]))), nil
+}
@@ -0,0 +1,70 @@
+package server
+
+import (
+	"encoding/binary"
+	"testing"
+
+	. "github.com/IceFireDB/IceFireDB/IceFireDB-SQLProxy/pkg/mysql/mysql"
+)
+
+type stmtTestHandler struct {
+	EmptyHandler
+	args []interface{}
+}
+
+func (h *stmtTestHandler) CloseConn(c *Conn) error {
+	return nil
+}
+
+func (h *stmtTestHandler) HandleStmtExecute(c *Conn, context interface{}, query string, args []interface{}) (*Result, error) {
+	h.args = append([]interface{}{}, args...)
+	return &Result{}, nil
+}
+
+func executePacket(id uint32, bound bool, types []byte, values []byte) []byte {
+	data := make([]byte, 4, 64)
+	binary.LittleEndian.PutUint32(data, id)
+	// flags, iteration count
+	data = append(data, 0, 1, 0, 0, 0)
+	// null bitmap of 2 params
+	data = append(data, 0)
+	if bound {
+		data = append(data, 1)
+		data = append(data, types...)
+	} else {
+		data = append(data, 0)
+	}
+	return append(data, values...)
+}
+
+func TestStmtExecuteBinaryParams(t *testing.T) {
+	h := &stmtTestHandler{}
+	c := &Conn{h: h, stmts: map[uint32]*Stmt{}}
+	st := &Stmt{ID: 1, Params: 2}
+	st.ResetParams()
+	c.stmts[1] = st
+
+	types := []byte{MYSQL_TYPE_DATETIME, 0, MYSQL_TYPE_TIME, 0}
+	// 2024-02-29 13:04:05
+	values := []byte{7, 0xe8, 0x07, 2, 29, 13, 4, 5}
+	// -1 day 02:03:04
+	values = append(values, 8, 1, 1, 0, 0, 0, 2, 3, 4)
+
+	if _, err := c.handleStmtExecute(executePacket(1, true, types, values)); err != nil {
+		t.Fatal(err)
+	}
+	if got := string(h.args[0].([]byte)); got != "2024-02-29 13:04:05" {
+		t.Errorf("datetime param: %q", got)
+	}
+	if got := string(h.args[1].([]byte)); got != "-26:03:04" {
+		t.Errorf("time param: %q", got)
+	}
+
+	// the types are only sent on the first execution
+	if _, err := c.handleStmtExecute(executePacket(1, false, nil, values)); err != nil {
+		t.Fatal(err)
+	}
+	if got := string(h.args[0].([]byte)); got != "2024-02-29 13:04:05" {
+		t.Errorf("datetime param of the second execution: %q", got)
+	}
+}
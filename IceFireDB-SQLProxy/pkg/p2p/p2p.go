@@ -9,19 +9,17 @@ import (
 	"sync/atomic"
 	"time"
 
+	"github.com/IceFireDB/IceFireDB/IceFireDB-SQLite/pkg/p2p/security"
 	"github.com/ipfs/go-cid"
 	"github.com/libp2p/go-libp2p"
 	dht "github.com/libp2p/go-libp2p-kad-dht"
 	pubsub "github.com/libp2p/go-libp2p-pubsub"
-	"github.com/libp2p/go-libp2p/core/crypto"
 	"github.com/libp2p/go-libp2p/core/host"
 	"github.com/libp2p/go-libp2p/core/peer"
 	"github.com/libp2p/go-libp2p/core/routing"
 	discoveryRouting "github.com/libp2p/go-libp2p/p2p/discovery/routing"
 	"github.com/libp2p/go-libp2p/p2p/muxer/yamux"
 	"github.com/libp2p/go-libp2p/p2p/net/connmgr"
-	"github.com/libp2p/go-libp2p/p2p/security/noise"
-	libp2ptls "github.com/libp2p/go-libp2p/p2p/security/tls"
 	"github.com/mr-tron/base58/base58"
 	"github.com/multiformats/go-multihash"
 	"github.com/sirupsen/logrus"
@@ -48,6 +46,10 @@ type P2P struct {
 	service string
 }
 
+// DefaultSecurityConfig is the security configuration used by NewP2P,
+// it must be set before the host is created
+var DefaultSecurityConfig = security.Default
+
 /*
 A constructor function that generates and returns a P2P object.
 
@@ -167,10 +169,8 @@ func (p2p *P2P) AnnounceConnect() {
 // libp2p host object for the given context. The created host is returned
 func setupHost(ctx context.Context, nodeHostIP string, nodeHostPort int) (host.Host, *dht.IpfsDHT) {
 	// Set up the host identity options
-	prvkey, pubkey, err := crypto.GenerateKeyPair(
-		crypto.Ed25519, // Select your key type. Ed25519 are nice short
-		-1,             // Select key length when possible (i.e. RSA).
-	)
+	// Select the key type allowed by the security configuration
+	prvkey, err := DefaultSecurityConfig.GenerateIdentity()
 
 	// Handle any potential error
 	if err != nil {
@@ -179,8 +179,6 @@ func setupHost(ctx context.Context, nodeHostIP string, nodeHostPort int) (host.H
 		}).Fatalln("Failed to Generate P2P Identity Configuration!")
 	}
 
-	_ = pubkey
-
 	// identity := libp2p.Identity(prvkey)
 
 	// // Trace log
@@ -289,13 +287,12 @@ func setupHost(ctx context.Context, nodeHostIP string, nodeHostPort int) (host.H
 			tcpListenAddress,  // regular tcp connections
 			quicListenAddress, // a UDP endpoint for the QUIC transport
 		),
-		// support TLS connections
-		libp2p.Security(libp2ptls.ID, libp2ptls.New),
-		// support noise connections
-		libp2p.Security(noise.ID, noise.New),
+		// support TLS and noise connections, in the configured order
+		security.Option(DefaultSecurityConfig),
 		libp2p.Muxer("/yamux/1.0.0", yamux.DefaultTransport),
-		// support any other default transports (TCP)
-		libp2p.DefaultTransports,
+		// support any other default transports (TCP), without WebRTC in
+		// FIPS mode
+		security.TransportOption(DefaultSecurityConfig),
 		// Let's prevent our peer from having too many
 		// connections by attaching a connection manager.
 		libp2p.ConnectionManager(connmgr),
@@ -320,6 +317,7 @@ func setupHost(ctx context.Context, nodeHostIP string, nodeHostPort int) (host.H
 	if err != nil {
 		panic(err)
 	}
+	security.Guard(h2, DefaultSecurityConfig)
 	//defer h2.Close()
 
 	// Return the created host and the kademlia DHT
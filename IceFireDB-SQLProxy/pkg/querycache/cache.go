@@ -0,0 +1,130 @@
+// Package querycache caches the results of the SELECT statements until they
+// expire or a write to one of their tables invalidates them
+package querycache
+
+import (
+	"sync"
+	"time"
+
+	"github.com/IceFireDB/IceFireDB/IceFireDB-SQLProxy/pkg/mysql/mysql"
+	lru "github.com/hashicorp/golang-lru/v2"
+)
+
+// Cache is a LRU cache of results. The results are invalidated with a
+// version per table, bumped by the writes, so an invalidation costs the same
+// whatever the number of results.
+type Cache struct {
+	entries *lru.Cache[string, *entry]
+	ttl     time.Duration
+	maxRows int
+
+	mu       sync.Mutex
+	versions map[string]uint64
+	// Bumped by the writes to unknown tables
+	global uint64
+}
+
+type entry struct {
+	res      *mysql.Result
+	expires  time.Time
+	tables   []string
+	versions []uint64
+	global   uint64
+}
+
+// Snapshot is the versions of the tables of a query, taken before the query
+// runs, so a write committed meanwhile invalidates the result put
+type Snapshot struct {
+	tables   []string
+	versions []uint64
+	global   uint64
+}
+
+// New creates a cache of size results that expire after ttl. The results of
+// more than maxRows rows are not cached.
+func New(size int, ttl time.Duration, maxRows int) (*Cache, error) {
+	entries, err := lru.New[string, *entry](size)
+	if err != nil {
+		return nil, err
+	}
+	return &Cache{
+		entries:  entries,
+		ttl:      ttl,
+		maxRows:  maxRows,
+		versions: make(map[string]uint64),
+	}, nil
+}
+
+// Get returns the result of a key, nil when it is not cached, expired or
+// invalidated
+func (c *Cache) Get(key string) *mysql.Result {
+	e, ok := c.entries.Get(key)
+	if !ok {
+		return nil
+	}
+	if time.Now().After(e.expires) || !c.valid(e.tables, e.versions, e.global) {
+		c.entries.Remove(key)
+		return nil
+	}
+	return e.res
+}
+
+// Snapshot returns the versions of the tables
+func (c *Cache) Snapshot(tables []string) Snapshot {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s := Snapshot{tables: tables, versions: make([]uint64, len(tables)), global: c.global}
+	for i, t := range tables {
+		s.versions[i] = c.versions[t]
+	}
+	return s
+}
+
+// Put caches the result of a query run after the snapshot. The result must
+// not be modified afterwards.
+func (c *Cache) Put(key string, s Snapshot, res *mysql.Result) {
+	if res == nil || res.Resultset == nil || len(res.RowDatas) > c.maxRows {
+		return
+	}
+	if !c.valid(s.tables, s.versions, s.global) {
+		return
+	}
+	c.entries.Add(key, &entry{
+		res:      res,
+		expires:  time.Now().Add(c.ttl),
+		tables:   s.tables,
+		versions: s.versions,
+		global:   s.global,
+	})
+}
+
+// Invalidate invalidates the results reading the tables, or every result
+// when the tables contain AllTables
+func (c *Cache) Invalidate(tables []string) {
+	if len(tables) == 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, t := range tables {
+		if t == AllTables {
+			c.global++
+			continue
+		}
+		c.versions[t]++
+	}
+}
+
+func (c *Cache) valid(tables []string, versions []uint64, global uint64) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if global != c.global {
+		return false
+	}
+	for i, t := range tables {
+		if c.versions[t] != versions[i] {
+			return false
+		}
+	}
+	return true
+}
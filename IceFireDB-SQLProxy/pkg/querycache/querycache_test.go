@@ -0,0 +1,107 @@
+package querycache
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/IceFireDB/IceFireDB/IceFireDB-SQLProxy/pkg/mysql/mysql"
+)
+
+func TestReadTables(t *testing.T) {
+	for sql, want := range map[string][]string{
+		"SELECT * FROM t WHERE id = 1":                                  {"db.t"},
+		"SELECT a.x FROM `T1` a, other.t2 AS b JOIN t3 ON t3.id = a.id": {"db.t1", "other.t2", "db.t3"},
+		"SELECT * FROM t WHERE id IN (SELECT id FROM u) -- FROM v":      {"db.t", "db.u"},
+		"SELECT 'FROM x' FROM t LEFT JOIN u USING (id)":                 {"db.t", "db.u"},
+		"SELECT 1": nil,
+	} {
+		got, ok := ReadTables("db", sql)
+		if !ok || !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: %v %v", sql, got, ok)
+		}
+	}
+	for _, sql := range []string{
+		"SELECT * FROM (SELECT * FROM t) d",
+		"SELECT * FROM information_schema.TABLES",
+	} {
+		if _, ok := ReadTables("db", sql); ok {
+			t.Errorf("%s is cacheable", sql)
+		}
+	}
+}
+
+func TestWriteTables(t *testing.T) {
+	for sql, want := range map[string][]string{
+		"INSERT INTO t (a) VALUES (1)":                 {"db.t"},
+		"INSERT IGNORE t SELECT * FROM u":              {"db.t", "db.u"},
+		"UPDATE LOW_PRIORITY x.t SET a = 1":            {"x.t"},
+		"UPDATE t JOIN u ON t.id = u.id SET t.a = u.a": {"db.t", "db.u"},
+		"DELETE FROM t WHERE id = 1":                   {"db.t"},
+		"DELETE t FROM t JOIN u ON t.id = u.id":        {"db.t", "db.t", "db.u"},
+		"ALTER TABLE t ADD c INT":                      {AllTables},
+		"CALL p()":                                     {AllTables},
+		"SELECT * FROM t":                              nil,
+		"COMMIT":                                       nil,
+	} {
+		if got := WriteTables("db", sql); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: %v", sql, got)
+		}
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	a := Normalize("SELECT  *\n  FROM t /* dashboard */ WHERE a = 'x  y';")
+	if a != "SELECT * FROM t WHERE a = 'x  y'" {
+		t.Fatalf("normalize %q", a)
+	}
+	if Key("db", "SELECT 1", false, nil) == Key("db", "SELECT 1", true, nil) {
+		t.Fatal("text and binary results share a key")
+	}
+	if Key("db", "SELECT ?", true, []interface{}{int64(1)}) == Key("db", "SELECT ?", true, []interface{}{"1"}) {
+		t.Fatal("arguments of different types share a key")
+	}
+}
+
+func TestCache(t *testing.T) {
+	c, err := New(16, time.Minute, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := &mysql.Result{Resultset: &mysql.Resultset{}}
+
+	s := c.Snapshot([]string{"db.t"})
+	c.Put("a", s, res)
+	if c.Get("a") != res {
+		t.Fatal("not cached")
+	}
+	c.Invalidate([]string{"db.u"})
+	if c.Get("a") != res {
+		t.Fatal("invalidated by another table")
+	}
+	c.Invalidate([]string{"db.t"})
+	if c.Get("a") != nil {
+		t.Fatal("not invalidated")
+	}
+
+	// a write committed while the query runs
+	s = c.Snapshot([]string{"db.t"})
+	c.Invalidate([]string{"db.t"})
+	c.Put("a", s, res)
+	if c.Get("a") != nil {
+		t.Fatal("stale result cached")
+	}
+
+	c.Put("b", c.Snapshot(nil), res)
+	c.Invalidate([]string{AllTables})
+	if c.Get("b") != nil {
+		t.Fatal("not invalidated by an unknown write")
+	}
+
+	c, _ = New(16, time.Millisecond, 10)
+	c.Put("a", c.Snapshot(nil), res)
+	time.Sleep(2 * time.Millisecond)
+	if c.Get("a") != nil {
+		t.Fatal("expired result returned")
+	}
+}
@@ -0,0 +1,313 @@
+package querycache
+
+import (
+	"fmt"
+	"strings"
+)
+
+// AllTables is the table of a write whose tables are not known, which
+// invalidates every result
+const AllTables = "*"
+
+type token struct {
+	text string
+	// Tells a `quoted` name
+	quoted bool
+}
+
+// word returns the upper case keyword of an unquoted token
+func (t token) word() string {
+	if t.quoted {
+		return ""
+	}
+	return strings.ToUpper(t.text)
+}
+
+func isWordByte(c byte) bool {
+	return c == '_' || c == '$' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= 0x80
+}
+
+// tokenize splits a statement into words, quoted names and punctuation. The
+// strings are replaced by ' and the comments are skipped.
+func tokenize(sql string) []token {
+	var tokens []token
+	for i := 0; i < len(sql); {
+		c := sql[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '#' || c == '-' && strings.HasPrefix(sql[i:], "-- "):
+			for i < len(sql) && sql[i] != '\n' {
+				i++
+			}
+		case c == '/' && strings.HasPrefix(sql[i:], "/*"):
+			end := strings.Index(sql[i+2:], "*/")
+			if end < 0 {
+				return tokens
+			}
+			i += end + 4
+		case c == '\'' || c == '"' || c == '`':
+			j := i + 1
+			var b strings.Builder
+			for j < len(sql) {
+				if sql[j] == '\\' && c != '`' && j+1 < len(sql) {
+					b.WriteByte(sql[j+1])
+					j += 2
+					continue
+				}
+				if sql[j] == c {
+					if j+1 < len(sql) && sql[j+1] == c {
+						b.WriteByte(c)
+						j += 2
+						continue
+					}
+					break
+				}
+				b.WriteByte(sql[j])
+				j++
+			}
+			if c == '`' {
+				tokens = append(tokens, token{text: b.String(), quoted: true})
+			} else {
+				tokens = append(tokens, token{text: "'"})
+			}
+			i = j + 1
+		case isWordByte(c):
+			j := i
+			for j < len(sql) && isWordByte(sql[j]) {
+				j++
+			}
+			tokens = append(tokens, token{text: sql[i:j]})
+			i = j
+		default:
+			tokens = append(tokens, token{text: sql[i : i+1]})
+			i++
+		}
+	}
+	return tokens
+}
+
+// The keywords ending a table reference, which are not an alias
+var refEnd = map[string]bool{
+	"WHERE": true, "JOIN": true, "ON": true, "USING": true, "GROUP": true, "ORDER": true, "LIMIT": true,
+	"LEFT": true, "RIGHT": true, "INNER": true, "OUTER": true, "CROSS": true, "NATURAL": true, "STRAIGHT_JOIN": true,
+	"UNION": true, "EXCEPT": true, "INTERSECT": true, "HAVING": true, "WINDOW": true, "FOR": true, "LOCK": true,
+	"INTO": true, "PARTITION": true, "USE": true, "IGNORE": true, "FORCE": true, "SET": true, "VALUES": true,
+	"VALUE": true, "SELECT": true, "FROM": true, "AS": true,
+}
+
+// The schemas whose content is the state of the server
+var systemSchemas = map[string]bool{
+	"information_schema": true,
+	"performance_schema": true,
+	"mysql":              true,
+	"sys":                true,
+}
+
+// parser reads the table references of a statement
+type parser struct {
+	tokens []token
+	db     string
+	tables []string
+	// Tells a table reference is not a table name
+	derived bool
+}
+
+func (p *parser) word(i int) string {
+	if i < len(p.tokens) {
+		return p.tokens[i].word()
+	}
+	return ""
+}
+
+func (p *parser) text(i int) string {
+	if i < len(p.tokens) {
+		return p.tokens[i].text
+	}
+	return ""
+}
+
+// refs reads the comma separated table references starting at i
+func (p *parser) refs(i int, list bool) int {
+	for i < len(p.tokens) {
+		if p.text(i) == "(" {
+			p.derived = true
+			return i
+		}
+		t := p.tokens[i]
+		if !t.quoted && !isWordByte(t.text[0]) {
+			return i
+		}
+		db, name := p.db, t.text
+		i++
+		if p.text(i) == "." && i+1 < len(p.tokens) {
+			db, name = t.text, p.tokens[i+1].text
+			i += 2
+		}
+		p.tables = append(p.tables, strings.ToLower(db+"."+name))
+		// alias
+		if p.word(i) == "AS" {
+			i += 2
+		} else if i < len(p.tokens) && (p.tokens[i].quoted || isWordByte(p.text(i)[0]) && !refEnd[p.word(i)]) {
+			i++
+		}
+		if !list || p.text(i) != "," {
+			return i
+		}
+		i++
+	}
+	return i
+}
+
+// ReadTables returns the tables a SELECT reads, as lower case database.table.
+// ok is false when the statement reads a derived table or a system schema,
+// whose results are not cached.
+func ReadTables(db string, sql string) (tables []string, ok bool) {
+	p := &parser{tokens: tokenize(sql), db: db}
+	for i := 0; i < len(p.tokens); i++ {
+		switch p.tokens[i].word() {
+		case "FROM":
+			i = p.refs(i+1, true) - 1
+		case "JOIN", "STRAIGHT_JOIN":
+			i = p.refs(i+1, false) - 1
+		}
+		if p.derived {
+			return nil, false
+		}
+	}
+	for _, t := range p.tables {
+		if systemSchemas[t[:strings.IndexByte(t, '.')]] {
+			return nil, false
+		}
+	}
+	return p.tables, true
+}
+
+// The statements that write no table
+var readOnly = []string{
+	"SELECT", "SHOW", "SET", "USE", "DESC", "DESCRIBE", "EXPLAIN", "HELP", "DO",
+	"BEGIN", "START", "COMMIT", "END", "ROLLBACK", "SAVEPOINT", "RELEASE", "XA",
+}
+
+// The modifiers between the keyword of a write and its table
+var writeModifiers = map[string]bool{
+	"LOW_PRIORITY": true, "DELAYED": true, "HIGH_PRIORITY": true, "QUICK": true, "IGNORE": true, "INTO": true,
+}
+
+// WriteTables returns the tables a statement writes, as lower case
+// database.table, nil when it writes none and AllTables when they are not
+// known. The tables it reads may be returned too.
+func WriteTables(db string, sql string) []string {
+	p := &parser{tokens: tokenize(sql), db: db}
+	first := p.word(0)
+	for _, v := range readOnly {
+		if first == v {
+			return nil
+		}
+	}
+	switch first {
+	case "INSERT", "REPLACE", "UPDATE", "DELETE":
+	default:
+		// the DDL, CALL, LOAD DATA...
+		return []string{AllTables}
+	}
+
+	i := 1
+	for writeModifiers[p.word(i)] {
+		i++
+	}
+	if p.word(i) != "FROM" {
+		p.refs(i, true)
+	}
+	for ; i < len(p.tokens); i++ {
+		switch p.tokens[i].word() {
+		case "FROM", "USING":
+			p.refs(i+1, true)
+		case "JOIN", "STRAIGHT_JOIN":
+			p.refs(i+1, false)
+		}
+	}
+	if len(p.tables) == 0 {
+		return []string{AllTables}
+	}
+	return p.tables
+}
+
+// Normalize returns the statement with the comments removed and the spaces
+// collapsed, so the same query sent differently shares a key
+func Normalize(sql string) string {
+	var b strings.Builder
+	space := false
+	for i := 0; i < len(sql); {
+		c := sql[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			space = b.Len() > 0
+			i++
+			continue
+		case c == '#' || c == '-' && strings.HasPrefix(sql[i:], "-- "):
+			for i < len(sql) && sql[i] != '\n' {
+				i++
+			}
+			space = b.Len() > 0
+			continue
+		case c == '/' && strings.HasPrefix(sql[i:], "/*") && !strings.HasPrefix(sql[i:], "/*!"):
+			end := strings.Index(sql[i+2:], "*/")
+			if end < 0 {
+				i = len(sql)
+			} else {
+				i += end + 4
+			}
+			space = b.Len() > 0
+			continue
+		}
+		if space {
+			b.WriteByte(' ')
+			space = false
+		}
+		if c == '\'' || c == '"' || c == '`' {
+			// the literals are kept as is
+			j := i + 1
+			for j < len(sql) {
+				if sql[j] == '\\' && c != '`' {
+					j += 2
+					continue
+				}
+				if sql[j] == c {
+					if j+1 < len(sql) && sql[j+1] == c {
+						j += 2
+						continue
+					}
+					break
+				}
+				j++
+			}
+			if j >= len(sql) {
+				j = len(sql) - 1
+			}
+			b.WriteString(sql[i : j+1])
+			i = j + 1
+			continue
+		}
+		b.WriteByte(c)
+		i++
+	}
+	return strings.TrimRight(b.String(), "; ")
+}
+
+// Key returns the cache key of a query of the database, with the arguments
+// of a prepared statement, whose results are in the binary protocol
+func Key(db string, sql string, prepared bool, args []interface{}) string {
+	var b strings.Builder
+	if prepared {
+		b.WriteString("stmt")
+	}
+	b.WriteByte(0)
+	b.WriteString(db)
+	b.WriteByte(0)
+	b.WriteString(Normalize(sql))
+	for _, arg := range args {
+		fmt.Fprintf(&b, "\x00%T:%v", arg, arg)
+	}
+	return b.String()
+}
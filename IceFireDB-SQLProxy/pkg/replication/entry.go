@@ -0,0 +1,94 @@
+package replication
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// Entry represents a transaction of the replicated log. The entries of a
+// writer are numbered from 1 without gaps, so Source:Seq identifies an entry
+// like a GTID of MySQL.
+type Entry struct {
+	// Source is the peer ID of the writer
+	Source string `json:"source"`
+	// Seq is the sequence number of the transaction on the writer
+	Seq uint64 `json:"seq"`
+	Ops []Op   `json:"ops"`
+	// After is the barrier of the entry: the last sequence numbers of the
+	// other writers applied by the writer, which every node applies first
+	After map[string]uint64 `json:"after,omitempty"`
+}
+
+// Resend asks the writer Source to publish its entries From..To again
+type Resend struct {
+	Source string `json:"source"`
+	From   uint64 `json:"from"`
+	To     uint64 `json:"to"`
+}
+
+// Head announces the last sequence number of the writer Source, so the peers
+// that missed the latest entries request them even when the writer is idle
+type Head struct {
+	Source string `json:"source"`
+	Seq    uint64 `json:"seq"`
+}
+
+// Message is a message of the replication topic. Exactly one field is set.
+type Message struct {
+	Entry  *Entry
+	Resend *Resend
+	Head   *Head
+	// Op is a statement published as plain SQL by the previous versions
+	Op *Op
+}
+
+type wireMessage struct {
+	*Entry
+	Resend *Resend `json:"resend,omitempty"`
+	Head   *Head   `json:"head,omitempty"`
+}
+
+// EncodeEntry encodes an entry for publishing
+func EncodeEntry(e Entry) (string, error) {
+	data, err := json.Marshal(wireMessage{Entry: &e})
+	return string(data), err
+}
+
+// EncodeResend encodes a resend request for publishing
+func EncodeResend(r Resend) (string, error) {
+	data, err := json.Marshal(wireMessage{Resend: &r})
+	return string(data), err
+}
+
+// EncodeHead encodes a head announcement for publishing
+func EncodeHead(h Head) (string, error) {
+	data, err := json.Marshal(wireMessage{Head: &h})
+	return string(data), err
+}
+
+// Decode decodes a message of the replication topic. Plain SQL and the
+// single statement entries of the previous versions are returned as an Op.
+func Decode(msg string) (Message, error) {
+	if !strings.HasPrefix(strings.TrimSpace(msg), "{") {
+		return Message{Op: &Op{SQL: msg}}, nil
+	}
+	var w struct {
+		Entry
+		SQL    string  `json:"sql"`
+		Args   []Value `json:"args"`
+		Resend *Resend `json:"resend"`
+		Head   *Head   `json:"head"`
+	}
+	if err := json.Unmarshal([]byte(msg), &w); err != nil {
+		return Message{}, err
+	}
+	switch {
+	case w.Resend != nil:
+		return Message{Resend: w.Resend}, nil
+	case w.Head != nil:
+		return Message{Head: w.Head}, nil
+	case w.Source == "" && w.SQL != "":
+		return Message{Op: &Op{SQL: w.SQL, Args: w.Args}}, nil
+	}
+	return Message{Entry: &w.Entry}, nil
+}
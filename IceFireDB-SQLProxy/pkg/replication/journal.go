@@ -0,0 +1,61 @@
+package replication
+
+import "sync"
+
+// Journal numbers the transactions of a writer and keeps the most recent
+// ones to answer the resend requests of the peers.
+type Journal struct {
+	mu      sync.Mutex
+	source  string
+	seq     uint64
+	entries []Entry
+	size    int
+}
+
+// NewJournal creates a journal of the writer source keeping size entries
+func NewJournal(source string, size int) *Journal {
+	if size <= 0 {
+		size = 1
+	}
+	return &Journal{source: source, size: size}
+}
+
+// Source returns the writer of the journal
+func (j *Journal) Source() string {
+	return j.source
+}
+
+// Append assigns the next sequence number to a transaction and keeps it.
+// after is the barrier of the transaction, nil when it has none.
+func (j *Journal) Append(ops []Op, after map[string]uint64) Entry {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.seq++
+	e := Entry{Source: j.source, Seq: j.seq, Ops: ops, After: after}
+	if len(j.entries) == j.size {
+		copy(j.entries, j.entries[1:])
+		j.entries = j.entries[:len(j.entries)-1]
+	}
+	j.entries = append(j.entries, e)
+	return e
+}
+
+// Range returns the kept entries with a sequence number in from..to
+func (j *Journal) Range(from, to uint64) []Entry {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	var entries []Entry
+	for _, e := range j.entries {
+		if e.Seq >= from && e.Seq <= to {
+			entries = append(entries, e)
+		}
+	}
+	return entries
+}
+
+// Head returns the last sequence number of the writer
+func (j *Journal) Head() Head {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return Head{Source: j.source, Seq: j.seq}
+}
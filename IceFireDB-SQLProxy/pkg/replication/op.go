@@ -0,0 +1,101 @@
+package replication
+
+import (
+	"fmt"
+)
+
+// Op represents a statement of the replicated log
+type Op struct {
+	SQL string `json:"sql"`
+	// Arguments of the placeholders of a prepared statement
+	Args []Value `json:"args,omitempty"`
+
+	// The PostgreSQL statements of IceFireDB-PGProxy keep their parameters
+	// as the client bound them, for the extended query protocol.
+	// Types are the parameter type OIDs of a prepared statement, 0 lets the
+	// backend infer the type
+	Types []uint32 `json:"types,omitempty"`
+	// Formats are the parameter format codes bound by the client: none for all
+	// text, one for all the parameters, or one per parameter
+	Formats []int16 `json:"formats,omitempty"`
+	// Params are the parameter values as sent by the client, nil for a NULL
+	Params [][]byte `json:"params,omitempty"`
+}
+
+// Extended tells whether the PostgreSQL statement is run with the extended
+// query protocol, a simple query may hold several statements
+func (op Op) Extended() bool {
+	return len(op.Types) > 0 || len(op.Formats) > 0 || len(op.Params) > 0
+}
+
+// Value represents an argument of a statement, a NULL when no field is set
+type Value struct {
+	Int   *int64   `json:"i,omitempty"`
+	Uint  *uint64  `json:"u,omitempty"`
+	Float *float64 `json:"f,omitempty"`
+	Bytes *[]byte  `json:"b,omitempty"`
+}
+
+// EncodeArgs converts the arguments bound by a client to the values of the log
+func EncodeArgs(args []interface{}) ([]Value, error) {
+	values := make([]Value, len(args))
+	for i, arg := range args {
+		switch v := arg.(type) {
+		case nil:
+		case int8:
+			n := int64(v)
+			values[i].Int = &n
+		case int16:
+			n := int64(v)
+			values[i].Int = &n
+		case int32:
+			n := int64(v)
+			values[i].Int = &n
+		case int64:
+			values[i].Int = &v
+		case uint8:
+			n := uint64(v)
+			values[i].Uint = &n
+		case uint16:
+			n := uint64(v)
+			values[i].Uint = &n
+		case uint32:
+			n := uint64(v)
+			values[i].Uint = &n
+		case uint64:
+			values[i].Uint = &v
+		case float32:
+			f := float64(v)
+			values[i].Float = &f
+		case float64:
+			values[i].Float = &v
+		case []byte:
+			b := append([]byte{}, v...)
+			values[i].Bytes = &b
+		case string:
+			b := []byte(v)
+			values[i].Bytes = &b
+		default:
+			return nil, fmt.Errorf("argument %d: unsupported type %T", i, arg)
+		}
+	}
+	return values, nil
+}
+
+// Arguments returns the arguments of the statement
+func (op Op) Arguments() []interface{} {
+	args := make([]interface{}, len(op.Args))
+	for i, v := range op.Args {
+		switch {
+		case v.Int != nil:
+			args[i] = *v.Int
+		case v.Uint != nil:
+			args[i] = *v.Uint
+		case v.Float != nil:
+			args[i] = *v.Float
+		case v.Bytes != nil:
+			args[i] = *v.Bytes
+		}
+	}
+	return args
+}
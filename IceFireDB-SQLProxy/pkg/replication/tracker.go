@@ -0,0 +1,205 @@
+package replication
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// resendEvery is the number of entries held behind a gap or a barrier between two resend
+// requests of the gap
+const resendEvery = 64
+
+// Tracker orders the entries received from the writers. It drops the entries
+// already applied, holds the entries received after a gap until the missing
+// ones are resent, and holds the entries behind a barrier until the entries
+// of the other writers it waits for are applied.
+type Tracker struct {
+	mu         sync.Mutex
+	local      string
+	load       func(source string) (uint64, error)
+	applied    map[string]uint64
+	pending    map[string]map[uint64]Entry
+	maxPending int
+}
+
+// NewTracker creates a tracker of the node local. load returns the last
+// sequence number of a writer applied by the node, maxPending is the number
+// of entries held behind a gap or a barrier before it is skipped.
+func NewTracker(local string, load func(source string) (uint64, error), maxPending int) *Tracker {
+	return &Tracker{
+		local:      local,
+		load:       load,
+		applied:    make(map[string]uint64),
+		pending:    make(map[string]map[uint64]Entry),
+		maxPending: maxPending,
+	}
+}
+
+// position returns the last sequence number of the writer applied by the node
+func (t *Tracker) position(source string) (uint64, error) {
+	if pos, ok := t.applied[source]; ok {
+		return pos, nil
+	}
+	pos, err := t.load(source)
+	if err != nil {
+		return 0, err
+	}
+	t.applied[source] = pos
+	return pos, nil
+}
+
+// Positions returns the last sequence numbers applied by the node, the
+// barrier of the entries that must follow them on every node
+func (t *Tracker) Positions() map[string]uint64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	positions := make(map[string]uint64, len(t.applied))
+	for source, pos := range t.applied {
+		if pos > 0 {
+			positions[source] = pos
+		}
+	}
+	return positions
+}
+
+// Receive returns the entries to apply in order after e is received, and a
+// request for the missing entries when e is received after a gap or behind
+// a barrier. The returned entries are considered applied.
+func (t *Tracker) Receive(e Entry) ([]Entry, *Resend, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	pos, err := t.position(e.Source)
+	if err != nil {
+		return nil, nil, err
+	}
+	pending := t.pending[e.Source]
+	if e.Seq <= pos {
+		return nil, nil, nil
+	}
+	if _, ok := pending[e.Seq]; ok {
+		return nil, nil, nil
+	}
+	if pending == nil {
+		pending = make(map[uint64]Entry)
+		t.pending[e.Source] = pending
+	}
+	pending[e.Seq] = e
+
+	ready := t.drain()
+	if len(pending) == 0 {
+		return ready, nil, nil
+	}
+	// the next entry is held behind its barrier, or missing
+	next, held := pending[t.applied[e.Source]+1]
+	if t.maxPending > 0 && len(pending) > t.maxPending {
+		if held {
+			logrus.Errorf("replication: pass the barrier of %s:%d, the entries it waits for were not resent", e.Source, next.Seq)
+			next.After = nil
+			pending[next.Seq] = next
+		} else {
+			first := t.first(e.Source)
+			logrus.Errorf("replication: skip entries %d..%d of %s not resent", t.applied[e.Source]+1, first-1, e.Source)
+			t.applied[e.Source] = first - 1
+		}
+		return append(ready, t.drain()...), nil, nil
+	}
+	if len(pending) != 1 && len(pending)%resendEvery != 0 {
+		return ready, nil, nil
+	}
+	if held {
+		return ready, t.barrierResend(next), nil
+	}
+	return ready, &Resend{Source: e.Source, From: t.applied[e.Source] + 1, To: t.first(e.Source) - 1}, nil
+}
+
+// barrierResend returns a request for the entries the barrier of e waits
+// for, of the first writer in order the node is behind
+func (t *Tracker) barrierResend(e Entry) *Resend {
+	sources := make([]string, 0, len(e.After))
+	for source := range e.After {
+		if source != t.local && source != e.Source {
+			sources = append(sources, source)
+		}
+	}
+	sort.Strings(sources)
+	for _, source := range sources {
+		pos, err := t.position(source)
+		if err == nil && pos < e.After[source] {
+			return &Resend{Source: source, From: pos + 1, To: e.After[source]}
+		}
+	}
+	return nil
+}
+
+// Head returns a request for the entries of the writer that the node missed,
+// given the last sequence number announced by the writer
+func (t *Tracker) Head(h Head) (*Resend, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	pos, err := t.position(h.Source)
+	if err != nil {
+		return nil, err
+	}
+	if h.Seq <= pos {
+		return nil, nil
+	}
+	return &Resend{Source: h.Source, From: pos + 1, To: h.Seq}, nil
+}
+
+// drain removes the entries that follow the applied positions, and whose
+// barrier is passed, from the pending entries
+func (t *Tracker) drain() []Entry {
+	var ready []Entry
+	for progress := true; progress; {
+		progress = false
+		for source, pending := range t.pending {
+			for {
+				e, ok := pending[t.applied[source]+1]
+				if !ok || !t.passed(e) {
+					break
+				}
+				delete(pending, e.Seq)
+				t.applied[source] = e.Seq
+				ready = append(ready, e)
+				progress = true
+			}
+		}
+	}
+	return ready
+}
+
+// passed tells whether the node applied the entries the barrier of e waits for
+func (t *Tracker) passed(e Entry) bool {
+	for source, seq := range e.After {
+		if source == t.local || source == e.Source {
+			continue
+		}
+		pos, err := t.position(source)
+		if err != nil || pos < seq {
+			return false
+		}
+	}
+	return true
+}
+
+// first returns the lowest sequence number of the pending entries of the writer
+func (t *Tracker) first(source string) uint64 {
+	var first uint64
+	for seq := range t.pending[source] {
+		if first == 0 || seq < first {
+			first = seq
+		}
+	}
+	return first
+}
+
+// Forget drops the position of the writer, so it is loaded again on the next
+// entry. It is called when the entries returned by Receive fail to apply.
+func (t *Tracker) Forget(source string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.applied, source)
+	delete(t.pending, source)
+}
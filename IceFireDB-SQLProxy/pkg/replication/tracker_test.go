@@ -0,0 +1,190 @@
+package replication
+
+import (
+	"testing"
+)
+
+func seqs(entries []Entry) []uint64 {
+	var s []uint64
+	for _, e := range entries {
+		s = append(s, e.Seq)
+	}
+	return s
+}
+
+func equalSeqs(a, b []uint64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestTrackerGapAndDuplicate(t *testing.T) {
+	tr := NewTracker("local", func(string) (uint64, error) { return 2, nil }, 0)
+	j := NewJournal("w", 16)
+	var entries []Entry
+	for i := 0; i < 6; i++ {
+		entries = append(entries, j.Append([]Op{{SQL: "INSERT INTO t VALUES (1)"}}, nil))
+	}
+
+	// applied before the restart
+	ready, resend, err := tr.Receive(entries[1])
+	if err != nil || len(ready) != 0 || resend != nil {
+		t.Fatalf("duplicate: %v %v %v", ready, resend, err)
+	}
+	ready, _, _ = tr.Receive(entries[2])
+	if !equalSeqs(seqs(ready), []uint64{3}) {
+		t.Fatalf("ready %v", seqs(ready))
+	}
+
+	ready, resend, _ = tr.Receive(entries[5])
+	if len(ready) != 0 {
+		t.Fatalf("ready after gap %v", seqs(ready))
+	}
+	if resend == nil || resend.Source != "w" || resend.From != 4 || resend.To != 5 {
+		t.Fatalf("resend %+v", resend)
+	}
+
+	// the writer resends 4..5 and the held entry follows
+	for _, e := range j.Range(resend.From, resend.To) {
+		got, _, _ := tr.Receive(e)
+		ready = append(ready, got...)
+	}
+	if !equalSeqs(seqs(ready), []uint64{4, 5, 6}) {
+		t.Fatalf("ready %v", seqs(ready))
+	}
+	if ready, _, _ = tr.Receive(entries[5]); len(ready) != 0 {
+		t.Fatalf("duplicate applied %v", seqs(ready))
+	}
+}
+
+func TestTrackerSkipGap(t *testing.T) {
+	tr := NewTracker("local", func(string) (uint64, error) { return 0, nil }, 2)
+	tr.Receive(Entry{Source: "w", Seq: 3})
+	tr.Receive(Entry{Source: "w", Seq: 4})
+	ready, _, _ := tr.Receive(Entry{Source: "w", Seq: 5})
+	if !equalSeqs(seqs(ready), []uint64{3, 4, 5}) {
+		t.Fatalf("ready %v", seqs(ready))
+	}
+}
+
+func TestTrackerBarrier(t *testing.T) {
+	tr := NewTracker("local", func(string) (uint64, error) { return 0, nil }, 0)
+	a := NewJournal("a", 16)
+	b := NewJournal("b", 16)
+
+	// b altered the table after applying a:1 and a:2
+	alter := b.Append([]Op{{SQL: "ALTER TABLE t ADD c INT"}}, map[string]uint64{"a": 2, "local": 5})
+	after := b.Append([]Op{{SQL: "INSERT INTO t (c) VALUES (1)"}}, nil)
+	// held behind the barrier, the entries of a are requested
+	ready, resend, _ := tr.Receive(alter)
+	if len(ready) != 0 || resend == nil || resend.Source != "a" || resend.From != 1 || resend.To != 2 {
+		t.Fatalf("ready %v resend %+v", seqs(ready), resend)
+	}
+	if ready, resend, _ = tr.Receive(after); len(ready) != 0 || resend != nil {
+		t.Fatalf("ready %v resend %+v", seqs(ready), resend)
+	}
+
+	ready, _, _ = tr.Receive(a.Append(nil, nil))
+	if len(ready) != 1 || ready[0].Source != "a" {
+		t.Fatalf("ready %v", ready)
+	}
+	ready, _, _ = tr.Receive(a.Append(nil, nil))
+	if len(ready) != 3 || ready[0].Source != "a" || ready[1].Seq != alter.Seq || ready[2].Seq != after.Seq {
+		t.Fatalf("ready %v", ready)
+	}
+	if got := tr.Positions(); got["a"] != 2 || got["b"] != 2 {
+		t.Fatalf("positions %v", got)
+	}
+}
+
+func TestTrackerBarrierResend(t *testing.T) {
+	tr := NewTracker("local", func(string) (uint64, error) { return 0, nil }, 3)
+	b := NewJournal("b", 16)
+
+	// a:1 and a:2 were lost, b asks for them behind its barrier
+	alter := b.Append([]Op{{SQL: "ALTER TABLE t ADD c INT"}}, map[string]uint64{"a": 2, "c": 0})
+	ready, resend, _ := tr.Receive(alter)
+	if len(ready) != 0 || resend == nil || resend.Source != "a" || resend.From != 1 || resend.To != 2 {
+		t.Fatalf("ready %v resend %+v", seqs(ready), resend)
+	}
+	for i := 0; i < 2; i++ {
+		if ready, resend, _ = tr.Receive(b.Append(nil, nil)); len(ready) != 0 || resend != nil {
+			t.Fatalf("ready %v resend %+v", seqs(ready), resend)
+		}
+	}
+	// past maxPending, the barrier is passed
+	ready, _, _ = tr.Receive(b.Append(nil, nil))
+	if !equalSeqs(seqs(ready), []uint64{1, 2, 3, 4}) {
+		t.Fatalf("ready %v", seqs(ready))
+	}
+}
+
+func TestTrackerHead(t *testing.T) {
+	tr := NewTracker("local", func(string) (uint64, error) { return 4, nil }, 0)
+	if r, _ := tr.Head(Head{Source: "w", Seq: 4}); r != nil {
+		t.Fatalf("resend %+v", r)
+	}
+	r, _ := tr.Head(Head{Source: "w", Seq: 7})
+	if r == nil || r.From != 5 || r.To != 7 {
+		t.Fatalf("resend %+v", r)
+	}
+}
+
+func TestJournalRange(t *testing.T) {
+	j := NewJournal("w", 2)
+	for i := 0; i < 4; i++ {
+		j.Append(nil, nil)
+	}
+	if got := seqs(j.Range(1, 4)); !equalSeqs(got, []uint64{3, 4}) {
+		t.Fatalf("range %v", got)
+	}
+}
+
+func TestDecode(t *testing.T) {
+	m, err := Decode("INSERT INTO t VALUES (1)")
+	if err != nil || m.Op == nil || m.Op.SQL != "INSERT INTO t VALUES (1)" {
+		t.Fatalf("plain sql %+v %v", m, err)
+	}
+	m, err = Decode(`{"sql":"INSERT INTO t VALUES (?)","args":[{"i":1}]}`)
+	if err != nil || m.Op == nil || len(m.Op.Args) != 1 {
+		t.Fatalf("op %+v %v", m, err)
+	}
+
+	one := int64(1)
+	msg, _ := EncodeEntry(Entry{Source: "w", Seq: 1, Ops: []Op{{SQL: "INSERT INTO t VALUES (?)", Args: []Value{{Int: &one}}}}})
+	m, err = Decode(msg)
+	if err != nil || m.Entry == nil || m.Entry.Seq != 1 || *m.Entry.Ops[0].Args[0].Int != 1 {
+		t.Fatalf("entry %s %+v %v", msg, m, err)
+	}
+	msg, _ = EncodeEntry(Entry{Source: "w", Seq: 2, Ops: []Op{{
+		SQL:     "INSERT INTO t VALUES ($1, $2)",
+		Types:   []uint32{23, 25},
+		Formats: []int16{0},
+		Params:  [][]byte{[]byte("1"), nil},
+	}}})
+	m, err = Decode(msg)
+	if err != nil || m.Entry == nil || m.Entry.Seq != 2 {
+		t.Fatalf("pg entry %s %+v %v", msg, m, err)
+	}
+	op := m.Entry.Ops[0]
+	if !op.Extended() || string(op.Params[0]) != "1" || op.Params[1] != nil || op.Types[1] != 25 {
+		t.Fatalf("pg op %s %+v", msg, op)
+	}
+	if (Op{SQL: "SELECT 1"}).Extended() {
+		t.Fatal("simple query extended")
+	}
+	msg, _ = EncodeResend(Resend{Source: "w", From: 1, To: 2})
+	if m, _ = Decode(msg); m.Resend == nil || m.Resend.To != 2 {
+		t.Fatalf("resend %s %+v", msg, m)
+	}
+	msg, _ = EncodeHead(Head{Source: "w", Seq: 3})
+	if m, _ = Decode(msg); m.Head == nil || m.Head.Seq != 3 {
+		t.Fatalf("head %s %+v", msg, m)
+	}
+}
@@ -6,17 +6,26 @@ import (
 	"strings"
 
 	"github.com/IceFireDB/IceFireDB/IceFireDB-SQLite/internal/sqlite"
-	"github.com/IceFireDB/IceFireDB/IceFireDB-SQLite/pkg/mysql/client"
 	"github.com/IceFireDB/IceFireDB/IceFireDB-SQLite/pkg/mysql/mysql"
 	"github.com/IceFireDB/IceFireDB/IceFireDB-SQLite/pkg/mysql/server"
 )
 
+// CloseConn rolls back the open transaction of the connection
 func (h *mysqlProxy) CloseConn(c *server.Conn) error {
-	if c.IsInTransaction() || c.IsAutoCommit() {
-		_, err := sqlite.Exec("COMMIT")
-		return err
+	h.pLock.Lock()
+	s, ok := h.sessions[c.ConnectionID()]
+	delete(h.sessions, c.ConnectionID())
+	h.pLock.Unlock()
+	if !ok {
+		return nil
 	}
-	return nil
+	return s.Close()
+}
+
+func (h *mysqlProxy) session(c *server.Conn) *sqlite.Session {
+	h.pLock.RLock()
+	defer h.pLock.RUnlock()
+	return h.sessions[c.ConnectionID()]
 }
 
 func (h *mysqlProxy) UseDB(c *server.Conn, dbName string) error {
@@ -38,13 +47,17 @@ func (h *mysqlProxy) HandleQuery(c *server.Conn, query string) (res *mysql.Resul
 	if strings.ToUpper(query) == "SELECT NOW()" {
 		query = "SELECT STRFTIME('%Y-%m-%d %H:%M:%S','now') AS 'NOW()'"
 	}
-	//if strings.ToUpper(query) == "START TRANSACTION" {
-	//	res = &mysql.Result{
-	//		Status: 2,
-	//	}
-	//	return
-	//}
-	return sqlite.Exec(query)
+	s := h.session(c)
+	if s == nil {
+		return nil, errors.New("connection has no session")
+	}
+	res, err = s.Exec(query)
+	if s.InTransaction() {
+		c.SetInTransaction()
+	} else {
+		c.ClearInTransaction()
+	}
+	return
 }
 
 func (h *mysqlProxy) HandleFieldList(c *server.Conn, table string, fieldWildcard string) ([]*mysql.Field, error) {
@@ -52,35 +65,28 @@ func (h *mysqlProxy) HandleFieldList(c *server.Conn, table string, fieldWildcard
 	return nil, nil
 }
 
+// HandleStmtPrepare counts the placeholders of the statement, the columns
+// are described with the rows of each execution
 func (h *mysqlProxy) HandleStmtPrepare(c *server.Conn, query string) (int, int, interface{}, error) {
-	//stmt, err := h.db.Prepare(query)
-	//if err != nil {
-	//	return 0, 0, nil, err
-	//}
-	//return 1, 0, stmt, nil
-	return 0, 0, nil, nil
+	return sqlite.ParamCount(query), 0, query, nil
 }
 
 func (h *mysqlProxy) HandleStmtExecute(c *server.Conn, context interface{}, query string, args []interface{}) (*mysql.Result, error) {
-	stmt, ok := context.(*client.Stmt)
-	//stmt, ok := context.(*sql.Stmt)
-	if !ok {
-		return nil, errors.New("other error")
+	s := h.session(c)
+	if s == nil {
+		return nil, errors.New("connection has no session")
 	}
-	res, err := stmt.Execute(args...)
-	if err == nil {
-		sqlite.Broadcast(query)
+	res, err := s.ExecStmt(query, args)
+	if s.InTransaction() {
+		c.SetInTransaction()
+	} else {
+		c.ClearInTransaction()
 	}
 	return res, err
 }
 
 func (h *mysqlProxy) HandleStmtClose(c *server.Conn, context interface{}) error {
-	stmt, ok := context.(*client.Stmt)
-	if !ok {
-		return errors.New("other error")
-	}
-	return stmt.Close()
-	//return nil
+	return nil
 }
 
 func (h *mysqlProxy) HandleOtherCommand(c *server.Conn, cmd byte, data []byte) error {
@@ -8,6 +8,7 @@ import (
 	"runtime"
 	"sync"
 
+	"github.com/IceFireDB/IceFireDB/IceFireDB-SQLite/internal/sqlite"
 	"github.com/IceFireDB/IceFireDB/IceFireDB-SQLite/pkg/mysql/server"
 
 	"github.com/sirupsen/logrus"
@@ -21,6 +22,8 @@ type mysqlProxy struct {
 	pLock      sync.RWMutex
 	closed     atomic.Value
 	db         *sql.DB
+	// sessions of the client connections, by connection id
+	sessions map[uint32]*sqlite.Session
 }
 
 func (m *mysqlProxy) onConn(c net.Conn) {
@@ -36,11 +39,18 @@ func (m *mysqlProxy) onConn(c net.Conn) {
 			buf = buf[:runtime.Stack(buf, false)]
 			logrus.Errorf("panic: %s", string(buf))
 		}
+		if err := m.CloseConn(conn); err != nil {
+			logrus.Warningf("close session error: %v", err)
+		}
 		if !conn.Closed() {
 			conn.Close()
 		}
 	}()
 
+	m.pLock.Lock()
+	m.sessions[conn.ConnectionID()] = sqlite.NewSession()
+	m.pLock.Unlock()
+
 	for {
 		err = conn.HandleCommand()
 		if err != nil {
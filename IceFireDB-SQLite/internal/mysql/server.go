@@ -52,7 +52,7 @@ func (m *mysqlProxy) Run(ctx context.Context) {
 }
 
 func newMysqlProxy() *mysqlProxy {
-	p := &mysqlProxy{}
+	p := &mysqlProxy{sessions: make(map[uint32]*sqlite.Session)}
 	p.server = server.NewDefaultServer()
 	p.credential = server.NewInMemoryProvider()
 	for _, info := range config.Get().UserList {
@@ -0,0 +1,218 @@
+package sqlite
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/IceFireDB/IceFireDB/IceFireDB-SQLite/pkg/config"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// Conflict resolution modes of the concurrent writes of the peers
+const (
+	// Apply every write in the order received
+	ConflictNone = "none"
+	// Per table, discard the writes older than the last applied one
+	ConflictLWW = "lww"
+	// Only the designated writer peer accepts writes
+	ConflictDesignatedWriter = "designated-writer"
+)
+
+// lwwTable records the timestamp of the last write applied to each table
+const lwwTable = "_icefiredb_lww"
+
+// ErrNotWriter is returned for the writes of a peer which is not the designated writer
+var ErrNotWriter = errors.New("writes are only accepted by the designated writer peer")
+
+var (
+	conflictMode     = ConflictNone
+	designatedWriter peer.ID
+)
+
+// A function that sets up the conflict resolution mode of the config
+func initConflict() error {
+	conf := config.Get().P2P.Conflict
+	switch mode := strings.ToLower(conf.Mode); mode {
+	case "", ConflictNone:
+		conflictMode = ConflictNone
+	case ConflictLWW:
+		if _, err := db.Exec("CREATE TABLE IF NOT EXISTS " + lwwTable +
+			" (tbl TEXT PRIMARY KEY, wall INTEGER NOT NULL, logical INTEGER NOT NULL, origin TEXT NOT NULL)"); err != nil {
+			return err
+		}
+		conflictMode = mode
+	case ConflictDesignatedWriter:
+		id, err := peer.Decode(conf.Writer)
+		if err != nil {
+			return fmt.Errorf("invalid designated writer %q: %w", conf.Writer, err)
+		}
+		designatedWriter = id
+		conflictMode = mode
+	default:
+		return fmt.Errorf("unknown conflict mode %q", conf.Mode)
+	}
+	return nil
+}
+
+// A function that returns the peer ID of the node, empty without p2p
+func selfID() string {
+	if p2pHost == nil {
+		return ""
+	}
+	return p2pHost.Host.ID().String()
+}
+
+// A function that checks whether the node accepts the writes of its clients
+func checkLocalWrite() error {
+	if follower {
+		return ErrReadOnly
+	}
+	if conflictMode == ConflictDesignatedWriter && selfID() != designatedWriter.String() {
+		return ErrNotWriter
+	}
+	return nil
+}
+
+// A function that stamps a local write with the time of the clock and, in
+// the lww mode, records it in the transaction of the write: the order of
+// the timestamps is then the order of the writes on the node, as on the
+// peers applying them
+func stampLocal(e execer, op *Op) error {
+	op.HLC = clock.Now()
+	op.Origin = selfID()
+	if conflictMode != ConflictLWW {
+		return nil
+	}
+	for _, table := range op.tables() {
+		if err := recordWrite(e, table, *op); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func recordWrite(e execer, table string, op Op) error {
+	_, err := e.Exec("INSERT INTO "+lwwTable+" (tbl, wall, logical, origin) VALUES (?, ?, ?, ?)"+
+		" ON CONFLICT(tbl) DO UPDATE SET wall = excluded.wall, logical = excluded.logical, origin = excluded.origin",
+		table, op.HLC.Wall, op.HLC.Logical, op.Origin)
+	return err
+}
+
+// A function that applies an entry of the log when it is newer than the
+// last write of each of its tables, the stale entries are discarded
+func applyLWW(op Op) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	tables := op.tables()
+	for _, table := range tables {
+		var last Timestamp
+		var origin string
+		err := tx.QueryRow("SELECT wall, logical, origin FROM "+lwwTable+" WHERE tbl = ?", table).
+			Scan(&last.Wall, &last.Logical, &origin)
+		if errors.Is(err, sql.ErrNoRows) {
+			continue
+		}
+		if err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+		if !newer(op.HLC, op.Origin, last, origin) {
+			_ = tx.Rollback()
+			return fmt.Errorf("stale write to table %s discarded, last write %d.%d from %s", table, last.Wall, last.Logical, origin)
+		}
+	}
+	for i, stmt := range op.Stmts {
+		if _, err := tx.Exec(stmt, op.args(i)...); err != nil {
+			_ = tx.Rollback()
+			return fmt.Errorf("transaction rolled back, %s: %w", stmt, err)
+		}
+	}
+	for _, table := range tables {
+		if err := recordWrite(tx, table, op); err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+	}
+	if op.ddl() {
+		if err := migrateSchema(tx, op); err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// A function that orders the writes by timestamp, then by origin peer
+func newer(ts Timestamp, origin string, than Timestamp, thanOrigin string) bool {
+	if ts != than {
+		return than.Before(ts)
+	}
+	return origin > thanOrigin
+}
+
+// A method that returns the tables written by the entry
+func (op Op) tables() []string {
+	var tables []string
+	seen := make(map[string]bool)
+	for _, stmt := range op.Stmts {
+		if table := writeTable(stmt); table != "" && !seen[table] {
+			seen[table] = true
+			tables = append(tables, table)
+		}
+	}
+	return tables
+}
+
+// A function that returns the table of an INSERT, REPLACE, UPDATE or DELETE statement
+func writeTable(stmt string) string {
+	fields := strings.Fields(stmt)
+	i := 0
+	next := func() string {
+		if i >= len(fields) {
+			return ""
+		}
+		i++
+		return strings.ToUpper(fields[i-1])
+	}
+	switch next() {
+	case "INSERT":
+		if w := next(); w == "OR" {
+			next()
+			w = next()
+			if w != "INTO" {
+				return ""
+			}
+		} else if w != "INTO" {
+			return ""
+		}
+	case "REPLACE":
+		if next() != "INTO" {
+			return ""
+		}
+	case "UPDATE":
+		if i < len(fields) && strings.EqualFold(fields[i], "OR") {
+			i += 2
+		}
+	case "DELETE":
+		if next() != "FROM" {
+			return ""
+		}
+	default:
+		return ""
+	}
+	if i >= len(fields) {
+		return ""
+	}
+	table := fields[i]
+	if j := strings.IndexAny(table, "("); j >= 0 {
+		table = table[:j]
+	}
+	if j := strings.LastIndex(table, "."); j >= 0 {
+		table = table[j+1:]
+	}
+	return strings.ToLower(strings.Trim(table, "`\"[]"))
+}
@@ -0,0 +1,227 @@
+package sqlite
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+
+	"github.com/IceFireDB/IceFireDB/IceFireDB-SQLite/pkg/config"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+func setConflict(t *testing.T, mode, writer string) {
+	old := config.Get().P2P.Conflict
+	config.Get().P2P.Conflict = config.ConflictC{Mode: mode, Writer: writer}
+	t.Cleanup(func() {
+		config.Get().P2P.Conflict = old
+		conflictMode, designatedWriter = ConflictNone, ""
+	})
+	if err := initConflict(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestHLC(t *testing.T) {
+	pt := int64(100)
+	c := &hlc{now: func() int64 { return pt }}
+
+	a := c.Now()
+	b := c.Now()
+	if !a.Before(b) {
+		t.Fatalf("%v not before %v", a, b)
+	}
+	// a peer ahead of the physical clock
+	c.Update(Timestamp{Wall: 200, Logical: 5})
+	if d := c.Now(); !(Timestamp{Wall: 200, Logical: 6}).Before(d) {
+		t.Fatalf("clock not moved past the received event: %v", d)
+	}
+	pt = 300
+	if d := c.Now(); d != (Timestamp{Wall: 300}) {
+		t.Fatalf("clock does not follow the physical time: %v", d)
+	}
+}
+
+func TestWriteTable(t *testing.T) {
+	for stmt, table := range map[string]string{
+		"INSERT INTO t (v) VALUES (1)":         "t",
+		"insert or replace into `T`(v) values": "t",
+		"REPLACE INTO main.t VALUES (1)":       "t",
+		"UPDATE t SET v = 1":                   "t",
+		"UPDATE OR IGNORE \"t\" SET v = 1":     "t",
+		"DELETE FROM t WHERE id = 1":           "t",
+		"CREATE TABLE t (id INTEGER)":          "",
+		"SELECT * FROM t":                      "",
+	} {
+		if got := writeTable(stmt); got != table {
+			t.Errorf("%s: want table %q, got %q", stmt, table, got)
+		}
+	}
+}
+
+func TestLWW(t *testing.T) {
+	openTestDB(t)
+	setConflict(t, ConflictLWW, "")
+
+	newOp := func(wall int64, origin, stmt string) Op {
+		return Op{Stmts: []string{stmt}, HLC: Timestamp{Wall: wall}, Origin: origin}
+	}
+	if err := applyOp(newOp(10, "b", "INSERT INTO t (id, v) VALUES (1, 'b')")); err != nil {
+		t.Fatal(err)
+	}
+	// a concurrent write delivered late loses
+	if err := applyOp(newOp(5, "a", "UPDATE t SET v = 'a'")); err == nil {
+		t.Fatal("stale write applied")
+	}
+	// same time, the greatest origin wins
+	if err := applyOp(newOp(10, "a", "UPDATE t SET v = 'a'")); err == nil {
+		t.Fatal("write of the lower origin applied")
+	}
+	if err := applyOp(newOp(10, "c", "UPDATE t SET v = 'c'")); err != nil {
+		t.Fatal(err)
+	}
+	var v string
+	if err := db.QueryRow("SELECT v FROM t WHERE id = 1").Scan(&v); err != nil || v != "c" {
+		t.Fatalf("want c, got %q, %v", v, err)
+	}
+
+	// a local write is newer than the applied ones
+	captureOutbound(t)
+	if _, err := NewSession().Exec("UPDATE t SET v = 'local'"); err != nil {
+		t.Fatal(err)
+	}
+	if err := applyOp(newOp(11, "d", "UPDATE t SET v = 'd'")); err == nil {
+		t.Fatal("write older than the local one applied")
+	}
+
+	// the table of the timestamps does not count for the snapshot bootstrap
+	if _, err := db.Exec("DROP TABLE t"); err != nil {
+		t.Fatal(err)
+	}
+	if empty, err := isEmpty(); err != nil || !empty {
+		t.Fatalf("database without user table reported as %v, %v", empty, err)
+	}
+}
+
+func TestDesignatedWriter(t *testing.T) {
+	openTestDB(t)
+	writer, err := peer.Decode("12D3KooWD3eckifWpRn9wQpMG9R9hX3sD158z7EqHWmweQAJU5SA")
+	if err != nil {
+		t.Fatal(err)
+	}
+	setConflict(t, ConflictDesignatedWriter, writer.String())
+
+	captureOutbound(t)
+	if _, err := NewSession().Exec("INSERT INTO t (v) VALUES ('a')"); !errors.Is(err, ErrNotWriter) {
+		t.Fatalf("local write of a follower: want ErrNotWriter, got %v", err)
+	}
+	if _, err := NewSession().Exec("SELECT * FROM t"); err != nil {
+		t.Fatal(err)
+	}
+	if err := applyOp(Op{Stmts: []string{"INSERT INTO t (v) VALUES ('b')"}, Origin: "other"}); !errors.Is(err, ErrNotWriter) {
+		t.Fatalf("write of another peer: want ErrNotWriter, got %v", err)
+	}
+	if err := applyOp(Op{Stmts: []string{"INSERT INTO t (v) VALUES ('c')"}, Origin: writer.String()}); err != nil {
+		t.Fatal(err)
+	}
+	if n := count(t); n != 1 {
+		t.Fatalf("want 1 row, got %d", n)
+	}
+
+	config.Get().P2P.Conflict.Writer = "invalid"
+	if err := initConflict(); err == nil {
+		t.Fatal("invalid writer accepted")
+	}
+}
+
+// lwwNode is a peer of a convergence test, its database and clock are set
+// as the ones of the package while it runs
+type lwwNode struct {
+	db    *sql.DB
+	clock *hlc
+	wall  int64
+}
+
+func newLWWNode(t *testing.T) *lwwNode {
+	openTestDB(t)
+	n := &lwwNode{db: db}
+	n.clock = &hlc{now: func() int64 { return n.wall }}
+	if err := initConflict(); err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func (n *lwwNode) use() {
+	db, clock = n.db, n.clock
+}
+
+func (n *lwwNode) value(t *testing.T) string {
+	var v string
+	if err := n.db.QueryRow("SELECT v FROM t WHERE id = 1").Scan(&v); err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+func TestLWWConvergence(t *testing.T) {
+	a := newLWWNode(t)
+	setConflict(t, ConflictLWW, "")
+	b := newLWWNode(t)
+	oldClock := clock
+	t.Cleanup(func() { clock = oldClock })
+	ops := captureOutbound(t)
+	for _, n := range []*lwwNode{a, b} {
+		n.use()
+		if _, err := db.Exec("INSERT INTO t (id, v) VALUES (1, '')"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write := func(n *lwwNode, wall int64, stmt string) Op {
+		n.use()
+		n.wall = wall
+		if _, err := NewSession().Exec(stmt); err != nil {
+			t.Fatal(err)
+		}
+		return (*ops)[len(*ops)-1]
+	}
+	deliver := func(n *lwwNode, op Op) {
+		n.use()
+		_ = applyOp(op)
+	}
+
+	// concurrent writes: both peers keep the newer one
+	opA := write(a, 10, "UPDATE t SET v = 'a'")
+	opB := write(b, 20, "UPDATE t SET v = 'b'")
+	deliver(a, opB)
+	deliver(b, opA)
+	if va, vb := a.value(t), b.value(t); va != "b" || vb != "b" {
+		t.Fatalf("concurrent writes: a has %q, b has %q", va, vb)
+	}
+
+	// a write made after a peer's one was applied is newer than it, even
+	// with a clock behind
+	opB = write(b, 30, "UPDATE t SET v = 'b2'")
+	deliver(a, opB)
+	opA = write(a, 25, "UPDATE t SET v = 'a2'")
+	deliver(b, opA)
+	if va, vb := a.value(t), b.value(t); va != "a2" || vb != "a2" {
+		t.Fatalf("ordered writes: a has %q, b has %q", va, vb)
+	}
+
+	// a transaction is recorded with its statements
+	a.use()
+	a.wall = 40
+	s := NewSession()
+	for _, q := range []string{"BEGIN", "UPDATE t SET v = 'tx'", "COMMIT"} {
+		if _, err := s.Exec(q); err != nil {
+			t.Fatalf("%s: %v", q, err)
+		}
+	}
+	if err := applyOp(Op{Stmts: []string{"UPDATE t SET v = 'old'"}, HLC: Timestamp{Wall: 35}, Origin: "c"}); err == nil {
+		t.Fatal("write older than the local transaction applied")
+	}
+	deliver(b, (*ops)[len(*ops)-1])
+	if va, vb := a.value(t), b.value(t); va != "tx" || vb != "tx" {
+		t.Fatalf("transaction: a has %q, b has %q", va, vb)
+	}
+}
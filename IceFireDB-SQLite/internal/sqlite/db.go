@@ -3,6 +3,7 @@ package sqlite
 import (
 	"context"
 	"database/sql"
+	"fmt"
 	"strconv"
 	"strings"
 	"time"
@@ -10,7 +11,9 @@ import (
 	"github.com/IceFireDB/IceFireDB/IceFireDB-SQLite/pkg/config"
 	"github.com/IceFireDB/IceFireDB/IceFireDB-SQLite/pkg/mysql/mysql"
 	"github.com/IceFireDB/IceFireDB/IceFireDB-SQLite/pkg/p2p"
+	"github.com/IceFireDB/IceFireDB/IceFireDB-SQLite/pkg/p2p/security"
 	"github.com/IceFireDB/IceFireDB/IceFireDB-SQLite/utils"
+	"github.com/libp2p/go-libp2p/core/peer"
 	_ "github.com/mattn/go-sqlite3"
 	"github.com/sirupsen/logrus"
 )
@@ -21,15 +24,53 @@ var (
 	p2pPubSub *p2p.PubSub
 )
 
+// how long a statement waits for the write of another connection before it
+// fails with "database is locked"
+var busyTimeout = 5 * time.Second
+
+// A function that returns the data source name of a database file with
+// the busy timeout and options of the driver
+func dataSource(filename string, options ...string) string {
+	options = append(options, fmt.Sprintf("_busy_timeout=%d", busyTimeout.Milliseconds()))
+	sep := "?"
+	if strings.Contains(filename, "?") {
+		sep = "&"
+	}
+	return filename + sep + strings.Join(options, "&")
+}
+
 func InitSQLite(ctx context.Context, filename string) *sql.DB {
 	var err error
-	// var driver sqlite3.SQLiteDriver
-	// conn, err := driver.Open(filename)
-	db, err = sql.Open("sqlite3", filename)
+	db, err = sql.Open("sqlite3", dataSource(filename))
 	if err != nil {
 		panic(err)
 	}
+	if err := initSandbox(); err != nil {
+		panic(err)
+	}
 	if config.Get().P2P.Enable {
+		announceAddrs, err := p2p.ParseAnnounceAddrs(config.Get().P2P.NAT.AnnounceAddrs)
+		if err != nil {
+			panic(err)
+		}
+		p2p.DefaultNATConfig.PortMap = config.Get().P2P.NAT.PortMap
+		p2p.DefaultNATConfig.AnnounceAddrs = announceAddrs
+		secConf := config.Get().P2P.Security
+		p2p.DefaultSecurityConfig = security.Config{
+			Transports: secConf.Transports,
+			KeyTypes:   secConf.KeyTypes,
+			FIPS:       secConf.FIPS,
+		}
+		if err := p2p.DefaultSecurityConfig.Validate(); err != nil {
+			panic(err)
+		}
+		if err := initMembership(); err != nil {
+			panic(err)
+		}
+		if err := initEncryption(); err != nil {
+			panic(err)
+		}
+
 		// create p2p element
 		p2pHost = p2p.NewP2P(config.Get().P2P.ServiceDiscoveryID,
 			config.Get().P2P.NodeHostIP,
@@ -37,6 +78,10 @@ func InitSQLite(ctx context.Context, filename string) *sql.DB {
 
 		logrus.Info("Completed P2P Setup")
 
+		if err := applyStaticPeers(); err != nil {
+			panic(err)
+		}
+
 		// Connect to peers with the chosen discovery method
 		switch strings.ToLower(config.Get().P2P.ServiceDiscoverMode) {
 		case "announce":
@@ -48,17 +93,145 @@ func InitSQLite(ctx context.Context, filename string) *sql.DB {
 		}
 
 		logrus.Info("Connected to P2P Service Peers")
-		var err error
 		p2pPubSub, err = p2p.JoinPubSub(p2pHost, "icefiredb-sqlite-client", config.Get().P2P.ServiceCommandTopic)
 		if err != nil {
 			panic(err)
 		}
 		logrus.Infof("Successfully joined [%s] P2P channel. \n", config.Get().P2P.ServiceCommandTopic)
+		if err := initRouting(); err != nil {
+			panic(err)
+		}
+		if config.Get().P2P.Snapshot.Bootstrap {
+			if err := bootstrap(ctx, filename); err != nil {
+				panic(err)
+			}
+		}
+		if err := initConflict(); err != nil {
+			panic(err)
+		}
+		if err := pruneAll(false); err != nil {
+			panic(err)
+		}
+		if err := joinTopics(); err != nil {
+			panic(err)
+		}
+		p2p.ServeSnapshots(p2pHost.Host, p2pHost.Membership, takeSnapshot)
+		if p2pHost.Keyring != nil {
+			p2p.ServeTopicKeys(p2pHost.Host, p2pHost.Membership, p2pHost.Keyring)
+			go rotateTopicKeys(ctx)
+		}
 		asyncSQL(ctx)
 	}
+	if config.Get().P2P.Follower {
+		if err := initFollower(filename); err != nil {
+			panic(err)
+		}
+		logrus.Info("Read-only follower, local writes are rejected")
+	}
 	return db
 }
 
+func initMembership() error {
+	p2pConf := config.Get().P2P
+	if p2pConf.IdentityFile != "" {
+		key, err := p2p.LoadIdentity(p2pConf.IdentityFile)
+		if err != nil {
+			return err
+		}
+		p2p.DefaultIdentity = key
+	}
+	if !p2pConf.Membership.Enable {
+		return nil
+	}
+	if p2p.DefaultIdentity == nil {
+		return fmt.Errorf("p2p membership requires a persistent identity_file")
+	}
+
+	membership, err := p2p.LoadMembership(p2pConf.Membership.AllowlistFile, p2pConf.Membership.OperatorKey)
+	if err != nil {
+		return err
+	}
+	self, err := peer.IDFromPrivateKey(p2p.DefaultIdentity)
+	if err != nil {
+		return err
+	}
+	if !membership.Allowed(self) {
+		return fmt.Errorf("p2p peer %s is not in the cluster allowlist", self)
+	}
+	p2p.DefaultMembership = membership
+	logrus.Infof("P2P membership enabled, peer id: %s", self)
+	return nil
+}
+
+func initEncryption() error {
+	encConf := config.Get().P2P.Encryption
+	if !encConf.Enable {
+		return nil
+	}
+	if p2p.DefaultMembership == nil {
+		return fmt.Errorf("p2p encryption requires the membership, the keys are only shared with members")
+	}
+	keyring, err := p2p.LoadKeyring(encConf.KeyFile, time.Duration(encConf.Grace)*time.Second)
+	if err != nil {
+		return err
+	}
+	p2p.DefaultKeyring = keyring
+	logrus.Infof("P2P topic encryption enabled, key id: %s", keyring.Current().ID)
+	return nil
+}
+
+// RotateTopicKey replaces the topic key and sends the new one to the
+// members connected, the others fetch it with the first message they get
+func RotateTopicKey() error {
+	if p2pHost == nil || p2pHost.Keyring == nil {
+		return fmt.Errorf("p2p topic encryption is not enabled")
+	}
+	k, err := p2pHost.Keyring.Rotate()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	n := p2p.DistributeTopicKey(ctx, p2pHost.Host, p2pHost.Membership, k)
+	logrus.Infof("Topic key rotated to %s, sent to %d peers", k.ID, n)
+	return nil
+}
+
+func rotateTopicKeys(ctx context.Context) {
+	interval := time.Duration(config.Get().P2P.Encryption.RotateInterval) * time.Second
+	if interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := RotateTopicKey(); err != nil {
+				logrus.Errorf("Rotate topic key: %v", err)
+			}
+		}
+	}
+}
+
+// ReloadP2PPeers applies the bootstrap peers and static relays of a reloaded
+// config to the running p2p host
+func ReloadP2PPeers() error {
+	if p2pHost == nil {
+		return nil
+	}
+	return applyStaticPeers()
+}
+
+func applyStaticPeers() error {
+	if err := p2pHost.SetBootstrapPeers(config.Get().P2P.BootstrapPeers); err != nil {
+		return err
+	}
+	return p2pHost.SetStaticRelays(config.Get().P2P.StaticRelays)
+}
+
 var DMLSQL = []string{
 	"BEGIN",
 	"BEGIN TRANSACTION",
@@ -69,43 +242,39 @@ var DMLSQL = []string{
 	"UPDATE",
 	"DELETE",
 	"CREATE",
-	"ALERT",
+	"ALTER",
 	"DROP",
 }
 
-func Exec(sql string) (*mysql.Result, error) {
-	prefix := sql
-	if len(sql) > 20 {
-		prefix = sql[:20]
-	}
-	prefix = strings.ToUpper(prefix)
-	for _, v := range DMLSQL {
-		if strings.HasPrefix(prefix, v) {
-			result, err := db.Exec(sql)
-			if err != nil {
-				return nil, err
-			}
-			if config.Get().P2P.Enable {
-				p2pPubSub.Outbound <- sql
-				logrus.Infof("Outbound sql: %s", sql)
-			}
+// execer runs statements on the database or in a transaction
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}
 
-			rf, _ := result.RowsAffected()
-			li, _ := result.LastInsertId()
-			res := &mysql.Result{
-				Status:       2,
-				Warnings:     0,
-				InsertId:     uint64(li),
-				AffectedRows: uint64(rf),
-			}
-			return res, nil
-		}
+func execWrite(e execer, sql string, args ...interface{}) (*mysql.Result, error) {
+	result, err := e.Exec(sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	rf, _ := result.RowsAffected()
+	li, _ := result.LastInsertId()
+	res := &mysql.Result{
+		Status:       2,
+		Warnings:     0,
+		InsertId:     uint64(li),
+		AffectedRows: uint64(rf),
 	}
+	return res, nil
+}
 
-	resp, err := db.Query(sql, nil)
+// execQuery runs a query, binary builds the rows of the prepared statement protocol
+func execQuery(e execer, sql string, binary bool, args ...interface{}) (*mysql.Result, error) {
+	resp, err := e.Query(sql, args...)
 	if err != nil {
 		return nil, err
 	}
+	defer resp.Close()
 
 	column, err := resp.Columns()
 	if err != nil {
@@ -132,6 +301,10 @@ func Exec(sql string) (*mysql.Result, error) {
 		// https://dev.mysql.com/doc/internals/en/com-query-response.html#column-type
 		f.Type, f.ColumnLength = getColumnTypeAndLen(columnType[k].DatabaseTypeName())
 		//mysql.MYSQL_TYPE_VARCHAR
+		if binary {
+			// sqlite values are not bound to the column type, send them as strings
+			f.Type = mysql.MYSQL_TYPE_VAR_STRING
+		}
 		res.Fields[k] = f
 	}
 
@@ -144,6 +317,14 @@ func Exec(sql string) (*mysql.Result, error) {
 	// writer rows
 	for resp.Next() {
 		_ = resp.Scan(c...)
+		if binary {
+			rowData, err := binaryRow(c)
+			if err != nil {
+				return nil, err
+			}
+			res.RowDatas = append(res.RowDatas, rowData)
+			continue
+		}
 		rowData := make([]byte, 0)
 		for _, data := range c {
 			cv, err := utils.GetString(*data.(*interface{}))
@@ -159,24 +340,63 @@ func Exec(sql string) (*mysql.Result, error) {
 	return res, nil
 }
 
+// binaryRow encodes a row of the binary protocol, every value as a string
+func binaryRow(values []interface{}) ([]byte, error) {
+	nullBitmap := make([]byte, (len(values)+7+2)>>3)
+	var data []byte
+	for i, v := range values {
+		d := *v.(*interface{})
+		if d == nil {
+			nullBitmap[(i+2)/8] |= 1 << (uint(i+2) % 8)
+			continue
+		}
+		cv, err := utils.GetString(d)
+		if err != nil {
+			return nil, err
+		}
+		data = append(data, mysql.PutLengthEncodedString([]byte(cv))...)
+	}
+	row := append([]byte{0}, nullBitmap...)
+	return append(row, data...), nil
+}
+
 func asyncSQL(ctx context.Context) {
+	consume(ctx, p2pPubSub)
+	consumeTopics(ctx)
+}
+
+// A function that applies the entries received on a topic, in order on
+// the goroutine of their queue
+func consume(ctx context.Context, ps *p2p.PubSub) {
+	q := newInbound()
+	apply(ctx, q)
+	receive(ctx, ps, q)
+}
+
+// A function that queues the entries received on a topic
+func receive(ctx context.Context, ps *p2p.PubSub, q *inbound) {
 	utils.GoWithRecover(func() {
 		for {
 			select {
 			case <-ctx.Done():
 				return
-			case s := <-p2pPubSub.Inbound:
-				_, err := db.Exec(s.Message)
-				if err != nil {
-					logrus.Infof("Inbound sql: %s err: %v", s, err)
-					continue
-				}
-				logrus.Infof("Inbound sql: %s", s.Message)
+			case s := <-ps.Inbound:
+				q.push(message{sender: s.SenderID, msg: s.Message})
 			}
 		}
 	}, func(r interface{}) {
 		time.Sleep(time.Second)
-		asyncSQL(ctx)
+		receive(ctx, ps, q)
+	})
+}
+
+// A function that applies the entries of a queue
+func apply(ctx context.Context, q *inbound) {
+	utils.GoWithRecover(func() {
+		q.run(ctx)
+	}, func(r interface{}) {
+		time.Sleep(time.Second)
+		apply(ctx, q)
 	})
 }
 
@@ -245,11 +465,3 @@ func isDML(sql string) bool {
 	}
 	return false
 }
-
-func Broadcast(sql string) {
-	if !isDML(sql) {
-		return
-	}
-	p2pPubSub.Outbound <- sql
-	logrus.Infof("Outbound sql: %s", sql)
-}
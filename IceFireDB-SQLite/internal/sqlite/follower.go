@@ -0,0 +1,41 @@
+package sqlite
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+)
+
+// ErrReadOnly is returned for the writes of the clients of a follower
+var ErrReadOnly = errors.New("read-only follower, writes are only applied from the replicated log")
+
+var (
+	follower bool
+	// clientDB runs the statements of the clients of a follower,
+	// its connections cannot change the database
+	clientDB *sql.DB
+)
+
+// A function that turns the node into a follower: the replicated log is
+// applied but the clients can only read. The statements of the clients run
+// on query_only connections, so a write disguised as a read fails too.
+func initFollower(filename string) error {
+	follower = true
+	if filename == "" || strings.Contains(filename, ":memory:") {
+		return nil
+	}
+	d, err := sql.Open("sqlite3", dataSource(filename, "_query_only=true"))
+	if err != nil {
+		return err
+	}
+	clientDB = d
+	return nil
+}
+
+// A function that returns the database the clients' statements run on
+func sessionDB() *sql.DB {
+	if clientDB != nil {
+		return clientDB
+	}
+	return db
+}
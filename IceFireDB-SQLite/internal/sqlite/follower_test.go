@@ -0,0 +1,42 @@
+package sqlite
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestFollower(t *testing.T) {
+	filename := openTestDB(t)
+	if err := initFollower(filename); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		clientDB.Close()
+		follower, clientDB = false, nil
+	})
+	ops := captureOutbound(t)
+
+	s := NewSession()
+	if _, err := s.Exec("INSERT INTO t (v) VALUES ('a')"); !errors.Is(err, ErrReadOnly) {
+		t.Fatalf("want ErrReadOnly, got %v", err)
+	}
+	// a write classified as a read fails on the query_only connections
+	if _, err := s.Exec("PRAGMA user_version = 7"); err == nil && version(t) == 7 {
+		t.Fatal("write run as a read changed the database")
+	}
+	if len(*ops) != 0 {
+		t.Fatalf("follower published writes: %v", *ops)
+	}
+
+	// the replicated log is applied and can be read
+	if err := applyOp(Op{Stmts: []string{"INSERT INTO t (v) VALUES ('b')"}}); err != nil {
+		t.Fatal(err)
+	}
+	res, err := s.Exec("SELECT v FROM t")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.RowDatas) != 1 {
+		t.Fatalf("want 1 row, got %d", len(res.RowDatas))
+	}
+}
@@ -0,0 +1,60 @@
+package sqlite
+
+import (
+	"sync"
+	"time"
+)
+
+// Timestamp represents a time of the hybrid logical clock: the physical
+// time in milliseconds and a counter ordering the events of a millisecond
+type Timestamp struct {
+	Wall    int64  `json:"w"`
+	Logical uint32 `json:"l"`
+}
+
+// Before reports whether t happened before o
+func (t Timestamp) Before(o Timestamp) bool {
+	return t.Wall < o.Wall || t.Wall == o.Wall && t.Logical < o.Logical
+}
+
+// hlc is a hybrid logical clock, its timestamps follow the physical time
+// and are greater than the ones of the received events
+type hlc struct {
+	mu   sync.Mutex
+	last Timestamp
+	now  func() int64
+}
+
+var clock = &hlc{now: func() int64 { return time.Now().UnixMilli() }}
+
+// A method that returns the timestamp of a local event
+func (c *hlc) Now() Timestamp {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if pt := c.now(); pt > c.last.Wall {
+		c.last = Timestamp{Wall: pt}
+	} else {
+		c.last.Logical++
+	}
+	return c.last
+}
+
+// A method that moves the clock past the timestamp of a received event
+func (c *hlc) Update(remote Timestamp) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	pt := c.now()
+	switch {
+	case pt > c.last.Wall && pt > remote.Wall:
+		c.last = Timestamp{Wall: pt}
+	case remote.Wall > c.last.Wall:
+		c.last = Timestamp{Wall: remote.Wall, Logical: remote.Logical + 1}
+	case c.last.Wall > remote.Wall:
+		c.last.Logical++
+	default:
+		if remote.Logical > c.last.Logical {
+			c.last.Logical = remote.Logical
+		}
+		c.last.Logical++
+	}
+}
@@ -0,0 +1,121 @@
+package sqlite
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	// how long an entry refused on a locked database waits before it is
+	// applied again, doubling up to inboundMaxDelay
+	inboundRetryDelay = 100 * time.Millisecond
+	inboundMaxDelay   = 5 * time.Second
+)
+
+// inbound is the queue of the entries of a topic not applied yet. They are
+// applied in order on the goroutine of the queue, so that the receive
+// goroutine does not wait for the database, and an entry refused on a
+// locked database holds back the entries after it until it is applied.
+type inbound struct {
+	mu      sync.Mutex
+	entries []message
+	wake    chan struct{}
+}
+
+func newInbound() *inbound {
+	return &inbound{wake: make(chan struct{}, 1)}
+}
+
+// A method that queues an entry received from a peer
+func (q *inbound) push(m message) {
+	q.mu.Lock()
+	q.entries = append(q.entries, m)
+	q.mu.Unlock()
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// A method that takes the first entry of the queue
+func (q *inbound) pop() (message, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.entries) == 0 {
+		return message{}, false
+	}
+	m := q.entries[0]
+	q.entries[0] = message{}
+	q.entries = q.entries[1:]
+	return m, true
+}
+
+// A method that applies the entries of the queue as they come, until ctx
+// is done
+func (q *inbound) run(ctx context.Context) {
+	for {
+		m, ok := q.pop()
+		if !ok {
+			select {
+			case <-ctx.Done():
+				return
+			case <-q.wake:
+			}
+			continue
+		}
+		applyMessage(ctx, m.sender, m.msg)
+		if ctx.Err() != nil {
+			return
+		}
+	}
+}
+
+// A function that applies an entry of the replicated log received from a
+// peer, sender is the verified author of the message. An entry refused on a
+// locked database is applied again from the statement it stopped at, until
+// it is applied or ctx is done.
+func applyMessage(ctx context.Context, sender, msg string) {
+	op, err := decodeOp(msg)
+	if err != nil {
+		logrus.Infof("Inbound sql: %s err: %v", msg, err)
+		return
+	}
+	if sender != "" {
+		op.Origin = sender
+	}
+	delay := inboundRetryDelay
+	for {
+		err = applyOp(op)
+		if err == nil || !isBusy(err) {
+			break
+		}
+		var perr *partialError
+		if errors.As(err, &perr) {
+			op = op.from(perr.applied)
+		}
+		logrus.Warnf("Inbound sql: %s err: %v, retry in %v", msg, err, delay)
+		select {
+		case <-ctx.Done():
+			logrus.Errorf("Inbound sql: %s not applied, stopped while the database is locked", msg)
+			return
+		case <-time.After(delay):
+		}
+		delay = min(2*delay, inboundMaxDelay)
+	}
+	if err != nil {
+		logrus.Infof("Inbound sql: %s err: %v", msg, err)
+		return
+	}
+	logrus.Infof("Inbound sql: %s", msg)
+}
+
+// A function that reports whether an entry was refused on a locked database
+func isBusy(err error) bool {
+	var serr sqlite3.Error
+	return errors.As(err, &serr) && (serr.Code == sqlite3.ErrBusy || serr.Code == sqlite3.ErrLocked)
+}
@@ -0,0 +1,241 @@
+package sqlite
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/IceFireDB/IceFireDB/IceFireDB-SQLite/pkg/config"
+	"github.com/sirupsen/logrus"
+)
+
+// Op represents an entry of the replicated statement log
+type Op struct {
+	// Statements applied in order
+	Stmts []string `json:"stmts"`
+	// Arguments of the placeholders of each statement, when any statement has some
+	Args [][]Value `json:"args,omitempty"`
+	// Apply the statements in a single transaction, all of them or none
+	Tx bool `json:"tx,omitempty"`
+	// Time of the write on the hybrid logical clock of its origin peer
+	HLC Timestamp `json:"hlc"`
+	// Peer ID of the node the write was made on
+	Origin string `json:"origin,omitempty"`
+	// Schema version the write was made on
+	Schema int `json:"schema,omitempty"`
+	// Fingerprint of the schema left by the write on its node
+	SchemaHash string `json:"schema_hash,omitempty"`
+}
+
+// Value represents an argument of a statement, a null value when no field is set
+type Value struct {
+	Int   *int64   `json:"i,omitempty"`
+	Float *float64 `json:"f,omitempty"`
+	Text  *string  `json:"s,omitempty"`
+	Bytes *[]byte  `json:"b,omitempty"`
+}
+
+// A function that converts the arguments bound by a client to the values of the log.
+// Strings and blobs are sent as bytes in the MySQL protocol and are bound as blobs,
+// the unsigned integers out of the range of SQLite as their decimal text.
+func encodeArgs(args []interface{}) ([]Value, error) {
+	values := make([]Value, len(args))
+	for i, arg := range args {
+		switch v := arg.(type) {
+		case nil:
+		case int8, int16, int32, int64, int, uint8, uint16, uint32, uint:
+			n, err := strconv.ParseInt(fmt.Sprintf("%d", v), 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("argument %d: %w", i, err)
+			}
+			values[i].Int = &n
+		case uint64:
+			if v > math.MaxInt64 {
+				t := strconv.FormatUint(v, 10)
+				values[i].Text = &t
+				continue
+			}
+			n := int64(v)
+			values[i].Int = &n
+		case float32:
+			f := float64(v)
+			values[i].Float = &f
+		case float64:
+			values[i].Float = &v
+		case string:
+			values[i].Text = &v
+		case []byte:
+			b := append([]byte{}, v...)
+			values[i].Bytes = &b
+		default:
+			return nil, fmt.Errorf("argument %d: unsupported type %T", i, arg)
+		}
+	}
+	return values, nil
+}
+
+// A function that converts the values of the log to the arguments of a statement
+func decodeArgs(values []Value) []interface{} {
+	args := make([]interface{}, len(values))
+	for i, v := range values {
+		switch {
+		case v.Int != nil:
+			args[i] = *v.Int
+		case v.Float != nil:
+			args[i] = *v.Float
+		case v.Text != nil:
+			args[i] = *v.Text
+		case v.Bytes != nil:
+			args[i] = *v.Bytes
+		}
+	}
+	return args
+}
+
+// A method that returns the arguments of the i-th statement
+func (op Op) args(i int) []interface{} {
+	if i >= len(op.Args) {
+		return nil
+	}
+	return decodeArgs(op.Args[i])
+}
+
+// A method that returns the entry without its first n statements
+func (op Op) from(n int) Op {
+	op.Stmts = op.Stmts[n:]
+	if len(op.Args) > n {
+		op.Args = op.Args[n:]
+	} else {
+		op.Args = nil
+	}
+	return op
+}
+
+// partialError is the error of a statement of an entry applied one statement
+// at a time, the statements before it are applied
+type partialError struct {
+	applied int
+	err     error
+}
+
+func (e *partialError) Error() string { return e.err.Error() }
+
+func (e *partialError) Unwrap() error { return e.err }
+
+// A method that appends a statement and its arguments to the entry
+func (op *Op) add(stmt string, args []Value) {
+	if len(args) > 0 && op.Args == nil {
+		op.Args = make([][]Value, len(op.Stmts))
+	}
+	op.Stmts = append(op.Stmts, stmt)
+	if op.Args != nil {
+		op.Args = append(op.Args, args)
+	}
+}
+
+// outbound sends an encoded entry to the peers on the replication topic,
+// the service command topic when empty, replaced in tests
+var outbound = func(topic, msg string) {
+	if !config.Get().P2P.Enable {
+		return
+	}
+	if ps, ok := topicPubSubs[topic]; ok {
+		ps.Outbound <- msg
+		return
+	}
+	p2pPubSub.Outbound <- msg
+}
+
+// A function that publishes an entry of the replicated log to the peers
+func publish(op Op) {
+	if op.HLC == (Timestamp{}) {
+		op.HLC = clock.Now()
+		op.Origin = selfID()
+	}
+	data, err := json.Marshal(op)
+	if err != nil {
+		logrus.Errorf("Outbound sql encode fail: %v", err)
+		return
+	}
+	outbound(tableRouting.topic(op), string(data))
+	logrus.Infof("Outbound sql: %s", data)
+}
+
+// A function that decodes an entry of the replicated log,
+// peers running an older version publish plain statements
+func decodeOp(msg string) (Op, error) {
+	if !strings.HasPrefix(strings.TrimSpace(msg), "{") {
+		return Op{Stmts: []string{msg}}, nil
+	}
+	var op Op
+	if err := json.Unmarshal([]byte(msg), &op); err != nil {
+		return op, err
+	}
+	return op, nil
+}
+
+// A function that applies an entry of the replicated log to the local database.
+// The statements of a transaction are rolled back together when one of them fails.
+func applyOp(op Op) error {
+	clock.Update(op.HLC)
+	if conflictMode == ConflictDesignatedWriter && op.Origin != designatedWriter.String() {
+		return fmt.Errorf("write from %s discarded: %w", op.Origin, ErrNotWriter)
+	}
+	if err := checkWriter(op.Origin); err != nil {
+		return err
+	}
+	for _, stmt := range op.Stmts {
+		if err := checkStatement(stmt); err != nil {
+			return err
+		}
+	}
+	if ok, err := checkSchema(op); !ok {
+		return err
+	}
+	if err := applyStmts(op); err != nil {
+		return err
+	}
+	if err := pruneTables(op.tables(), false); err != nil {
+		logrus.Errorf("Inbound sql: %v", err)
+	}
+	if op.ddl() {
+		applyHeld()
+	}
+	return nil
+}
+
+// A function that runs the statements of an entry. The schema changes
+// are applied in a transaction bumping the schema version.
+func applyStmts(op Op) error {
+	if conflictMode == ConflictLWW {
+		return applyLWW(op)
+	}
+	if !op.Tx && !op.ddl() {
+		for i, stmt := range op.Stmts {
+			if _, err := db.Exec(stmt, op.args(i)...); err != nil {
+				return &partialError{applied: i, err: err}
+			}
+		}
+		return nil
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	for i, stmt := range op.Stmts {
+		if _, err := tx.Exec(stmt, op.args(i)...); err != nil {
+			_ = tx.Rollback()
+			return fmt.Errorf("transaction rolled back, %s: %w", stmt, err)
+		}
+	}
+	if op.ddl() {
+		if err := migrateSchema(tx, op); err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,191 @@
+package sqlite
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/IceFireDB/IceFireDB/IceFireDB-SQLite/pkg/config"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// ErrDenied is returned for the statements rejected by the sandbox
+var ErrDenied = errors.New("statement denied by the sandbox")
+
+// ErrWriterDenied is returned for the writes of a peer without write permission
+var ErrWriterDenied = errors.New("peer not allowed to write")
+
+// The pragmas taking an argument in parentheses without changing the database
+var readPragmas = map[string]bool{
+	"TABLE_INFO":        true,
+	"TABLE_XINFO":       true,
+	"TABLE_LIST":        true,
+	"INDEX_INFO":        true,
+	"INDEX_XINFO":       true,
+	"INDEX_LIST":        true,
+	"FOREIGN_KEY_LIST":  true,
+	"FOREIGN_KEY_CHECK": true,
+	"INTEGRITY_CHECK":   true,
+	"QUICK_CHECK":       true,
+}
+
+// writers are the peers allowed to replicate writes, nil allows every peer
+var writers map[string]bool
+
+// A function that loads the peers allowed to replicate writes
+func initSandbox() error {
+	ids := config.Get().SQLite.Sandbox.Writers
+	if len(ids) == 0 {
+		writers = nil
+		return nil
+	}
+	writers = make(map[string]bool, len(ids))
+	for _, s := range ids {
+		id, err := peer.Decode(s)
+		if err != nil {
+			return fmt.Errorf("invalid sandbox writer %q: %w", s, err)
+		}
+		writers[id.String()] = true
+	}
+	return nil
+}
+
+// A function that checks whether a peer may replicate writes
+func checkWriter(origin string) error {
+	if writers != nil && !writers[origin] {
+		return fmt.Errorf("%w: %s", ErrWriterDenied, origin)
+	}
+	return nil
+}
+
+// A function that checks every statement of a query against the sandbox:
+// ATTACH, DETACH, PRAGMA writes and load_extension are denied, so are the
+// configured prefixes, and only the allowed prefixes run when some are set
+func checkStatement(query string) error {
+	conf := config.Get().SQLite.Sandbox
+	if !conf.Enable && len(conf.Deny) == 0 && len(conf.Allow) == 0 {
+		return nil
+	}
+	for _, stmt := range splitStatements(query) {
+		q := strings.ToUpper(stmt)
+		if conf.Enable {
+			if reason := sandboxed(q); reason != "" {
+				return fmt.Errorf("%w: %s", ErrDenied, reason)
+			}
+		}
+		for _, prefix := range conf.Deny {
+			if hasKeyword(q, strings.ToUpper(prefix)) {
+				return fmt.Errorf("%w: %s", ErrDenied, prefix)
+			}
+		}
+		if len(conf.Allow) > 0 && !allowed(q, conf.Allow) {
+			return fmt.Errorf("%w: not in the allowlist", ErrDenied)
+		}
+	}
+	return nil
+}
+
+// A function that returns why a statement escapes the sandbox, empty when it doesn't
+func sandboxed(q string) string {
+	switch {
+	case hasKeyword(q, "ATTACH"):
+		return "ATTACH"
+	case hasKeyword(q, "DETACH"):
+		return "DETACH"
+	case strings.Contains(q, "LOAD_EXTENSION"):
+		return "load_extension"
+	case hasKeyword(q, "PRAGMA") && pragmaWrite(q):
+		return "PRAGMA write"
+	}
+	return ""
+}
+
+// A function that reports whether a PRAGMA statement changes a setting
+func pragmaWrite(q string) bool {
+	if strings.Contains(q, "=") {
+		return true
+	}
+	i := strings.Index(q, "(")
+	if i < 0 {
+		return false
+	}
+	name := strings.TrimSpace(strings.TrimPrefix(q[:i], "PRAGMA"))
+	if j := strings.LastIndex(name, "."); j >= 0 {
+		name = name[j+1:]
+	}
+	return !readPragmas[strings.TrimSpace(name)]
+}
+
+func allowed(q string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if hasKeyword(q, strings.ToUpper(prefix)) {
+			return true
+		}
+	}
+	return false
+}
+
+// A function that reports whether a statement starts with a keyword
+func hasKeyword(q, keyword string) bool {
+	if !strings.HasPrefix(q, keyword) {
+		return false
+	}
+	if len(q) == len(keyword) {
+		return true
+	}
+	c := q[len(keyword)]
+	return !(c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_')
+}
+
+// A function that splits a query in statements without their leading
+// comments, SQLite runs every statement of a query
+func splitStatements(query string) []string {
+	var stmts []string
+	var b strings.Builder
+	flush := func() {
+		if s := strings.TrimSpace(b.String()); s != "" {
+			stmts = append(stmts, s)
+		}
+		b.Reset()
+	}
+	for i := 0; i < len(query); i++ {
+		c := query[i]
+		switch {
+		case c == '\'' || c == '"' || c == '`' || c == '[':
+			end := c
+			if c == '[' {
+				end = ']'
+			}
+			j := strings.IndexByte(query[i+1:], end)
+			if j < 0 {
+				b.WriteString(query[i:])
+				i = len(query)
+				continue
+			}
+			b.WriteString(query[i : i+j+2])
+			i += j + 1
+		case c == '-' && i+1 < len(query) && query[i+1] == '-':
+			j := strings.IndexByte(query[i:], '\n')
+			if j < 0 {
+				i = len(query)
+				continue
+			}
+			b.WriteByte(' ')
+			i += j
+		case c == '/' && i+1 < len(query) && query[i+1] == '*':
+			j := strings.Index(query[i+2:], "*/")
+			if j < 0 {
+				i = len(query)
+				continue
+			}
+			b.WriteByte(' ')
+			i += j + 3
+		case c == ';':
+			flush()
+		default:
+			b.WriteByte(c)
+		}
+	}
+	flush()
+	return stmts
+}
@@ -0,0 +1,91 @@
+package sqlite
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/IceFireDB/IceFireDB/IceFireDB-SQLite/pkg/config"
+)
+
+func setSandbox(t *testing.T, conf config.SandboxC) {
+	old := config.Get().SQLite.Sandbox
+	config.Get().SQLite.Sandbox = conf
+	t.Cleanup(func() {
+		config.Get().SQLite.Sandbox = old
+		writers = nil
+	})
+	if err := initSandbox(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSplitStatements(t *testing.T) {
+	got := splitStatements("SELECT ';'; -- comment\n/* ; */ ATTACH 'x' AS y;;")
+	want := []string{"SELECT ';'", "ATTACH 'x' AS y"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("want %q, got %q", want, got)
+	}
+}
+
+func TestCheckStatement(t *testing.T) {
+	setSandbox(t, config.SandboxC{Enable: true, Deny: []string{"VACUUM"}})
+
+	for query, denied := range map[string]bool{
+		"SELECT * FROM t":                       false,
+		"PRAGMA table_info(t)":                  false,
+		"pragma main.index_list(t)":             false,
+		"PRAGMA user_version":                   false,
+		"INSERT INTO t (v) VALUES ('attach')":   false,
+		"ATTACH DATABASE '/etc/x.db' AS x":      true,
+		"/* hidden */ attach '/tmp/x.db' AS x":  true,
+		"SELECT 1; DETACH x":                    true,
+		"PRAGMA journal_mode = OFF":             true,
+		"PRAGMA writable_schema(1)":             true,
+		"SELECT load_extension('/tmp/evil.so')": true,
+		"VACUUM":                                true,
+		"VACUUMED":                              false,
+	} {
+		err := checkStatement(query)
+		if denied != errors.Is(err, ErrDenied) {
+			t.Errorf("%s: denied %v, got %v", query, denied, err)
+		}
+	}
+
+	setSandbox(t, config.SandboxC{Allow: []string{"SELECT", "INSERT"}})
+	if err := checkStatement("INSERT INTO t (v) VALUES (1); SELECT 1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkStatement("SELECT 1; DELETE FROM t"); !errors.Is(err, ErrDenied) {
+		t.Fatalf("statement out of the allowlist: want ErrDenied, got %v", err)
+	}
+}
+
+func TestSandboxWriters(t *testing.T) {
+	openTestDB(t)
+	writer := "12D3KooWD3eckifWpRn9wQpMG9R9hX3sD158z7EqHWmweQAJU5SA"
+	setSandbox(t, config.SandboxC{Enable: true, Writers: []string{writer}})
+
+	if err := applyOp(Op{Stmts: []string{"INSERT INTO t (v) VALUES ('a')"}, Origin: "other"}); !errors.Is(err, ErrWriterDenied) {
+		t.Fatalf("write of another peer: want ErrWriterDenied, got %v", err)
+	}
+	if err := applyOp(Op{Stmts: []string{"ATTACH '/tmp/x.db' AS x"}, Origin: writer}); !errors.Is(err, ErrDenied) {
+		t.Fatalf("denied statement of a writer: want ErrDenied, got %v", err)
+	}
+	if err := applyOp(Op{Stmts: []string{"INSERT INTO t (v) VALUES ('a')"}, Origin: writer}); err != nil {
+		t.Fatal(err)
+	}
+	if n := count(t); n != 1 {
+		t.Fatalf("want 1 row, got %d", n)
+	}
+
+	// the local clients are sandboxed too
+	if _, err := NewSession().Exec("PRAGMA journal_mode=OFF"); !errors.Is(err, ErrDenied) {
+		t.Fatalf("want ErrDenied, got %v", err)
+	}
+
+	config.Get().SQLite.Sandbox.Writers = []string{"invalid"}
+	if err := initSandbox(); err == nil {
+		t.Fatal("invalid writer accepted")
+	}
+}
@@ -0,0 +1,195 @@
+package sqlite
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// The schema version of the database is kept in its user_version header,
+// it is bumped by every DDL statement in the transaction of the statement
+
+// ErrSchemaMismatch is returned for the writes of a peer behind the local
+// schema version or on another schema
+var ErrSchemaMismatch = errors.New("schema version mismatch")
+
+// maxHeldOps bounds the writes held until the local schema catches up
+const maxHeldOps = 4096
+
+var (
+	heldMu sync.Mutex
+	// writes of newer schema versions, applied once the migrations arrive
+	heldOps []Op
+)
+
+// A function that reports whether a statement changes the schema
+func isDDL(stmt string) bool {
+	q := strings.ToUpper(strings.TrimSpace(stmt))
+	return strings.HasPrefix(q, "CREATE") || strings.HasPrefix(q, "ALTER") || strings.HasPrefix(q, "DROP")
+}
+
+// A method that reports whether the entry changes the schema
+func (op Op) ddl() bool {
+	for _, stmt := range op.Stmts {
+		if isDDL(stmt) {
+			return true
+		}
+	}
+	return false
+}
+
+// A function that returns the schema version of the database
+func schemaVersion(e execer) (int, error) {
+	rows, err := e.Query("PRAGMA user_version")
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+	var v int
+	if rows.Next() {
+		if err := rows.Scan(&v); err != nil {
+			return 0, err
+		}
+	}
+	return v, rows.Err()
+}
+
+func setSchemaVersion(e execer, v int) error {
+	_, err := e.Exec(fmt.Sprintf("PRAGMA user_version = %d", v))
+	return err
+}
+
+// A function that returns the fingerprint of the schema, a hash of the SQL
+// text of its tables, indexes, views and triggers. The nodes applying the
+// same migrations in the same order have the same fingerprint.
+func schemaHash(e execer) (string, error) {
+	rows, err := e.Query(`SELECT type, name, sql FROM sqlite_master WHERE sql IS NOT NULL AND tbl_name NOT LIKE '\_icefiredb\_%' ESCAPE '\' ORDER BY type, name`)
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+	h := sha256.New()
+	for rows.Next() {
+		var typ, name, stmt string
+		if err := rows.Scan(&typ, &name, &stmt); err != nil {
+			return "", err
+		}
+		fmt.Fprintf(h, "%s\x00%s\x00%s\x00", typ, name, stmt)
+	}
+	if err := rows.Err(); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// A function that checks that the schema is the one the write of a peer
+// left on its node. Peers running an older version send no fingerprint.
+func checkSchemaHash(e execer, op Op) error {
+	if op.SchemaHash == "" {
+		return nil
+	}
+	h, err := schemaHash(e)
+	if err != nil {
+		return err
+	}
+	if h != op.SchemaHash {
+		return fmt.Errorf("%w: write of %s at version %d on another schema", ErrSchemaMismatch, op.Origin, op.Schema)
+	}
+	return nil
+}
+
+// A function that sets the schema version of a local write and the
+// fingerprint of the schema it leaves, the version is bumped when the
+// write changes the schema
+func stampSchema(e execer, op *Op) error {
+	v, err := schemaVersion(e)
+	if err != nil {
+		return err
+	}
+	h, err := schemaHash(e)
+	if err != nil {
+		return err
+	}
+	op.Schema, op.SchemaHash = v, h
+	if op.ddl() {
+		return setSchemaVersion(e, v+1)
+	}
+	return nil
+}
+
+// A function that checks the schema left by a schema change of a peer,
+// run in its transaction, and sets the version the change was made on
+// plus one. A migration of the same version made differently on the peer
+// is rolled back.
+func migrateSchema(e execer, op Op) error {
+	if err := checkSchemaHash(e, op); err != nil {
+		return err
+	}
+	return setSchemaVersion(e, op.Schema+1)
+}
+
+// A function that checks the schema version of a write received from a peer.
+// The writes of a newer version are held until the migrations are applied,
+// the writes of an older version are refused until the peer catches up, as
+// are the writes of the same version made on another schema.
+func checkSchema(op Op) (bool, error) {
+	v, err := schemaVersion(db)
+	if err != nil {
+		return false, err
+	}
+	switch {
+	case op.Schema < v:
+		return false, fmt.Errorf("%w: write of %s at version %d, local version %d", ErrSchemaMismatch, op.Origin, op.Schema, v)
+	case op.Schema > v:
+		heldMu.Lock()
+		defer heldMu.Unlock()
+		if len(heldOps) >= maxHeldOps {
+			return false, fmt.Errorf("%w: write of %s at version %d, local version %d, too many held writes", ErrSchemaMismatch, op.Origin, op.Schema, v)
+		}
+		heldOps = append(heldOps, op)
+		logrus.Infof("Inbound sql held until schema version %d, local version %d", op.Schema, v)
+		return false, nil
+	}
+	// the fingerprint of a schema change is checked after it is applied
+	if !op.ddl() {
+		if err := checkSchemaHash(db, op); err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// A function that applies the held writes of the current schema version
+func applyHeld() {
+	for {
+		op, ok := nextHeld()
+		if !ok {
+			return
+		}
+		if err := applyOp(op); err != nil {
+			logrus.Infof("Held sql: %v err: %v", op.Stmts, err)
+		}
+	}
+}
+
+// A function that takes the first held write not newer than the schema
+func nextHeld() (Op, bool) {
+	v, err := schemaVersion(db)
+	if err != nil {
+		return Op{}, false
+	}
+	heldMu.Lock()
+	defer heldMu.Unlock()
+	for i, op := range heldOps {
+		if op.Schema <= v {
+			heldOps = append(heldOps[:i], heldOps[i+1:]...)
+			return op, true
+		}
+	}
+	return Op{}, false
+}
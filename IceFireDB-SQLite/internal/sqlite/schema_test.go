@@ -0,0 +1,169 @@
+package sqlite
+
+import (
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func version(t *testing.T) int {
+	v, err := schemaVersion(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+func TestSchemaVersionLocal(t *testing.T) {
+	openTestDB(t)
+	ops := captureOutbound(t)
+
+	s := NewSession()
+	if _, err := s.Exec("ALTER TABLE t ADD COLUMN w TEXT"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Exec("INSERT INTO t (v, w) VALUES ('a', 'b')"); err != nil {
+		t.Fatal(err)
+	}
+	for _, q := range []string{"BEGIN", "CREATE INDEX t_v ON t (v)", "COMMIT"} {
+		if _, err := s.Exec(q); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if v := version(t); v != 2 {
+		t.Fatalf("want schema version 2, got %d", v)
+	}
+	if len(*ops) != 3 {
+		t.Fatalf("want 3 published writes, got %d", len(*ops))
+	}
+	for i, want := range []int{0, 1, 1} {
+		if got := (*ops)[i].Schema; got != want {
+			t.Errorf("write %d: want schema version %d, got %d", i, want, got)
+		}
+	}
+}
+
+func TestSchemaVersionInbound(t *testing.T) {
+	openTestDB(t)
+	t.Cleanup(func() { heldOps = nil })
+
+	// a write made after a migration not received yet is held
+	insert := Op{Stmts: []string{"INSERT INTO t (v, w) VALUES ('a', 'b')"}, Schema: 1}
+	if err := applyOp(insert); err != nil {
+		t.Fatal(err)
+	}
+	if len(heldOps) != 1 {
+		t.Fatalf("want 1 held write, got %d", len(heldOps))
+	}
+	if err := applyOp(Op{Stmts: []string{"ALTER TABLE t ADD COLUMN w TEXT"}}); err != nil {
+		t.Fatal(err)
+	}
+	if v := version(t); v != 1 {
+		t.Fatalf("want schema version 1, got %d", v)
+	}
+	if len(heldOps) != 0 || count(t) != 1 {
+		t.Fatal("held write not applied after the migration")
+	}
+
+	// a peer behind the schema is refused
+	err := applyOp(Op{Stmts: []string{"INSERT INTO t (v) VALUES ('c')"}, Schema: 0})
+	if !errors.Is(err, ErrSchemaMismatch) {
+		t.Fatalf("want ErrSchemaMismatch, got %v", err)
+	}
+
+	// too many writes of a newer version
+	heldOps = make([]Op, maxHeldOps)
+	err = applyOp(Op{Stmts: []string{"INSERT INTO t (v) VALUES ('d')"}, Schema: 2})
+	if !errors.Is(err, ErrSchemaMismatch) {
+		t.Fatalf("want ErrSchemaMismatch, got %v", err)
+	}
+	heldOps = nil
+
+	// the snapshot keeps the schema version
+	path, err := takeSnapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := restoreSnapshot(path, filepath.Join(t.TempDir(), "joining.db")); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if v := version(t); v != 1 {
+		t.Fatalf("want schema version 1 in the snapshot, got %d", v)
+	}
+}
+
+func TestSchemaHash(t *testing.T) {
+	t.Cleanup(func() { heldOps = nil })
+	ops := captureOutbound(t)
+	openTestDB(t)
+	a := db
+	openTestDB(t)
+	b := db
+	t.Cleanup(func() { a.Close() })
+
+	exec := func(node *sql.DB, stmt string) Op {
+		db = node
+		if _, err := NewSession().Exec(stmt); err != nil {
+			t.Fatalf("%s: %v", stmt, err)
+		}
+		return (*ops)[len(*ops)-1]
+	}
+	apply := func(node *sql.DB, op Op) error {
+		db = node
+		return applyOp(op)
+	}
+
+	// the same migration run on both nodes leaves the same schema
+	fromA := exec(a, "CREATE TABLE u (id INTEGER PRIMARY KEY)")
+	fromB := exec(b, "CREATE TABLE u (id INTEGER PRIMARY KEY)")
+	if fromA.SchemaHash == "" || fromA.SchemaHash != fromB.SchemaHash {
+		t.Fatalf("want the same fingerprint, got %q and %q", fromA.SchemaHash, fromB.SchemaHash)
+	}
+	// the migration of the other node is behind, its writes are applied
+	if err := apply(a, fromB); !errors.Is(err, ErrSchemaMismatch) {
+		t.Fatalf("want ErrSchemaMismatch, got %v", err)
+	}
+	if err := apply(b, exec(a, "INSERT INTO t (v) VALUES ('a')")); err != nil {
+		t.Fatal(err)
+	}
+
+	// different migrations of the same version are refused by each other,
+	// as are the writes made on them
+	fromA = exec(a, "ALTER TABLE t ADD COLUMN w TEXT")
+	fromB = exec(b, "CREATE INDEX t_v ON t (v)")
+	for _, d := range []struct {
+		node *sql.DB
+		op   Op
+	}{
+		{a, fromB},
+		{b, fromA},
+		{a, exec(b, "INSERT INTO t (v) VALUES ('b')")},
+		{b, exec(a, "INSERT INTO t (v) VALUES ('c')")},
+	} {
+		if err := apply(d.node, d.op); !errors.Is(err, ErrSchemaMismatch) {
+			t.Fatalf("%v: want ErrSchemaMismatch, got %v", d.op.Stmts, err)
+		}
+	}
+	for name, node := range map[string]*sql.DB{"a": a, "b": b} {
+		db = node
+		if v := version(t); v != 2 {
+			t.Errorf("%s: want schema version 2, got %d", name, v)
+		}
+	}
+
+	// a migration leaving another schema than on its peer is rolled back
+	openTestDB(t)
+	c := db
+	if _, err := c.Exec("CREATE TABLE u (id TEXT)"); err != nil {
+		t.Fatal(err)
+	}
+	op := Op{Stmts: []string{"CREATE TABLE IF NOT EXISTS u (id INTEGER PRIMARY KEY)"}, SchemaHash: (*ops)[0].SchemaHash}
+	if err := apply(c, op); !errors.Is(err, ErrSchemaMismatch) {
+		t.Fatalf("want ErrSchemaMismatch, got %v", err)
+	}
+	if v := version(t); v != 0 {
+		t.Fatalf("want schema version 0 after the rollback, got %d", v)
+	}
+}
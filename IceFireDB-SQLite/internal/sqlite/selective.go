@@ -0,0 +1,172 @@
+package sqlite
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/IceFireDB/IceFireDB/IceFireDB-SQLite/pkg/config"
+	"github.com/IceFireDB/IceFireDB/IceFireDB-SQLite/pkg/p2p"
+	"github.com/sirupsen/logrus"
+)
+
+// The writes of the tables routed to a topic are published on it instead of
+// the service command topic. An entry writing the tables of several topics,
+// and every schema change, goes on the service command topic, which every
+// node receives, so the schema versions stay in step.
+
+// routing represents the replication topics of the tables
+type routing struct {
+	// topic of each routed table
+	topics map[string]string
+	// topics whose writes are not received
+	publishOnly map[string]bool
+	// condition of the rows kept of each filtered table
+	filters map[string]string
+}
+
+var (
+	tableRouting = routing{}
+	// joined topics by name
+	topicPubSubs = make(map[string]*p2p.PubSub)
+)
+
+// A function that builds the routing of the tables from the config
+func newRouting(conf config.ReplicationC) (routing, error) {
+	r := routing{
+		topics:      make(map[string]string),
+		publishOnly: make(map[string]bool),
+		filters:     make(map[string]string),
+	}
+	for _, t := range conf.Topics {
+		if t.Name == "" {
+			return r, fmt.Errorf("replication topic name is required")
+		}
+		if _, ok := r.publishOnly[t.Name]; ok {
+			return r, fmt.Errorf("duplicate replication topic %q", t.Name)
+		}
+		r.publishOnly[t.Name] = t.PublishOnly
+		for _, table := range t.Tables {
+			table = strings.ToLower(table)
+			if topic, ok := r.topics[table]; ok {
+				return r, fmt.Errorf("table %s routed to the topics %q and %q", table, topic, t.Name)
+			}
+			r.topics[table] = t.Name
+		}
+	}
+	for _, f := range conf.Filters {
+		table := strings.ToLower(f.Table)
+		if table == "" || strings.TrimSpace(f.Where) == "" {
+			return r, fmt.Errorf("replication filter requires a table and a where condition")
+		}
+		if _, ok := r.filters[table]; ok {
+			return r, fmt.Errorf("duplicate replication filter of table %s", table)
+		}
+		r.filters[table] = f.Where
+	}
+	return r, nil
+}
+
+// A method that returns the topic an entry is published on, empty for the
+// service command topic
+func (r routing) topic(op Op) string {
+	if op.ddl() {
+		return ""
+	}
+	topic := ""
+	for i, table := range op.tables() {
+		t := r.topics[table]
+		if i > 0 && t != topic {
+			return ""
+		}
+		topic = t
+	}
+	return topic
+}
+
+// A method that returns the statement deleting the rows of a table out of
+// its filter, and every row of a publish only table with publishOnly, empty
+// when the rows are kept
+func (r routing) prune(table string, publishOnly bool) string {
+	if publishOnly && r.publishOnly[r.topics[table]] {
+		return "DELETE FROM " + quoteIdent(table)
+	}
+	if where, ok := r.filters[table]; ok {
+		return fmt.Sprintf("DELETE FROM %s WHERE NOT (%s)", quoteIdent(table), where)
+	}
+	return ""
+}
+
+func quoteIdent(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+// A function that sets up the routing of the config
+func initRouting() error {
+	r, err := newRouting(config.Get().P2P.Replication)
+	if err != nil {
+		return err
+	}
+	tableRouting = r
+	return nil
+}
+
+// A function that joins the replication topics, without subscribing to the
+// publish only ones
+func joinTopics() error {
+	conf := config.Get().P2P
+	for name, publishOnly := range tableRouting.publishOnly {
+		ps, err := p2p.JoinTopic(p2pHost, "icefiredb-sqlite-client", conf.ServiceCommandTopic+"-"+name, !publishOnly)
+		if err != nil {
+			return err
+		}
+		topicPubSubs[name] = ps
+		logrus.Infof("Successfully joined [%s] replication topic, publish only: %v", name, publishOnly)
+	}
+	return nil
+}
+
+// A function that consumes the entries of the subscribed replication topics
+func consumeTopics(ctx context.Context) {
+	for _, ps := range topicPubSubs {
+		if ps.Inbound != nil {
+			consume(ctx, ps)
+		}
+	}
+}
+
+// A function that deletes the rows of the tables out of their filters, and
+// the rows of the publish only tables with publishOnly
+func pruneTables(tables []string, publishOnly bool) error {
+	for _, table := range tables {
+		if stmt := tableRouting.prune(table, publishOnly); stmt != "" {
+			if _, err := db.Exec(stmt); err != nil {
+				return fmt.Errorf("prune table %s: %w", table, err)
+			}
+		}
+	}
+	return nil
+}
+
+// A function that prunes every table, the rows of the publish only tables
+// are only deleted from a snapshot, which holds the rows of every node
+func pruneAll(publishOnly bool) error {
+	rows, err := db.Query("SELECT name FROM sqlite_master WHERE type = 'table'")
+	if err != nil {
+		return err
+	}
+	var tables []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return err
+		}
+		tables = append(tables, strings.ToLower(name))
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	return pruneTables(tables, publishOnly)
+}
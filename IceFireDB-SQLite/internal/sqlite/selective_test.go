@@ -0,0 +1,114 @@
+package sqlite
+
+import (
+	"testing"
+
+	"github.com/IceFireDB/IceFireDB/IceFireDB-SQLite/pkg/config"
+)
+
+func setRouting(t *testing.T, conf config.ReplicationC) {
+	r, err := newRouting(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := tableRouting
+	tableRouting = r
+	t.Cleanup(func() { tableRouting = old })
+}
+
+func TestNewRouting(t *testing.T) {
+	for _, conf := range []config.ReplicationC{
+		{Topics: []config.ReplicationTopicC{{Tables: []string{"t"}}}},
+		{Topics: []config.ReplicationTopicC{{Name: "a"}, {Name: "a"}}},
+		{Topics: []config.ReplicationTopicC{{Name: "a", Tables: []string{"t"}}, {Name: "b", Tables: []string{"T"}}}},
+		{Filters: []config.RowFilterC{{Table: "t"}}},
+		{Filters: []config.RowFilterC{{Table: "t", Where: "id > 0"}, {Table: "t", Where: "id < 9"}}},
+	} {
+		if _, err := newRouting(conf); err == nil {
+			t.Errorf("config %+v must be refused", conf)
+		}
+	}
+}
+
+func TestRoutingTopic(t *testing.T) {
+	r, err := newRouting(config.ReplicationC{Topics: []config.ReplicationTopicC{
+		{Name: "a", Tables: []string{"T"}},
+		{Name: "b", Tables: []string{"u"}},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		stmts []string
+		topic string
+	}{
+		{[]string{"INSERT INTO t (v) VALUES ('a')"}, "a"},
+		{[]string{"UPDATE t SET v = 'b'", "DELETE FROM t"}, "a"},
+		{[]string{"INSERT INTO v (v) VALUES ('a')"}, ""},
+		{[]string{"INSERT INTO t (v) VALUES ('a')", "INSERT INTO u (v) VALUES ('a')"}, ""},
+		{[]string{"ALTER TABLE t ADD COLUMN w TEXT"}, ""},
+	} {
+		if topic := r.topic(Op{Stmts: c.stmts}); topic != c.topic {
+			t.Errorf("%v published on %q, want %q", c.stmts, topic, c.topic)
+		}
+	}
+}
+
+func TestRowFilter(t *testing.T) {
+	openTestDB(t)
+	ops := captureOutbound(t)
+	setRouting(t, config.ReplicationC{Filters: []config.RowFilterC{{Table: "t", Where: "v = 'edge'"}}})
+
+	// the local writes are published whatever the filter
+	s := NewSession()
+	if _, err := s.Exec("INSERT INTO t (id, v) VALUES (1, 'local')"); err != nil {
+		t.Fatal(err)
+	}
+	if len(*ops) != 1 {
+		t.Fatalf("want 1 published entry, got %d", len(*ops))
+	}
+
+	// the rows out of the filter are dropped once a write is received
+	if err := applyOp(Op{Stmts: []string{
+		"INSERT INTO t (id, v) VALUES (2, 'edge')",
+		"INSERT INTO t (id, v) VALUES (3, 'core')",
+	}}); err != nil {
+		t.Fatal(err)
+	}
+	var ids []int
+	rows, err := db.Query("SELECT id FROM t ORDER BY id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+	if len(ids) != 1 || ids[0] != 2 {
+		t.Fatalf("want the row 2 kept, got %v", ids)
+	}
+}
+
+func TestPrunePublishOnly(t *testing.T) {
+	openTestDB(t)
+	setRouting(t, config.ReplicationC{Topics: []config.ReplicationTopicC{{Name: "a", Tables: []string{"t"}, PublishOnly: true}}})
+	if _, err := db.Exec("INSERT INTO t (v) VALUES ('a')"); err != nil {
+		t.Fatal(err)
+	}
+	if err := pruneAll(false); err != nil {
+		t.Fatal(err)
+	}
+	if n := count(t); n != 1 {
+		t.Fatalf("local rows must be kept, got %d", n)
+	}
+	if err := pruneAll(true); err != nil {
+		t.Fatal(err)
+	}
+	if n := count(t); n != 0 {
+		t.Fatalf("snapshot rows of a publish only table must be deleted, got %d", n)
+	}
+}
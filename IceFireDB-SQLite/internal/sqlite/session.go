@@ -0,0 +1,218 @@
+package sqlite
+
+import (
+	"database/sql"
+	"strings"
+
+	"github.com/IceFireDB/IceFireDB/IceFireDB-SQLite/pkg/mysql/mysql"
+)
+
+// Kinds of statements, as far as the transactions are concerned
+const (
+	stmtRead = iota
+	stmtWrite
+	stmtBegin
+	stmtCommit
+	stmtRollback
+)
+
+// Session represents the state of a client connection: its open transaction
+// and the statements run in it. The statements of a transaction are
+// replicated together once it commits, so peers apply all of them or none.
+type Session struct {
+	tx *sql.Tx
+	op Op
+}
+
+// NewSession creates the session of a client connection
+func NewSession() *Session {
+	return &Session{}
+}
+
+// InTransaction reports whether the session has an open transaction
+func (s *Session) InTransaction() bool {
+	return s.tx != nil
+}
+
+// Exec runs a statement of the client. Writes outside a transaction are
+// replicated at once, writes in a transaction when it commits.
+func (s *Session) Exec(query string) (*mysql.Result, error) {
+	return s.exec(query, nil, false)
+}
+
+// ExecStmt runs a prepared statement with the arguments bound to its
+// placeholders, which are replicated along with the statement.
+// Rows are built for the binary protocol.
+func (s *Session) ExecStmt(query string, args []interface{}) (*mysql.Result, error) {
+	return s.exec(query, args, true)
+}
+
+func (s *Session) exec(query string, args []interface{}, binary bool) (*mysql.Result, error) {
+	if err := checkStatement(query); err != nil {
+		return nil, err
+	}
+	switch statementKind(query) {
+	case stmtBegin:
+		// like MySQL, a transaction started in a transaction commits it
+		if err := s.commit(); err != nil {
+			return nil, err
+		}
+		tx, err := sessionDB().Begin()
+		if err != nil {
+			return nil, err
+		}
+		s.tx = tx
+		return &mysql.Result{Status: mysql.SERVER_STATUS_IN_TRANS}, nil
+	case stmtCommit:
+		return &mysql.Result{Status: mysql.SERVER_STATUS_AUTOCOMMIT}, s.commit()
+	case stmtRollback:
+		return &mysql.Result{Status: mysql.SERVER_STATUS_AUTOCOMMIT}, s.rollback()
+	case stmtWrite:
+		if err := checkLocalWrite(); err != nil {
+			return nil, err
+		}
+		values, err := encodeArgs(args)
+		if err != nil {
+			return nil, err
+		}
+		// the statement runs with the arguments the peers apply
+		args = decodeArgs(values)
+		if s.tx == nil {
+			op := Op{}
+			op.add(query, values)
+			res, err := autocommit(&op, query, args)
+			if err == nil {
+				publish(op)
+			}
+			return res, err
+		}
+		res, err := execWrite(s.tx, query, args...)
+		if err != nil {
+			return nil, err
+		}
+		s.op.add(query, values)
+		res.Status = mysql.SERVER_STATUS_IN_TRANS
+		return res, nil
+	}
+
+	if s.tx != nil {
+		return execQuery(s.tx, query, binary, args...)
+	}
+	return execQuery(sessionDB(), query, binary, args...)
+}
+
+// Close rolls back the open transaction of a closed connection
+func (s *Session) Close() error {
+	return s.rollback()
+}
+
+// A method that commits the open transaction and replicates its statements
+func (s *Session) commit() error {
+	if s.tx == nil {
+		return nil
+	}
+	tx, op := s.tx, s.op
+	s.tx, s.op = nil, Op{}
+	if err := stampSchema(tx, &op); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	if err := stampLocal(tx, &op); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	if len(op.Stmts) > 0 {
+		op.Tx = true
+		publish(op)
+	}
+	return nil
+}
+
+// A function that runs a write out of a transaction, a schema change
+// bumps the schema version in the transaction of the statement and the
+// lww mode records the time of the write in it
+func autocommit(op *Op, query string, args []interface{}) (*mysql.Result, error) {
+	if !op.ddl() && conflictMode != ConflictLWW {
+		if err := stampSchema(db, op); err != nil {
+			return nil, err
+		}
+		return execWrite(db, query, args...)
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	res, err := execWrite(tx, query, args...)
+	if err == nil {
+		err = stampSchema(tx, op)
+	}
+	if err == nil {
+		err = stampLocal(tx, op)
+	}
+	if err != nil {
+		_ = tx.Rollback()
+		return nil, err
+	}
+	return res, tx.Commit()
+}
+
+// A method that rolls back the open transaction, nothing is replicated
+func (s *Session) rollback() error {
+	if s.tx == nil {
+		return nil
+	}
+	tx := s.tx
+	s.tx, s.op = nil, Op{}
+	return tx.Rollback()
+}
+
+// A function that classifies a statement. ROLLBACK TO a savepoint stays
+// in the transaction and is replicated with the other statements.
+func statementKind(query string) int {
+	q := strings.ToUpper(strings.TrimRight(strings.TrimSpace(query), "; "))
+	switch {
+	case q == "BEGIN" || strings.HasPrefix(q, "BEGIN ") || strings.HasPrefix(q, "START TRANSACTION"):
+		return stmtBegin
+	case q == "COMMIT" || strings.HasPrefix(q, "COMMIT ") || q == "END" || strings.HasPrefix(q, "END TRANSACTION"):
+		return stmtCommit
+	case q == "ROLLBACK" || q == "ROLLBACK TRANSACTION":
+		return stmtRollback
+	case isDML(q) || strings.HasPrefix(q, "SAVEPOINT") || strings.HasPrefix(q, "RELEASE") || strings.HasPrefix(q, "ROLLBACK"):
+		return stmtWrite
+	}
+	return stmtRead
+}
+
+// ParamCount returns the number of placeholders of a prepared statement,
+// the question marks out of the quoted strings, identifiers and comments
+func ParamCount(query string) int {
+	n := 0
+	for i := 0; i < len(query); i++ {
+		switch c := query[i]; {
+		case c == '\'' || c == '"' || c == '`':
+			if j := strings.IndexByte(query[i+1:], c); j >= 0 {
+				i += j + 1
+			} else {
+				i = len(query)
+			}
+		case c == '-' && strings.HasPrefix(query[i:], "--"):
+			if j := strings.IndexByte(query[i:], '\n'); j >= 0 {
+				i += j
+			} else {
+				i = len(query)
+			}
+		case c == '/' && strings.HasPrefix(query[i:], "/*"):
+			if j := strings.Index(query[i+2:], "*/"); j >= 0 {
+				i += j + 3
+			} else {
+				i = len(query)
+			}
+		case c == '?':
+			n++
+		}
+	}
+	return n
+}
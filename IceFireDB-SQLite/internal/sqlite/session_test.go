@@ -0,0 +1,312 @@
+package sqlite
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func openTestDB(t *testing.T) string {
+	filename := filepath.Join(t.TempDir(), "test.db")
+	var err error
+	db, err = sql.Open("sqlite3", dataSource(filename))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if _, err := db.Exec("CREATE TABLE t (id INTEGER PRIMARY KEY, v TEXT)"); err != nil {
+		t.Fatal(err)
+	}
+	return filename
+}
+
+func captureOutbound(t *testing.T) *[]Op {
+	ops := &[]Op{}
+	old := outbound
+	outbound = func(topic, msg string) {
+		var op Op
+		if err := json.Unmarshal([]byte(msg), &op); err != nil {
+			t.Errorf("outbound message %s: %v", msg, err)
+		}
+		*ops = append(*ops, op)
+	}
+	t.Cleanup(func() { outbound = old })
+	return ops
+}
+
+func count(t *testing.T) int {
+	var n int
+	if err := db.QueryRow("SELECT COUNT(*) FROM t").Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestSessionCommit(t *testing.T) {
+	openTestDB(t)
+	ops := captureOutbound(t)
+
+	s := NewSession()
+	for _, q := range []string{
+		"BEGIN",
+		"INSERT INTO t (v) VALUES ('a')",
+		"INSERT INTO t (v) VALUES ('b')",
+		"SELECT * FROM t",
+	} {
+		if _, err := s.Exec(q); err != nil {
+			t.Fatalf("%s: %v", q, err)
+		}
+	}
+	if !s.InTransaction() {
+		t.Fatal("session should be in a transaction")
+	}
+	if len(*ops) != 0 {
+		t.Fatalf("statements published before commit: %v", *ops)
+	}
+	if _, err := s.Exec("COMMIT;"); err != nil {
+		t.Fatal(err)
+	}
+	if s.InTransaction() {
+		t.Fatal("session should not be in a transaction")
+	}
+	if len(*ops) != 1 || !(*ops)[0].Tx || len((*ops)[0].Stmts) != 2 {
+		t.Fatalf("want one transaction of 2 statements, got %v", *ops)
+	}
+	if n := count(t); n != 2 {
+		t.Fatalf("want 2 rows, got %d", n)
+	}
+
+	if _, err := s.Exec("INSERT INTO t (v) VALUES ('c')"); err != nil {
+		t.Fatal(err)
+	}
+	if len(*ops) != 2 || (*ops)[1].Tx {
+		t.Fatalf("autocommit statement not published alone: %v", *ops)
+	}
+}
+
+func TestSessionRollback(t *testing.T) {
+	openTestDB(t)
+	ops := captureOutbound(t)
+
+	s := NewSession()
+	for _, q := range []string{"START TRANSACTION", "INSERT INTO t (v) VALUES ('a')", "ROLLBACK"} {
+		if _, err := s.Exec(q); err != nil {
+			t.Fatalf("%s: %v", q, err)
+		}
+	}
+	if len(*ops) != 0 {
+		t.Fatalf("rolled back statements published: %v", *ops)
+	}
+	if n := count(t); n != 0 {
+		t.Fatalf("want 0 rows, got %d", n)
+	}
+
+	// a closed connection rolls back its transaction
+	s.Exec("BEGIN")
+	s.Exec("INSERT INTO t (v) VALUES ('a')")
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if len(*ops) != 0 || count(t) != 0 {
+		t.Fatal("transaction of a closed session not rolled back")
+	}
+}
+
+func TestApplyOp(t *testing.T) {
+	openTestDB(t)
+
+	err := applyOp(Op{Tx: true, Stmts: []string{
+		"INSERT INTO t (id, v) VALUES (1, 'a')",
+		"INSERT INTO t (id, v) VALUES (1, 'b')",
+	}})
+	if err == nil {
+		t.Fatal("want a constraint error")
+	}
+	if n := count(t); n != 0 {
+		t.Fatalf("failed transaction applied partially, %d rows", n)
+	}
+
+	op, err := decodeOp("INSERT INTO t (id, v) VALUES (1, 'a')")
+	if err != nil || op.Tx || len(op.Stmts) != 1 {
+		t.Fatalf("plain statement decoded as %v, %v", op, err)
+	}
+	if err := applyOp(op); err != nil {
+		t.Fatal(err)
+	}
+	if n := count(t); n != 1 {
+		t.Fatalf("want 1 row, got %d", n)
+	}
+}
+
+func TestApplyDuringTransaction(t *testing.T) {
+	defer func(d, r time.Duration) { busyTimeout, inboundRetryDelay = d, r }(busyTimeout, inboundRetryDelay)
+	busyTimeout, inboundRetryDelay = 200*time.Millisecond, 50*time.Millisecond
+	// the busy timeout waits for the short transaction, the entries are
+	// applied again after the long one
+	for _, hold := range []time.Duration{100 * time.Millisecond, time.Second} {
+		openTestDB(t)
+		captureOutbound(t)
+		ctx, cancel := context.WithCancel(context.Background())
+		q := newInbound()
+		stopped := make(chan struct{})
+		go func() {
+			defer close(stopped)
+			q.run(ctx)
+		}()
+
+		s := NewSession()
+		for _, stmt := range []string{"BEGIN", "INSERT INTO t (v) VALUES ('local')"} {
+			if _, err := s.Exec(stmt); err != nil {
+				t.Fatalf("%s: %v", stmt, err)
+			}
+		}
+		q.push(message{msg: `{"stmts":["INSERT INTO t (v) VALUES ('peer 1')","INSERT INTO t (v) VALUES ('peer 2')"]}`})
+		q.push(message{msg: `{"stmts":["INSERT INTO t (v) VALUES ('peer 3')"]}`})
+		time.Sleep(hold)
+		if _, err := s.Exec("COMMIT"); err != nil {
+			t.Fatal(err)
+		}
+		for deadline := time.Now().Add(5 * time.Second); count(t) < 4 && time.Now().Before(deadline); {
+			time.Sleep(10 * time.Millisecond)
+		}
+		cancel()
+		<-stopped
+
+		var got []string
+		rows, err := db.Query("SELECT v FROM t ORDER BY id")
+		if err != nil {
+			t.Fatal(err)
+		}
+		for rows.Next() {
+			var v string
+			if err := rows.Scan(&v); err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, v)
+		}
+		rows.Close()
+		if want := []string{"local", "peer 1", "peer 2", "peer 3"}; !reflect.DeepEqual(got, want) {
+			t.Fatalf("transaction held %v: want %v, got %v", hold, want, got)
+		}
+	}
+}
+
+func TestSessionExecStmt(t *testing.T) {
+	openTestDB(t)
+	ops := captureOutbound(t)
+
+	s := NewSession()
+	if _, err := s.ExecStmt("INSERT INTO t (id, v) VALUES (?, ?)", []interface{}{int64(1), []byte("a'b")}); err != nil {
+		t.Fatal(err)
+	}
+	if len(*ops) != 1 || len((*ops)[0].Args) != 1 {
+		t.Fatalf("want one statement with its arguments, got %v", *ops)
+	}
+	op := (*ops)[0]
+	if args := op.args(0); args[0] != int64(1) || string(args[1].([]byte)) != "a'b" {
+		t.Fatalf("replicated arguments %v", args)
+	}
+
+	res, err := s.ExecStmt("SELECT v FROM t WHERE v = ?", []interface{}{[]byte("a'b")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.RowDatas) != 1 {
+		t.Fatalf("want 1 row, got %d", len(res.RowDatas))
+	}
+
+	// the peers apply the statement with the same arguments
+	if _, err := db.Exec("DELETE FROM t"); err != nil {
+		t.Fatal(err)
+	}
+	if err := applyOp(op); err != nil {
+		t.Fatal(err)
+	}
+	var v string
+	if err := db.QueryRow("SELECT v FROM t WHERE id = 1").Scan(&v); err != nil || v != "a'b" {
+		t.Fatalf("applied row %q, %v", v, err)
+	}
+}
+
+func TestBlobArgs(t *testing.T) {
+	openTestDB(t)
+	ops := captureOutbound(t)
+	blob := []byte{0, 'a', 0xff, '\''}
+	if _, err := NewSession().ExecStmt("INSERT INTO t (id, v) VALUES (?, ?)", []interface{}{uint64(1), blob}); err != nil {
+		t.Fatal(err)
+	}
+	check := func(where string) {
+		var v []byte
+		var typ string
+		if err := db.QueryRow("SELECT v, typeof(v) FROM t WHERE id = 1").Scan(&v, &typ); err != nil || !bytes.Equal(v, blob) || typ != "blob" {
+			t.Fatalf("%s: %q %s, %v", where, v, typ, err)
+		}
+	}
+	check("local row")
+
+	// the peers store the same blob
+	data, err := json.Marshal((*ops)[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("DELETE FROM t"); err != nil {
+		t.Fatal(err)
+	}
+	applyMessage(context.Background(), "", string(data))
+	check("applied row")
+}
+
+func TestOpArgs(t *testing.T) {
+	op := Op{}
+	op.add("INSERT INTO t (v) VALUES ('a')", nil)
+	values, err := encodeArgs([]interface{}{nil, int8(-1), uint32(2), 1.5, "s", []byte{0, 1}, uint64(1) << 63})
+	if err != nil {
+		t.Fatal(err)
+	}
+	op.add("INSERT INTO t (id, v) VALUES (?, ?)", values)
+	if len(op.Args) != 2 || len(op.args(0)) != 0 {
+		t.Fatalf("arguments not aligned with the statements: %v", op.Args)
+	}
+	data, err := json.Marshal(op)
+	if err != nil {
+		t.Fatal(err)
+	}
+	op, err = decodeOp(string(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	args := op.args(1)
+	want := []interface{}{nil, int64(-1), int64(2), 1.5, "s", []byte{0, 1}, "9223372036854775808"}
+	for i := range want {
+		if b, ok := want[i].([]byte); ok {
+			if got, _ := args[i].([]byte); !bytes.Equal(got, b) {
+				t.Fatalf("argument %d: want %v, got %v", i, want[i], args[i])
+			}
+			continue
+		}
+		if args[i] != want[i] {
+			t.Fatalf("argument %d: want %v, got %v", i, want[i], args[i])
+		}
+	}
+}
+
+func TestParamCount(t *testing.T) {
+	for query, n := range map[string]int{
+		"SELECT * FROM t":                                 0,
+		"SELECT * FROM t WHERE id = ?":                    1,
+		"INSERT INTO t (id, v) VALUES (?, '?')":           1,
+		"UPDATE `t?` SET v = ? WHERE v = \"?\" OR id = ?": 2,
+		"SELECT ? -- why?\nFROM t WHERE id = ?":           2,
+		"SELECT /* a ? in a comment */ * FROM t -- ?":     0,
+		"SELECT * FROM t WHERE v = 'it''s ?' AND id = ?":  1,
+	} {
+		if got := ParamCount(query); got != n {
+			t.Errorf("%s: want %d params, got %d", query, n, got)
+		}
+	}
+}
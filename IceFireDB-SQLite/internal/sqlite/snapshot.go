@@ -0,0 +1,165 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/IceFireDB/IceFireDB/IceFireDB-SQLite/pkg/config"
+	"github.com/IceFireDB/IceFireDB/IceFireDB-SQLite/pkg/p2p"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/sirupsen/logrus"
+)
+
+// A function that writes a consistent copy of the database to a temporary
+// file, VACUUM INTO reads it in a single transaction
+func takeSnapshot() (string, error) {
+	f, err := os.CreateTemp("", "icefiredb-sqlite-snapshot-*.db")
+	if err != nil {
+		return "", err
+	}
+	path := f.Name()
+	_ = f.Close()
+	// VACUUM INTO does not overwrite a file
+	_ = os.Remove(path)
+	if _, err := db.Exec("VACUUM INTO ?", path); err != nil {
+		_ = os.Remove(path)
+		return "", err
+	}
+	return path, nil
+}
+
+// A function that reports whether the database has no table but the ones of the node
+func isEmpty() (bool, error) {
+	var n int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE tbl_name NOT LIKE '\_icefiredb\_%' ESCAPE '\'`).Scan(&n); err != nil {
+		return false, err
+	}
+	return n == 0, nil
+}
+
+// A function that replaces the database with a snapshot file
+func restoreSnapshot(path, filename string) error {
+	if err := db.Close(); err != nil {
+		return err
+	}
+	for _, suffix := range []string{"-wal", "-shm", "-journal"} {
+		if err := os.Remove(filename + suffix); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	if err := os.Rename(path, filename); err != nil {
+		return err
+	}
+	var err error
+	db, err = sql.Open("sqlite3", dataSource(filename))
+	return err
+}
+
+// message is an entry of the log received during the snapshot transfer
+type message struct {
+	sender string
+	msg    string
+}
+
+// A function that fetches the database of a peer of the topic when the
+// local one is empty. The entries of the log received during the transfer
+// are applied on the snapshot, so statements published while the snapshot
+// was taken may be applied twice.
+func bootstrap(ctx context.Context, filename string) error {
+	if filename == "" || strings.Contains(filename, ":memory:") {
+		logrus.Warn("Snapshot bootstrap needs a database file, skipped")
+		return nil
+	}
+	empty, err := isEmpty()
+	if err != nil || !empty {
+		return err
+	}
+
+	var (
+		mu      sync.Mutex
+		pending []message
+		wg      sync.WaitGroup
+	)
+	done := make(chan struct{})
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-done:
+				return
+			case s, ok := <-p2pPubSub.Inbound:
+				if !ok {
+					return
+				}
+				mu.Lock()
+				pending = append(pending, message{sender: s.SenderID, msg: s.Message})
+				mu.Unlock()
+			}
+		}
+	}()
+	err = fetchSnapshot(ctx, filename)
+	close(done)
+	wg.Wait()
+	if err != nil {
+		return err
+	}
+
+	for _, m := range pending {
+		applyMessage(ctx, m.sender, m.msg)
+	}
+	return nil
+}
+
+// A function that fetches a snapshot from a peer of the topic and restores it
+func fetchSnapshot(ctx context.Context, filename string) error {
+	timeout := time.Duration(config.Get().P2P.Snapshot.Timeout) * time.Second
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	peers := waitTopicPeers(ctx)
+	if len(peers) == 0 {
+		logrus.Warn("Snapshot bootstrap found no peer, starting with an empty database")
+		return nil
+	}
+
+	path := filename + ".snapshot"
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(path)
+	id, err := p2p.FetchSnapshotFrom(ctx, p2pHost.Host, peers, f)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("snapshot bootstrap: %w", err)
+	}
+	if err := restoreSnapshot(path, filename); err != nil {
+		return err
+	}
+	logrus.Infof("Database restored from the snapshot of peer %s", id)
+	return pruneAll(true)
+}
+
+// A function that waits for peers to join the topic
+func waitTopicPeers(ctx context.Context) []peer.ID {
+	ticker := time.NewTicker(500 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		if peers := p2pPubSub.PeerList(); len(peers) > 0 {
+			return peers
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
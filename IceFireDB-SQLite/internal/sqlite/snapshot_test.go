@@ -0,0 +1,35 @@
+package sqlite
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+)
+
+func TestSnapshotRestore(t *testing.T) {
+	openTestDB(t)
+	if _, err := db.Exec("INSERT INTO t (v) VALUES ('a'), ('b')"); err != nil {
+		t.Fatal(err)
+	}
+	path, err := takeSnapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a joining peer with an empty database
+	filename := filepath.Join(t.TempDir(), "joining.db")
+	db, err = sql.Open("sqlite3", filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if empty, err := isEmpty(); err != nil || !empty {
+		t.Fatalf("empty database reported as %v, %v", empty, err)
+	}
+	if err := restoreSnapshot(path, filename); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if n := count(t); n != 2 {
+		t.Fatalf("want 2 rows, got %d", n)
+	}
+}
@@ -2,6 +2,8 @@ package main
 
 import (
 	"context"
+	"encoding/base64"
+	"encoding/json"
 	"fmt"
 	"log"
 	"os"
@@ -10,7 +12,11 @@ import (
 	"time"
 
 	"github.com/IceFireDB/IceFireDB/IceFireDB-SQLite/internal/mysql"
+	"github.com/IceFireDB/IceFireDB/IceFireDB-SQLite/internal/sqlite"
 	"github.com/IceFireDB/IceFireDB/IceFireDB-SQLite/pkg/config"
+	"github.com/IceFireDB/IceFireDB/IceFireDB-SQLite/pkg/p2p"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
 	"github.com/sirupsen/logrus"
 	"github.com/urfave/cli"
 )
@@ -34,6 +40,31 @@ func main() {
 		},
 	}
 
+	app.Commands = []cli.Command{
+		{
+			Name:      "sign-allowlist",
+			Usage:     "sign the peer ID allowlist of a permissioned p2p cluster",
+			ArgsUsage: "<peer id>...",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "key, k",
+					Usage: "operator private key file",
+					Value: "operator.key",
+				},
+				cli.BoolFlag{
+					Name:  "new-key",
+					Usage: "generate the operator private key file, which must not exist",
+				},
+				cli.StringFlag{
+					Name:  "out, o",
+					Usage: "signed allowlist output file",
+					Value: "allowlist.json",
+				},
+			},
+			Action: signAllowlist,
+		},
+	}
+
 	app.Before = func(c *cli.Context) error {
 		log.SetFlags(log.Llongfile)
 		// init log
@@ -66,9 +97,50 @@ func main() {
 	}
 }
 
+func signAllowlist(c *cli.Context) error {
+	var key crypto.PrivKey
+	var err error
+	if c.Bool("new-key") {
+		key, err = p2p.CreateIdentity(c.String("key"))
+	} else {
+		key, err = p2p.ReadIdentity(c.String("key"))
+	}
+	if err != nil {
+		return fmt.Errorf("operator key: %w", err)
+	}
+
+	peers := make([]peer.ID, 0, c.NArg())
+	for _, arg := range c.Args() {
+		id, err := peer.Decode(arg)
+		if err != nil {
+			return fmt.Errorf("invalid peer id %s: %w", arg, err)
+		}
+		peers = append(peers, id)
+	}
+
+	allowlist, err := p2p.SignAllowlist(key, peers)
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(allowlist, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(c.String("out"), data, 0o644); err != nil {
+		return err
+	}
+
+	pub, err := crypto.MarshalPublicKey(key.GetPublic())
+	if err != nil {
+		return err
+	}
+	fmt.Println("operator_key:", base64.StdEncoding.EncodeToString(pub))
+	return nil
+}
+
 func exitSignal(cancel context.CancelFunc, stop chan struct{}) error {
 	sigs := make(chan os.Signal, 1)
-	signal.Notify(sigs, syscall.SIGHUP, syscall.SIGQUIT, syscall.SIGTERM, syscall.SIGINT)
+	signal.Notify(sigs, syscall.SIGHUP, syscall.SIGUSR1, syscall.SIGQUIT, syscall.SIGTERM, syscall.SIGINT)
 	for sig := range sigs {
 		switch sig {
 		case syscall.SIGQUIT, syscall.SIGTERM, syscall.SIGINT:
@@ -83,6 +155,17 @@ func exitSignal(cancel context.CancelFunc, stop chan struct{}) error {
 			os.Exit(0)
 		case syscall.SIGHUP:
 			fmt.Println("catch syscall.SIGHUP")
+			if err := config.ReloadP2PPeers(); err != nil {
+				logrus.Errorf("reload config failed: %v", err)
+				continue
+			}
+			if err := sqlite.ReloadP2PPeers(); err != nil {
+				logrus.Errorf("reload p2p peers failed: %v", err)
+			}
+		case syscall.SIGUSR1:
+			if err := sqlite.RotateTopicKey(); err != nil {
+				logrus.Errorf("rotate topic key failed: %v", err)
+			}
 		}
 	}
 	return nil
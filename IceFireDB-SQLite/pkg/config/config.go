@@ -19,7 +19,19 @@ type ServerC struct {
 }
 
 type SQLiteC struct {
-	Filename string `mapstructure:"filename" json:"filename"`
+	Filename string   `mapstructure:"filename" json:"filename"`
+	Sandbox  SandboxC `mapstructure:"sandbox" json:"sandbox"`
+}
+
+type SandboxC struct {
+	// Deny ATTACH, DETACH, PRAGMA writes and load_extension
+	Enable bool `mapstructure:"enable" json:"enable"`
+	// Denied statement prefixes, e.g. VACUUM
+	Deny []string `mapstructure:"deny" json:"deny"`
+	// Allowed statement prefixes, empty allows every statement
+	Allow []string `mapstructure:"allow" json:"allow"`
+	// Peer IDs allowed to replicate writes, empty allows every peer
+	Writers []string `mapstructure:"writers" json:"writers"`
 }
 
 type UserInfo struct {
@@ -33,12 +45,93 @@ type DebugC struct {
 }
 
 type P2PS struct {
-	Enable              bool   `mapstructure:"enable" json:"enable"`
-	ServiceDiscoveryID  string `mapstructure:"service_discovery_id" json:"service_discovery_id"`
-	ServiceCommandTopic string `mapstructure:"service_command_topic" json:"service_command_topic"`
-	ServiceDiscoverMode string `mapstructure:"service_discover_mode" json:"service_discover_mode"`
-	NodeHostIP          string `mapstructure:"node_host_ip" json:"node_host_ip"`
-	NodeHostPort        int    `mapstructure:"node_host_port" json:"node_host_port"`
+	Enable              bool         `mapstructure:"enable" json:"enable"`
+	ServiceDiscoveryID  string       `mapstructure:"service_discovery_id" json:"service_discovery_id"`
+	ServiceCommandTopic string       `mapstructure:"service_command_topic" json:"service_command_topic"`
+	ServiceDiscoverMode string       `mapstructure:"service_discover_mode" json:"service_discover_mode"`
+	NodeHostIP          string       `mapstructure:"node_host_ip" json:"node_host_ip"`
+	NodeHostPort        int          `mapstructure:"node_host_port" json:"node_host_port"`
+	NAT                 NATC         `mapstructure:"nat" json:"nat"`
+	Security            SecurityC    `mapstructure:"security" json:"security"`
+	IdentityFile        string       `mapstructure:"identity_file" json:"identity_file"`
+	Membership          MembershipC  `mapstructure:"membership" json:"membership"`
+	Encryption          EncryptionC  `mapstructure:"encryption" json:"encryption"`
+	Snapshot            SnapshotC    `mapstructure:"snapshot" json:"snapshot"`
+	Conflict            ConflictC    `mapstructure:"conflict" json:"conflict"`
+	Replication         ReplicationC `mapstructure:"replication" json:"replication"`
+	// Apply the replicated log but reject the writes of the clients
+	Follower bool `mapstructure:"follower" json:"follower"`
+	// Peer multiaddrs, reloaded on SIGHUP
+	BootstrapPeers []string `mapstructure:"bootstrap_peers" json:"bootstrap_peers"`
+	StaticRelays   []string `mapstructure:"static_relays" json:"static_relays"`
+}
+
+type NATC struct {
+	PortMap       bool     `mapstructure:"port_map" json:"port_map"`
+	AnnounceAddrs []string `mapstructure:"announce_addrs" json:"announce_addrs"`
+}
+
+type SecurityC struct {
+	// Security transports in order of preference: tls, noise
+	Transports []string `mapstructure:"transports" json:"transports"`
+	// Identity key types of the peers, empty accepts every type
+	KeyTypes []string `mapstructure:"key_types" json:"key_types"`
+	// Only use FIPS approved primitives, requires GODEBUG=fips140=on
+	FIPS bool `mapstructure:"fips" json:"fips"`
+}
+
+type MembershipC struct {
+	Enable        bool   `mapstructure:"enable" json:"enable"`
+	OperatorKey   string `mapstructure:"operator_key" json:"operator_key"`
+	AllowlistFile string `mapstructure:"allowlist_file" json:"allowlist_file"`
+}
+
+// EncryptionC encrypts the payloads of the topics with keys shared by the
+// members of the cluster
+type EncryptionC struct {
+	Enable bool `mapstructure:"enable" json:"enable"`
+	// File of the topic keys, generated when it does not exist
+	KeyFile string `mapstructure:"key_file" json:"key_file"`
+	// How long a replaced key still decrypts the messages, unit: second
+	Grace int `mapstructure:"grace" json:"grace"`
+	// How often this node rotates the key, unit: second, 0 disables it
+	RotateInterval int `mapstructure:"rotate_interval" json:"rotate_interval"`
+}
+
+type SnapshotC struct {
+	// Fetch the database of a peer when the local one has no table
+	Bootstrap bool `mapstructure:"bootstrap" json:"bootstrap"`
+	// Time to find a peer and transfer the snapshot, unit: second
+	Timeout int `mapstructure:"timeout" json:"timeout"`
+}
+
+type ConflictC struct {
+	// Resolution of the concurrent writes: none, lww or designated-writer
+	Mode string `mapstructure:"mode" json:"mode"`
+	// Peer ID of the only node accepting writes in designated-writer mode
+	Writer string `mapstructure:"writer" json:"writer"`
+}
+
+// ReplicationC routes the writes of tables to their own topics, so the nodes
+// carrying a subset of the data only receive the topics they need
+type ReplicationC struct {
+	Topics []ReplicationTopicC `mapstructure:"topics" json:"topics"`
+	// Row filters of the received writes, the rows out of the filter are deleted
+	Filters []RowFilterC `mapstructure:"filters" json:"filters"`
+}
+
+type ReplicationTopicC struct {
+	// Topic name, appended to the service command topic
+	Name   string   `mapstructure:"name" json:"name"`
+	Tables []string `mapstructure:"tables" json:"tables"`
+	// Publish the writes of the local clients but receive none, the rows of the tables are deleted
+	PublishOnly bool `mapstructure:"publish_only" json:"publish_only"`
+}
+
+type RowFilterC struct {
+	Table string `mapstructure:"table" json:"table"`
+	// SQL condition the rows kept match, e.g. region = 'eu'
+	Where string `mapstructure:"where" json:"where"`
 }
 
 func init() {
@@ -49,6 +142,12 @@ var defaultConfig *Config
 
 func InitConfig(path string) {
 	viper.SetConfigFile(path)
+	viper.SetDefault("p2p.nat.port_map", true)
+	viper.SetDefault("p2p.security.transports", []string{"tls", "noise"})
+	viper.SetDefault("p2p.snapshot.timeout", 60)
+	viper.SetDefault("p2p.encryption.key_file", "topic.keys")
+	viper.SetDefault("p2p.encryption.grace", 3600)
+	viper.SetDefault("sqlite.sandbox.enable", true)
 	if err := viper.ReadInConfig(); err != nil {
 		panic(err)
 	}
@@ -67,6 +166,23 @@ func InitConfig(path string) {
 	}
 }
 
+// ReloadP2PPeers re-reads the config file and updates the bootstrap peers
+// and static relays, the other settings only apply on restart
+func ReloadP2PPeers() error {
+	if err := viper.ReadInConfig(); err != nil {
+		return err
+	}
+
+	conf := &Config{}
+	if err := viper.Unmarshal(conf); err != nil {
+		return err
+	}
+
+	defaultConfig.P2P.BootstrapPeers = conf.P2P.BootstrapPeers
+	defaultConfig.P2P.StaticRelays = conf.P2P.StaticRelays
+	return nil
+}
+
 func Get() *Config {
 	return defaultConfig
 }
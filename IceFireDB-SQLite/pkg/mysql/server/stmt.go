@@ -31,6 +31,8 @@ type Stmt struct {
 	Columns int
 
 	Args []interface{}
+	// types of the params, only sent on the first execution
+	paramTypes []byte
 
 	Context interface{}
 }
@@ -147,8 +149,15 @@ func (c *Conn) handleStmtExecute(data []byte) (*Result, error) {
 			paramTypes = data[pos : pos+(paramNum<<1)]
 			pos += paramNum << 1
 
-			paramValues = data[pos:]
+			s.paramTypes = append(s.paramTypes[:0], paramTypes...)
+		} else {
+			pos++
+			if len(s.paramTypes) != paramNum<<1 {
+				return nil, ErrMalformPacket
+			}
+			paramTypes = s.paramTypes
 		}
+		paramValues = data[pos:]
 
 		if err := c.bindStmtArgs(s, nullBitmaps, paramTypes, paramValues); err != nil {
 			return nil, errors.Trace(err)
@@ -0,0 +1,181 @@
+package p2p
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// Allowlist represents the peer IDs allowed into a permissioned cluster,
+// signed by the cluster operator key
+type Allowlist struct {
+	Peers     []string `json:"peers"`
+	Signature []byte   `json:"signature"`
+}
+
+// Membership represents the verified allowlist of a permissioned cluster.
+// Only the listed peers are allowed into the replication topics.
+type Membership struct {
+	peers map[peer.ID]struct{}
+}
+
+// DefaultMembership is the membership used by NewP2P, a nil membership
+// disables the cluster authentication. It must be set before the host is created.
+var DefaultMembership *Membership
+
+// The signed payload of an allowlist, the sorted peer IDs joined by newlines
+func (a *Allowlist) payload() []byte {
+	peers := append([]string(nil), a.Peers...)
+	sort.Strings(peers)
+	var buf bytes.Buffer
+	buf.WriteString("icefiredb-allowlist\n")
+	for _, p := range peers {
+		buf.WriteString(p)
+		buf.WriteByte('\n')
+	}
+	return buf.Bytes()
+}
+
+// SignAllowlist creates an allowlist of the given peers signed by the operator key
+func SignAllowlist(operator crypto.PrivKey, peers []peer.ID) (*Allowlist, error) {
+	a := &Allowlist{Peers: make([]string, 0, len(peers))}
+	for _, id := range peers {
+		a.Peers = append(a.Peers, id.String())
+	}
+	sort.Strings(a.Peers)
+
+	sig, err := operator.Sign(a.payload())
+	if err != nil {
+		return nil, err
+	}
+	a.Signature = sig
+	return a, nil
+}
+
+// Verify checks the allowlist signature against the operator key and
+// returns the resulting membership
+func (a *Allowlist) Verify(operator crypto.PubKey) (*Membership, error) {
+	ok, err := operator.Verify(a.payload(), a.Signature)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, errors.New("invalid allowlist signature")
+	}
+
+	m := &Membership{peers: make(map[peer.ID]struct{}, len(a.Peers))}
+	for _, s := range a.Peers {
+		id, err := peer.Decode(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid allowlist peer %s: %w", s, err)
+		}
+		m.peers[id] = struct{}{}
+	}
+	return m, nil
+}
+
+// LoadMembership reads a signed allowlist file and verifies it against the
+// operator public key, encoded in base64 as produced by MarshalPublicKey
+func LoadMembership(path string, operatorKey string) (*Membership, error) {
+	raw, err := base64.StdEncoding.DecodeString(operatorKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid operator key: %w", err)
+	}
+	operator, err := crypto.UnmarshalPublicKey(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid operator key: %w", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var a Allowlist
+	if err := json.Unmarshal(data, &a); err != nil {
+		return nil, fmt.Errorf("invalid allowlist %s: %w", path, err)
+	}
+	return a.Verify(operator)
+}
+
+// Allowed reports whether the peer is a member of the cluster
+func (m *Membership) Allowed(id peer.ID) bool {
+	_, ok := m.peers[id]
+	return ok
+}
+
+// LoadIdentity reads the private key of a node from the given file, a new
+// key of the type of DefaultSecurityConfig is generated and saved when the
+// file does not exist.
+// A persistent identity keeps the peer ID stable across restarts.
+func LoadIdentity(path string) (crypto.PrivKey, error) {
+	key, err := ReadIdentity(path)
+	if os.IsNotExist(err) {
+		return CreateIdentity(path)
+	}
+	return key, err
+}
+
+// ReadIdentity reads the private key of the given file, it fails when the
+// file does not exist
+func ReadIdentity(path string) (crypto.PrivKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return crypto.UnmarshalPrivateKey(data)
+}
+
+// CreateIdentity generates a private key of the type of
+// DefaultSecurityConfig and saves it to a new file, it fails when the file
+// exists
+func CreateIdentity(path string) (crypto.PrivKey, error) {
+	key, err := DefaultSecurityConfig.GenerateIdentity()
+	if err != nil {
+		return nil, err
+	}
+	data, err := crypto.MarshalPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return key, f.Close()
+}
+
+// A function that returns the PubSub options that keep non-members out of the topics
+func membershipOption(m *Membership) []pubsub.Option {
+	if m == nil {
+		return nil
+	}
+	return []pubsub.Option{
+		pubsub.WithPeerFilter(func(id peer.ID, topic string) bool {
+			return m.Allowed(id)
+		}),
+	}
+}
+
+// A function that returns a topic validator rejecting messages
+// that were not published by a member
+func membershipValidator(m *Membership) pubsub.ValidatorEx {
+	return func(ctx context.Context, from peer.ID, msg *pubsub.Message) pubsub.ValidationResult {
+		if !m.Allowed(from) || !m.Allowed(msg.GetFrom()) {
+			return pubsub.ValidationReject
+		}
+		return pubsub.ValidationAccept
+	}
+}
@@ -0,0 +1,75 @@
+package p2p
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+func newTestPeer(t *testing.T) (crypto.PrivKey, peer.ID) {
+	key, _, err := crypto.GenerateKeyPair(crypto.Ed25519, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := peer.IDFromPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key, id
+}
+
+func TestAllowlist(t *testing.T) {
+	operator, _ := newTestPeer(t)
+	_, member := newTestPeer(t)
+	_, stranger := newTestPeer(t)
+
+	allowlist, err := SignAllowlist(operator, []peer.ID{member})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := allowlist.Verify(operator.GetPublic())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !m.Allowed(member) {
+		t.Error("listed peer must be allowed")
+	}
+	if m.Allowed(stranger) {
+		t.Error("unlisted peer must not be allowed")
+	}
+
+	other, _ := newTestPeer(t)
+	if _, err := allowlist.Verify(other.GetPublic()); err == nil {
+		t.Error("allowlist signed by another key must fail")
+	}
+
+	allowlist.Peers = append(allowlist.Peers, stranger.String())
+	if _, err := allowlist.Verify(operator.GetPublic()); err == nil {
+		t.Error("tampered allowlist must fail")
+	}
+}
+
+func TestIdentityFiles(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "operator.key")
+	if _, err := ReadIdentity(path); !os.IsNotExist(err) {
+		t.Fatalf("read a missing key: %v", err)
+	}
+	key, err := CreateIdentity(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := CreateIdentity(path); err == nil {
+		t.Error("existing key overwritten")
+	}
+	read, err := ReadIdentity(path)
+	if err != nil || !read.Equals(key) {
+		t.Errorf("read key: %v", err)
+	}
+	loaded, err := LoadIdentity(path)
+	if err != nil || !loaded.Equals(key) {
+		t.Errorf("loaded key: %v", err)
+	}
+}
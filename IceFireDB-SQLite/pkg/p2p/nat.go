@@ -0,0 +1,92 @@
+package p2p
+
+import (
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/event"
+	"github.com/libp2p/go-libp2p/core/host"
+	ma "github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
+	"github.com/sirupsen/logrus"
+)
+
+// NATConfig represents the NAT traversal options of the host
+type NATConfig struct {
+	// Map the listen ports on the gateway through UPnP-IGD or NAT-PMP
+	// and advertise the mapped addresses
+	PortMap bool
+
+	// Addresses advertised in addition to the listen and mapped addresses,
+	// e.g. a port forwarded by hand on the router
+	AnnounceAddrs []ma.Multiaddr
+}
+
+// DefaultNATConfig is the NAT configuration used by NewP2P,
+// it must be set before the host is created
+var DefaultNATConfig = NATConfig{
+	PortMap: true,
+}
+
+// ParseAnnounceAddrs converts the configured announce addresses into multiaddrs
+func ParseAnnounceAddrs(addrs []string) ([]ma.Multiaddr, error) {
+	maddrs := make([]ma.Multiaddr, 0, len(addrs))
+	for _, addr := range addrs {
+		maddr, err := ma.NewMultiaddr(addr)
+		if err != nil {
+			return nil, err
+		}
+		maddrs = append(maddrs, maddr)
+	}
+	return maddrs, nil
+}
+
+// PublicAddrs returns the publicly routable addresses of the host, which
+// include the addresses mapped on the gateway once the mapping succeeded
+func (p2p *P2P) PublicAddrs() []ma.Multiaddr {
+	var addrs []ma.Multiaddr
+	for _, addr := range p2p.Host.Addrs() {
+		if manet.IsPublicAddr(addr) {
+			addrs = append(addrs, addr)
+		}
+	}
+	return addrs
+}
+
+// A function that returns the NAT traversal options of the host
+func natOption(c NATConfig) libp2p.Option {
+	var opts []libp2p.Option
+	if c.PortMap {
+		opts = append(opts, libp2p.NATPortMap())
+	}
+	if len(c.AnnounceAddrs) > 0 {
+		announce := c.AnnounceAddrs
+		opts = append(opts, libp2p.AddrsFactory(func(addrs []ma.Multiaddr) []ma.Multiaddr {
+			return append(addrs[:len(addrs):len(addrs)], announce...)
+		}))
+	}
+	return libp2p.ChainOptions(opts...)
+}
+
+// A function that logs the public addresses gained or lost by the host,
+// so the result of the port mapping is visible. Meant to be started as a go routine.
+func watchPublicAddrs(nodehost host.Host) {
+	sub, err := nodehost.EventBus().Subscribe(new(event.EvtLocalAddressesUpdated))
+	if err != nil {
+		logrus.Warnln("p2p address subscription failed:", err)
+		return
+	}
+	defer sub.Close()
+
+	for e := range sub.Out() {
+		evt := e.(event.EvtLocalAddressesUpdated)
+		for _, addr := range evt.Current {
+			if addr.Action == event.Added && manet.IsPublicAddr(addr.Address) {
+				logrus.Infoln("p2p public address added:", addr.Address)
+			}
+		}
+		for _, addr := range evt.Removed {
+			if manet.IsPublicAddr(addr.Address) {
+				logrus.Infoln("p2p public address removed:", addr.Address)
+			}
+		}
+	}
+}
@@ -9,6 +9,7 @@ import (
 	"sync/atomic"
 	"time"
 
+	"github.com/IceFireDB/IceFireDB/IceFireDB-SQLite/pkg/p2p/security"
 	"github.com/ipfs/go-cid"
 	"github.com/libp2p/go-libp2p"
 	dht "github.com/libp2p/go-libp2p-kad-dht"
@@ -20,8 +21,6 @@ import (
 	discoveryRouting "github.com/libp2p/go-libp2p/p2p/discovery/routing"
 	"github.com/libp2p/go-libp2p/p2p/muxer/yamux"
 	"github.com/libp2p/go-libp2p/p2p/net/connmgr"
-	"github.com/libp2p/go-libp2p/p2p/security/noise"
-	libp2ptls "github.com/libp2p/go-libp2p/p2p/security/tls"
 	"github.com/mr-tron/base58/base58"
 	"github.com/multiformats/go-multihash"
 	"github.com/sirupsen/logrus"
@@ -44,9 +43,29 @@ type P2P struct {
 	// Represents the PubSub Handler
 	PubSub *pubsub.PubSub
 
+	// Represents the NAT traversal options
+	NAT NATConfig
+
+	// Represents the cluster membership, nil when the cluster is open
+	Membership *Membership
+
+	// Represents the keys of the topic payloads, nil when they are not encrypted
+	Keyring *Keyring
+
 	service string
+
+	peersMu sync.Mutex
+	peers   staticPeers
 }
 
+// DefaultIdentity is the private key of the host created by NewP2P,
+// a random key is generated when it is nil
+var DefaultIdentity crypto.PrivKey
+
+// DefaultSecurityConfig is the security configuration used by NewP2P,
+// it must be set before the host is created
+var DefaultSecurityConfig = security.Default
+
 /*
 A constructor function that generates and returns a P2P object.
 
@@ -68,6 +87,9 @@ func NewP2P(serviceName string, nodeHostIP string, nodeHostPort int) *P2P {
 	logrus.Infoln("Setup the p2p host,listen on", nodehost.Addrs())
 	log.Println("MY P2P Node ID",nodehost.ID())
 
+	// Log the addresses mapped on the gateway
+	go watchPublicAddrs(nodehost)
+
 	// Bootstrap the Kad DHT
 	bootstrapDHT(ctx, nodehost, kaddht)
 
@@ -80,18 +102,25 @@ func NewP2P(serviceName string, nodeHostIP string, nodeHostPort int) *P2P {
 	logrus.Debugln("Created the Peer Discovery Service.")
 
 	// Create a PubSub handler with the routing discovery PubSu
-	pubsubhandler := setupPubSub(ctx, nodehost, routingdiscovery)
+	pubsubhandler := setupPubSub(ctx, nodehost, routingdiscovery, DefaultMembership)
 	// Debug log
 	logrus.Debugln("Created the PubSub Handler.")
 
 	// Return the P2P object
 	return &P2P{
-		Ctx:       ctx,
-		Host:      nodehost,
-		KadDHT:    kaddht,
-		Discovery: routingdiscovery,
-		PubSub:    pubsubhandler,
-		service:   serviceName,
+		Ctx:        ctx,
+		Host:       nodehost,
+		KadDHT:     kaddht,
+		Discovery:  routingdiscovery,
+		PubSub:     pubsubhandler,
+		NAT:        DefaultNATConfig,
+		Membership: DefaultMembership,
+		Keyring:    DefaultKeyring,
+		service:    serviceName,
+		peers: staticPeers{
+			bootstrap: make(map[peer.ID]peer.AddrInfo),
+			relays:    make(map[peer.ID]staticRelay),
+		},
 	}
 }
 
@@ -166,10 +195,8 @@ func (p2p *P2P) AnnounceConnect() {
 // libp2p host object for the given context. The created host is returned
 func setupHost(ctx context.Context, nodeHostIP string, nodeHostPort int) (host.Host, *dht.IpfsDHT) {
 	// Set up the host identity options
-	prvkey, pubkey, err := crypto.GenerateKeyPair(
-		crypto.Ed25519, // Select your key type. Ed25519 are nice short
-		-1,             // Select key length when possible (i.e. RSA).
-	)
+	// Select the key type allowed by the security configuration
+	prvkey, err := DefaultSecurityConfig.GenerateIdentity()
 
 	// Handle any potential error
 	if err != nil {
@@ -178,8 +205,6 @@ func setupHost(ctx context.Context, nodeHostIP string, nodeHostPort int) (host.H
 		}).Fatalln("Failed to Generate P2P Identity Configuration!")
 	}
 
-	_ = pubkey
-
 	// identity := libp2p.Identity(prvkey)
 
 	// // Trace log
@@ -265,6 +290,14 @@ func setupHost(ctx context.Context, nodeHostIP string, nodeHostPort int) (host.H
 
 	var idht *dht.IpfsDHT
 
+	// Use the persistent identity when it is configured
+	if DefaultIdentity != nil {
+		prvkey = DefaultIdentity
+	}
+	if !DefaultSecurityConfig.AllowsKey(prvkey.Type()) {
+		panic(fmt.Errorf("p2p identity key type %s is not allowed", prvkey.Type()))
+	}
+
 	connmgr, err := connmgr.NewConnManager(
 		100, // Lowwater
 		400, // HighWater,
@@ -288,18 +321,16 @@ func setupHost(ctx context.Context, nodeHostIP string, nodeHostPort int) (host.H
 			tcpListenAddress,  // regular tcp connections
 			quicListenAddress, // a UDP endpoint for the QUIC transport
 		),
-		// support TLS connections
-		libp2p.Security(libp2ptls.ID, libp2ptls.New),
-		// support noise connections
-		libp2p.Security(noise.ID, noise.New),
+		// support TLS and noise connections, in the configured order
+		security.Option(DefaultSecurityConfig),
 		libp2p.Muxer("/yamux/1.0.0", yamux.DefaultTransport),
 		// support any other default transports (TCP)
-		libp2p.DefaultTransports,
+		security.TransportOption(DefaultSecurityConfig),
 		// Let's prevent our peer from having too many
 		// connections by attaching a connection manager.
 		libp2p.ConnectionManager(connmgr),
-		// Attempt to open ports using uPNP for NATed hosts.
-		libp2p.NATPortMap(),
+		// Attempt to open ports using UPnP or NAT-PMP for NATed hosts.
+		natOption(DefaultNATConfig),
 		// Let this host use the DHT to find other hosts
 		libp2p.Routing(func(h host.Host) (routing.PeerRouting, error) {
 			idht, err = setupKadDHT(ctx, h)
@@ -319,6 +350,7 @@ func setupHost(ctx context.Context, nodeHostIP string, nodeHostPort int) (host.H
 	if err != nil {
 		panic(err)
 	}
+	security.Guard(h2, DefaultSecurityConfig)
 	//defer h2.Close()
 
 	// Return the created host and the kademlia DHT
@@ -352,9 +384,11 @@ func setupKadDHT(ctx context.Context, nodehost host.Host) (*dht.IpfsDHT, error)
 
 // A function that generates a PubSub Handler object and returns it
 // Requires a node host and a routing discovery service.
-func setupPubSub(ctx context.Context, nodehost host.Host, routingdiscovery *discoveryRouting.RoutingDiscovery) *pubsub.PubSub {
+func setupPubSub(ctx context.Context, nodehost host.Host, routingdiscovery *discoveryRouting.RoutingDiscovery, membership *Membership) *pubsub.PubSub {
+	// Only exchange topics with cluster members when the membership is set
+	opts := append([]pubsub.Option{pubsub.WithDiscovery(routingdiscovery)}, membershipOption(membership)...)
 	// Create a new PubSub service which uses a GossipSub router
-	pubsubhandler, err := pubsub.NewGossipSub(ctx, nodehost, pubsub.WithDiscovery(routingdiscovery))
+	pubsubhandler, err := pubsub.NewGossipSub(ctx, nodehost, opts...)
 	// Handle any potential error
 	if err != nil {
 		logrus.WithFields(logrus.Fields{
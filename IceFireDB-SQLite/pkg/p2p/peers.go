@@ -0,0 +1,173 @@
+package p2p
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/p2p/protocol/circuitv2/client"
+	"github.com/sirupsen/logrus"
+)
+
+// Connection manager tags of the static peers
+const (
+	bootstrapPeerTag = "icefiredb-bootstrap"
+	staticRelayTag   = "icefiredb-relay"
+)
+
+// Retry delay of a failed relay reservation, and how long before its
+// expiration a reservation is refreshed
+const (
+	relayRetryInterval   = time.Minute
+	relayRefreshInterval = time.Minute
+)
+
+// staticPeers represents the bootstrap peers and static relays that were
+// added at runtime, together with the cancel functions of their handlers
+type staticPeers struct {
+	bootstrap map[peer.ID]peer.AddrInfo
+	relays    map[peer.ID]staticRelay
+}
+
+type staticRelay struct {
+	info   peer.AddrInfo
+	cancel context.CancelFunc
+}
+
+// SetBootstrapPeers replaces the configured bootstrap peers with the given
+// multiaddrs. New peers are connected and protected from the connection
+// manager, removed peers are only unprotected, so sessions that are still
+// running over their connections are not dropped.
+func (p2p *P2P) SetBootstrapPeers(addrs []string) error {
+	infos, err := parseAddrInfos(addrs)
+	if err != nil {
+		return err
+	}
+
+	p2p.peersMu.Lock()
+	defer p2p.peersMu.Unlock()
+
+	cm := p2p.Host.ConnManager()
+	for id := range p2p.peers.bootstrap {
+		if _, ok := infos[id]; !ok {
+			cm.Unprotect(id, bootstrapPeerTag)
+			delete(p2p.peers.bootstrap, id)
+			logrus.Infoln("Removed p2p bootstrap peer", id)
+		}
+	}
+	for id, info := range infos {
+		if _, ok := p2p.peers.bootstrap[id]; ok {
+			continue
+		}
+		p2p.peers.bootstrap[id] = info
+		cm.Protect(id, bootstrapPeerTag)
+		go func(info peer.AddrInfo) {
+			if err := p2p.Host.Connect(p2p.Ctx, info); err != nil {
+				logrus.Warnln("p2p bootstrap peer connection failed:", info.ID, err)
+				return
+			}
+			logrus.Infoln("Added p2p bootstrap peer", info.ID)
+		}(info)
+	}
+	return nil
+}
+
+// SetStaticRelays replaces the configured static relays with the given
+// multiaddrs. A reservation is kept on every new relay until it is removed,
+// removed relays are unprotected without closing their connections.
+func (p2p *P2P) SetStaticRelays(addrs []string) error {
+	infos, err := parseAddrInfos(addrs)
+	if err != nil {
+		return err
+	}
+
+	p2p.peersMu.Lock()
+	defer p2p.peersMu.Unlock()
+
+	cm := p2p.Host.ConnManager()
+	for id, relay := range p2p.peers.relays {
+		if _, ok := infos[id]; !ok {
+			relay.cancel()
+			cm.Unprotect(id, staticRelayTag)
+			delete(p2p.peers.relays, id)
+			logrus.Infoln("Removed p2p static relay", id)
+		}
+	}
+	for id, info := range infos {
+		if _, ok := p2p.peers.relays[id]; ok {
+			continue
+		}
+		ctx, cancel := context.WithCancel(p2p.Ctx)
+		p2p.peers.relays[id] = staticRelay{info: info, cancel: cancel}
+		cm.Protect(id, staticRelayTag)
+		go p2p.keepRelayReservation(ctx, info)
+	}
+	return nil
+}
+
+// BootstrapPeers returns the IDs of the configured bootstrap peers
+func (p2p *P2P) BootstrapPeers() []peer.ID {
+	p2p.peersMu.Lock()
+	defer p2p.peersMu.Unlock()
+
+	ids := make([]peer.ID, 0, len(p2p.peers.bootstrap))
+	for id := range p2p.peers.bootstrap {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+// StaticRelays returns the IDs of the configured static relays
+func (p2p *P2P) StaticRelays() []peer.ID {
+	p2p.peersMu.Lock()
+	defer p2p.peersMu.Unlock()
+
+	ids := make([]peer.ID, 0, len(p2p.peers.relays))
+	for id := range p2p.peers.relays {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+// A method of P2P that reserves a slot on a static relay and refreshes it
+// before it expires, until the given context is cancelled
+func (p2p *P2P) keepRelayReservation(ctx context.Context, info peer.AddrInfo) {
+	for {
+		wait := relayRetryInterval
+		rsvp, err := client.Reserve(ctx, p2p.Host, info)
+		if err != nil {
+			logrus.Warnln("p2p static relay reservation failed:", info.ID, err)
+		} else {
+			logrus.Debugln("p2p static relay reservation success:", info.ID, rsvp.Expiration)
+			if d := time.Until(rsvp.Expiration) - relayRefreshInterval; d > wait {
+				wait = d
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+// A function that parses a list of peer multiaddrs, addresses of the same
+// peer are merged into a single address information
+func parseAddrInfos(addrs []string) (map[peer.ID]peer.AddrInfo, error) {
+	infos := make(map[peer.ID]peer.AddrInfo, len(addrs))
+	for _, addr := range addrs {
+		info, err := peer.AddrInfoFromString(addr)
+		if err != nil {
+			return nil, err
+		}
+		if prev, ok := infos[info.ID]; ok {
+			info.Addrs = append(prev.Addrs, info.Addrs...)
+		}
+		infos[info.ID] = *info
+	}
+	return infos, nil
+}
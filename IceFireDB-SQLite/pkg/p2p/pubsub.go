@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"time"
 
 	pubsub "github.com/libp2p/go-libp2p-pubsub"
 	"github.com/libp2p/go-libp2p/core/peer"
@@ -63,19 +64,38 @@ func (c chatlog) String() string {
 // A constructor function that generates and returns a new
 // PubSub for a given P2PHost, username and roomname
 func JoinPubSub(p2phost *P2P, clientName string, topicName string) (*PubSub, error) {
+	return JoinTopic(p2phost, clientName, topicName, true)
+}
+
+// JoinTopic joins the topic like JoinPubSub, without subscribing to it
+// when subscribe is false: the messages are published but none is received
+// and Inbound is nil
+func JoinTopic(p2phost *P2P, clientName string, topicName string, subscribe bool) (*PubSub, error) {
+
+	pstopicName := fmt.Sprintf("icefiredb-sqlite-pub-sub-p2p-%s", topicName)
+
+	// Reject the messages of non-members when the cluster is permissioned
+	if p2phost.Membership != nil {
+		if err := p2phost.PubSub.RegisterTopicValidator(pstopicName, membershipValidator(p2phost.Membership)); err != nil {
+			return nil, err
+		}
+	}
 
 	// Create a PubSub topic with the room name
-	topic, err := p2phost.PubSub.Join(fmt.Sprintf("icefiredb-sqlite-pub-sub-p2p-%s", topicName))
+	topic, err := p2phost.PubSub.Join(pstopicName)
 	// Check the error
 	if err != nil {
 		return nil, err
 	}
 
 	// Subscribe to the PubSub topic
-	sub, err := topic.Subscribe()
-	// Check the error
-	if err != nil {
-		return nil, err
+	var sub *pubsub.Subscription
+	if subscribe {
+		sub, err = topic.Subscribe()
+		// Check the error
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	// Check the provided clientname
@@ -97,7 +117,6 @@ func JoinPubSub(p2phost *P2P, clientName string, topicName string) (*PubSub, err
 	PubSub := &PubSub{
 		Host: p2phost,
 
-		Inbound:  make(chan chatmessage),
 		Outbound: make(chan string),
 		Logs:     make(chan chatlog),
 
@@ -112,7 +131,10 @@ func JoinPubSub(p2phost *P2P, clientName string, topicName string) (*PubSub, err
 	}
 
 	// Start the subscribe loop
-	go PubSub.SubLoop()
+	if subscribe {
+		PubSub.Inbound = make(chan chatmessage)
+		go PubSub.SubLoop()
+	}
 	// Start the publish loop
 	go PubSub.PubLoop()
 
@@ -143,6 +165,15 @@ func (cr *PubSub) PubLoop() {
 				continue
 			}
 
+			// Encrypt the message with the current topic key
+			if kr := cr.Host.Keyring; kr != nil {
+				messagebytes, err = kr.Seal(messagebytes)
+				if err != nil {
+					cr.Logs <- chatlog{logprefix: "puberr", logmsg: "could not encrypt message"}
+					continue
+				}
+			}
+
 			// Publish the message to the topic
 			err = cr.pstopic.Publish(cr.psctx, messagebytes)
 			if err != nil {
@@ -179,14 +210,26 @@ func (cr *PubSub) SubLoop() {
 				continue
 			}
 
+			data := message.Data
+			// Decrypt the message, fetching its key from the sender when it is unknown
+			if kr := cr.Host.Keyring; kr != nil {
+				data, err = cr.open(message)
+				if err != nil {
+					cr.Logs <- chatlog{logprefix: "suberr", logmsg: "could not decrypt message: " + err.Error()}
+					continue
+				}
+			}
+
 			// Declare a ChatMessage
 			cm := &chatmessage{}
 			// Unmarshal the message data into a ChatMessage
-			err = json.Unmarshal(message.Data, cm)
+			err = json.Unmarshal(data, cm)
 			if err != nil {
 				cr.Logs <- chatlog{logprefix: "suberr", logmsg: "could not unmarshal JSON"}
 				continue
 			}
+			// The author of the message is verified by its signature, not the claimed one
+			cm.SenderID = message.GetFrom().String()
 
 			// Send the ChatMessage into the message queue
 			cr.Inbound <- *cm
@@ -194,6 +237,29 @@ func (cr *PubSub) SubLoop() {
 	}
 }
 
+// A method of PubSub that decrypts a message, the key of a message is
+// fetched from the peer that forwarded it or from its author
+func (cr *PubSub) open(message *pubsub.Message) ([]byte, error) {
+	kr := cr.Host.Keyring
+	data, keyID, err := kr.Open(message.Data)
+	if err != ErrUnknownTopicKey {
+		return data, err
+	}
+	ctx, cancel := context.WithTimeout(cr.psctx, 5*time.Second)
+	defer cancel()
+	for _, id := range []peer.ID{message.ReceivedFrom, message.GetFrom()} {
+		if cr.Host.Membership == nil || !cr.Host.Membership.Allowed(id) {
+			continue
+		}
+		if err := FetchTopicKey(ctx, cr.Host.Host, id, kr, keyID); err != nil {
+			continue
+		}
+		data, _, err = kr.Open(message.Data)
+		return data, err
+	}
+	return nil, err
+}
+
 // A method of PubSub that returns a list
 // of all peer IDs connected to it
 func (cr *PubSub) PeerList() []peer.ID {
@@ -207,7 +273,9 @@ func (cr *PubSub) Exit() {
 	defer cr.pscancel()
 
 	// Cancel the existing subscription
-	cr.psub.Cancel()
+	if cr.psub != nil {
+		cr.psub.Cancel()
+	}
 	// Close the topic handler
 	cr.pstopic.Close()
 }
@@ -0,0 +1,166 @@
+// Package security selects the security transports of a libp2p host, the
+// identity key types of its peers and the FIPS mode. It is shared by the
+// hosts of IceFireDB-SQLite, IceFireDB-SQLProxy and IceFireDB-PGProxy.
+package security
+
+import (
+	"crypto/fips140"
+	"fmt"
+	"strings"
+
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/crypto/pb"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/p2p/security/noise"
+	libp2ptls "github.com/libp2p/go-libp2p/p2p/security/tls"
+	quic "github.com/libp2p/go-libp2p/p2p/transport/quic"
+	"github.com/libp2p/go-libp2p/p2p/transport/tcp"
+	ws "github.com/libp2p/go-libp2p/p2p/transport/websocket"
+	webtransport "github.com/libp2p/go-libp2p/p2p/transport/webtransport"
+	"github.com/sirupsen/logrus"
+)
+
+// Security transports accepted in the p2p configuration
+const (
+	TLS   = "tls"
+	Noise = "noise"
+)
+
+// Config represents the security transports of the host and the key types
+// of the peers it accepts
+type Config struct {
+	// Security transports in order of preference, the first one shared
+	// with the remote peer secures the connection
+	Transports []string
+
+	// Identity key types of the peers (ed25519, ecdsa, rsa, secp256k1),
+	// empty accepts every type. The first one is the type of a generated
+	// identity.
+	KeyTypes []string
+
+	// Only use the FIPS 140-3 approved primitives: TLS 1.3 over the Go
+	// FIPS module, no Noise nor WebRTC, and no secp256k1 key. The binary
+	// must run with GODEBUG=fips140=on.
+	FIPS bool
+}
+
+// Default is the configuration of a host without a security section
+var Default = Config{
+	Transports: []string{TLS, Noise},
+}
+
+// the key types of the configuration
+var keyTypes = map[string]int{
+	"ed25519":   crypto.Ed25519,
+	"ecdsa":     crypto.ECDSA,
+	"rsa":       crypto.RSA,
+	"secp256k1": crypto.Secp256k1,
+}
+
+// the key types approved in FIPS mode
+var fipsKeyTypes = map[string]bool{"ed25519": true, "ecdsa": true, "rsa": true}
+
+// Validate checks the names of the transports and key types, and that the
+// FIPS mode only selects approved ones
+func (c Config) Validate() error {
+	if len(c.Transports) == 0 {
+		return fmt.Errorf("no p2p security transport")
+	}
+	for _, t := range c.Transports {
+		switch strings.ToLower(t) {
+		case TLS:
+		case Noise:
+			if c.FIPS {
+				return fmt.Errorf("p2p security transport noise is not FIPS approved")
+			}
+		default:
+			return fmt.Errorf("unknown p2p security transport: %s, expected tls or noise", t)
+		}
+	}
+	for _, k := range c.KeyTypes {
+		k = strings.ToLower(k)
+		if _, ok := keyTypes[k]; !ok {
+			return fmt.Errorf("unknown p2p key type: %s", k)
+		}
+		if c.FIPS && !fipsKeyTypes[k] {
+			return fmt.Errorf("p2p key type %s is not FIPS approved", k)
+		}
+	}
+	if c.FIPS && !fips140.Enabled() {
+		return fmt.Errorf("p2p FIPS mode requires running with GODEBUG=fips140=on")
+	}
+	return nil
+}
+
+// AllowsKey reports whether a peer may use the identity key type
+func (c Config) AllowsKey(t pb.KeyType) bool {
+	if len(c.KeyTypes) == 0 {
+		return !c.FIPS || int(t) != crypto.Secp256k1
+	}
+	for _, k := range c.KeyTypes {
+		if keyTypes[strings.ToLower(k)] == int(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// GenerateIdentity generates a private key of the first key type allowed
+func (c Config) GenerateIdentity() (crypto.PrivKey, error) {
+	t := crypto.Ed25519
+	if len(c.KeyTypes) > 0 {
+		t = keyTypes[strings.ToLower(c.KeyTypes[0])]
+	}
+	key, _, err := crypto.GenerateKeyPair(t, 2048)
+	return key, err
+}
+
+// Option returns the security transports of the host in order of
+// preference
+func Option(c Config) libp2p.Option {
+	var opts []libp2p.Option
+	for _, t := range c.Transports {
+		switch strings.ToLower(t) {
+		case TLS:
+			opts = append(opts, libp2p.Security(libp2ptls.ID, libp2ptls.New))
+		case Noise:
+			opts = append(opts, libp2p.Security(noise.ID, noise.New))
+		}
+	}
+	return libp2p.ChainOptions(opts...)
+}
+
+// WithoutWebRTC are the default transports of libp2p but WebRTC
+var WithoutWebRTC = libp2p.ChainOptions(
+	libp2p.Transport(tcp.NewTCPTransport),
+	libp2p.Transport(quic.NewTransport),
+	libp2p.Transport(ws.New),
+	libp2p.Transport(webtransport.New),
+)
+
+// TransportOption returns the default transports of libp2p, without WebRTC
+// in FIPS mode, its DTLS not being part of the Go FIPS module
+func TransportOption(c Config) libp2p.Option {
+	if c.FIPS {
+		return WithoutWebRTC
+	}
+	return libp2p.DefaultTransports
+}
+
+// Guard closes the connections of the peers whose identity key type is not
+// allowed. The key is only known once the connection is secured, so the
+// connection is closed as soon as it is up.
+func Guard(nodehost host.Host, c Config) {
+	nodehost.Network().Notify(&network.NotifyBundle{
+		ConnectedF: func(_ network.Network, conn network.Conn) {
+			key := conn.RemotePublicKey()
+			if key == nil || c.AllowsKey(key.Type()) {
+				return
+			}
+			logrus.Warnf("p2p peer %s refused, key type %s is not allowed", conn.RemotePeer(), key.Type())
+			go conn.Close()
+		},
+	})
+}
@@ -0,0 +1,99 @@
+package security
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+func TestValidate(t *testing.T) {
+	list := []struct {
+		c  Config
+		ok bool
+	}{
+		{Default, true},
+		{Config{Transports: []string{"Noise"}, KeyTypes: []string{"secp256k1"}}, true},
+		{Config{}, false},
+		{Config{Transports: []string{"quic"}}, false},
+		{Config{Transports: []string{"tls"}, KeyTypes: []string{"dsa"}}, false},
+		{Config{Transports: []string{"tls", "noise"}, FIPS: true}, false},
+		{Config{Transports: []string{"tls"}, KeyTypes: []string{"secp256k1"}, FIPS: true}, false},
+	}
+	for _, v := range list {
+		if err := v.c.Validate(); (err == nil) != v.ok {
+			t.Errorf("validate %+v: %v", v.c, err)
+		}
+	}
+}
+
+func TestKeyTypes(t *testing.T) {
+	c := Config{Transports: []string{"tls"}, KeyTypes: []string{"ecdsa", "ed25519"}}
+	key, err := c.GenerateIdentity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key.Type() != crypto.ECDSA {
+		t.Errorf("generated key type %s, want ECDSA", key.Type())
+	}
+	if !c.AllowsKey(crypto.Ed25519) || c.AllowsKey(crypto.RSA) {
+		t.Error("allowed key types error")
+	}
+	if !Default.AllowsKey(crypto.Secp256k1) {
+		t.Error("every key type must be allowed by default")
+	}
+	if (Config{FIPS: true}).AllowsKey(crypto.Secp256k1) {
+		t.Error("secp256k1 must be refused in FIPS mode")
+	}
+}
+
+// newSecurityHost creates a local host with the security transports and
+// identity key type of the configuration
+func newSecurityHost(t *testing.T, c Config) peer.AddrInfo {
+	t.Helper()
+	key, err := c.GenerateIdentity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	h, err := libp2p.New(libp2p.Identity(key), libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"), Option(c))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { h.Close() })
+	Guard(h, c)
+	return peer.AddrInfo{ID: h.ID(), Addrs: h.Addrs()}
+}
+
+func TestTransports(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	client := Config{Transports: []string{"noise"}}
+	h, err := libp2p.New(libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"), Option(client))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+
+	if err := h.Connect(ctx, newSecurityHost(t, Config{Transports: []string{"tls", "noise"}})); err != nil {
+		t.Errorf("shared noise transport: %v", err)
+	}
+	if err := h.Connect(ctx, newSecurityHost(t, Config{Transports: []string{"tls"}})); err == nil {
+		t.Error("connected without a shared security transport")
+	}
+
+	// the peer only accepts ECDSA identities, the Ed25519 one is disconnected
+	server := newSecurityHost(t, Config{Transports: []string{"noise"}, KeyTypes: []string{"ecdsa"}})
+	if err := h.Connect(ctx, server); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for len(h.Network().ConnsToPeer(server.ID)) > 0 && time.Now().Before(deadline) {
+		time.Sleep(50 * time.Millisecond)
+	}
+	if len(h.Network().ConnsToPeer(server.ID)) > 0 {
+		t.Error("peer with a refused key type still connected")
+	}
+}
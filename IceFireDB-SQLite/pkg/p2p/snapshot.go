@@ -0,0 +1,120 @@
+package p2p
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/sirupsen/logrus"
+)
+
+// SnapshotProtocol is the protocol of the streams transferring a database
+// snapshot to a joining peer
+const SnapshotProtocol = protocol.ID("/icefiredb-sqlite/snapshot/1.0.0")
+
+// ErrNoSnapshot is returned when no peer could send a snapshot
+var ErrNoSnapshot = errors.New("no peer sent a snapshot")
+
+// A function that registers the snapshot stream handler of a host.
+// snapshot writes a consistent copy of the database to a file and returns
+// its path, the file is removed once sent. With a membership, only the
+// peers of the cluster are served.
+func ServeSnapshots(h host.Host, membership *Membership, snapshot func() (string, error)) {
+	h.SetStreamHandler(SnapshotProtocol, func(s network.Stream) {
+		remote := s.Conn().RemotePeer()
+		if membership != nil && !membership.Allowed(remote) {
+			logrus.Warnf("Snapshot refused to non-member peer %s", remote)
+			_ = s.Reset()
+			return
+		}
+		if err := sendSnapshot(s, snapshot); err != nil {
+			logrus.Errorf("Snapshot to peer %s failed: %v", remote, err)
+			_ = s.Reset()
+			return
+		}
+		logrus.Infof("Snapshot sent to peer %s", remote)
+		_ = s.Close()
+	})
+}
+
+// A function that writes a snapshot to a stream: its size then its content
+func sendSnapshot(w io.Writer, snapshot func() (string, error)) error {
+	path, err := snapshot()
+	if err != nil {
+		return err
+	}
+	defer os.Remove(path)
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	bw := bufio.NewWriter(w)
+	if err := binary.Write(bw, binary.BigEndian, uint64(info.Size())); err != nil {
+		return err
+	}
+	if _, err := io.Copy(bw, f); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// A function that fetches the snapshot of a peer and writes it to w,
+// a partial transfer is an error
+func FetchSnapshot(ctx context.Context, h host.Host, id peer.ID, w io.Writer) error {
+	s, err := h.NewStream(ctx, id, SnapshotProtocol)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = s.SetReadDeadline(deadline)
+	}
+	_ = s.CloseWrite()
+
+	r := bufio.NewReader(s)
+	var size uint64
+	if err := binary.Read(r, binary.BigEndian, &size); err != nil {
+		return fmt.Errorf("snapshot header: %w", err)
+	}
+	n, err := io.Copy(w, io.LimitReader(r, int64(size)))
+	if err != nil {
+		return err
+	}
+	if uint64(n) != size {
+		return fmt.Errorf("snapshot truncated: %d of %d bytes", n, size)
+	}
+	return nil
+}
+
+// A function that fetches a snapshot to a file from the first of the peers sending one
+func FetchSnapshotFrom(ctx context.Context, h host.Host, peers []peer.ID, f *os.File) (peer.ID, error) {
+	for _, id := range peers {
+		if err := f.Truncate(0); err != nil {
+			return "", err
+		}
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return "", err
+		}
+		if err := FetchSnapshot(ctx, h, id, f); err != nil {
+			logrus.Warnf("Snapshot from peer %s failed: %v", id, err)
+			continue
+		}
+		return id, nil
+	}
+	return "", ErrNoSnapshot
+}
@@ -0,0 +1,63 @@
+package p2p
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+func newTestHost(t *testing.T) host.Host {
+	h, err := libp2p.New(libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { h.Close() })
+	return h
+}
+
+func TestSnapshot(t *testing.T) {
+	server, client := newTestHost(t), newTestHost(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := client.Connect(ctx, peer.AddrInfo{ID: server.ID(), Addrs: server.Addrs()}); err != nil {
+		t.Fatal(err)
+	}
+
+	content := bytes.Repeat([]byte("snapshot"), 100000)
+	path := filepath.Join(t.TempDir(), "snapshot.db")
+	ServeSnapshots(server, nil, func() (string, error) {
+		return path, os.WriteFile(path, content, 0o600)
+	})
+
+	var buf bytes.Buffer
+	if err := FetchSnapshot(ctx, client, server.ID(), &buf); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), content) {
+		t.Fatalf("got %d bytes, want %d", buf.Len(), len(content))
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatal("snapshot file not removed once sent")
+	}
+
+	// a non-member is refused
+	_, member := newTestPeer(t)
+	ServeSnapshots(server, &Membership{peers: map[peer.ID]struct{}{member: {}}}, func() (string, error) {
+		return path, os.WriteFile(path, content, 0o600)
+	})
+	f, err := os.Create(filepath.Join(t.TempDir(), "fetched.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := FetchSnapshotFrom(ctx, client, []peer.ID{server.ID()}, f); err != ErrNoSnapshot {
+		t.Fatalf("want ErrNoSnapshot, got %v", err)
+	}
+}
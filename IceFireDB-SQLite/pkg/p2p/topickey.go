@@ -0,0 +1,350 @@
+package p2p
+
+import (
+	"bufio"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/sirupsen/logrus"
+)
+
+// TopicKeyProtocol is the protocol of the streams distributing the topic
+// keys between the members of the cluster
+const TopicKeyProtocol = protocol.ID("/icefiredb-sqlite/topickey/1.0.0")
+
+// ErrUnknownTopicKey is returned when a message is encrypted with a key
+// the node does not have, it is fetched from the sender
+var ErrUnknownTopicKey = errors.New("unknown topic key")
+
+// ErrRetiredTopicKey is returned when a message is encrypted with a key
+// retired for longer than the grace window
+var ErrRetiredTopicKey = errors.New("retired topic key")
+
+// TopicKey represents an AES-256-GCM key of the topic payloads
+type TopicKey struct {
+	ID  string `json:"id"`
+	Key []byte `json:"key"`
+	// When the key was created, unix nanoseconds. The newest key is the
+	// one the messages are encrypted with.
+	Created int64 `json:"created"`
+	// When a newer key replaced it, unix nanoseconds, 0 for the current key
+	Retired int64 `json:"retired,omitempty"`
+}
+
+// Keyring represents the topic keys of a node: the current key and the keys
+// it replaced, kept for the grace window to decrypt the messages in flight
+type Keyring struct {
+	mu      sync.Mutex
+	keys    map[string]*TopicKey
+	current *TopicKey
+	grace   time.Duration
+	// the file the keys are saved to, none when empty
+	path string
+}
+
+// DefaultKeyring is the keyring used by NewP2P, a nil keyring disables the
+// encryption of the topics. It must be set before the host is created.
+var DefaultKeyring *Keyring
+
+// the encrypted payload of a message
+type sealedMessage struct {
+	KeyID string `json:"kid"`
+	Nonce []byte `json:"nonce"`
+	Data  []byte `json:"data"`
+}
+
+// A function that generates a new topic key
+func newTopicKey() (*TopicKey, error) {
+	id := make([]byte, 8)
+	key := make([]byte, 32)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	return &TopicKey{ID: hex.EncodeToString(id), Key: key, Created: time.Now().UnixNano()}, nil
+}
+
+// LoadKeyring reads the keys of a node from the given file, a new key is
+// generated and saved when the file does not exist. The nodes adopt the
+// newest key they see, so the keys generated on their own converge.
+func LoadKeyring(path string, grace time.Duration) (*Keyring, error) {
+	kr := &Keyring{keys: make(map[string]*TopicKey), grace: grace, path: path}
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err == nil {
+		var keys []*TopicKey
+		if err := json.Unmarshal(data, &keys); err != nil {
+			return nil, fmt.Errorf("invalid key file %s: %w", path, err)
+		}
+		for _, k := range keys {
+			kr.add(k, time.Now())
+		}
+	}
+	if kr.current == nil {
+		if _, err := kr.Rotate(); err != nil {
+			return nil, err
+		}
+	}
+	return kr, nil
+}
+
+// A method of Keyring that adds a key, the newest key becomes the current
+// one and the others are retired. Must be called with the lock held.
+func (kr *Keyring) add(k *TopicKey, now time.Time) bool {
+	if len(k.Key) != 32 || k.ID == "" {
+		return false
+	}
+	if _, ok := kr.keys[k.ID]; ok {
+		return false
+	}
+	k = &TopicKey{ID: k.ID, Key: k.Key, Created: k.Created, Retired: k.Retired}
+	switch {
+	case kr.current == nil || k.Created > kr.current.Created:
+		if kr.current != nil {
+			kr.current.Retired = now.UnixNano()
+		}
+		k.Retired = 0
+		kr.current = k
+	case k.Retired == 0:
+		k.Retired = now.UnixNano()
+	}
+	kr.keys[k.ID] = k
+	kr.prune(now)
+	return true
+}
+
+// A method of Keyring that drops the keys retired for longer than the grace
+// window. Must be called with the lock held.
+func (kr *Keyring) prune(now time.Time) {
+	for id, k := range kr.keys {
+		if kr.expired(k, now) {
+			delete(kr.keys, id)
+		}
+	}
+}
+
+func (kr *Keyring) expired(k *TopicKey, now time.Time) bool {
+	return k.Retired != 0 && now.Sub(time.Unix(0, k.Retired)) > kr.grace
+}
+
+// A method of Keyring that writes the keys to its file. Must be called with
+// the lock held.
+func (kr *Keyring) save() error {
+	if kr.path == "" {
+		return nil
+	}
+	keys := make([]*TopicKey, 0, len(kr.keys))
+	for _, k := range kr.keys {
+		keys = append(keys, k)
+	}
+	data, err := json.Marshal(keys)
+	if err != nil {
+		return err
+	}
+	tmp := kr.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, kr.path)
+}
+
+// Rotate generates a new current key, the previous one is kept for the
+// grace window
+func (kr *Keyring) Rotate() (*TopicKey, error) {
+	k, err := newTopicKey()
+	if err != nil {
+		return nil, err
+	}
+	kr.mu.Lock()
+	defer kr.mu.Unlock()
+	kr.add(k, time.Now())
+	return k, kr.save()
+}
+
+// Adopt adds a key received from a peer and reports whether it is new
+func (kr *Keyring) Adopt(k *TopicKey) (bool, error) {
+	kr.mu.Lock()
+	defer kr.mu.Unlock()
+	if !kr.add(k, time.Now()) {
+		return false, nil
+	}
+	return true, kr.save()
+}
+
+// Current returns the key the messages are encrypted with
+func (kr *Keyring) Current() *TopicKey {
+	kr.mu.Lock()
+	defer kr.mu.Unlock()
+	return kr.current
+}
+
+// Key returns a key still in its grace window, nil when it is unknown
+func (kr *Keyring) Key(id string) *TopicKey {
+	kr.mu.Lock()
+	defer kr.mu.Unlock()
+	k := kr.keys[id]
+	if k == nil || kr.expired(k, time.Now()) {
+		return nil
+	}
+	return k
+}
+
+// Seal encrypts a message payload with the current key
+func (kr *Keyring) Seal(data []byte) ([]byte, error) {
+	k := kr.Current()
+	aead, err := newAEAD(k.Key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return json.Marshal(sealedMessage{KeyID: k.ID, Nonce: nonce, Data: aead.Seal(nil, nonce, data, []byte(k.ID))})
+}
+
+// Open decrypts a message payload, ErrUnknownTopicKey is returned with the
+// ID of a key the node does not have
+func (kr *Keyring) Open(data []byte) ([]byte, string, error) {
+	var m sealedMessage
+	if err := json.Unmarshal(data, &m); err != nil || m.KeyID == "" {
+		return nil, "", errors.New("message not encrypted")
+	}
+	kr.mu.Lock()
+	k := kr.keys[m.KeyID]
+	expired := k != nil && kr.expired(k, time.Now())
+	kr.mu.Unlock()
+	if k == nil {
+		return nil, m.KeyID, ErrUnknownTopicKey
+	}
+	if expired {
+		return nil, m.KeyID, ErrRetiredTopicKey
+	}
+	aead, err := newAEAD(k.Key)
+	if err != nil {
+		return nil, m.KeyID, err
+	}
+	if len(m.Nonce) != aead.NonceSize() {
+		return nil, m.KeyID, errors.New("invalid nonce")
+	}
+	plain, err := aead.Open(nil, m.Nonce, m.Data, []byte(m.KeyID))
+	return plain, m.KeyID, err
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// the request of a topic key stream: push a key, or get a key by its ID
+type topicKeyRequest struct {
+	Op  string    `json:"op"`
+	ID  string    `json:"id,omitempty"`
+	Key *TopicKey `json:"key,omitempty"`
+}
+
+// A function that registers the topic key stream handler of a host. Only
+// the members of the cluster push or get keys.
+func ServeTopicKeys(h host.Host, membership *Membership, kr *Keyring) {
+	h.SetStreamHandler(TopicKeyProtocol, func(s network.Stream) {
+		defer s.Close()
+		remote := s.Conn().RemotePeer()
+		if membership == nil || !membership.Allowed(remote) {
+			logrus.Warnf("Topic key refused to non-member peer %s", remote)
+			_ = s.Reset()
+			return
+		}
+		_ = s.SetDeadline(time.Now().Add(10 * time.Second))
+		var req topicKeyRequest
+		if err := json.NewDecoder(bufio.NewReader(s)).Decode(&req); err != nil {
+			_ = s.Reset()
+			return
+		}
+		switch req.Op {
+		case "push":
+			if req.Key == nil {
+				return
+			}
+			added, err := kr.Adopt(req.Key)
+			if err != nil {
+				logrus.Errorf("Save topic key %s: %v", req.Key.ID, err)
+			} else if added {
+				logrus.Infof("Topic key %s received from peer %s", req.Key.ID, remote)
+			}
+		case "get":
+			_ = json.NewEncoder(s).Encode(kr.Key(req.ID))
+		}
+	})
+}
+
+// A function that sends a topic key to a peer
+func PushTopicKey(ctx context.Context, h host.Host, id peer.ID, k *TopicKey) error {
+	s, err := h.NewStream(ctx, id, TopicKeyProtocol)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+	return json.NewEncoder(s).Encode(topicKeyRequest{Op: "push", Key: k})
+}
+
+// A function that fetches a topic key from a peer and adds it to the keyring
+func FetchTopicKey(ctx context.Context, h host.Host, id peer.ID, kr *Keyring, keyID string) error {
+	s, err := h.NewStream(ctx, id, TopicKeyProtocol)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = s.SetDeadline(deadline)
+	}
+	if err := json.NewEncoder(s).Encode(topicKeyRequest{Op: "get", ID: keyID}); err != nil {
+		return err
+	}
+	_ = s.CloseWrite()
+	var k *TopicKey
+	if err := json.NewDecoder(bufio.NewReader(s)).Decode(&k); err != nil {
+		return err
+	}
+	if k == nil || k.ID != keyID {
+		return ErrUnknownTopicKey
+	}
+	_, err = kr.Adopt(k)
+	return err
+}
+
+// DistributeTopicKey sends a key to the connected members of the cluster and
+// returns the number of peers which received it
+func DistributeTopicKey(ctx context.Context, h host.Host, membership *Membership, k *TopicKey) int {
+	sent := 0
+	for _, id := range h.Network().Peers() {
+		if membership == nil || !membership.Allowed(id) {
+			continue
+		}
+		if err := PushTopicKey(ctx, h, id, k); err != nil {
+			logrus.Warnf("Topic key %s to peer %s failed: %v", k.ID, id, err)
+			continue
+		}
+		sent++
+	}
+	return sent
+}
@@ -0,0 +1,139 @@
+package p2p
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+func TestKeyring(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "topic.keys")
+	kr, err := LoadKeyring(path, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := kr.Current()
+	sealed, err := kr.Seal([]byte("insert"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the replaced key still decrypts the messages in its grace window
+	second, err := kr.Rotate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if kr.Current().ID != second.ID {
+		t.Fatalf("current key %s, want %s", kr.Current().ID, second.ID)
+	}
+	if data, id, err := kr.Open(sealed); err != nil || string(data) != "insert" || id != first.ID {
+		t.Errorf("open with the replaced key: %q %s %v", data, id, err)
+	}
+
+	// the keys are saved
+	loaded, err := LoadKeyring(path, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Current().ID != second.ID || loaded.Key(first.ID) == nil {
+		t.Errorf("loaded keyring: current %s", loaded.Current().ID)
+	}
+
+	// a keyring without the key asks for it, an older key is not current
+	other, err := LoadKeyring("", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, id, err := other.Open(sealed); err != ErrUnknownTopicKey || id != first.ID {
+		t.Errorf("open with an unknown key: %s %v", id, err)
+	}
+	if added, err := other.Adopt(first); !added || err != nil {
+		t.Fatalf("adopt: %v %v", added, err)
+	}
+	if other.Current().ID == first.ID {
+		t.Error("an older key must not become current")
+	}
+	// without a grace window the retired key is dropped
+	if _, _, err := other.Open(sealed); err == nil {
+		t.Error("message of a retired key decrypted")
+	}
+
+	tampered := append([]byte(nil), sealed...)
+	tampered[len(tampered)-3] ^= 1
+	if _, _, err := kr.Open(tampered); err == nil {
+		t.Error("tampered message decrypted")
+	}
+	if _, _, err := kr.Open([]byte(`{"message":"insert"}`)); err == nil {
+		t.Error("plain message accepted")
+	}
+}
+
+func TestTopicKeyStreams(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	a, err := libp2p.New(libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+	b, err := libp2p.New(libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	operator, _ := newTestPeer(t)
+	allowlist, err := SignAllowlist(operator, []peer.ID{a.ID(), b.ID()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	membership, err := allowlist.Verify(operator.GetPublic())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ka, _ := LoadKeyring("", time.Hour)
+	kb, _ := LoadKeyring("", time.Hour)
+	ServeTopicKeys(a, membership, ka)
+	ServeTopicKeys(b, membership, kb)
+	if err := a.Connect(ctx, peer.AddrInfo{ID: b.ID(), Addrs: b.Addrs()}); err != nil {
+		t.Fatal(err)
+	}
+
+	// b fetches the key of a message of a
+	sealed, _ := ka.Seal([]byte("insert"))
+	_, id, _ := kb.Open(sealed)
+	if err := FetchTopicKey(ctx, b, a.ID(), kb, id); err != nil {
+		t.Fatal(err)
+	}
+	if data, _, err := kb.Open(sealed); err != nil || string(data) != "insert" {
+		t.Errorf("open after fetch: %q %v", data, err)
+	}
+
+	// a rotation is pushed to the members
+	k, _ := ka.Rotate()
+	if n := DistributeTopicKey(ctx, a, membership, k); n != 1 {
+		t.Fatalf("key sent to %d peers", n)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for kb.Current().ID != k.ID && time.Now().Before(deadline) {
+		time.Sleep(50 * time.Millisecond)
+	}
+	if kb.Current().ID != k.ID {
+		t.Errorf("current key %s after the rotation, want %s", kb.Current().ID, k.ID)
+	}
+
+	// a non-member gets no key
+	c, err := libp2p.New(libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.Peerstore().AddAddrs(a.ID(), a.Addrs(), time.Minute)
+	kc, _ := LoadKeyring("", time.Hour)
+	if err := FetchTopicKey(ctx, c, a.ID(), kc, k.ID); err == nil {
+		t.Error("key sent to a non-member")
+	}
+}
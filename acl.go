@@ -0,0 +1,552 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tidwall/match"
+	"github.com/tidwall/redcon"
+	rafthub "github.com/tidwall/uhaha"
+	"go.uber.org/zap"
+)
+
+var (
+	// the file of the ACL users and roles, the clients authenticated with
+	// --auth have every right without it
+	aclPath string
+	// the failed AUTH in a row locking a user out, 0 disables the lockout
+	aclLockoutAttempts = 5
+	// how long a user locked out is refused
+	aclLockoutTime = time.Minute
+)
+
+// the most users with failed AUTH tracked, the unknown user names given to
+// the providers would grow the failures without bound
+const aclMaxFailures = 10000
+
+var (
+	errWrongPass = errors.New("WRONGPASS invalid username-password pair or user is disabled.")
+	errNoPermKey = errors.New("NOPERM this user has no permissions to access one of the keys used as arguments")
+)
+
+// the user of the connections authenticated with --auth, or without auth
+const defaultUser = "default"
+
+// the roles every ACL file has, a role of the file with the same name
+// replaces them
+var aclBuiltinRoles = map[string]aclRoleConfig{
+	"admin":     {Commands: []string{"+@all"}},
+	"readwrite": {Commands: []string{"+@read", "+@write", "-flushall", "-flushdb"}},
+	"readonly":  {Commands: []string{"+@read"}},
+}
+
+// aclConfig is the content of --acl-file
+type aclConfig struct {
+	Roles map[string]aclRoleConfig `json:"roles"`
+	Users []aclUserConfig          `json:"users"`
+	// the providers authenticating the users not in Users, in order
+	Providers []authProviderConfig `json:"providers"`
+}
+
+// aclRoleConfig are the commands and the keys of a role. The command rules
+// apply in order, +@read allows a category and -flushall denies a command,
+// +config|get a subcommand. The keys are glob patterns, * by default.
+type aclRoleConfig struct {
+	Commands []string `json:"commands"`
+	Keys     []string `json:"keys"`
+}
+
+// aclUserConfig is a user with the scrypt hashes of its passwords
+type aclUserConfig struct {
+	Name      string   `json:"name"`
+	Passwords []string `json:"passwords"`
+	Roles     []string `json:"roles"`
+	Disabled  bool     `json:"disabled"`
+}
+
+type aclRule struct {
+	allow bool
+	// @all, @read, @write or @admin
+	category string
+	cmd, sub string
+}
+
+func parseACLRule(s string) (aclRule, error) {
+	if len(s) < 2 || (s[0] != '+' && s[0] != '-') {
+		return aclRule{}, fmt.Errorf("invalid command rule '%s'", s)
+	}
+	r := aclRule{allow: s[0] == '+'}
+	name := strings.ToLower(s[1:])
+	if strings.HasPrefix(name, "@") {
+		switch name {
+		case "@all", "@read", "@write", "@admin":
+			r.category = name
+		default:
+			return aclRule{}, fmt.Errorf("unknown command category '%s'", s[1:])
+		}
+		return r, nil
+	}
+	r.cmd, r.sub, _ = strings.Cut(name, "|")
+	return r, nil
+}
+
+// matches reports whether the rule is about a command
+func (r aclRule) matches(args []string) bool {
+	cmd := args[0]
+	switch r.category {
+	case "@all":
+		return true
+	case "@read":
+		return readCommands[cmd] != nil || cmd == "watchprefix"
+	case "@write":
+		return writeCommands[cmd] != nil
+	case "@admin":
+		return readCommands[cmd] == nil && writeCommands[cmd] == nil && cmd != "watchprefix"
+	}
+	if r.cmd != cmd {
+		return false
+	}
+	return r.sub == "" || (len(args) > 1 && strings.EqualFold(args[1], r.sub))
+}
+
+type aclRole struct {
+	name  string
+	rules []aclRule
+	keys  []string
+}
+
+// allowsCommand reports whether the role runs a command, the last rule
+// matching it decides
+func (r *aclRole) allowsCommand(args []string) bool {
+	allow := false
+	for _, rule := range r.rules {
+		if rule.matches(args) {
+			allow = rule.allow
+		}
+	}
+	return allow
+}
+
+// allowsEveryCommand reports whether the role runs every command, as the
+// scripts may call any of them
+func (r *aclRole) allowsEveryCommand() bool {
+	all := false
+	for _, rule := range r.rules {
+		if rule.category == "@all" {
+			all = rule.allow
+		} else if !rule.allow {
+			all = false
+		}
+	}
+	return all
+}
+
+// allowsKeys reports whether the role accesses the keys, allKeys for the
+// commands going through every key
+func (r *aclRole) allowsKeys(keys []string, allKeys bool) bool {
+	if allKeys {
+		for _, pattern := range r.keys {
+			if pattern == "*" {
+				return true
+			}
+		}
+		return false
+	}
+	for _, key := range keys {
+		ok := false
+		for _, pattern := range r.keys {
+			if match.Match(key, pattern) {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
+
+type aclUser struct {
+	name      string
+	passwords []*passwordHash
+	roles     []*aclRole
+	disabled  bool
+}
+
+// allowed returns the error of a command the user may not run
+func (u *aclUser) allowed(args []string) error {
+	cmd := args[0]
+	if cmd == "tracer" || cmd == "tracew" {
+		// TRACER traceparent command args...
+		if len(args) < 3 {
+			return nil
+		}
+		inner := append([]string{strings.ToLower(args[2])}, args[3:]...)
+		return u.allowed(inner)
+	}
+	keys, allKeys := commandKeys(cmd, args)
+	cmdAllowed := false
+	for _, role := range u.roles {
+		// the commands called by a script are not checked, only the roles
+		// allowed every command run one
+		if !role.allowsCommand(args) || (scriptCommands[cmd] && !role.allowsEveryCommand()) {
+			continue
+		}
+		cmdAllowed = true
+		if role.allowsKeys(keys, allKeys) {
+			return nil
+		}
+	}
+	if !cmdAllowed {
+		return fmt.Errorf("NOPERM this user has no permissions to run the '%s' command", cmd)
+	}
+	return errNoPermKey
+}
+
+// the commands whose arguments are all keys
+var multiKeyCommands = map[string]bool{
+	"del": true, "exists": true, "mget": true, "rpoplpush": true,
+	"sdiff": true, "sinter": true, "sunion": true,
+	"sdiffstore": true, "sinterstore": true, "sunionstore": true,
+	"hmclear": true, "lmclear": true, "smclear": true,
+}
+
+// the commands going through every key
+var allKeysCommands = map[string]bool{
+	"flushall": true, "flushdb": true,
+	"xscan": true, "xhscan": true, "xsscan": true, "xzscan": true, "scanat": true,
+}
+
+// commandKeys returns the keys of a command, or allKeys for the commands
+// going through every key
+func commandKeys(cmd string, args []string) (keys []string, allKeys bool) {
+	switch {
+	case allKeysCommands[cmd]:
+		return nil, true
+	case cmd == "mset":
+		for i := 1; i < len(args); i += 2 {
+			keys = append(keys, args[i])
+		}
+	case cmd == "watchprefix":
+		// the watcher reads every key of the prefix
+		if len(args) > 1 {
+			keys = []string{args[1] + "*"}
+		}
+	case cmd == "bitop":
+		if len(args) > 2 {
+			keys = args[2:]
+		}
+	case cmd == "blpop":
+		// BLPOP key... timeout
+		if len(args) > 2 {
+			keys = args[1 : len(args)-1]
+		}
+	case cmd == "xread" || cmd == "xreadgroup":
+		// the keys follow STREAMS, then their IDs
+		if r, err := parseStreamRead(args); err == nil {
+			keys = r.keys
+		}
+	case cmd == "xgroup":
+		// XGROUP subcommand key ...
+		if len(args) > 2 {
+			keys = args[2:3]
+		}
+	case keylessCommands[cmd] && writeCommands[cmd] != nil:
+		// the writes without key, such as SCRIPT FLUSH and LOAD, change
+		// the data of every client
+		return nil, true
+	case scriptCommands[cmd]:
+		// EVAL script numkeys key... arg...
+		keys, _, _ = scriptKeys(args)
+	case multiKeyCommands[cmd]:
+		keys = args[1:]
+	case readCommands[cmd] != nil || writeCommands[cmd] != nil:
+		if key, ok := commandKey(cmd, args); ok {
+			keys = []string{key}
+		}
+	}
+	return keys, false
+}
+
+// aclFailures are the failed AUTH of a user in a row
+type aclFailures struct {
+	count       int
+	lockedUntil time.Time
+}
+
+// aclState holds the users, the roles and the providers of --acl-file, and
+// the failed AUTH, which are kept across reloads
+type aclState struct {
+	mu        sync.Mutex
+	users     map[string]*aclUser
+	roles     map[string]*aclRole
+	providers []*authBackend
+	failures  map[string]*aclFailures
+	// the failed AUTH and the users locked out, for the metrics
+	authFailures, lockouts uint64
+}
+
+var acl = &aclState{failures: make(map[string]*aclFailures)}
+
+func init() {
+	conf.AddIntermediateCommand("ACL", cmdACL)
+}
+
+// enabled reports whether the clients authenticate as ACL users
+func (a *aclState) enabled() bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.users != nil
+}
+
+// load reads the users and the roles of path, the users in use are kept when
+// the file is not valid
+func (a *aclState) load(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var cfg aclConfig
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	configs := make(map[string]aclRoleConfig, len(aclBuiltinRoles)+len(cfg.Roles))
+	for name, rc := range aclBuiltinRoles {
+		configs[name] = rc
+	}
+	for name, rc := range cfg.Roles {
+		configs[name] = rc
+	}
+	roles := make(map[string]*aclRole, len(configs))
+	for name, rc := range configs {
+		role := &aclRole{name: name, keys: rc.Keys}
+		if len(role.keys) == 0 {
+			role.keys = []string{"*"}
+		}
+		for _, s := range rc.Commands {
+			rule, err := parseACLRule(s)
+			if err != nil {
+				return fmt.Errorf("role %s: %w", name, err)
+			}
+			role.rules = append(role.rules, rule)
+		}
+		roles[name] = role
+	}
+	users := make(map[string]*aclUser, len(cfg.Users))
+	for _, uc := range cfg.Users {
+		if uc.Name == "" || uc.Name == defaultUser {
+			return fmt.Errorf("invalid user name '%s'", uc.Name)
+		}
+		if users[uc.Name] != nil {
+			return fmt.Errorf("user %s defined twice", uc.Name)
+		}
+		u := &aclUser{name: uc.Name, disabled: uc.Disabled}
+		for _, s := range uc.Passwords {
+			h, err := parsePasswordHash(s)
+			if err != nil {
+				return fmt.Errorf("user %s: %w", uc.Name, err)
+			}
+			u.passwords = append(u.passwords, h)
+		}
+		for _, name := range uc.Roles {
+			role := roles[name]
+			if role == nil {
+				return fmt.Errorf("user %s: unknown role '%s'", uc.Name, name)
+			}
+			u.roles = append(u.roles, role)
+		}
+		users[uc.Name] = u
+	}
+	providers := make([]*authBackend, 0, len(cfg.Providers))
+	for _, pc := range cfg.Providers {
+		b, err := newAuthBackend(pc, roles)
+		if err != nil {
+			return err
+		}
+		providers = append(providers, b)
+	}
+	a.mu.Lock()
+	a.users, a.roles, a.providers = users, roles, providers
+	a.mu.Unlock()
+	return nil
+}
+
+func (a *aclState) user(name string) *aclUser {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.users[name]
+}
+
+// externalUser returns a user authenticated by a provider with its roles,
+// nil when the providers or all its roles were removed by a reload
+func (a *aclState) externalUser(name string, roleNames []string) *aclUser {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if len(a.providers) == 0 || a.users[name] != nil {
+		return nil
+	}
+	u := &aclUser{name: name}
+	for _, rn := range roleNames {
+		if role := a.roles[rn]; role != nil {
+			u.roles = append(u.roles, role)
+		}
+	}
+	if len(u.roles) == 0 {
+		return nil
+	}
+	return u
+}
+
+// authenticateExternal tries the providers in order, and returns the roles
+// of the first accepting the credentials
+func authenticateExternal(providers []*authBackend, name, password string) ([]string, bool) {
+	for _, b := range providers {
+		ctx, cancel := context.WithTimeout(context.Background(), authProviderTimeout)
+		groups, err := b.provider.authenticate(ctx, name, password)
+		cancel()
+		if err != nil {
+			if !errors.Is(err, errAuthRejected) {
+				loggers[logServer].Warn("auth provider failed", zap.String("provider", b.name),
+					zap.String("user", name), zap.Error(err))
+			}
+			continue
+		}
+		roles := b.roles(groups)
+		if len(roles) == 0 {
+			loggers[logServer].Warn("user without role refused", zap.String("provider", b.name),
+				zap.String("user", name), zap.Strings("groups", groups))
+			continue
+		}
+		return roles, true
+	}
+	return nil, false
+}
+
+// authenticate checks the password of a user, the users not in the file
+// are authenticated by the providers, which return their roles. Past
+// --acl-lockout-attempts failures in a row, the user is refused for
+// --acl-lockout-time whatever the password. The error does not tell an
+// unknown user from a wrong password or a user locked out.
+func (a *aclState) authenticate(name, password string, now time.Time) ([]string, error) {
+	a.mu.Lock()
+	u := a.users[name]
+	providers := a.providers
+	f := a.failures[name]
+	locked := f != nil && now.Before(f.lockedUntil)
+	a.mu.Unlock()
+	ok := false
+	var roles []string
+	if u != nil && !u.disabled && !locked {
+		for _, h := range u.passwords {
+			if h.verify(password) {
+				ok = true
+				break
+			}
+		}
+	} else if u == nil && !locked {
+		roles, ok = authenticateExternal(providers, name, password)
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if ok {
+		delete(a.failures, name)
+		return roles, nil
+	}
+	a.authFailures++
+	if (u == nil && len(providers) == 0) || locked || aclLockoutAttempts <= 0 {
+		return nil, errWrongPass
+	}
+	if f == nil {
+		if len(a.failures) >= aclMaxFailures {
+			return nil, errWrongPass
+		}
+		f = &aclFailures{}
+		a.failures[name] = f
+	}
+	f.count++
+	if f.count >= aclLockoutAttempts {
+		f.count = 0
+		f.lockedUntil = now.Add(aclLockoutTime)
+		a.lockouts++
+		loggers[logServer].Warn("user locked out after failed AUTH", zap.String("user", name),
+			zap.Int("attempts", aclLockoutAttempts), zap.Duration("for", aclLockoutTime))
+	}
+	return nil, errWrongPass
+}
+
+// stats returns the failed AUTH and the lockouts
+func (a *aclState) stats() (failures, lockouts uint64) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.authFailures, a.lockouts
+}
+
+// list returns the users by name with their roles and lockout
+func (a *aclState) list(now time.Time) []string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	lines := make([]string, 0, len(a.users))
+	for name, u := range a.users {
+		state := "on"
+		if u.disabled {
+			state = "off"
+		}
+		roles := make([]string, len(u.roles))
+		for i, role := range u.roles {
+			roles[i] = role.name
+		}
+		var locked float64
+		if f := a.failures[name]; f != nil && now.Before(f.lockedUntil) {
+			locked = math.Ceil(f.lockedUntil.Sub(now).Seconds())
+		}
+		lines = append(lines, fmt.Sprintf("user %s %s roles=%s passwords=%d locked=%.0f",
+			name, state, strings.Join(roles, ","), len(u.passwords), locked))
+	}
+	sort.Strings(lines)
+	return lines
+}
+
+// cmdACL is ACL WHOAMI, ACL USERS, ACL LIST and ACL LOAD
+func cmdACL(m rafthub.Machine, args []string) (interface{}, error) {
+	if len(args) < 2 {
+		return nil, rafthub.ErrWrongNumArgs
+	}
+	switch strings.ToLower(args[1]) {
+	case "whoami":
+		if c, ok := m.Context().(*client); ok {
+			if cc := clients.get(c.id); cc != nil {
+				return cc.userName(), nil
+			}
+		}
+		return defaultUser, nil
+	case "users":
+		names := []string{defaultUser}
+		acl.mu.Lock()
+		for name := range acl.users {
+			names = append(names, name)
+		}
+		acl.mu.Unlock()
+		sort.Strings(names)
+		return names, nil
+	case "list":
+		return acl.list(time.Now()), nil
+	case "load":
+		if aclPath == "" {
+			return nil, errors.New("ERR no ACL file, see --acl-file")
+		}
+		if err := acl.load(aclPath); err != nil {
+			return nil, fmt.Errorf("ERR %v", err)
+		}
+		return redcon.SimpleString("OK"), nil
+	}
+	return nil, fmt.Errorf("ERR unknown subcommand '%s'", args[1])
+}
@@ -0,0 +1,225 @@
+//go:build alltest
+// +build alltest
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// writeACL writes an ACL file of users with password as their password
+func writeACL(t *testing.T, path, password string, roles map[string]aclRoleConfig, users ...aclUserConfig) {
+	t.Helper()
+	hash, err := hashPassword(password)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range users {
+		users[i].Passwords = []string{hash}
+	}
+	data, err := json.Marshal(aclConfig{Roles: roles, Users: users})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestACL(t *testing.T) {
+	getTestConn()
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "acl.json")
+	roles := map[string]aclRoleConfig{
+		"tenant1": {Commands: []string{"+@read", "+@write", "-flushall", "+config|get"}, Keys: []string{"tenant1:*"}},
+	}
+	writeACL(t, path, "pw", roles,
+		aclUserConfig{Name: "alice", Roles: []string{"tenant1"}},
+		aclUserConfig{Name: "bob", Roles: []string{"readonly"}},
+		aclUserConfig{Name: "carol", Roles: []string{"admin"}, Disabled: true},
+	)
+	if err := acl.load(path); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		acl.mu.Lock()
+		acl.users, acl.roles, acl.providers, acl.failures = nil, nil, nil, make(map[string]*aclFailures)
+		acl.mu.Unlock()
+	}()
+	aclPath = path
+	defer func() { aclPath = "" }()
+
+	login := func(user, password string) *redis.Client {
+		c := redis.NewClient(&redis.Options{Addr: "127.0.0.1:11001", Username: user, Password: password, MaxRetries: -1, PoolSize: 1})
+		t.Cleanup(func() { c.Close() })
+		return c
+	}
+	alice := login("alice", "pw")
+	for _, cmd := range []struct {
+		args []interface{}
+		err  string
+	}{
+		{[]interface{}{"SET", "tenant1:a", "1"}, ""},
+		{[]interface{}{"GET", "tenant1:a"}, ""},
+		{[]interface{}{"MGET", "tenant1:a", "tenant1:b"}, ""},
+		{[]interface{}{"CONFIG", "GET", "maxclients"}, ""},
+		{[]interface{}{"ACL", "WHOAMI"}, ""},
+		{[]interface{}{"SET", "tenant2:a", "1"}, "NOPERM this user has no permissions to access one of the keys used as arguments"},
+		{[]interface{}{"MSET", "tenant1:a", "1", "tenant2:a", "2"}, "NOPERM this user has no permissions to access one of the keys used as arguments"},
+		{[]interface{}{"XSCAN", "KV", ""}, "NOPERM this user has no permissions to access one of the keys used as arguments"},
+		{[]interface{}{"FLUSHALL"}, "NOPERM this user has no permissions to run the 'flushall' command"},
+		{[]interface{}{"CONFIG", "SET", "maxclients", "1"}, "NOPERM this user has no permissions to run the 'config' command"},
+		{[]interface{}{"ACL", "LIST"}, "NOPERM this user has no permissions to run the 'acl' command"},
+		{[]interface{}{"EVAL", "return redis.call('del', KEYS[1])", "1", "tenant1:a"}, "NOPERM this user has no permissions to run the 'eval' command"},
+		{[]interface{}{"EVAL_RO", "return redis.call('get', KEYS[1])", "1", "tenant1:a"}, "NOPERM this user has no permissions to run the 'eval_ro' command"},
+	} {
+		err := alice.Do(ctx, cmd.args...).Err()
+		if (err == nil && cmd.err != "") || (err != nil && err.Error() != cmd.err) {
+			t.Errorf("alice %v: %v", cmd.args, err)
+		}
+	}
+	if v, err := alice.Do(ctx, "ACL", "WHOAMI").Text(); v != "alice" {
+		t.Errorf("ACL WHOAMI: %q %v", v, err)
+	}
+	list, err := getTestConn().Do(ctx, "CLIENT", "LIST").Text()
+	if err != nil || !strings.Contains(list, " user=alice ") {
+		t.Errorf("CLIENT LIST: %q %v", list, err)
+	}
+	bob := login("bob", "pw")
+	if err := bob.Get(ctx, "tenant1:a").Err(); err != nil {
+		t.Errorf("bob GET: %v", err)
+	}
+	if err := bob.Set(ctx, "tenant1:a", "2", 0).Err(); err == nil || !strings.HasPrefix(err.Error(), "NOPERM") {
+		t.Errorf("bob SET: %v", err)
+	}
+	if err := bob.ZIncrBy(ctx, "tenant1:z", 1, "m").Err(); err == nil || !strings.HasPrefix(err.Error(), "NOPERM") {
+		t.Errorf("bob ZINCRBY: %v", err)
+	}
+	for _, user := range []string{"carol", "dave"} {
+		if err := login(user, "pw").Ping(ctx).Err(); err == nil || !strings.HasPrefix(err.Error(), "WRONGPASS") {
+			t.Errorf("%s: %v", user, err)
+		}
+	}
+
+	// HELLO 2 AUTH authenticates a connection
+	conn := login("", "").Conn()
+	defer conn.Close()
+	if v, err := connDo(ctx, conn, "HELLO", "2", "AUTH", "bob", "pw").Slice(); err != nil || len(v) < 4 || v[0] != "server" {
+		t.Errorf("HELLO 2 AUTH: %v %v", v, err)
+	}
+	if v, err := connDo(ctx, conn, "ACL", "WHOAMI").Text(); v != "bob" {
+		t.Errorf("ACL WHOAMI after HELLO: %q %v", v, err)
+	}
+	if err := connDo(ctx, conn, "HELLO", "4").Err(); err == nil || !strings.HasPrefix(err.Error(), "NOPROTO") {
+		t.Errorf("HELLO 4: %v", err)
+	}
+	// a failed AUTH does not leave the user of its name
+	if err := connDo(ctx, conn, "AUTH", "bob", "bad").Err(); err == nil || !strings.HasPrefix(err.Error(), "WRONGPASS") {
+		t.Errorf("AUTH with a wrong password: %v", err)
+	}
+	if v, err := connDo(ctx, conn, "ACL", "WHOAMI").Text(); v != "default" {
+		t.Errorf("ACL WHOAMI after a failed AUTH: %q %v", v, err)
+	}
+
+	// the failed AUTH in a row lock the user out
+	aclLockoutAttempts, aclLockoutTime = 3, time.Second
+	defer func() { aclLockoutAttempts, aclLockoutTime = 5, time.Minute }()
+	for i := 0; i < 3; i++ {
+		if err := login("alice", "bad").Ping(ctx).Err(); err == nil {
+			t.Fatalf("wrong password accepted")
+		}
+	}
+	if err := login("alice", "pw").Ping(ctx).Err(); err == nil || !strings.HasPrefix(err.Error(), "WRONGPASS") {
+		t.Errorf("alice locked out: %v", err)
+	}
+	lines, err := getTestConn().Do(ctx, "ACL", "LIST").StringSlice()
+	if err != nil || len(lines) != 3 || !strings.HasPrefix(lines[0], "user alice on roles=tenant1 ") || strings.HasSuffix(lines[0], " locked=0") {
+		t.Errorf("ACL LIST: %q %v", lines, err)
+	}
+	time.Sleep(aclLockoutTime)
+	if err := login("alice", "pw").Ping(ctx).Err(); err != nil {
+		t.Errorf("alice after the lockout: %v", err)
+	}
+	// the connections already authenticated are not locked out
+	if err := alice.Get(ctx, "tenant1:a").Err(); err != nil {
+		t.Errorf("alice connection: %v", err)
+	}
+
+	// ACL LOAD replaces the users, the connections of a removed user are
+	// refused
+	writeACL(t, path, "pw2", roles, aclUserConfig{Name: "alice", Roles: []string{"readonly"}})
+	if err := getTestConn().Do(ctx, "ACL", "LOAD").Err(); err != nil {
+		t.Fatal(err)
+	}
+	if err := bob.Get(ctx, "tenant1:a").Err(); err == nil {
+		t.Errorf("removed user served")
+	}
+	if err := alice.Set(ctx, "tenant1:a", "1", 0).Err(); err == nil {
+		t.Errorf("alice still writes after the reload")
+	}
+	if err := login("alice", "pw2").Get(ctx, "tenant1:a").Err(); err != nil {
+		t.Errorf("alice with the new password: %v", err)
+	}
+	if err := os.WriteFile(path, []byte(`{"users": [{"name": "eve", "roles": ["root"]}]}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := getTestConn().Do(ctx, "ACL", "LOAD").Err(); err == nil || !strings.Contains(err.Error(), "unknown role") {
+		t.Errorf("ACL LOAD of an invalid file: %v", err)
+	}
+	if acl.user("alice") == nil {
+		t.Errorf("users lost by an invalid file")
+	}
+}
+
+func TestACLScripts(t *testing.T) {
+	for _, tc := range []struct {
+		rules []string
+		eval  bool
+	}{
+		{[]string{"+@all"}, true},
+		{[]string{"-flushall", "+@all"}, true},
+		{[]string{"+@all", "-del"}, false},
+		{[]string{"+@read", "+@write", "+@admin"}, false},
+		{[]string{"+@write", "-del", "+eval"}, false},
+	} {
+		role := &aclRole{keys: []string{"*"}}
+		for _, s := range tc.rules {
+			rule, err := parseACLRule(s)
+			if err != nil {
+				t.Fatal(err)
+			}
+			role.rules = append(role.rules, rule)
+		}
+		u := &aclUser{roles: []*aclRole{role}}
+		if err := u.allowed([]string{"eval", "return 1", "1", "a"}); (err == nil) != tc.eval {
+			t.Errorf("%q EVAL: %v", tc.rules, err)
+		}
+	}
+
+	// SCRIPT goes through the scripts of every key
+	for _, tc := range []struct {
+		keys   []string
+		script bool
+	}{
+		{[]string{"*"}, true},
+		{[]string{"tenant1:*"}, false},
+	} {
+		role := &aclRole{keys: tc.keys}
+		rule, _ := parseACLRule("+@all")
+		role.rules = append(role.rules, rule)
+		u := &aclUser{roles: []*aclRole{role}}
+		for _, sub := range []string{"flush", "load"} {
+			if err := u.allowed([]string{"script", sub, "return 1"}); (err == nil) != tc.script {
+				t.Errorf("%q SCRIPT %s: %v", tc.keys, sub, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,424 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/tidwall/redcon"
+	"go.uber.org/zap"
+)
+
+var (
+	// admin API listen, disabled when empty
+	adminAddr string
+	// bearer token of the admin API, the cluster auth when empty
+	adminToken string
+)
+
+var errNotReady = errors.New("the node is not ready")
+
+// adminError is the body of the failed admin requests
+type adminError struct {
+	Error string `json:"error"`
+	// address of the leader, for the requests only the leader can serve
+	Leader string `json:"leader,omitempty"`
+}
+
+// nodeStatus is the status of the node served on GET /v1/status
+type nodeStatus struct {
+	ID             string            `json:"id"`
+	Version        string            `json:"version"`
+	GitSHA         string            `json:"git_sha,omitempty"`
+	Addr           string            `json:"addr"`
+	DataDir        string            `json:"data_dir"`
+	StorageBackend string            `json:"storage_backend"`
+	Uptime         float64           `json:"uptime_seconds"`
+	Clients        int64             `json:"clients"`
+	Leader         string            `json:"leader"`
+	Raft           map[string]string `json:"raft"`
+}
+
+// member is a server of the cluster
+type member struct {
+	ID      string `json:"id"`
+	Address string `json:"address"`
+	Leader  bool   `json:"leader"`
+}
+
+// nodeConfig is the configuration served on GET /v1/config, the log levels
+// can be changed with PUT
+type nodeConfig struct {
+	StorageBackend string            `json:"storage_backend"`
+	OpenReads      bool              `json:"openreads"`
+	NoSync         bool              `json:"nosync"`
+	LogFormat      string            `json:"log_format"`
+	LogLevels      map[string]string `json:"log_levels"`
+}
+
+// localDo runs a command on the node, an error reply is returned as an error
+func localDo(args ...string) (redcon.RESP, error) {
+	if localConnector == nil {
+		return redcon.RESP{}, errNotReady
+	}
+	lc, err := localConnector.Open()
+	if err != nil {
+		return redcon.RESP{}, err
+	}
+	defer lc.Close()
+	resp := lc.Do(args...)
+	if resp.Type == redcon.Error {
+		return resp, errors.New(resp.String())
+	}
+	return resp, nil
+}
+
+// the writes only the leader proposes through localDo, refused to the
+// clients
+var internalCommands = map[string]bool{
+	"cdccheckpoint": true, "diskfence": true,
+}
+
+// checkInternal refuses the internal writes to a client: a checkpoint moved
+// forward would skip the changes of a sink, a fence would stop the writes
+func checkInternal(args []string) error {
+	if internalCommands[args[0]] {
+		return fmt.Errorf("NOPERM %s is internal", strings.ToUpper(args[0]))
+	}
+	return nil
+}
+
+// adminHandler returns the admin API, every request must carry the token
+func adminHandler(token string) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /v1/status", handleStatus)
+	mux.HandleFunc("GET /v1/members", handleMembers)
+	mux.HandleFunc("POST /v1/members", handleAddMember)
+	mux.HandleFunc("DELETE /v1/members/{id}", handleRemoveMember)
+	mux.HandleFunc("POST /v1/join-tokens", handleJoinToken)
+	mux.HandleFunc("DELETE /v1/cdc/{sink}", handleDropCheckpoint)
+	mux.HandleFunc("GET /v1/snapshots", handleSnapshots)
+	mux.HandleFunc("POST /v1/snapshots", handleSnapshotNow)
+	mux.HandleFunc("GET /v1/snapshots/{id}", handleSnapshotFile)
+	mux.HandleFunc("GET /v1/config", handleConfig)
+	mux.HandleFunc("PUT /v1/config", handleConfig)
+	mux.HandleFunc("GET /v1/mode", handleNodeMode)
+	mux.HandleFunc("PUT /v1/mode", handleNodeMode)
+	mux.HandleFunc("POST /v1/drain", handleDrain)
+	mux.HandleFunc("POST /v1/bulk", handleBulk)
+	mux.HandleFunc("GET /v1/audit", handleAudit)
+	mux.HandleFunc("GET /v1/audit/verify", handleAuditVerify)
+	mux.HandleFunc("GET /v1/diagnostics", handleDiagnostics)
+	mux.HandleFunc("GET /v1/fsck", handleFsck)
+	registerPprof(mux)
+	// the probes of the orchestrator carry no token
+	mux.HandleFunc("GET /healthz", handleHealthz)
+	mux.HandleFunc("GET /readyz", handleReadyz)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/healthz" || r.URL.Path == "/readyz" {
+			mux.ServeHTTP(w, r)
+			return
+		}
+		bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(bearer), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="icefiredb"`)
+			writeJSON(w, http.StatusUnauthorized, adminError{Error: "unauthorized"})
+			return
+		}
+		loggers[logServer].Debug("admin request",
+			zap.String("method", r.Method), zap.String("path", r.URL.Path), zap.String("client", r.RemoteAddr))
+		mux.ServeHTTP(w, r)
+	})
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// writeError replies the error of a command run on the node, a command that
+// must run on the leader is answered with its address
+func writeError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, errNotReady):
+		writeJSON(w, http.StatusServiceUnavailable, adminError{Error: err.Error()})
+	case strings.HasPrefix(err.Error(), "MOVED 0 "):
+		writeJSON(w, http.StatusConflict, adminError{Error: "not the leader", Leader: strings.TrimPrefix(err.Error(), "MOVED 0 ")})
+	case strings.HasPrefix(err.Error(), "TRY "):
+		writeJSON(w, http.StatusConflict, adminError{Error: "not the leader", Leader: strings.TrimPrefix(err.Error(), "TRY ")})
+	default:
+		writeJSON(w, http.StatusInternalServerError, adminError{Error: err.Error()})
+	}
+}
+
+func handleStatus(w http.ResponseWriter, r *http.Request) {
+	resp, err := localDo("raft", "info")
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	st := nodeStatus{
+		ID:             conf.NodeID,
+		Version:        conf.Version,
+		GitSHA:         conf.GitSHA,
+		Addr:           conf.Addr,
+		DataDir:        conf.DataDir,
+		StorageBackend: storageBackend,
+		Uptime:         time.Since(startTime).Seconds(),
+		Clients:        atomic.LoadInt64(&respClientNum),
+		Raft:           make(map[string]string),
+	}
+	for k, v := range resp.Map() {
+		st.Raft[k] = v.String()
+	}
+	if resp, err = localDo("raft", "leader"); err == nil {
+		st.Leader = resp.String()
+	}
+	writeJSON(w, http.StatusOK, st)
+}
+
+func handleMembers(w http.ResponseWriter, r *http.Request) {
+	resp, err := localDo("raft", "server", "list")
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	members := []member{}
+	resp.ForEach(func(s redcon.RESP) bool {
+		m := s.Map()
+		members = append(members, member{
+			ID:      m["id"].String(),
+			Address: m["address"].String(),
+			Leader:  m["leader"].String() == "true",
+		})
+		return true
+	})
+	writeJSON(w, http.StatusOK, members)
+}
+
+// handleAddMember adds the voter of the body, {"id":"2","address":"host:port"},
+// it runs on the leader only
+func handleAddMember(w http.ResponseWriter, r *http.Request) {
+	var m member
+	if err := json.NewDecoder(r.Body).Decode(&m); err != nil {
+		writeJSON(w, http.StatusBadRequest, adminError{Error: err.Error()})
+		return
+	}
+	if m.ID == "" || m.Address == "" {
+		writeJSON(w, http.StatusBadRequest, adminError{Error: "id and address are required"})
+		return
+	}
+	_, err := localDo("raft", "server", "add", m.ID, m.Address)
+	auditAdmin(r, []string{"raft", "server", "add", m.ID, m.Address}, err)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	loggers[logRaft].Info("member added", zap.String("id", m.ID), zap.String("address", m.Address))
+	writeJSON(w, http.StatusCreated, m)
+}
+
+func handleRemoveMember(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	_, err := localDo("raft", "server", "remove", id)
+	auditAdmin(r, []string{"raft", "server", "remove", id}, err)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	loggers[logRaft].Info("member removed", zap.String("id", id))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleDropCheckpoint drops the checkpoint of a CDC sink removed, it runs
+// on the leader only
+func handleDropCheckpoint(w http.ResponseWriter, r *http.Request) {
+	sink := r.PathValue("sink")
+	_, err := localDo("cdccheckpoint", sink, "0")
+	auditAdmin(r, []string{"cdccheckpoint", sink, "0"}, err)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleJoinToken issues a join token for the node and ttl of the body
+func handleJoinToken(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Node string `json:"node"`
+		TTL  string `json:"ttl"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, adminError{Error: err.Error()})
+		return
+	}
+	args := []string{"jointoken", "issue", req.TTL}
+	if req.Node != "" {
+		args = append(args, req.Node)
+	}
+	resp, err := localDo(args...)
+	auditAdmin(r, args, err)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, map[string]string{"node": req.Node, "token": resp.String()})
+}
+
+func handleSnapshots(w http.ResponseWriter, r *http.Request) {
+	resp, err := localDo("raft", "snapshot", "list")
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	snaps := []map[string]string{}
+	resp.ForEach(func(s redcon.RESP) bool {
+		snap := make(map[string]string)
+		for k, v := range s.Map() {
+			snap[k] = v.String()
+		}
+		snaps = append(snaps, snap)
+		return true
+	})
+	writeJSON(w, http.StatusOK, snaps)
+}
+
+// handleSnapshotNow takes a snapshot of the node, the backup is then
+// downloaded from GET /v1/snapshots/{id}
+func handleSnapshotNow(w http.ResponseWriter, r *http.Request) {
+	resp, err := localDo("raft", "snapshot", "now")
+	auditAdmin(r, []string{"raft", "snapshot", "now"}, err)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	snap := make(map[string]string)
+	for k, v := range resp.Map() {
+		snap[k] = v.String()
+	}
+	loggers[logRaft].Info("snapshot taken", zap.String("id", snap["id"]))
+	writeJSON(w, http.StatusCreated, snap)
+}
+
+// handleSnapshotFile downloads a snapshot, a new cluster is restored from it
+// with --restore
+func handleSnapshotFile(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if strings.ContainsAny(id, `/\`) || strings.Contains(id, "..") {
+		writeJSON(w, http.StatusBadRequest, adminError{Error: "invalid snapshot id"})
+		return
+	}
+	resp, err := localDo("raft", "snapshot", "file", id)
+	auditAdmin(r, []string{"raft", "snapshot", "file", id}, err)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	f, err := os.Open(resp.String())
+	if err != nil {
+		writeJSON(w, http.StatusNotFound, adminError{Error: "snapshot not found"})
+		return
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.Header().Set("Content-Disposition", `attachment; filename="`+id+`.snapshot"`)
+	http.ServeContent(w, r, "", fi.ModTime(), f)
+}
+
+// handleConfig serves the configuration of the node, PUT sets the log
+// levels of the body, e.g. {"log_levels":{"raft":"debug"}}
+func handleConfig(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPut {
+		var c nodeConfig
+		if err := json.NewDecoder(r.Body).Decode(&c); err != nil {
+			writeJSON(w, http.StatusBadRequest, adminError{Error: err.Error()})
+			return
+		}
+		err := setLogLevels(c.LogLevels)
+		auditAdmin(r, logLevelsCommand(c.LogLevels), err)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, adminError{Error: err.Error()})
+			return
+		}
+	}
+	writeJSON(w, http.StatusOK, currentConfig())
+}
+
+// nodeModeBody is the body of /v1/mode
+type nodeModeBody struct {
+	Mode   string `json:"mode"`
+	Reason string `json:"reason,omitempty"`
+	Since  int64  `json:"since,omitempty"`
+}
+
+// handleNodeMode serves the mode of the node, PUT sets the mode of the body,
+// e.g. {"mode":"maintenance","reason":"disk replacement"}
+func handleNodeMode(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPut {
+		var b nodeModeBody
+		if err := json.NewDecoder(r.Body).Decode(&b); err != nil {
+			writeJSON(w, http.StatusBadRequest, adminError{Error: err.Error()})
+			return
+		}
+		err := nodeMode.set(b.Mode, b.Reason)
+		auditAdmin(r, []string{"nodemode", "set", b.Mode, b.Reason}, err)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, adminError{Error: err.Error()})
+			return
+		}
+	}
+	mode, reason, since := nodeMode.get()
+	b := nodeModeBody{Mode: mode, Reason: reason}
+	if !since.IsZero() {
+		b.Since = since.Unix()
+	}
+	writeJSON(w, http.StatusOK, b)
+}
+
+// currentConfig returns the configuration of the node
+func currentConfig() nodeConfig {
+	c := nodeConfig{
+		StorageBackend: storageBackend,
+		OpenReads:      conf.OpenReads,
+		NoSync:         conf.NoSync,
+		LogFormat:      logFormat,
+		LogLevels:      make(map[string]string, len(logSubsystems)),
+	}
+	for _, name := range logSubsystems {
+		c.LogLevels[name] = logLevels[name].String()
+	}
+	return c
+}
+
+// serveAdmin serves the admin API on addr, with the TLS certificate of the
+// server when it has one
+func serveAdmin(addr string) {
+	token := adminToken
+	if token == "" {
+		token = conf.Auth
+	}
+	srv := &http.Server{Addr: addr, Handler: adminHandler(token)}
+	ln, err := net.Listen("tcp", addr)
+	if err == nil {
+		fln := &filteredListener{Listener: ln, name: listenerAdmin}
+		if conf.TLSCertPath != "" {
+			err = srv.ServeTLS(fln, conf.TLSCertPath, conf.TLSKeyPath)
+		} else {
+			err = srv.Serve(fln)
+		}
+	}
+	loggers[logServer].Error("admin listen fail", zap.Error(err))
+}
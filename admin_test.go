@@ -0,0 +1,69 @@
+//go:build alltest
+// +build alltest
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func adminRequest(t *testing.T, h http.Handler, method, path, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	r := httptest.NewRequest(method, path, strings.NewReader(body))
+	r.Header.Set("Authorization", "Bearer secret")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func TestAdmin(t *testing.T) {
+	getTestConn()
+	h := adminHandler("secret")
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/v1/status", nil))
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("a request without the token must be refused, got %d", w.Code)
+	}
+
+	w = adminRequest(t, h, "GET", "/v1/status", "")
+	var st nodeStatus
+	if err := json.NewDecoder(w.Body).Decode(&st); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("status %d: %v", w.Code, err)
+	}
+	if st.Raft["state"] != "Leader" || st.Leader == "" {
+		t.Errorf("status %+v", st)
+	}
+
+	w = adminRequest(t, h, "GET", "/v1/members", "")
+	var members []member
+	if err := json.NewDecoder(w.Body).Decode(&members); err != nil || len(members) != 1 || !members[0].Leader {
+		t.Errorf("members %v: %v", members, err)
+	}
+	if w = adminRequest(t, h, "POST", "/v1/members", `{"id":"2"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("a member without address must be refused, got %d", w.Code)
+	}
+
+	w = adminRequest(t, h, "POST", "/v1/snapshots", "")
+	var snap map[string]string
+	if err := json.NewDecoder(w.Body).Decode(&snap); err != nil || w.Code != http.StatusCreated || snap["id"] == "" {
+		t.Fatalf("snapshot %d %v: %v", w.Code, snap, err)
+	}
+	if w = adminRequest(t, h, "GET", "/v1/snapshots/"+snap["id"], ""); w.Code != http.StatusOK || w.Body.Len() == 0 {
+		t.Errorf("snapshot download %d", w.Code)
+	}
+	if w = adminRequest(t, h, "GET", "/v1/snapshots/missing", ""); w.Code != http.StatusNotFound {
+		t.Errorf("a missing snapshot must not be found, got %d", w.Code)
+	}
+
+	defer setLogLevel("loglevel", "info")
+	w = adminRequest(t, h, "PUT", "/v1/config", `{"log_levels":{"raft":"debug"}}`)
+	var c nodeConfig
+	if err := json.NewDecoder(w.Body).Decode(&c); err != nil || c.LogLevels["raft"] != "debug" {
+		t.Errorf("config %d %+v: %v", w.Code, c, err)
+	}
+}
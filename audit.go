@@ -0,0 +1,285 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// audit log path, disabled when empty
+var auditPath string
+
+// the audit log, nil when disabled
+var auditLog *auditLogger
+
+// auditRecord is an entry of the audit log. Its hash covers the hash of the
+// previous entry, so an entry changed or removed breaks the chain.
+type auditRecord struct {
+	Seq  uint64    `json:"seq"`
+	Time time.Time `json:"time"`
+	Node string    `json:"node"`
+	// resp for the commands of the clients, admin for the admin API
+	Source  string   `json:"source"`
+	Client  string   `json:"client,omitempty"`
+	Command []string `json:"command"`
+	Error   string   `json:"error,omitempty"`
+	Prev    string   `json:"prev"`
+	Hash    string   `json:"hash"`
+}
+
+// digest returns the hash of the record, its own hash left out
+func (r auditRecord) digest() string {
+	r.Hash = ""
+	b, _ := json.Marshal(r)
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// auditLogger appends the records to a file, it is never truncated
+type auditLogger struct {
+	mu   sync.Mutex
+	f    *os.File
+	seq  uint64
+	last string
+}
+
+// openAuditLog opens the audit log at path and continues its chain, it fails
+// when the chain is broken
+func openAuditLog(path string) (*auditLogger, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	last, err := verifyAuditLog(f)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("audit log %s: %w", path, err)
+	}
+	return &auditLogger{f: f, seq: last.Seq, last: last.Hash}, nil
+}
+
+// verifyAuditLog checks the chain of the records read from r and returns the
+// last one
+func verifyAuditLog(r io.Reader) (auditRecord, error) {
+	var last auditRecord
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for sc.Scan() {
+		var rec auditRecord
+		if err := json.Unmarshal(sc.Bytes(), &rec); err != nil {
+			return last, fmt.Errorf("record %d: %w", last.Seq+1, err)
+		}
+		switch {
+		case rec.Seq != last.Seq+1:
+			return last, fmt.Errorf("record %d follows record %d", rec.Seq, last.Seq)
+		case rec.Prev != last.Hash:
+			return last, fmt.Errorf("record %d is not chained to record %d", rec.Seq, last.Seq)
+		case rec.Hash != rec.digest():
+			return last, fmt.Errorf("record %d was changed", rec.Seq)
+		}
+		last = rec
+	}
+	return last, sc.Err()
+}
+
+// Log appends a record of the command to the log
+func (l *auditLogger) Log(source, client string, command []string, cmdErr error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	rec := auditRecord{
+		Seq:     l.seq + 1,
+		Time:    time.Now().UTC(),
+		Node:    conf.NodeID,
+		Source:  source,
+		Client:  client,
+		Command: command,
+		Prev:    l.last,
+	}
+	if cmdErr != nil {
+		rec.Error = cmdErr.Error()
+	}
+	rec.Hash = rec.digest()
+	b, _ := json.Marshal(rec)
+	if _, err := l.f.Write(append(b, '\n')); err != nil {
+		loggers[logServer].Error("audit log write fail", zap.Error(err))
+		return
+	}
+	if err := l.f.Sync(); err != nil {
+		loggers[logServer].Error("audit log sync fail", zap.Error(err))
+	}
+	l.seq, l.last = rec.Seq, rec.Hash
+}
+
+// Export writes the records from seq since in JSON lines, or in CSV
+func (l *auditLogger) Export(w io.Writer, since uint64, format string) error {
+	f, err := os.Open(l.f.Name())
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	var cw *csv.Writer
+	if format == "csv" {
+		cw = csv.NewWriter(w)
+		defer cw.Flush()
+		_ = cw.Write([]string{"seq", "time", "node", "source", "client", "command", "error", "prev", "hash"})
+	}
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for sc.Scan() {
+		var rec auditRecord
+		if err := json.Unmarshal(sc.Bytes(), &rec); err != nil {
+			return err
+		}
+		if rec.Seq < since {
+			continue
+		}
+		if cw != nil {
+			err = cw.Write([]string{strconv.FormatUint(rec.Seq, 10), rec.Time.Format(time.RFC3339Nano), rec.Node,
+				rec.Source, rec.Client, strings.Join(rec.Command, " "), rec.Error, rec.Prev, rec.Hash})
+		} else {
+			_, err = w.Write(append(sc.Bytes(), '\n'))
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return sc.Err()
+}
+
+// Verify checks the chain of the whole log and returns its last record
+func (l *auditLogger) Verify() (auditRecord, error) {
+	f, err := os.Open(l.f.Name())
+	if err != nil {
+		return auditRecord{}, err
+	}
+	defer f.Close()
+	return verifyAuditLog(f)
+}
+
+// audited reports whether a command of a client is logged: the commands
+// changing the configuration or the users, deleting the data, changing the
+// members of the cluster, taking a backup or disconnecting a client
+func audited(args []string) bool {
+	if len(args) == 0 {
+		return false
+	}
+	arg := func(i int) string {
+		if i < len(args) {
+			return strings.ToLower(args[i])
+		}
+		return ""
+	}
+	switch arg(0) {
+	case "tracer", "tracew":
+		if len(args) > 2 {
+			return audited(args[2:])
+		}
+	case "flushall", "flushdb", "diskfence", "cdccheckpoint":
+		return true
+	case "config":
+		return arg(1) == "set"
+	case "client":
+		return arg(1) == "kill"
+	case "acl":
+		return arg(1) == "load"
+	case "token":
+		return arg(1) == "issue"
+	case "jointoken":
+		return arg(1) == "issue" || arg(1) == "redeem"
+	case "raft":
+		switch arg(1) {
+		case "server":
+			return arg(2) == "add" || arg(2) == "remove"
+		case "snapshot":
+			return arg(2) == "now"
+		}
+	}
+	return false
+}
+
+// auditFilter is the response filter of the raft machine, it logs the
+// audited commands of the clients with their outcome. The local connections
+// of the admin API are logged by the API.
+func auditFilter(_ string, context interface{}, args []string, v interface{}) interface{} {
+	if auditLog == nil || !audited(args) {
+		return v
+	}
+	c, ok := context.(*client)
+	if !ok || c.addr == "" {
+		return v
+	}
+	err, _ := v.(error)
+	auditLog.Log("resp", c.addr, redactAudit(args), err)
+	return v
+}
+
+// redactAudit returns the command with the tokens it carries left out, a
+// token refused may still be valid
+func redactAudit(args []string) []string {
+	if len(args) > 2 && strings.EqualFold(args[0], "jointoken") && strings.EqualFold(args[1], "redeem") {
+		args = append([]string(nil), args...)
+		args[2] = "(redacted)"
+	}
+	return args
+}
+
+// auditAdmin logs an action of the admin API
+func auditAdmin(r *http.Request, command []string, err error) {
+	if auditLog != nil {
+		auditLog.Log("admin", r.RemoteAddr, command, err)
+	}
+}
+
+// handleAudit exports the audit log from the since query parameter, in JSON
+// lines or with format=csv
+func handleAudit(w http.ResponseWriter, r *http.Request) {
+	if auditLog == nil {
+		writeJSON(w, http.StatusNotFound, adminError{Error: "audit log disabled"})
+		return
+	}
+	var since uint64
+	if v := r.URL.Query().Get("since"); v != "" {
+		var err error
+		if since, err = strconv.ParseUint(v, 10, 64); err != nil {
+			writeJSON(w, http.StatusBadRequest, adminError{Error: "invalid since"})
+			return
+		}
+	}
+	format := r.URL.Query().Get("format")
+	if format == "csv" {
+		w.Header().Set("Content-Type", "text/csv")
+	} else {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+	}
+	if err := auditLog.Export(w, since, format); err != nil {
+		loggers[logServer].Error("audit log export fail", zap.Error(err))
+	}
+}
+
+// handleAuditVerify checks the chain of the audit log
+func handleAuditVerify(w http.ResponseWriter, r *http.Request) {
+	if auditLog == nil {
+		writeJSON(w, http.StatusNotFound, adminError{Error: "audit log disabled"})
+		return
+	}
+	last, err := auditLog.Verify()
+	res := map[string]interface{}{"valid": err == nil, "records": last.Seq, "last_hash": last.Hash}
+	if err != nil {
+		res["error"] = err.Error()
+		writeJSON(w, http.StatusConflict, res)
+		return
+	}
+	writeJSON(w, http.StatusOK, res)
+}
@@ -0,0 +1,102 @@
+//go:build alltest
+// +build alltest
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAuditLog(t *testing.T) {
+	c := getTestConn()
+	ctx := context.Background()
+
+	path := filepath.Join(t.TempDir(), "audit.log")
+	l, err := openAuditLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	auditLog = l
+	defer func() {
+		auditLog = nil
+		_ = setLogLevel("loglevel", "info")
+	}()
+
+	if err := c.Do(ctx, "CONFIG", "SET", "loglevel-raft", "info").Err(); err != nil {
+		t.Fatal(err)
+	}
+	// a failed command is audited with its error
+	if err := c.Do(ctx, "FLUSHALL", "extra").Err(); err == nil {
+		t.Fatal("FLUSHALL with an argument must fail")
+	}
+	// the reads and the ordinary writes are not audited
+	if err := c.Do(ctx, "CONFIG", "GET", "loglevel").Err(); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Set(ctx, "audited", "no", 0).Err(); err != nil {
+		t.Fatal(err)
+	}
+	h := adminHandler("secret")
+	if w := adminRequest(t, h, "PUT", "/v1/config", `{"log_levels":{"p2p":"warn"}}`); w.Code != http.StatusOK {
+		t.Fatalf("config %d", w.Code)
+	}
+
+	w := adminRequest(t, h, "GET", "/v1/audit", "")
+	var recs []auditRecord
+	for _, line := range strings.Split(strings.TrimSpace(w.Body.String()), "\n") {
+		var rec auditRecord
+		if err := json.Unmarshal([]byte(line), &rec); err != nil {
+			t.Fatalf("%q: %v", line, err)
+		}
+		recs = append(recs, rec)
+	}
+	if len(recs) != 3 {
+		t.Fatalf("%d records, want 3: %v", len(recs), recs)
+	}
+	if strings.Join(recs[0].Command, " ") != "config SET loglevel-raft info" || recs[0].Source != "resp" || recs[0].Client == "" {
+		t.Errorf("record 1 %+v", recs[0])
+	}
+	if recs[1].Error == "" {
+		t.Errorf("record 2 %+v must carry the error", recs[1])
+	}
+	if strings.Join(recs[2].Command, " ") != "config set loglevel-p2p warn" || recs[2].Source != "admin" {
+		t.Errorf("record 3 %+v", recs[2])
+	}
+	if recs[1].Prev != recs[0].Hash || recs[2].Prev != recs[1].Hash {
+		t.Error("the records must be chained")
+	}
+
+	w = adminRequest(t, h, "GET", "/v1/audit?since=2&format=csv", "")
+	if lines := strings.Split(strings.TrimSpace(w.Body.String()), "\n"); len(lines) != 3 || !strings.HasPrefix(lines[1], "2,") {
+		t.Errorf("csv export %q", w.Body.String())
+	}
+	if w = adminRequest(t, h, "GET", "/v1/audit/verify", ""); w.Code != http.StatusOK {
+		t.Errorf("verify %d %s", w.Code, w.Body.String())
+	}
+
+	// the log continues its chain once reopened
+	l.f.Close()
+	if l, err = openAuditLog(path); err != nil || l.seq != 3 || l.last != recs[2].Hash {
+		t.Fatalf("reopen: %v", err)
+	}
+	auditLog = l
+
+	// a changed record breaks the chain
+	b, _ := os.ReadFile(path)
+	if err := os.WriteFile(path, []byte(strings.Replace(string(b), "loglevel-raft", "loglevel-p2p", 1)), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if w = adminRequest(t, h, "GET", "/v1/audit/verify", ""); w.Code != http.StatusConflict || !strings.Contains(w.Body.String(), "record 1 was changed") {
+		t.Errorf("verify of a changed log %d %s", w.Code, w.Body.String())
+	}
+	l.f.Close()
+	if _, err := openAuditLog(path); err == nil {
+		t.Error("a changed log must not be reopened")
+	}
+}
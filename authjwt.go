@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+	"time"
+)
+
+// jwtProvider authenticates the users with a JWT as their password, signed
+// with an HMAC secret or by one of the public keys
+type jwtProvider struct {
+	cfg    authProviderConfig
+	secret []byte
+	keys   []crypto.PublicKey
+}
+
+func newJWTProvider(cfg authProviderConfig) (*jwtProvider, error) {
+	p := &jwtProvider{cfg: cfg}
+	if cfg.SecretFile != "" {
+		secret, err := readSecret(cfg.SecretFile)
+		if err != nil {
+			return nil, err
+		}
+		p.secret = []byte(secret)
+	}
+	if cfg.PublicKeysFile != "" {
+		data, err := os.ReadFile(cfg.PublicKeysFile)
+		if err != nil {
+			return nil, err
+		}
+		for block, rest := pem.Decode(data); block != nil; block, rest = pem.Decode(rest) {
+			switch block.Type {
+			case "PUBLIC KEY":
+				key, err := x509.ParsePKIXPublicKey(block.Bytes)
+				if err != nil {
+					return nil, err
+				}
+				p.keys = append(p.keys, key)
+			case "CERTIFICATE":
+				cert, err := x509.ParseCertificate(block.Bytes)
+				if err != nil {
+					return nil, err
+				}
+				p.keys = append(p.keys, cert.PublicKey)
+			}
+		}
+		if len(p.keys) == 0 {
+			return nil, fmt.Errorf("no public key in %s", cfg.PublicKeysFile)
+		}
+	}
+	if len(p.secret) == 0 && len(p.keys) == 0 {
+		return nil, errors.New("secret_file or public_keys_file is required")
+	}
+	return p, nil
+}
+
+func (p *jwtProvider) authenticate(_ context.Context, username, token string) ([]string, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("%w: not a JWT", errAuthRejected)
+	}
+	var header struct {
+		Alg string `json:"alg"`
+	}
+	if err := decodeJWTPart(parts[0], &header); err != nil {
+		return nil, err
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errAuthRejected, err)
+	}
+	if !p.verify(header.Alg, []byte(parts[0]+"."+parts[1]), sig) {
+		return nil, fmt.Errorf("%w: invalid %s signature", errAuthRejected, header.Alg)
+	}
+	var claims map[string]interface{}
+	if err := decodeJWTPart(parts[1], &claims); err != nil {
+		return nil, err
+	}
+	return checkClaims(p.cfg, claims, username, time.Now(), true)
+}
+
+func decodeJWTPart(part string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return fmt.Errorf("%w: %v", errAuthRejected, err)
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("%w: %v", errAuthRejected, err)
+	}
+	return nil
+}
+
+// verify checks the signature of a JWT with the algorithm of its header, the
+// algorithm must fit the key
+func (p *jwtProvider) verify(alg string, signed, sig []byte) bool {
+	var hash crypto.Hash
+	switch alg[min(len(alg), 2):] {
+	case "256":
+		hash = crypto.SHA256
+	case "384":
+		hash = crypto.SHA384
+	case "512":
+		hash = crypto.SHA512
+	}
+	if alg == "EdDSA" {
+		for _, key := range p.keys {
+			if k, ok := key.(ed25519.PublicKey); ok && ed25519.Verify(k, signed, sig) {
+				return true
+			}
+		}
+		return false
+	}
+	if hash == 0 {
+		return false
+	}
+	h := hash.New()
+	h.Write(signed)
+	digest := h.Sum(nil)
+	for _, key := range p.keys {
+		switch k := key.(type) {
+		case *rsa.PublicKey:
+			switch alg[:2] {
+			case "RS":
+				if rsa.VerifyPKCS1v15(k, hash, digest, sig) == nil {
+					return true
+				}
+			case "PS":
+				if rsa.VerifyPSS(k, hash, digest, sig, nil) == nil {
+					return true
+				}
+			}
+		case *ecdsa.PublicKey:
+			size := (k.Curve.Params().BitSize + 7) / 8
+			if alg[:2] == "ES" && len(sig) == 2*size {
+				r := new(big.Int).SetBytes(sig[:size])
+				s := new(big.Int).SetBytes(sig[size:])
+				if ecdsa.Verify(k, digest, r, s) {
+					return true
+				}
+			}
+		}
+	}
+	if alg[:2] == "HS" && len(p.secret) > 0 {
+		mac := hmac.New(hash.New, p.secret)
+		mac.Write(signed)
+		return hmac.Equal(mac.Sum(nil), sig)
+	}
+	return false
+}
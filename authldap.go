@@ -0,0 +1,392 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// ldapProvider authenticates the users with a simple bind as their DN, and
+// takes their roles from the groups they are a member of
+type ldapProvider struct {
+	cfg     authProviderConfig
+	addr    string
+	tlsConf *tls.Config
+}
+
+func newLDAPProvider(cfg authProviderConfig) (*ldapProvider, error) {
+	u, err := url.Parse(cfg.URL)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.Contains(cfg.UserDN, "%s") {
+		return nil, errors.New("user_dn needs %s for the user name")
+	}
+	p := &ldapProvider{cfg: cfg, addr: u.Host}
+	if p.cfg.GroupMemberAttr == "" {
+		p.cfg.GroupMemberAttr = "member"
+	}
+	if p.cfg.GroupNameAttr == "" {
+		p.cfg.GroupNameAttr = "cn"
+	}
+	switch u.Scheme {
+	case "ldap":
+		if u.Port() == "" {
+			p.addr = net.JoinHostPort(u.Hostname(), "389")
+		}
+	case "ldaps":
+		if u.Port() == "" {
+			p.addr = net.JoinHostPort(u.Hostname(), "636")
+		}
+		p.tlsConf = &tls.Config{ServerName: u.Hostname(), MinVersion: tls.VersionTLS12}
+		if cfg.CAFile != "" {
+			data, err := os.ReadFile(cfg.CAFile)
+			if err != nil {
+				return nil, err
+			}
+			p.tlsConf.RootCAs = x509.NewCertPool()
+			if !p.tlsConf.RootCAs.AppendCertsFromPEM(data) {
+				return nil, fmt.Errorf("no certificate found in %s", cfg.CAFile)
+			}
+		}
+	default:
+		return nil, fmt.Errorf("invalid url '%s', expected ldap:// or ldaps://", cfg.URL)
+	}
+	return p, nil
+}
+
+func (p *ldapProvider) authenticate(ctx context.Context, username, password string) ([]string, error) {
+	// a bind without password is an anonymous bind, which succeeds
+	if username == "" || password == "" {
+		return nil, errAuthRejected
+	}
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", p.addr)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+	if p.tlsConf != nil {
+		tc := tls.Client(conn, p.tlsConf)
+		if err := tc.HandshakeContext(ctx); err != nil {
+			return nil, err
+		}
+		conn = tc
+	}
+	c := &ldapConn{w: conn, r: bufio.NewReader(conn)}
+	userDN := fmt.Sprintf(p.cfg.UserDN, ldapEscapeDN(username))
+	if err := c.bind(userDN, password); err != nil {
+		return nil, err
+	}
+	defer c.unbind()
+	if p.cfg.GroupBaseDN == "" {
+		return nil, nil
+	}
+	member := userDN
+	if strings.EqualFold(p.cfg.GroupMemberAttr, "memberUid") {
+		member = username
+	}
+	return c.search(p.cfg.GroupBaseDN, p.cfg.GroupMemberAttr, member, p.cfg.GroupNameAttr)
+}
+
+// ldapEscapeDN escapes a value of a DN as in RFC 4514
+func ldapEscapeDN(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == 0:
+			b.WriteString(`\00`)
+			continue
+		case strings.IndexByte(`,+"\<>;=`, c) >= 0,
+			i == 0 && (c == '#' || c == ' '),
+			i == len(s)-1 && c == ' ':
+			b.WriteByte('\\')
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
+
+// the BER tags of the LDAP messages (RFC 4511)
+const (
+	berBoolean     = 0x01
+	berInteger     = 0x02
+	berOctetString = 0x04
+	berEnumerated  = 0x0a
+	berSequence    = 0x30
+	berSet         = 0x31
+
+	ldapBindRequest      = 0x60
+	ldapBindResponse     = 0x61
+	ldapUnbindRequest    = 0x42
+	ldapSearchRequest    = 0x63
+	ldapSearchEntry      = 0x64
+	ldapSearchDone       = 0x65
+	ldapSearchReference  = 0x73
+	ldapSimpleAuth       = 0x80
+	ldapEqualityMatch    = 0xa3
+	ldapInvalidCredCode  = 49
+	ldapMaxMessageLength = 16 << 20
+)
+
+// berTLV encodes a BER value with a definite length
+func berTLV(tag byte, content ...[]byte) []byte {
+	n := 0
+	for _, c := range content {
+		n += len(c)
+	}
+	b := []byte{tag}
+	if n < 0x80 {
+		b = append(b, byte(n))
+	} else {
+		var l []byte
+		for v := n; v > 0; v >>= 8 {
+			l = append([]byte{byte(v)}, l...)
+		}
+		b = append(append(b, 0x80|byte(len(l))), l...)
+	}
+	for _, c := range content {
+		b = append(b, c...)
+	}
+	return b
+}
+
+func berInt(tag byte, v int) []byte {
+	b := []byte{byte(v)}
+	for v >>= 8; v > 0; v >>= 8 {
+		b = append([]byte{byte(v)}, b...)
+	}
+	if b[0]&0x80 != 0 {
+		b = append([]byte{0}, b...)
+	}
+	return berTLV(tag, b)
+}
+
+func berString(tag byte, s string) []byte {
+	return berTLV(tag, []byte(s))
+}
+
+// berValue is a decoded BER value
+type berValue struct {
+	tag  byte
+	data []byte
+}
+
+// berParse decodes the first value of b, the lengths in long form are
+// accepted even when they fit the short form
+func berParse(b []byte) (berValue, []byte, error) {
+	if len(b) < 2 {
+		return berValue{}, nil, io.ErrUnexpectedEOF
+	}
+	tag, n, hdr := b[0], int(b[1]), 2
+	if n&0x80 != 0 {
+		size := n & 0x7f
+		if size == 0 || size > 4 || len(b) < 2+size {
+			return berValue{}, nil, errors.New("ldap: invalid BER length")
+		}
+		n = 0
+		for _, c := range b[2 : 2+size] {
+			n = n<<8 | int(c)
+		}
+		hdr += size
+	}
+	if n < 0 || len(b) < hdr+n {
+		return berValue{}, nil, io.ErrUnexpectedEOF
+	}
+	return berValue{tag: tag, data: b[hdr : hdr+n]}, b[hdr+n:], nil
+}
+
+// children decodes the values of a constructed value
+func (v berValue) children() ([]berValue, error) {
+	var values []berValue
+	for b := v.data; len(b) > 0; {
+		c, rest, err := berParse(b)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, c)
+		b = rest
+	}
+	return values, nil
+}
+
+func (v berValue) int() int {
+	n := 0
+	for _, c := range v.data {
+		n = n<<8 | int(c)
+	}
+	return n
+}
+
+// readBER reads a whole BER value
+func readBER(r *bufio.Reader) ([]byte, error) {
+	hdr := make([]byte, 2, 6)
+	if _, err := io.ReadFull(r, hdr); err != nil {
+		return nil, err
+	}
+	n := int(hdr[1])
+	if n&0x80 != 0 {
+		size := n & 0x7f
+		if size == 0 || size > 4 {
+			return nil, errors.New("ldap: invalid BER length")
+		}
+		l := make([]byte, size)
+		if _, err := io.ReadFull(r, l); err != nil {
+			return nil, err
+		}
+		hdr = append(hdr, l...)
+		n = 0
+		for _, c := range l {
+			n = n<<8 | int(c)
+		}
+	}
+	if n > ldapMaxMessageLength {
+		return nil, errors.New("ldap: message too large")
+	}
+	b := make([]byte, len(hdr)+n)
+	copy(b, hdr)
+	if _, err := io.ReadFull(r, b[len(hdr):]); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// ldapConn runs the requests of a provider on a connection
+type ldapConn struct {
+	w      io.Writer
+	r      *bufio.Reader
+	lastID int
+}
+
+func (c *ldapConn) send(op []byte) error {
+	c.lastID++
+	_, err := c.w.Write(berTLV(berSequence, berInt(berInteger, c.lastID), op))
+	return err
+}
+
+// recv reads the operation of the next message
+func (c *ldapConn) recv() (berValue, error) {
+	b, err := readBER(c.r)
+	if err != nil {
+		return berValue{}, err
+	}
+	msg, _, err := berParse(b)
+	if err != nil {
+		return berValue{}, err
+	}
+	fields, err := msg.children()
+	if err != nil {
+		return berValue{}, err
+	}
+	if len(fields) < 2 || fields[0].int() != c.lastID {
+		return berValue{}, errors.New("ldap: unexpected message")
+	}
+	return fields[1], nil
+}
+
+// result returns the error of an LDAPResult
+func ldapResult(op berValue) error {
+	fields, err := op.children()
+	if err != nil {
+		return err
+	}
+	if len(fields) < 3 {
+		return errors.New("ldap: invalid result")
+	}
+	switch code := fields[0].int(); code {
+	case 0:
+		return nil
+	case ldapInvalidCredCode:
+		return errAuthRejected
+	default:
+		return fmt.Errorf("ldap: result code %d: %s", code, fields[2].data)
+	}
+}
+
+func (c *ldapConn) bind(dn, password string) error {
+	err := c.send(berTLV(ldapBindRequest,
+		berInt(berInteger, 3),
+		berString(berOctetString, dn),
+		berString(ldapSimpleAuth, password)))
+	if err != nil {
+		return err
+	}
+	op, err := c.recv()
+	if err != nil {
+		return err
+	}
+	if op.tag != ldapBindResponse {
+		return errors.New("ldap: unexpected bind response")
+	}
+	return ldapResult(op)
+}
+
+func (c *ldapConn) unbind() {
+	_ = c.send(berTLV(ldapUnbindRequest))
+}
+
+// search returns the values of attr of the entries under base whose
+// filterAttr equals value
+func (c *ldapConn) search(base, filterAttr, value, attr string) ([]string, error) {
+	err := c.send(berTLV(ldapSearchRequest,
+		berString(berOctetString, base),
+		berInt(berEnumerated, 2), // wholeSubtree
+		berInt(berEnumerated, 0), // neverDerefAliases
+		berInt(berInteger, 0),
+		berInt(berInteger, int(authProviderTimeout/time.Second)),
+		berTLV(berBoolean, []byte{0}),
+		berTLV(ldapEqualityMatch, berString(berOctetString, filterAttr), berString(berOctetString, value)),
+		berTLV(berSequence, berString(berOctetString, attr))))
+	if err != nil {
+		return nil, err
+	}
+	var values []string
+	for {
+		op, err := c.recv()
+		if err != nil {
+			return nil, err
+		}
+		switch op.tag {
+		case ldapSearchDone:
+			return values, ldapResult(op)
+		case ldapSearchReference:
+		case ldapSearchEntry:
+			fields, err := op.children()
+			if err != nil || len(fields) < 2 {
+				return nil, errors.New("ldap: invalid search entry")
+			}
+			attrs, err := fields[1].children()
+			if err != nil {
+				return nil, err
+			}
+			for _, a := range attrs {
+				parts, err := a.children()
+				if err != nil || len(parts) < 2 || !strings.EqualFold(string(parts[0].data), attr) {
+					continue
+				}
+				vals, err := parts[1].children()
+				if err != nil {
+					return nil, err
+				}
+				for _, v := range vals {
+					values = append(values, string(v.data))
+				}
+			}
+		default:
+			return nil, errors.New("ldap: unexpected search response")
+		}
+	}
+}
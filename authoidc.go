@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// oidcProvider authenticates the users with an access token as their
+// password, validated by the token introspection endpoint of an OpenID
+// provider (RFC 7662)
+type oidcProvider struct {
+	cfg          authProviderConfig
+	clientSecret string
+	client       *http.Client
+}
+
+func newOIDCProvider(cfg authProviderConfig) (*oidcProvider, error) {
+	if cfg.IntrospectionURL == "" {
+		return nil, errors.New("introspection_url is required")
+	}
+	if _, err := url.Parse(cfg.IntrospectionURL); err != nil {
+		return nil, err
+	}
+	p := &oidcProvider{cfg: cfg, client: &http.Client{}}
+	if cfg.UsernameClaim == "" {
+		p.cfg.UsernameClaim = "username"
+	}
+	if cfg.ClientSecretFile != "" {
+		secret, err := readSecret(cfg.ClientSecretFile)
+		if err != nil {
+			return nil, err
+		}
+		p.clientSecret = secret
+	}
+	return p, nil
+}
+
+func (p *oidcProvider) authenticate(ctx context.Context, username, token string) ([]string, error) {
+	form := url.Values{"token": {token}, "token_type_hint": {"access_token"}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.cfg.IntrospectionURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.cfg.ClientID != "" {
+		req.SetBasicAuth(url.QueryEscape(p.cfg.ClientID), url.QueryEscape(p.clientSecret))
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("introspection: %s", resp.Status)
+	}
+	var claims map[string]interface{}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&claims); err != nil {
+		return nil, fmt.Errorf("introspection: %w", err)
+	}
+	if active, _ := claims["active"].(bool); !active {
+		return nil, fmt.Errorf("%w: inactive token", errAuthRejected)
+	}
+	return checkClaims(p.cfg, claims, username, time.Now(), false)
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// how long an AUTH waits for an external provider
+var authProviderTimeout = 5 * time.Second
+
+// errAuthRejected is returned by a provider refusing the credentials, the
+// other errors are failures of the provider
+var errAuthRejected = errors.New("credentials rejected")
+
+// authProvider validates the credentials of the users unknown to the ACL
+// file against an external service
+type authProvider interface {
+	// authenticate returns the groups, or the role claims, of a user
+	authenticate(ctx context.Context, username, password string) ([]string, error)
+}
+
+// authProviderConfig is a provider of the ACL file. The groups or claims
+// the provider returns are mapped to ACL roles by RoleMap, and the users
+// also get DefaultRoles. A user without role is refused.
+type authProviderConfig struct {
+	Type         string              `json:"type"`
+	Name         string              `json:"name"`
+	RoleMap      map[string][]string `json:"role_map"`
+	DefaultRoles []string            `json:"default_roles"`
+
+	// the claim of the user name, sub by default, and of its roles, roles
+	// by default, for jwt and oidc
+	UsernameClaim string `json:"username_claim"`
+	RolesClaim    string `json:"roles_claim"`
+	Issuer        string `json:"issuer"`
+	Audience      string `json:"audience"`
+
+	// jwt: the HMAC secret, or the PEM public keys or certificates
+	SecretFile     string `json:"secret_file"`
+	PublicKeysFile string `json:"public_keys_file"`
+
+	// oidc: the token introspection endpoint and the client credentials
+	IntrospectionURL string `json:"introspection_url"`
+	ClientID         string `json:"client_id"`
+	ClientSecretFile string `json:"client_secret_file"`
+
+	// ldap: the server, the DN of the users with %s for the user name, and
+	// where to search their groups
+	URL             string `json:"url"`
+	CAFile          string `json:"ca_file"`
+	UserDN          string `json:"user_dn"`
+	GroupBaseDN     string `json:"group_base_dn"`
+	GroupMemberAttr string `json:"group_member_attr"`
+	GroupNameAttr   string `json:"group_name_attr"`
+}
+
+// authBackend is a provider with its role mapping
+type authBackend struct {
+	name         string
+	provider     authProvider
+	roleMap      map[string][]string
+	defaultRoles []string
+}
+
+// roles returns the ACL roles of the groups of a user, sorted
+func (b *authBackend) roles(groups []string) []string {
+	seen := make(map[string]bool)
+	var roles []string
+	add := func(names []string) {
+		for _, name := range names {
+			if !seen[name] {
+				seen[name] = true
+				roles = append(roles, name)
+			}
+		}
+	}
+	add(b.defaultRoles)
+	for _, g := range groups {
+		add(b.roleMap[g])
+	}
+	sort.Strings(roles)
+	return roles
+}
+
+// newAuthBackend builds a provider of the ACL file, roles are the roles
+// defined
+func newAuthBackend(cfg authProviderConfig, roles map[string]*aclRole) (*authBackend, error) {
+	b := &authBackend{name: cfg.Name, roleMap: cfg.RoleMap, defaultRoles: cfg.DefaultRoles}
+	if b.name == "" {
+		b.name = cfg.Type
+	}
+	for _, names := range append([][]string{cfg.DefaultRoles}, mapValues(cfg.RoleMap)...) {
+		for _, name := range names {
+			if roles[name] == nil {
+				return nil, fmt.Errorf("provider %s: unknown role '%s'", b.name, name)
+			}
+		}
+	}
+	var err error
+	switch cfg.Type {
+	case "jwt":
+		b.provider, err = newJWTProvider(cfg)
+	case "oidc":
+		b.provider, err = newOIDCProvider(cfg)
+	case "ldap":
+		b.provider, err = newLDAPProvider(cfg)
+	default:
+		err = fmt.Errorf("unknown type '%s'", cfg.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("provider %s: %w", b.name, err)
+	}
+	return b, nil
+}
+
+func mapValues(m map[string][]string) [][]string {
+	values := make([][]string, 0, len(m))
+	for _, v := range m {
+		values = append(values, v)
+	}
+	return values
+}
+
+// readSecret reads a secret file without its trailing new line
+func readSecret(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+// claimStrings returns the values of a claim: a string, a list of strings,
+// or the space separated scope
+func claimStrings(claims map[string]interface{}, name string) []string {
+	switch v := claims[name].(type) {
+	case string:
+		if name == "scope" {
+			return strings.Fields(v)
+		}
+		return []string{v}
+	case []interface{}:
+		values := make([]string, 0, len(v))
+		for _, e := range v {
+			if s, ok := e.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	}
+	return nil
+}
+
+// checkClaims checks the user name, the expiry, the issuer and the audience
+// of a token, and returns its roles claim. The tokens without expiry are
+// refused with requireExp.
+func checkClaims(cfg authProviderConfig, claims map[string]interface{}, username string, now time.Time, requireExp bool) ([]string, error) {
+	usernameClaim, rolesClaim := cfg.UsernameClaim, cfg.RolesClaim
+	if usernameClaim == "" {
+		usernameClaim = "sub"
+	}
+	if rolesClaim == "" {
+		rolesClaim = "roles"
+	}
+	if name, _ := claims[usernameClaim].(string); name == "" || name != username {
+		return nil, fmt.Errorf("%w: the token is not of user %s", errAuthRejected, username)
+	}
+	// a minute of clock skew
+	const skew = time.Minute
+	exp, ok := claims["exp"].(float64)
+	if !ok && requireExp {
+		return nil, fmt.Errorf("%w: the token has no expiry", errAuthRejected)
+	}
+	if ok && now.After(time.Unix(int64(exp), 0).Add(skew)) {
+		return nil, fmt.Errorf("%w: the token expired", errAuthRejected)
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now.Add(skew).Before(time.Unix(int64(nbf), 0)) {
+		return nil, fmt.Errorf("%w: the token is not valid yet", errAuthRejected)
+	}
+	if cfg.Issuer != "" {
+		if iss, _ := claims["iss"].(string); iss != cfg.Issuer {
+			return nil, fmt.Errorf("%w: issuer '%s'", errAuthRejected, iss)
+		}
+	}
+	if cfg.Audience != "" {
+		found := false
+		for _, aud := range claimStrings(claims, "aud") {
+			found = found || aud == cfg.Audience
+		}
+		if !found {
+			return nil, fmt.Errorf("%w: not for audience %s", errAuthRejected, cfg.Audience)
+		}
+	}
+	return claimStrings(claims, rolesClaim), nil
+}
@@ -0,0 +1,333 @@
+//go:build alltest
+// +build alltest
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// signJWT returns a JWT of claims signed with an HMAC secret or an ECDSA
+// P-256 key
+func signJWT(t *testing.T, key interface{}, claims map[string]interface{}) string {
+	t.Helper()
+	alg := "HS256"
+	if _, ok := key.(*ecdsa.PrivateKey); ok {
+		alg = "ES256"
+	}
+	header, _ := json.Marshal(map[string]string{"alg": alg, "typ": "JWT"})
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+	var sig []byte
+	switch k := key.(type) {
+	case []byte:
+		mac := hmac.New(crypto.SHA256.New, k)
+		mac.Write([]byte(signed))
+		sig = mac.Sum(nil)
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, k, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		sig = make([]byte, 64)
+		r.FillBytes(sig[:32])
+		s.FillBytes(sig[32:])
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func TestJWTProvider(t *testing.T) {
+	dir := t.TempDir()
+	secret := []byte("s3cret")
+	if err := os.WriteFile(filepath.Join(dir, "secret"), append(secret, '\n'), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "keys.pem"), pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	p, err := newJWTProvider(authProviderConfig{
+		SecretFile:     filepath.Join(dir, "secret"),
+		PublicKeysFile: filepath.Join(dir, "keys.pem"),
+		Issuer:         "https://idp",
+		Audience:       "icefiredb",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now().Unix()
+	claims := func(edit func(map[string]interface{})) map[string]interface{} {
+		c := map[string]interface{}{"sub": "alice", "iss": "https://idp", "aud": []string{"icefiredb"}, "exp": now + 60, "roles": []string{"dev"}}
+		if edit != nil {
+			edit(c)
+		}
+		return c
+	}
+	for _, key := range []interface{}{secret, key} {
+		groups, err := p.authenticate(context.Background(), "alice", signJWT(t, key, claims(nil)))
+		if err != nil || !reflect.DeepEqual(groups, []string{"dev"}) {
+			t.Errorf("%T: %v %v", key, groups, err)
+		}
+	}
+	for name, token := range map[string]string{
+		"expired":      signJWT(t, secret, claims(func(c map[string]interface{}) { c["exp"] = now - 120 })),
+		"no expiry":    signJWT(t, secret, claims(func(c map[string]interface{}) { delete(c, "exp") })),
+		"other user":   signJWT(t, secret, claims(func(c map[string]interface{}) { c["sub"] = "bob" })),
+		"issuer":       signJWT(t, secret, claims(func(c map[string]interface{}) { c["iss"] = "https://evil" })),
+		"audience":     signJWT(t, secret, claims(func(c map[string]interface{}) { c["aud"] = "other" })),
+		"wrong secret": signJWT(t, []byte("other"), claims(nil)),
+		"alg none":     strings.Join(strings.Split(signJWT(t, secret, claims(nil)), ".")[:2], ".") + ".",
+		"not a jwt":    "password",
+	} {
+		if _, err := p.authenticate(context.Background(), "alice", token); !errors.Is(err, errAuthRejected) {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+}
+
+func TestOIDCProvider(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if id, secret, _ := r.BasicAuth(); id != "icefiredb" || secret != "client-secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		resp := map[string]interface{}{"active": false}
+		if r.FormValue("token") == "good" {
+			resp = map[string]interface{}{"active": true, "username": "alice", "scope": "openid kv:write"}
+		}
+		_ = json.NewEncoder(w).Encode(resp)
+	}))
+	defer srv.Close()
+	secretFile := filepath.Join(t.TempDir(), "secret")
+	if err := os.WriteFile(secretFile, []byte("client-secret"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	p, err := newOIDCProvider(authProviderConfig{IntrospectionURL: srv.URL, ClientID: "icefiredb", ClientSecretFile: secretFile, RolesClaim: "scope"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	groups, err := p.authenticate(context.Background(), "alice", "good")
+	if err != nil || !reflect.DeepEqual(groups, []string{"openid", "kv:write"}) {
+		t.Errorf("active token: %v %v", groups, err)
+	}
+	if _, err := p.authenticate(context.Background(), "bob", "good"); !errors.Is(err, errAuthRejected) {
+		t.Errorf("token of another user: %v", err)
+	}
+	if _, err := p.authenticate(context.Background(), "alice", "bad"); !errors.Is(err, errAuthRejected) {
+		t.Errorf("inactive token: %v", err)
+	}
+	p.clientSecret = "wrong"
+	if _, err := p.authenticate(context.Background(), "alice", "good"); err == nil || errors.Is(err, errAuthRejected) {
+		t.Errorf("failed introspection: %v", err)
+	}
+}
+
+// fakeLDAP serves the binds of dn with password, and the groups of members
+func fakeLDAP(t *testing.T, dn, password string, groups map[string][]string) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	result := func(tag byte, code int) []byte {
+		return berTLV(tag, berInt(berEnumerated, code), berString(berOctetString, ""), berString(berOctetString, ""))
+	}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+				for {
+					b, err := readBER(r)
+					if err != nil {
+						return
+					}
+					msg, _, _ := berParse(b)
+					fields, _ := msg.children()
+					id := berInt(berInteger, fields[0].int())
+					op, _ := fields[1].children()
+					switch fields[1].tag {
+					case ldapBindRequest:
+						code := ldapInvalidCredCode
+						if string(op[1].data) == dn && string(op[2].data) == password {
+							code = 0
+						}
+						conn.Write(berTLV(berSequence, id, result(ldapBindResponse, code)))
+					case ldapSearchRequest:
+						filter, _ := op[6].children()
+						for _, name := range groups[string(filter[1].data)] {
+							conn.Write(berTLV(berSequence, id, berTLV(ldapSearchEntry,
+								berString(berOctetString, "cn="+name+",ou=groups,dc=example"),
+								berTLV(berSequence, berTLV(berSequence,
+									berString(berOctetString, "cn"),
+									berTLV(berSet, berString(berOctetString, name)))))))
+						}
+						conn.Write(berTLV(berSequence, id, result(ldapSearchDone, 0)))
+					case ldapUnbindRequest:
+						return
+					}
+				}
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func TestLDAPProvider(t *testing.T) {
+	dn := `uid=a\,lice,ou=people,dc=example`
+	addr := fakeLDAP(t, dn, "pw", map[string][]string{dn: {"devs", "ops"}})
+	p, err := newLDAPProvider(authProviderConfig{
+		URL:         "ldap://" + addr,
+		UserDN:      "uid=%s,ou=people,dc=example",
+		GroupBaseDN: "ou=groups,dc=example",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	groups, err := p.authenticate(context.Background(), "a,lice", "pw")
+	if err != nil || !reflect.DeepEqual(groups, []string{"devs", "ops"}) {
+		t.Errorf("bind: %v %v", groups, err)
+	}
+	for _, password := range []string{"bad", ""} {
+		if _, err := p.authenticate(context.Background(), "a,lice", password); !errors.Is(err, errAuthRejected) {
+			t.Errorf("password %q: %v", password, err)
+		}
+	}
+	if _, err := newLDAPProvider(authProviderConfig{URL: "http://" + addr, UserDN: "uid=%s"}); err == nil {
+		t.Errorf("http url accepted")
+	}
+}
+
+func TestAuthProviders(t *testing.T) {
+	getTestConn()
+	ctx := context.Background()
+	dir := t.TempDir()
+	secret := []byte("s3cret")
+	if err := os.WriteFile(filepath.Join(dir, "secret"), secret, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	dn := "uid=bob,ou=people,dc=example"
+	addr := fakeLDAP(t, dn, "pw", map[string][]string{dn: {"devs"}})
+	cfg := aclConfig{
+		Roles: map[string]aclRoleConfig{"dev": {Commands: []string{"+@read", "+@write"}, Keys: []string{"dev:*"}}},
+		Providers: []authProviderConfig{
+			{Type: "jwt", SecretFile: filepath.Join(dir, "secret"), RoleMap: map[string][]string{"developer": {"dev"}}},
+			{Type: "ldap", URL: "ldap://" + addr, UserDN: "uid=%s,ou=people,dc=example", GroupBaseDN: "ou=groups,dc=example",
+				RoleMap: map[string][]string{"devs": {"dev"}}, DefaultRoles: []string{"readonly"}},
+		},
+	}
+	path := filepath.Join(dir, "acl.json")
+	writeConfig := func() {
+		data, err := json.Marshal(cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, data, 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeConfig()
+	if err := acl.load(path); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		acl.mu.Lock()
+		acl.users, acl.roles, acl.providers, acl.failures = nil, nil, nil, make(map[string]*aclFailures)
+		acl.mu.Unlock()
+	}()
+	aclPath = path
+	defer func() { aclPath = "" }()
+
+	login := func(user, password string) *redis.Client {
+		c := redis.NewClient(&redis.Options{Addr: "127.0.0.1:11001", Username: user, Password: password, MaxRetries: -1, PoolSize: 1})
+		t.Cleanup(func() { c.Close() })
+		return c
+	}
+	token := signJWT(t, secret, map[string]interface{}{"sub": "alice", "exp": time.Now().Unix() + 60, "roles": []string{"developer"}})
+	alice := login("alice", token)
+	if err := alice.Set(ctx, "dev:a", "1", 0).Err(); err != nil {
+		t.Errorf("jwt user SET: %v", err)
+	}
+	if err := alice.Set(ctx, "prod:a", "1", 0).Err(); err == nil || !strings.HasPrefix(err.Error(), "NOPERM") {
+		t.Errorf("jwt user SET out of its keys: %v", err)
+	}
+	if v, err := alice.Do(ctx, "ACL", "WHOAMI").Text(); v != "alice" {
+		t.Errorf("ACL WHOAMI: %q %v", v, err)
+	}
+	bob := login("bob", "pw")
+	if err := bob.Set(ctx, "dev:b", "1", 0).Err(); err != nil {
+		t.Errorf("ldap user SET: %v", err)
+	}
+	if err := bob.Get(ctx, "prod:a").Err(); err != nil && err != redis.Nil {
+		t.Errorf("ldap user GET with the default roles: %v", err)
+	}
+	// a token without mapped role is refused
+	token = signJWT(t, secret, map[string]interface{}{"sub": "carol", "exp": time.Now().Unix() + 60, "roles": []string{"guest"}})
+	for user, password := range map[string]string{"carol": token, "bob": "bad"} {
+		if err := login(user, password).Ping(ctx).Err(); err == nil || !strings.HasPrefix(err.Error(), "WRONGPASS") {
+			t.Errorf("%s: %v", user, err)
+		}
+	}
+
+	// the roles of the users of a provider removed by ACL LOAD are dropped
+	cfg.Providers = cfg.Providers[1:]
+	writeConfig()
+	if err := getTestConn().Do(ctx, "ACL", "LOAD").Err(); err != nil {
+		t.Fatal(err)
+	}
+	if err := bob.Set(ctx, "dev:b", "2", 0).Err(); err != nil {
+		t.Errorf("ldap user after the reload: %v", err)
+	}
+	cfg.Providers = nil
+	writeConfig()
+	if err := getTestConn().Do(ctx, "ACL", "LOAD").Err(); err != nil {
+		t.Fatal(err)
+	}
+	if err := alice.Get(ctx, "dev:a").Err(); err == nil {
+		t.Errorf("jwt user served without providers")
+	}
+	cfg.Providers = []authProviderConfig{{Type: "jwt", SecretFile: filepath.Join(dir, "secret"), DefaultRoles: []string{"root"}}}
+	writeConfig()
+	if err := getTestConn().Do(ctx, "ACL", "LOAD").Err(); err == nil || !strings.Contains(err.Error(), "unknown role") {
+		t.Errorf("ACL LOAD of a provider with an unknown role: %v", err)
+	}
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// the operations of the bench workloads
+var benchOps = []string{"set", "get", "hset", "hget", "incr", "lpush", "lrange"}
+
+// benchConfig is the workload of IceFireDB bench
+type benchConfig struct {
+	addrs     []string
+	user      string
+	password  string
+	clients   int
+	requests  int
+	pipeline  int
+	valueSize int
+	keyspace  int
+	dist      string
+	prefix    string
+	weights   map[string]int
+}
+
+// benchResult holds the latencies of the requests of an operation, and
+// their errors
+type benchResult struct {
+	latencies []time.Duration
+	errors    int
+}
+
+// parseWorkload parses the ratios of the operations, e.g. set=1,get=9
+func parseWorkload(s string) (map[string]int, error) {
+	weights := make(map[string]int)
+	total := 0
+	for _, part := range strings.Split(s, ",") {
+		op, w, ok := strings.Cut(strings.TrimSpace(part), "=")
+		op = strings.ToLower(op)
+		n, err := strconv.Atoi(w)
+		if !ok || err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid workload '%s', expected op=weight", part)
+		}
+		known := false
+		for _, o := range benchOps {
+			known = known || o == op
+		}
+		if !known {
+			return nil, fmt.Errorf("unknown operation '%s', expected one of %s", op, strings.Join(benchOps, ", "))
+		}
+		weights[op] += n
+		total += n
+	}
+	if total == 0 {
+		return nil, errors.New("the workload has no operation")
+	}
+	return weights, nil
+}
+
+// runBench runs IceFireDB bench [options] and returns the exit code
+func runBench(args []string, out io.Writer) int {
+	fs := flag.NewFlagSet("bench", flag.ContinueOnError)
+	fs.SetOutput(out)
+	addrs := fs.String("a", "127.0.0.1:11001", "addresses of the nodes, comma separated, the clients are spread over them")
+	var c benchConfig
+	fs.StringVar(&c.user, "user", "", "ACL user")
+	fs.StringVar(&c.password, "auth", "", "password of the user, or of --auth")
+	fs.IntVar(&c.clients, "c", 50, "concurrent clients")
+	fs.IntVar(&c.requests, "n", 100000, "total requests")
+	fs.IntVar(&c.pipeline, "P", 1, "requests of a pipeline")
+	fs.IntVar(&c.valueSize, "d", 64, "value size in bytes")
+	fs.IntVar(&c.keyspace, "r", 100000, "number of keys")
+	fs.StringVar(&c.dist, "dist", "uniform", "key distribution: uniform or zipf")
+	fs.StringVar(&c.prefix, "prefix", "bench:", "prefix of the keys")
+	workload := fs.String("workload", "set=1,get=1", "ratios of the operations: "+strings.Join(benchOps, ", "))
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	var err error
+	if c.weights, err = parseWorkload(*workload); err != nil {
+		fmt.Fprintln(out, err)
+		return 2
+	}
+	for _, addr := range strings.Split(*addrs, ",") {
+		if addr = strings.TrimSpace(addr); addr != "" {
+			c.addrs = append(c.addrs, addr)
+		}
+	}
+	switch {
+	case len(c.addrs) == 0:
+		err = errors.New("no address")
+	case c.clients <= 0 || c.requests <= 0 || c.pipeline <= 0 || c.keyspace <= 0 || c.valueSize < 0:
+		err = errors.New("-c, -n, -P and -r must be positive")
+	case c.dist != "uniform" && c.dist != "zipf":
+		err = fmt.Errorf("unknown distribution '%s', expected uniform or zipf", c.dist)
+	}
+	if err != nil {
+		fmt.Fprintln(out, err)
+		return 2
+	}
+	results, elapsed, err := bench(context.Background(), c)
+	if err != nil {
+		fmt.Fprintln(out, err)
+		return 1
+	}
+	printBench(out, c, results, elapsed)
+	return 0
+}
+
+// bench runs the workload and returns the results by operation
+func bench(ctx context.Context, c benchConfig) (map[string]*benchResult, time.Duration, error) {
+	dbs := make([]*redis.Client, len(c.addrs))
+	for i, addr := range c.addrs {
+		dbs[i] = redis.NewClient(&redis.Options{
+			Addr: addr, Username: c.user, Password: c.password,
+			PoolSize: c.clients/len(c.addrs) + 1, MaxRetries: -1,
+		})
+		defer dbs[i].Close()
+		if err := dbs[i].Ping(ctx).Err(); err != nil {
+			return nil, 0, fmt.Errorf("%s: %v", addr, err)
+		}
+	}
+	value := strings.Repeat("x", c.valueSize)
+	var ops []string
+	for _, op := range benchOps {
+		for i := 0; i < c.weights[op]; i++ {
+			ops = append(ops, op)
+		}
+	}
+
+	var mu sync.Mutex
+	results := make(map[string]*benchResult)
+	var wg sync.WaitGroup
+	start := time.Now()
+	for w := 0; w < c.clients; w++ {
+		n := c.requests / c.clients
+		if w < c.requests%c.clients {
+			n++
+		}
+		wg.Add(1)
+		go func(w, n int) {
+			defer wg.Done()
+			db := dbs[w%len(dbs)]
+			rnd := rand.New(rand.NewSource(time.Now().UnixNano() + int64(w)))
+			key := func() string { return c.prefix + strconv.Itoa(rnd.Intn(c.keyspace)) }
+			if c.dist == "zipf" {
+				zipf := rand.NewZipf(rnd, 1.1, 1, uint64(c.keyspace-1))
+				key = func() string { return c.prefix + strconv.FormatUint(zipf.Uint64(), 10) }
+			}
+			local := make(map[string]*benchResult)
+			for n > 0 {
+				size := min(c.pipeline, n)
+				n -= size
+				pipe := db.Pipeline()
+				cmds := make([]redis.Cmder, size)
+				names := make([]string, size)
+				for i := range cmds {
+					names[i] = ops[rnd.Intn(len(ops))]
+					cmds[i] = benchCommand(ctx, pipe, names[i], key(), value)
+				}
+				t := time.Now()
+				_, _ = pipe.Exec(ctx)
+				d := time.Since(t)
+				for i, cmd := range cmds {
+					r := local[names[i]]
+					if r == nil {
+						r = &benchResult{}
+						local[names[i]] = r
+					}
+					r.latencies = append(r.latencies, d)
+					if err := cmd.Err(); err != nil && err != redis.Nil {
+						r.errors++
+					}
+				}
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			for op, r := range local {
+				if results[op] == nil {
+					results[op] = &benchResult{}
+				}
+				results[op].latencies = append(results[op].latencies, r.latencies...)
+				results[op].errors += r.errors
+			}
+		}(w, n)
+	}
+	wg.Wait()
+	return results, time.Since(start), nil
+}
+
+// benchCommand queues an operation on a key in a pipeline
+func benchCommand(ctx context.Context, pipe redis.Pipeliner, op, key, value string) redis.Cmder {
+	switch op {
+	case "set":
+		return pipe.Set(ctx, key, value, 0)
+	case "get":
+		return pipe.Get(ctx, key)
+	case "hset":
+		return pipe.HSet(ctx, key+":h", "f", value)
+	case "hget":
+		return pipe.HGet(ctx, key+":h", "f")
+	case "incr":
+		return pipe.Incr(ctx, key+":n")
+	case "lpush":
+		return pipe.LPush(ctx, key+":l", value)
+	default:
+		return pipe.LRange(ctx, key+":l", 0, 9)
+	}
+}
+
+// percentile returns the latency under which are p percent of the sorted
+// latencies
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	i := int(float64(len(sorted))*p/100+0.5) - 1
+	return sorted[max(0, min(i, len(sorted)-1))]
+}
+
+// printBench writes the throughput and the latency percentiles of each
+// operation and of all of them
+func printBench(out io.Writer, c benchConfig, results map[string]*benchResult, elapsed time.Duration) {
+	fmt.Fprintf(out, "%d clients on %s, %d requests, pipeline %d, %d byte values, %d keys (%s)\n\n",
+		c.clients, strings.Join(c.addrs, ","), c.requests, c.pipeline, c.valueSize, c.keyspace, c.dist)
+	tw := tabwriter.NewWriter(out, 0, 8, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "op\trequests\terrors\trps\tp50\tp90\tp99\tp99.9\tmax\t")
+	all := &benchResult{}
+	row := func(op string, r *benchResult) {
+		sort.Slice(r.latencies, func(i, j int) bool { return r.latencies[i] < r.latencies[j] })
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%.0f\t%v\t%v\t%v\t%v\t%v\t\n", op, len(r.latencies), r.errors,
+			float64(len(r.latencies))/elapsed.Seconds(),
+			percentile(r.latencies, 50), percentile(r.latencies, 90), percentile(r.latencies, 99),
+			percentile(r.latencies, 99.9), percentile(r.latencies, 100))
+	}
+	for _, op := range benchOps {
+		if r := results[op]; r != nil {
+			row(op, r)
+			all.latencies = append(all.latencies, r.latencies...)
+			all.errors += r.errors
+		}
+	}
+	row("total", all)
+	tw.Flush()
+	fmt.Fprintf(out, "\nelapsed %v\n", elapsed.Round(time.Millisecond))
+}
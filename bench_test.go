@@ -0,0 +1,49 @@
+//go:build alltest
+// +build alltest
+
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseWorkload(t *testing.T) {
+	w, err := parseWorkload("set=1, GET=9,get=1")
+	if err != nil || w["set"] != 1 || w["get"] != 10 {
+		t.Fatalf("parseWorkload: %v %v", w, err)
+	}
+	for _, s := range []string{"", "set", "set=-1", "set=0", "sadd=1"} {
+		if _, err := parseWorkload(s); err == nil {
+			t.Errorf("workload %q accepted", s)
+		}
+	}
+	lat := []time.Duration{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}
+	if p := percentile(lat, 50); p != 5 {
+		t.Errorf("p50 %v", p)
+	}
+	if p := percentile(lat, 100); p != 10 {
+		t.Errorf("max %v", p)
+	}
+}
+
+func TestBench(t *testing.T) {
+	getTestConn()
+	var out bytes.Buffer
+	code := runBench([]string{"-a", "127.0.0.1:11001", "-n", "200", "-c", "4", "-P", "5", "-r", "10",
+		"-dist", "zipf", "-workload", "set=1,get=1,hset=1,hget=1,incr=1,lpush=1,lrange=1"}, &out)
+	if code != 0 {
+		t.Fatalf("bench exited with %d: %s", code, out.String())
+	}
+	for _, line := range strings.Split(out.String(), "\n") {
+		if fields := strings.Fields(line); len(fields) > 2 && fields[0] == "total" {
+			if fields[1] != "200" || fields[2] != "0" {
+				t.Errorf("total %q", line)
+			}
+			return
+		}
+	}
+	t.Errorf("no total in %s", out.String())
+}
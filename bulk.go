@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gomodule/redigo/redis"
+	rafthub "github.com/tidwall/uhaha"
+)
+
+// the commands of a bulk load sent at once, the writes of a raft entry
+const bulkChunk = 256
+
+// bulkResult is the reply of POST /v1/bulk
+type bulkResult struct {
+	Commands   int    `json:"commands"`
+	Errors     int    `json:"errors"`
+	FirstError string `json:"first_error,omitempty"`
+}
+
+// bulkLoad pipelines the commands on conn by chunks, so that the writes of
+// a chunk are proposed together, and counts the error replies
+type bulkLoad struct {
+	conn    redis.Conn
+	pending int
+	result  bulkResult
+}
+
+func (b *bulkLoad) send(args []string) error {
+	cmdArgs := make([]interface{}, len(args)-1)
+	for i, arg := range args[1:] {
+		cmdArgs[i] = arg
+	}
+	if err := b.conn.Send(args[0], cmdArgs...); err != nil {
+		return err
+	}
+	b.result.Commands++
+	b.pending++
+	if b.pending == bulkChunk {
+		return b.flush()
+	}
+	return nil
+}
+
+// flush sends the commands queued and reads their replies
+func (b *bulkLoad) flush() error {
+	if err := b.conn.Flush(); err != nil {
+		return err
+	}
+	for ; b.pending > 0; b.pending-- {
+		if _, err := b.conn.Receive(); err != nil {
+			if _, ok := err.(redis.Error); !ok {
+				return err
+			}
+			b.result.Errors++
+			if b.result.FirstError == "" {
+				b.result.FirstError = err.Error()
+			}
+		}
+	}
+	return nil
+}
+
+// handleBulk runs the write commands of the body, one JSON array of strings
+// by line, e.g. ["SET","k","v"]. They are pipelined on a connection to the
+// node, so that a loader pays a raft entry for up to 256 commands instead
+// of one for each. The commands before a malformed line are run.
+func handleBulk(w http.ResponseWriter, r *http.Request) {
+	conn, err := rafthub.RedisDial(conf.Addr, conf.Auth, serverTLS)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	defer conn.Close()
+	b := &bulkLoad{conn: conn}
+	dec := json.NewDecoder(r.Body)
+	for {
+		var args []string
+		if err = dec.Decode(&args); err == io.EOF {
+			err = b.flush()
+			break
+		}
+		if err == nil && (len(args) == 0 || writeCommands[strings.ToLower(args[0])] == nil) {
+			err = errors.New("not a write command")
+		}
+		if err != nil {
+			err = fmt.Errorf("command %d: %v", b.result.Commands+1, err)
+			if ferr := b.flush(); ferr != nil {
+				err = ferr
+			}
+			auditAdmin(r, []string{"bulk", strconv.Itoa(b.result.Commands)}, err)
+			writeJSON(w, http.StatusBadRequest, adminError{Error: err.Error()})
+			return
+		}
+		if err = b.send(args); err != nil {
+			break
+		}
+	}
+	auditAdmin(r, []string{"bulk", strconv.Itoa(b.result.Commands)}, err)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if b.result.Errors > 0 && b.result.Errors == b.result.Commands {
+		// a follower redirects every write to the leader
+		if msg := b.result.FirstError; strings.HasPrefix(msg, "MOVED 0 ") || strings.HasPrefix(msg, "TRY ") {
+			writeError(w, errors.New(msg))
+			return
+		}
+	}
+	writeJSON(w, http.StatusOK, b.result)
+}
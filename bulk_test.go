@@ -0,0 +1,53 @@
+//go:build alltest
+// +build alltest
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestBulk(t *testing.T) {
+	c := getTestConn()
+	ctx := context.Background()
+	h := adminHandler("secret")
+
+	var body strings.Builder
+	for i := 0; i < 600; i++ {
+		fmt.Fprintf(&body, "[\"SET\",\"bulk:%d\",\"v%d\"]\n", i, i)
+	}
+	body.WriteString(`["HSET","bulk:h","f","v"]` + "\n")
+	body.WriteString(`["INCR","bulk:1"]` + "\n")
+	w := adminRequest(t, h, "POST", "/v1/bulk", body.String())
+	var res bulkResult
+	if err := json.NewDecoder(w.Body).Decode(&res); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("bulk %d: %v", w.Code, err)
+	}
+	if res.Commands != 602 || res.Errors != 1 || res.FirstError == "" {
+		t.Errorf("bulk result %+v", res)
+	}
+	if v, err := c.Get(ctx, "bulk:599").Result(); err != nil || v != "v599" {
+		t.Errorf("GET bulk:599 %q %v", v, err)
+	}
+	if v, err := c.HGet(ctx, "bulk:h", "f").Result(); err != nil || v != "v" {
+		t.Errorf("HGET bulk:h %q %v", v, err)
+	}
+
+	// the commands before a bad line are run
+	w = adminRequest(t, h, "POST", "/v1/bulk", `["SET","bulk:a","1"]`+"\n"+`["GET","bulk:a"]`)
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "command 2") {
+		t.Errorf("a read in a bulk load must be refused, got %d %s", w.Code, w.Body)
+	}
+	if v, err := c.Get(ctx, "bulk:a").Result(); err != nil || v != "1" {
+		t.Errorf("GET bulk:a %q %v", v, err)
+	}
+	if w = adminRequest(t, h, "POST", "/v1/bulk", `["SET"`); w.Code != http.StatusBadRequest {
+		t.Errorf("a truncated body must be refused, got %d", w.Code)
+	}
+	c.Del(ctx, "bulk:h", "bulk:a")
+}
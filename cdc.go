@@ -0,0 +1,607 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	rafthub "github.com/tidwall/uhaha"
+	"go.uber.org/zap"
+)
+
+// the sinks of the change data capture, name=url, may be repeated
+var cdcSinks stringList
+
+// the version of the payload of the changes shipped
+const cdcSchema = "icefiredb.cdc.v1"
+
+// the writes shipped to a sink at once
+const cdcBatch = 256
+
+// cdcCheckpointsKey holds the cursors shipped in the storage, out of the
+// ledis key space, like the fences
+var cdcCheckpointsKey = []byte("__icefiredb_cdc_checkpoints")
+
+// cdcCheckpoints are the cursors of the last writes shipped to each sink.
+// They only change as CDCCHECKPOINT is applied, so a new leader ships from
+// where the last one stopped.
+var cdcCheckpoints = &cdcCheckpointSet{cursors: make(map[string]int64)}
+
+type cdcCheckpointSet struct {
+	mu      sync.RWMutex
+	cursors map[string]int64
+}
+
+func init() {
+	conf.Config.AddWriteCommand("CDCCHECKPOINT", cmdCDCCHECKPOINT)
+	conf.AddIntermediateCommand("CDC", cmdCDC)
+}
+
+// load reads the checkpoints of the storage, after it is opened or restored
+func (s *cdcCheckpointSet) load() error {
+	data, err := ldb.GetSDB().Get(cdcCheckpointsKey)
+	if err != nil {
+		return err
+	}
+	cursors := make(map[string]int64)
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &cursors); err != nil {
+			return err
+		}
+	}
+	s.mu.Lock()
+	s.cursors = cursors
+	s.mu.Unlock()
+	return nil
+}
+
+// advance moves the checkpoint of a sink forward, a cursor of 0 drops it
+func (s *cdcCheckpointSet) advance(sink string, cursor int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if cursor != 0 && cursor <= s.cursors[sink] {
+		return nil
+	}
+	cursors := make(map[string]int64, len(s.cursors)+1)
+	for name, c := range s.cursors {
+		cursors[name] = c
+	}
+	if cursor == 0 {
+		delete(cursors, sink)
+	} else {
+		cursors[sink] = cursor
+	}
+	var err error
+	if len(cursors) == 0 {
+		err = ldb.GetSDB().Delete(cdcCheckpointsKey)
+	} else {
+		var data []byte
+		if data, err = json.Marshal(cursors); err == nil {
+			err = ldb.GetSDB().Put(cdcCheckpointsKey, data)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	s.cursors = cursors
+	return nil
+}
+
+func (s *cdcCheckpointSet) get(sink string) int64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.cursors[sink]
+}
+
+// CDCCHECKPOINT sink cursor
+// Records the cursor of the last write shipped to a sink by the leader. The
+// checkpoint only moves forward, 0 drops the checkpoint of a sink removed.
+func cmdCDCCHECKPOINT(m rafthub.Machine, args []string) (interface{}, error) {
+	if len(args) != 3 {
+		return nil, rafthub.ErrWrongNumArgs
+	}
+	cursor, err := strconv.ParseInt(args[2], 10, 64)
+	if err != nil || cursor < 0 {
+		return nil, errWatchCursor
+	}
+	if err := cdcCheckpoints.advance(args[1], cursor); err != nil {
+		return nil, err
+	}
+	return "OK", nil
+}
+
+// cdcEvent is the payload of a write shipped to the sinks. The keys and the
+// arguments are base64 when one of them is not UTF-8.
+type cdcEvent struct {
+	Schema   string    `json:"schema"`
+	Cursor   string    `json:"cursor"`
+	Time     time.Time `json:"time"`
+	Op       string    `json:"op"`
+	Keys     []string  `json:"keys"`
+	Args     []string  `json:"args"`
+	Encoding string    `json:"encoding,omitempty"`
+}
+
+// newCDCEvent returns the payload of the changes of a write
+func newCDCEvent(changes []watchChange) cdcEvent {
+	c := changes[0]
+	e := cdcEvent{
+		Schema: cdcSchema,
+		Cursor: strconv.FormatInt(c.Cursor, 10),
+		Time:   time.Unix(0, c.Cursor).UTC(),
+		Op:     c.Op,
+		Keys:   []string{},
+		Args:   append([]string{}, c.Args...),
+	}
+	for _, c := range changes {
+		if !c.All {
+			e.Keys = append(e.Keys, c.Key)
+		}
+	}
+	for _, list := range [][]string{e.Keys, e.Args} {
+		for _, s := range list {
+			if !utf8.ValidString(s) {
+				e.Encoding = "base64"
+			}
+		}
+	}
+	if e.Encoding != "" {
+		for _, list := range [][]string{e.Keys, e.Args} {
+			for i, s := range list {
+				list[i] = base64.StdEncoding.EncodeToString([]byte(s))
+			}
+		}
+	}
+	return e
+}
+
+// cdcWrites groups the changes of the backlog by write
+func cdcWrites(changes []watchChange) [][]watchChange {
+	var writes [][]watchChange
+	for i := 0; i < len(changes); {
+		j := i + 1
+		for j < len(changes) && changes[j].Cursor == changes[i].Cursor {
+			j++
+		}
+		writes = append(writes, changes[i:j])
+		i = j
+	}
+	return writes
+}
+
+// cdcSink receives the writes in the order of the log. A batch that failed
+// is sent again, so the sink may receive a write more than once.
+type cdcSink interface {
+	send(events []cdcEvent) error
+	close() error
+}
+
+// parseCDCSink parses a --cdc-sink name=url
+func parseCDCSink(v string) (string, *url.URL, error) {
+	name, raw, ok := strings.Cut(v, "=")
+	if !ok || name == "" {
+		return "", nil, fmt.Errorf("expected name=url, got '%s'", v)
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", nil, err
+	}
+	switch u.Scheme {
+	case "file":
+		if u.Path == "" {
+			return "", nil, fmt.Errorf("sink %s has no path", name)
+		}
+	case "http", "https":
+	case "nats":
+		if strings.Trim(u.Path, "/") == "" {
+			return "", nil, fmt.Errorf("sink %s has no subject", name)
+		}
+	case "kafka":
+		return "", nil, fmt.Errorf("sink %s: kafka is not supported, bridge the nats or http sink to it", name)
+	default:
+		return "", nil, fmt.Errorf("sink %s: unknown scheme '%s', expected file, http, https or nats", name, u.Scheme)
+	}
+	return name, u, nil
+}
+
+// checkCDCSinks validates --cdc-sink, the names are unique
+func checkCDCSinks() error {
+	names := make(map[string]bool)
+	for _, v := range cdcSinks {
+		name, _, err := parseCDCSink(v)
+		if err != nil {
+			return err
+		}
+		if names[name] {
+			return fmt.Errorf("sink %s is repeated", name)
+		}
+		names[name] = true
+	}
+	return nil
+}
+
+func newCDCSink(u *url.URL) (cdcSink, error) {
+	switch u.Scheme {
+	case "file":
+		f, err := os.OpenFile(u.Path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+		if err != nil {
+			return nil, err
+		}
+		return &cdcFileSink{f: f}, nil
+	case "http", "https":
+		return &cdcHTTPSink{url: u.String(), client: &http.Client{Timeout: 10 * time.Second}}, nil
+	case "nats":
+		s := &cdcNATSSink{addr: u.Host, subject: strings.Trim(u.Path, "/")}
+		if u.User != nil {
+			if pass, ok := u.User.Password(); ok {
+				s.user, s.pass = u.User.Username(), pass
+			} else {
+				s.token = u.User.Username()
+			}
+		}
+		if !strings.Contains(s.addr, ":") {
+			s.addr += ":4222"
+		}
+		return s, nil
+	}
+	return nil, fmt.Errorf("unknown scheme '%s'", u.Scheme)
+}
+
+// cdcFileSink appends the writes to a file, one JSON line each, synced
+// before the batch is done
+type cdcFileSink struct {
+	f *os.File
+}
+
+func (s *cdcFileSink) send(events []cdcEvent) error {
+	w := bufio.NewWriter(s.f)
+	enc := json.NewEncoder(w)
+	for _, e := range events {
+		if err := enc.Encode(e); err != nil {
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	return s.f.Sync()
+}
+
+func (s *cdcFileSink) close() error {
+	return s.f.Close()
+}
+
+// cdcHTTPSink posts each batch as a JSON array, signed as the webhooks
+type cdcHTTPSink struct {
+	url    string
+	client *http.Client
+}
+
+func (s *cdcHTTPSink) send(events []cdcEvent) error {
+	body, err := json.Marshal(events)
+	if err != nil {
+		return err
+	}
+	return postEvent(s.client, s.url, webhookSecret, "cdc", body)
+}
+
+func (s *cdcHTTPSink) close() error {
+	s.client.CloseIdleConnections()
+	return nil
+}
+
+// cdcNATSSink publishes each write to a subject of a NATS server. A PING
+// follows each batch, its PONG tells the server took the messages.
+type cdcNATSSink struct {
+	addr, subject     string
+	user, pass, token string
+	conn              net.Conn
+	r                 *bufio.Reader
+}
+
+// natsInfo is the part of the INFO greeting of the server the sink needs
+type natsInfo struct {
+	TLSRequired bool `json:"tls_required"`
+}
+
+func (s *cdcNATSSink) connect() error {
+	conn, err := net.DialTimeout("tcp", s.addr, 5*time.Second)
+	if err != nil {
+		return err
+	}
+	_ = conn.SetDeadline(time.Now().Add(10 * time.Second))
+	r := bufio.NewReader(conn)
+	line, err := r.ReadString('\n')
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	var info natsInfo
+	if !strings.HasPrefix(line, "INFO ") || json.Unmarshal([]byte(strings.TrimPrefix(line, "INFO ")), &info) != nil {
+		conn.Close()
+		return fmt.Errorf("unexpected greeting %q", strings.TrimSpace(line))
+	}
+	if info.TLSRequired {
+		host, _, _ := net.SplitHostPort(s.addr)
+		tc := tls.Client(conn, &tls.Config{ServerName: host})
+		if err := tc.Handshake(); err != nil {
+			conn.Close()
+			return err
+		}
+		conn, r = tc, bufio.NewReader(tc)
+	}
+	opts, _ := json.Marshal(map[string]interface{}{
+		"verbose": false, "pedantic": false, "tls_required": info.TLSRequired,
+		"name": "icefiredb-cdc-" + conf.NodeID, "lang": "go", "version": conf.Version,
+		"user": s.user, "pass": s.pass, "auth_token": s.token,
+	})
+	s.conn, s.r = conn, r
+	if _, err := fmt.Fprintf(conn, "CONNECT %s\r\nPING\r\n", opts); err != nil {
+		s.close()
+		return err
+	}
+	if err := s.pong(); err != nil {
+		s.close()
+		return err
+	}
+	return nil
+}
+
+// pong reads the replies of the server up to the PONG of the last PING
+func (s *cdcNATSSink) pong() error {
+	for {
+		line, err := s.r.ReadString('\n')
+		if err != nil {
+			return err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		switch {
+		case line == "PONG":
+			return nil
+		case line == "PING":
+			if _, err := s.conn.Write([]byte("PONG\r\n")); err != nil {
+				return err
+			}
+		case strings.HasPrefix(line, "-ERR"):
+			return errors.New(strings.TrimSpace(strings.TrimPrefix(line, "-ERR")))
+		}
+	}
+}
+
+func (s *cdcNATSSink) send(events []cdcEvent) error {
+	if s.conn == nil {
+		if err := s.connect(); err != nil {
+			return err
+		}
+	}
+	_ = s.conn.SetDeadline(time.Now().Add(10 * time.Second))
+	w := bufio.NewWriter(s.conn)
+	for _, e := range events {
+		data, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "PUB %s %d\r\n", s.subject, len(data))
+		w.Write(data)
+		w.WriteString("\r\n")
+	}
+	w.WriteString("PING\r\n")
+	err := w.Flush()
+	if err == nil {
+		err = s.pong()
+	}
+	if err != nil {
+		// the next batch reconnects
+		s.close()
+	}
+	return err
+}
+
+func (s *cdcNATSSink) close() error {
+	if s.conn == nil {
+		return nil
+	}
+	err := s.conn.Close()
+	s.conn, s.r = nil, nil
+	return err
+}
+
+// cdcShipper ships the writes of the log to a sink while the node leads
+type cdcShipper struct {
+	name string
+	sink cdcSink
+	mu   sync.Mutex
+	// the cursor of the last write shipped by this node, and of the last
+	// checkpoint it proposed
+	shipped, saved int64
+	checked        time.Time
+}
+
+// the shippers of --cdc-sink, in its order
+var cdcShippers []*cdcShipper
+
+// startCDC ships the writes to the sinks of --cdc-sink
+func startCDC() error {
+	for _, v := range cdcSinks {
+		name, u, err := parseCDCSink(v)
+		if err != nil {
+			return err
+		}
+		sink, err := newCDCSink(u)
+		if err != nil {
+			return fmt.Errorf("sink %s: %v", name, err)
+		}
+		s := &cdcShipper{name: name, sink: sink}
+		cdcShippers = append(cdcShippers, s)
+		go s.run(nil)
+	}
+	return nil
+}
+
+// cdcLeader reports whether the node leads the cluster, only the leader
+// ships
+func cdcLeader() bool {
+	resp, err := localDo("raft", "info")
+	return err == nil && resp.Map()["state"].String() == "Leader"
+}
+
+// run ships the writes while the node leads, until stop is closed
+func (s *cdcShipper) run(stop <-chan struct{}) {
+	t := time.NewTicker(time.Second)
+	defer t.Stop()
+	for {
+		if cdcLeader() {
+			s.ship(stop)
+		}
+		select {
+		case <-stop:
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// ship sends the writes after the checkpoint of the sink as long as the node
+// leads. A watcher falling behind returns, the next one resumes from the
+// last write shipped.
+func (s *cdcShipper) ship(stop <-chan struct{}) {
+	log := loggers[logServer].With(zap.String("sink", s.name))
+	after := cdcCheckpoints.get(s.name)
+	w, backlog, cursor, err := watches.subscribe("", after, after != 0)
+	if err == errWatchExpired {
+		log.Warn("the checkpoint of the sink is out of the backlog, shipping from now", zap.Int64("checkpoint", after))
+		events.Publish(eventCDCGap, map[string]interface{}{"sink": s.name, "checkpoint": strconv.FormatInt(after, 10)})
+		w, _, cursor, err = watches.subscribe("", 0, false)
+	}
+	if err != nil {
+		log.Warn("cdc subscribe fail", zap.Error(err))
+		return
+	}
+	defer watches.unsubscribe(w)
+	s.mu.Lock()
+	s.shipped = cursor
+	s.mu.Unlock()
+	if after == 0 || cursor > after {
+		// a new sink, or a sink past a gap, ships from now on
+		s.checkpoint(true)
+	}
+	pending := cdcWrites(backlog)
+	t := time.NewTicker(time.Second)
+	defer t.Stop()
+	for {
+		for len(pending) > 0 {
+			n := len(pending)
+			if n > cdcBatch {
+				n = cdcBatch
+			}
+			if !s.deliver(pending[:n]) {
+				return
+			}
+			pending = pending[n:]
+		}
+		s.checkpoint(false)
+		select {
+		case <-stop:
+			s.checkpoint(true)
+			return
+		case <-t.C:
+			if !cdcLeader() {
+				s.checkpoint(true)
+				return
+			}
+		case changes, ok := <-w.ch:
+			if !ok {
+				log.Warn("the sink fell behind the writes, resuming from the last write shipped")
+				s.checkpoint(true)
+				return
+			}
+			pending = append(pending, changes)
+			for len(pending) < cdcBatch && len(w.ch) > 0 {
+				pending = append(pending, <-w.ch)
+			}
+		}
+	}
+}
+
+// deliver sends a batch until the sink takes it, or the node no longer
+// leads
+func (s *cdcShipper) deliver(writes [][]watchChange) bool {
+	batch := make([]cdcEvent, 0, len(writes))
+	for _, changes := range writes {
+		if len(changes) > 0 {
+			batch = append(batch, newCDCEvent(changes))
+		}
+	}
+	for backoff := time.Second; ; backoff *= 2 {
+		err := s.sink.send(batch)
+		if err == nil {
+			break
+		}
+		loggers[logServer].Warn("cdc sink fail", zap.String("sink", s.name), zap.Error(err))
+		if backoff > 30*time.Second {
+			backoff = 30 * time.Second
+		}
+		time.Sleep(backoff)
+		if !cdcLeader() {
+			return false
+		}
+	}
+	s.mu.Lock()
+	s.shipped = writes[len(writes)-1][0].Cursor
+	s.mu.Unlock()
+	return true
+}
+
+// checkpoint proposes the cursor shipped, at most every second unless
+// forced
+func (s *cdcShipper) checkpoint(force bool) {
+	s.mu.Lock()
+	shipped, saved := s.shipped, s.saved
+	s.mu.Unlock()
+	if shipped <= saved || shipped <= cdcCheckpoints.get(s.name) || (!force && time.Since(s.checked) < time.Second) {
+		return
+	}
+	s.checked = time.Now()
+	if _, err := localDo("cdccheckpoint", s.name, strconv.FormatInt(shipped, 10)); err != nil {
+		loggers[logServer].Warn("cdc checkpoint fail", zap.String("sink", s.name), zap.Error(err))
+		return
+	}
+	s.mu.Lock()
+	s.saved = shipped
+	s.mu.Unlock()
+}
+
+// CDC
+// Replies the sinks of the node with the checkpoint of the cluster and the
+// last write shipped by the node.
+func cmdCDC(m rafthub.Machine, args []string) (interface{}, error) {
+	if len(args) != 1 {
+		return nil, rafthub.ErrWrongNumArgs
+	}
+	shippers := append([]*cdcShipper(nil), cdcShippers...)
+	sort.Slice(shippers, func(i, j int) bool { return shippers[i].name < shippers[j].name })
+	var buf bytes.Buffer
+	buf.WriteString("# CDC\r\n")
+	fmt.Fprintf(&buf, "sinks:%d\r\n", len(shippers))
+	for i, s := range shippers {
+		s.mu.Lock()
+		shipped := s.shipped
+		s.mu.Unlock()
+		fmt.Fprintf(&buf, "sink%d:name=%s,checkpoint=%d,shipped=%d\r\n", i, s.name, cdcCheckpoints.get(s.name), shipped)
+	}
+	return buf.String(), nil
+}
@@ -0,0 +1,209 @@
+//go:build alltest
+// +build alltest
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCDCEvent(t *testing.T) {
+	writes := cdcWrites([]watchChange{
+		{Cursor: 1, Op: "del", Key: "a", Args: []string{"a", "b"}},
+		{Cursor: 1, Op: "del", Key: "b", Args: []string{"a", "b"}},
+		{Cursor: 2, Op: "flushall", All: true},
+		{Cursor: 3, Op: "set", Key: "k\xff", Args: []string{"k\xff", "v"}},
+	})
+	if len(writes) != 3 || len(writes[0]) != 2 {
+		t.Fatalf("writes %+v", writes)
+	}
+	e := newCDCEvent(writes[0])
+	if e.Schema != cdcSchema || e.Cursor != "1" || e.Op != "del" || strings.Join(e.Keys, ",") != "a,b" || e.Encoding != "" {
+		t.Errorf("event %+v", e)
+	}
+	if e := newCDCEvent(writes[1]); len(e.Keys) != 0 || e.Args == nil {
+		t.Errorf("event of FLUSHALL %+v", e)
+	}
+	e = newCDCEvent(writes[2])
+	if e.Encoding != "base64" || e.Keys[0] != "a/8=" || e.Args[1] != "dg==" {
+		t.Errorf("binary event %+v", e)
+	}
+	if writes[2][0].Args[0] != "k\xff" {
+		t.Error("the event must not change the changes")
+	}
+}
+
+func TestCDCSinkFlags(t *testing.T) {
+	for _, v := range []string{"x", "=file:///tmp/x", "a=file://", "a=nats://host", "a=kafka://host/topic", "a=ftp://host"} {
+		if _, _, err := parseCDCSink(v); err == nil {
+			t.Errorf("%s must be refused", v)
+		}
+	}
+	defer func(sinks stringList) { cdcSinks = sinks }(cdcSinks)
+	cdcSinks = stringList{"a=file:///tmp/a", "b=nats://user:pw@host/changes", "a=https://host/cdc"}
+	if err := checkCDCSinks(); err == nil || !strings.Contains(err.Error(), "repeated") {
+		t.Errorf("repeated sink: %v", err)
+	}
+}
+
+func TestCDCNATSSink(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	msgs := make(chan string, 16)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		conn.Write([]byte("INFO {\"server_id\":\"test\"}\r\n"))
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			fields := strings.Fields(line)
+			switch fields[0] {
+			case "CONNECT":
+				if !strings.Contains(line, `"user":"u"`) {
+					conn.Write([]byte("-ERR 'Authorization Violation'\r\n"))
+					return
+				}
+			case "PING":
+				conn.Write([]byte("PONG\r\n"))
+			case "PUB":
+				n, _ := strconv.Atoi(fields[2])
+				data := make([]byte, n+2)
+				io.ReadFull(r, data)
+				msgs <- fields[1] + " " + string(data[:n])
+			}
+		}
+	}()
+
+	s, err := newCDCSink(mustParseSink(t, "n=nats://u:pw@"+ln.Addr().String()+"/icefiredb.changes"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.close()
+	batch := []cdcEvent{newCDCEvent([]watchChange{{Cursor: 7, Op: "set", Key: "k", Args: []string{"k", "v"}}})}
+	if err := s.send(batch); err != nil {
+		t.Fatal(err)
+	}
+	msg := <-msgs
+	subject, data, _ := strings.Cut(msg, " ")
+	var e cdcEvent
+	if err := json.Unmarshal([]byte(data), &e); err != nil || subject != "icefiredb.changes" || e.Cursor != "7" || e.Keys[0] != "k" {
+		t.Errorf("message %q %v", msg, err)
+	}
+}
+
+func mustParseSink(t *testing.T, v string) *url.URL {
+	t.Helper()
+	_, u, err := parseCDCSink(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u
+}
+
+func TestCDC(t *testing.T) {
+	c := getTestConn()
+	ctx := context.Background()
+	checkpoint := func(cursor string) error {
+		_, err := cmdCDCCHECKPOINT(nil, []string{"cdccheckpoint", "test", cursor})
+		return err
+	}
+	defer checkpoint("0")
+
+	// the checkpoints only move forward and are kept in the storage
+	for _, cursor := range []string{"20", "10"} {
+		if err := checkpoint(cursor); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := cdcCheckpoints.load(); err != nil || cdcCheckpoints.get("test") != 20 {
+		t.Errorf("checkpoint %d %v", cdcCheckpoints.get("test"), err)
+	}
+	if err := checkpoint("x"); err == nil {
+		t.Error("invalid cursor")
+	}
+	// only the leader proposes the checkpoints
+	if err := c.Do(ctx, "CDCCHECKPOINT", "test", "30").Err(); err == nil || !strings.HasPrefix(err.Error(), "NOPERM") {
+		t.Errorf("CDCCHECKPOINT of a client: %v", err)
+	}
+	checkpoint("0")
+
+	// the leader ships the writes to the file from now on, then checkpoints
+	path := filepath.Join(t.TempDir(), "cdc.json")
+	defer func(sinks stringList) { cdcSinks = sinks }(cdcSinks)
+	cdcSinks = stringList{"test=file://" + path}
+	sink, err := newCDCSink(mustParseSink(t, cdcSinks[0]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sink.close()
+	s := &cdcShipper{name: "test", sink: sink}
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		s.run(stop)
+	}()
+	deadline := time.Now().Add(5 * time.Second)
+	for cdcCheckpoints.get("test") == 0 && time.Now().Before(deadline) {
+		c.Set(ctx, "cdc:start", "1", 0)
+		time.Sleep(50 * time.Millisecond)
+	}
+	c.MSet(ctx, "cdc:a", "1", "cdc:b", "2")
+	c.Del(ctx, "cdc:a")
+
+	var lines []cdcEvent
+	for time.Now().Before(deadline) {
+		lines = lines[:0]
+		data, _ := os.ReadFile(path)
+		dec := json.NewDecoder(strings.NewReader(string(data)))
+		for {
+			var e cdcEvent
+			if dec.Decode(&e) != nil {
+				break
+			}
+			if e.Op != "set" {
+				lines = append(lines, e)
+			}
+		}
+		if len(lines) >= 2 {
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	if len(lines) != 2 || lines[0].Op != "mset" || strings.Join(lines[0].Keys, ",") != "cdc:a,cdc:b" ||
+		strings.Join(lines[0].Args, ",") != "cdc:a,1,cdc:b,2" || lines[1].Op != "del" || lines[1].Cursor <= lines[0].Cursor {
+		t.Fatalf("shipped %+v", lines)
+	}
+	close(stop)
+	<-done
+	if cursor, _ := strconv.ParseInt(lines[1].Cursor, 10, 64); cdcCheckpoints.get("test") != cursor {
+		t.Errorf("checkpoint %d, expected %d", cdcCheckpoints.get("test"), cursor)
+	}
+
+	info, err := c.Do(ctx, "CDC").Text()
+	if err != nil || infoFields(info)["sinks"] != "0" {
+		t.Errorf("CDC %q %v", info, err)
+	}
+	c.Del(ctx, "cdc:start", "cdc:b")
+}
@@ -0,0 +1,929 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/tidwall/redcon"
+	rafthub "github.com/tidwall/uhaha"
+	"go.uber.org/zap"
+)
+
+var (
+	// the connections accepted over this number of clients are shed, 0 for
+	// no limit
+	maxClients = 10000
+	// the clients idle for longer are disconnected, 0 disables the timeout
+	clientTimeout time.Duration
+	// the reads of the pipelines run on up to this number of workers shared
+	// by the clients, 0 runs them in order on their connection
+	pipelineWorkers int
+	// pipelineSem holds a token for each pipelined read running
+	pipelineSem chan struct{}
+)
+
+var errMaxClients = errors.New("ERR max number of clients reached")
+
+// clients are the client connections of the RESP port by id
+var clients = &clientRegistry{conns: make(map[uint64]*clientConn)}
+
+func init() {
+	// served in place of the RESP service of rafthub, the services with a
+	// sniffer are matched first
+	conf.Config.AddService("resp", func(io.Reader) bool { return true }, serveClients)
+	conf.AddIntermediateCommand("CLIENT", cmdCLIENT)
+}
+
+// clientConn is a client connection of the RESP port with its buffers and
+// counters
+type clientConn struct {
+	*client
+	conn       redcon.Conn
+	opts       rafthub.SendOptions
+	authorized bool
+	created    time.Time
+	// the listener the connection was accepted on, port or tls-port
+	listener string
+	// the protocol of the replies, 2 or 3 as set by HELLO, written by the
+	// connection only
+	proto int
+	// the address of the caller of a gateway the commands are limited by,
+	// set by GATEWAYCLIENT, written by the connection only
+	gatewayIP string
+	// set once the connection is closed by the server, for the blocked reads
+	closing atomic.Bool
+
+	mu sync.Mutex
+	// the ACL user of the connection, empty for the default user
+	user string
+	// the roles of a user authenticated by a provider, nil for the users of
+	// the ACL file
+	roles []string
+	// the token the connection authenticated with
+	token *scopedToken
+	// when the last reply was written
+	last time.Time
+	// the commands read and not answered yet, and their size
+	backlog, qbuf int
+	// the replies not sent yet
+	obuf int
+	// the largest input and output buffers of the connection
+	qbufPeak, obufPeak int
+	cmds               uint64
+	netIn, netOut      uint64
+	lastCmd            string
+}
+
+// received accounts for a pipeline of commands read from the connection
+func (c *clientConn) received(cmds []redcon.Command) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, cmd := range cmds {
+		c.qbuf += len(cmd.Raw)
+		c.netIn += uint64(len(cmd.Raw))
+	}
+	c.backlog += len(cmds)
+	if c.qbuf > c.qbufPeak {
+		c.qbufPeak = c.qbuf
+	}
+}
+
+// replied accounts for the reply of a command of size bytes, obuf are the
+// replies buffered since the pipeline was read
+func (c *clientConn) replied(cmd string, size, obuf int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.backlog > 0 {
+		c.backlog--
+		c.qbuf -= size
+	}
+	c.obuf = obuf
+	if obuf > c.obufPeak {
+		c.obufPeak = obuf
+	}
+	c.cmds++
+	c.lastCmd = cmd
+}
+
+// flushed accounts for the replies of the pipeline sent to the client
+func (c *clientConn) flushed(now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.netOut += uint64(c.obuf)
+	c.obuf = 0
+	c.backlog, c.qbuf = 0, 0
+	c.last = now
+}
+
+// idle returns for how long the connection has been waiting for a command,
+// 0 while it runs commands
+func (c *clientConn) idle(now time.Time) time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.backlog > 0 {
+		return 0
+	}
+	return now.Sub(c.last)
+}
+
+// info returns the line of the connection in CLIENT LIST
+func (c *clientConn) info(now time.Time) string {
+	idle := c.idle(now)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	cmd := c.lastCmd
+	if cmd == "" {
+		cmd = "NULL"
+	}
+	user := c.user
+	if user == "" {
+		user = defaultUser
+	}
+	return fmt.Sprintf("id=%d addr=%s age=%d idle=%d qbuf=%d qbuf-peak=%d obuf=%d obuf-peak=%d backlog=%d cmds=%d tot-net-in=%d tot-net-out=%d user=%s resp=%d cmd=%s",
+		c.id, c.addr, int64(now.Sub(c.created).Seconds()), int64(idle.Seconds()), c.qbuf, c.qbufPeak,
+		c.obuf, c.obufPeak, c.backlog, c.cmds, c.netIn, c.netOut, user, c.proto, cmd)
+}
+
+// userName returns the user the connection is authenticated as
+func (c *clientConn) userName() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.user == "" {
+		return defaultUser
+	}
+	return c.user
+}
+
+// scopedToken returns the token the connection authenticated with, nil for
+// a user
+func (c *clientConn) scopedToken() *scopedToken {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.token
+}
+
+// isAdmin reports whether the connection is authenticated as a user of the
+// admin role, which the default user and the tokens are not
+func (c *clientConn) isAdmin() bool {
+	c.mu.Lock()
+	name, roles, token := c.user, c.roles, c.token
+	c.mu.Unlock()
+	if name == "" || token != nil {
+		return false
+	}
+	var u *aclUser
+	if roles != nil {
+		u = acl.externalUser(name, roles)
+	} else {
+		u = acl.user(name)
+	}
+	if u == nil {
+		return false
+	}
+	for _, role := range u.roles {
+		if role.name == "admin" {
+			return true
+		}
+	}
+	return false
+}
+
+// auth authenticates the connection with AUTH password, against --auth, or
+// AUTH username password, against the ACL users. A scoped token is taken
+// for the password, whatever the user name.
+func (c *clientConn) auth(s rafthub.Service, args []string) error {
+	var err error
+	var user string
+	var roles []string
+	var token *scopedToken
+	if tk := tokens.Load(); tk != nil && len(args) > 0 && len(args) <= 2 && strings.HasPrefix(args[len(args)-1], tokenPrefix) {
+		token, err = tk.verify(args[len(args)-1], time.Now())
+		if err != nil {
+			loggers[logServer].Debug("token refused", zap.String("client", c.addr), zap.Error(err))
+			err = errWrongPass
+		} else {
+			user = token.userName()
+		}
+		args = nil
+	}
+	switch len(args) {
+	case 0:
+		// only a token leaves no arguments, AUTH needs a password
+		if token == nil && err == nil {
+			return rafthub.ErrWrongNumArgs
+		}
+	case 1:
+		err = s.Auth(args[0])
+	case 2:
+		if args[0] == defaultUser {
+			err = s.Auth(args[1])
+		} else if acl.enabled() {
+			user = args[0]
+			roles, err = acl.authenticate(args[0], args[1], time.Now())
+		} else {
+			err = errWrongPass
+		}
+	default:
+		return rafthub.ErrWrongNumArgs
+	}
+	c.authorized = err == nil
+	if err != nil {
+		// a failed AUTH leaves the connection to the default user
+		user, roles, token = "", nil, nil
+	}
+	c.mu.Lock()
+	c.user, c.roles, c.token = user, roles, token
+	c.mu.Unlock()
+	return err
+}
+
+// allowed returns the error of a command the user of the connection may not
+// run, the default user runs every command
+func (c *clientConn) allowed(args []string) error {
+	c.mu.Lock()
+	name, roles, token := c.user, c.roles, c.token
+	c.mu.Unlock()
+	if name == "" {
+		return nil
+	}
+	switch args[0] {
+	case "ping", "echo", "command":
+		return nil
+	case "acl":
+		if len(args) == 2 && strings.EqualFold(args[1], "whoami") {
+			return nil
+		}
+	}
+	if token != nil {
+		if !time.Now().Before(time.Unix(token.Expires, 0)) {
+			return errTokenExpired
+		}
+		return token.user().allowed(args)
+	}
+	var u *aclUser
+	if roles != nil {
+		u = acl.externalUser(name, roles)
+	} else {
+		u = acl.user(name)
+	}
+	if u == nil {
+		// removed by ACL LOAD
+		return errWrongPass
+	}
+	return u.allowed(args)
+}
+
+// hello runs HELLO [protover [AUTH username password]], it switches the
+// connection to RESP2 or RESP3
+func (c *clientConn) hello(s rafthub.Service, args []string) (interface{}, error) {
+	proto := c.proto
+	if len(args) > 1 {
+		ver, err := strconv.Atoi(args[1])
+		if err != nil {
+			return nil, errors.New("ERR Protocol version is not an integer or out of range")
+		}
+		if ver != 2 && ver != 3 {
+			return nil, errors.New("NOPROTO unsupported protocol version")
+		}
+		proto = ver
+	}
+	for i := 2; i < len(args); i++ {
+		if !strings.EqualFold(args[i], "auth") || i+2 >= len(args) {
+			return nil, fmt.Errorf("ERR Syntax error in HELLO option '%s'", args[i])
+		}
+		if err := c.auth(s, args[i+1:i+3]); err != nil {
+			return nil, err
+		}
+		i += 2
+	}
+	if !c.authorized {
+		if err := s.Auth(""); err != nil {
+			return nil, err
+		}
+		c.authorized = true
+	}
+	c.mu.Lock()
+	c.proto = proto
+	c.mu.Unlock()
+	return resp3Map{
+		"server", "icefiredb",
+		"version", conf.Version,
+		"proto", redcon.SimpleInt(proto),
+		"id", redcon.SimpleInt(c.id),
+		"mode", "cluster",
+	}, nil
+}
+
+// close disconnects the client, its commands in flight are not answered
+func (c *clientConn) close() error {
+	c.closing.Store(true)
+	return c.conn.NetConn().Close()
+}
+
+// clientRegistry holds the client connections, the limits applied to them
+// and the counters of the connections accepted, shed and timed out
+type clientRegistry struct {
+	mu                           sync.RWMutex
+	conns                        map[uint64]*clientConn
+	accepted, rejected, timedOut uint64
+}
+
+// clientStats are the figures of the clients in INFO and the metrics
+type clientStats struct {
+	Connected                int
+	MaxInput, MaxOutput      int
+	Backlog                  int
+	Accepted, Rejected, Idle uint64
+}
+
+// admit counts a new connection, it returns why the connection must be
+// shed, over --maxclients or under memory pressure
+func (r *clientRegistry) admit() error {
+	err := acceptBackpressure()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err == nil && maxClients > 0 && len(r.conns) >= maxClients {
+		err = errMaxClients
+	}
+	if err != nil {
+		r.rejected++
+		return err
+	}
+	r.accepted++
+	return nil
+}
+
+func (r *clientRegistry) add(c *clientConn) {
+	r.mu.Lock()
+	r.conns[c.id] = c
+	r.mu.Unlock()
+}
+
+func (r *clientRegistry) remove(c *clientConn) {
+	r.mu.Lock()
+	delete(r.conns, c.id)
+	r.mu.Unlock()
+}
+
+func (r *clientRegistry) get(id uint64) *clientConn {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.conns[id]
+}
+
+// list returns the connections sorted by id
+func (r *clientRegistry) list() []*clientConn {
+	r.mu.RLock()
+	conns := make([]*clientConn, 0, len(r.conns))
+	for _, c := range r.conns {
+		conns = append(conns, c)
+	}
+	r.mu.RUnlock()
+	sort.Slice(conns, func(i, j int) bool { return conns[i].id < conns[j].id })
+	return conns
+}
+
+func (r *clientRegistry) stats() clientStats {
+	r.mu.RLock()
+	s := clientStats{Connected: len(r.conns), Accepted: r.accepted, Rejected: r.rejected, Idle: r.timedOut}
+	conns := make([]*clientConn, 0, len(r.conns))
+	for _, c := range r.conns {
+		conns = append(conns, c)
+	}
+	r.mu.RUnlock()
+	for _, c := range conns {
+		c.mu.Lock()
+		s.MaxInput = max(s.MaxInput, c.qbufPeak)
+		s.MaxOutput = max(s.MaxOutput, c.obufPeak)
+		s.Backlog += c.backlog
+		c.mu.Unlock()
+	}
+	return s
+}
+
+// limits returns maxclients and the idle timeout
+func (r *clientRegistry) limits() (int, time.Duration) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return maxClients, clientTimeout
+}
+
+// setConfig sets maxclients, or timeout in seconds as in CONFIG SET
+func (r *clientRegistry) setConfig(param, value string) error {
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return fmt.Errorf("ERR invalid %s '%s'", param, value)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	switch param {
+	case "maxclients":
+		maxClients = n
+	case "timeout":
+		clientTimeout = time.Duration(n) * time.Second
+	}
+	return nil
+}
+
+// closeIdle disconnects the clients idle for longer than the timeout
+func (r *clientRegistry) closeIdle(now time.Time) {
+	_, timeout := r.limits()
+	if timeout <= 0 {
+		return
+	}
+	for _, c := range r.list() {
+		idle := c.idle(now)
+		if idle < timeout {
+			continue
+		}
+		loggers[logServer].Debug("idle client disconnected", zap.Uint64("conn_id", c.id),
+			zap.String("client", c.addr), zap.Duration("idle", idle))
+		if c.close() == nil {
+			r.mu.Lock()
+			r.timedOut++
+			r.mu.Unlock()
+		}
+	}
+}
+
+// clientServer serves the RESP clients, as the service of rafthub does, and
+// keeps the accounts of their connections. The connections over
+// --maxclients are shed as soon as they are accepted, with an error, rather
+// than left waiting in the accept queue.
+type clientServer struct {
+	s rafthub.Service
+}
+
+// the RESP service, set once the server is ready
+var respServer *clientServer
+
+// serveClients serves the clients of the port of the server, and of the TLS
+// port and the client port when set
+func serveClients(s rafthub.Service, ln net.Listener) {
+	srv := &clientServer{s: s}
+	respServer = srv
+	go func() {
+		t := time.NewTicker(time.Second)
+		defer t.Stop()
+		for now := range t.C {
+			clients.closeIdle(now)
+			limiter.prune(now)
+		}
+	}()
+	if tlsPort > 0 {
+		go func() {
+			s.Log().Fatal(srv.serveTLS(tlsAddr()))
+		}()
+	}
+	if clientAddr != "" {
+		go func() {
+			cln, err := net.Listen("tcp", clientAddr)
+			if err != nil {
+				s.Log().Fatal(err)
+			}
+			loggers[logServer].Info("serving the clients", zap.String("addr", cln.Addr().String()))
+			s.Log().Fatal(srv.serve(cln, listenerClient))
+		}()
+	}
+	s.Log().Fatal(srv.serve(ln, listenerPort))
+}
+
+func (srv *clientServer) serve(ln net.Listener, listener string) error {
+	accept := func(conn redcon.Conn) bool {
+		return srv.accept(conn, listener)
+	}
+	return redcon.Serve(ln, srv.handle, accept, srv.closed)
+}
+
+func (srv *clientServer) accept(conn redcon.Conn, listener string) bool {
+	switch refuseClient(listener, conn.RemoteAddr()) {
+	case refusedDenied:
+		return false
+	case refusedProtected:
+		nc := conn.NetConn()
+		_ = nc.SetDeadline(time.Now().Add(time.Second))
+		_, _ = nc.Write([]byte("-" + errProtected.Error() + "\r\n"))
+		return false
+	}
+	if err := clients.admit(); err != nil {
+		// a TLS connection shakes hands first
+		nc := conn.NetConn()
+		_ = nc.SetDeadline(time.Now().Add(time.Second))
+		_, _ = nc.Write([]byte("-" + err.Error() + "\r\n"))
+		loggers[logServer].Debug("client shed", zap.String("client", conn.RemoteAddr()), zap.Error(err))
+		return false
+	}
+	context, accept := srv.s.Opened(conn.RemoteAddr())
+	if !accept {
+		return false
+	}
+	now := time.Now()
+	c := &clientConn{conn: conn, created: now, last: now, listener: listener, proto: 2}
+	c.client, _ = context.(*client)
+	if c.client == nil {
+		c.client = &client{id: atomic.AddUint64(&lastConnID, 1), addr: conn.RemoteAddr()}
+	}
+	c.opts.From = c
+	c.opts.Context = context
+	conn.SetContext(c)
+	clients.add(c)
+	return true
+}
+
+func (srv *clientServer) closed(conn redcon.Conn, err error) {
+	c, ok := conn.Context().(*clientConn)
+	if !ok {
+		return
+	}
+	clients.remove(c)
+	srv.s.Closed(c.opts.Context, conn.RemoteAddr())
+}
+
+func (srv *clientServer) handle(conn redcon.Conn, cmd redcon.Command) {
+	c := conn.Context().(*clientConn)
+	cmds := append([]redcon.Command{cmd}, conn.ReadPipeline()...)
+	c.received(cmds)
+	args := make([][]string, len(cmds))
+	sizes := make([]int, len(cmds))
+	for i, cmd := range cmds {
+		args[i] = commandArgs(cmd)
+		sizes[i] = len(cmd.Raw)
+	}
+	execClientCommands(srv.s, c, args, sizes)
+	c.flushed(time.Now())
+}
+
+// pipelinedRead is the reply of a read of a pipeline running on a worker
+type pipelinedRead struct {
+	args    []string
+	done    chan struct{}
+	resp    interface{}
+	elapsed time.Duration
+	err     error
+}
+
+func (r *pipelinedRead) Args() []string {
+	return r.args
+}
+
+func (r *pipelinedRead) Recv() (interface{}, time.Duration, error) {
+	<-r.done
+	return r.resp, r.elapsed, r.err
+}
+
+// sendRead runs a read of a pipeline on a worker, so that a slow read does
+// not hold the next commands, whose replies are still written in order. It
+// returns nil when every worker is busy or the node is under memory
+// pressure, the read then runs in place. A read sent after a write of the
+// connection waits for it, as in place.
+func sendRead(s rafthub.Service, args []string, opts *rafthub.SendOptions, reads *sync.WaitGroup) rafthub.Receiver {
+	if memoryPressure.Load() {
+		return nil
+	}
+	select {
+	case pipelineSem <- struct{}{}:
+	default:
+		return nil
+	}
+	r := &pipelinedRead{args: args, done: make(chan struct{})}
+	reads.Add(1)
+	go func() {
+		defer func() {
+			<-pipelineSem
+			reads.Done()
+			close(r.done)
+		}()
+		r.resp, r.elapsed, r.err = s.Send(args, opts).Recv()
+	}()
+	return r
+}
+
+// commandArgs copies the arguments of a command out of the read buffer of
+// the connection. They share a single string, so the allocations of a
+// command do not grow with its number of arguments.
+func commandArgs(cmd redcon.Command) []string {
+	n := 0
+	for _, arg := range cmd.Args[1:] {
+		n += len(arg)
+	}
+	var b strings.Builder
+	b.Grow(n)
+	for _, arg := range cmd.Args[1:] {
+		b.Write(arg)
+	}
+	all := b.String()
+	args := make([]string, len(cmd.Args))
+	args[0] = strings.ToLower(string(cmd.Args[0]))
+	for i, off := 1, 0; i < len(cmd.Args); i++ {
+		args[i] = all[off : off+len(cmd.Args[i])]
+		off += len(cmd.Args[i])
+	}
+	return args
+}
+
+// execClientCommands runs a pipeline of commands of a client and writes the
+// replies, as the RESP service of rafthub does
+func execClientCommands(s rafthub.Service, c *clientConn, args [][]string, sizes []int) {
+	filter := s.ResponseFilter()
+	write := func(args []string, v interface{}) {
+		if _, ok := v.(error); !ok {
+			v = openReply(args, v)
+		}
+		if filter != nil {
+			v = filter(s.Name(), c.opts.Context, args, v)
+		}
+		if c.proto == 3 {
+			c.conn.WriteRaw(appendRESP3(nil, resp3Reply(args, v)))
+			return
+		}
+		c.conn.WriteAny(v)
+	}
+	recvs := make([]rafthub.Receiver, 0, len(args))
+	var quit bool
+	// the reads of the pipeline running on a worker, a command that is not
+	// a read waits for them so it does not overtake them
+	var reads sync.WaitGroup
+	pipelined := pipelineSem != nil && len(args) > 1
+	// the last command of the pipeline sent to a remote namespace
+	var remoteLast *remoteCall
+	user, ip := limitedKeys(c)
+	for i, args := range args {
+		if err := resolveCommand(c.listener, args); err != nil {
+			recvs = append(recvs, rafthub.Response(args, nil, 0, err))
+			continue
+		}
+		var r rafthub.Receiver
+		switch args[0] {
+		case "quit":
+			r = rafthub.Response(args, redcon.SimpleString("OK"), 0, nil)
+			quit = true
+		case "auth":
+			if err := c.auth(s, args[1:]); err != nil {
+				r = rafthub.Response(args, nil, 0, err)
+			} else {
+				r = rafthub.Response(args, redcon.SimpleString("OK"), 0, nil)
+			}
+		case "hello":
+			v, err := c.hello(s, args)
+			r = rafthub.Response(args, v, 0, err)
+		case "gatewayclient":
+			if err := c.gatewayClient(args); err != nil {
+				r = rafthub.Response(args, nil, 0, err)
+			} else {
+				r = rafthub.Response(args, redcon.SimpleString("OK"), 0, nil)
+				user, ip = limitedKeys(c)
+			}
+		default:
+			if !c.authorized {
+				if err := s.Auth(""); err != nil {
+					r = rafthub.Response(args, nil, 0, err)
+				} else {
+					c.authorized = true
+				}
+			}
+			if !c.authorized {
+				break
+			}
+			if err := c.allowed(args); err != nil {
+				r = rafthub.Response(args, nil, 0, err)
+				break
+			}
+			if err := limiter.admit(user, ip, sizes[i], time.Now()); err != nil {
+				r = rafthub.Response(args, nil, 0, err)
+				break
+			}
+			if err := checkJoin(args, time.Now()); err != nil {
+				r = rafthub.Response(args, nil, 0, err)
+				break
+			}
+			if err := checkInternal(args); err != nil {
+				r = rafthub.Response(args, nil, 0, err)
+				break
+			}
+			if err := checkNodeMode(args); err != nil {
+				r = rafthub.Response(args, nil, 0, err)
+				break
+			}
+			remote, err := remoteOf(args)
+			if err != nil {
+				r = rafthub.Response(args, nil, 0, err)
+				break
+			}
+			if remote != nil {
+				remoteLast = remote.send(args, remoteLast)
+				r = remoteLast
+				break
+			}
+			if err := sealArgs(args); err != nil {
+				r = rafthub.Response(args, nil, 0, err)
+				break
+			}
+			switch args[0] {
+			case "ping":
+				if len(args) == 1 {
+					r = rafthub.Response(args, redcon.SimpleString("PONG"), 0, nil)
+				} else if len(args) == 2 {
+					r = rafthub.Response(args, args[1], 0, nil)
+				} else {
+					r = rafthub.Response(args, nil, 0, rafthub.ErrWrongNumArgs)
+				}
+			case "command":
+				v, err := cmdCOMMAND(c.listener, args)
+				r = rafthub.Response(args, v, 0, err)
+			case "echo":
+				if len(args) != 2 {
+					r = rafthub.Response(args, nil, 0, rafthub.ErrWrongNumArgs)
+				} else {
+					r = rafthub.Response(args, args[1], 0, nil)
+				}
+			case "shutdown":
+				s.Log().Error("Shutting down")
+				os.Exit(0)
+			default:
+				if args[0] == "xread" || args[0] == "xreadgroup" {
+					if sr, err := parseStreamRead(args); err == nil && sr.blocking {
+						reads.Wait()
+						r = blockStream(s, c, args, sr.block)
+						break
+					}
+				}
+				if pipelined && readCommands[args[0]] != nil {
+					if r = sendRead(s, args, &c.opts, &reads); r != nil {
+						break
+					}
+				}
+				reads.Wait()
+				r = s.Send(args, &c.opts)
+			}
+		}
+		recvs = append(recvs, r)
+		if quit {
+			break
+		}
+	}
+	var filtered [][]string
+	for i, r := range recvs {
+		obuf := len(redcon.BaseWriter(c.conn).Buffer())
+		resp, elapsed, err := r.Recv()
+		if err != nil {
+			if err == rafthub.ErrUnknownCommand {
+				err = fmt.Errorf("%s '%s'", err, args[i][0])
+			}
+			write(r.Args(), err)
+		} else {
+			switch v := resp.(type) {
+			case rafthub.FilterArgs:
+				filtered = append(filtered, v)
+			case rafthub.Hijack:
+				go v(s, &hijackedConn{dconn: c.conn.Detach(), proto: c.proto})
+			default:
+				write(r.Args(), v)
+			}
+		}
+		s.Monitor().Send(rafthub.Message{
+			Addr:    c.addr,
+			Args:    args[i],
+			Resp:    resp,
+			Err:     err,
+			Elapsed: elapsed,
+		})
+		size := len(redcon.BaseWriter(c.conn).Buffer())
+		limiter.sent(user, ip, size-obuf, time.Now())
+		c.replied(args[i][0], sizes[i], size)
+	}
+	if quit {
+		c.conn.Close()
+		return
+	}
+	if len(filtered) > 0 {
+		execClientCommands(s, c, filtered, make([]int, len(filtered)))
+	}
+}
+
+// hijackedConn is a client connection taken over by a command, in the
+// protocol of the connection
+type hijackedConn struct {
+	dconn redcon.DetachedConn
+	cmds  []redcon.Command
+	proto int
+}
+
+func (c *hijackedConn) RemoteAddr() string { return c.dconn.RemoteAddr() }
+
+func (c *hijackedConn) WriteAny(v interface{}) {
+	if c.proto == 3 {
+		c.dconn.WriteRaw(appendRESP3(nil, v))
+		return
+	}
+	c.dconn.WriteAny(v)
+}
+
+func (c *hijackedConn) WriteRaw(data []byte) { c.dconn.WriteRaw(data) }
+func (c *hijackedConn) Flush() error         { return c.dconn.Flush() }
+func (c *hijackedConn) Close() error         { return c.dconn.Close() }
+
+func (c *hijackedConn) ReadCommands(iter func(args []string) bool) error {
+	if len(c.cmds) == 0 {
+		cmd, err := c.dconn.ReadCommand()
+		if err != nil {
+			return err
+		}
+		if !iter(commandArgs(cmd)) {
+			return nil
+		}
+		c.cmds = c.dconn.ReadPipeline()
+	}
+	for len(c.cmds) > 0 {
+		cmd := c.cmds[0]
+		c.cmds = c.cmds[1:]
+		if !iter(commandArgs(cmd)) {
+			return nil
+		}
+	}
+	return nil
+}
+
+func (c *hijackedConn) ReadCommand() (args []string, err error) {
+	err = c.ReadCommands(func(cmdArgs []string) bool {
+		args = cmdArgs
+		return false
+	})
+	return args, err
+}
+
+// CLIENT LIST
+// CLIENT INFO
+// CLIENT ID
+// CLIENT KILL ID id | CLIENT KILL addr
+// Lists the client connections of the node with their buffers, backlog and
+// idle time, or disconnects one.
+func cmdCLIENT(m rafthub.Machine, args []string) (interface{}, error) {
+	if len(args) < 2 {
+		return nil, rafthub.ErrWrongNumArgs
+	}
+	self, _ := m.Context().(*client)
+	now := time.Now()
+	switch strings.ToLower(args[1]) {
+	case "list":
+		if len(args) != 2 {
+			return nil, rafthub.ErrWrongNumArgs
+		}
+		var b strings.Builder
+		for _, c := range clients.list() {
+			b.WriteString(c.info(now))
+			b.WriteByte('\n')
+		}
+		return b.String(), nil
+	case "info":
+		if len(args) != 2 {
+			return nil, rafthub.ErrWrongNumArgs
+		}
+		if self == nil || clients.get(self.id) == nil {
+			return nil, errors.New("ERR not a client connection")
+		}
+		return clients.get(self.id).info(now) + "\n", nil
+	case "id":
+		if len(args) != 2 {
+			return nil, rafthub.ErrWrongNumArgs
+		}
+		if self == nil {
+			return nil, errors.New("ERR not a client connection")
+		}
+		return redcon.SimpleInt(self.id), nil
+	case "kill":
+		var target *clientConn
+		switch {
+		case len(args) == 3:
+			for _, c := range clients.list() {
+				if c.addr == args[2] {
+					target = c
+				}
+			}
+		case len(args) == 4 && strings.ToLower(args[2]) == "id":
+			id, err := strconv.ParseUint(args[3], 10, 64)
+			if err != nil {
+				return nil, errors.New("ERR client-id should be greater than 0")
+			}
+			target = clients.get(id)
+		default:
+			return nil, rafthub.ErrWrongNumArgs
+		}
+		if target == nil {
+			return nil, errors.New("ERR No such client")
+		}
+		if err := target.close(); err != nil {
+			return nil, fmt.Errorf("ERR %v", err)
+		}
+		return redcon.SimpleString("OK"), nil
+	}
+	return nil, fmt.Errorf("ERR unknown CLIENT subcommand '%s'", args[1])
+}
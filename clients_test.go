@@ -0,0 +1,255 @@
+//go:build alltest
+// +build alltest
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/tidwall/redcon"
+	rafthub "github.com/tidwall/uhaha"
+)
+
+// connDo runs a command on a connection of the pool
+func connDo(ctx context.Context, conn *redis.Conn, args ...interface{}) *redis.Cmd {
+	cmd := redis.NewCmd(ctx, args...)
+	_ = conn.Process(ctx, cmd)
+	return cmd
+}
+
+// clientField returns the value of a field of a CLIENT LIST line
+func clientField(line, name string) string {
+	for _, f := range strings.Fields(line) {
+		if v, ok := strings.CutPrefix(f, name+"="); ok {
+			return v
+		}
+	}
+	return ""
+}
+
+func TestClientList(t *testing.T) {
+	ctx := context.Background()
+	conn := getTestConn().Conn()
+	defer conn.Close()
+
+	id, err := connDo(ctx, conn, "CLIENT", "ID").Int64()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pipe := conn.Pipeline()
+	for i := 0; i < 10; i++ {
+		pipe.Set(ctx, "client:"+strconv.Itoa(i), strings.Repeat("v", 100), 0)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		t.Fatal(err)
+	}
+	line, err := connDo(ctx, conn, "CLIENT", "INFO").Text()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if clientField(line, "id") != strconv.FormatInt(id, 10) {
+		t.Errorf("CLIENT INFO of another connection: %s", line)
+	}
+	if n, _ := strconv.Atoi(clientField(line, "qbuf-peak")); n < 1000 {
+		t.Errorf("qbuf-peak after a pipeline of 10 SET: %s", line)
+	}
+	if n, _ := strconv.Atoi(clientField(line, "cmds")); n < 12 {
+		t.Errorf("cmds: %s", line)
+	}
+	// CLIENT INFO itself is in the backlog
+	if clientField(line, "cmd") != "set" || clientField(line, "backlog") != "1" {
+		t.Errorf("last command: %s", line)
+	}
+
+	list, err := connDo(ctx, conn, "CLIENT", "LIST").Text()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(list, "id="+strconv.FormatInt(id, 10)+" ") {
+		t.Errorf("connection %d not listed: %s", id, list)
+	}
+	info, err := connDo(ctx, conn, "INFO", "clients").Text()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fields := infoFields(info)
+	if n, _ := strconv.Atoi(fields["connected_clients"]); n < 1 {
+		t.Errorf("connected_clients: %v", fields)
+	}
+	if n, _ := strconv.Atoi(fields["client_max_input_buffer"]); n < 1000 {
+		t.Errorf("client_max_input_buffer: %v", fields)
+	}
+}
+
+func TestClientLimits(t *testing.T) {
+	c := getTestConn()
+	ctx := context.Background()
+	stats := func() map[string]string {
+		info, err := c.Do(ctx, "INFO", "clients").Text()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return infoFields(info)
+	}
+
+	// the connections over maxclients are shed with an error. The server
+	// tells the clients from the raft peers by their first bytes.
+	before := stats()
+	connected, _ := strconv.Atoi(before["connected_clients"])
+	if err := c.Do(ctx, "CONFIG", "SET", "maxclients", strconv.Itoa(connected)).Err(); err != nil {
+		t.Fatal(err)
+	}
+	nc, err := net.Dial("tcp", "127.0.0.1:11001")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = nc.SetDeadline(time.Now().Add(5 * time.Second))
+	_, _ = nc.Write([]byte("PING\r\n"))
+	line, _ := bufio.NewReader(nc).ReadString('\n')
+	nc.Close()
+	if err := c.Do(ctx, "CONFIG", "SET", "maxclients", "10000").Err(); err != nil {
+		t.Fatal(err)
+	}
+	if line != "-ERR max number of clients reached\r\n" {
+		t.Errorf("connection over maxclients: %q", line)
+	}
+	after := stats()
+	if after["rejected_connections"] == before["rejected_connections"] {
+		t.Errorf("rejected_connections: %s then %s", before["rejected_connections"], after["rejected_connections"])
+	}
+
+	// the idle clients are disconnected
+	if err := c.Do(ctx, "CONFIG", "SET", "timeout", "1").Err(); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Do(ctx, "CONFIG", "SET", "timeout", "0")
+	nc, err = net.Dial("tcp", "127.0.0.1:11001")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nc.Close()
+	_ = nc.SetDeadline(time.Now().Add(5 * time.Second))
+	rd := bufio.NewReader(nc)
+	if _, err := nc.Write([]byte("PING\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	if line, err := rd.ReadString('\n'); line != "+PONG\r\n" {
+		t.Fatalf("PING: %q %v", line, err)
+	}
+	start := time.Now()
+	if _, err := rd.ReadByte(); err == nil || time.Since(start) >= 5*time.Second {
+		t.Errorf("idle connection not closed: %v after %s", err, time.Since(start))
+	}
+	if err := c.Do(ctx, "CONFIG", "SET", "timeout", "0").Err(); err != nil {
+		t.Fatal(err)
+	}
+	if stats()["idle_disconnections"] == "0" {
+		t.Errorf("idle_disconnections not counted")
+	}
+	// the pool of the test client may have lost idle connections too
+	c.Ping(ctx)
+
+	v, err := c.Do(ctx, "CONFIG", "GET", "maxclients").StringSlice()
+	if err != nil || len(v) != 2 || v[1] != "10000" {
+		t.Errorf("CONFIG GET maxclients: %v %v", v, err)
+	}
+}
+
+func TestClientKill(t *testing.T) {
+	ctx := context.Background()
+	c := getTestConn()
+	conn := c.Conn()
+	defer conn.Close()
+	id, err := connDo(ctx, conn, "CLIENT", "ID").Int64()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Do(ctx, "CLIENT", "KILL", "ID", strconv.FormatInt(id, 10)).Err(); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Do(ctx, "CLIENT", "KILL", "ID", "999999").Err(); err == nil || err.Error() != "ERR No such client" {
+		t.Errorf("kill of a missing client: %v", err)
+	}
+	if err := conn.Ping(ctx).Err(); err == nil {
+		t.Errorf("killed connection still served")
+	}
+}
+
+func TestCommandArgs(t *testing.T) {
+	cmd := redcon.Command{Args: [][]byte{[]byte("MSET"), []byte("a"), []byte(""), []byte("key"), []byte("value")}}
+	if args := commandArgs(cmd); strings.Join(args, ",") != "mset,a,,key,value" {
+		t.Fatalf("commandArgs %q", args)
+	}
+	for i := 0; i < 100; i++ {
+		cmd.Args = append(cmd.Args, []byte("k"+strconv.Itoa(i)))
+	}
+	if n := testing.AllocsPerRun(100, func() { commandArgs(cmd) }); n > 4 {
+		t.Errorf("commandArgs of %d arguments: %v allocations", len(cmd.Args), n)
+	}
+}
+
+func TestPipelineWorkers(t *testing.T) {
+	c := getTestConn()
+	ctx := context.Background()
+	pipelineSem = make(chan struct{}, 2)
+	defer func() { pipelineSem = nil }()
+
+	pipe := c.Pipeline()
+	var gets []*redis.StringCmd
+	for i := 0; i < 10; i++ {
+		key := "pipeline:" + strconv.Itoa(i%3)
+		pipe.Set(ctx, key, strconv.Itoa(i), 0)
+		gets = append(gets, pipe.Get(ctx, key))
+		gets = append(gets, pipe.Get(ctx, key))
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		t.Fatal(err)
+	}
+	for i, get := range gets {
+		if v, err := get.Result(); err != nil || v != strconv.Itoa(i/2) {
+			t.Errorf("GET %d of the pipeline: %q %v", i, v, err)
+		}
+	}
+	if len(pipelineSem) != 0 {
+		t.Errorf("%d workers still busy", len(pipelineSem))
+	}
+	c.Del(ctx, "pipeline:0", "pipeline:1", "pipeline:2")
+}
+
+// passwordService is a service whose clients authorize with a password
+type passwordService struct {
+	rafthub.Service
+	password string
+}
+
+func (s passwordService) Auth(auth string) error {
+	if auth != s.password {
+		return errWrongPass
+	}
+	return nil
+}
+
+func TestBareAuth(t *testing.T) {
+	s := passwordService{password: "secret"}
+	c := &clientConn{client: &client{}}
+	if err := c.auth(s, nil); err != rafthub.ErrWrongNumArgs || c.authorized {
+		t.Errorf("AUTH without a password: %v authorized %v", err, c.authorized)
+	}
+	if err := c.auth(s, []string{"wrong"}); err == nil || c.authorized {
+		t.Errorf("AUTH wrong: %v authorized %v", err, c.authorized)
+	}
+	if err := c.auth(s, []string{"secret"}); err != nil || !c.authorized {
+		t.Errorf("AUTH secret: %v authorized %v", err, c.authorized)
+	}
+	// a bare AUTH does not keep the authorization of a former one either
+	if err := c.auth(s, []string{}); err != rafthub.ErrWrongNumArgs {
+		t.Errorf("AUTH without a password after AUTH: %v", err)
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/url"
+	"sync/atomic"
+
+	"go.uber.org/zap"
+)
+
+var (
+	// the CA bundle of the node certificates, it turns on mutual TLS between
+	// the nodes with the certificate of --tls-cert
+	clusterCACert string
+	// the SPIFFE trust domain of the nodes, their certificates then carry a
+	// spiffe://<domain>/... URI
+	clusterTrustDomain string
+)
+
+var (
+	// the certificates of the node, set once the cluster is secured
+	clusterCerts atomic.Pointer[certReloader]
+	// the handshakes of peers refused
+	clusterRejected int64
+)
+
+// secureCluster turns the TLS configuration of the server into mutual TLS:
+// the raft transport, the joins and the calls to the leader all use it, as
+// the server and as the client. The certificate and the CA are reloaded as
+// their files change, the peers are verified against the CA in use and, with
+// a trust domain, against their SPIFFE ID rather than their host name.
+func secureCluster(tlscfg *tls.Config, certFile, keyFile string) (*certReloader, error) {
+	if tlscfg == nil {
+		return nil, errors.New("the cluster CA needs --tls-cert and --tls-key")
+	}
+	certs, err := newCertReloader(certFile, keyFile, clusterCACert, "yes")
+	if err != nil {
+		return nil, err
+	}
+	if err := verifyPeer(certs.roots(), clusterTrustDomain, certs.certificate().Certificate); err != nil {
+		return nil, fmt.Errorf("certificate of the node: %w", err)
+	}
+	tlscfg.MinVersion = tls.VersionTLS12
+	tlscfg.Certificates = nil
+	tlscfg.GetCertificate = func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+		return certs.certificate(), nil
+	}
+	tlscfg.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+		return certs.certificate(), nil
+	}
+	tlscfg.ClientAuth = tls.RequireAnyClientCert
+	// the chain is verified below, with the CA in use at each handshake
+	tlscfg.InsecureSkipVerify = true
+	tlscfg.VerifyPeerCertificate = func(raw [][]byte, _ [][]*x509.Certificate) error {
+		if err := verifyPeer(certs.roots(), clusterTrustDomain, raw); err != nil {
+			atomic.AddInt64(&clusterRejected, 1)
+			loggers[logServer].Warn("cluster peer refused", zap.Error(err))
+			return err
+		}
+		return nil
+	}
+	clusterCerts.Store(certs)
+	return certs, nil
+}
+
+// verifyPeer verifies the certificate chain of a peer against roots and, with
+// a trust domain, its SPIFFE ID
+func verifyPeer(roots *x509.CertPool, trustDomain string, raw [][]byte) error {
+	if len(raw) == 0 {
+		return errors.New("no certificate")
+	}
+	chain := make([]*x509.Certificate, len(raw))
+	for i, der := range raw {
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			return err
+		}
+		chain[i] = cert
+	}
+	opts := x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: x509.NewCertPool(),
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	}
+	for _, cert := range chain[1:] {
+		opts.Intermediates.AddCert(cert)
+	}
+	if _, err := chain[0].Verify(opts); err != nil {
+		return err
+	}
+	if trustDomain == "" {
+		return nil
+	}
+	id, err := spiffeID(chain[0])
+	if err != nil {
+		return err
+	}
+	if id.Host != trustDomain {
+		return fmt.Errorf("%s is not in the trust domain %s", id, trustDomain)
+	}
+	return nil
+}
+
+// spiffeID returns the SPIFFE ID of a certificate, its only URI SAN
+func spiffeID(cert *x509.Certificate) (*url.URL, error) {
+	if len(cert.URIs) != 1 || cert.URIs[0].Scheme != "spiffe" {
+		return nil, fmt.Errorf("certificate of %s has no SPIFFE ID", cert.Subject.CommonName)
+	}
+	return cert.URIs[0], nil
+}
@@ -0,0 +1,130 @@
+//go:build alltest
+// +build alltest
+
+package main
+
+import (
+	"crypto/tls"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestClusterTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca, caKey := issueCert(t, dir, "ca", 1, nil, nil)
+	issueCert(t, dir, "node1", 2, ca, caKey, "spiffe://icefiredb.test/node/1")
+	issueCert(t, dir, "node2", 3, ca, caKey, "spiffe://icefiredb.test/node/2")
+	issueCert(t, dir, "outsider", 4, ca, caKey, "spiffe://other.test/node/3")
+	issueCert(t, dir, "anonymous", 5, ca, caKey)
+	rogue, rogueKey := issueCert(t, dir, "rogue-ca", 6, nil, nil)
+	issueCert(t, dir, "rogue", 7, rogue, rogueKey, "spiffe://icefiredb.test/node/4")
+
+	clusterCACert = filepath.Join(dir, "ca.crt")
+	clusterTrustDomain = "icefiredb.test"
+	defer func() {
+		clusterCACert, clusterTrustDomain = "", ""
+		clusterCerts.Store(nil)
+	}()
+	// the configuration uhaha builds from --tls-cert and --tls-key
+	nodeConfig := func(name string) *tls.Config {
+		cert, err := tls.LoadX509KeyPair(filepath.Join(dir, name+".crt"), filepath.Join(dir, name+".key"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return &tls.Config{Certificates: []tls.Certificate{cert}}
+	}
+	node1 := nodeConfig("node1")
+	certs, err := secureCluster(node1, filepath.Join(dir, "node1.crt"), filepath.Join(dir, "node1.key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := secureCluster(nodeConfig("outsider"), filepath.Join(dir, "outsider.crt"), filepath.Join(dir, "outsider.key")); err == nil {
+		t.Errorf("node outside of the trust domain secured")
+	}
+	node2 := nodeConfig("node2")
+	if _, err := secureCluster(node2, filepath.Join(dir, "node2.crt"), filepath.Join(dir, "node2.key")); err != nil {
+		t.Fatal(err)
+	}
+
+	serve := func(cfg *tls.Config) string {
+		ln, err := tls.Listen("tcp", "127.0.0.1:0", cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { ln.Close() })
+		go func() {
+			for {
+				c, err := ln.Accept()
+				if err != nil {
+					return
+				}
+				go func() {
+					defer c.Close()
+					_ = c.SetDeadline(time.Now().Add(5 * time.Second))
+					_, _ = c.Write([]byte("ok"))
+				}()
+			}
+		}()
+		return ln.Addr().String()
+	}
+	// dial reports whether a peer with cfg is served, the client certificate
+	// is only verified once the client handshake is done with TLS 1.3
+	dial := func(addr string, cfg *tls.Config) (*tls.Conn, bool) {
+		c, err := tls.DialWithDialer(&net.Dialer{Timeout: 5 * time.Second}, "tcp", addr, cfg)
+		if err != nil {
+			return nil, false
+		}
+		t.Cleanup(func() { c.Close() })
+		_ = c.SetDeadline(time.Now().Add(5 * time.Second))
+		buf := make([]byte, 2)
+		if _, err := io.ReadFull(c, buf); err != nil || string(buf) != "ok" {
+			return c, false
+		}
+		return c, true
+	}
+	peer := func(name string) *tls.Config {
+		cfg := nodeConfig(name)
+		cfg.InsecureSkipVerify = true
+		return cfg
+	}
+
+	addr := serve(node1)
+	if _, ok := dial(addr, node2); !ok {
+		t.Errorf("node2 refused")
+	}
+	for _, name := range []string{"outsider", "anonymous", "rogue"} {
+		if _, ok := dial(addr, peer(name)); ok {
+			t.Errorf("%s served", name)
+		}
+	}
+	if _, ok := dial(addr, &tls.Config{InsecureSkipVerify: true}); ok {
+		t.Errorf("peer without certificate served")
+	}
+	// a node does not join a server of another CA
+	if _, ok := dial(serve(nodeConfig("rogue")), node2); ok {
+		t.Errorf("node2 joined a rogue server")
+	}
+
+	// the certificate of the node rotates without restart
+	issueCert(t, dir, "node1", 8, ca, caKey, "spiffe://icefiredb.test/node/1")
+	future := time.Now().Add(time.Minute)
+	for _, name := range []string{"node1.crt", "node1.key"} {
+		if err := os.Chtimes(filepath.Join(dir, name), future, future); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if changed, err := certs.reload(); !changed || err != nil {
+		t.Fatalf("reload: %v %v", changed, err)
+	}
+	c, ok := dial(addr, node2)
+	if !ok {
+		t.Fatalf("node2 refused after the rotation")
+	}
+	if serial := c.ConnectionState().PeerCertificates[0].SerialNumber.Int64(); serial != 8 {
+		t.Errorf("certificate served after the rotation: serial %d", serial)
+	}
+}
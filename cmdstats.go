@@ -0,0 +1,232 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"math/bits"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// the namespace of a key is its prefix up to the separator, e.g. tenant1 for
+// tenant1:user:42
+var namespaceSeparator = ":"
+
+const (
+	// the namespace of the keys without separator and of the commands
+	// without key
+	defaultNamespace = "default"
+	// the namespaces over maxNamespaces are counted in overflowNamespace
+	overflowNamespace = "other"
+	maxNamespaces     = 1024
+	// the latency buckets are powers of 2 of microseconds, up to 2^31us
+	latencyBuckets = 32
+)
+
+// the commands taking no key, their first argument is not a key
+var keylessCommands = map[string]bool{
+	"info": true, "flushall": true, "flushdb": true,
+	"xscan": true, "xhscan": true, "xsscan": true, "xzscan": true, "scanat": true,
+	"script": true,
+}
+
+// cmdStat counts the calls of a command in a namespace
+type cmdStat struct {
+	calls  uint64
+	failed uint64
+	usec   uint64
+	// the calls by latency, bucket i holds the calls under 2^i us
+	latency [latencyBuckets]uint64
+}
+
+type cmdStatKey struct {
+	ns  string
+	cmd string
+}
+
+// cmdStats are the stats of every command by namespace, served by INFO
+// commandstats and INFO latencystats
+var cmdStats = &commandStats{stats: make(map[cmdStatKey]*cmdStat), namespaces: make(map[string]bool)}
+
+type commandStats struct {
+	mu         sync.RWMutex
+	stats      map[cmdStatKey]*cmdStat
+	namespaces map[string]bool
+}
+
+// commandKey returns the first key of a command
+func commandKey(cmd string, args []string) (string, bool) {
+	if keylessCommands[cmd] {
+		return "", false
+	}
+	if cmd == "bitop" {
+		// BITOP operation destkey key...
+		if len(args) > 2 {
+			return args[2], true
+		}
+		return "", false
+	}
+	if len(args) > 1 {
+		return args[1], true
+	}
+	return "", false
+}
+
+// namespaceOf returns the namespace of the first key of a command
+func namespaceOf(cmd string, args []string) string {
+	key, ok := commandKey(cmd, args)
+	if !ok {
+		return defaultNamespace
+	}
+	return keyNamespace(key)
+}
+
+// keyNamespace returns the namespace of a key
+func keyNamespace(key string) string {
+	if namespaceSeparator == "" {
+		return defaultNamespace
+	}
+	i := strings.Index(key, namespaceSeparator)
+	if i <= 0 {
+		return defaultNamespace
+	}
+	return key[:i]
+}
+
+// stat returns the stats of a command in a namespace, created on first use
+func (s *commandStats) stat(ns, cmd string) *cmdStat {
+	s.mu.RLock()
+	st, ok := s.stats[cmdStatKey{ns, cmd}]
+	if !ok && !s.namespaces[ns] && len(s.namespaces) >= maxNamespaces {
+		st, ok = s.stats[cmdStatKey{overflowNamespace, cmd}]
+	}
+	s.mu.RUnlock()
+	if ok {
+		return st
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.namespaces[ns] {
+		if len(s.namespaces) >= maxNamespaces {
+			ns = overflowNamespace
+		}
+		s.namespaces[ns] = true
+	}
+	k := cmdStatKey{ns, cmd}
+	if st, ok = s.stats[k]; !ok {
+		st = new(cmdStat)
+		s.stats[k] = st
+	}
+	return st
+}
+
+// Observe counts a call of a command
+func (s *commandStats) Observe(cmd string, args []string, d time.Duration, failed bool) {
+	st := s.stat(namespaceOf(cmd, args), cmd)
+	usec := uint64(d.Microseconds())
+	atomic.AddUint64(&st.calls, 1)
+	atomic.AddUint64(&st.usec, usec)
+	if failed {
+		atomic.AddUint64(&st.failed, 1)
+	}
+	b := bits.Len64(usec)
+	if b >= latencyBuckets {
+		b = latencyBuckets - 1
+	}
+	atomic.AddUint64(&st.latency[b], 1)
+}
+
+// Reset forgets the stats, for CONFIG RESETSTAT
+func (s *commandStats) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stats = make(map[cmdStatKey]*cmdStat)
+	s.namespaces = make(map[string]bool)
+}
+
+// snapshot returns a copy of the stats of each command, of every namespace
+// under the empty namespace, sorted by command then namespace
+func (s *commandStats) snapshot() ([]cmdStatKey, map[cmdStatKey]*cmdStat) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	out := make(map[cmdStatKey]*cmdStat, len(s.stats))
+	for k, st := range s.stats {
+		c := new(cmdStat)
+		c.calls = atomic.LoadUint64(&st.calls)
+		c.failed = atomic.LoadUint64(&st.failed)
+		c.usec = atomic.LoadUint64(&st.usec)
+		for i := range st.latency {
+			c.latency[i] = atomic.LoadUint64(&st.latency[i])
+		}
+		out[k] = c
+		total, ok := out[cmdStatKey{cmd: k.cmd}]
+		if !ok {
+			total = new(cmdStat)
+			out[cmdStatKey{cmd: k.cmd}] = total
+		}
+		total.calls += c.calls
+		total.failed += c.failed
+		total.usec += c.usec
+		for i := range c.latency {
+			total.latency[i] += c.latency[i]
+		}
+	}
+	keys := make([]cmdStatKey, 0, len(out))
+	for k := range out {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].cmd != keys[j].cmd {
+			return keys[i].cmd < keys[j].cmd
+		}
+		return keys[i].ns < keys[j].ns
+	})
+	return keys, out
+}
+
+// statName returns the name of the stats of a command in INFO, cmd for every
+// namespace and cmd@ns for one
+func statName(k cmdStatKey) string {
+	if k.ns == "" {
+		return k.cmd
+	}
+	// the INFO fields are split on ':' and ','
+	return k.cmd + "@" + strings.NewReplacer(":", "_", ",", "_", "\r", "_", "\n", "_").Replace(k.ns)
+}
+
+// percentile returns the upper bound in microseconds of the bucket holding
+// the p-th percentile of the calls
+func (st *cmdStat) percentile(p float64) uint64 {
+	rank := uint64(math.Ceil(p / 100 * float64(st.calls)))
+	var n uint64
+	for i, c := range st.latency {
+		if n += c; n >= rank {
+			return 1 << i
+		}
+	}
+	return 1 << (latencyBuckets - 1)
+}
+
+func (i *info) dumpCommandStats(buf *bytes.Buffer) {
+	buf.WriteString("# Commandstats\r\n")
+	keys, stats := cmdStats.snapshot()
+	for _, k := range keys {
+		st := stats[k]
+		buf.WriteString(fmt.Sprintf("cmdstat_%s:calls=%d,usec=%d,usec_per_call=%.2f,failed_calls=%d\r\n",
+			statName(k), st.calls, st.usec, float64(st.usec)/float64(st.calls), st.failed))
+	}
+}
+
+func (i *info) dumpLatencyStats(buf *bytes.Buffer) {
+	buf.WriteString("# Latencystats\r\n")
+	keys, stats := cmdStats.snapshot()
+	for _, k := range keys {
+		st := stats[k]
+		buf.WriteString(fmt.Sprintf("latency_percentiles_usec_%s:p50=%d,p99=%d,p99.9=%d\r\n",
+			statName(k), st.percentile(50), st.percentile(99), st.percentile(99.9)))
+	}
+}
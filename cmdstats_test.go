@@ -0,0 +1,117 @@
+//go:build alltest
+// +build alltest
+
+package main
+
+import (
+	"context"
+	"math/bits"
+	"strings"
+	"testing"
+)
+
+// infoFields returns the fields of a section of INFO by name
+func infoFields(info string) map[string]string {
+	fields := make(map[string]string)
+	for _, line := range strings.Split(info, "\r\n") {
+		if k, v, ok := strings.Cut(line, ":"); ok && !strings.HasPrefix(line, "#") {
+			fields[k] = v
+		}
+	}
+	return fields
+}
+
+func TestCommandStats(t *testing.T) {
+	c := getTestConn()
+	ctx := context.Background()
+
+	if err := c.Do(ctx, "CONFIG", "RESETSTAT").Err(); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"tenant1:a", "tenant1:b", "tenant2:a", "nonamespace"} {
+		if err := c.Set(ctx, key, "v", 0).Err(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c.Get(ctx, "tenant2:missing")
+	if err := c.Do(ctx, "GETBIT", "tenant1:a", "offset").Err(); err == nil {
+		t.Fatal("GETBIT with an invalid offset must fail")
+	}
+
+	info, err := c.Do(ctx, "INFO", "commandstats").Text()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(info, "# Commandstats\r\n") {
+		t.Fatalf("INFO commandstats %q", info)
+	}
+	stats := infoFields(info)
+	for name, calls := range map[string]string{
+		"cmdstat_set":            "calls=4,",
+		"cmdstat_set@tenant1":    "calls=2,",
+		"cmdstat_set@tenant2":    "calls=1,",
+		"cmdstat_set@default":    "calls=1,",
+		"cmdstat_get@tenant2":    "calls=1,",
+		"cmdstat_getbit@tenant1": "calls=1,",
+	} {
+		if !strings.HasPrefix(stats[name], calls) {
+			t.Errorf("%s: %q, want %s", name, stats[name], calls)
+		}
+	}
+	if !strings.HasSuffix(stats["cmdstat_getbit@tenant1"], "failed_calls=1") {
+		t.Errorf("the failed GETBIT must be counted: %q", stats["cmdstat_getbit@tenant1"])
+	}
+
+	info, err = c.Do(ctx, "INFO", "latencystats").Text()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := infoFields(info)["latency_percentiles_usec_set@tenant1"]; !strings.HasPrefix(v, "p50=") {
+		t.Errorf("latencystats %q", info)
+	}
+
+	if err := c.Do(ctx, "CONFIG", "RESETSTAT").Err(); err != nil {
+		t.Fatal(err)
+	}
+	info, _ = c.Do(ctx, "INFO", "commandstats").Text()
+	if _, ok := infoFields(info)["cmdstat_set"]; ok {
+		t.Errorf("RESETSTAT must forget the stats: %q", info)
+	}
+}
+
+func TestNamespaceOf(t *testing.T) {
+	for _, tc := range []struct {
+		args []string
+		ns   string
+	}{
+		{[]string{"set", "a:b:c", "v"}, "a"},
+		{[]string{"set", "abc", "v"}, defaultNamespace},
+		{[]string{"set", ":abc", "v"}, defaultNamespace},
+		{[]string{"bitop", "and", "t1:dest", "t2:src"}, "t1"},
+		{[]string{"xscan", "t1:cursor"}, defaultNamespace},
+		{[]string{"flushall"}, defaultNamespace},
+	} {
+		if ns := namespaceOf(tc.args[0], tc.args); ns != tc.ns {
+			t.Errorf("namespaceOf(%v) = %q, want %q", tc.args, ns, tc.ns)
+		}
+	}
+}
+
+func TestLatencyPercentile(t *testing.T) {
+	st := new(cmdStat)
+	for i := 0; i < 100; i++ {
+		st.calls++
+		if i < 99 {
+			// 1000us falls under 1024us
+			st.latency[bits.Len64(1000)]++
+		} else {
+			st.latency[21]++
+		}
+	}
+	if p := st.percentile(50); p != 1024 {
+		t.Errorf("p50 %d", p)
+	}
+	if p := st.percentile(99.9); p != 1<<21 {
+		t.Errorf("p99.9 %d", p)
+	}
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/tidwall/redcon"
+	rafthub "github.com/tidwall/uhaha"
+)
+
+// the listeners of the clients
+const (
+	listenerPort    = "port"
+	listenerTLSPort = "tls-port"
+)
+
+var (
+	// --rename-command [listener:]command=name, an empty name disables the
+	// command
+	renameCommands stringList
+	// --disable-command [listener:]command
+	disableCommands stringList
+)
+
+// the commands served besides the read, the write and the intermediate
+// commands: those of the connection and those of rafthub
+var builtinCommands = []string{
+	"auth", "hello", "quit", "ping", "echo", "shutdown", "command",
+	"tracer", "tracew", "raft", "cluster", "machine", "version",
+}
+
+// the intermediate commands by lower case name
+var intermediateCommands = make(map[string]bool)
+
+func (c *raftConfig) AddIntermediateCommand(name string, fn func(m rafthub.Machine, args []string) (interface{}, error)) {
+	intermediateCommands[strings.ToLower(name)] = true
+	c.Config.AddIntermediateCommand(name, fn)
+}
+
+// commandTable holds the commands renamed and disabled on a listener
+type commandTable struct {
+	// the original command of a new name
+	renamed map[string]string
+	// the new name of a renamed command, empty when it is disabled
+	names map[string]string
+}
+
+// the command tables by listener, a listener without table serves the
+// commands by their name
+var commandTables = make(map[string]*commandTable)
+
+// allCommands returns the lower case names of the commands served, sorted
+func allCommands() []string {
+	seen := make(map[string]bool)
+	for _, m := range []map[string]commandFunc{readCommands, writeCommands} {
+		for name := range m {
+			seen[name] = true
+		}
+	}
+	for name := range intermediateCommands {
+		seen[name] = true
+	}
+	for _, name := range builtinCommands {
+		seen[name] = true
+	}
+	names := make([]string, 0, len(seen))
+	for name := range seen {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// loadCommandTables builds the tables of --rename-command and
+// --disable-command
+func loadCommandTables() error {
+	known := make(map[string]bool)
+	for _, name := range allCommands() {
+		known[name] = true
+	}
+	set := func(spec, to string) error {
+		listeners := []string{listenerPort, listenerTLSPort, listenerClient}
+		if i := strings.IndexByte(spec, ':'); i >= 0 {
+			switch spec[:i] {
+			case listenerPort, listenerTLSPort, listenerClient:
+				listeners = []string{spec[:i]}
+			default:
+				return fmt.Errorf("unknown listener '%s', expected %s, %s or %s", spec[:i], listenerPort, listenerTLSPort, listenerClient)
+			}
+			spec = spec[i+1:]
+		}
+		from := strings.ToLower(spec)
+		to = strings.ToLower(to)
+		if !known[from] {
+			return fmt.Errorf("unknown command '%s'", spec)
+		}
+		if known[to] {
+			return fmt.Errorf("cannot rename %s to the command %s", from, to)
+		}
+		for _, l := range listeners {
+			t := commandTables[l]
+			if t == nil {
+				t = &commandTable{renamed: make(map[string]string), names: make(map[string]string)}
+				commandTables[l] = t
+			}
+			if _, ok := t.names[from]; ok {
+				return fmt.Errorf("command %s renamed twice on the %s", from, l)
+			}
+			if to != "" {
+				if prev, ok := t.renamed[to]; ok {
+					return fmt.Errorf("commands %s and %s renamed to %s on the %s", prev, from, to, l)
+				}
+				t.renamed[to] = from
+			}
+			t.names[from] = to
+		}
+		return nil
+	}
+	for _, spec := range renameCommands {
+		i := strings.LastIndexByte(spec, '=')
+		if i < 0 {
+			return fmt.Errorf("invalid --rename-command '%s', expected [listener:]command=name", spec)
+		}
+		if err := set(spec[:i], spec[i+1:]); err != nil {
+			return err
+		}
+	}
+	for _, spec := range disableCommands {
+		if err := set(spec, ""); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// resolve returns the command a client names on the listener, false for a
+// command disabled, or called by its name once renamed
+func (t *commandTable) resolve(name string) (string, bool) {
+	if t == nil {
+		return name, true
+	}
+	if cmd, ok := t.renamed[name]; ok {
+		return cmd, true
+	}
+	if _, ok := t.names[name]; ok {
+		return "", false
+	}
+	return name, true
+}
+
+// name returns the name of a command on the listener, empty when it is
+// disabled
+func (t *commandTable) name(cmd string) string {
+	if t == nil {
+		return cmd
+	}
+	if name, ok := t.names[cmd]; ok {
+		return name
+	}
+	return cmd
+}
+
+// resolveCommand rewrites args to the command a client of the listener
+// names, and the command traced by TRACER and TRACEW
+func resolveCommand(listener string, args []string) error {
+	t := commandTables[listener]
+	if t == nil {
+		return nil
+	}
+	cmd, ok := t.resolve(args[0])
+	if !ok {
+		return rafthub.ErrUnknownCommand
+	}
+	args[0] = cmd
+	if (cmd == "tracer" || cmd == "tracew") && len(args) > 2 {
+		inner, ok := t.resolve(strings.ToLower(args[2]))
+		if !ok {
+			return rafthub.ErrUnknownCommand
+		}
+		args[2] = inner
+	}
+	return nil
+}
+
+// commandInfo is the reply of COMMAND INFO for a command: its name, any
+// arity, its flags, and its key positions
+func commandInfo(cmd, name string) []interface{} {
+	flags := []interface{}{}
+	first, last, step := 0, 0, 0
+	switch {
+	case writeCommands[cmd] != nil:
+		flags = append(flags, redcon.SimpleString("write"))
+	case readCommands[cmd] != nil:
+		flags = append(flags, redcon.SimpleString("readonly"))
+	}
+	if readCommands[cmd] != nil || writeCommands[cmd] != nil {
+		switch {
+		case allKeysCommands[cmd]:
+		case multiKeyCommands[cmd]:
+			first, last, step = 1, -1, 1
+		case cmd == "mset":
+			first, last, step = 1, -1, 2
+		case cmd == "xread" || cmd == "xreadgroup" || scriptCommands[cmd]:
+			// the keys follow STREAMS, or the number of keys
+		case cmd == "xgroup":
+			first, last, step = 2, 2, 1
+		default:
+			first, last, step = 1, 1, 1
+		}
+	}
+	return []interface{}{name, redcon.SimpleInt(-1), flags,
+		redcon.SimpleInt(first), redcon.SimpleInt(last), redcon.SimpleInt(step)}
+}
+
+// cmdCOMMAND is COMMAND, COMMAND COUNT, COMMAND LIST and COMMAND INFO
+// name..., the commands disabled on the listener are hidden and the
+// commands renamed go by their new name
+func cmdCOMMAND(listener string, args []string) (interface{}, error) {
+	t := commandTables[listener]
+	var names, cmds []string
+	for _, cmd := range allCommands() {
+		if name := t.name(cmd); name != "" {
+			names = append(names, name)
+			cmds = append(cmds, cmd)
+		}
+	}
+	sub := ""
+	if len(args) > 1 {
+		sub = strings.ToLower(args[1])
+	}
+	switch sub {
+	case "":
+		infos := make([]interface{}, len(names))
+		for i := range names {
+			infos[i] = commandInfo(cmds[i], names[i])
+		}
+		return infos, nil
+	case "count":
+		if len(args) != 2 {
+			return nil, rafthub.ErrWrongNumArgs
+		}
+		return redcon.SimpleInt(len(names)), nil
+	case "list":
+		if len(args) != 2 {
+			return nil, rafthub.ErrWrongNumArgs
+		}
+		return names, nil
+	case "info":
+		infos := make([]interface{}, 0, len(args)-2)
+		for _, name := range args[2:] {
+			cmd, ok := t.resolve(strings.ToLower(name))
+			if !ok || !contains(cmds, cmd) {
+				infos = append(infos, nil)
+				continue
+			}
+			infos = append(infos, commandInfo(cmd, t.name(cmd)))
+		}
+		return infos, nil
+	default:
+		return nil, fmt.Errorf("ERR unknown subcommand '%s'", args[1])
+	}
+}
+
+func contains(list []string, s string) bool {
+	i := sort.SearchStrings(list, s)
+	return i < len(list) && list[i] == s
+}
@@ -0,0 +1,87 @@
+//go:build alltest
+// +build alltest
+
+package main
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRenameCommand(t *testing.T) {
+	ctx := context.Background()
+	c := getTestConn()
+	defer func() {
+		renameCommands, disableCommands = nil, nil
+		commandTables = make(map[string]*commandTable)
+	}()
+	for _, bad := range []struct{ rename, disable []string }{
+		{rename: []string{"nosuchcmd=x"}},
+		{rename: []string{"config"}},
+		{rename: []string{"config=get"}},
+		{rename: []string{"config=x", "flushdb=x"}},
+		{disable: []string{"admin:config"}},
+	} {
+		renameCommands, disableCommands = bad.rename, bad.disable
+		commandTables = make(map[string]*commandTable)
+		if err := loadCommandTables(); err == nil {
+			t.Errorf("rename %q disable %q accepted", bad.rename, bad.disable)
+		}
+	}
+
+	renameCommands = []string{"CONFIG=cfg-9f2c"}
+	disableCommands = []string{"port:flushdb", "tls-port:get"}
+	commandTables = make(map[string]*commandTable)
+	if err := loadCommandTables(); err != nil {
+		t.Fatal(err)
+	}
+	for _, args := range [][]interface{}{
+		{"CONFIG", "GET", "maxclients"},
+		{"FLUSHDB"},
+		{"TRACER", "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01", "FLUSHDB"},
+	} {
+		if err := c.Do(ctx, args...).Err(); err == nil || !strings.Contains(err.Error(), "unknown command") {
+			t.Errorf("%v: %v", args, err)
+		}
+	}
+	if err := c.Do(ctx, "CFG-9F2C", "GET", "maxclients").Err(); err != nil {
+		t.Errorf("renamed CONFIG: %v", err)
+	}
+	// GET is only disabled on the TLS port
+	if err := c.Set(ctx, "rename:a", "1", 0).Err(); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := c.Get(ctx, "rename:a").Result(); v != "1" {
+		t.Errorf("GET: %q %v", v, err)
+	}
+
+	names, err := c.Do(ctx, "COMMAND", "LIST").StringSlice()
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen := make(map[string]bool)
+	for _, name := range names {
+		seen[name] = true
+	}
+	if !seen["cfg-9f2c"] || !seen["get"] || seen["config"] || seen["flushdb"] {
+		t.Errorf("COMMAND LIST: %v", names)
+	}
+	if n, err := c.Do(ctx, "COMMAND", "COUNT").Int(); n != len(names) {
+		t.Errorf("COMMAND COUNT: %d %v", n, err)
+	}
+	infos, err := c.Do(ctx, "COMMAND", "INFO", "get", "config", "cfg-9f2c", "flushdb").Slice()
+	if err != nil || len(infos) != 4 {
+		t.Fatalf("COMMAND INFO: %v %v", infos, err)
+	}
+	if !reflect.DeepEqual(infos[0], []interface{}{"get", int64(-1), []interface{}{"readonly"}, int64(1), int64(1), int64(1)}) {
+		t.Errorf("COMMAND INFO get: %#v", infos[0])
+	}
+	if infos[1] != nil || infos[3] != nil {
+		t.Errorf("COMMAND INFO of the hidden commands: %v", infos)
+	}
+	if info, ok := infos[2].([]interface{}); !ok || info[0] != "cfg-9f2c" {
+		t.Errorf("COMMAND INFO of the renamed command: %v", infos[2])
+	}
+}
@@ -17,6 +17,7 @@ import (
 	"github.com/cenkalti/backoff/v4"
 	lediscfg "github.com/ledisdb/ledisdb/config"
 	"github.com/redis/go-redis/v9"
+	"github.com/syndtr/goleveldb/leveldb"
 	"github.com/tidwall/uhaha"
 )
 
@@ -29,6 +30,9 @@ func getTestConn() *redis.Client {
 	log.SetOutput(os.Stderr)
 	f := func() {
 		conf.DataDir = "/tmp/icefiredb"
+		conf.NodeID = "1"
+		// the admin, gRPC and gateway endpoints dial the node on its address
+		conf.Addr = "127.0.0.1:11001"
 		os.RemoveAll(conf.DataDir)
 		conf.DataDirReady = func(dir string) {
 			os.RemoveAll(filepath.Join(dir, "main.db"))
@@ -47,15 +51,26 @@ func getTestConn() *redis.Client {
 			if err != nil {
 				panic(err)
 			}
+			// the snapshots are taken from the leveldb of the goleveldb driver
+			db, _ = ldb.GetSDB().GetDriver().GetStorageEngine().(*leveldb.DB)
 		}
 
 		conf.Snapshot = snapshot
 		conf.Restore = restore
+		conf.LocalConnector = func(lc uhaha.LocalConnector) {
+			localConnector = lc
+		}
+		conf.ConnOpened = connOpened
+		conf.ConnClosed = connClosed
+		// the commands are audited once a test opens the audit log
+		conf.ResponseFilter = auditFilter
 		fmt.Printf("start with Storage Engine: %s\n", os.Getenv("DRIVER"))
-		go uhaha.Main(conf)
+		go uhaha.Main(conf.Config)
 
+		// the tests check the RESP2 replies, resp3_test the RESP3 ones
 		testRedisClient = redis.NewClient(&redis.Options{
-			Addr: "127.0.0.1:11001",
+			Addr:     "127.0.0.1:11001",
+			Protocol: 2,
 		})
 
 		log.Println("waiting for DB bootstrap")
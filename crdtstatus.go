@@ -0,0 +1,53 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/IceFireDB/IceFireDB/driver/crdt"
+	rafthub "github.com/tidwall/uhaha"
+)
+
+func init() {
+	conf.AddIntermediateCommand("CRDT.STATUS", cmdCRDTSTATUS)
+}
+
+// CRDT.STATUS
+// Returns the replication state of the node in CRDT mode: its clock, and the
+// last seen clock, the unmerged operations and the convergence lag of each
+// peer.
+func cmdCRDTSTATUS(m rafthub.Machine, args []string) (interface{}, error) {
+	if len(args) != 1 {
+		return nil, rafthub.ErrWrongNumArgs
+	}
+	var rs replicationStats
+	if ldb != nil {
+		rs, _ = ldb.GetSDB().GetDriver().(replicationStats)
+	}
+	if rs == nil {
+		return nil, errors.New("ERR the storage backend is not crdt")
+	}
+	return dumpReplication(rs.ReplicationStatus(), time.Now()), nil
+}
+
+// dumpReplication formats the replication state like the sections of INFO
+func dumpReplication(st crdt.Status, now time.Time) string {
+	var buf bytes.Buffer
+	buf.WriteString("# CRDT\r\n")
+	fmt.Fprintf(&buf, "node_id:%s\r\n", st.ID)
+	fmt.Fprintf(&buf, "clock:%d\r\n", st.Clock)
+	fmt.Fprintf(&buf, "peers:%d\r\n", len(st.Peers))
+	fmt.Fprintf(&buf, "unmerged_ops:%d\r\n", st.Unmerged())
+	fmt.Fprintf(&buf, "convergence_lag_seconds:%.3f\r\n", st.Lag(now).Seconds())
+	for i, p := range st.Peers {
+		lastSeen := -1.0
+		if !p.Seen.IsZero() {
+			lastSeen = now.Sub(p.Seen).Seconds()
+		}
+		fmt.Fprintf(&buf, "peer%d:id=%s,clock=%d,known_clock=%d,unmerged_ops=%d,last_seen_seconds=%.3f,lag_seconds=%.3f\r\n",
+			i, p.ID, p.Clock, p.Known, p.Unmerged(), lastSeen, p.Lag(now).Seconds())
+	}
+	return buf.String()
+}
@@ -0,0 +1,40 @@
+//go:build alltest
+// +build alltest
+
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/IceFireDB/IceFireDB/driver/crdt"
+)
+
+func TestCRDTStatus(t *testing.T) {
+	c := getTestConn()
+	err := c.Do(context.Background(), "CRDT.STATUS").Err()
+	if err == nil || !strings.Contains(err.Error(), "not crdt") {
+		t.Errorf("CRDT.STATUS outside of the crdt mode: %v", err)
+	}
+
+	now := time.Now()
+	st := crdt.Status{ID: "self", Clock: 7, Peers: []crdt.PeerStatus{
+		{ID: "a", Clock: 5, Known: 8, Sent: now.Add(-2 * time.Second), Seen: now.Add(-time.Second)},
+		{ID: "b", Clock: 3, Known: 3},
+	}}
+	fields := infoFields(dumpReplication(st, now))
+	for k, v := range map[string]string{
+		"node_id":      "self",
+		"clock":        "7",
+		"peers":        "2",
+		"unmerged_ops": "3",
+		"peer0":        "id=a,clock=5,known_clock=8,unmerged_ops=3,last_seen_seconds=1.000,lag_seconds=1.000",
+		"peer1":        "id=b,clock=3,known_clock=3,unmerged_ops=0,last_seen_seconds=-1.000,lag_seconds=0.000",
+	} {
+		if fields[k] != v {
+			t.Errorf("%s: %q, want %q", k, fields[k], v)
+		}
+	}
+}
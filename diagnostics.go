@@ -0,0 +1,221 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/pprof"
+	"os"
+	"path/filepath"
+	"runtime"
+	rpprof "runtime/pprof"
+	"strings"
+	"time"
+
+	rafthub "github.com/tidwall/uhaha"
+	"go.uber.org/zap"
+)
+
+// the flags holding a secret, their value is left out of the bundle
+var secretFlags = map[string]bool{"auth": true, "admin-token": true, "oss-ak": true, "oss-sk": true, "webhook-secret": true, "join-secret": true, "join-token": true, "namespace-keys": true}
+
+func init() {
+	conf.AddIntermediateCommand("DIAGNOSTICS", cmdDIAGNOSTICS)
+}
+
+// DIAGNOSTICS BUNDLE
+// Writes the diagnostics bundle of the node to its data directory and
+// returns the path of the archive.
+func cmdDIAGNOSTICS(m rafthub.Machine, args []string) (interface{}, error) {
+	if len(args) != 2 {
+		return nil, rafthub.ErrWrongNumArgs
+	}
+	if strings.ToLower(args[1]) != "bundle" {
+		return nil, fmt.Errorf("ERR unknown DIAGNOSTICS subcommand '%s'", args[1])
+	}
+	if memoryPressure.Load() {
+		return nil, errMemoryPressure
+	}
+	dir := os.TempDir()
+	if ldsCfg != nil {
+		dir = filepath.Join(filepath.Dir(ldsCfg.DataDir), "diagnostics")
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("ERR %v", err)
+	}
+	path := filepath.Join(dir, bundleName())
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("ERR %v", err)
+	}
+	err = writeBundle(f)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(path)
+		return nil, fmt.Errorf("ERR %v", err)
+	}
+	loggers[logServer].Info("diagnostics bundle written", zap.String("path", path))
+	return path, nil
+}
+
+// bundleName returns the file name of a bundle taken now
+func bundleName() string {
+	return fmt.Sprintf("diagnostics-%s-%s.tar.gz", conf.NodeID, time.Now().UTC().Format("20060102T150405.000Z"))
+}
+
+// writeBundle writes the diagnostics of the node as a gzipped tar: the
+// goroutines, a heap profile, the configuration, the recent logs, the server
+// info with the engine stats and the raft status
+func writeBundle(w io.Writer) error {
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	now := time.Now()
+	add := func(name string, write func(w io.Writer) error) error {
+		var buf bytes.Buffer
+		if err := write(&buf); err != nil {
+			// a part that fails is replaced by its error, the rest is
+			// still worth the support case
+			buf.Reset()
+			fmt.Fprintf(&buf, "error: %v\n", err)
+			name += ".error"
+		}
+		hdr := &tar.Header{Name: name, Mode: 0o600, Size: int64(buf.Len()), ModTime: now}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		_, err := tw.Write(buf.Bytes())
+		return err
+	}
+	parts := []struct {
+		name  string
+		write func(w io.Writer) error
+	}{
+		{"goroutines.txt", func(w io.Writer) error { return rpprof.Lookup("goroutine").WriteTo(w, 2) }},
+		{"heap.pprof", func(w io.Writer) error {
+			runtime.GC()
+			return rpprof.Lookup("heap").WriteTo(w, 0)
+		}},
+		{"config.json", writeBundleConfig},
+		{"logs.txt", func(w io.Writer) error {
+			_, err := recentLogs.WriteTo(w)
+			return err
+		}},
+		{"info.txt", func(w io.Writer) error {
+			_, err := w.Write(serverInfo.Dump(""))
+			return err
+		}},
+		{"raft.txt", func(w io.Writer) error {
+			resp, err := localDo("raft", "info")
+			if err != nil {
+				return err
+			}
+			for k, v := range resp.Map() {
+				fmt.Fprintf(w, "%s:%s\n", k, v.String())
+			}
+			return nil
+		}},
+	}
+	for _, p := range parts {
+		if err := add(p.name, p.write); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// writeBundleConfig writes the configuration of the node and its command
+// line, without the secrets
+func writeBundleConfig(w io.Writer) error {
+	c := struct {
+		ID        string            `json:"id"`
+		Version   string            `json:"version"`
+		GitSHA    string            `json:"git_sha,omitempty"`
+		GoVersion string            `json:"go_version"`
+		Addr      string            `json:"addr"`
+		DataDir   string            `json:"data_dir"`
+		Args      []string          `json:"args"`
+		Uptime    float64           `json:"uptime_seconds"`
+		Config    nodeConfig        `json:"config"`
+		Env       map[string]string `json:"env,omitempty"`
+	}{
+		ID:        conf.NodeID,
+		Version:   conf.Version,
+		GitSHA:    conf.GitSHA,
+		GoVersion: runtime.Version(),
+		Addr:      conf.Addr,
+		DataDir:   conf.DataDir,
+		Args:      redactArgs(os.Args[1:]),
+		Uptime:    time.Since(startTime).Seconds(),
+		Config:    currentConfig(),
+	}
+	for _, name := range []string{"GOMAXPROCS", "GOGC", "GOMEMLIMIT", "GODEBUG", "DRIVER"} {
+		if v, ok := os.LookupEnv(name); ok {
+			if c.Env == nil {
+				c.Env = make(map[string]string)
+			}
+			c.Env[name] = v
+		}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(c)
+}
+
+// redactArgs returns the command line with the values of the secret flags
+// replaced
+func redactArgs(args []string) []string {
+	out := make([]string, len(args))
+	copy(out, args)
+	for i := 0; i < len(out); i++ {
+		name := strings.TrimLeft(out[i], "-")
+		if !strings.HasPrefix(out[i], "-") {
+			continue
+		}
+		if k, _, ok := strings.Cut(name, "="); ok {
+			if secretFlags[k] {
+				out[i] = out[i][:strings.IndexByte(out[i], '=')+1] + "REDACTED"
+			}
+		} else if secretFlags[name] && i+1 < len(out) {
+			out[i+1] = "REDACTED"
+			i++
+		}
+	}
+	return out
+}
+
+// handleDiagnostics downloads the diagnostics bundle of the node
+func handleDiagnostics(w http.ResponseWriter, r *http.Request) {
+	if memoryPressure.Load() {
+		writeJSON(w, http.StatusServiceUnavailable, adminError{Error: errMemoryPressure.Error()})
+		return
+	}
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+bundleName()+`"`)
+	if err := writeBundle(w); err != nil {
+		loggers[logServer].Error("diagnostics bundle fail", zap.Error(err))
+	}
+}
+
+// registerPprof adds the pprof endpoints to the admin API, the command line
+// is served without the secrets
+func registerPprof(mux *http.ServeMux) {
+	mux.HandleFunc("GET /debug/pprof/", pprof.Index)
+	mux.HandleFunc("GET /debug/pprof/cmdline", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		_, _ = io.WriteString(w, strings.Join(redactArgs(os.Args), "\x00"))
+	})
+	mux.HandleFunc("GET /debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("GET /debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("POST /debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("GET /debug/pprof/trace", pprof.Trace)
+}
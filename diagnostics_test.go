@@ -0,0 +1,97 @@
+//go:build alltest
+// +build alltest
+
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// bundleFiles returns the files of a diagnostics bundle by name
+func bundleFiles(t *testing.T, r io.Reader) map[string]string {
+	t.Helper()
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := make(map[string]string)
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return files
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := io.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[hdr.Name] = string(b)
+	}
+}
+
+func TestDiagnostics(t *testing.T) {
+	c := getTestConn()
+	ctx := context.Background()
+
+	path, err := c.Do(ctx, "DIAGNOSTICS", "BUNDLE").Text()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(path)
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	files := bundleFiles(t, f)
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	want := []string{"config.json", "goroutines.txt", "heap.pprof", "info.txt", "logs.txt", "raft.txt"}
+	if !reflect.DeepEqual(names, want) {
+		t.Fatalf("bundle files %v, want %v", names, want)
+	}
+	if !strings.Contains(files["goroutines.txt"], "goroutine ") || !strings.Contains(files["raft.txt"], "state:Leader") {
+		t.Error("the bundle must hold the goroutines and the raft status")
+	}
+	if err := c.Do(ctx, "DIAGNOSTICS", "OTHER").Err(); err == nil {
+		t.Error("an unknown subcommand must fail")
+	}
+
+	h := adminHandler("secret")
+	w := adminRequest(t, h, "GET", "/v1/diagnostics", "")
+	if w.Code != http.StatusOK || len(bundleFiles(t, w.Body)) != len(want) {
+		t.Errorf("diagnostics download %d", w.Code)
+	}
+	if w = adminRequest(t, h, "GET", "/debug/pprof/goroutine?debug=1", ""); w.Code != http.StatusOK {
+		t.Errorf("pprof %d", w.Code)
+	}
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/debug/pprof/", nil))
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("pprof without the token %d", w.Code)
+	}
+}
+
+func TestRedactArgs(t *testing.T) {
+	args := []string{"-n", "1", "--auth", "pass", "-admin-token=tok", "--oss-sk", "key", "-d", "data"}
+	want := []string{"-n", "1", "--auth", "REDACTED", "-admin-token=REDACTED", "--oss-sk", "REDACTED", "-d", "data"}
+	if got := redactArgs(args); !reflect.DeepEqual(got, want) {
+		t.Errorf("redactArgs %v, want %v", got, want)
+	}
+}
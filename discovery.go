@@ -0,0 +1,368 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/tidwall/redcon"
+	"go.uber.org/zap"
+)
+
+var (
+	// the address of the DNS server of the members, disabled when empty
+	dnsAddr string
+	// the zone the DNS server answers for
+	dnsDomain = "icefiredb.local."
+	// the Consul agent the node registers with, disabled when empty
+	consulAddr string
+	// the ACL token of the Consul agent
+	consulToken string
+	// the etcd endpoint the node registers with, disabled when empty
+	etcdAddr string
+	// the name the nodes register under
+	discoveryService = "icefiredb"
+)
+
+const (
+	// the time to live of the DNS records, in seconds
+	dnsTTL = 5
+	// the time to live of the registrations, refreshed every third of it
+	discoveryTTL = 15 * time.Second
+)
+
+// the roles of the members in the DNS names and the registrations
+const (
+	roleLeader  = "leader"
+	roleReplica = "replica"
+)
+
+// clusterMember is a member of the cluster at its address in the cluster
+type clusterMember struct {
+	id, host, port string
+	leader         bool
+}
+
+func (m clusterMember) role() string {
+	if m.leader {
+		return roleLeader
+	}
+	return roleReplica
+}
+
+// clusterMembers returns the members of the cluster, the leader first
+func clusterMembers() ([]clusterMember, error) {
+	resp, err := localDo("raft", "server", "list")
+	if err != nil {
+		return nil, err
+	}
+	var members []clusterMember
+	resp.ForEach(func(s redcon.RESP) bool {
+		m := s.Map()
+		host, port, err := net.SplitHostPort(m["address"].String())
+		if err != nil {
+			return true
+		}
+		cm := clusterMember{id: m["id"].String(), host: host, port: port, leader: m["leader"].String() == "true"}
+		if cm.leader {
+			members = append([]clusterMember{cm}, members...)
+		} else {
+			members = append(members, cm)
+		}
+		return true
+	})
+	return members, nil
+}
+
+// leaderOf returns the leader of the members, nil while there is none
+func leaderOf(members []clusterMember) *clusterMember {
+	if len(members) > 0 && members[0].leader {
+		return &members[0]
+	}
+	return nil
+}
+
+// dnsAnswer returns the records of a question of the zone, and false when
+// the name is not in it. The zone has leader, replicas and node-<id> with
+// the addresses of the members, and _redis._tcp, _redis._tcp.leader and
+// _redis._tcp.replicas with their SRV records.
+func dnsAnswer(q dns.Question, members []clusterMember) (answer, extra []dns.RR, ok bool) {
+	name := strings.ToLower(q.Name)
+	if name == dnsDomain {
+		return nil, nil, true
+	}
+	label := strings.TrimSuffix(name, "."+dnsDomain)
+	srv := false
+	if rest, found := strings.CutPrefix(label, "_redis._tcp"); found && (rest == "" || rest[0] == '.') {
+		srv, label = true, strings.TrimPrefix(rest, ".")
+	}
+	var selected []clusterMember
+	switch {
+	case srv && label == "":
+		selected = members
+	case label == "leader", label == "replicas":
+		for _, m := range members {
+			if m.leader == (label == "leader") {
+				selected = append(selected, m)
+			}
+		}
+	case !srv && strings.HasPrefix(label, "node-"):
+		for _, m := range members {
+			if label == "node-"+strings.ToLower(m.id) {
+				selected = append(selected, m)
+			}
+		}
+		if len(selected) == 0 {
+			return nil, nil, false
+		}
+	default:
+		return nil, nil, false
+	}
+	for _, m := range selected {
+		if !srv {
+			answer = append(answer, dnsAddress(name, q.Qtype, m.host)...)
+			continue
+		}
+		if q.Qtype != dns.TypeSRV && q.Qtype != dns.TypeANY {
+			continue
+		}
+		port, _ := strconv.Atoi(m.port)
+		target := dns.Fqdn(m.host)
+		if net.ParseIP(m.host) != nil {
+			target = "node-" + strings.ToLower(m.id) + "." + dnsDomain
+			extra = append(extra, dnsAddress(target, dns.TypeANY, m.host)...)
+		}
+		answer = append(answer, &dns.SRV{
+			Hdr:      dns.RR_Header{Name: q.Name, Rrtype: dns.TypeSRV, Class: dns.ClassINET, Ttl: dnsTTL},
+			Priority: 0, Weight: 10, Port: uint16(port), Target: target,
+		})
+	}
+	return answer, extra, true
+}
+
+// dnsAddress returns the A or AAAA record of a member at an IP address
+func dnsAddress(name string, qtype uint16, host string) []dns.RR {
+	ip := net.ParseIP(host)
+	hdr := dns.RR_Header{Name: name, Class: dns.ClassINET, Ttl: dnsTTL}
+	switch {
+	case ip == nil:
+	case ip.To4() != nil && (qtype == dns.TypeA || qtype == dns.TypeANY):
+		hdr.Rrtype = dns.TypeA
+		return []dns.RR{&dns.A{Hdr: hdr, A: ip.To4()}}
+	case ip.To4() == nil && (qtype == dns.TypeAAAA || qtype == dns.TypeANY):
+		hdr.Rrtype = dns.TypeAAAA
+		return []dns.RR{&dns.AAAA{Hdr: hdr, AAAA: ip}}
+	}
+	return nil
+}
+
+// handleDNS answers the questions of the zone from the members seen by the
+// node, any member answers with the same leader
+func handleDNS(w dns.ResponseWriter, r *dns.Msg) {
+	m := new(dns.Msg)
+	m.SetReply(r)
+	m.Authoritative = true
+	switch {
+	case refuseClient(listenerDNS, w.RemoteAddr().String()) != "",
+		len(r.Question) != 1, !dns.IsSubDomain(dnsDomain, strings.ToLower(r.Question[0].Name)):
+		m.Authoritative = false
+		m.Rcode = dns.RcodeRefused
+	default:
+		members, err := clusterMembers()
+		if err != nil {
+			m.Rcode = dns.RcodeServerFailure
+			break
+		}
+		answer, extra, ok := dnsAnswer(r.Question[0], members)
+		if !ok {
+			m.Rcode = dns.RcodeNameError
+		}
+		m.Answer, m.Extra = answer, extra
+	}
+	_ = w.WriteMsg(m)
+}
+
+// serveDNS serves the zone of --dns-domain on --dns-addr, over UDP and TCP
+func serveDNS(addr string) {
+	loggers[logServer].Info("serving the DNS zone", zap.String("addr", addr), zap.String("domain", dnsDomain))
+	handler := dns.HandlerFunc(handleDNS)
+	go func() {
+		err := (&dns.Server{Addr: addr, Net: "tcp", Handler: handler}).ListenAndServe()
+		loggers[logServer].Error("DNS listen fail", zap.String("net", "tcp"), zap.Error(err))
+	}()
+	err := (&dns.Server{Addr: addr, Net: "udp", Handler: handler}).ListenAndServe()
+	loggers[logServer].Error("DNS listen fail", zap.String("net", "udp"), zap.Error(err))
+}
+
+// registry publishes the address and the role of the node. register is
+// called every third of discoveryTTL, it refreshes the registration and
+// updates the role when it changed.
+type registry interface {
+	register(m clusterMember) error
+}
+
+// watchDiscovery keeps the node registered every interval until stop is
+// closed
+func watchDiscovery(interval time.Duration, stop <-chan struct{}) {
+	client := &http.Client{Timeout: 5 * time.Second}
+	var registries []registry
+	if consulAddr != "" {
+		registries = append(registries, &consulRegistry{addr: strings.TrimRight(consulAddr, "/"), token: consulToken, client: client})
+	}
+	if etcdAddr != "" {
+		registries = append(registries, &etcdRegistry{addr: strings.TrimRight(etcdAddr, "/"), client: client})
+	}
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		if members, err := clusterMembers(); err == nil {
+			for _, m := range members {
+				if m.id != conf.NodeID {
+					continue
+				}
+				for _, r := range registries {
+					if err := r.register(m); err != nil {
+						loggers[logServer].Warn("discovery registration fail", zap.String("registry", fmt.Sprintf("%T", r)), zap.Error(err))
+					}
+				}
+			}
+		}
+		select {
+		case <-stop:
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// discoveryCall sends a JSON request to a registry and decodes its reply
+// into out when not nil
+func discoveryCall(client *http.Client, method, url string, header http.Header, in, out interface{}) error {
+	var body []byte
+	if in != nil {
+		var err error
+		if body, err = json.Marshal(in); err != nil {
+			return err
+		}
+	}
+	req, err := http.NewRequest(method, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%s %s: status %s", method, url, resp.Status)
+	}
+	if out != nil {
+		return json.NewDecoder(resp.Body).Decode(out)
+	}
+	return nil
+}
+
+// consulRegistry registers the node as a service of the local Consul agent
+// tagged with its role, with a TTL check passed on each refresh
+type consulRegistry struct {
+	addr, token string
+	client      *http.Client
+	// the role registered, empty until the service is
+	role string
+}
+
+func (c *consulRegistry) register(m clusterMember) error {
+	header := http.Header{}
+	if c.token != "" {
+		header.Set("X-Consul-Token", c.token)
+	}
+	id := discoveryService + "-" + conf.NodeID
+	if m.role() != c.role {
+		port, _ := strconv.Atoi(m.port)
+		service := map[string]interface{}{
+			"ID": id, "Name": discoveryService, "Address": m.host, "Port": port,
+			"Tags": []string{m.role()},
+			"Meta": map[string]string{"node": conf.NodeID},
+			"Check": map[string]string{
+				"TTL": discoveryTTL.String(), "DeregisterCriticalServiceAfter": (4 * discoveryTTL).String(),
+			},
+		}
+		if err := discoveryCall(c.client, http.MethodPut, c.addr+"/v1/agent/service/register", header, service, nil); err != nil {
+			return err
+		}
+		c.role = m.role()
+	}
+	if err := discoveryCall(c.client, http.MethodPut, c.addr+"/v1/agent/check/pass/service:"+id, header, nil, nil); err != nil {
+		// the agent may have dropped the service, register it again
+		c.role = ""
+		return err
+	}
+	return nil
+}
+
+// etcdRegistry puts the node at /<service>/nodes/<id> in etcd with a lease
+// kept alive on each refresh, through the JSON gateway of etcd v3
+type etcdRegistry struct {
+	addr   string
+	client *http.Client
+	// the lease of the key, and the role put with it
+	lease, role string
+}
+
+// etcdNode is the value of the key of a node in etcd
+type etcdNode struct {
+	ID      string `json:"id"`
+	Address string `json:"address"`
+	Role    string `json:"role"`
+}
+
+func (e *etcdRegistry) register(m clusterMember) error {
+	if e.lease != "" {
+		var ka struct {
+			Result struct {
+				TTL string `json:"TTL"`
+			} `json:"result"`
+		}
+		err := discoveryCall(e.client, http.MethodPost, e.addr+"/v3/lease/keepalive", nil, map[string]string{"ID": e.lease}, &ka)
+		if err != nil || ka.Result.TTL == "" || ka.Result.TTL == "0" {
+			// the lease expired with the key
+			e.lease, e.role = "", ""
+		}
+	}
+	if e.lease == "" {
+		var grant struct {
+			ID string `json:"ID"`
+		}
+		if err := discoveryCall(e.client, http.MethodPost, e.addr+"/v3/lease/grant", nil, map[string]int64{"TTL": int64(discoveryTTL / time.Second)}, &grant); err != nil {
+			return err
+		}
+		e.lease = grant.ID
+	}
+	if m.role() == e.role {
+		return nil
+	}
+	value, _ := json.Marshal(etcdNode{ID: conf.NodeID, Address: net.JoinHostPort(m.host, m.port), Role: m.role()})
+	key := "/" + discoveryService + "/nodes/" + conf.NodeID
+	put := map[string]string{
+		"key":   base64.StdEncoding.EncodeToString([]byte(key)),
+		"value": base64.StdEncoding.EncodeToString(value),
+		"lease": e.lease,
+	}
+	if err := discoveryCall(e.client, http.MethodPost, e.addr+"/v3/kv/put", nil, put, nil); err != nil {
+		return err
+	}
+	e.role = m.role()
+	return nil
+}
@@ -0,0 +1,214 @@
+//go:build alltest
+// +build alltest
+
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestDNSAnswer(t *testing.T) {
+	members := []clusterMember{
+		{id: "1", host: "10.0.0.1", port: "11001", leader: true},
+		{id: "2", host: "fd00::2", port: "11001"},
+		{id: "3", host: "node3.example.com", port: "11002"},
+	}
+	answer := func(name string, qtype uint16) ([]dns.RR, []dns.RR, bool) {
+		return dnsAnswer(dns.Question{Name: name + "." + dnsDomain, Qtype: qtype, Qclass: dns.ClassINET}, members)
+	}
+	if rr, _, ok := answer("leader", dns.TypeA); !ok || len(rr) != 1 || rr[0].(*dns.A).A.String() != "10.0.0.1" {
+		t.Errorf("leader %v", rr)
+	}
+	if rr, _, ok := answer("replicas", dns.TypeAAAA); !ok || len(rr) != 1 || rr[0].(*dns.AAAA).AAAA.String() != "fd00::2" {
+		t.Errorf("replicas %v", rr)
+	}
+	if rr, _, ok := answer("NODE-2", dns.TypeA); !ok || len(rr) != 0 {
+		t.Errorf("A of an IPv6 node %v %v", rr, ok)
+	}
+	if _, _, ok := answer("node-9", dns.TypeA); ok {
+		t.Error("unknown node")
+	}
+	if _, _, ok := answer("other", dns.TypeA); ok {
+		t.Error("unknown name")
+	}
+
+	rr, extra, ok := answer("_redis._tcp", dns.TypeSRV)
+	if !ok || len(rr) != 3 || len(extra) != 2 {
+		t.Fatalf("SRV %v %v", rr, extra)
+	}
+	if srv := rr[0].(*dns.SRV); srv.Target != "node-1."+dnsDomain || srv.Port != 11001 {
+		t.Errorf("SRV of the leader %v", srv)
+	}
+	if srv := rr[2].(*dns.SRV); srv.Target != "node3.example.com." || srv.Port != 11002 {
+		t.Errorf("SRV of a named node %v", srv)
+	}
+	if rr, _, _ := answer("_redis._tcp.leader", dns.TypeSRV); len(rr) != 1 || rr[0].(*dns.SRV).Port != 11001 {
+		t.Errorf("SRV of the leader %v", rr)
+	}
+	if rr, _, _ := answer("_redis._tcp.replicas", dns.TypeA); len(rr) != 0 {
+		t.Errorf("A of a SRV name %v", rr)
+	}
+}
+
+func TestDNSServer(t *testing.T) {
+	getTestConn()
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	started := make(chan struct{})
+	server := &dns.Server{PacketConn: pc, Handler: dns.HandlerFunc(handleDNS), NotifyStartedFunc: func() { close(started) }}
+	go server.ActivateAndServe()
+	defer server.Shutdown()
+	<-started
+
+	query := func(name string, qtype uint16) *dns.Msg {
+		m := new(dns.Msg)
+		m.SetQuestion(name, qtype)
+		r, err := dns.Exchange(m, pc.LocalAddr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return r
+	}
+	leader, _ := localDo("raft", "leader")
+	host, _, _ := net.SplitHostPort(leader.String())
+	r := query("leader."+dnsDomain, dns.TypeANY)
+	if r.Rcode != dns.RcodeSuccess || !r.Authoritative || len(r.Answer) != 1 || !strings.HasSuffix(r.Answer[0].String(), host) {
+		t.Errorf("leader %v", r)
+	}
+	if r := query("missing."+dnsDomain, dns.TypeA); r.Rcode != dns.RcodeNameError {
+		t.Errorf("missing name %v", r)
+	}
+	if r := query("example.com.", dns.TypeA); r.Rcode != dns.RcodeRefused {
+		t.Errorf("name outside the zone %v", r)
+	}
+}
+
+func TestConsulRegistry(t *testing.T) {
+	var mu sync.Mutex
+	var registered []map[string]interface{}
+	var passes int
+	fail := false
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if r.Method != http.MethodPut || r.Header.Get("X-Consul-Token") != "secret" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		switch {
+		case r.URL.Path == "/v1/agent/service/register":
+			var service map[string]interface{}
+			json.NewDecoder(r.Body).Decode(&service)
+			registered = append(registered, service)
+		case r.URL.Path == "/v1/agent/check/pass/service:"+discoveryService+"-"+conf.NodeID && !fail:
+			passes++
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer ts.Close()
+
+	c := &consulRegistry{addr: ts.URL, token: "secret", client: ts.Client()}
+	m := clusterMember{id: conf.NodeID, host: "10.0.0.1", port: "11001", leader: true}
+	for i := 0; i < 2; i++ {
+		if err := c.register(m); err != nil {
+			t.Fatal(err)
+		}
+	}
+	m.leader = false
+	if err := c.register(m); err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	if len(registered) != 2 || passes != 3 {
+		t.Fatalf("registered %d times, passed %d times", len(registered), passes)
+	}
+	if s := registered[1]; s["Name"] != discoveryService || s["Address"] != "10.0.0.1" || s["Port"] != float64(11001) ||
+		s["Tags"].([]interface{})[0] != roleReplica || s["Check"].(map[string]interface{})["TTL"] != discoveryTTL.String() {
+		t.Errorf("service %v", s)
+	}
+	fail = true
+	mu.Unlock()
+
+	// the service dropped by the agent is registered again
+	if err := c.register(m); err == nil {
+		t.Error("the check must fail")
+	}
+	mu.Lock()
+	fail = false
+	mu.Unlock()
+	err := c.register(m)
+	mu.Lock()
+	defer mu.Unlock()
+	if err != nil || len(registered) != 3 {
+		t.Errorf("registered %d times %v", len(registered), err)
+	}
+}
+
+func TestEtcdRegistry(t *testing.T) {
+	var mu sync.Mutex
+	grants, puts := 0, map[string]string{}
+	lease := "7"
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		var in map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&in)
+		switch r.URL.Path {
+		case "/v3/lease/grant":
+			grants++
+			json.NewEncoder(w).Encode(map[string]string{"ID": lease, "TTL": "15"})
+		case "/v3/lease/keepalive":
+			if in["ID"] == lease {
+				json.NewEncoder(w).Encode(map[string]interface{}{"result": map[string]string{"ID": lease, "TTL": "15"}})
+			} else {
+				json.NewEncoder(w).Encode(map[string]interface{}{"result": map[string]string{"ID": in["ID"].(string)}})
+			}
+		case "/v3/kv/put":
+			key, _ := base64.StdEncoding.DecodeString(in["key"].(string))
+			value, _ := base64.StdEncoding.DecodeString(in["value"].(string))
+			puts[string(key)+"@"+in["lease"].(string)] = string(value)
+			w.Write([]byte("{}"))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer ts.Close()
+
+	e := &etcdRegistry{addr: ts.URL, client: ts.Client()}
+	m := clusterMember{id: conf.NodeID, host: "10.0.0.1", port: "11001", leader: true}
+	for i := 0; i < 2; i++ {
+		if err := e.register(m); err != nil {
+			t.Fatal(err)
+		}
+	}
+	key := "/" + discoveryService + "/nodes/" + conf.NodeID
+	mu.Lock()
+	var node etcdNode
+	json.Unmarshal([]byte(puts[key+"@7"]), &node)
+	if grants != 1 || len(puts) != 1 || node.Address != "10.0.0.1:11001" || node.Role != roleLeader {
+		t.Errorf("granted %d times, put %v", grants, puts)
+	}
+	// the expired lease is granted again with the key
+	lease = "8"
+	mu.Unlock()
+	if err := e.register(m); err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if grants != 2 || puts[key+"@8"] == "" {
+		t.Errorf("granted %d times, put %v", grants, puts)
+	}
+}
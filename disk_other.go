@@ -0,0 +1,11 @@
+//go:build !linux && !darwin
+// +build !linux,!darwin
+
+package main
+
+import "errors"
+
+// diskUsage is not supported on this platform, no disk_pressure event fires
+func diskUsage(path string) (total, free uint64, err error) {
+	return 0, 0, errors.New("disk usage not supported")
+}
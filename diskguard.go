@@ -0,0 +1,356 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	rafthub "github.com/tidwall/uhaha"
+	"go.uber.org/zap"
+)
+
+var (
+	// the writes of the cluster are fenced while the free space of the data
+	// directory of a member is under this percentage, 0 disables the guard
+	readonlyDiskFree float64 = 5
+	// the member lifts its fence once its free space is back over this
+	// percentage
+	readonlyDiskResume float64 = 10
+	// the TLS config of the server, to read the disk guard of the members
+	serverTLS *tls.Config
+	// the free space percentage of the data directory at the last check,
+	// as math.Float64bits, NaN before the first one
+	diskFreePct = math.Float64bits(math.NaN())
+)
+
+// fencesKey holds the fenced members in the storage, out of the ledis key
+// space: the fences are in the raft snapshots and survive a restart
+var fencesKey = []byte("__icefiredb_fences")
+
+// the commands still allowed on a fenced cluster, they free space
+var freeingCommands = map[string]bool{
+	"del": true, "flushall": true, "flushdb": true,
+	"hdel": true, "hclear": true, "hmclear": true,
+	"lpop": true, "rpop": true, "ltrim": true, "lclear": true, "lmclear": true,
+	"srem": true, "sclear": true, "smclear": true,
+	"zrem": true, "zclear": true, "zremrangebyscore": true, "zremrangebyrank": true,
+}
+
+// fences are the members short of disk, by node id, with their free space
+// percentage when they were fenced. They only change as DISKFENCE is
+// applied, so that every member rejects the same writes.
+var fences = &diskFences{nodes: make(map[string]float64)}
+
+type diskFences struct {
+	mu    sync.RWMutex
+	nodes map[string]float64
+}
+
+func init() {
+	conf.Config.AddWriteCommand("DISKFENCE", cmdDISKFENCE)
+	conf.AddIntermediateCommand("DISKGUARD", cmdDISKGUARD)
+}
+
+// load reads the fences of the storage, after it is opened or restored
+func (f *diskFences) load() error {
+	data, err := ldb.GetSDB().Get(fencesKey)
+	if err != nil {
+		return err
+	}
+	nodes := make(map[string]float64)
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &nodes); err != nil {
+			return err
+		}
+	}
+	f.mu.Lock()
+	f.nodes = nodes
+	f.mu.Unlock()
+	return nil
+}
+
+// set fences a member, or lifts its fence when free is negative
+func (f *diskFences) set(node string, free float64) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	nodes := make(map[string]float64, len(f.nodes)+1)
+	for id, pct := range f.nodes {
+		nodes[id] = pct
+	}
+	if free < 0 {
+		delete(nodes, node)
+	} else {
+		nodes[node] = free
+	}
+	var err error
+	if len(nodes) == 0 {
+		err = ldb.GetSDB().Delete(fencesKey)
+	} else {
+		var data []byte
+		if data, err = json.Marshal(nodes); err == nil {
+			err = ldb.GetSDB().Put(fencesKey, data)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	f.nodes = nodes
+	return nil
+}
+
+func (f *diskFences) get(node string) (float64, bool) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	pct, ok := f.nodes[node]
+	return pct, ok
+}
+
+// list returns the fenced members sorted by node id
+func (f *diskFences) list() []string {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	nodes := make([]string, 0, len(f.nodes))
+	for id := range f.nodes {
+		nodes = append(nodes, id)
+	}
+	sort.Strings(nodes)
+	return nodes
+}
+
+// err returns the error of the writes while a member is fenced
+func (f *diskFences) err() error {
+	nodes := f.list()
+	if len(nodes) == 0 {
+		return nil
+	}
+	pct, _ := f.get(nodes[0])
+	return fmt.Errorf("READONLY writes are fenced, node %s is short of disk space (%.2f%% free)", nodes[0], pct)
+}
+
+// fenced rejects the writes while the cluster is fenced, except the ones
+// freeing space
+func fenced(name string, fn commandFunc) commandFunc {
+	if freeingCommands[strings.ToLower(name)] {
+		return fn
+	}
+	return func(m rafthub.Machine, args []string) (interface{}, error) {
+		if err := fences.err(); err != nil {
+			return nil, err
+		}
+		return fn(m, args)
+	}
+}
+
+// DISKFENCE ADD node free_percent
+// DISKFENCE DEL node
+// Fences the writes of the cluster while a member is short of disk, or lifts
+// its fence. The disk guard of the leader proposes them, the clients cannot
+// run it.
+func cmdDISKFENCE(m rafthub.Machine, args []string) (interface{}, error) {
+	if len(args) < 3 {
+		return nil, rafthub.ErrWrongNumArgs
+	}
+	switch strings.ToLower(args[1]) {
+	case "add":
+		if len(args) != 4 {
+			return nil, rafthub.ErrWrongNumArgs
+		}
+		pct, err := strconv.ParseFloat(args[3], 64)
+		if err != nil || pct < 0 {
+			return nil, errors.New("ERR invalid free percent")
+		}
+		if err := fences.set(args[2], pct); err != nil {
+			return nil, err
+		}
+	case "del":
+		if len(args) != 3 {
+			return nil, rafthub.ErrWrongNumArgs
+		}
+		if err := fences.set(args[2], -1); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("ERR unknown DISKFENCE subcommand '%s'", args[1])
+	}
+	return "OK", nil
+}
+
+// DISKGUARD
+// Returns the free space of the data directory of the node, the thresholds
+// and the fenced members.
+func cmdDISKGUARD(m rafthub.Machine, args []string) (interface{}, error) {
+	if len(args) != 1 {
+		return nil, rafthub.ErrWrongNumArgs
+	}
+	var buf bytes.Buffer
+	buf.WriteString("# Diskguard\r\n")
+	nodes := fences.list()
+	readonly := 0
+	if len(nodes) > 0 {
+		readonly = 1
+	}
+	fmt.Fprintf(&buf, "readonly:%d\r\n", readonly)
+	if pct := math.Float64frombits(atomic.LoadUint64(&diskFreePct)); !math.IsNaN(pct) {
+		fmt.Fprintf(&buf, "disk_free_percent:%.2f\r\n", pct)
+	}
+	fmt.Fprintf(&buf, "readonly_threshold_percent:%g\r\n", readonlyDiskFree)
+	fmt.Fprintf(&buf, "resume_threshold_percent:%g\r\n", readonlyDiskResume)
+	fmt.Fprintf(&buf, "fenced_nodes:%d\r\n", len(nodes))
+	for i, node := range nodes {
+		pct, _ := fences.get(node)
+		fmt.Fprintf(&buf, "fence%d:node=%s,free_percent=%.2f\r\n", i, node, pct)
+	}
+	return buf.String(), nil
+}
+
+// diskGuard checks the free space of the data directory every interval
+// until stop is closed, and compacts the storage under --readonly-disk-free.
+// On the leader it also fences the writes of the cluster while a member is
+// short of disk, and lifts the fence once it is back over its
+// --readonly-disk-resume.
+func diskGuard(interval time.Duration, stop <-chan struct{}) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		checkDisk()
+		select {
+		case <-stop:
+			return
+		case <-t.C:
+		}
+	}
+}
+
+func checkDisk() {
+	total, free, err := diskUsage(conf.DataDir)
+	if err != nil || total == 0 {
+		return
+	}
+	pct := 100 * float64(free) / float64(total)
+	last := math.Float64frombits(atomic.SwapUint64(&diskFreePct, math.Float64bits(pct)))
+	if pct < readonlyDiskFree && !(last < readonlyDiskFree) {
+		go compactStore()
+	}
+	members, err := clusterMembers()
+	if err != nil {
+		return
+	}
+	if leader := leaderOf(members); leader != nil && leader.id == conf.NodeID {
+		fenceMembers(members)
+	}
+}
+
+// memberDisk is the free space percentage of a member and its thresholds
+type memberDisk struct {
+	free, readonly, resume float64
+}
+
+// diskOf returns the free space of a member, read from its DISKGUARD
+func diskOf(m clusterMember) (memberDisk, error) {
+	if m.id == conf.NodeID {
+		return memberDisk{
+			free:     math.Float64frombits(atomic.LoadUint64(&diskFreePct)),
+			readonly: readonlyDiskFree,
+			resume:   readonlyDiskResume,
+		}, nil
+	}
+	c, err := rafthub.RedisDial(net.JoinHostPort(m.host, m.port), conf.Auth, serverTLS)
+	if err != nil {
+		return memberDisk{}, err
+	}
+	defer c.Close()
+	reply, err := c.Do("diskguard")
+	if err != nil {
+		return memberDisk{}, err
+	}
+	info, ok := reply.([]byte)
+	if !ok {
+		return memberDisk{}, fmt.Errorf("unexpected DISKGUARD reply %T", reply)
+	}
+	d := memberDisk{free: math.NaN()}
+	for _, line := range strings.Split(string(info), "\r\n") {
+		k, v, _ := strings.Cut(line, ":")
+		var field *float64
+		switch k {
+		case "disk_free_percent":
+			field = &d.free
+		case "readonly_threshold_percent":
+			field = &d.readonly
+		case "resume_threshold_percent":
+			field = &d.resume
+		default:
+			continue
+		}
+		if *field, err = strconv.ParseFloat(v, 64); err != nil {
+			return memberDisk{}, fmt.Errorf("DISKGUARD %s: %w", k, err)
+		}
+	}
+	return d, nil
+}
+
+// fenceMembers proposes the fences of the members short of disk and lifts
+// those of the members back over their threshold or removed from the
+// cluster. Only the leader proposes them, DISKFENCE is refused to the
+// clients. A member that cannot be reached keeps its fence.
+func fenceMembers(members []clusterMember) {
+	ids := make(map[string]bool, len(members))
+	for _, m := range members {
+		ids[m.id] = true
+		d, err := diskOf(m)
+		if err != nil {
+			loggers[logServer].Debug("disk guard of a member", zap.String("node", m.id), zap.Error(err))
+			continue
+		}
+		if math.IsNaN(d.free) {
+			continue
+		}
+		data := map[string]interface{}{"node": m.id, "free_percent": d.free}
+		_, isFenced := fences.get(m.id)
+		switch {
+		case !isFenced && d.free < d.readonly:
+			if _, err := localDo("diskfence", "add", m.id, strconv.FormatFloat(d.free, 'f', 2, 64)); err != nil {
+				loggers[logServer].Warn("fencing the writes", zap.String("node", m.id), zap.Error(err))
+				continue
+			}
+			data["threshold_percent"] = d.readonly
+			events.Publish(eventWritesFenced, data)
+		case isFenced && d.free >= d.resume:
+			if _, err := localDo("diskfence", "del", m.id); err != nil {
+				loggers[logServer].Warn("lifting the write fence", zap.String("node", m.id), zap.Error(err))
+				continue
+			}
+			data["threshold_percent"] = d.resume
+			events.Publish(eventWritesResumed, data)
+		}
+	}
+	for _, node := range fences.list() {
+		if ids[node] {
+			continue
+		}
+		if _, err := localDo("diskfence", "del", node); err != nil {
+			loggers[logServer].Warn("lifting the write fence", zap.String("node", node), zap.Error(err))
+			continue
+		}
+		events.Publish(eventWritesResumed, map[string]interface{}{"node": node, "removed": true})
+	}
+}
+
+// compactStore reclaims the space of the deleted and overwritten data
+func compactStore() {
+	start := time.Now()
+	if err := le.CompactStore(); err != nil {
+		loggers[logServer].Error("compaction under disk pressure", zap.Error(err))
+		return
+	}
+	loggers[logServer].Info("compaction under disk pressure", zap.Duration("elapsed", time.Since(start)))
+}
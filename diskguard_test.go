@@ -0,0 +1,106 @@
+//go:build alltest
+// +build alltest
+
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDiskFence(t *testing.T) {
+	c := getTestConn()
+	ctx := context.Background()
+	fence := func(args ...string) error {
+		_, err := cmdDISKFENCE(nil, append([]string{"diskfence"}, args...))
+		return err
+	}
+	defer fence("del", "gone")
+
+	if err := c.Set(ctx, "diskfence:a", "1", 0).Err(); err != nil {
+		t.Fatal(err)
+	}
+	// only the leader proposes the fences
+	if err := c.Do(ctx, "DISKFENCE", "ADD", "gone", "1.5").Err(); err == nil || !strings.HasPrefix(err.Error(), "NOPERM") {
+		t.Errorf("DISKFENCE of a client: %v", err)
+	}
+	if err := fence("add", "gone", "1.5"); err != nil {
+		t.Fatal(err)
+	}
+	err := c.Set(ctx, "diskfence:b", "1", 0).Err()
+	if err == nil || !strings.HasPrefix(err.Error(), "READONLY ") || !strings.Contains(err.Error(), "node gone") {
+		t.Errorf("write on a fenced cluster: %v", err)
+	}
+	// the reads and the writes freeing space still run
+	if v, err := c.Get(ctx, "diskfence:a").Result(); err != nil || v != "1" {
+		t.Errorf("read on a fenced cluster: %q %v", v, err)
+	}
+	if err := c.Del(ctx, "diskfence:a").Err(); err != nil {
+		t.Errorf("delete on a fenced cluster: %v", err)
+	}
+
+	info, err := c.Do(ctx, "DISKGUARD").Text()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fields := infoFields(info)
+	if fields["readonly"] != "1" || fields["fence0"] != "node=gone,free_percent=1.50" {
+		t.Errorf("DISKGUARD %q", info)
+	}
+
+	// the fences are kept in the storage
+	if err := fences.load(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := fences.get("gone"); !ok {
+		t.Error("the fence must be reloaded from the storage")
+	}
+
+	if err := fence("del", "gone"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Set(ctx, "diskfence:b", "1", 0).Err(); err != nil {
+		t.Errorf("write once the fence is lifted: %v", err)
+	}
+}
+
+func TestDiskGuard(t *testing.T) {
+	getTestConn()
+	bus, unsubscribe := events.Subscribe(16)
+	defer unsubscribe()
+	defer func(free, resume float64) { readonlyDiskFree, readonlyDiskResume = free, resume }(readonlyDiskFree, readonlyDiskResume)
+
+	// the fence of a member removed is lifted
+	if _, err := cmdDISKFENCE(nil, []string{"diskfence", "add", "gone", "1"}); err != nil {
+		t.Fatal(err)
+	}
+	// any free space is under 100%
+	readonlyDiskFree, readonlyDiskResume = 100, 100
+	checkDisk()
+	if _, ok := fences.get(conf.NodeID); !ok {
+		t.Fatal("the node must fence the writes under the threshold")
+	}
+	if _, ok := fences.get("gone"); ok {
+		t.Fatal("the fence of a member removed must be lifted")
+	}
+	readonlyDiskFree, readonlyDiskResume = 0, 0
+	checkDisk()
+	if _, ok := fences.get(conf.NodeID); ok {
+		t.Fatal("the node must lift its fence over the threshold")
+	}
+
+	want := []string{eventWritesFenced, eventWritesResumed}
+	timeout := time.After(5 * time.Second)
+	for len(want) > 0 {
+		select {
+		case e := <-bus:
+			if e.Type == want[0] {
+				want = want[1:]
+			}
+		case <-timeout:
+			t.Fatalf("events %v not published", want)
+		}
+	}
+}
@@ -84,11 +84,9 @@ func (db *DB) NewWriteBatch() driver.IWriteBatch {
 
 func (db *DB) NewIterator() driver.IIterator {
 	tnx := db.db.NewTransaction(false)
-	opts := db.iteratorOpts
-	opts.PrefetchSize = 100 // Optimized prefetch
 	it := &Iterator{
 		db:  db.db,
-		it:  tnx.NewIterator(opts),
+		it:  tnx.NewIterator(db.iteratorOpts),
 		txn: tnx,
 	}
 	return it
@@ -106,8 +104,18 @@ func (db *DB) NewStream() *badger.Stream {
 	return db.db.NewStream()
 }
 
+// Compact runs the garbage collection of the value log until it has
+// nothing left to rewrite, the LSM tree compacts itself
 func (db *DB) Compact() error {
-	return nil
+	for {
+		switch err := db.db.RunValueLogGC(0.5); err {
+		case nil:
+		case badger.ErrNoRewrite, badger.ErrRejected:
+			return nil
+		default:
+			return err
+		}
+	}
 }
 
 func (db *DB) GetStorageEngine() interface{} {
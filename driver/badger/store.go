@@ -1,6 +1,8 @@
 package badger
 
 import (
+	"math"
+
 	"github.com/dgraph-io/badger/v4"
 	"github.com/dgraph-io/badger/v4/options"
 	"github.com/ledisdb/ledisdb/config"
@@ -9,7 +11,27 @@ import (
 
 const StorageName = "badger"
 
-const defaultFilterBits int = 10
+const (
+	defaultFilterBits     int   = 10
+	defaultBlockCacheSize int64 = 1000 << 20
+	defaultPrefetchSize   int   = 100
+)
+
+type Config struct {
+	// size of the block cache in bytes
+	BlockCacheSize int64
+	// bits per key of the bloom filters of the tables
+	FilterBits int
+	// values an iterator reads ahead of its position, 0 reads them as the
+	// iterator reaches them
+	PrefetchSize int
+}
+
+var DefaultConfig = Config{
+	BlockCacheSize: defaultBlockCacheSize,
+	FilterBits:     defaultFilterBits,
+	PrefetchSize:   defaultPrefetchSize,
+}
 
 var _ driver.Store = (*Store)(nil)
 
@@ -37,9 +59,21 @@ func (s Store) Open(path string, cfg *config.Config) (driver.IDB, error) {
 	db.opts.DetectConflicts = false
 	db.opts.NumCompactors = 100
 	db.opts.NumMemtables = 100
-	db.opts.BlockCacheSize = 1000 << 20
+	db.opts.BlockCacheSize = DefaultConfig.BlockCacheSize
+	if db.opts.BlockCacheSize <= 0 {
+		db.opts.BlockCacheSize = defaultBlockCacheSize
+	}
+	bits := DefaultConfig.FilterBits
+	if bits <= 0 {
+		bits = defaultFilterBits
+	}
+	// the false positive rate of a bloom filter of that many bits per key
+	// with the optimal number of hashes
+	db.opts.BloomFalsePositive = math.Pow(0.6185, float64(bits))
 
 	db.iteratorOpts = badger.DefaultIteratorOptions
+	db.iteratorOpts.PrefetchValues = DefaultConfig.PrefetchSize > 0
+	db.iteratorOpts.PrefetchSize = DefaultConfig.PrefetchSize
 	var err error
 	db.db, err = badger.Open(db.opts)
 	if err != nil {
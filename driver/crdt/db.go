@@ -7,6 +7,7 @@ import (
 	"io/fs"
 	"log"
 	"os"
+	"time"
 	"unicode/utf8"
 
 	"github.com/IceFireDB/icefiredb-crdt-kv/kv"
@@ -14,6 +15,8 @@ import (
 	"github.com/ipfs/go-datastore"
 	"github.com/ledisdb/ledisdb/config"
 	"github.com/ledisdb/ledisdb/store/driver"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
 	"github.com/sirupsen/logrus"
 	"github.com/syndtr/goleveldb/leveldb"
 	"github.com/syndtr/goleveldb/leveldb/filter"
@@ -31,12 +34,15 @@ type Config struct {
 	ServiceName         string
 	DataSyncChannel     string
 	NetDiscoveryChannel string
+	// StatusInterval is how often the node announces its replication state
+	StatusInterval time.Duration
 }
 
 var DefaultConfig = Config{
 	ServiceName:         "icefiredb",
 	DataSyncChannel:     "icefiredb-data",
 	NetDiscoveryChannel: "icefiredb-net",
+	StatusInterval:      5 * time.Second,
 }
 
 func init() {
@@ -58,6 +64,7 @@ func (s Store) Open(path string, cfg *config.Config) (driver.IDB, error) {
 	db := new(DB)
 	db.ctx = context.TODO()
 	db.path = path
+	db.status = newReplication()
 	var err error
 	kvcfg := kv.Config{
 		NodeServiceName:     DefaultConfig.ServiceName,
@@ -66,6 +73,16 @@ func (s Store) Open(path string, cfg *config.Config) (driver.IDB, error) {
 		NetDiscoveryChannel: DefaultConfig.NetDiscoveryChannel,
 		Namespace:           defaultNamespace,
 		Logger:              logrus.New(),
+		PutHook: func(k datastore.Key, v []byte) {
+			if id, ok := isStatusKey(k.String()); ok {
+				db.status.merged(id, v)
+			}
+		},
+		DeleteHook: func(k datastore.Key) {
+			if id, ok := isStatusKey(k.String()); ok {
+				db.status.deleted(id)
+			}
+		},
 	}
 
 	db.db, err = kv.NewCRDTKeyValueDB(context.TODO(), kvcfg)
@@ -82,6 +99,18 @@ func (s Store) Open(path string, cfg *config.Config) (driver.IDB, error) {
 	db.namespace = kvcfg.Namespace
 	db.iteratorOpts = badger.DefaultIteratorOptions
 
+	id, err := nodeID(db.db)
+	if err != nil {
+		return nil, err
+	}
+	last, err := db.db.Get(db.ctx, []byte(statusPrefix+id))
+	if err != nil && err != datastore.ErrNotFound {
+		return nil, err
+	}
+	db.status.start(id, last, DefaultConfig.StatusInterval, func(key string, value []byte) error {
+		return db.db.Put(db.ctx, []byte(key), value)
+	})
+
 	return db, nil
 }
 
@@ -112,6 +141,23 @@ func newOptions(cfg *config.LevelDBConfig) *opt.Options {
 	return opts
 }
 
+// nodeID returns the peer id of the libp2p host of the CRDT
+func nodeID(db *kv.CRDTKeyValueDB) (string, error) {
+	data, err := db.MarshalPrivateKey()
+	if err != nil {
+		return "", err
+	}
+	key, err := crypto.UnmarshalPrivateKey(data)
+	if err != nil {
+		return "", err
+	}
+	id, err := peer.IDFromPrivateKey(key)
+	if err != nil {
+		return "", err
+	}
+	return id.String(), nil
+}
+
 func (s Store) Repair(path string, cfg *config.Config) error {
 	db, err := s.Open(path, cfg)
 	if err != nil {
@@ -127,6 +173,7 @@ type DB struct {
 	namespace    string
 	leveldb      *leveldb.DB
 	iteratorOpts badger.IteratorOptions
+	status       *replication
 }
 
 func (d *DB) GetLevelDB() *leveldb.DB {
@@ -138,6 +185,7 @@ func (s *DB) GetStorageEngine() interface{} {
 }
 
 func (db *DB) Close() error {
+	db.status.close()
 	db.db.Close()
 	return nil
 }
@@ -150,8 +198,10 @@ func (db *DB) Put(key, value []byte) error {
 	err := db.db.Put(db.ctx, k, value)
 	if err != nil {
 		log.Println("err", err, key, k, value, string(value))
+		return err
 	}
-	return err
+	db.status.write()
+	return nil
 }
 
 func (db *DB) Get(key []byte) ([]byte, error) {
@@ -165,7 +215,11 @@ func (db *DB) Get(key []byte) ([]byte, error) {
 
 func (db *DB) Delete(key []byte) error {
 	key = db.EncodeKey(key)
-	return db.db.Delete(db.ctx, key)
+	if err := db.db.Delete(db.ctx, key); err != nil {
+		return err
+	}
+	db.status.write()
+	return nil
 }
 
 func (db *DB) SyncPut(key []byte, value []byte) error {
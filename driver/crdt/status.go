@@ -0,0 +1,263 @@
+package crdt
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// The nodes announce their replication state in the CRDT itself, under
+// statusPrefix. The ledis keys start with their database index, they never
+// collide with it.
+const statusPrefix = "_crdt_status/"
+
+// keepAliveTicks is the number of ticks after which a node announces itself
+// even if nothing changed, so that its peers see it alive
+const keepAliveTicks = 10
+
+// announcement is the replication state a node writes under statusPrefix
+type announcement struct {
+	// Clock is the number of local writes of the node
+	Clock uint64 `json:"clock"`
+	// Time is when the node wrote the announcement, in unix nanoseconds
+	Time int64 `json:"time"`
+	// Seen is the clock of each peer whose announcement the node merged
+	Seen map[string]uint64 `json:"seen,omitempty"`
+}
+
+// PeerStatus is the replication state of a peer seen from this node
+type PeerStatus struct {
+	ID string
+	// Clock is the clock of the last announcement of the peer merged here
+	Clock uint64
+	// Known is the highest clock of the peer reported by any node
+	Known uint64
+	// Sent is when the peer wrote its last announcement merged here
+	Sent time.Time
+	// Seen is when its last announcement was merged here
+	Seen time.Time
+	// behind is since when Known is over Clock
+	behind time.Time
+}
+
+// Unmerged is the estimated number of operations of the peer that other
+// nodes merged and this node did not yet
+func (p PeerStatus) Unmerged() uint64 {
+	if p.Known > p.Clock {
+		return p.Known - p.Clock
+	}
+	return 0
+}
+
+// Lag is the estimated convergence lag with the peer: the delay of its last
+// announcement, or how long this node has been behind it if longer
+func (p PeerStatus) Lag(now time.Time) time.Duration {
+	lag := p.Seen.Sub(p.Sent)
+	if lag < 0 {
+		// clocks skew
+		lag = 0
+	}
+	if p.Unmerged() > 0 && !p.behind.IsZero() && now.Sub(p.behind) > lag {
+		lag = now.Sub(p.behind)
+	}
+	return lag
+}
+
+// Status is the replication state of the node
+type Status struct {
+	ID string
+	// Clock is the number of local writes of the node
+	Clock uint64
+	Peers []PeerStatus
+}
+
+// Unmerged is the estimated number of operations of every peer not merged
+// yet
+func (s Status) Unmerged() uint64 {
+	var n uint64
+	for _, p := range s.Peers {
+		n += p.Unmerged()
+	}
+	return n
+}
+
+// Lag is the highest estimated convergence lag with the peers
+func (s Status) Lag(now time.Time) time.Duration {
+	var lag time.Duration
+	for _, p := range s.Peers {
+		if l := p.Lag(now); l > lag {
+			lag = l
+		}
+	}
+	return lag
+}
+
+// replication tracks the announcements of the peers and announces the state
+// of this node
+type replication struct {
+	mu    sync.Mutex
+	id    string
+	clock uint64
+	peers map[string]*PeerStatus
+	// changed is set when the announcement of this node is out of date
+	changed bool
+	// put writes an announcement to the CRDT
+	put  func(key string, value []byte) error
+	stop chan struct{}
+}
+
+func newReplication() *replication {
+	return &replication{
+		peers:   make(map[string]*PeerStatus),
+		changed: true,
+		stop:    make(chan struct{}),
+	}
+}
+
+// isStatusKey returns whether a CRDT key is an announcement and of which
+// node
+func isStatusKey(key string) (string, bool) {
+	return strings.CutPrefix(strings.TrimPrefix(key, "/"), statusPrefix)
+}
+
+// start restores the clock of the node from its last announcement and
+// announces the node every interval
+func (r *replication) start(id string, last []byte, interval time.Duration, put func(key string, value []byte) error) {
+	r.mu.Lock()
+	r.id = id
+	r.put = put
+	var a announcement
+	if len(last) > 0 && json.Unmarshal(last, &a) == nil {
+		r.clock = a.Clock
+	}
+	r.mu.Unlock()
+
+	go func() {
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for ticks := 0; ; ticks++ {
+			select {
+			case <-r.stop:
+				return
+			case <-t.C:
+			}
+			if err := r.announce(ticks%keepAliveTicks == 0); err != nil {
+				logrus.Warnf("crdt status announcement: %v", err)
+			}
+		}
+	}()
+}
+
+// close stops the announcements
+func (r *replication) close() {
+	close(r.stop)
+}
+
+// write counts a local write
+func (r *replication) write() {
+	r.mu.Lock()
+	r.clock++
+	r.changed = true
+	r.mu.Unlock()
+}
+
+// announce writes the announcement of the node if it changed since the last
+// one, or if force is set
+func (r *replication) announce(force bool) error {
+	r.mu.Lock()
+	if r.put == nil || (!r.changed && !force) {
+		r.mu.Unlock()
+		return nil
+	}
+	a := announcement{Clock: r.clock, Time: time.Now().UnixNano(), Seen: make(map[string]uint64, len(r.peers))}
+	for id, p := range r.peers {
+		a.Seen[id] = p.Clock
+	}
+	r.changed = false
+	put := r.put
+	r.mu.Unlock()
+
+	value, err := json.Marshal(a)
+	if err != nil {
+		return err
+	}
+	return put(statusPrefix+r.id, value)
+}
+
+// merged handles the announcement of a node merged in the CRDT
+func (r *replication) merged(id string, value []byte) {
+	var a announcement
+	if err := json.Unmarshal(value, &a); err != nil {
+		return
+	}
+	now := time.Now()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if id == r.id {
+		return
+	}
+	p := r.peer(id)
+	if a.Clock >= p.Clock {
+		p.Clock = a.Clock
+		p.Sent = time.Unix(0, a.Time)
+		p.Seen = now
+		r.changed = true
+	}
+	r.known(p, a.Clock, now)
+	for peerID, clock := range a.Seen {
+		if peerID != r.id {
+			r.known(r.peer(peerID), clock, now)
+		}
+	}
+}
+
+// deleted handles the deletion of the announcement of a node, by FLUSHALL
+func (r *replication) deleted(id string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if id == r.id {
+		// announce again at the next tick
+		r.changed = true
+	}
+}
+
+func (r *replication) peer(id string) *PeerStatus {
+	p, ok := r.peers[id]
+	if !ok {
+		p = &PeerStatus{ID: id}
+		r.peers[id] = p
+	}
+	return p
+}
+
+// known records a clock of a peer reported by a node
+func (r *replication) known(p *PeerStatus, clock uint64, now time.Time) {
+	if clock > p.Known {
+		if p.Known <= p.Clock {
+			p.behind = now
+		}
+		p.Known = clock
+	}
+}
+
+// status returns the replication state of the node, the peers sorted by id
+func (r *replication) status() Status {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s := Status{ID: r.id, Clock: r.clock, Peers: make([]PeerStatus, 0, len(r.peers))}
+	for _, p := range r.peers {
+		s.Peers = append(s.Peers, *p)
+	}
+	sort.Slice(s.Peers, func(i, j int) bool { return s.Peers[i].ID < s.Peers[j].ID })
+	return s
+}
+
+// ReplicationStatus returns the replication state of the node and of the
+// peers it heard of
+func (db *DB) ReplicationStatus() Status {
+	return db.status.status()
+}
@@ -0,0 +1,73 @@
+package crdt
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestReplicationStatus(t *testing.T) {
+	r := newReplication()
+	defer r.close()
+	written := make(map[string][]byte)
+	r.start("self", []byte(`{"clock":41}`), time.Hour, func(key string, value []byte) error {
+		written[key] = value
+		return nil
+	})
+	r.write()
+	if st := r.status(); st.Clock != 42 {
+		t.Fatalf("clock %d, want the restored one plus the write", st.Clock)
+	}
+
+	announce := func(id string, a announcement) {
+		value, _ := json.Marshal(a)
+		r.merged(id, value)
+	}
+	sent := time.Now().Add(-time.Second)
+	announce("a", announcement{Clock: 10, Time: sent.UnixNano()})
+	// b merged 15 operations of a, this node only 10
+	announce("b", announcement{Clock: 3, Time: sent.UnixNano(), Seen: map[string]uint64{"a": 15, "self": 42}})
+
+	st := r.status()
+	if len(st.Peers) != 2 || st.Peers[0].ID != "a" || st.Peers[1].ID != "b" {
+		t.Fatalf("peers %+v", st.Peers)
+	}
+	a := st.Peers[0]
+	if a.Clock != 10 || a.Known != 15 || a.Unmerged() != 5 || st.Unmerged() != 5 {
+		t.Errorf("peer a %+v", a)
+	}
+	if lag := a.Lag(time.Now()); lag < time.Second {
+		t.Errorf("lag %v, want at least the delay of the announcement", lag)
+	}
+
+	// the next announcement of a catches up
+	announce("a", announcement{Clock: 15, Time: time.Now().UnixNano()})
+	if st := r.status(); st.Unmerged() != 0 {
+		t.Errorf("unmerged %d", st.Unmerged())
+	}
+
+	if err := r.announce(false); err != nil {
+		t.Fatal(err)
+	}
+	var own announcement
+	if err := json.Unmarshal(written[statusPrefix+"self"], &own); err != nil {
+		t.Fatal(err)
+	}
+	if own.Clock != 42 || own.Seen["a"] != 15 || own.Seen["b"] != 3 {
+		t.Errorf("announcement %+v", own)
+	}
+	// nothing changed since
+	delete(written, statusPrefix+"self")
+	if err := r.announce(false); err != nil || len(written) != 0 {
+		t.Errorf("announced again without change: %v", err)
+	}
+}
+
+func TestIsStatusKey(t *testing.T) {
+	if id, ok := isStatusKey("/" + statusPrefix + "peer"); !ok || id != "peer" {
+		t.Errorf("%q %v", id, ok)
+	}
+	if _, ok := isStatusKey("/\x00\x01key"); ok {
+		t.Error("a ledis key is not an announcement")
+	}
+}
@@ -24,10 +24,16 @@ const (
 
 type Config struct {
 	HotCacheSize int64
+	// bits per key of the bloom filters of the tables
+	FilterBits int
+	// the blocks read by the iterators are kept in the block cache, so a
+	// range read again is served from memory
+	IteratorFillCache bool
 }
 
 var DefaultConfig = Config{
 	HotCacheSize: defaultHotCacheSize,
+	FilterBits:   defaultFilterBits,
 }
 
 func init() {
@@ -113,7 +119,7 @@ func (db *DB) initOpts() {
 	db.opts = newOptions(db.cfg)
 
 	db.iteratorOpts = &opt.ReadOptions{}
-	db.iteratorOpts.DontFillCache = true
+	db.iteratorOpts.DontFillCache = !DefaultConfig.IteratorFillCache
 
 	db.syncOpts = &opt.WriteOptions{}
 	db.syncOpts.Sync = true
@@ -126,7 +132,11 @@ func newOptions(cfg *config.LevelDBConfig) *opt.Options {
 	opts.BlockCacheCapacity = cfg.CacheSize
 
 	// we must use bloomfilter
-	opts.Filter = filter.NewBloomFilter(defaultFilterBits)
+	bits := DefaultConfig.FilterBits
+	if bits <= 0 {
+		bits = defaultFilterBits
+	}
+	opts.Filter = filter.NewBloomFilter(bits)
 
 	if !cfg.Compression {
 		opts.Compression = opt.NoCompression
@@ -232,6 +242,11 @@ func (db *DB) Compact() error {
 	})
 }
 
+// CacheStats returns the hits and the misses of the hot cache
+func (db *DB) CacheStats() (hits, misses uint64) {
+	return db.cache.Metrics.Hits(), db.cache.Metrics.Misses()
+}
+
 func (db *DB) Metrics() (tit string, metrics []map[string]interface{}) {
 	tit = "hybriddb cache"
 	costAdd := db.cache.Metrics.CostAdded()
@@ -10,6 +10,7 @@ import (
 	"github.com/IceFireDB/icefiredb-ipfs-log/stores/levelkv"
 	"github.com/ledisdb/ledisdb/config"
 	"github.com/ledisdb/ledisdb/store/driver"
+	p2pmetrics "github.com/libp2p/go-libp2p/core/metrics"
 	ma "github.com/multiformats/go-multiaddr"
 	"github.com/syndtr/goleveldb/leveldb"
 	"github.com/syndtr/goleveldb/leveldb/opt"
@@ -74,9 +75,12 @@ func (s Store) Open(path string, cfg *config.Config) (driver.IDB, error) {
 		fmt.Println(a.Encapsulate(hostAddr).String())
 	}
 	fmt.Println("multi node communication identifier:", Dbname)
+	if node.Reporter != nil {
+		db.bandwidth = node.Reporter
+	}
 
 	// Initialize the logger
-	logger := zap.NewNop()
+	logger := Logger.With(zap.String("peer_id", node.PeerHost.ID().String()))
 
 	// Create the IPFS log
 	ev, err := iflog.NewIpfsLog(ctx, api, Dbname, &iflog.EventOptions{
@@ -121,6 +125,15 @@ type DB struct {
 	db           *levelkv.LevelKV
 	iteratorOpts *opt.ReadOptions
 	leveldb      *leveldb.DB
+	bandwidth    p2pmetrics.Reporter
+}
+
+// Bandwidth returns the traffic of the IPFS node
+func (db *DB) Bandwidth() p2pmetrics.Stats {
+	if db.bandwidth == nil {
+		return p2pmetrics.Stats{}
+	}
+	return db.bandwidth.GetBandwidthTotals()
 }
 
 // GetLevelDB returns the underlying leveldb instance
@@ -0,0 +1,6 @@
+package ipfs_log
+
+import "go.uber.org/zap"
+
+// Logger logs the p2p network of the driver, it logs nothing until set
+var Logger = zap.NewNop()
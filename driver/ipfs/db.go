@@ -255,6 +255,11 @@ func (db *DB) Compact() error {
 	})
 }
 
+// CacheStats returns the hits and the misses of the hot cache
+func (db *DB) CacheStats() (hits, misses uint64) {
+	return db.cache.Metrics.Hits(), db.cache.Metrics.Misses()
+}
+
 func (db *DB) Metrics() (tit string, metrics []map[string]interface{}) {
 	tit = "hybriddb cache"
 	costAdd := db.cache.Metrics.CostAdded()
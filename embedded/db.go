@@ -0,0 +1,245 @@
+// Package embedded runs the storage of IceFireDB in the process of a Go
+// application, without a network hop. The data is kept by the engines of
+// the server, with the same layout, but the writes do not go through a raft
+// log: an embedded database belongs to a single process. The crdt engine
+// still replicates it with the other nodes of its P2P channels.
+package embedded
+
+import (
+	"errors"
+	"time"
+
+	lediscfg "github.com/ledisdb/ledisdb/config"
+	"github.com/ledisdb/ledisdb/ledis"
+
+	// the engines of the server, goleveldb is built in ledis
+	_ "github.com/IceFireDB/IceFireDB/driver/badger"
+	_ "github.com/IceFireDB/IceFireDB/driver/crdt"
+	_ "github.com/IceFireDB/IceFireDB/driver/hybriddb"
+)
+
+// DefaultEngine is the engine of a Config without one
+const DefaultEngine = "goleveldb"
+
+// Config is the configuration of an embedded database
+type Config struct {
+	// DataDir is the directory of the data, created when missing
+	DataDir string
+	// Engine is the storage engine: goleveldb, badger, hybriddb or crdt.
+	// The crdt engine runs a P2P node, configured by crdt.DefaultConfig,
+	// which replicates the data with the nodes of its channels.
+	Engine string
+}
+
+// DB is an embedded database, its methods are safe for concurrent use. A
+// missing key, field or element is returned as a nil value.
+type DB struct {
+	le *ledis.Ledis
+	db *ledis.DB
+}
+
+// Open opens the database of the config
+func Open(cfg Config) (*DB, error) {
+	if cfg.DataDir == "" {
+		return nil, errors.New("embedded: no data directory")
+	}
+	lcfg := lediscfg.NewConfigDefault()
+	lcfg.DataDir = cfg.DataDir
+	lcfg.Databases = 1
+	lcfg.DBName = cfg.Engine
+	if lcfg.DBName == "" {
+		lcfg.DBName = DefaultEngine
+	}
+	le, err := ledis.Open(lcfg)
+	if err != nil {
+		return nil, err
+	}
+	db, err := le.Select(0)
+	if err != nil {
+		le.Close()
+		return nil, err
+	}
+	return &DB{le: le, db: db}, nil
+}
+
+// Close closes the database and its engine
+func (d *DB) Close() {
+	d.le.Close()
+}
+
+// Get returns the value of a key
+func (d *DB) Get(key string) ([]byte, error) {
+	return d.db.Get([]byte(key))
+}
+
+// Set sets the value of a key, and removes its expiration
+func (d *DB) Set(key string, value []byte) error {
+	return d.db.Set([]byte(key), value)
+}
+
+// SetEX sets the value of a key, which expires after ttl, rounded down to
+// the second
+func (d *DB) SetEX(key string, value []byte, ttl time.Duration) error {
+	return d.db.SetEX([]byte(key), int64(ttl/time.Second), value)
+}
+
+// Del deletes the keys and returns how many were deleted
+func (d *DB) Del(keys ...string) (int64, error) {
+	return d.db.Del(bytesOf(keys)...)
+}
+
+// Exists returns whether a key exists
+func (d *DB) Exists(key string) (bool, error) {
+	n, err := d.db.Exists([]byte(key))
+	return n == 1, err
+}
+
+// Incr increments the integer value of a key and returns it
+func (d *DB) Incr(key string) (int64, error) {
+	return d.db.Incr([]byte(key))
+}
+
+// Expire sets the time to live of a key, rounded down to the second, and
+// returns whether the key exists
+func (d *DB) Expire(key string, ttl time.Duration) (bool, error) {
+	n, err := d.db.Expire([]byte(key), int64(ttl/time.Second))
+	return n == 1, err
+}
+
+// TTL returns the time to live of a key, -1s when it does not expire or
+// does not exist
+func (d *DB) TTL(key string) (time.Duration, error) {
+	n, err := d.db.TTL([]byte(key))
+	return time.Duration(n) * time.Second, err
+}
+
+// HGet returns the value of a field of a hash
+func (d *DB) HGet(key, field string) ([]byte, error) {
+	return d.db.HGet([]byte(key), []byte(field))
+}
+
+// HSet sets a field of a hash and returns 1 when it is new
+func (d *DB) HSet(key, field string, value []byte) (int64, error) {
+	return d.db.HSet([]byte(key), []byte(field), value)
+}
+
+// HGetAll returns the fields of a hash
+func (d *DB) HGetAll(key string) (map[string][]byte, error) {
+	pairs, err := d.db.HGetAll([]byte(key))
+	if err != nil {
+		return nil, err
+	}
+	m := make(map[string][]byte, len(pairs))
+	for _, p := range pairs {
+		m[string(p.Field)] = p.Value
+	}
+	return m, nil
+}
+
+// HDel deletes fields of a hash and returns how many were deleted
+func (d *DB) HDel(key string, fields ...string) (int64, error) {
+	return d.db.HDel([]byte(key), bytesOf(fields)...)
+}
+
+// LPush prepends values to a list and returns its length
+func (d *DB) LPush(key string, values ...[]byte) (int64, error) {
+	return d.db.LPush([]byte(key), values...)
+}
+
+// RPush appends values to a list and returns its length
+func (d *DB) RPush(key string, values ...[]byte) (int64, error) {
+	return d.db.RPush([]byte(key), values...)
+}
+
+// LPop removes and returns the first element of a list
+func (d *DB) LPop(key string) ([]byte, error) {
+	return d.db.LPop([]byte(key))
+}
+
+// RPop removes and returns the last element of a list
+func (d *DB) RPop(key string) ([]byte, error) {
+	return d.db.RPop([]byte(key))
+}
+
+// LRange returns the elements of a list from start to stop included, the
+// negative indexes count from the end as in LRANGE
+func (d *DB) LRange(key string, start, stop int32) ([][]byte, error) {
+	return d.db.LRange([]byte(key), start, stop)
+}
+
+// LLen returns the length of a list
+func (d *DB) LLen(key string) (int64, error) {
+	return d.db.LLen([]byte(key))
+}
+
+// SAdd adds members to a set and returns how many were new
+func (d *DB) SAdd(key string, members ...string) (int64, error) {
+	return d.db.SAdd([]byte(key), bytesOf(members)...)
+}
+
+// SRem removes members of a set and returns how many were removed
+func (d *DB) SRem(key string, members ...string) (int64, error) {
+	return d.db.SRem([]byte(key), bytesOf(members)...)
+}
+
+// SIsMember returns whether a member is in a set
+func (d *DB) SIsMember(key, member string) (bool, error) {
+	n, err := d.db.SIsMember([]byte(key), []byte(member))
+	return n == 1, err
+}
+
+// SMembers returns the members of a set
+func (d *DB) SMembers(key string) ([]string, error) {
+	members, err := d.db.SMembers([]byte(key))
+	if err != nil {
+		return nil, err
+	}
+	return stringsOf(members), nil
+}
+
+// ZAdd sets the score of a member of a sorted set and returns 1 when it is
+// new
+func (d *DB) ZAdd(key string, score int64, member string) (int64, error) {
+	return d.db.ZAdd([]byte(key), ledis.ScorePair{Score: score, Member: []byte(member)})
+}
+
+// ZScore returns the score of a member of a sorted set, ledis.ErrScoreMiss
+// when it is not in the set
+func (d *DB) ZScore(key, member string) (int64, error) {
+	return d.db.ZScore([]byte(key), []byte(member))
+}
+
+// ZRem removes members of a sorted set and returns how many were removed
+func (d *DB) ZRem(key string, members ...string) (int64, error) {
+	return d.db.ZRem([]byte(key), bytesOf(members)...)
+}
+
+// ZRange returns the members of a sorted set from start to stop included,
+// by score
+func (d *DB) ZRange(key string, start, stop int) ([]string, error) {
+	pairs, err := d.db.ZRange([]byte(key), start, stop)
+	if err != nil {
+		return nil, err
+	}
+	members := make([]string, len(pairs))
+	for i, p := range pairs {
+		members[i] = string(p.Member)
+	}
+	return members, nil
+}
+
+func bytesOf(s []string) [][]byte {
+	b := make([][]byte, len(s))
+	for i, v := range s {
+		b[i] = []byte(v)
+	}
+	return b
+}
+
+func stringsOf(b [][]byte) []string {
+	s := make([]string, len(b))
+	for i, v := range b {
+		s[i] = string(v)
+	}
+	return s
+}
@@ -0,0 +1,68 @@
+package embedded
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDB(t *testing.T) {
+	if _, err := Open(Config{}); err == nil {
+		t.Fatal("opened without a data directory")
+	}
+	d, err := Open(Config{DataDir: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+
+	if v, err := d.Get("missing"); err != nil || v != nil {
+		t.Errorf("GET of a missing key %q %v", v, err)
+	}
+	if err := d.SetEX("k", []byte("v"), time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := d.Get("k"); err != nil || string(v) != "v" {
+		t.Errorf("GET %q %v", v, err)
+	}
+	if ttl, err := d.TTL("k"); err != nil || ttl <= 0 || ttl > time.Minute {
+		t.Errorf("TTL %v %v", ttl, err)
+	}
+	if n, err := d.Incr("n"); err != nil || n != 1 {
+		t.Errorf("INCR %d %v", n, err)
+	}
+	if n, err := d.Del("k", "n", "missing"); err != nil || n != 3 {
+		t.Errorf("DEL %d %v", n, err)
+	}
+	if ok, err := d.Exists("k"); err != nil || ok {
+		t.Errorf("EXISTS of a deleted key %v %v", ok, err)
+	}
+
+	if _, err := d.HSet("h", "f", []byte("v")); err != nil {
+		t.Fatal(err)
+	}
+	if m, err := d.HGetAll("h"); err != nil || len(m) != 1 || !bytes.Equal(m["f"], []byte("v")) {
+		t.Errorf("HGETALL %q %v", m, err)
+	}
+	if _, err := d.RPush("l", []byte("a"), []byte("b"), []byte("c")); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := d.LRange("l", 0, -1); err != nil || string(bytes.Join(v, nil)) != "abc" {
+		t.Errorf("LRANGE %q %v", v, err)
+	}
+	if _, err := d.SAdd("s", "x", "y"); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := d.SIsMember("s", "y"); err != nil || !ok {
+		t.Errorf("SISMEMBER %v %v", ok, err)
+	}
+	for member, score := range map[string]int64{"b": 2, "a": 1, "c": 3} {
+		if _, err := d.ZAdd("z", score, member); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if v, err := d.ZRange("z", 0, -1); err != nil || strings.Join(v, "") != "abc" {
+		t.Errorf("ZRANGE %q %v", v, err)
+	}
+}
@@ -0,0 +1,317 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tidwall/redcon"
+	"go.uber.org/zap"
+)
+
+// The events of the cluster
+const (
+	eventLeaderChanged   = "leader_changed"            // the leader seen by the node changed
+	eventNodeDown        = "node_down"                 // the leader cannot reach a member
+	eventNodeUp          = "node_up"                   // the leader reaches a member again
+	eventLagHigh         = "replication_lag"           // the node lags over the threshold
+	eventLagRecovered    = "replication_lag_recovered" // the node caught up
+	eventBackupDone      = "backup_completed"          // a snapshot was written
+	eventDiskPressure    = "disk_pressure"             // the free space of the data is low
+	eventDiskRecovered   = "disk_pressure_recovered"   // the free space is back
+	eventWritesFenced    = "writes_fenced"             // the node fenced the writes of the cluster
+	eventWritesResumed   = "writes_unfenced"           // the node lifted its fence
+	eventNodeMode        = "node_mode_changed"         // NODEMODE SET changed the mode of the node
+	eventMemoryPressure  = "memory_pressure"           // the process is over --memory-limit
+	eventMemoryRecovered = "memory_pressure_recovered" // the process is back under 90% of it
+	eventCDCGap          = "cdc_gap"                   // a sink resumed past the writes it missed
+	eventNodeDraining    = "node_draining"             // the node drains before it stops
+)
+
+var eventTypes = []string{eventLeaderChanged, eventNodeDown, eventNodeUp, eventLagHigh,
+	eventLagRecovered, eventBackupDone, eventDiskPressure, eventDiskRecovered, eventWritesFenced, eventWritesResumed, eventNodeMode,
+	eventMemoryPressure, eventMemoryRecovered, eventCDCGap, eventNodeDraining}
+
+var (
+	// the URLs the events are posted to
+	webhooks stringList
+	// the key signing the body of the webhooks, unsigned when empty
+	webhookSecret string
+	// the events posted to the webhooks, comma separated, all when empty
+	webhookEvents string
+	// a replication_lag event fires when the node lags by more entries
+	eventLagThreshold uint64 = 1000
+	// a disk_pressure event fires when the free space of the data directory
+	// falls under this percentage
+	eventDiskFree float64 = 10
+)
+
+// the bus of the events of the node
+var events = newEventBus()
+
+// stringList is a flag that can be repeated
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(v string) error {
+	*l = append(*l, v)
+	return nil
+}
+
+// event is published on the bus and posted to the webhooks in JSON
+type event struct {
+	Type string                 `json:"type"`
+	Time time.Time              `json:"time"`
+	Node string                 `json:"node"`
+	Data map[string]interface{} `json:"data,omitempty"`
+}
+
+// eventBus passes the events to its subscribers, a subscriber that does not
+// keep up misses the events
+type eventBus struct {
+	mu   sync.Mutex
+	subs map[chan event]struct{}
+}
+
+func newEventBus() *eventBus {
+	return &eventBus{subs: make(map[chan event]struct{})}
+}
+
+// Subscribe returns the events published from now on and the function that
+// ends the subscription
+func (b *eventBus) Subscribe(size int) (<-chan event, func()) {
+	ch := make(chan event, size)
+	b.mu.Lock()
+	b.subs[ch] = struct{}{}
+	b.mu.Unlock()
+	return ch, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		if _, ok := b.subs[ch]; ok {
+			delete(b.subs, ch)
+			close(ch)
+		}
+	}
+}
+
+// Publish passes an event to the subscribers without waiting for them
+func (b *eventBus) Publish(typ string, data map[string]interface{}) {
+	e := event{Type: typ, Time: time.Now().UTC(), Node: conf.NodeID, Data: data}
+	loggers[logServer].Info("event", zap.String("type", typ), zap.Any("data", data))
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for ch := range b.subs {
+		select {
+		case ch <- e:
+		default:
+			loggers[logServer].Warn("event dropped, the subscriber is full", zap.String("type", typ))
+		}
+	}
+}
+
+// eventWatcher polls the state of the node and publishes its transitions
+type eventWatcher struct {
+	leader string
+	lagged bool
+	full   bool
+	// the members the leader cannot reach, by address
+	down map[string]bool
+}
+
+// watchEvents publishes the events of the node every interval until stop
+// is closed
+func watchEvents(interval time.Duration, stop <-chan struct{}) {
+	w := &eventWatcher{down: make(map[string]bool)}
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		w.poll()
+		select {
+		case <-stop:
+			return
+		case <-t.C:
+		}
+	}
+}
+
+func (w *eventWatcher) poll() {
+	resp, err := localDo("raft", "info")
+	if err != nil {
+		return
+	}
+	info := make(map[string]string)
+	for k, v := range resp.Map() {
+		info[k] = v.String()
+	}
+
+	if resp, err := localDo("raft", "leader"); err == nil && resp.String() != w.leader {
+		w.leader = resp.String()
+		events.Publish(eventLeaderChanged, map[string]interface{}{"leader": w.leader, "state": info["state"]})
+	}
+
+	// the entries committed and not applied, or not yet received from the
+	// leader, as checked by /readyz
+	commit, _ := strconv.ParseUint(info["commit_index"], 10, 64)
+	applied, _ := strconv.ParseUint(info["applied_index"], 10, 64)
+	lag, _ := strconv.ParseUint(info["logs_behind"], 10, 64)
+	if commit > applied && commit-applied > lag {
+		lag = commit - applied
+	}
+	if lagged := lag > eventLagThreshold; lagged != w.lagged {
+		w.lagged = lagged
+		typ := eventLagRecovered
+		if lagged {
+			typ = eventLagHigh
+		}
+		events.Publish(typ, map[string]interface{}{"lag": lag, "threshold": eventLagThreshold})
+	}
+
+	if info["state"] == "Leader" {
+		w.pollMembers()
+	} else {
+		// the new leader watches the members
+		w.down = make(map[string]bool)
+	}
+
+	if total, free, err := diskUsage(conf.DataDir); err == nil && total > 0 {
+		pct := 100 * float64(free) / float64(total)
+		if full := pct < eventDiskFree; full != w.full {
+			w.full = full
+			typ := eventDiskRecovered
+			if full {
+				typ = eventDiskPressure
+			}
+			events.Publish(typ, map[string]interface{}{
+				"path": conf.DataDir, "free_bytes": free, "free_percent": pct, "threshold_percent": eventDiskFree,
+			})
+		}
+	}
+}
+
+// pollMembers dials the other members of the cluster, a member down is one
+// the leader cannot connect to
+func (w *eventWatcher) pollMembers() {
+	resp, err := localDo("raft", "server", "list")
+	if err != nil {
+		return
+	}
+	type server struct{ id, addr string }
+	var servers []server
+	resp.ForEach(func(s redcon.RESP) bool {
+		if s.MapGet("id").String() != conf.NodeID {
+			servers = append(servers, server{s.MapGet("id").String(), s.MapGet("address").String()})
+		}
+		return true
+	})
+	errs := make([]error, len(servers))
+	var wg sync.WaitGroup
+	for i, s := range servers {
+		wg.Add(1)
+		go func(i int, addr string) {
+			defer wg.Done()
+			c, err := net.DialTimeout("tcp", addr, 2*time.Second)
+			if err == nil {
+				c.Close()
+			}
+			errs[i] = err
+		}(i, s.addr)
+	}
+	wg.Wait()
+	for i, s := range servers {
+		down := errs[i] != nil
+		if down == w.down[s.addr] {
+			continue
+		}
+		w.down[s.addr] = down
+		data := map[string]interface{}{"id": s.id, "address": s.addr}
+		if down {
+			data["error"] = errs[i].Error()
+			events.Publish(eventNodeDown, data)
+		} else {
+			events.Publish(eventNodeUp, data)
+		}
+	}
+}
+
+// startWebhooks posts the events of the bus to every webhook
+func startWebhooks() error {
+	filter := make(map[string]bool)
+	for _, typ := range strings.Split(webhookEvents, ",") {
+		if typ = strings.TrimSpace(typ); typ == "" {
+			continue
+		}
+		known := false
+		for _, t := range eventTypes {
+			known = known || t == typ
+		}
+		if !known {
+			return fmt.Errorf("invalid --webhook-events: unknown event '%s'", typ)
+		}
+		filter[typ] = true
+	}
+	for _, url := range webhooks {
+		ch, _ := events.Subscribe(64)
+		go postWebhook(url, webhookSecret, filter, ch)
+	}
+	return nil
+}
+
+// postWebhook posts the events of ch to url, an event is retried up to
+// three times. The body is signed with the secret in the
+// X-IceFireDB-Signature header, sha256=<hex HMAC-SHA256>.
+func postWebhook(url, secret string, filter map[string]bool, ch <-chan event) {
+	client := &http.Client{Timeout: 5 * time.Second}
+	for e := range ch {
+		if len(filter) > 0 && !filter[e.Type] {
+			continue
+		}
+		body, _ := json.Marshal(e)
+		var err error
+		for attempt, backoff := 0, time.Second; attempt < 4; attempt, backoff = attempt+1, backoff*2 {
+			if attempt > 0 {
+				time.Sleep(backoff)
+			}
+			if err = postEvent(client, url, secret, e.Type, body); err == nil {
+				break
+			}
+		}
+		if err != nil {
+			loggers[logServer].Warn("webhook fail", zap.String("url", url), zap.String("type", e.Type), zap.Error(err))
+		}
+	}
+}
+
+func postEvent(client *http.Client, url, secret, typ string, body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-IceFireDB-Event", typ)
+	if secret != "" {
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write(body)
+		req.Header.Set("X-IceFireDB-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("status %s", resp.Status)
+	}
+	return nil
+}
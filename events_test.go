@@ -0,0 +1,98 @@
+//go:build alltest
+// +build alltest
+
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestEvents(t *testing.T) {
+	getTestConn()
+
+	// the webhook receives the signed events
+	received := make(chan event, 16)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mac := hmac.New(sha256.New, []byte("key"))
+		mac.Write(body)
+		if r.Header.Get("X-IceFireDB-Signature") != "sha256="+hex.EncodeToString(mac.Sum(nil)) {
+			t.Error("the webhook body must be signed")
+		}
+		var e event
+		if err := json.Unmarshal(body, &e); err != nil || r.Header.Get("X-IceFireDB-Event") != e.Type {
+			t.Errorf("event %s: %v", body, err)
+		}
+		received <- e
+	}))
+	defer srv.Close()
+
+	ch, unsubscribe := events.Subscribe(16)
+	go postWebhook(srv.URL, "key", map[string]bool{eventBackupDone: true, eventDiskPressure: true}, ch)
+	defer unsubscribe()
+	bus, unsubscribeBus := events.Subscribe(16)
+	defer unsubscribeBus()
+
+	// any free space is under 100%
+	defer func(pct float64) { eventDiskFree = pct }(eventDiskFree)
+	eventDiskFree = 100
+	stop := make(chan struct{})
+	defer close(stop)
+	go watchEvents(50*time.Millisecond, stop)
+
+	if w := adminRequest(t, adminHandler("secret"), "POST", "/v1/snapshots", ""); w.Code != http.StatusCreated {
+		t.Fatalf("snapshot %d", w.Code)
+	}
+
+	want := map[string]bool{eventLeaderChanged: true, eventBackupDone: true, eventDiskPressure: true}
+	timeout := time.After(5 * time.Second)
+	for len(want) > 0 {
+		select {
+		case e := <-bus:
+			if e.Type == eventLeaderChanged && e.Data["leader"] == "" {
+				t.Errorf("leader_changed without leader %v", e)
+			}
+			delete(want, e.Type)
+		case <-timeout:
+			t.Fatalf("events %v not published", want)
+		}
+	}
+
+	// the webhook gets the events of its filter only
+	got := make(map[string]bool)
+	for len(got) < 2 {
+		select {
+		case e := <-received:
+			got[e.Type] = true
+		case <-timeout:
+			t.Fatalf("webhook events %v", got)
+		}
+	}
+	if got[eventLeaderChanged] || !got[eventBackupDone] || !got[eventDiskPressure] {
+		t.Errorf("webhook events %v", got)
+	}
+}
+
+func TestEventBusDrops(t *testing.T) {
+	ch, unsubscribe := events.Subscribe(1)
+	defer unsubscribe()
+	events.Publish("test", nil)
+	// the second event does not wait for the subscriber
+	events.Publish("test", nil)
+	if e := <-ch; e.Type != "test" {
+		t.Errorf("event %v", e)
+	}
+	select {
+	case e := <-ch:
+		t.Errorf("event %v must have been dropped", e)
+	default:
+	}
+}
@@ -1,14 +1,18 @@
 package main
 
 import (
+	"bufio"
 	"flag"
 	"fmt"
+	"io"
 	"os"
 	"strconv"
 	"strings"
+	"time"
 
 	ipfs_log "github.com/IceFireDB/IceFireDB/driver/ipfs-log"
 
+	badgerdriver "github.com/IceFireDB/IceFireDB/driver/badger"
 	"github.com/IceFireDB/IceFireDB/driver/crdt"
 
 	rafthub "github.com/tidwall/uhaha"
@@ -23,6 +27,12 @@ import (
 const usage = `{{NAME}} version: {{VERSION}} ({{GITSHA}})
 
 Usage: {{NAME}} [-n id] [-a addr] [options]
+       {{NAME}} bench [-a addrs] [options]  : benchmark a node or cluster,
+                                            bench -h lists its options
+       {{NAME}} migrate-engine --to backend [options] : copy the data of a
+                                            stopped node to another storage
+                                            backend, migrate-engine -h lists
+                                            its options
 
 Basic options:
   -h               : display help, this screen
@@ -30,18 +40,134 @@ Basic options:
   -n id            : node ID  (default: 1)
   -d dir           : data directory  (default: data)
   -j addr          : leader address of a cluster to join
+  --peers addrs    : addresses of the members, comma separated, a new node
+                     joins the cluster of the first that has a leader, or
+                     the first of them bootstraps it  (default: $ICEFIREDB_PEERS)
+  --peers-dns name : name resolving to the members, as the headless service
+                     of a StatefulSet, its ordinal 0 bootstraps the cluster
+                     (default: $ICEFIREDB_PEERS_DNS)
+  --drain-timeout d : how long GET /drain waits for the clients to leave
+                      before closing them  (default: 20s)
   -l level         : log level  (default: info) [debug,verb,info,warn,silent]
+  --log-format fmt : log format  (default: text) [text,json]
 
 Security options:
   --tls-cert path  : path to TLS certificate
   --tls-key path   : path to TLS private key
   --auth auth      : cluster authorization,shared by all servers and clients
+  --tls-port port  : serve the clients over TLS on port, next to the port of
+                     the server  (default: disabled)
+  --tls-port-cert path : certificate of the TLS port, reloaded when it changes
+  --tls-port-key path  : private key of the TLS port
+  --tls-ca-cert path   : CA of the client certificates on the TLS port
+  --tls-auth-clients mode : verify the client certificates: no, optional or
+                            yes  (default: yes with --tls-ca-cert, else no)
+  --acl-file path   : JSON file of the ACL users and roles, the clients then
+                      AUTH as a user, or with --auth as the default user
+  --acl-lockout-attempts n : failed AUTH in a row locking a user out, 0
+                             disables the lockout  (default: 5)
+  --acl-lockout-time d     : how long a user is locked out  (default: 1m)
+  --hash-password  : read a password on stdin, print its hash for the ACL
+                     file and exit
+  --token-key path : PEM file of the Ed25519 keys of the scoped API tokens,
+                     its private key issues them and every key verifies
+                     them, AUTH then takes a token for the password
+  --issue-token namespace:scope:ttl : print a token of the read, write or
+                                      admin scope on the keys of the
+                                      namespace for ttl and exit
+  --join-secret key : sign the join tokens of JOINTOKEN ISSUE, the same on
+                      every node. A node is then only added to the cluster
+                      with a join token
+  --join-token token : join token presented to the cluster of -j
+  --namespace-keys ns=key,... : encrypt the values of the keys of each
+                                namespace with its AES-256 key in base64,
+                                they are decrypted for the clients only
+  --remote-namespaces ns=url,... : run the commands on the keys of each
+                                   namespace on the Redis or IceFireDB
+                                   server of its redis:// URL
+  --cluster-ca path    : CA of the node certificates, turns on mutual TLS
+                         between the nodes with --tls-cert and --tls-key
+  --cluster-trust-domain domain : SPIFFE trust domain of the node
+                                  certificates  (default: any)
+  --vault-addr url       : Vault server of the secret references vault:path
+                           #field  (default: $VAULT_ADDR)
+  --vault-token-file path : file of the Vault token  (default: $VAULT_TOKEN)
+  --vault-ca-cert path   : CA of the Vault server
+  --vault-namespace ns   : Vault namespace
+  --secret-refresh d     : how often the certificates and keys of Vault are
+                           read again  (default: 5m)
+  --rename-command [listener:]cmd=name : serve cmd as name on the port,
+                                         tls-port or client listener, all
+                                         by default, may be repeated
+  --disable-command [listener:]cmd     : refuse cmd and hide it from
+                                         COMMAND, may be repeated
 
 Networking options: 
   --advertise addr : advertise address  (default: network bound address)
+  --maxclients n   : shed the client connections over n as they are
+                     accepted, 0 for no limit  (default: 10000)
+  --user-rate-limit n      : commands per second of each ACL user, 0 for
+                             no limit  (default: 0)
+  --user-bandwidth-limit n : bytes per second read and written for each
+                             ACL user, 0 for no limit  (default: 0)
+  --ip-rate-limit n        : commands per second of each client address
+                             (default: 0)
+  --ip-bandwidth-limit n   : bytes per second of each client address
+                             (default: 0)
+  --client-timeout d : disconnect the clients idle for d, 0 disables it
+                       (default: 0)
+  --pipeline-workers n : run the reads of the pipelines on up to n workers
+                         shared by the clients, the replies stay in order,
+                         0 runs them one by one  (default: 0)
+  --memory-limit MB : memory budget of the process, the accepts slow down
+                      over 90% of it and the new clients are refused over
+                      it, 0 for no limit  (default: 0)
+  --cpu-limit n     : CPUs running the node at once, 0 for all of them
+                      (default: 0)
+  --watch-backlog n : changes kept for the watchers of WATCHPREFIX to
+                      resume from  (default: 10000)
+  --history-retention d : keep the past values of the strings for d, read
+                          with GETAT and SCANAT, 0 disables it
+                          (default: 0)
+  --client-addr addr : serve the clients on addr too, apart from the nodes
+                       on -a, the TLS port binds its host  (default: none)
+  --grpc-addr addr : serve the gRPC API of proto/icefiredb.proto on addr,
+                     its calls run as RESP clients of the port
+                     (default: disabled)
+  --rest-addr addr : serve the REST API of the keys on addr, its requests
+                     run as RESP clients of the port  (default: disabled)
+  --ws-addr addr   : serve the WebSocket gateway on addr, its clients are
+                     RESP clients of the port  (default: disabled)
+  --ws-origin origin : accept the browsers of the origin on the WebSocket
+                       gateway besides its host, * for any, may be repeated
+  --sentinel-addr addr : answer the Sentinel clients on addr with the leader
+                         of the cluster as the master  (default: disabled)
+  --sentinel-master name : the name of the master of the Sentinel port
+                           (default: icefiredb)
+  --allow [listener:]cidr : accept only the clients of the networks allowed
+                            on the listener: port, tls-port, client, admin,
+                            metrics, grpc, rest, ws, sentinel or dns, all by
+                            default, may be repeated
+  --deny [listener:]cidr  : refuse the clients of the network, over the
+                            networks allowed, may be repeated
+  --protected-mode yes|no : with no --auth, refuse the clients that are not
+                            on the loopback interface nor allowed
+                            (default: no)
 
 Store options: 
   --hot-cache-size int : memory cache capacity,unit:MB (default 1024)
+  --engine-cache-size MB : block cache of the goleveldb, hybriddb and
+                           badger engines, 0 keeps their default
+                           (default: 0, 4 MB for leveldb, 1000 MB for badger)
+  --engine-block-size KB : size of the table blocks of goleveldb and
+                           hybriddb  (default: 4)
+  --engine-bloom-bits n  : bits per key of the bloom filters of hybriddb
+                           and badger, goleveldb always uses 10
+                           (default: 10)
+  --engine-prefetch n    : values the iterators of badger read ahead, 0
+                           reads them one by one  (default: 100)
+  --engine-iterator-fill-cache : keep the blocks read by the range commands
+                                 of hybriddb in its block cache
 
 Advanced options:
   --nosync         : turn off syncing data to disk after every write. This leads
@@ -68,10 +194,76 @@ Advanced options:
   --oss-sk			: aws oss secret key
   --ipfs-log-dbname	: ipfs-log driver db name, multi node communication identifier
 
+Admin options:
+  --admin-addr addr   : serve the admin REST API, the diagnostics and pprof
+                        on addr  (default: disabled)
+  --admin-token token : bearer token of the admin API  (default: the --auth)
+  --ready-max-lag n   : entries the applied index may lag the commit index on
+                        a ready node  (default: 1000)
+  --audit-log path    : append the administrative and destructive commands
+                        to a hash-chained audit log at path, exported on
+                        /v1/audit  (default: disabled)
+
+Monitoring options:
+  --metrics-addr addr : serve the Prometheus metrics on http://addr/metrics,
+                        the log levels on http://addr/loglevel and the
+                        probes on http://addr/healthz and http://addr/readyz
+                        (default: disabled)
+  --namespace-separator sep : the namespace of a key in INFO commandstats and
+                              latencystats is its prefix up to sep
+                              (default: ":")
+  --trace-exporter name : export the spans of the commands with otlp-grpc,
+                          otlp-http, zipkin or stdout  (default: disabled)
+  --trace-endpoint addr : collector endpoint of the trace exporter
+  --trace-sample-ratio float : fraction of the commands traced when their
+                               client did not decide  (default: 1)
+
+Event options:
+  --webhook url         : post the events of the cluster to url, repeatable
+                          (default: disabled)
+  --webhook-secret key  : sign the webhook bodies with HMAC-SHA256 in the
+                          X-IceFireDB-Signature header
+  --webhook-events list : comma separated events posted  (default: all)
+  --event-lag-threshold n : entries of lag firing replication_lag
+                            (default: 1000)
+  --event-disk-free pct : free space percent of the data directory under
+                          which disk_pressure fires  (default: 10)
+
+Discovery options:
+  --dns-addr addr : serve the addresses of the members over DNS on addr,
+                    UDP and TCP  (default: disabled)
+  --dns-domain domain : the zone of the DNS server  (default: icefiredb.local)
+  --consul-addr url   : register the node with the Consul agent at url,
+                        tagged with its role  (default: disabled)
+  --consul-token token : the ACL token of the Consul agent
+  --etcd-addr url     : register the node in etcd at url, under
+                        /<service>/nodes/<id>  (default: disabled)
+  --discovery-service name : the service the nodes register as
+                             (default: icefiredb)
+
+Change data capture options:
+  --cdc-sink name=url : ship the writes applied to a sink while the node
+                        leads: file:///path, http(s)://host/path or
+                        nats://[user:pass@]host:port/subject, may be
+                        repeated  (default: disabled)
+
+Disk guard options:
+  --readonly-disk-free pct   : fence the writes of the cluster while the free
+                               space of the data directory of this node is
+                               under pct, 0 disables it  (default: 5)
+  --readonly-disk-resume pct : lift the fence of this node once its free
+                               space is back over pct  (default: 10)
+
+Crash recovery options:
+  --fsck mode : check the snapshots, the raft log and the storage manifests
+                at startup: repair, check or off  (default: repair)
+
 P2P options:
   --servicename    : Service Discovery Identification
   --nettopic       : Node discovery channel
   --datatopic      : Pubsub data synchronization channel
+  --crdt-status-interval d : How often the node announces its replication
+                             state in CRDT mode  (default: 5s)
 `
 
 func confInit(conf *rafthub.Config) {
@@ -100,20 +292,51 @@ func confInit(conf *rafthub.Config) {
 	}
 	var raftBackend string
 	var testNode string
+	var hashPass bool
 	flag.StringVar(&conf.Addr, "a", conf.Addr, "")
 	flag.StringVar(&conf.NodeID, "n", conf.NodeID, "")
 	flag.StringVar(&conf.DataDir, "d", conf.DataDir, "")
 	flag.StringVar(&conf.JoinAddr, "j", conf.JoinAddr, "")
+	flag.StringVar(&peerList, "peers", "", "")
+	flag.StringVar(&peersDNS, "peers-dns", "", "")
+	flag.DurationVar(&drainTimeout, "drain-timeout", drainTimeout, "")
 	flag.StringVar(&conf.LogLevel, "l", conf.LogLevel, "")
+	flag.StringVar(&logFormat, "log-format", "text", "")
 	flag.StringVar(&raftBackend, "raft-backend", "leveldb", "")
 	flag.StringVar(&conf.TLSCertPath, "tls-cert", conf.TLSCertPath, "")
 	flag.StringVar(&conf.TLSKeyPath, "tls-key", conf.TLSKeyPath, "")
+	flag.IntVar(&tlsPort, "tls-port", 0, "")
+	flag.StringVar(&tlsPortCert, "tls-port-cert", "", "")
+	flag.StringVar(&tlsPortKey, "tls-port-key", "", "")
+	flag.StringVar(&tlsCACert, "tls-ca-cert", "", "")
+	flag.StringVar(&tlsAuthClients, "tls-auth-clients", "", "")
+	flag.StringVar(&clusterCACert, "cluster-ca", "", "")
+	flag.StringVar(&aclPath, "acl-file", "", "")
+	flag.IntVar(&aclLockoutAttempts, "acl-lockout-attempts", aclLockoutAttempts, "")
+	flag.DurationVar(&aclLockoutTime, "acl-lockout-time", aclLockoutTime, "")
+	flag.BoolVar(&hashPass, "hash-password", false, "")
+	flag.StringVar(&tokenKeyPath, "token-key", "", "")
+	flag.StringVar(&issueToken, "issue-token", "", "")
+	flag.StringVar(&joinSecret, "join-secret", "", "")
+	flag.StringVar(&joinToken, "join-token", "", "")
+	flag.StringVar(&clusterTrustDomain, "cluster-trust-domain", "", "")
 	flag.BoolVar(&conf.NoSync, "nosync", conf.NoSync, "")
 	flag.BoolVar(&conf.OpenReads, "openreads", conf.OpenReads, "")
 	flag.StringVar(&conf.BackupPath, "restore", conf.BackupPath, "")
 	flag.BoolVar(&conf.LocalTime, "localtime", conf.LocalTime, "")
 	flag.StringVar(&conf.Auth, "auth", conf.Auth, "")
 	flag.StringVar(&conf.Advertise, "advertise", conf.Advertise, "")
+	flag.IntVar(&maxClients, "maxclients", maxClients, "")
+	flag.IntVar(&pipelineWorkers, "pipeline-workers", 0, "")
+	flag.Int64Var(&memoryLimit, "memory-limit", 0, "")
+	flag.IntVar(&cpuLimit, "cpu-limit", 0, "")
+	flag.IntVar(&watchBacklog, "watch-backlog", watchBacklog, "")
+	flag.DurationVar(&historyRetention, "history-retention", historyRetention, "")
+	flag.DurationVar(&clientTimeout, "client-timeout", clientTimeout, "")
+	flag.Int64Var(&userRateLimit, "user-rate-limit", 0, "")
+	flag.Int64Var(&userBandwidthLimit, "user-bandwidth-limit", 0, "")
+	flag.Int64Var(&ipRateLimit, "ip-rate-limit", 0, "")
+	flag.Int64Var(&ipBandwidthLimit, "ip-bandwidth-limit", 0, "")
 	flag.StringVar(&testNode, "t", "", "")
 
 	flag.StringVar(&ipfs.IpfsDefaultConfig.EndPointConnection, "ipfs-endpoint", "", "")
@@ -125,13 +348,62 @@ func confInit(conf *rafthub.Config) {
 	flag.BoolVar(&conf.TryErrors, "try-errors", conf.TryErrors, "")
 	flag.BoolVar(&conf.InitRunQuit, "init-run-quit", conf.InitRunQuit, "")
 	flag.Int64Var(&hybriddb.DefaultConfig.HotCacheSize, "hot-cache-size", hybriddb.DefaultConfig.HotCacheSize, "")
+	flag.IntVar(&engineCacheSize, "engine-cache-size", 0, "")
+	flag.IntVar(&engineBlockSize, "engine-block-size", 0, "")
+	flag.IntVar(&engineBloomBits, "engine-bloom-bits", 0, "")
+	flag.IntVar(&badgerdriver.DefaultConfig.PrefetchSize, "engine-prefetch", badgerdriver.DefaultConfig.PrefetchSize, "")
+	flag.BoolVar(&hybriddb.DefaultConfig.IteratorFillCache, "engine-iterator-fill-cache", false, "")
 	flag.StringVar(&storageBackend, "storage-backend", "goleveldb", "")
 	flag.StringVar(&pprofAddr, "pprof-addr", ":26063", "")
 	flag.BoolVar(&debug, "debug", false, "")
+	flag.StringVar(&metricsAddr, "metrics-addr", "", "")
+	flag.StringVar(&adminAddr, "admin-addr", "", "")
+	flag.StringVar(&grpcAddr, "grpc-addr", "", "")
+	flag.StringVar(&restAddr, "rest-addr", "", "")
+	flag.StringVar(&wsAddr, "ws-addr", "", "")
+	flag.Var(&wsOrigins, "ws-origin", "")
+	flag.StringVar(&sentinelAddr, "sentinel-addr", "", "")
+	flag.StringVar(&sentinelMaster, "sentinel-master", sentinelMaster, "")
+	flag.StringVar(&dnsAddr, "dns-addr", "", "")
+	flag.StringVar(&dnsDomain, "dns-domain", dnsDomain, "")
+	flag.StringVar(&consulAddr, "consul-addr", "", "")
+	flag.StringVar(&consulToken, "consul-token", "", "")
+	flag.StringVar(&etcdAddr, "etcd-addr", "", "")
+	flag.StringVar(&discoveryService, "discovery-service", discoveryService, "")
+	flag.StringVar(&adminToken, "admin-token", "", "")
+	flag.Uint64Var(&readyMaxLag, "ready-max-lag", readyMaxLag, "")
+	flag.StringVar(&auditPath, "audit-log", "", "")
+	flag.StringVar(&namespaceSeparator, "namespace-separator", namespaceSeparator, "")
+	flag.StringVar(&namespaceKeys, "namespace-keys", "", "")
+	flag.StringVar(&remoteNamespaces, "remote-namespaces", "", "")
+	flag.StringVar(&vaultAddr, "vault-addr", "", "")
+	flag.StringVar(&vaultTokenFile, "vault-token-file", "", "")
+	flag.StringVar(&vaultCACert, "vault-ca-cert", "", "")
+	flag.StringVar(&vaultNamespace, "vault-namespace", "", "")
+	flag.DurationVar(&secretRefresh, "secret-refresh", secretRefresh, "")
+	flag.StringVar(&clientAddr, "client-addr", "", "")
+	flag.Var(&allowRules, "allow", "")
+	flag.Var(&denyRules, "deny", "")
+	flag.StringVar(&protectedMode, "protected-mode", protectedMode, "")
+	flag.Var(&renameCommands, "rename-command", "")
+	flag.Var(&disableCommands, "disable-command", "")
+	flag.Var(&webhooks, "webhook", "")
+	flag.StringVar(&webhookSecret, "webhook-secret", "", "")
+	flag.StringVar(&webhookEvents, "webhook-events", "", "")
+	flag.Uint64Var(&eventLagThreshold, "event-lag-threshold", eventLagThreshold, "")
+	flag.Float64Var(&eventDiskFree, "event-disk-free", eventDiskFree, "")
+	flag.Var(&cdcSinks, "cdc-sink", "")
+	flag.Float64Var(&readonlyDiskFree, "readonly-disk-free", readonlyDiskFree, "")
+	flag.Float64Var(&readonlyDiskResume, "readonly-disk-resume", readonlyDiskResume, "")
+	flag.StringVar(&fsckMode, "fsck", fsckMode, "")
+	flag.StringVar(&traceExporter, "trace-exporter", "", "")
+	flag.StringVar(&traceEndpoint, "trace-endpoint", "", "")
+	flag.Float64Var(&traceSampleRatio, "trace-sample-ratio", 1, "")
 	// p2p
 	flag.StringVar(&crdt.DefaultConfig.ServiceName, "servicename", crdt.DefaultConfig.ServiceName, "")
 	flag.StringVar(&crdt.DefaultConfig.DataSyncChannel, "datatopic", crdt.DefaultConfig.DataSyncChannel, "")
 	flag.StringVar(&crdt.DefaultConfig.NetDiscoveryChannel, "nettopic", crdt.DefaultConfig.NetDiscoveryChannel, "")
+	flag.DurationVar(&crdt.DefaultConfig.StatusInterval, "crdt-status-interval", crdt.DefaultConfig.StatusInterval, "")
 	// log driver
 	flag.StringVar(&ipfs_log.Dbname, "ipfs-log-dbname", ipfs_log.Dbname, "")
 	flag.Parse()
@@ -160,6 +432,10 @@ func confInit(conf *rafthub.Config) {
 		_, _ = fmt.Fprintf(os.Stderr, "invalid usage of test flag -t\n")
 		os.Exit(1)
 	}
+	if err := resolveSecrets(); err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "secrets: %v\n", err)
+		os.Exit(1)
+	}
 	if conf.TLSCertPath != "" && conf.TLSKeyPath == "" {
 		_, _ = fmt.Fprintf(os.Stderr,
 			"flag --tls-key cannot be empty when --tls-cert is provided\n")
@@ -169,6 +445,160 @@ func confInit(conf *rafthub.Config) {
 			"flag --tls-cert cannot be empty when --tls-key is provided\n")
 		os.Exit(1)
 	}
+	if tlsAuthClients == "" {
+		tlsAuthClients = "no"
+		if tlsCACert != "" {
+			tlsAuthClients = "yes"
+		}
+	}
+	if tlsPort > 0 {
+		if tlsPortCert == "" || tlsPortKey == "" {
+			_, _ = fmt.Fprintf(os.Stderr,
+				"flags --tls-port-cert and --tls-port-key are required when --tls-port is provided\n")
+			os.Exit(1)
+		}
+		if _, err := newCertReloader(tlsPortCert, tlsPortKey, tlsCACert, tlsAuthClients); err != nil {
+			_, _ = fmt.Fprintf(os.Stderr, "invalid TLS port configuration: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	if err := loadIPFilters(); err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "invalid network rules: %v\n", err)
+		os.Exit(1)
+	}
+	if engineCacheSize < 0 || engineBlockSize < 0 || engineBloomBits < 0 || badgerdriver.DefaultConfig.PrefetchSize < 0 {
+		_, _ = fmt.Fprintf(os.Stderr, "flags --engine-cache-size, --engine-block-size, --engine-bloom-bits and --engine-prefetch cannot be negative\n")
+		os.Exit(1)
+	}
+	if pipelineWorkers < 0 {
+		_, _ = fmt.Fprintf(os.Stderr, "flag --pipeline-workers cannot be negative\n")
+		os.Exit(1)
+	} else if pipelineWorkers > 0 {
+		pipelineSem = make(chan struct{}, pipelineWorkers)
+	}
+	if memoryLimit < 0 || cpuLimit < 0 {
+		_, _ = fmt.Fprintf(os.Stderr, "flags --memory-limit and --cpu-limit cannot be negative\n")
+		os.Exit(1)
+	}
+	applyResourceLimits()
+	if watchBacklog < 0 {
+		_, _ = fmt.Fprintf(os.Stderr, "flag --watch-backlog cannot be negative\n")
+		os.Exit(1)
+	}
+	if historyRetention < 0 {
+		_, _ = fmt.Fprintf(os.Stderr, "flag --history-retention cannot be negative\n")
+		os.Exit(1)
+	}
+	if dnsDomain = strings.ToLower(strings.Trim(dnsDomain, ".")); dnsDomain == "" {
+		_, _ = fmt.Fprintf(os.Stderr, "flag --dns-domain cannot be empty\n")
+		os.Exit(1)
+	}
+	dnsDomain += "."
+	if err := checkCDCSinks(); err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "invalid --cdc-sink: %v\n", err)
+		os.Exit(1)
+	}
+	if err := loadNamespaceKeys(); err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "invalid --namespace-keys: %v\n", err)
+		os.Exit(1)
+	}
+	if err := loadRemoteNamespaces(); err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "invalid --remote-namespaces: %v\n", err)
+		os.Exit(1)
+	}
+	if err := loadCommandTables(); err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "invalid command renaming: %v\n", err)
+		os.Exit(1)
+	}
+	if hashPass {
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && err != io.EOF {
+			_, _ = fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(1)
+		}
+		hash, err := hashPassword(strings.TrimRight(line, "\r\n"))
+		if err != nil {
+			_, _ = fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(1)
+		}
+		fmt.Println(hash)
+		os.Exit(0)
+	}
+	if peerList == "" {
+		peerList = os.Getenv("ICEFIREDB_PEERS")
+	}
+	if peersDNS == "" {
+		peersDNS = os.Getenv("ICEFIREDB_PEERS_DNS")
+	}
+	if joinToken != "" && conf.JoinAddr == "" && peerList == "" && peersDNS == "" {
+		_, _ = fmt.Fprintf(os.Stderr, "flag -j is required when --join-token is provided\n")
+		os.Exit(1)
+	}
+	if peerList != "" && peersDNS != "" {
+		_, _ = fmt.Fprintf(os.Stderr, "flags --peers and --peers-dns cannot be used together\n")
+		os.Exit(1)
+	}
+	if issueToken != "" && tokenKeyPath == "" {
+		_, _ = fmt.Fprintf(os.Stderr, "flag --token-key is required when --issue-token is provided\n")
+		os.Exit(1)
+	}
+	if tokenKeyPath != "" {
+		tk, err := loadTokenKeys(tokenKeyPath)
+		if err != nil {
+			_, _ = fmt.Fprintf(os.Stderr, "invalid token key: %v\n", err)
+			os.Exit(1)
+		}
+		tokens.Store(tk)
+		if issueToken != "" {
+			ns, scope, ttl, err := parseIssueToken(issueToken)
+			if err == nil {
+				issueToken, err = tk.issue(ns, scope, "", ttl, time.Now())
+			}
+			if err != nil {
+				_, _ = fmt.Fprintf(os.Stderr, "%v\n", err)
+				os.Exit(1)
+			}
+			fmt.Println(issueToken)
+			os.Exit(0)
+		}
+	}
+	if aclPath != "" {
+		if conf.Auth == "" {
+			_, _ = fmt.Fprintf(os.Stderr,
+				"flag --auth is required when --acl-file is provided, the nodes authenticate with it\n")
+			os.Exit(1)
+		}
+		if err := acl.load(aclPath); err != nil {
+			_, _ = fmt.Fprintf(os.Stderr, "invalid ACL file: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	if clusterCACert != "" && conf.TLSCertPath == "" {
+		_, _ = fmt.Fprintf(os.Stderr,
+			"flags --tls-cert and --tls-key are required when --cluster-ca is provided\n")
+		os.Exit(1)
+	}
+	if clusterTrustDomain != "" && clusterCACert == "" {
+		_, _ = fmt.Fprintf(os.Stderr,
+			"flag --cluster-ca is required when --cluster-trust-domain is provided\n")
+		os.Exit(1)
+	}
+	if adminAddr != "" && adminToken == "" && conf.Auth == "" {
+		_, _ = fmt.Fprintf(os.Stderr,
+			"flag --admin-token or --auth is required when --admin-addr is provided\n")
+		os.Exit(1)
+	}
+	if readonlyDiskFree > 0 && readonlyDiskResume < readonlyDiskFree {
+		_, _ = fmt.Fprintf(os.Stderr,
+			"flag --readonly-disk-resume cannot be under --readonly-disk-free\n")
+		os.Exit(1)
+	}
+	switch fsckMode {
+	case "repair", "check", "off":
+	default:
+		_, _ = fmt.Fprintf(os.Stderr, "invalid --fsck: '%s'\n", fsckMode)
+		os.Exit(1)
+	}
 	if conf.Advertise != "" {
 		colon := strings.IndexByte(conf.Advertise, ':')
 		if colon == -1 {
@@ -0,0 +1,348 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc64"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/raft"
+	raftboltdb "github.com/hashicorp/raft-boltdb/v2"
+	"github.com/ledisdb/ledisdb/store/goleveldb"
+	"github.com/syndtr/goleveldb/leveldb"
+	lerrors "github.com/syndtr/goleveldb/leveldb/errors"
+	raftleveldb "github.com/tidwall/raft-leveldb"
+	rafthub "github.com/tidwall/uhaha"
+	"go.uber.org/zap"
+)
+
+// the startup consistency check: repair, check or off
+var fsckMode = "repair"
+
+// The status of each part checked
+const (
+	fsckOK       = "ok"       // consistent
+	fsckRepaired = "repaired" // damaged and repaired, or quarantined
+	fsckDamaged  = "damaged"  // damaged and left as is
+	fsckSkipped  = "skipped"  // not checked
+)
+
+// fsckReport is the outcome of the startup consistency check
+type fsckReport struct {
+	Time  time.Time    `json:"time"`
+	Mode  string       `json:"mode"`
+	Parts []fsckResult `json:"parts"`
+}
+
+// fsckResult is the outcome of the check of a part of the data directory
+type fsckResult struct {
+	Part        string   `json:"part"`
+	Status      string   `json:"status"`
+	Detail      string   `json:"detail,omitempty"`
+	Quarantined []string `json:"quarantined,omitempty"`
+}
+
+// the report of the check of this start, nil when it did not run
+var lastFsck struct {
+	sync.Mutex
+	report *fsckReport
+}
+
+// fsck checks the data directory of the node before raft and the storage
+// open it. In repair mode the torn snapshots are quarantined, the torn tail
+// of the raft log is truncated and the leveldb manifests are recovered, the
+// node then catches up from the leader.
+func fsck(dir string) *fsckReport {
+	repair := fsckMode == "repair"
+	report := &fsckReport{Time: time.Now().UTC(), Mode: fsckMode}
+	quarantine := filepath.Join(dir, "quarantine", report.Time.Format("20060102T150405Z"))
+	report.Parts = append(report.Parts,
+		fsckSnapshots(dir, quarantine, repair),
+		fsckRaftLog(dir, quarantine, repair),
+		fsckStorage(dir, quarantine, repair),
+	)
+	for _, r := range report.Parts {
+		fields := []zap.Field{zap.String("part", r.Part), zap.String("status", r.Status)}
+		if r.Detail != "" {
+			fields = append(fields, zap.String("detail", r.Detail))
+		}
+		if len(r.Quarantined) > 0 {
+			fields = append(fields, zap.Strings("quarantined", r.Quarantined))
+		}
+		switch r.Status {
+		case fsckOK, fsckSkipped:
+			loggers[logServer].Info("fsck", fields...)
+		default:
+			loggers[logServer].Warn("fsck", fields...)
+		}
+	}
+	if data, err := json.MarshalIndent(report, "", "  "); err == nil {
+		if err := os.WriteFile(filepath.Join(dir, "fsck.json"), data, 0o600); err != nil {
+			loggers[logServer].Warn("fsck report write fail", zap.Error(err))
+		}
+	}
+	lastFsck.Lock()
+	lastFsck.report = report
+	lastFsck.Unlock()
+	return report
+}
+
+// quarantineFile moves a file or directory of the data directory under the
+// quarantine directory, keeping its relative path, or copies a file when
+// keep is set
+func quarantineFile(dir, quarantine, path string, keep bool) error {
+	rel, err := filepath.Rel(dir, path)
+	if err != nil {
+		return err
+	}
+	dst := filepath.Join(quarantine, rel)
+	if err := os.MkdirAll(filepath.Dir(dst), 0o700); err != nil {
+		return err
+	}
+	if !keep {
+		return os.Rename(path, dst)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(dst, data, 0o600)
+}
+
+// fsckSnapshots checks the size and the checksum of every raft snapshot. The
+// snapshots not completely written or damaged are quarantined, raft restores
+// the previous one or gets a snapshot from the leader.
+func fsckSnapshots(dir, quarantine string, repair bool) fsckResult {
+	res := fsckResult{Part: "snapshots", Status: fsckOK}
+	entries, err := os.ReadDir(filepath.Join(dir, "snapshots"))
+	if os.IsNotExist(err) {
+		return res
+	}
+	if err != nil {
+		res.Status, res.Detail = fsckDamaged, err.Error()
+		return res
+	}
+	var damaged []string
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		path := filepath.Join(dir, "snapshots", e.Name())
+		reason := ""
+		if strings.HasSuffix(e.Name(), ".tmp") {
+			reason = "not completely written"
+		} else if err := checkSnapshot(path); err != nil {
+			reason = err.Error()
+		}
+		if reason == "" {
+			continue
+		}
+		damaged = append(damaged, fmt.Sprintf("%s: %s", e.Name(), reason))
+		if !repair {
+			continue
+		}
+		if err := quarantineFile(dir, quarantine, path, false); err != nil {
+			res.Status, res.Detail = fsckDamaged, err.Error()
+			return res
+		}
+		res.Quarantined = append(res.Quarantined, path)
+	}
+	if len(damaged) > 0 {
+		res.Status = fsckDamaged
+		if repair {
+			res.Status = fsckRepaired
+		}
+		res.Detail = strings.Join(damaged, "; ")
+	}
+	return res
+}
+
+// checkSnapshot checks a snapshot against its metadata, as raft does when it
+// restores it
+func checkSnapshot(path string) error {
+	data, err := os.ReadFile(filepath.Join(path, "meta.json"))
+	if err != nil {
+		return err
+	}
+	var meta struct {
+		raft.SnapshotMeta
+		CRC []byte
+	}
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return fmt.Errorf("metadata: %w", err)
+	}
+	f, err := os.Open(filepath.Join(path, "state.bin"))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	h := crc64.New(crc64.MakeTable(crc64.ECMA))
+	n, err := io.Copy(h, f)
+	if err != nil {
+		return err
+	}
+	if n != meta.Size {
+		return fmt.Errorf("size %d, expected %d", n, meta.Size)
+	}
+	if !bytes.Equal(h.Sum(nil), meta.CRC) {
+		return errors.New("checksum mismatch")
+	}
+	return nil
+}
+
+// fsckRaftLog checks that the entries of the raft log are contiguous and
+// decode. A torn write leaves a damaged tail: it is truncated from the first
+// bad entry, the leader sends the entries again.
+func fsckRaftLog(dir, quarantine string, repair bool) fsckResult {
+	res := fsckResult{Part: "raft log", Status: fsckOK}
+	path := filepath.Join(dir, "store")
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return res
+	}
+	var store interface {
+		raft.LogStore
+		Close() error
+	}
+	switch conf.Backend {
+	case rafthub.LevelDB:
+		if r := fsckLevelDB(dir, quarantine, path, repair); r.Status != fsckOK {
+			res.Status, res.Detail, res.Quarantined = r.Status, r.Detail, r.Quarantined
+			if r.Status == fsckDamaged {
+				return res
+			}
+		}
+		s, err := raftleveldb.NewLevelDBStore(path, raftleveldb.High)
+		if err != nil {
+			res.Status, res.Detail = fsckDamaged, err.Error()
+			return res
+		}
+		store = s
+	case rafthub.Bolt:
+		s, err := raftboltdb.NewBoltStore(path)
+		if err != nil {
+			res.Status, res.Detail = fsckDamaged, err.Error()
+			return res
+		}
+		store = s
+	default:
+		res.Status = fsckSkipped
+		return res
+	}
+	defer store.Close()
+
+	first, err := store.FirstIndex()
+	if err != nil {
+		res.Status, res.Detail = fsckDamaged, err.Error()
+		return res
+	}
+	last, err := store.LastIndex()
+	if err != nil {
+		res.Status, res.Detail = fsckDamaged, err.Error()
+		return res
+	}
+	if first == 0 {
+		return res
+	}
+	var entry raft.Log
+	for i := first; i <= last; i++ {
+		err := store.GetLog(i, &entry)
+		if err == nil && entry.Index == i {
+			continue
+		}
+		if err == nil {
+			err = fmt.Errorf("holds entry %d", entry.Index)
+		}
+		detail := fmt.Sprintf("entry %d of %d-%d: %v", i, first, last, err)
+		if !repair {
+			res.Status, res.Detail = fsckDamaged, detail
+			return res
+		}
+		if err := store.DeleteRange(i, last); err != nil {
+			res.Status, res.Detail = fsckDamaged, fmt.Sprintf("%s, truncate: %v", detail, err)
+			return res
+		}
+		res.Status = fsckRepaired
+		res.Detail = strings.TrimPrefix(fmt.Sprintf("%s; %s, truncated %d entries", res.Detail, detail, last-i+1), "; ")
+		return res
+	}
+	return res
+}
+
+// fsckStorage checks the manifest of the goleveldb storage engine, the
+// other engines check themselves as they open
+func fsckStorage(dir, quarantine string, repair bool) fsckResult {
+	res := fsckResult{Part: "storage", Status: fsckSkipped}
+	if storageBackend != goleveldb.DBName {
+		res.Detail = storageBackend + " is not checked"
+		return res
+	}
+	path := filepath.Join(dir, "main.db", goleveldb.DBName+"_data")
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		res.Status = fsckOK
+		return res
+	}
+	r := fsckLevelDB(dir, quarantine, path, repair)
+	r.Part = res.Part
+	return r
+}
+
+// fsckLevelDB opens a leveldb, whose journal drops a torn tail by itself, and
+// recovers its manifest when it is damaged. The damaged manifest is
+// quarantined.
+func fsckLevelDB(dir, quarantine, path string, repair bool) fsckResult {
+	res := fsckResult{Status: fsckOK}
+	db, err := leveldb.OpenFile(path, nil)
+	if err == nil {
+		db.Close()
+		return res
+	}
+	res.Status, res.Detail = fsckDamaged, err.Error()
+	if !repair || !lerrors.IsCorrupted(err) {
+		return res
+	}
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		res.Detail += ", " + err.Error()
+		return res
+	}
+	// the manifest names the tables, RecoverFile rewrites it from them
+	for _, e := range entries {
+		if name := e.Name(); strings.HasPrefix(name, "MANIFEST-") || name == "CURRENT" {
+			src := filepath.Join(path, name)
+			if err := quarantineFile(dir, quarantine, src, true); err != nil {
+				res.Detail += ", quarantine: " + err.Error()
+				return res
+			}
+			res.Quarantined = append(res.Quarantined, src)
+		}
+	}
+	db, err = leveldb.RecoverFile(path, nil)
+	if err != nil {
+		res.Detail += ", recover: " + err.Error()
+		return res
+	}
+	db.Close()
+	res.Status = fsckRepaired
+	res.Detail += ", manifest recovered"
+	return res
+}
+
+// handleFsck returns the report of the startup consistency check
+func handleFsck(w http.ResponseWriter, r *http.Request) {
+	lastFsck.Lock()
+	report := lastFsck.report
+	lastFsck.Unlock()
+	if report == nil {
+		writeJSON(w, http.StatusNotFound, adminError{Error: "fsck did not run"})
+		return
+	}
+	writeJSON(w, http.StatusOK, report)
+}
@@ -0,0 +1,136 @@
+//go:build alltest
+// +build alltest
+
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hashicorp/raft"
+	"github.com/syndtr/goleveldb/leveldb"
+	raftleveldb "github.com/tidwall/raft-leveldb"
+	rafthub "github.com/tidwall/uhaha"
+)
+
+func TestFsck(t *testing.T) {
+	getTestConn()
+	dir := t.TempDir()
+	backend := conf.Backend
+	conf.Backend = rafthub.LevelDB
+	defer func() { conf.Backend = backend }()
+
+	// two snapshots, the second one damaged, and one not completely written
+	snaps, err := raft.NewFileSnapshotStore(dir, 3, io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for i := uint64(1); i <= 2; i++ {
+		sink, err := snaps.Create(raft.SnapshotVersionMax, i*10, 1, raft.Configuration{}, 0, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := sink.Write([]byte("state of the machine")); err != nil {
+			t.Fatal(err)
+		}
+		if err := sink.Close(); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, sink.ID())
+	}
+	state := filepath.Join(dir, "snapshots", ids[1], "state.bin")
+	if err := os.WriteFile(state, []byte("state of the machinX"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(dir, "snapshots", "1-30-1.tmp"), 0o700); err != nil {
+		t.Fatal(err)
+	}
+
+	// a raft log whose entry 4 of 5 is torn
+	path := filepath.Join(dir, "store")
+	store, err := raftleveldb.NewLevelDBStore(path, raftleveldb.High)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := uint64(1); i <= 5; i++ {
+		if err := store.StoreLog(&raft.Log{Index: i, Term: 1, Data: []byte("set k v")}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	store.Close()
+	db, err := leveldb.OpenFile(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := binary.BigEndian.AppendUint64([]byte("logs"), 4)
+	if err := db.Put(key, []byte("torn"), nil); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	fsckMode = "check"
+	report := fsck(dir)
+	if s := report.Parts[0]; s.Status != fsckDamaged || len(s.Quarantined) != 0 {
+		t.Errorf("snapshots checked: %+v", s)
+	}
+	if s := report.Parts[1]; s.Status != fsckDamaged {
+		t.Errorf("raft log checked: %+v", s)
+	}
+
+	fsckMode = "repair"
+	defer func() { fsckMode = "repair" }()
+	report = fsck(dir)
+	if s := report.Parts[0]; s.Status != fsckRepaired || len(s.Quarantined) != 2 {
+		t.Errorf("snapshots repaired: %+v", s)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "snapshots", ids[0])); err != nil {
+		t.Errorf("sound snapshot: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "snapshots", ids[1])); !os.IsNotExist(err) {
+		t.Errorf("damaged snapshot left: %v", err)
+	}
+	if s := report.Parts[1]; s.Status != fsckRepaired {
+		t.Errorf("raft log repaired: %+v", s)
+	}
+	if s := report.Parts[2]; s.Status != fsckOK && s.Status != fsckSkipped {
+		t.Errorf("storage: %+v", s)
+	}
+
+	store, err = raftleveldb.NewLevelDBStore(path, raftleveldb.High)
+	if err != nil {
+		t.Fatal(err)
+	}
+	last, err := store.LastIndex()
+	store.Close()
+	if err != nil || last != 3 {
+		t.Errorf("raft log truncated to %d: %v", last, err)
+	}
+
+	// the report is on disk and on the admin API
+	data, err := os.ReadFile(filepath.Join(dir, "fsck.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var saved fsckReport
+	if err := json.Unmarshal(data, &saved); err != nil || saved.Mode != "repair" || len(saved.Parts) != 3 {
+		t.Errorf("saved report: %+v %v", saved, err)
+	}
+	w := adminRequest(t, adminHandler("secret"), "GET", "/v1/fsck", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET /v1/fsck: %d %s", w.Code, w.Body)
+	}
+
+	// a second run finds nothing
+	report = fsck(dir)
+	for _, s := range report.Parts {
+		if s.Status != fsckOK && s.Status != fsckSkipped {
+			t.Errorf("after repair: %+v", s)
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"strings"
+	"sync"
+
+	"github.com/redis/go-redis/v9"
+	rafthub "github.com/tidwall/uhaha"
+)
+
+// the most pools of the gateways, one for each set of credentials seen
+const gatewayMaxPools = 256
+
+// gatewayPools are the RESP clients the gRPC and REST gateways run the
+// commands of their callers with, by their credentials. The commands go
+// through the port of the node as those of any RESP client, so they meet
+// the same ACL, limits, node mode and namespace encryption.
+var gatewayPools = &gatewayRegistry{pools: make(map[[32]byte]*gatewayPool)}
+
+type gatewayRegistry struct {
+	mu    sync.Mutex
+	pools map[[32]byte]*gatewayPool
+}
+
+// gatewayPool is a pool with the count of the commands running on it. A
+// pool evicted or dropped while in use is closed by its last command.
+type gatewayPool struct {
+	*redis.Client
+	refs    int
+	removed bool
+}
+
+// gatewayKey identifies the credentials of a caller
+func gatewayKey(user, password string) [32]byte {
+	return sha256.Sum256([]byte(user + "\x00" + password))
+}
+
+// get returns the pool of the credentials of a caller, the default user
+// when they are empty. The pool must be given back with release.
+func (g *gatewayRegistry) get(user, password string) *gatewayPool {
+	key := gatewayKey(user, password)
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if p := g.pools[key]; p != nil {
+		p.refs++
+		return p
+	}
+	if len(g.pools) >= gatewayMaxPools {
+		g.evict()
+	}
+	p := &gatewayPool{Client: redis.NewClient(&redis.Options{
+		Addr: conf.Addr, Username: user, Password: password,
+		TLSConfig: serverTLS, Protocol: 2, DisableIdentity: true, MaxRetries: -1,
+	}), refs: 1}
+	g.pools[key] = p
+	return p
+}
+
+// evict removes a pool to make room for another, an idle one when there is
+// any, so that the commands running on the others are not cut
+func (g *gatewayRegistry) evict() {
+	var key [32]byte
+	var victim *gatewayPool
+	for k, p := range g.pools {
+		key, victim = k, p
+		if p.refs == 0 {
+			break
+		}
+	}
+	if victim != nil {
+		g.remove(key, victim)
+	}
+}
+
+// remove takes a pool out of the registry, closing it when it is idle
+func (g *gatewayRegistry) remove(key [32]byte, p *gatewayPool) {
+	delete(g.pools, key)
+	p.removed = true
+	if p.refs == 0 {
+		_ = p.Close()
+	}
+}
+
+// release gives back a pool got from get
+func (g *gatewayRegistry) release(p *gatewayPool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	p.refs--
+	if p.removed && p.refs == 0 {
+		_ = p.Close()
+	}
+}
+
+// drop removes the pool of credentials refused by the node
+func (g *gatewayRegistry) drop(user, password string) {
+	key := gatewayKey(user, password)
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if p := g.pools[key]; p != nil {
+		g.remove(key, p)
+	}
+}
+
+// gatewayDo runs a command as the caller at addr of a gateway. A nil reply
+// is returned as nil, an error reply as an error. The command follows
+// GATEWAYCLIENT on the same connection, so it meets the limits of the
+// address of the caller rather than those of the gateway.
+func gatewayDo(ctx context.Context, addr, user, password string, args ...string) (interface{}, error) {
+	cmdArgs := make([]interface{}, len(args))
+	for i, arg := range args {
+		cmdArgs[i] = arg
+	}
+	p := gatewayPools.get(user, password)
+	pipe := p.Pipeline()
+	pipe.Do(ctx, "gatewayclient", gatewaySecret, addrIP(addr))
+	cmd := pipe.Do(ctx, cmdArgs...)
+	if _, err := pipe.Exec(ctx); err != nil && cmd.Err() == nil {
+		// the connection was refused before the commands were sent, as on
+		// the AUTH of wrong credentials
+		cmd.SetErr(err)
+	}
+	gatewayPools.release(p)
+	v, err := cmd.Result()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil && isAuthError(err) {
+		gatewayPools.drop(user, password)
+	}
+	return v, err
+}
+
+// isAuthError returns whether the node refused the credentials of a caller
+func isAuthError(err error) bool {
+	msg := err.Error()
+	return strings.HasPrefix(msg, "WRONGPASS") || strings.HasPrefix(msg, "NOAUTH") ||
+		strings.HasSuffix(msg, rafthub.ErrUnauthorized.Error())
+}
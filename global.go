@@ -2,6 +2,7 @@ package main
 
 import (
 	"fmt"
+	"strings"
 
 	lediscfg "github.com/ledisdb/ledisdb/config"
 
@@ -26,7 +27,26 @@ var (
 	respClientNum int64
 )
 
-var conf rafthub.Config // raft config
+var conf raftConfig // raft config
+
+// raftConfig is the raft config whose commands are instrumented for the
+// metrics, the tracing and the logs, and whose writes are fenced by the disk
+// guard, passed to the watchers and kept in the history
+type raftConfig struct {
+	rafthub.Config
+}
+
+func (c *raftConfig) AddReadCommand(name string, fn func(m rafthub.Machine, args []string) (interface{}, error)) {
+	fn = instrument(name, logged(name, fn))
+	readCommands[strings.ToLower(name)] = fn
+	c.Config.AddReadCommand(name, traced(name, false, fn))
+}
+
+func (c *raftConfig) AddWriteCommand(name string, fn func(m rafthub.Machine, args []string) (interface{}, error)) {
+	fn = instrument(name, logged(name, fenced(name, watched(name, kept(name, fn)))))
+	writeCommands[strings.ToLower(name)] = fn
+	c.Config.AddWriteCommand(name, traced(name, true, fn))
+}
 
 var banner string
 
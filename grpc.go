@@ -0,0 +1,527 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"github.com/gomodule/redigo/redis"
+	rafthub "github.com/tidwall/uhaha"
+)
+
+// the address of the gRPC API, disabled when empty
+var grpcAddr string
+
+// the name of the service of proto/icefiredb.proto
+const grpcServiceName = "icefiredb.v1.IceFireDB"
+
+// grpcRequest holds the fields of every request of the service, which
+// share their numbers
+type grpcRequest struct {
+	Key         string            // 1
+	Value       []byte            // 2
+	TTL         int64             // 3
+	Field       string            // 4
+	Keys        []string          // 5
+	Items       []string          // 6
+	Values      [][]byte          // 7
+	Front       bool              // 8
+	Start, Stop int64             // 9, 10
+	Fields      map[string][]byte // 11
+	Prefix      string            // 12
+	Cursor      string            // 13
+}
+
+// grpcReply holds the fields of every reply of the service
+type grpcReply struct {
+	Value  []byte            // 1
+	Found  bool              // 2
+	Values [][]byte          // 3
+	Fields map[string][]byte // 4
+	Items  []string          // 5
+	Count  int64             // 6
+	Cursor string            // 7
+	Op     string            // 8
+	Key    string            // 9
+}
+
+func (r *grpcRequest) unmarshal(b []byte) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+		switch typ {
+		case protowire.BytesType:
+			v, n := protowire.ConsumeBytes(b)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			b = b[n:]
+			switch num {
+			case 1:
+				r.Key = string(v)
+			case 2:
+				r.Value = append([]byte{}, v...)
+			case 4:
+				r.Field = string(v)
+			case 5:
+				r.Keys = append(r.Keys, string(v))
+			case 6:
+				r.Items = append(r.Items, string(v))
+			case 7:
+				r.Values = append(r.Values, append([]byte{}, v...))
+			case 11:
+				k, val, err := unmarshalMapEntry(v)
+				if err != nil {
+					return err
+				}
+				if r.Fields == nil {
+					r.Fields = make(map[string][]byte)
+				}
+				r.Fields[k] = val
+			case 12:
+				r.Prefix = string(v)
+			case 13:
+				r.Cursor = string(v)
+			}
+		case protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			b = b[n:]
+			switch num {
+			case 3:
+				r.TTL = int64(v)
+			case 8:
+				r.Front = v != 0
+			case 9:
+				r.Start = int64(v)
+			case 10:
+				r.Stop = int64(v)
+			}
+		default:
+			n := protowire.ConsumeFieldValue(num, typ, b)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			b = b[n:]
+		}
+	}
+	return nil
+}
+
+// unmarshalMapEntry decodes an entry of a map<string, bytes>
+func unmarshalMapEntry(b []byte) (key string, value []byte, err error) {
+	value = []byte{}
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return "", nil, protowire.ParseError(n)
+		}
+		b = b[n:]
+		if typ != protowire.BytesType {
+			if n = protowire.ConsumeFieldValue(num, typ, b); n < 0 {
+				return "", nil, protowire.ParseError(n)
+			}
+			b = b[n:]
+			continue
+		}
+		v, n := protowire.ConsumeBytes(b)
+		if n < 0 {
+			return "", nil, protowire.ParseError(n)
+		}
+		b = b[n:]
+		switch num {
+		case 1:
+			key = string(v)
+		case 2:
+			value = append([]byte{}, v...)
+		}
+	}
+	return key, value, nil
+}
+
+func (r *grpcReply) marshal() []byte {
+	var b []byte
+	if len(r.Value) > 0 {
+		b = protowire.AppendTag(b, 1, protowire.BytesType)
+		b = protowire.AppendBytes(b, r.Value)
+	}
+	if r.Found {
+		b = protowire.AppendTag(b, 2, protowire.VarintType)
+		b = protowire.AppendVarint(b, 1)
+	}
+	for _, v := range r.Values {
+		b = protowire.AppendTag(b, 3, protowire.BytesType)
+		b = protowire.AppendBytes(b, v)
+	}
+	fields := make([]string, 0, len(r.Fields))
+	for f := range r.Fields {
+		fields = append(fields, f)
+	}
+	sort.Strings(fields)
+	for _, f := range fields {
+		var entry []byte
+		entry = protowire.AppendTag(entry, 1, protowire.BytesType)
+		entry = protowire.AppendString(entry, f)
+		entry = protowire.AppendTag(entry, 2, protowire.BytesType)
+		entry = protowire.AppendBytes(entry, r.Fields[f])
+		b = protowire.AppendTag(b, 4, protowire.BytesType)
+		b = protowire.AppendBytes(b, entry)
+	}
+	for _, item := range r.Items {
+		b = protowire.AppendTag(b, 5, protowire.BytesType)
+		b = protowire.AppendString(b, item)
+	}
+	if r.Count != 0 {
+		b = protowire.AppendTag(b, 6, protowire.VarintType)
+		b = protowire.AppendVarint(b, uint64(r.Count))
+	}
+	for i, s := range []string{r.Cursor, r.Op, r.Key} {
+		if s != "" {
+			b = protowire.AppendTag(b, protowire.Number(7+i), protowire.BytesType)
+			b = protowire.AppendString(b, s)
+		}
+	}
+	return b
+}
+
+// grpcCodec encodes the messages of the service in the protobuf wire
+// format, without generated code
+type grpcCodec struct{}
+
+func (grpcCodec) Marshal(v interface{}) ([]byte, error) {
+	r, ok := v.(*grpcReply)
+	if !ok {
+		return nil, fmt.Errorf("cannot marshal %T", v)
+	}
+	return r.marshal(), nil
+}
+
+func (grpcCodec) Unmarshal(data []byte, v interface{}) error {
+	r, ok := v.(*grpcRequest)
+	if !ok {
+		return fmt.Errorf("cannot unmarshal into %T", v)
+	}
+	return r.unmarshal(data)
+}
+
+func (grpcCodec) Name() string {
+	return "proto"
+}
+
+// runFunc runs a command as the caller of a gateway
+type runFunc func(args ...string) (interface{}, error)
+
+// grpcCall runs a request with the commands of its method
+type grpcCall func(do runFunc, req *grpcRequest) (*grpcReply, error)
+
+// the methods of the service, each runs the commands of its comment in
+// proto/icefiredb.proto
+var grpcMethods = map[string]grpcCall{
+	"Get": func(do runFunc, req *grpcRequest) (*grpcReply, error) {
+		return valueReply(do("get", req.Key))
+	},
+	"Set": func(do runFunc, req *grpcRequest) (*grpcReply, error) {
+		args := []string{"set", req.Key, string(req.Value)}
+		if req.TTL > 0 {
+			args = append(args, "ex", strconv.FormatInt(req.TTL, 10))
+		}
+		_, err := do(args...)
+		return &grpcReply{}, err
+	},
+	"Delete": func(do runFunc, req *grpcRequest) (*grpcReply, error) {
+		return countReply(do(append([]string{"del"}, req.Keys...)...))
+	},
+	"HGet": func(do runFunc, req *grpcRequest) (*grpcReply, error) {
+		return valueReply(do("hget", req.Key, req.Field))
+	},
+	"HSet": func(do runFunc, req *grpcRequest) (*grpcReply, error) {
+		args := []string{"hset", req.Key}
+		for f, v := range req.Fields {
+			args = append(args, f, string(v))
+		}
+		return countReply(do(args...))
+	},
+	"HGetAll": func(do runFunc, req *grpcRequest) (*grpcReply, error) {
+		v, err := do("hgetall", req.Key)
+		if err != nil {
+			return nil, err
+		}
+		pairs, _ := v.([]interface{})
+		r := &grpcReply{Fields: make(map[string][]byte, len(pairs)/2)}
+		for i := 0; i+1 < len(pairs); i += 2 {
+			r.Fields[fmt.Sprint(pairs[i])] = replyBytes(pairs[i+1])
+		}
+		return r, nil
+	},
+	"HDel": func(do runFunc, req *grpcRequest) (*grpcReply, error) {
+		return countReply(do(append([]string{"hdel", req.Key}, req.Items...)...))
+	},
+	"Push": func(do runFunc, req *grpcRequest) (*grpcReply, error) {
+		args := []string{"rpush", req.Key}
+		if req.Front {
+			args[0] = "lpush"
+		}
+		for _, v := range req.Values {
+			args = append(args, string(v))
+		}
+		return countReply(do(args...))
+	},
+	"LRange": func(do runFunc, req *grpcRequest) (*grpcReply, error) {
+		v, err := do("lrange", req.Key, strconv.FormatInt(req.Start, 10), strconv.FormatInt(req.Stop, 10))
+		if err != nil {
+			return nil, err
+		}
+		values, _ := v.([]interface{})
+		r := &grpcReply{Values: make([][]byte, len(values))}
+		for i, e := range values {
+			r.Values[i] = replyBytes(e)
+		}
+		return r, nil
+	},
+	"SAdd": func(do runFunc, req *grpcRequest) (*grpcReply, error) {
+		return countReply(do(append([]string{"sadd", req.Key}, req.Items...)...))
+	},
+	"SRem": func(do runFunc, req *grpcRequest) (*grpcReply, error) {
+		return countReply(do(append([]string{"srem", req.Key}, req.Items...)...))
+	},
+	"SMembers": func(do runFunc, req *grpcRequest) (*grpcReply, error) {
+		v, err := do("smembers", req.Key)
+		if err != nil {
+			return nil, err
+		}
+		members, _ := v.([]interface{})
+		r := &grpcReply{Items: make([]string, len(members))}
+		for i, m := range members {
+			r.Items[i] = fmt.Sprint(m)
+		}
+		return r, nil
+	},
+}
+
+// replyBytes returns the bytes of a bulk reply
+func replyBytes(v interface{}) []byte {
+	switch v := v.(type) {
+	case string:
+		return []byte(v)
+	case []byte:
+		return v
+	case nil:
+		return nil
+	}
+	return []byte(fmt.Sprint(v))
+}
+
+func valueReply(v interface{}, err error) (*grpcReply, error) {
+	if err != nil || v == nil {
+		return &grpcReply{}, err
+	}
+	return &grpcReply{Value: replyBytes(v), Found: true}, nil
+}
+
+func countReply(v interface{}, err error) (*grpcReply, error) {
+	n, _ := v.(int64)
+	return &grpcReply{Count: n}, err
+}
+
+// grpcCredentials returns the user and the password of the Basic
+// authorization of a call, empty for the default user
+func grpcCredentials(ctx context.Context) (user, password string) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, auth := range md.Get("authorization") {
+		basic, ok := strings.CutPrefix(auth, "Basic ")
+		if !ok {
+			continue
+		}
+		raw, err := base64.StdEncoding.DecodeString(basic)
+		if err != nil {
+			continue
+		}
+		user, password, _ = strings.Cut(string(raw), ":")
+		return user, password
+	}
+	return "", ""
+}
+
+// grpcCaller returns the address of the caller of a call
+func grpcCaller(ctx context.Context) string {
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		return p.Addr.String()
+	}
+	return ""
+}
+
+// grpcError returns the status of the error reply of a command
+func grpcError(err error) error {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return status.FromContextError(err).Err()
+	}
+	msg := err.Error()
+	code := codes.Unknown
+	switch {
+	case isAuthError(err):
+		code = codes.Unauthenticated
+	case strings.HasPrefix(msg, "NOPERM"):
+		code = codes.PermissionDenied
+	case strings.HasPrefix(msg, "LIMIT"):
+		code = codes.ResourceExhausted
+	case strings.HasPrefix(msg, "MOVED "), strings.HasPrefix(msg, "TRY "), strings.HasPrefix(msg, "CLUSTERDOWN"),
+		strings.HasPrefix(msg, "READONLY"), strings.HasPrefix(msg, "MAINTENANCE"):
+		code = codes.Unavailable
+	case strings.HasPrefix(msg, "WRONGTYPE"):
+		code = codes.FailedPrecondition
+	case msg == errWatchExpired.Error():
+		code = codes.OutOfRange
+	case msg == errWatchBehind.Error():
+		code = codes.Aborted
+	case msg == errWatchCursor.Error(), strings.Contains(msg, rafthub.ErrWrongNumArgs.Error()):
+		// an empty list of keys or items
+		code = codes.InvalidArgument
+	}
+	return status.Error(code, msg)
+}
+
+// grpcServiceDesc describes the service of proto/icefiredb.proto
+func grpcServiceDesc() *grpc.ServiceDesc {
+	desc := &grpc.ServiceDesc{
+		ServiceName: grpcServiceName,
+		HandlerType: (*interface{})(nil),
+		Metadata:    "icefiredb.proto",
+	}
+	names := make([]string, 0, len(grpcMethods))
+	for name := range grpcMethods {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		call := grpcMethods[name]
+		desc.Methods = append(desc.Methods, grpc.MethodDesc{
+			MethodName: name,
+			Handler: func(_ interface{}, ctx context.Context, dec func(interface{}) error, _ grpc.UnaryServerInterceptor) (interface{}, error) {
+				req := &grpcRequest{}
+				if err := dec(req); err != nil {
+					return nil, status.Error(codes.InvalidArgument, err.Error())
+				}
+				user, password := grpcCredentials(ctx)
+				r, err := call(func(args ...string) (interface{}, error) {
+					return gatewayDo(ctx, grpcCaller(ctx), user, password, args...)
+				}, req)
+				if err != nil {
+					return nil, grpcError(err)
+				}
+				return r, nil
+			},
+		})
+	}
+	desc.Streams = append(desc.Streams, grpc.StreamDesc{
+		StreamName:    "Watch",
+		Handler:       grpcWatch,
+		ServerStreams: true,
+	})
+	return desc
+}
+
+// grpcWatch streams the changes of WATCHPREFIX prefix [cursor], run as a
+// RESP client of the port as the other calls
+func grpcWatch(_ interface{}, stream grpc.ServerStream) error {
+	req := &grpcRequest{}
+	if err := stream.RecvMsg(req); err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	ctx := stream.Context()
+	conn, err := rafthub.RedisDial(conf.Addr, "", serverTLS)
+	if err != nil {
+		return status.Error(codes.Unavailable, err.Error())
+	}
+	defer conn.Close()
+	if _, err := conn.Do("gatewayclient", gatewaySecret, addrIP(grpcCaller(ctx))); err != nil {
+		return grpcError(err)
+	}
+	if user, password := grpcCredentials(ctx); user != "" {
+		_, err = conn.Do("auth", user, password)
+	} else if password != "" {
+		_, err = conn.Do("auth", password)
+	}
+	if err != nil {
+		return grpcError(err)
+	}
+	args := []interface{}{req.Prefix}
+	if req.Cursor != "" {
+		args = append(args, req.Cursor)
+	}
+	if err := conn.Send("watchprefix", args...); err != nil {
+		return grpcError(err)
+	}
+	if err := conn.Flush(); err != nil {
+		return grpcError(err)
+	}
+	// the connection is closed to stop reading when the caller leaves
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+	for {
+		msg, err := redis.Strings(conn.Receive())
+		if ctx.Err() != nil {
+			return status.FromContextError(ctx.Err()).Err()
+		}
+		if err != nil {
+			return grpcError(err)
+		}
+		if len(msg) != 4 && len(msg) != 3 {
+			return status.Errorf(codes.Internal, "unexpected reply %q", msg)
+		}
+		r := &grpcReply{Op: msg[0], Cursor: msg[len(msg)-1]}
+		if msg[0] == "change" {
+			r.Cursor, r.Op, r.Key = msg[1], msg[2], msg[3]
+		}
+		if err := stream.SendMsg(r); err != nil {
+			return err
+		}
+	}
+}
+
+// newGRPCServer returns the gRPC server of the API, over TLS when the server
+// has --tls-cert and --tls-key
+func newGRPCServer() (*grpc.Server, error) {
+	opts := []grpc.ServerOption{grpc.ForceServerCodec(grpcCodec{})}
+	if conf.TLSCertPath != "" {
+		creds, err := credentials.NewServerTLSFromFile(conf.TLSCertPath, conf.TLSKeyPath)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, grpc.Creds(creds))
+	}
+	srv := grpc.NewServer(opts...)
+	srv.RegisterService(grpcServiceDesc(), struct{}{})
+	return srv, nil
+}
+
+// serveGRPC serves the gRPC API on --grpc-addr
+func serveGRPC(addr string) {
+	srv, err := newGRPCServer()
+	if err == nil {
+		var ln net.Listener
+		if ln, err = net.Listen("tcp", addr); err == nil {
+			loggers[logServer].Info("serving the gRPC API", zap.String("addr", ln.Addr().String()))
+			err = srv.Serve(&filteredListener{Listener: ln, name: listenerGRPC})
+		}
+	}
+	loggers[logServer].Error("gRPC listen fail", zap.Error(err))
+}
@@ -0,0 +1,193 @@
+//go:build alltest
+// +build alltest
+
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"net"
+	"path/filepath"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// rawCodec sends and receives the encoded messages as they are
+type rawCodec struct{}
+
+func (rawCodec) Marshal(v interface{}) ([]byte, error)      { return *v.(*[]byte), nil }
+func (rawCodec) Unmarshal(data []byte, v interface{}) error { *v.(*[]byte) = data; return nil }
+func (rawCodec) Name() string                               { return "proto" }
+
+// decodeReply returns the raw values of the fields of a reply by number
+func decodeReply(t *testing.T, b []byte) map[protowire.Number][][]byte {
+	t.Helper()
+	fields := make(map[protowire.Number][][]byte)
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		b = b[n:]
+		n = protowire.ConsumeFieldValue(num, typ, b)
+		if n < 0 {
+			t.Fatalf("reply: %v", protowire.ParseError(n))
+		}
+		v := b[:n]
+		if typ == protowire.BytesType {
+			v, _ = protowire.ConsumeBytes(v)
+		}
+		fields[num] = append(fields[num], v)
+		b = b[n:]
+	}
+	return fields
+}
+
+func TestGRPCCodec(t *testing.T) {
+	var b []byte
+	b = protowire.AppendTag(b, 1, protowire.BytesType)
+	b = protowire.AppendString(b, "k")
+	b = protowire.AppendTag(b, 10, protowire.VarintType)
+	stop := int64(-1)
+	b = protowire.AppendVarint(b, uint64(stop))
+	var entry []byte
+	entry = protowire.AppendTag(entry, 1, protowire.BytesType)
+	entry = protowire.AppendString(entry, "f")
+	entry = protowire.AppendTag(entry, 2, protowire.BytesType)
+	entry = protowire.AppendString(entry, "v")
+	b = protowire.AppendTag(b, 11, protowire.BytesType)
+	b = protowire.AppendBytes(b, entry)
+	// an unknown field is skipped
+	b = protowire.AppendTag(b, 99, protowire.Fixed64Type)
+	b = protowire.AppendFixed64(b, 7)
+	var req grpcRequest
+	if err := req.unmarshal(b); err != nil {
+		t.Fatal(err)
+	}
+	if req.Key != "k" || req.Stop != -1 || string(req.Fields["f"]) != "v" {
+		t.Errorf("request %+v", req)
+	}
+	if err := req.unmarshal(b[:len(b)-3]); err == nil {
+		t.Errorf("truncated request decoded")
+	}
+
+	r := decodeReply(t, (&grpcReply{Found: true, Values: [][]byte{{}, []byte("x")}, Count: 3}).marshal())
+	if len(r[2]) != 1 || len(r[3]) != 2 || len(r[3][0]) != 0 || string(r[3][1]) != "x" {
+		t.Errorf("reply %q", r)
+	}
+	if v, _ := protowire.ConsumeVarint(r[6][0]); v != 3 {
+		t.Errorf("count %d", v)
+	}
+}
+
+func TestGRPC(t *testing.T) {
+	getTestConn()
+	srv, err := newGRPCServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Serve(ln)
+	defer srv.Stop()
+	cc, err := grpc.NewClient(ln.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cc.Close()
+	ctx := context.Background()
+	call := func(ctx context.Context, method string, req []byte) ([]byte, error) {
+		var reply []byte
+		err := cc.Invoke(ctx, "/"+grpcServiceName+"/"+method, &req, &reply, grpc.ForceCodec(rawCodec{}))
+		return reply, err
+	}
+	field := func(num protowire.Number, v string) []byte {
+		b := protowire.AppendTag(nil, num, protowire.BytesType)
+		return protowire.AppendString(b, v)
+	}
+
+	if _, err := call(ctx, "Set", append(field(1, "grpc:k"), field(2, "v")...)); err != nil {
+		t.Fatal(err)
+	}
+	reply, err := call(ctx, "Get", field(1, "grpc:k"))
+	if r := decodeReply(t, reply); err != nil || string(r[1][0]) != "v" || len(r[2]) != 1 {
+		t.Errorf("Get %q %v", r, err)
+	}
+	reply, err = call(ctx, "Get", field(1, "grpc:missing"))
+	if r := decodeReply(t, reply); err != nil || len(r) != 0 {
+		t.Errorf("Get of a missing key %q %v", r, err)
+	}
+	if _, err := call(ctx, "SAdd", append(field(1, "grpc:s"), append(field(6, "a"), field(6, "b")...)...)); err != nil {
+		t.Fatal(err)
+	}
+	reply, err = call(ctx, "SMembers", field(1, "grpc:s"))
+	if r := decodeReply(t, reply); err != nil || len(r[5]) != 2 {
+		t.Errorf("SMembers %q %v", r, err)
+	}
+	if _, err := call(ctx, "Delete", nil); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Delete of no key: %v", err)
+	}
+
+	// Watch streams the changes of WATCHPREFIX
+	watch := func(ctx context.Context, req []byte) grpc.ClientStream {
+		t.Helper()
+		stream, err := cc.NewStream(ctx, &grpc.StreamDesc{ServerStreams: true}, "/"+grpcServiceName+"/Watch", grpc.ForceCodec(rawCodec{}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := stream.SendMsg(&req); err != nil {
+			t.Fatal(err)
+		}
+		_ = stream.CloseSend()
+		return stream
+	}
+	wctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stream := watch(wctx, field(12, "grpc:w"))
+	var msg []byte
+	if err := stream.RecvMsg(&msg); err != nil || string(decodeReply(t, msg)[8][0]) != "watching" {
+		t.Fatalf("Watch %q %v", msg, err)
+	}
+	getTestConn().Set(ctx, "grpc:w1", "v", 0)
+	if err := stream.RecvMsg(&msg); err != nil {
+		t.Fatal(err)
+	}
+	if r := decodeReply(t, msg); string(r[8][0]) != "set" || string(r[9][0]) != "grpc:w1" || len(r[7]) != 1 {
+		t.Errorf("Watch change %q", r)
+	}
+	cancel()
+	if err := watch(ctx, append(field(12, "grpc:w"), field(13, "1")...)).RecvMsg(&msg); status.Code(err) != codes.OutOfRange {
+		t.Errorf("Watch out of the backlog: %v", err)
+	}
+
+	// the calls run as the user of their credentials
+	path := filepath.Join(t.TempDir(), "acl.json")
+	writeACL(t, path, "pw", nil, aclUserConfig{Name: "reader", Roles: []string{"readonly"}})
+	if err := acl.load(path); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		acl.mu.Lock()
+		acl.users, acl.roles, acl.providers, acl.failures = nil, nil, nil, make(map[string]*aclFailures)
+		acl.mu.Unlock()
+	}()
+	basic := func(user, password string) context.Context {
+		auth := "Basic " + base64.StdEncoding.EncodeToString([]byte(user+":"+password))
+		return metadata.AppendToOutgoingContext(ctx, "authorization", auth)
+	}
+	if _, err := call(basic("reader", "wrong"), "Get", field(1, "grpc:k")); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("Get with a wrong password: %v", err)
+	}
+	if _, err := call(basic("reader", "pw"), "Get", field(1, "grpc:k")); err != nil {
+		t.Errorf("Get of the reader: %v", err)
+	}
+	if _, err := call(basic("reader", "pw"), "Set", append(field(1, "grpc:k"), field(2, "w")...)); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("Set of the reader: %v", err)
+	}
+	getTestConn().Del(ctx, "grpc:k", "grpc:s", "grpc:w1")
+}
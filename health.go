@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/tidwall/redcon"
+)
+
+// the applied index may lag the commit index by this many entries on a
+// ready node
+var readyMaxLag uint64 = 1000
+
+// readyKey is read to check the storage, it is never written
+var readyKey = []byte("__icefiredb_readyz")
+
+// readiness is the body of GET /readyz, each check is "ok" or the reason
+// the node is not ready
+type readiness struct {
+	Ready  bool              `json:"ready"`
+	Checks map[string]string `json:"checks"`
+}
+
+// checkReady checks the node can serve: it is a member of a cluster that
+// has a leader, it has applied the committed entries, it does not drain and
+// its storage reads
+func checkReady() readiness {
+	r := readiness{Ready: true, Checks: map[string]string{
+		"raft":    "ok",
+		"member":  "ok",
+		"lag":     "ok",
+		"storage": "ok",
+		"mode":    "ok",
+		"drain":   "ok",
+	}}
+	fail := func(check, reason string) {
+		r.Ready = false
+		r.Checks[check] = reason
+	}
+
+	if resp, err := localDo("raft", "info"); err != nil {
+		fail("raft", err.Error())
+		fail("lag", "unknown")
+	} else {
+		info := make(map[string]string)
+		for k, v := range resp.Map() {
+			info[k] = v.String()
+		}
+		if state := info["state"]; state != "Leader" && state != "Follower" {
+			fail("raft", "state "+state)
+		}
+		// the entries committed and not applied here, and the entries of the
+		// leader not yet applied here, polled by the raft machine
+		commit, _ := strconv.ParseUint(info["commit_index"], 10, 64)
+		applied, _ := strconv.ParseUint(info["applied_index"], 10, 64)
+		behind, _ := strconv.ParseUint(info["logs_behind"], 10, 64)
+		if commit > applied && commit-applied > readyMaxLag {
+			fail("lag", fmt.Sprintf("applied index %d is %d behind the commit index", applied, commit-applied))
+		} else if behind > readyMaxLag {
+			fail("lag", fmt.Sprintf("%d entries behind the leader", behind))
+		}
+	}
+
+	if resp, err := localDo("raft", "leader"); err != nil || resp.String() == "" {
+		fail("member", "no leader")
+	} else if resp, err = localDo("raft", "server", "list"); err != nil {
+		fail("member", err.Error())
+	} else {
+		member := false
+		resp.ForEach(func(s redcon.RESP) bool {
+			member = s.MapGet("id").String() == conf.NodeID
+			return !member
+		})
+		if !member {
+			fail("member", "not a member of the cluster")
+		}
+	}
+
+	if mode, _, _ := nodeMode.get(); mode == nodeModeMaintenance {
+		fail("mode", "maintenance")
+	}
+
+	if since := drainingSince.Load(); since != nil {
+		fail("drain", "draining since "+since.UTC().Format(time.RFC3339))
+	}
+
+	if ldb == nil {
+		fail("storage", "not open")
+	} else if _, err := ldb.Exists(readyKey); err != nil {
+		fail("storage", err.Error())
+	}
+	return r
+}
+
+// handleHealthz answers as long as the process serves, a node still
+// starting or out of its cluster is alive
+func handleHealthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain")
+	_, _ = w.Write([]byte("ok\n"))
+}
+
+// handleReadyz answers 200 when the node is ready to serve clients, 503
+// with the failed checks otherwise
+func handleReadyz(w http.ResponseWriter, r *http.Request) {
+	ready := checkReady()
+	code := http.StatusOK
+	if !ready.Ready {
+		code = http.StatusServiceUnavailable
+	}
+	writeJSON(w, code, ready)
+}
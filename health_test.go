@@ -0,0 +1,39 @@
+//go:build alltest
+// +build alltest
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestReadyz(t *testing.T) {
+	getTestConn()
+	h := adminHandler("secret")
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/healthz", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("healthz %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/readyz", nil))
+	var ready readiness
+	if err := json.NewDecoder(w.Body).Decode(&ready); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusOK || !ready.Ready {
+		t.Errorf("the leader of a single node cluster must be ready, got %d %v", w.Code, ready.Checks)
+	}
+
+	id := conf.NodeID
+	conf.NodeID = "9"
+	defer func() { conf.NodeID = id }()
+	if ready = checkReady(); ready.Ready || ready.Checks["member"] == "ok" {
+		t.Errorf("a node out of the cluster must not be ready, got %v", ready.Checks)
+	}
+}
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"errors"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ledisdb/ledisdb/ledis"
+	rafthub "github.com/tidwall/uhaha"
+)
+
+// how long the past values of the strings are kept for GETAT and SCANAT, 0
+// keeps none
+var historyRetention time.Duration
+
+var (
+	errHistoryDisabled = errors.New("ERR the history is disabled, start the node with --history-retention")
+	errHistoryExpired  = errors.New("ERR the time is out of the history of the node")
+	errHistoryTime     = errors.New("ERR invalid time")
+)
+
+// the writes of the strings, whose keys have their values before the write
+// kept, and the position of the key
+var historyCommands = map[string]int{
+	"set": 1, "setnx": 1, "setex": 1, "setexat": 1, "getset": 1,
+	"append": 1, "setrange": 1, "setbit": 1,
+	"incr": 1, "incrby": 1, "decr": 1, "decrby": 1,
+	"mset": 1, "del": 1, "bitop": 2,
+}
+
+// the past values of the strings
+var history = &historyLog{keys: make(map[string][]keyVersion)}
+
+func init() {
+	conf.AddReadCommand("GETAT", cmdGETAT)
+	conf.AddReadCommand("SCANAT", cmdSCANAT)
+}
+
+// keyVersion is the value of a key before a write. The value of a key at a
+// time is the one before its first write after the time, or its value now.
+type keyVersion struct {
+	cursor int64
+	// nil when the key was missing
+	prev []byte
+}
+
+// historyLog is the past values of the keys written in the retention, by
+// the cursor of their writes as WATCHPREFIX
+type historyLog struct {
+	mu   sync.Mutex
+	keys map[string][]keyVersion
+	// the writes in the order of the log, to drop them out of the retention
+	order []historyWrite
+	// the first time the history answers, 0 before the first write
+	since int64
+}
+
+type historyWrite struct {
+	cursor int64
+	key    string
+}
+
+// kept keeps the values of the keys of a write of strings before it runs.
+// Every node runs it on the writes of the log, so their histories agree.
+func kept(name string, fn commandFunc) commandFunc {
+	cmd := strings.ToLower(name)
+	pos, ok := historyCommands[cmd]
+	flush := cmd == "flushall" || cmd == "flushdb"
+	if !ok && !flush {
+		return fn
+	}
+	return func(m rafthub.Machine, args []string) (interface{}, error) {
+		if historyRetention == 0 {
+			return fn(m, args)
+		}
+		cursor := m.Now().UnixNano()
+		if flush {
+			// the values before FLUSHALL are not kept
+			v, err := fn(m, args)
+			if err == nil {
+				history.reset(cursor)
+			}
+			return v, err
+		}
+		keys := historyKeys(cmd, pos, args)
+		prevs := make([][]byte, len(keys))
+		for i, key := range keys {
+			v, err := ldb.Get([]byte(key))
+			if err != nil {
+				return nil, err
+			}
+			prevs[i] = v
+		}
+		v, err := fn(m, args)
+		if err == nil {
+			history.record(cursor, keys, prevs)
+		}
+		return v, err
+	}
+}
+
+// historyKeys returns the keys written by a write of strings
+func historyKeys(cmd string, pos int, args []string) []string {
+	switch cmd {
+	case "mset":
+		var keys []string
+		for i := 1; i < len(args); i += 2 {
+			keys = append(keys, args[i])
+		}
+		return keys
+	case "del":
+		return args[1:]
+	}
+	if len(args) > pos {
+		return args[pos : pos+1]
+	}
+	return nil
+}
+
+// record adds the values of the keys before a write, and drops the writes
+// out of the retention
+func (h *historyLog) record(cursor int64, keys []string, prevs [][]byte) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.since == 0 {
+		h.since = cursor
+	}
+	for i, key := range keys {
+		h.keys[key] = append(h.keys[key], keyVersion{cursor, prevs[i]})
+		h.order = append(h.order, historyWrite{cursor, key})
+	}
+	cutoff := cursor - int64(historyRetention)
+	n := 0
+	for ; n < len(h.order) && h.order[n].cursor < cutoff; n++ {
+		key := h.order[n].key
+		if vs := h.keys[key][1:]; len(vs) > 0 {
+			h.keys[key] = vs
+		} else {
+			delete(h.keys, key)
+		}
+	}
+	if n > 0 {
+		h.order = append([]historyWrite(nil), h.order[n:]...)
+		if cutoff > h.since {
+			h.since = cutoff
+		}
+	}
+}
+
+// reset drops the history at a write of every key
+func (h *historyLog) reset(cursor int64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.keys = make(map[string][]keyVersion)
+	h.order = nil
+	h.since = cursor
+}
+
+// valueAt returns the value of a key at a time, and whether it was kept:
+// the key has not been written since, and its value is the one now, when
+// it is not
+func (h *historyLog) valueAt(key string, at int64) ([]byte, bool) {
+	vs := h.keys[key]
+	i := sort.Search(len(vs), func(i int) bool { return vs[i].cursor > at })
+	if i == len(vs) {
+		return nil, false
+	}
+	return vs[i].prev, true
+}
+
+// check returns the error of a read of the history at a time
+func (h *historyLog) check(at int64) error {
+	if historyRetention == 0 {
+		return errHistoryDisabled
+	}
+	if h.since == 0 || at < h.since {
+		return errHistoryExpired
+	}
+	return nil
+}
+
+func parseHistoryTime(arg string) (int64, error) {
+	at, err := strconv.ParseInt(arg, 10, 64)
+	if err != nil || at < 0 {
+		return 0, errHistoryTime
+	}
+	return at, nil
+}
+
+// GETAT key time
+// Returns the value the string had at a time of the log, in nanoseconds as
+// the cursors of WATCHPREFIX, within the history of the node.
+func cmdGETAT(_ rafthub.Machine, args []string) (interface{}, error) {
+	if len(args) != 3 {
+		return nil, rafthub.ErrWrongNumArgs
+	}
+	at, err := parseHistoryTime(args[2])
+	if err != nil {
+		return nil, err
+	}
+	history.mu.Lock()
+	defer history.mu.Unlock()
+	if err := history.check(at); err != nil {
+		return nil, err
+	}
+	v, ok := history.valueAt(args[1], at)
+	if !ok {
+		if v, err = ldb.Get([]byte(args[1])); err != nil {
+			return nil, err
+		}
+	}
+	if v == nil {
+		return nil, nil
+	}
+	return v, nil
+}
+
+// SCANAT time cursor [MATCH match] [COUNT count]
+// Scans the strings which existed at a time of the log, as SCAN.
+func cmdSCANAT(_ rafthub.Machine, args []string) (interface{}, error) {
+	if len(args) < 3 {
+		return nil, rafthub.ErrWrongNumArgs
+	}
+	at, err := parseHistoryTime(args[1])
+	if err != nil {
+		return nil, err
+	}
+	cursor, match, count, desc, err := parseScanArgs(args[2:])
+	if err != nil {
+		return nil, err
+	}
+	if desc {
+		return nil, errors.New("ERR SCANAT does not scan in reverse")
+	}
+	var r *regexp.Regexp
+	if match != "" {
+		if r, err = regexp.Compile(match); err != nil {
+			return nil, err
+		}
+	}
+	history.mu.Lock()
+	defer history.mu.Unlock()
+	if err := history.check(at); err != nil {
+		return nil, err
+	}
+	// the keys now, and those written since the time, up to the last key
+	// of the page
+	keys, err := ldb.Scan(ledis.KV, cursor, count, false, match)
+	if err != nil {
+		return nil, err
+	}
+	next := nilCursorRedis
+	var last string
+	if len(keys) >= count && len(keys) > 0 {
+		next = keys[len(keys)-1]
+		last = string(next)
+	}
+	candidates := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		candidates[string(key)] = true
+	}
+	for key := range history.keys {
+		if key > string(cursor) && (last == "" || key <= last) && (r == nil || r.MatchString(key)) {
+			candidates[key] = true
+		}
+	}
+	names := make([]string, 0, len(candidates))
+	for key := range candidates {
+		names = append(names, key)
+	}
+	sort.Strings(names)
+	out := make([][]byte, 0, len(names))
+	for _, key := range names {
+		v, ok := history.valueAt(key, at)
+		if !ok {
+			if v, err = ldb.Get([]byte(key)); err != nil {
+				return nil, err
+			}
+		}
+		if v != nil {
+			out = append(out, []byte(key))
+		}
+	}
+	return []interface{}{next, out}, nil
+}
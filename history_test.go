@@ -0,0 +1,99 @@
+//go:build alltest
+// +build alltest
+
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+func TestHistoryLog(t *testing.T) {
+	defer func(d time.Duration) { historyRetention = d }(historyRetention)
+	historyRetention = 10
+	h := &historyLog{keys: make(map[string][]keyVersion)}
+	h.record(100, []string{"a"}, [][]byte{nil})
+	h.record(105, []string{"a", "b"}, [][]byte{[]byte("1"), nil})
+	if v, ok := h.valueAt("a", 102); !ok || string(v) != "1" {
+		t.Errorf("a at 102: %q %v", v, ok)
+	}
+	if v, ok := h.valueAt("a", 99); !ok || v != nil {
+		t.Errorf("a at 99: %q %v", v, ok)
+	}
+	if _, ok := h.valueAt("a", 105); ok {
+		t.Error("a at 105 is its value now")
+	}
+	// the write at 100 is out of the retention at 112
+	h.record(112, []string{"c"}, [][]byte{nil})
+	if err := h.check(101); err != errHistoryExpired {
+		t.Errorf("101 after the retention %v", err)
+	}
+	if err := h.check(102); err != nil {
+		t.Error(err)
+	}
+	if len(h.keys["a"]) != 1 || len(h.order) != 3 {
+		t.Errorf("the writes kept %v %v", h.keys, h.order)
+	}
+	h.reset(120)
+	if err := h.check(119); err != errHistoryExpired || len(h.keys) != 0 {
+		t.Errorf("FLUSHALL %v %v", err, h.keys)
+	}
+}
+
+func TestHistory(t *testing.T) {
+	c := getTestConn()
+	ctx := context.Background()
+	if err := c.Do(ctx, "getat", "hist:a", "1").Err(); err == nil || err.Error() != errHistoryDisabled.Error() {
+		t.Errorf("GETAT without --history-retention %v", err)
+	}
+	defer func(d time.Duration) { historyRetention = d }(historyRetention)
+	historyRetention = time.Hour
+	defer c.Del(ctx, "hist:a", "hist:b")
+
+	// the time of the log only moves on the ticks of the leader, every
+	// 200ms, so a write after a time waits for the next one
+	step := func() int64 {
+		time.Sleep(20 * time.Millisecond)
+		now := time.Now().UnixNano()
+		time.Sleep(500 * time.Millisecond)
+		return now
+	}
+	c.Set(ctx, "hist:a", "1", 0)
+	t1 := step()
+	c.Set(ctx, "hist:a", "2", 0)
+	t2 := step()
+	c.Del(ctx, "hist:a")
+	c.Set(ctx, "hist:b", "x", 0)
+	t3 := step()
+
+	for _, tc := range []struct {
+		at   int64
+		want string
+	}{{t1, "1"}, {t2, "2"}} {
+		if v, err := c.Do(ctx, "getat", "hist:a", tc.at).Text(); err != nil || v != tc.want {
+			t.Errorf("GETAT %d: %q %v", tc.at, v, err)
+		}
+	}
+	if err := c.Do(ctx, "getat", "hist:a", t3).Err(); err != redis.Nil {
+		t.Errorf("GETAT of a key deleted %v", err)
+	}
+	if err := c.Do(ctx, "getat", "hist:a", 1).Err(); err == nil {
+		t.Error("GETAT before the history must fail")
+	}
+	scan := func(at int64) []interface{} {
+		v, err := c.Do(ctx, "scanat", at, "0", "MATCH", "^hist:", "COUNT", 100).Slice()
+		if err != nil || len(v) != 2 || v[0] != "0" {
+			t.Fatalf("SCANAT %v %v", v, err)
+		}
+		return v[1].([]interface{})
+	}
+	if keys := scan(t2); len(keys) != 1 || keys[0] != "hist:a" {
+		t.Errorf("SCANAT before DEL %v", keys)
+	}
+	if keys := scan(t3); len(keys) != 1 || keys[0] != "hist:b" {
+		t.Errorf("SCANAT after DEL %v", keys)
+	}
+}
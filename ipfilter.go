@@ -0,0 +1,227 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+	"sync/atomic"
+
+	"go.uber.org/zap"
+)
+
+// the listeners served besides the port and the TLS port
+const (
+	listenerClient   = "client"
+	listenerAdmin    = "admin"
+	listenerMetrics  = "metrics"
+	listenerGRPC     = "grpc"
+	listenerREST     = "rest"
+	listenerWS       = "ws"
+	listenerSentinel = "sentinel"
+	listenerDNS      = "dns"
+)
+
+var (
+	// a port serving the clients only, next to the port of the server shared
+	// with the nodes
+	clientAddr string
+	// --allow and --deny [listener:]cidr, the rules without listener apply
+	// to every listener
+	allowRules, denyRules stringList
+	// with no --auth, refuse the clients that are not on the loopback
+	// interface nor allowed by a rule. It is off by default: the nodes join
+	// on the port of the server, and a node joining is not known yet.
+	protectedMode = "no"
+)
+
+var errProtected = errors.New("DENIED running in protected mode: no --auth is set, so only the clients on the loopback interface are accepted. " +
+	"Set --auth, allow the clients with --allow, or turn off protected mode with --protected-mode no")
+
+// the reasons a connection is refused
+const (
+	refusedDenied    = "denied"
+	refusedProtected = "protected"
+)
+
+// ipFilter holds the allowed and the denied networks of a listener
+type ipFilter struct {
+	allow, deny []netip.Prefix
+}
+
+// the filters by listener, and the connections they refused
+var (
+	ipFilters     = make(map[string]*ipFilter)
+	clientRefused [2]int64
+)
+
+// the listeners the rules may name
+var filterListeners = []string{listenerPort, listenerTLSPort, listenerClient, listenerAdmin, listenerMetrics, listenerGRPC, listenerREST, listenerWS, listenerSentinel, listenerDNS}
+
+// parsePrefix parses a network, or an address standing for itself
+func parsePrefix(s string) (netip.Prefix, error) {
+	if strings.Contains(s, "/") {
+		p, err := netip.ParsePrefix(s)
+		return p.Masked(), err
+	}
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+// loadIPFilters builds the filters of --allow and --deny
+func loadIPFilters() error {
+	filters := make(map[string]*ipFilter)
+	add := func(spec string, deny bool) error {
+		listeners := filterListeners
+		if i := strings.IndexByte(spec, ':'); i >= 0 {
+			for _, l := range filterListeners {
+				if spec[:i] == l {
+					listeners, spec = []string{l}, spec[i+1:]
+					break
+				}
+			}
+		}
+		p, err := parsePrefix(spec)
+		if err != nil {
+			return fmt.Errorf("invalid network '%s'", spec)
+		}
+		for _, l := range listeners {
+			f := filters[l]
+			if f == nil {
+				f = &ipFilter{}
+				filters[l] = f
+			}
+			if deny {
+				f.deny = append(f.deny, p)
+			} else {
+				f.allow = append(f.allow, p)
+			}
+		}
+		return nil
+	}
+	for _, spec := range allowRules {
+		if err := add(spec, false); err != nil {
+			return err
+		}
+	}
+	for _, spec := range denyRules {
+		if err := add(spec, true); err != nil {
+			return err
+		}
+	}
+	switch protectedMode {
+	case "yes", "no":
+	default:
+		return fmt.Errorf("invalid --protected-mode '%s', expected yes or no", protectedMode)
+	}
+	ipFilters = filters
+	return nil
+}
+
+// warnUnprotected logs a warning when the port of the server is open to
+// other hosts without --auth nor the protected mode
+func warnUnprotected() {
+	if conf.Auth != "" || protectedMode == "yes" {
+		return
+	}
+	host, _, err := net.SplitHostPort(conf.Addr)
+	if err != nil || host == "localhost" {
+		return
+	}
+	if ip, err := netip.ParseAddr(host); err == nil && ip.IsLoopback() {
+		return
+	}
+	loggers[logServer].Warn("no --auth and no protected mode: every client reaching the port runs every command, " +
+		"set --auth, or --protected-mode yes with --allow port:cidr for the nodes")
+}
+
+// remoteIP returns the address of a remote end of a connection
+func remoteIP(addr string) netip.Addr {
+	ap, err := netip.ParseAddrPort(addr)
+	if err != nil {
+		return netip.Addr{}
+	}
+	return ap.Addr().Unmap()
+}
+
+// checkClient returns the reason a connection from addr is refused on the
+// listener, empty when it is accepted. A denied network wins over an allowed
+// one, and with allowed networks the others are refused.
+func checkClient(listener, addr string) string {
+	ip := remoteIP(addr)
+	f := ipFilters[listener]
+	allowed := false
+	if f != nil {
+		for _, p := range f.deny {
+			if p.Contains(ip) {
+				return refusedDenied
+			}
+		}
+		for _, p := range f.allow {
+			allowed = allowed || p.Contains(ip)
+		}
+		if len(f.allow) > 0 && !allowed {
+			return refusedDenied
+		}
+	}
+	// the admin API and the metrics do not take the clients of --auth
+	if protectedMode == "yes" && conf.Auth == "" && !allowed && !ip.IsLoopback() &&
+		listener != listenerAdmin && listener != listenerMetrics {
+		return refusedProtected
+	}
+	return ""
+}
+
+// refuseClient returns the reason a connection is refused and counts it
+func refuseClient(listener, addr string) string {
+	reason := checkClient(listener, addr)
+	switch reason {
+	case "":
+		return ""
+	case refusedDenied:
+		atomic.AddInt64(&clientRefused[0], 1)
+	case refusedProtected:
+		atomic.AddInt64(&clientRefused[1], 1)
+	}
+	loggers[logServer].Debug("client refused", zap.String("listener", listener),
+		zap.String("client", addr), zap.String("reason", reason))
+	return reason
+}
+
+// filteredListener closes the connections its listener refuses as soon as
+// they are accepted
+type filteredListener struct {
+	net.Listener
+	name string
+}
+
+func (ln *filteredListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := ln.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		if refuseClient(ln.name, conn.RemoteAddr().String()) == "" {
+			return conn, nil
+		}
+		conn.Close()
+	}
+}
+
+// filterHandshakes refuses the TLS handshakes of the port from the networks
+// denied, the nodes dial the raft transport over it with --tls-cert
+func filterHandshakes(tlscfg *tls.Config) {
+	tlscfg.GetConfigForClient = func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+		addr := hello.Conn.RemoteAddr().String()
+		if reason := checkClient(listenerPort, addr); reason == refusedDenied {
+			refuseClient(listenerPort, addr)
+			return nil, fmt.Errorf("connection from %s refused", addr)
+		}
+		return nil, nil
+	}
+}
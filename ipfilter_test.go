@@ -0,0 +1,87 @@
+//go:build alltest
+// +build alltest
+
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/redis/go-redis/v9"
+)
+
+func TestIPFilter(t *testing.T) {
+	defer func() {
+		allowRules, denyRules, protectedMode = nil, nil, "no"
+		ipFilters = make(map[string]*ipFilter)
+	}()
+	for _, bad := range []string{"10.0.0.0/33", "nowhere:10.0.0.0/8", "10.0.0"} {
+		allowRules = []string{bad}
+		if err := loadIPFilters(); err == nil {
+			t.Errorf("%s accepted", bad)
+		}
+	}
+	allowRules = []string{"10.0.0.0/8", "tls-port:192.168.1.0/24", "admin:fd00::/8"}
+	denyRules = []string{"10.9.0.0/16", "client:::1"}
+	if err := loadIPFilters(); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		listener, addr, reason string
+	}{
+		{listenerPort, "10.1.2.3:5000", ""},
+		{listenerPort, "10.9.2.3:5000", refusedDenied},
+		{listenerPort, "172.16.0.1:5000", refusedDenied},
+		{listenerTLSPort, "192.168.1.7:5000", ""},
+		{listenerAdmin, "[fd00::1]:5000", ""},
+		{listenerAdmin, "[fe80::1]:5000", refusedDenied},
+		{listenerClient, "[::1]:5000", refusedDenied},
+		{listenerClient, "[::ffff:10.1.2.3]:5000", ""},
+	} {
+		if reason := checkClient(c.listener, c.addr); reason != c.reason {
+			t.Errorf("%s %s: %q, expected %q", c.listener, c.addr, reason, c.reason)
+		}
+	}
+
+	// without --auth, the protected mode refuses the clients neither on the
+	// loopback interface nor allowed
+	allowRules, denyRules, protectedMode = nil, nil, "yes"
+	if err := loadIPFilters(); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		listener, addr, reason string
+	}{
+		{listenerPort, "127.0.0.1:5000", ""},
+		{listenerClient, "[::1]:5000", ""},
+		{listenerPort, "10.1.2.3:5000", refusedProtected},
+		{listenerMetrics, "10.1.2.3:5000", ""},
+	} {
+		if reason := checkClient(c.listener, c.addr); reason != c.reason {
+			t.Errorf("%s %s: %q, expected %q", c.listener, c.addr, reason, c.reason)
+		}
+	}
+	protectedMode = "no"
+	if reason := checkClient(listenerPort, "10.1.2.3:5000"); reason != "" {
+		t.Errorf("protected mode off: %q", reason)
+	}
+
+	// the connections denied are closed as soon as they are accepted
+	getTestConn()
+	denyRules = []string{"port:127.0.0.0/8"}
+	if err := loadIPFilters(); err != nil {
+		t.Fatal(err)
+	}
+	c := redis.NewClient(&redis.Options{Addr: "127.0.0.1:11001", MaxRetries: -1})
+	defer c.Close()
+	if err := c.Ping(context.Background()).Err(); err == nil {
+		t.Errorf("denied client served")
+	}
+	denyRules = nil
+	if err := loadIPFilters(); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Ping(context.Background()).Err(); err != nil {
+		t.Errorf("client after the rules are lifted: %v", err)
+	}
+}
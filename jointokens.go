@@ -0,0 +1,300 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	rafthub "github.com/tidwall/uhaha"
+)
+
+var (
+	// the secret signing the join tokens, shared by the members. With a
+	// secret, a node is only added to the cluster with a join token.
+	joinSecret string
+	// the join token this node presents to the cluster of -j
+	joinToken string
+)
+
+// the prefix of the join tokens
+const joinTokenPrefix = "ifj1."
+
+// joinGrantsKey holds the join grants in the storage, out of the ledis key
+// space, like the fences
+var joinGrantsKey = []byte("__icefiredb_join_grants")
+
+var errJoinSecret = errors.New("ERR no join secret, see --join-secret")
+
+// joinClaims is the payload of a join token: the node it admits, any node
+// when empty, and until when
+type joinClaims struct {
+	ID       string `json:"id"`
+	Node     string `json:"node,omitempty"`
+	IssuedAt int64  `json:"iat"`
+	Expires  int64  `json:"exp"`
+}
+
+// joinGrants are the nodes which redeemed a join token, and the tokens
+// redeemed, by id with their expiry in unix seconds. A token is redeemed
+// once, and a grant lasts as long as its token. They only change as
+// JOINGRANT is applied, so every member admits the same nodes.
+var joinGrants = &joinGrantSet{}
+
+type joinGrantSet struct {
+	mu    sync.RWMutex
+	state joinGrantState
+}
+
+type joinGrantState struct {
+	Nodes map[string]int64 `json:"nodes,omitempty"`
+	Used  map[string]int64 `json:"used,omitempty"`
+}
+
+func init() {
+	conf.Config.AddWriteCommand("JOINGRANT", cmdJOINGRANT)
+	conf.AddIntermediateCommand("JOINTOKEN", cmdJOINTOKEN)
+}
+
+// signJoin returns the HMAC-SHA256 of a token with the secret
+func signJoin(secret string, msg []byte) []byte {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(msg)
+	return mac.Sum(nil)
+}
+
+// issueJoinToken signs a token admitting node, any node when empty, for ttl
+func issueJoinToken(secret, node string, ttl time.Duration, now time.Time) (string, error) {
+	if secret == "" {
+		return "", errJoinSecret
+	}
+	if ttl <= 0 {
+		return "", errors.New("ERR the ttl must be positive")
+	}
+	id, err := newTokenID()
+	if err != nil {
+		return "", err
+	}
+	return signToken(joinTokenPrefix, joinClaims{ID: id, Node: node, IssuedAt: now.Unix(), Expires: now.Add(ttl).Unix()},
+		func(msg []byte) []byte { return signJoin(secret, msg) })
+}
+
+// verifyJoinToken returns the payload of a token signed with the secret and
+// not expired
+func verifyJoinToken(secret, token string, now time.Time) (*joinClaims, error) {
+	var jc joinClaims
+	err := openToken(joinTokenPrefix, token, func(msg, sig []byte) bool {
+		return hmac.Equal(sig, signJoin(secret, msg))
+	}, &jc)
+	if err != nil {
+		return nil, err
+	}
+	if jc.ID == "" {
+		return nil, errors.New("invalid join token")
+	}
+	if !now.Before(time.Unix(jc.Expires, 0)) {
+		return nil, errors.New("token expired")
+	}
+	return &jc, nil
+}
+
+// load reads the grants of the storage, after it is opened or restored
+func (g *joinGrantSet) load() error {
+	data, err := ldb.GetSDB().Get(joinGrantsKey)
+	if err != nil {
+		return err
+	}
+	var state joinGrantState
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &state); err != nil {
+			return err
+		}
+	}
+	g.mu.Lock()
+	g.state = state
+	g.mu.Unlock()
+	return nil
+}
+
+// grant records the token id redeemed for a node until expires, the entries
+// expired at now are dropped
+func (g *joinGrantSet) grant(id, node string, expires int64, now time.Time) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if _, ok := g.state.Used[id]; ok {
+		return errors.New("ERR join token already redeemed")
+	}
+	state := joinGrantState{Nodes: make(map[string]int64), Used: make(map[string]int64)}
+	for _, m := range []struct{ from, to map[string]int64 }{
+		{g.state.Nodes, state.Nodes}, {g.state.Used, state.Used},
+	} {
+		for k, exp := range m.from {
+			if now.Before(time.Unix(exp, 0)) {
+				m.to[k] = exp
+			}
+		}
+	}
+	state.Nodes[node] = expires
+	state.Used[id] = expires
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	if err := ldb.GetSDB().Put(joinGrantsKey, data); err != nil {
+		return err
+	}
+	g.state = state
+	return nil
+}
+
+// allowed reports whether a node holds a grant at now
+func (g *joinGrantSet) allowed(node string, now time.Time) bool {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	exp, ok := g.state.Nodes[node]
+	return ok && now.Before(time.Unix(exp, 0))
+}
+
+// list returns the nodes holding a grant at now sorted by node id, with
+// their expiry
+func (g *joinGrantSet) list(now time.Time) []interface{} {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	nodes := make([]string, 0, len(g.state.Nodes))
+	for node, exp := range g.state.Nodes {
+		if now.Before(time.Unix(exp, 0)) {
+			nodes = append(nodes, node)
+		}
+	}
+	sort.Strings(nodes)
+	out := make([]interface{}, 0, 2*len(nodes))
+	for _, node := range nodes {
+		out = append(out, node, time.Unix(g.state.Nodes[node], 0).UTC().Format(time.RFC3339))
+	}
+	return out
+}
+
+// checkJoin refuses RAFT SERVER ADD of a node without a grant under
+// --join-secret, and JOINGRANT, which only the node verifying a token runs
+// through its local connection. The members added by the admin API are not
+// checked.
+func checkJoin(args []string, now time.Time) error {
+	if args[0] == "joingrant" {
+		return errors.New("NOPERM JOINGRANT is internal, see JOINTOKEN REDEEM")
+	}
+	if joinSecret == "" || len(args) < 4 || args[0] != "raft" ||
+		!strings.EqualFold(args[1], "server") || !strings.EqualFold(args[2], "add") {
+		return nil
+	}
+	if !joinGrants.allowed(args[3], now) {
+		return fmt.Errorf("NOPERM node %s has no join grant, see JOINTOKEN", args[3])
+	}
+	return nil
+}
+
+// JOINGRANT token-id node expires
+// Records the grant of a join token verified by JOINTOKEN REDEEM, the
+// secret is not needed to apply it. A token is only granted once.
+func cmdJOINGRANT(m rafthub.Machine, args []string) (interface{}, error) {
+	if len(args) != 4 {
+		return nil, rafthub.ErrWrongNumArgs
+	}
+	expires, err := strconv.ParseInt(args[3], 10, 64)
+	if err != nil {
+		return nil, errors.New("ERR invalid expiry")
+	}
+	now := m.Now()
+	if !now.Before(time.Unix(expires, 0)) {
+		return nil, errors.New("ERR join token expired")
+	}
+	if err := joinGrants.grant(args[1], args[2], expires, now); err != nil {
+		return nil, err
+	}
+	return "OK", nil
+}
+
+// cmdJOINTOKEN is JOINTOKEN ISSUE ttl [node], JOINTOKEN REDEEM token node
+// and JOINTOKEN LIST, the nodes granted to join and until when. ISSUE is
+// only run by the users of the admin role and the admin API.
+func cmdJOINTOKEN(m rafthub.Machine, args []string) (interface{}, error) {
+	if len(args) < 2 {
+		return nil, rafthub.ErrWrongNumArgs
+	}
+	switch strings.ToLower(args[1]) {
+	case "issue":
+		if len(args) != 3 && len(args) != 4 {
+			return nil, rafthub.ErrWrongNumArgs
+		}
+		if c, ok := m.Context().(*client); ok {
+			if cc := clients.get(c.id); cc == nil || !cc.isAdmin() {
+				return nil, errors.New("NOPERM JOINTOKEN ISSUE needs the admin role")
+			}
+		}
+		ttl, err := time.ParseDuration(args[2])
+		if err != nil {
+			return nil, fmt.Errorf("ERR invalid ttl '%s'", args[2])
+		}
+		node := ""
+		if len(args) == 4 {
+			node = args[3]
+		}
+		return issueJoinToken(joinSecret, node, ttl, time.Now())
+	case "redeem":
+		if len(args) != 4 {
+			return nil, rafthub.ErrWrongNumArgs
+		}
+		if joinSecret == "" {
+			return nil, errJoinSecret
+		}
+		jc, err := verifyJoinToken(joinSecret, args[2], time.Now())
+		if err != nil {
+			return nil, fmt.Errorf("ERR invalid join token: %v", err)
+		}
+		if jc.Node != "" && jc.Node != args[3] {
+			return nil, fmt.Errorf("ERR the join token is for node %s", jc.Node)
+		}
+		// a follower answers MOVED, the joining node redeems on the leader
+		if _, err := localDo("joingrant", jc.ID, args[3], strconv.FormatInt(jc.Expires, 10)); err != nil {
+			return nil, err
+		}
+		return "OK", nil
+	case "list":
+		if len(args) != 2 {
+			return nil, rafthub.ErrWrongNumArgs
+		}
+		return joinGrants.list(time.Now()), nil
+	}
+	return nil, fmt.Errorf("ERR unknown subcommand '%s'", args[1])
+}
+
+// redeemJoinToken redeems --join-token on the leader of the cluster of
+// addr, before this node asks to be added
+func redeemJoinToken(addr string, tlscfg *tls.Config) error {
+	for i := 0; i < 3; i++ {
+		c, err := rafthub.RedisDial(addr, conf.Auth, tlscfg)
+		if err != nil {
+			return err
+		}
+		_, err = c.Do("jointoken", "redeem", joinToken, conf.NodeID)
+		c.Close()
+		if err == nil {
+			return nil
+		}
+		switch msg := err.Error(); {
+		case strings.HasPrefix(msg, "MOVED 0 "):
+			addr = strings.TrimPrefix(msg, "MOVED 0 ")
+		case strings.HasPrefix(msg, "TRY "):
+			addr = strings.TrimPrefix(msg, "TRY ")
+		default:
+			return err
+		}
+	}
+	return errors.New("too many redirects to the leader")
+}
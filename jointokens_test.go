@@ -0,0 +1,94 @@
+//go:build alltest
+// +build alltest
+
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+func TestJoinTokenSign(t *testing.T) {
+	now := time.Now()
+	token, err := issueJoinToken("secret", "4", time.Minute, now)
+	if err != nil || !strings.HasPrefix(token, joinTokenPrefix) {
+		t.Fatalf("issue: %q %v", token, err)
+	}
+	jc, err := verifyJoinToken("secret", token, now)
+	if err != nil || jc.Node != "4" {
+		t.Fatalf("verify: %+v %v", jc, err)
+	}
+	if _, err := verifyJoinToken("other", token, now); err == nil {
+		t.Errorf("token of another secret verified")
+	}
+	if _, err := verifyJoinToken("secret", token, now.Add(time.Minute)); err == nil {
+		t.Errorf("expired token verified")
+	}
+	p, sig, _ := strings.Cut(strings.TrimPrefix(token, joinTokenPrefix), ".")
+	if _, err := verifyJoinToken("secret", joinTokenPrefix+p+"x."+sig, now); err == nil {
+		t.Errorf("forged token verified")
+	}
+	if _, err := issueJoinToken("", "", time.Minute, now); err != errJoinSecret {
+		t.Errorf("issue without a secret: %v", err)
+	}
+}
+
+func TestJoinTokens(t *testing.T) {
+	c := getTestConn()
+	ctx := context.Background()
+	joinSecret = "join-secret"
+	defer func() { joinSecret = "" }()
+	path := filepath.Join(t.TempDir(), "acl.json")
+	writeACL(t, path, "pw", nil, aclUserConfig{Name: "ops", Roles: []string{"admin"}})
+	if err := acl.load(path); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		acl.mu.Lock()
+		acl.users, acl.roles, acl.providers, acl.failures = nil, nil, nil, make(map[string]*aclFailures)
+		acl.mu.Unlock()
+	}()
+
+	if err := c.Do(ctx, "RAFT", "SERVER", "ADD", "9", "127.0.0.1:11009").Err(); err == nil || !strings.HasPrefix(err.Error(), "NOPERM") {
+		t.Fatalf("RAFT SERVER ADD without a grant: %v", err)
+	}
+	// the default user of --auth does not issue join tokens
+	if err := c.Do(ctx, "JOINTOKEN", "ISSUE", "1m", "9").Err(); err == nil || !strings.HasPrefix(err.Error(), "NOPERM") {
+		t.Errorf("JOINTOKEN ISSUE of the default user: %v", err)
+	}
+	ops := redis.NewClient(&redis.Options{Addr: "127.0.0.1:11001", Username: "ops", Password: "pw", MaxRetries: -1, PoolSize: 1})
+	defer ops.Close()
+	token, err := ops.Do(ctx, "JOINTOKEN", "ISSUE", "1m", "9").Text()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Do(ctx, "JOINGRANT", "id", "9", "9999999999").Err(); err == nil || !strings.HasPrefix(err.Error(), "NOPERM") {
+		t.Errorf("JOINGRANT of a client: %v", err)
+	}
+	if err := c.Do(ctx, "JOINTOKEN", "REDEEM", token, "8").Err(); err == nil {
+		t.Errorf("token of node 9 redeemed by node 8")
+	}
+	if err := c.Do(ctx, "JOINTOKEN", "REDEEM", token, "9").Err(); err != nil {
+		t.Fatalf("JOINTOKEN REDEEM: %v", err)
+	}
+	if err := c.Do(ctx, "JOINTOKEN", "REDEEM", token, "9").Err(); err == nil {
+		t.Errorf("token redeemed twice")
+	}
+	if v, err := c.Do(ctx, "JOINTOKEN", "LIST").StringSlice(); err != nil || len(v) != 2 || v[0] != "9" {
+		t.Errorf("JOINTOKEN LIST: %q %v", v, err)
+	}
+	if !joinGrants.allowed("9", time.Now()) || joinGrants.allowed("9", time.Now().Add(time.Minute)) {
+		t.Errorf("the grant must last as long as the token")
+	}
+	if err := checkJoin([]string{"raft", "server", "add", "9", "127.0.0.1:11009"}, time.Now()); err != nil {
+		t.Errorf("checkJoin of a granted node: %v", err)
+	}
+	if v := redactAudit([]string{"jointoken", "redeem", token, "9"}); v[2] == token {
+		t.Errorf("token left in the audit log: %q", v)
+	}
+}
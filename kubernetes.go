@@ -0,0 +1,219 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	rafthub "github.com/tidwall/uhaha"
+	"go.uber.org/zap"
+)
+
+var (
+	// the raft addresses of the members a new node looks for a cluster on,
+	// comma separated  (default: $ICEFIREDB_PEERS)
+	peerList string
+	// the name resolving to the members, as the headless service of a
+	// StatefulSet  (default: $ICEFIREDB_PEERS_DNS)
+	peersDNS string
+	// how long a drain waits for the clients to leave before closing them
+	drainTimeout = 20 * time.Second
+)
+
+// how often a new node looks for a cluster on its peers
+const peerRetryInterval = 2 * time.Second
+
+// autoJoin sets -j for a new node of --peers or --peers-dns: it joins the
+// cluster of the first peer that has a leader, or bootstraps it when no peer
+// has one and it is the bootstrap node. A node with a data directory is
+// already a member and keeps its cluster.
+func autoJoin() error {
+	if peerList == "" && peersDNS == "" || conf.JoinAddr != "" {
+		return nil
+	}
+	if _, err := os.Stat(filepath.Join(conf.DataDir, conf.Name, conf.NodeID)); err == nil {
+		return nil
+	}
+	tlscfg, err := peerTLS()
+	if err != nil {
+		return err
+	}
+	probe := func(addr string) (string, error) {
+		conn, err := rafthub.RedisDial(addr, conf.Auth, tlscfg)
+		if err != nil {
+			return "", err
+		}
+		defer conn.Close()
+		return redis.String(conn.Do("raft", "leader"))
+	}
+	self := conf.Advertise
+	if self == "" {
+		self = conf.Addr
+	}
+	for {
+		peers, first, err := resolvePeers(self)
+		if err != nil {
+			loggers[logServer].Warn("resolving the peers", zap.Error(err))
+		} else {
+			join, bootstrap := findCluster(peers, first, probe)
+			switch {
+			case join != "":
+				loggers[logServer].Info("joining the cluster of a peer", zap.String("peer", join))
+				conf.JoinAddr = join
+				return nil
+			case bootstrap:
+				loggers[logServer].Info("no peer has a cluster, bootstrapping it")
+				return nil
+			}
+			loggers[logServer].Info("waiting for the cluster of the peers", zap.Strings("peers", peers))
+		}
+		time.Sleep(peerRetryInterval)
+	}
+}
+
+// resolvePeers returns the addresses of the other peers, and whether the
+// node is the bootstrap node: the first of --peers, or the ordinal 0 of its
+// StatefulSet with --peers-dns
+func resolvePeers(self string) (peers []string, first bool, err error) {
+	_, port, err := net.SplitHostPort(self)
+	if err != nil {
+		return nil, false, err
+	}
+	var addrs []string
+	if peerList != "" {
+		for _, addr := range strings.Split(peerList, ",") {
+			if addr = strings.TrimSpace(addr); addr != "" {
+				addrs = append(addrs, addr)
+			}
+		}
+		first = len(addrs) > 0 && isSelf(addrs[0], self)
+	} else {
+		hosts, err := net.LookupHost(peersDNS)
+		if err != nil {
+			return nil, false, err
+		}
+		for _, host := range hosts {
+			addrs = append(addrs, net.JoinHostPort(host, port))
+		}
+		hostname, _ := os.Hostname()
+		first = strings.HasSuffix(hostname, "-0")
+	}
+	for _, addr := range addrs {
+		if !isSelf(addr, self) {
+			peers = append(peers, addr)
+		}
+	}
+	return peers, first, nil
+}
+
+// isSelf tells whether a peer address is the node, its advertised address
+// or an address of its interfaces on its port
+func isSelf(addr, self string) bool {
+	if addr == self {
+		return true
+	}
+	host, port, err := net.SplitHostPort(addr)
+	_, selfPort, _ := net.SplitHostPort(self)
+	ip := net.ParseIP(host)
+	if err != nil || port != selfPort || ip == nil {
+		return false
+	}
+	ifaddrs, _ := net.InterfaceAddrs()
+	for _, a := range ifaddrs {
+		if n, ok := a.(*net.IPNet); ok && n.IP.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// findCluster returns the first peer that has a leader, else whether the
+// bootstrap node may bootstrap: none of the peers answered with a leader
+func findCluster(peers []string, first bool, probe func(addr string) (string, error)) (join string, bootstrap bool) {
+	for _, addr := range peers {
+		leader, err := probe(addr)
+		if err == nil && leader != "" {
+			return addr, false
+		}
+	}
+	return "", first
+}
+
+// peerTLS is the client TLS configuration of the port of the peers, as the
+// server builds it from --tls-cert and --tls-key
+func peerTLS() (*tls.Config, error) {
+	if conf.TLSCertPath == "" {
+		return nil, nil
+	}
+	cert, err := tls.LoadX509KeyPair(conf.TLSCertPath, conf.TLSKeyPath)
+	if err != nil {
+		return nil, err
+	}
+	tlscfg := &tls.Config{Certificates: []tls.Certificate{cert}, InsecureSkipVerify: true}
+	if clusterCACert != "" {
+		if _, err := secureCluster(tlscfg, conf.TLSCertPath, conf.TLSKeyPath); err != nil {
+			return nil, err
+		}
+	}
+	return tlscfg, nil
+}
+
+// since when the node drains, nil until it does
+var drainingSince atomic.Pointer[time.Time]
+
+// drainResult is the body of the drain requests
+type drainResult struct {
+	Since time.Time `json:"since"`
+	// the clients still connected at the timeout, disconnected
+	Closed int `json:"closed"`
+}
+
+// drain fails the readiness of the node so that its service stops sending
+// it clients, waits up to timeout for its clients to leave and disconnects
+// the others. The node stays a member of the cluster and keeps draining
+// until it stops.
+func drain(timeout time.Duration) drainResult {
+	now := time.Now()
+	if drainingSince.CompareAndSwap(nil, &now) {
+		loggers[logServer].Info("draining the node", zap.Duration("timeout", timeout))
+		events.Publish(eventNodeDraining, map[string]interface{}{"timeout": timeout.String()})
+	}
+	deadline := time.Now().Add(timeout)
+	for len(clients.list()) > 0 && time.Now().Before(deadline) {
+		time.Sleep(100 * time.Millisecond)
+	}
+	res := drainResult{Since: *drainingSince.Load()}
+	for _, c := range clients.list() {
+		if c.close() == nil {
+			res.Closed++
+		}
+	}
+	return res
+}
+
+// handleDrain drains the node, as the pre-stop hook of its pod, and answers
+// once its clients are gone. ?timeout=10s overrides --drain-timeout.
+func handleDrain(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	timeout := drainTimeout
+	if v := r.URL.Query().Get("timeout"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			writeJSON(w, http.StatusBadRequest, adminError{Error: fmt.Sprintf("invalid timeout '%s'", v)})
+			return
+		}
+		timeout = d
+	}
+	auditAdmin(r, []string{"drain", timeout.String()}, nil)
+	writeJSON(w, http.StatusOK, drain(timeout))
+}
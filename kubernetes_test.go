@@ -0,0 +1,91 @@
+//go:build alltest
+// +build alltest
+
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	redigo "github.com/gomodule/redigo/redis"
+)
+
+func TestFindCluster(t *testing.T) {
+	leaders := map[string]string{"b:11001": "", "c:11001": "a:11001"}
+	probe := func(addr string) (string, error) {
+		leader, ok := leaders[addr]
+		if !ok {
+			return "", errors.New("connection refused")
+		}
+		return leader, nil
+	}
+	if join, bootstrap := findCluster([]string{"x:11001", "b:11001", "c:11001"}, true, probe); join != "c:11001" || bootstrap {
+		t.Errorf("join %q bootstrap %v, expected the peer with a leader", join, bootstrap)
+	}
+	leaders["c:11001"] = ""
+	if join, bootstrap := findCluster([]string{"b:11001", "c:11001"}, true, probe); join != "" || !bootstrap {
+		t.Errorf("join %q bootstrap %v, the first node bootstraps", join, bootstrap)
+	}
+	if join, bootstrap := findCluster([]string{"b:11001", "c:11001"}, false, probe); join != "" || bootstrap {
+		t.Errorf("join %q bootstrap %v, the other nodes wait", join, bootstrap)
+	}
+}
+
+func TestResolvePeers(t *testing.T) {
+	defer func(list string) { peerList = list }(peerList)
+	peerList = "127.0.0.1:11001, db-1.db:11001,db-2.db:11001"
+	peers, first, err := resolvePeers(":11001")
+	if err != nil || !first || len(peers) != 2 || peers[0] != "db-1.db:11001" {
+		t.Errorf("peers %q first %v %v", peers, first, err)
+	}
+	peerList = "db-0.db:11001,db-1.db:11001,db-2.db:11001"
+	peers, first, err = resolvePeers("db-1.db:11001")
+	if err != nil || first || len(peers) != 2 || peers[0] != "db-0.db:11001" {
+		t.Errorf("peers %q first %v %v", peers, first, err)
+	}
+}
+
+func TestDrain(t *testing.T) {
+	c := getTestConn()
+	ctx := context.Background()
+	defer drainingSince.Store(nil)
+
+	conn, err := redigo.Dial("tcp", "127.0.0.1:11001")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := conn.Do("ping"); err != nil {
+		t.Fatal(err)
+	}
+	h := adminHandler("secret")
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("POST", "/v1/drain?timeout=100ms", nil)
+	r.Header.Set("Authorization", "Bearer secret")
+	start := time.Now()
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusOK || time.Since(start) < 100*time.Millisecond {
+		t.Errorf("drain %d %s after %s", w.Code, w.Body, time.Since(start))
+	}
+	if _, err := conn.Do("ping"); err == nil {
+		t.Error("the clients left must be disconnected")
+	}
+	if ready := checkReady(); ready.Ready || ready.Checks["drain"] == "ok" {
+		t.Errorf("a draining node must not be ready, got %v", ready.Checks)
+	}
+
+	w = httptest.NewRecorder()
+	r = httptest.NewRequest("POST", "/v1/drain?timeout=x", nil)
+	r.Header.Set("Authorization", "Bearer secret")
+	if h.ServeHTTP(w, r); w.Code != http.StatusBadRequest {
+		t.Errorf("invalid timeout %d", w.Code)
+	}
+	drainingSince.Store(nil)
+	if err := c.Ping(ctx).Err(); err != nil {
+		t.Errorf("the clients reconnect %v", err)
+	}
+}
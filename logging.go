@@ -0,0 +1,422 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	sdlog "github.com/siddontang/go/log"
+	"github.com/tidwall/match"
+	"github.com/tidwall/redcon"
+	rafthub "github.com/tidwall/uhaha"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+
+	ipfs_log "github.com/IceFireDB/IceFireDB/driver/ipfs-log"
+)
+
+// The subsystems logging with their own level
+const (
+	logRaft    = "raft"    // the raft machine and the cluster membership
+	logServer  = "server"  // the connections, the commands and the admin
+	logStorage = "storage" // the storage engine
+	logP2P     = "p2p"     // the p2p network of the drivers
+)
+
+var logSubsystems = []string{logRaft, logServer, logStorage, logP2P}
+
+// log format: text or json
+var logFormat string
+
+var (
+	// the level of each subsystem, changed with CONFIG SET loglevel-name
+	logLevels = make(map[string]zap.AtomicLevel)
+	// the logger of each subsystem, they log nothing until initLogging
+	loggers = make(map[string]*zap.Logger)
+)
+
+// the number of lines kept for the diagnostics bundle
+const recentLogSize = 1000
+
+// the lines logged lately by every subsystem
+var recentLogs = &logRing{}
+
+// The ids of the client connections, a command is identified in the logs by
+// the id of its connection and its sequence number on the connection
+var lastConnID uint64
+
+// client is the context of a client connection
+type client struct {
+	id   uint64
+	addr string
+	seq  uint64
+}
+
+// requestID returns the id of the next command of the client
+func (c *client) requestID() string {
+	return fmt.Sprintf("%d-%d", c.id, atomic.AddUint64(&c.seq, 1))
+}
+
+func init() {
+	for _, name := range logSubsystems {
+		logLevels[name] = zap.NewAtomicLevel()
+		loggers[name] = zap.NewNop()
+	}
+	conf.AddIntermediateCommand("CONFIG", cmdCONFIG)
+}
+
+// parseLogLevel parses a level with the names of the -l flag or of zap,
+// silent logs the fatal errors only
+func parseLogLevel(s string) (zapcore.Level, error) {
+	switch strings.ToLower(s) {
+	case "debug", "verb", "verbose":
+		return zapcore.DebugLevel, nil
+	case "info", "notice":
+		return zapcore.InfoLevel, nil
+	case "warn", "warning":
+		return zapcore.WarnLevel, nil
+	case "error":
+		return zapcore.ErrorLevel, nil
+	case "silent", "quiet", "fatal":
+		return zapcore.FatalLevel, nil
+	}
+	return 0, fmt.Errorf("invalid log level: '%s'", s)
+}
+
+// initLogging logs every subsystem in the log format, at the level of the
+// -l flag. The raft machine and the storage engine log through the
+// subsystem loggers from then on, as does the standard log.
+func initLogging(c *rafthub.Config) error {
+	// the defaults of the raft machine
+	if c.LogLevel == "" {
+		c.LogLevel = "info"
+	}
+	if c.NodeID == "" {
+		c.NodeID = "1"
+	}
+	level, err := parseLogLevel(c.LogLevel)
+	if err != nil {
+		return err
+	}
+	var enc zapcore.Encoder
+	switch logFormat {
+	case "text":
+		ec := zap.NewDevelopmentEncoderConfig()
+		ec.EncodeTime = zapcore.ISO8601TimeEncoder
+		enc = zapcore.NewConsoleEncoder(ec)
+	case "json":
+		ec := zap.NewProductionEncoderConfig()
+		ec.EncodeTime = zapcore.ISO8601TimeEncoder
+		enc = zapcore.NewJSONEncoder(ec)
+	default:
+		return fmt.Errorf("invalid --log-format: '%s'", logFormat)
+	}
+	out := zapcore.Lock(os.Stderr)
+	for _, name := range logSubsystems {
+		logLevels[name].SetLevel(level)
+		core := zapcore.NewTee(
+			zapcore.NewCore(enc, out, logLevels[name]),
+			zapcore.NewCore(enc, recentLogs, logLevels[name]),
+		)
+		loggers[name] = zap.New(core).Named(name).With(zap.String("node", c.NodeID))
+	}
+
+	// the raft machine logs everything, the level of the subsystem filters
+	c.LogLevel = "debug"
+	c.LogOutput = raftLogWriter{}
+	sdlog.SetLevel(sdlog.LevelTrace)
+	sdlog.SetHandler(storageLogHandler{})
+	zap.RedirectStdLog(loggers[logServer])
+	ipfs_log.Logger = loggers[logP2P]
+	return nil
+}
+
+// logRing keeps the last recentLogSize lines written to it
+type logRing struct {
+	mu    sync.Mutex
+	lines []string
+	next  int
+}
+
+func (r *logRing) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.lines) < recentLogSize {
+		r.lines = append(r.lines, string(p))
+	} else {
+		r.lines[r.next] = string(p)
+		r.next = (r.next + 1) % recentLogSize
+	}
+	return len(p), nil
+}
+
+func (r *logRing) Sync() error {
+	return nil
+}
+
+// WriteTo writes the lines from the oldest
+func (r *logRing) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var n int64
+	for i := range r.lines {
+		m, err := io.WriteString(w, r.lines[(r.next+i)%len(r.lines)])
+		n += int64(m)
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// raftLogWriter logs the lines of the raft machine, they read
+// "pid:R 02 Jan 2006 15:04:05.000 L message" where R is the role of the
+// node and L the level
+type raftLogWriter struct{}
+
+var raftRoles = map[byte]string{'S': "starting", 'L': "leader", 'F': "follower", 'C': "candidate"}
+
+func (raftLogWriter) Write(p []byte) (int, error) {
+	for _, line := range bytes.Split(bytes.TrimSpace(p), []byte{'\n'}) {
+		level, role, msg := parseRaftLine(string(line))
+		if ce := loggers[logRaft].Check(level, msg); ce != nil {
+			if role != "" {
+				ce.Write(zap.String("role", role))
+			} else {
+				ce.Write()
+			}
+		}
+	}
+	return len(p), nil
+}
+
+func parseRaftLine(line string) (zapcore.Level, string, string) {
+	sp := strings.IndexByte(line, ' ')
+	// the time takes 24 bytes and is followed by the level
+	if sp < 2 || len(line) < sp+27 || line[sp+25] != ' ' || line[sp+27] != ' ' {
+		return zapcore.InfoLevel, "", line
+	}
+	level := zapcore.InfoLevel
+	switch line[sp+26] {
+	case '.', '-':
+		level = zapcore.DebugLevel
+	case '#':
+		level = zapcore.WarnLevel
+	}
+	return level, raftRoles[line[sp-1]], line[sp+28:]
+}
+
+// storageLogHandler logs the lines of the storage engine, they read
+// "[2006/01/02 15:04:05] file.go:12 [Level] message"
+type storageLogHandler struct{}
+
+var storageLogLine = regexp.MustCompile(`^\[[^\]]*\] (\S+:\d+) \[(\w+)\] (.*)$`)
+
+func (storageLogHandler) Write(p []byte) (int, error) {
+	level, caller, msg := parseStorageLine(string(bytes.TrimSpace(p)))
+	if ce := loggers[logStorage].Check(level, msg); ce != nil {
+		ce.Write(zap.String("source", caller))
+	}
+	return len(p), nil
+}
+
+func (storageLogHandler) Close() error {
+	return nil
+}
+
+func parseStorageLine(line string) (zapcore.Level, string, string) {
+	m := storageLogLine.FindStringSubmatch(line)
+	if m == nil {
+		return zapcore.InfoLevel, "", line
+	}
+	level := zapcore.InfoLevel
+	switch m[2] {
+	case "Trace", "Debug":
+		level = zapcore.DebugLevel
+	case "Warn":
+		level = zapcore.WarnLevel
+	case "Error", "Fatal":
+		// a fatal error of the engine exits by itself, the logger must not
+		level = zapcore.ErrorLevel
+	}
+	return level, m[1], m[3]
+}
+
+// logged logs the failures of a command at the debug level, with the
+// request id when the command comes from a client connection
+func logged(name string, fn commandFunc) commandFunc {
+	cmd := strings.ToLower(name)
+	return func(m rafthub.Machine, args []string) (interface{}, error) {
+		v, err := fn(m, args)
+		if err == nil {
+			return v, err
+		}
+		if ce := loggers[logServer].Check(zapcore.DebugLevel, "command failed"); ce != nil {
+			fields := []zap.Field{zap.String("cmd", cmd), zap.Error(err)}
+			if c, ok := m.Context().(*client); ok {
+				fields = append(fields, zap.String("request_id", c.requestID()), zap.String("client", c.addr))
+			}
+			ce.Write(fields...)
+		}
+		return v, err
+	}
+}
+
+// CONFIG GET pattern, CONFIG SET parameter value, CONFIG RESETSTAT
+// The parameters are the log level of every subsystem, loglevel, and the
+// level of each, loglevel-name. They are set on the node the command runs on.
+// RESETSTAT forgets the command stats of INFO of the node.
+func cmdCONFIG(m rafthub.Machine, args []string) (interface{}, error) {
+	if len(args) < 2 {
+		return nil, rafthub.ErrWrongNumArgs
+	}
+	switch strings.ToLower(args[1]) {
+	case "get":
+		if len(args) != 3 {
+			return nil, rafthub.ErrWrongNumArgs
+		}
+		params := configParams()
+		names := make([]string, 0, len(params))
+		for name := range params {
+			if match.Match(name, strings.ToLower(args[2])) {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+		reply := make([]interface{}, 0, 2*len(names))
+		for _, name := range names {
+			reply = append(reply, name, params[name])
+		}
+		return reply, nil
+	case "set":
+		if len(args) != 4 {
+			return nil, rafthub.ErrWrongNumArgs
+		}
+		param := strings.ToLower(args[2])
+		var err error
+		switch param {
+		case "maxclients", "timeout":
+			err = clients.setConfig(param, args[3])
+		case "user-rate-limit", "user-bandwidth-limit", "ip-rate-limit", "ip-bandwidth-limit":
+			err = limiter.setConfig(param, args[3])
+		default:
+			err = setLogLevel(param, args[3])
+		}
+		if err != nil {
+			return nil, err
+		}
+		return redcon.SimpleString("OK"), nil
+	case "resetstat":
+		if len(args) != 2 {
+			return nil, rafthub.ErrWrongNumArgs
+		}
+		cmdStats.Reset()
+		return redcon.SimpleString("OK"), nil
+	}
+	return nil, fmt.Errorf("ERR unknown CONFIG subcommand '%s'", args[1])
+}
+
+// configParams returns the CONFIG parameters by name
+func configParams() map[string]string {
+	params := map[string]string{"loglevel": logLevels[logServer].String()}
+	limit, timeout := clients.limits()
+	params["maxclients"] = strconv.Itoa(limit)
+	params["timeout"] = strconv.Itoa(int(timeout.Seconds()))
+	for name, v := range limiter.limits() {
+		params[name] = v
+	}
+	for _, name := range logSubsystems {
+		params["loglevel-"+name] = logLevels[name].String()
+	}
+	return params
+}
+
+// setLogLevel sets the log level of a subsystem, every subsystem for the
+// loglevel parameter
+func setLogLevel(param, value string) error {
+	level, err := parseLogLevel(value)
+	if err != nil {
+		return fmt.Errorf("ERR %v", err)
+	}
+	if param == "loglevel" {
+		for _, name := range logSubsystems {
+			logLevels[name].SetLevel(level)
+		}
+		return nil
+	}
+	l, ok := logLevels[strings.TrimPrefix(param, "loglevel-")]
+	if !ok || !strings.HasPrefix(param, "loglevel-") {
+		return fmt.Errorf("ERR Unsupported CONFIG parameter: %s", param)
+	}
+	l.SetLevel(level)
+	return nil
+}
+
+// setLogLevels sets the levels by subsystem, none is set when one is invalid
+func setLogLevels(levels map[string]string) error {
+	for name, level := range levels {
+		if _, ok := logLevels[name]; !ok {
+			return fmt.Errorf("unknown subsystem: %s", name)
+		}
+		if _, err := parseLogLevel(level); err != nil {
+			return err
+		}
+	}
+	for name, level := range levels {
+		_ = setLogLevel("loglevel-"+name, level)
+	}
+	return nil
+}
+
+// logLevelsCommand returns the CONFIG SET commands setting the levels, as
+// recorded in the audit log
+func logLevelsCommand(levels map[string]string) []string {
+	names := make([]string, 0, len(levels))
+	for name := range levels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	cmd := []string{"config", "set"}
+	for _, name := range names {
+		cmd = append(cmd, "loglevel-"+name, levels[name])
+	}
+	return cmd
+}
+
+// handleLogLevel serves the log levels, GET returns the level of every
+// subsystem and PUT sets the levels of the body, e.g. {"raft":"debug"}
+func handleLogLevel(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		var levels map[string]string
+		if err := json.NewDecoder(r.Body).Decode(&levels); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		err := setLogLevels(levels)
+		auditAdmin(r, logLevelsCommand(levels), err)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	levels := make(map[string]string, len(logSubsystems))
+	for _, name := range logSubsystems {
+		levels[name] = logLevels[name].String()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(levels)
+}
@@ -0,0 +1,64 @@
+//go:build alltest
+// +build alltest
+
+package main
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap/zapcore"
+)
+
+func TestParseLogLines(t *testing.T) {
+	level, role, msg := parseRaftLine("24625:L 16 Oct 2026 03:15:57.550 * entering leader state")
+	if level != zapcore.InfoLevel || role != "leader" || msg != "entering leader state" {
+		t.Errorf("raft line: %v %q %q", level, role, msg)
+	}
+	if level, _, _ = parseRaftLine("24625:F 16 Oct 2026 03:15:57.550 # heartbeat timeout reached"); level != zapcore.WarnLevel {
+		t.Errorf("raft warning parsed as %v", level)
+	}
+	if _, _, msg = parseRaftLine("not a raft line"); msg != "not a raft line" {
+		t.Errorf("unknown line logged as %q", msg)
+	}
+	level, source, msg := parseStorageLine("[2026/10/16 03:15:57] ledis.go:120 [Warn] open db fail")
+	if level != zapcore.WarnLevel || source != "ledis.go:120" || msg != "open db fail" {
+		t.Errorf("storage line: %v %q %q", level, source, msg)
+	}
+}
+
+func TestConfigLogLevel(t *testing.T) {
+	c := getTestConn()
+	ctx := context.Background()
+	defer setLogLevel("loglevel", "info")
+
+	if err := c.Do(ctx, "CONFIG", "SET", "loglevel-raft", "debug").Err(); err != nil {
+		t.Fatal(err)
+	}
+	v, err := c.Do(ctx, "CONFIG", "GET", "loglevel-*").StringSlice()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(v, " ") != "loglevel-p2p info loglevel-raft debug loglevel-server info loglevel-storage info" {
+		t.Errorf("CONFIG GET: %v", v)
+	}
+	if err := c.Do(ctx, "CONFIG", "SET", "loglevel-disk", "debug").Err(); err == nil {
+		t.Error("want an error on an unknown subsystem")
+	}
+	if err := c.Do(ctx, "CONFIG", "SET", "loglevel", "loud").Err(); err == nil {
+		t.Error("want an error on an unknown level")
+	}
+
+	w := httptest.NewRecorder()
+	handleLogLevel(w, httptest.NewRequest("PUT", "/loglevel", strings.NewReader(`{"storage":"warn","p2p":"nope"}`)))
+	if w.Code != 400 || logLevels[logStorage].Level() != zapcore.InfoLevel {
+		t.Errorf("an invalid level must set none, got %d", w.Code)
+	}
+	w = httptest.NewRecorder()
+	handleLogLevel(w, httptest.NewRequest("PUT", "/loglevel", strings.NewReader(`{"storage":"warn"}`)))
+	if w.Code != 200 || !strings.Contains(w.Body.String(), `"storage":"warn"`) {
+		t.Errorf("PUT /loglevel: %d %s", w.Code, w.Body.String())
+	}
+}
@@ -1,13 +1,17 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
 	_ "net/http/pprof"
+	"os"
 	"path/filepath"
 	"sync/atomic"
+	"time"
 
 	ipfs_log "github.com/IceFireDB/IceFireDB/driver/ipfs-log"
 	"github.com/IceFireDB/icefiredb-ipfs-log/stores/levelkv"
@@ -19,8 +23,9 @@ import (
 	"github.com/syndtr/goleveldb/leveldb"
 	"github.com/tidwall/sds"
 	rafthub "github.com/tidwall/uhaha"
+	"go.uber.org/zap"
 
-	_ "github.com/IceFireDB/IceFireDB/driver/badger"
+	badgerdriver "github.com/IceFireDB/IceFireDB/driver/badger"
 	"github.com/IceFireDB/IceFireDB/driver/crdt"
 	"github.com/IceFireDB/IceFireDB/driver/hybriddb"
 	"github.com/IceFireDB/IceFireDB/driver/ipfs"
@@ -37,6 +42,11 @@ var (
 	pprofAddr string
 	// debug
 	debug bool
+	// the block cache in MB, the block size in KB and the bloom filter bits
+	// per key of the engine, 0 keeps the default of the engine
+	engineCacheSize int
+	engineBlockSize int
+	engineBloomBits int
 )
 
 func init() {
@@ -47,18 +57,36 @@ func init() {
 }
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "bench" {
+		os.Exit(runBench(os.Args[2:], os.Stdout))
+	}
 	conf.Name = "IceFireDB"
 	conf.Version = "1.0.0"
+	if len(os.Args) > 1 && os.Args[1] == "migrate-engine" {
+		os.Exit(runMigrateEngine(os.Args[2:], os.Stdout))
+	}
 	conf.GitSHA = BuildVersion
 	conf.Flag.Custom = true
-	confInit(&conf)
+	confInit(&conf.Config)
+	if err := initLogging(&conf.Config); err != nil {
+		panic(err)
+	}
+	keepSecrets(context.Background())
+	warnUnprotected()
+	if err := autoJoin(); err != nil {
+		panic(err)
+	}
 	conf.DataDirReady = func(dir string) {
 		//os.RemoveAll(filepath.Join(dir, "main.db"))
+		if fsckMode != "off" {
+			fsck(dir)
+		}
 
 		ldsCfg = lediscfg.NewConfigDefault()
 		ldsCfg.DataDir = filepath.Join(dir, "main.db")
 		ldsCfg.Databases = 1
 		ldsCfg.DBName = storageBackend
+		applyEngineOptions(ldsCfg)
 
 		var err error
 		le, err = ledis.Open(ldsCfg)
@@ -70,6 +98,15 @@ func main() {
 		if err != nil {
 			panic(err)
 		}
+		if err := fences.load(); err != nil {
+			panic(err)
+		}
+		if err := joinGrants.load(); err != nil {
+			panic(err)
+		}
+		if err := cdcCheckpoints.load(); err != nil {
+			panic(err)
+		}
 
 		// Obtain the leveldb object and handle it carefully
 		driver := ldb.GetSDB().GetDriver().GetStorageEngine()
@@ -99,6 +136,82 @@ func main() {
 		}
 
 	}
+	if auditPath != "" {
+		var err error
+		if auditLog, err = openAuditLog(auditPath); err != nil {
+			panic(err)
+		}
+		conf.ResponseFilter = auditFilter
+	}
+	if metricsAddr != "" || adminAddr != "" || len(webhooks) > 0 || readonlyDiskFree > 0 || joinSecret != "" || len(cdcSinks) > 0 || sentinelAddr != "" ||
+		dnsAddr != "" || consulAddr != "" || etcdAddr != "" {
+		conf.LocalConnector = func(lc rafthub.LocalConnector) {
+			localConnector = lc
+		}
+	}
+	if metricsAddr != "" {
+		go serveMetrics(metricsAddr)
+	}
+	if adminAddr != "" {
+		go serveAdmin(adminAddr)
+	}
+	if grpcAddr != "" {
+		go serveGRPC(grpcAddr)
+	}
+	if restAddr != "" {
+		go serveREST(restAddr)
+	}
+	if wsAddr != "" {
+		go serveWS(wsAddr)
+	}
+	if sentinelAddr != "" {
+		go serveSentinel(sentinelAddr)
+	}
+	if dnsAddr != "" {
+		go serveDNS(dnsAddr)
+	}
+	if consulAddr != "" || etcdAddr != "" {
+		go watchDiscovery(discoveryTTL/3, nil)
+	}
+	if len(webhooks) > 0 {
+		if err := startWebhooks(); err != nil {
+			panic(err)
+		}
+		go watchEvents(time.Second, nil)
+	}
+	if len(cdcSinks) > 0 {
+		if err := startCDC(); err != nil {
+			panic(err)
+		}
+	}
+	conf.ServerReady = func(addr, auth string, tlscfg *tls.Config) {
+		if clusterCACert != "" {
+			certs, err := secureCluster(tlscfg, conf.TLSCertPath, conf.TLSKeyPath)
+			if err != nil {
+				panic(err)
+			}
+			go certs.watch(tlsReloadInterval, nil)
+		}
+		if tlscfg != nil {
+			filterHandshakes(tlscfg)
+		}
+		serverTLS = tlscfg
+		if joinToken != "" && conf.JoinAddr != "" {
+			// a node already in the cluster ignores -j, its token is spent
+			if err := redeemJoinToken(conf.JoinAddr, tlscfg); err != nil {
+				loggers[logServer].Warn("redeeming the join token", zap.Error(err))
+			}
+		}
+	}
+	if readonlyDiskFree > 0 {
+		go diskGuard(time.Second, nil)
+	}
+	if memoryLimit > 0 {
+		go watchMemory(time.Second, nil)
+	}
+	if err := initTracing(); err != nil {
+		panic(err)
+	}
 	if debug {
 		// pprof for profiling
 		go func() {
@@ -112,14 +225,32 @@ func main() {
 	//conf.CmdRewriteFunc = utils.RedisCmdRewrite
 
 	fmt.Printf("start with Storage Engine: %s\n", storageBackend)
-	rafthub.Main(conf)
+	rafthub.Main(conf.Config)
+}
+
+// applyEngineOptions sets the engine options of the flags on the ledis
+// config and the configs of the drivers
+func applyEngineOptions(cfg *lediscfg.Config) {
+	if engineCacheSize > 0 {
+		cfg.LevelDB.CacheSize = engineCacheSize * lediscfg.MB
+		badgerdriver.DefaultConfig.BlockCacheSize = int64(engineCacheSize) << 20
+	}
+	if engineBlockSize > 0 {
+		cfg.LevelDB.BlockSize = engineBlockSize * lediscfg.KB
+	}
+	if engineBloomBits > 0 {
+		hybriddb.DefaultConfig.FilterBits = engineBloomBits
+		badgerdriver.DefaultConfig.FilterBits = engineBloomBits
+	}
 }
 
 type snap struct {
 	s *leveldb.Snapshot
 }
 
-func (s *snap) Done(path string) {}
+func (s *snap) Done(path string) {
+	events.Publish(eventBackupDone, map[string]interface{}{"path": path})
+}
 
 func (s *snap) Persist(wr io.Writer) error {
 	sw := sds.NewWriter(wr)
@@ -173,15 +304,25 @@ func restore(rd io.Reader) (interface{}, error) {
 	if err := db.Write(&batch, nil); err != nil {
 		return nil, err
 	}
-	return nil, nil
+	if err := fences.load(); err != nil {
+		return nil, err
+	}
+	if err := joinGrants.load(); err != nil {
+		return nil, err
+	}
+	return nil, cdcCheckpoints.load()
 }
 
 func connOpened(addr string) (context interface{}, accept bool) {
 	atomic.AddInt64(&respClientNum, 1)
-	return nil, true
+	c := &client{id: atomic.AddUint64(&lastConnID, 1), addr: addr}
+	loggers[logServer].Debug("client connected", zap.Uint64("conn_id", c.id), zap.String("client", addr))
+	return c, true
 }
 
 func connClosed(context interface{}, addr string) {
 	atomic.AddInt64(&respClientNum, -1)
-	return
+	if c, ok := context.(*client); ok {
+		loggers[logServer].Debug("client disconnected", zap.Uint64("conn_id", c.id), zap.String("client", addr))
+	}
 }
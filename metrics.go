@@ -0,0 +1,419 @@
+package main
+
+import (
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/IceFireDB/IceFireDB/driver/crdt"
+	p2pmetrics "github.com/libp2p/go-libp2p/core/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/expfmt"
+	"github.com/syndtr/goleveldb/leveldb"
+	rafthub "github.com/tidwall/uhaha"
+)
+
+var (
+	// metrics listen
+	metricsAddr string
+	// local connection to the raft machine, set once the server is ready
+	localConnector rafthub.LocalConnector
+)
+
+var (
+	commandCalls = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "icefiredb_commands_total",
+		Help: "Commands run, the writes on every node of the cluster.",
+	}, []string{"cmd"})
+	commandErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "icefiredb_command_errors_total",
+		Help: "Commands that returned an error.",
+	}, []string{"cmd"})
+	commandDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "icefiredb_command_duration_seconds",
+		Help:    "Time the commands took to run on the machine.",
+		Buckets: prometheus.ExponentialBuckets(0.00001, 2, 20),
+	}, []string{"cmd"})
+)
+
+// instrument counts the calls of a command and observes their duration, in
+// the metrics and in the command stats of INFO
+func instrument(name string, fn func(m rafthub.Machine, args []string) (interface{}, error),
+) func(m rafthub.Machine, args []string) (interface{}, error) {
+	cmd := strings.ToLower(name)
+	calls := commandCalls.WithLabelValues(cmd)
+	errs := commandErrors.WithLabelValues(cmd)
+	duration := commandDuration.WithLabelValues(cmd)
+	return func(m rafthub.Machine, args []string) (interface{}, error) {
+		start := time.Now()
+		v, err := fn(m, args)
+		d := time.Since(start)
+		duration.Observe(d.Seconds())
+		cmdStats.Observe(cmd, args, d, err != nil)
+		calls.Inc()
+		if err != nil {
+			errs.Inc()
+		}
+		return v, err
+	}
+}
+
+// cacheStats is implemented by the storage drivers with a hot cache
+type cacheStats interface {
+	CacheStats() (hits, misses uint64)
+}
+
+// bandwidthStats is implemented by the storage drivers running a P2P node
+type bandwidthStats interface {
+	Bandwidth() p2pmetrics.Stats
+}
+
+// replicationStats is implemented by the storage drivers replicating with a
+// CRDT
+type replicationStats interface {
+	ReplicationStatus() crdt.Status
+}
+
+func newDesc(name, help string, labels ...string) *prometheus.Desc {
+	return prometheus.NewDesc("icefiredb_"+name, help, labels, nil)
+}
+
+var (
+	clientsDesc        = newDesc("connected_clients", "Client connections.")
+	clientBufferDesc   = newDesc("client_buffer_max_bytes", "Largest input and output buffers of the connected clients.", "direction")
+	clientBacklogDesc  = newDesc("client_command_backlog", "Commands of the clients read and not answered yet.")
+	clientConnsDesc    = newDesc("client_connections_total", "Client connections accepted, shed over maxclients and closed idle.", "outcome")
+	tlsExpiryDesc      = newDesc("tls_certificate_expiry_timestamp_seconds", "End of validity of the certificates of the TLS port and of the node.", "listener")
+	clusterRejectDesc  = newDesc("cluster_tls_rejected_total", "Handshakes of cluster peers refused for their certificate.")
+	clientRefusedDesc  = newDesc("client_refused_total", "Connections refused by the network rules or the protected mode.", "reason")
+	rateLimitedDesc    = newDesc("rate_limited_total", "Commands refused by the rate limits of the users and addresses.", "scope", "limit")
+	authFailuresDesc   = newDesc("auth_failures_total", "AUTH of ACL users refused.")
+	authLockoutsDesc   = newDesc("auth_lockouts_total", "ACL users locked out after failed AUTH.")
+	storeOpsDesc       = newDesc("store_operations_total", "Operations on the storage engine.", "op")
+	storeTimeDesc      = newDesc("store_operation_seconds_total", "Time spent in the operations on the storage engine.", "op")
+	keyspaceHitsDesc   = newDesc("keyspace_hits_total", "Lookups of existing keys.")
+	keyspaceMissDesc   = newDesc("keyspace_misses_total", "Lookups of missing keys.")
+	levelSizeDesc      = newDesc("leveldb_level_size_bytes", "Size of the tables of each level.", "level")
+	levelTablesDesc    = newDesc("leveldb_level_tables", "Tables of each level.", "level")
+	compactionsDesc    = newDesc("leveldb_compactions_total", "Compactions by type.", "type")
+	compactionTimeDesc = newDesc("leveldb_compaction_seconds_total", "Time spent compacting each level.", "level")
+	compactionIODesc   = newDesc("leveldb_compaction_bytes_total", "Bytes compacted by each level.", "level", "direction")
+	writeDelaysDesc    = newDesc("leveldb_write_delays_total", "Writes delayed by the compaction.")
+	writeDelayTimeDesc = newDesc("leveldb_write_delay_seconds_total", "Time the writes were delayed.")
+	writePausedDesc    = newDesc("leveldb_write_paused", "Whether the writes are paused by the compaction.")
+	ioDesc             = newDesc("leveldb_io_bytes_total", "Bytes read and written on disk.", "direction")
+	blockCacheDesc     = newDesc("leveldb_block_cache_bytes", "Size of the block cache.")
+	openTablesDesc     = newDesc("leveldb_open_tables", "Tables open in the table cache.")
+	aliveDesc          = newDesc("leveldb_alive", "Snapshots and iterators not released.", "type")
+	cacheHitsDesc      = newDesc("cache_hits_total", "Hits of the hot cache of the storage driver.")
+	cacheMissDesc      = newDesc("cache_misses_total", "Misses of the hot cache of the storage driver.")
+	raftStateDesc      = newDesc("raft_state", "Raft state of the node.", "state")
+	raftIndexDesc      = newDesc("raft_index", "Raft log indexes.", "index")
+	raftTermDesc       = newDesc("raft_term", "Current raft term.")
+	raftPeersDesc      = newDesc("raft_peers", "Other voters of the cluster.")
+	raftPendingDesc    = newDesc("raft_fsm_pending", "Committed logs waiting to be applied.")
+	raftBehindDesc     = newDesc("raft_logs_behind", "Logs left to load at startup.")
+	raftContactDesc    = newDesc("raft_last_contact_seconds", "Time since the last contact with the leader, 0 on the leader.")
+	p2pBytesDesc       = newDesc("p2p_bytes_total", "Bytes exchanged with the peers.", "direction")
+	p2pRateDesc        = newDesc("p2p_bytes_per_second", "Current traffic with the peers.", "direction")
+	writesFencedDesc   = newDesc("writes_fenced", "Whether the writes are fenced, a member being short of disk space.")
+	diskFreeDesc       = newDesc("disk_free_ratio", "Free space ratio of the filesystem of the data directory.")
+	crdtClockDesc      = newDesc("crdt_clock", "Local writes of the node, and of each peer as last merged here.", "peer")
+	crdtUnmergedDesc   = newDesc("crdt_unmerged_operations", "Estimated operations of each peer merged by other nodes and not yet here.", "peer")
+	crdtLastSeenDesc   = newDesc("crdt_peer_last_seen_seconds", "Time since the last announcement of each peer was merged.", "peer")
+	crdtLagDesc        = newDesc("crdt_convergence_lag_seconds", "Estimated convergence lag with each peer.", "peer")
+)
+
+// The raft log indexes exported, by their key in RAFT INFO
+var raftIndexes = map[string]string{
+	"last_log_index":      "last_log",
+	"commit_index":        "commit",
+	"applied_index":       "applied",
+	"last_snapshot_index": "last_snapshot",
+}
+
+var raftStates = []string{"Follower", "Candidate", "Leader", "Shutdown"}
+
+// serverCollector reads the statistics of the server when scraped
+type serverCollector struct{}
+
+func (serverCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, desc := range []*prometheus.Desc{
+		clientsDesc,
+		clientBufferDesc,
+		clientBacklogDesc,
+		clientConnsDesc,
+		tlsExpiryDesc,
+		clusterRejectDesc,
+		clientRefusedDesc,
+		rateLimitedDesc,
+		authFailuresDesc,
+		authLockoutsDesc,
+		storeOpsDesc,
+		storeTimeDesc,
+		keyspaceHitsDesc,
+		keyspaceMissDesc,
+		levelSizeDesc,
+		levelTablesDesc,
+		compactionsDesc,
+		compactionTimeDesc,
+		compactionIODesc,
+		writeDelaysDesc,
+		writeDelayTimeDesc,
+		writePausedDesc,
+		ioDesc,
+		blockCacheDesc,
+		openTablesDesc,
+		aliveDesc,
+		cacheHitsDesc,
+		cacheMissDesc,
+		raftStateDesc,
+		raftIndexDesc,
+		raftTermDesc,
+		raftPeersDesc,
+		raftPendingDesc,
+		raftBehindDesc,
+		raftContactDesc,
+		p2pBytesDesc,
+		p2pRateDesc,
+		writesFencedDesc,
+		diskFreeDesc,
+		crdtClockDesc,
+		crdtUnmergedDesc,
+		crdtLastSeenDesc,
+		crdtLagDesc,
+	} {
+		ch <- desc
+	}
+}
+
+func (c serverCollector) Collect(ch chan<- prometheus.Metric) {
+	gauge := func(desc *prometheus.Desc, v float64, labels ...string) {
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, v, labels...)
+	}
+	counter := func(desc *prometheus.Desc, v float64, labels ...string) {
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.CounterValue, v, labels...)
+	}
+
+	gauge(clientsDesc, float64(atomic.LoadInt64(&respClientNum)))
+	cs := clients.stats()
+	gauge(clientBufferDesc, float64(cs.MaxInput), "input")
+	gauge(clientBufferDesc, float64(cs.MaxOutput), "output")
+	gauge(clientBacklogDesc, float64(cs.Backlog))
+	counter(clientConnsDesc, float64(cs.Accepted), "accepted")
+	counter(clientConnsDesc, float64(cs.Rejected), "rejected")
+	counter(clientConnsDesc, float64(cs.Idle), "idle_timeout")
+	if certs := tlsCerts.Load(); certs != nil {
+		gauge(tlsExpiryDesc, float64(certs.expiry().Unix()), "tls_port")
+	}
+	counter(clientRefusedDesc, float64(atomic.LoadInt64(&clientRefused[0])), refusedDenied)
+	counter(clientRefusedDesc, float64(atomic.LoadInt64(&clientRefused[1])), refusedProtected)
+	limited := limiter.stats()
+	counter(rateLimitedDesc, float64(limited[limitUserRate]), "user", "commands")
+	counter(rateLimitedDesc, float64(limited[limitUserBandwidth]), "user", "bandwidth")
+	counter(rateLimitedDesc, float64(limited[limitIPRate]), "ip", "commands")
+	counter(rateLimitedDesc, float64(limited[limitIPBandwidth]), "ip", "bandwidth")
+	if acl.enabled() {
+		failures, lockouts := acl.stats()
+		counter(authFailuresDesc, float64(failures))
+		counter(authLockoutsDesc, float64(lockouts))
+	}
+	if certs := clusterCerts.Load(); certs != nil {
+		gauge(tlsExpiryDesc, float64(certs.expiry().Unix()), "cluster")
+		counter(clusterRejectDesc, float64(atomic.LoadInt64(&clusterRejected)))
+	}
+	if le != nil {
+		s := le.StoreStat()
+		for op, n := range map[string]int64{
+			"get":          s.GetNum.Get(),
+			"put":          s.PutNum.Get(),
+			"delete":       s.DeleteNum.Get(),
+			"iter":         s.IterNum.Get(),
+			"iter_seek":    s.IterSeekNum.Get(),
+			"batch_commit": s.BatchCommitNum.Get(),
+			"compact":      s.CompactNum.Get(),
+		} {
+			counter(storeOpsDesc, float64(n), op)
+		}
+		counter(storeTimeDesc, s.GetTotalTime.Get().Seconds(), "get")
+		counter(storeTimeDesc, s.BatchCommitTotalTime.Get().Seconds(), "batch_commit")
+		counter(storeTimeDesc, s.CompactTotalTime.Get().Seconds(), "compact")
+		counter(keyspaceHitsDesc, float64(s.GetNum.Get()-s.GetMissingNum.Get()))
+		counter(keyspaceMissDesc, float64(s.GetMissingNum.Get()))
+	}
+	if db != nil {
+		collectLevelDB(db, gauge, counter)
+	}
+	if ldb != nil {
+		drv := ldb.GetSDB().GetDriver()
+		if cs, ok := drv.(cacheStats); ok {
+			hits, misses := cs.CacheStats()
+			counter(cacheHitsDesc, float64(hits))
+			counter(cacheMissDesc, float64(misses))
+		}
+		if bs, ok := drv.(bandwidthStats); ok {
+			st := bs.Bandwidth()
+			counter(p2pBytesDesc, float64(st.TotalIn), "in")
+			counter(p2pBytesDesc, float64(st.TotalOut), "out")
+			gauge(p2pRateDesc, st.RateIn, "in")
+			gauge(p2pRateDesc, st.RateOut, "out")
+		}
+		if rs, ok := drv.(replicationStats); ok {
+			collectReplication(rs.ReplicationStatus(), gauge)
+		}
+	}
+	if stats := raftInfo(); stats != nil {
+		collectRaft(stats, gauge, counter)
+	}
+	fenced := 0.0
+	if len(fences.list()) > 0 {
+		fenced = 1
+	}
+	gauge(writesFencedDesc, fenced)
+	if pct := math.Float64frombits(atomic.LoadUint64(&diskFreePct)); !math.IsNaN(pct) {
+		gauge(diskFreeDesc, pct/100)
+	}
+}
+
+func collectLevelDB(db *leveldb.DB, gauge, counter func(*prometheus.Desc, float64, ...string)) {
+	var st leveldb.DBStats
+	if err := db.Stats(&st); err != nil {
+		return
+	}
+	for i, size := range st.LevelSizes {
+		level := strconv.Itoa(i)
+		gauge(levelSizeDesc, float64(size), level)
+		if i < len(st.LevelTablesCounts) {
+			gauge(levelTablesDesc, float64(st.LevelTablesCounts[i]), level)
+		}
+		if i < len(st.LevelDurations) {
+			counter(compactionTimeDesc, st.LevelDurations[i].Seconds(), level)
+		}
+		if i < len(st.LevelRead) {
+			counter(compactionIODesc, float64(st.LevelRead[i]), level, "read")
+		}
+		if i < len(st.LevelWrite) {
+			counter(compactionIODesc, float64(st.LevelWrite[i]), level, "write")
+		}
+	}
+	counter(compactionsDesc, float64(st.MemComp), "memory")
+	counter(compactionsDesc, float64(st.Level0Comp), "level0")
+	counter(compactionsDesc, float64(st.NonLevel0Comp), "non_level0")
+	counter(compactionsDesc, float64(st.SeekComp), "seek")
+	counter(writeDelaysDesc, float64(st.WriteDelayCount))
+	counter(writeDelayTimeDesc, st.WriteDelayDuration.Seconds())
+	paused := 0.0
+	if st.WritePaused {
+		paused = 1
+	}
+	gauge(writePausedDesc, paused)
+	counter(ioDesc, float64(st.IORead), "read")
+	counter(ioDesc, float64(st.IOWrite), "write")
+	gauge(blockCacheDesc, float64(st.BlockCacheSize))
+	gauge(openTablesDesc, float64(st.OpenedTablesCount))
+	gauge(aliveDesc, float64(st.AliveSnapshots), "snapshot")
+	gauge(aliveDesc, float64(st.AliveIterators), "iterator")
+}
+
+func collectReplication(st crdt.Status, gauge func(*prometheus.Desc, float64, ...string)) {
+	now := time.Now()
+	gauge(crdtClockDesc, float64(st.Clock), st.ID)
+	for _, p := range st.Peers {
+		gauge(crdtClockDesc, float64(p.Clock), p.ID)
+		gauge(crdtUnmergedDesc, float64(p.Unmerged()), p.ID)
+		if !p.Seen.IsZero() {
+			gauge(crdtLastSeenDesc, now.Sub(p.Seen).Seconds(), p.ID)
+		}
+		gauge(crdtLagDesc, p.Lag(now).Seconds(), p.ID)
+	}
+}
+
+// raftInfo returns the statistics of RAFT INFO, nil before the server is
+// ready
+func raftInfo() map[string]string {
+	if localConnector == nil {
+		return nil
+	}
+	lc, err := localConnector.Open()
+	if err != nil {
+		return nil
+	}
+	defer lc.Close()
+	resp := lc.Do("raft", "info")
+	stats := make(map[string]string)
+	for k, v := range resp.Map() {
+		stats[k] = v.String()
+	}
+	return stats
+}
+
+func collectRaft(stats map[string]string, gauge, counter func(*prometheus.Desc, float64, ...string)) {
+	for _, state := range raftStates {
+		v := 0.0
+		if stats["state"] == state {
+			v = 1
+		}
+		gauge(raftStateDesc, v, strings.ToLower(state))
+	}
+	for key, index := range raftIndexes {
+		if v, err := strconv.ParseFloat(stats[key], 64); err == nil {
+			gauge(raftIndexDesc, v, index)
+		}
+	}
+	for key, desc := range map[string]*prometheus.Desc{
+		"term":        raftTermDesc,
+		"num_peers":   raftPeersDesc,
+		"fsm_pending": raftPendingDesc,
+		"logs_behind": raftBehindDesc,
+	} {
+		if v, err := strconv.ParseFloat(stats[key], 64); err == nil {
+			gauge(desc, v)
+		}
+	}
+	// "never" before the first contact
+	if d, err := time.ParseDuration(stats["last_contact"]); err == nil {
+		gauge(raftContactDesc, d.Seconds())
+	}
+}
+
+var metricsRegistry = prometheus.NewRegistry()
+
+func init() {
+	metricsRegistry.MustRegister(commandCalls, commandErrors, commandDuration, serverCollector{})
+}
+
+func handleMetrics(w http.ResponseWriter, r *http.Request) {
+	families, err := metricsRegistry.Gather()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	format := expfmt.NewFormat(expfmt.TypeTextPlain)
+	w.Header().Set("Content-Type", string(format))
+	enc := expfmt.NewEncoder(w, format)
+	for _, f := range families {
+		if err = enc.Encode(f); err != nil {
+			log.Println("metrics encoding fail:", err)
+			return
+		}
+	}
+}
+
+func serveMetrics(addr string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", handleMetrics)
+	mux.HandleFunc("/loglevel", handleLogLevel)
+	mux.HandleFunc("/healthz", handleHealthz)
+	mux.HandleFunc("/readyz", handleReadyz)
+	mux.HandleFunc("/drain", handleDrain)
+	ln, err := net.Listen("tcp", addr)
+	if err == nil {
+		err = http.Serve(&filteredListener{Listener: ln, name: listenerMetrics}, mux)
+	}
+	log.Println("metrics listen fail:", err)
+}
@@ -0,0 +1,41 @@
+//go:build alltest
+// +build alltest
+
+package main
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMetrics(t *testing.T) {
+	c := getTestConn()
+	ctx := context.Background()
+
+	if err := c.Set(ctx, "metrics", "1", 0).Err(); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Get(ctx, "metrics").Err(); err != nil {
+		t.Fatal(err)
+	}
+	_ = c.Get(ctx, "metrics-missing").Err()
+	_ = c.Do(ctx, "INCR", "metrics").Err()
+
+	w := httptest.NewRecorder()
+	handleMetrics(w, httptest.NewRequest("GET", "/metrics", nil))
+	body := w.Body.String()
+	for _, want := range []string{
+		`icefiredb_commands_total{cmd="set"}`,
+		`icefiredb_command_duration_seconds_bucket{cmd="get",le=`,
+		`icefiredb_command_errors_total{cmd="incr"}`,
+		"icefiredb_keyspace_misses_total",
+		`icefiredb_raft_state{state="leader"} 1`,
+		`icefiredb_raft_index{index="applied"}`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("metric %s missing", want)
+		}
+	}
+}
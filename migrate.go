@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	lediscfg "github.com/ledisdb/ledisdb/config"
+	"github.com/ledisdb/ledisdb/store"
+	"github.com/ledisdb/ledisdb/store/driver"
+)
+
+// migrateResult is the count of the keys copied by migrate-engine
+type migrateResult struct {
+	keys, bytes int64
+	verified    bool
+}
+
+// runMigrateEngine runs IceFireDB migrate-engine [options] and returns the
+// exit code
+func runMigrateEngine(args []string, out io.Writer) int {
+	fs := flag.NewFlagSet("migrate-engine", flag.ContinueOnError)
+	fs.SetOutput(out)
+	dir := fs.String("d", "data", "data directory of the node")
+	id := fs.String("n", "1", "node ID")
+	from := fs.String("from", "goleveldb", "storage backend of the data")
+	to := fs.String("to", "", "storage backend the data is copied to: "+strings.Join(storageEngines(), ", "))
+	batch := fs.Int("batch", 1000, "keys written per batch")
+	verify := fs.Bool("verify", true, "read the copy back and compare it with the data")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	var err error
+	switch {
+	case *to == "":
+		err = errors.New("flag --to is required")
+	case *to == *from:
+		err = errors.New("flags --from and --to name the same backend")
+	case *batch <= 0:
+		err = errors.New("--batch must be positive")
+	}
+	if err != nil {
+		fmt.Fprintln(out, err)
+		return 2
+	}
+	dataDir := filepath.Join(*dir, conf.Name, *id, "main.db")
+	start := time.Now()
+	res, err := migrateEngine(dataDir, *from, *to, *batch, *verify)
+	if err != nil {
+		fmt.Fprintln(out, err)
+		return 1
+	}
+	fmt.Fprintf(out, "copied %d keys, %d bytes, from %s to %s in %s\n", res.keys, res.bytes, *from, *to, time.Since(start).Round(time.Millisecond))
+	if res.verified {
+		fmt.Fprintln(out, "verified the copy")
+	}
+	fmt.Fprintf(out, "start the node with --storage-backend %s, the data of %s stays in %s\n",
+		*to, *from, filepath.Join(dataDir, *from+"_data"))
+	return 0
+}
+
+// storageEngines returns the names of the storage backends built in
+func storageEngines() []string {
+	names := driver.ListStores()
+	sort.Strings(names)
+	return names
+}
+
+// openEngine opens the store of a backend in the data directory of a node,
+// as the server does
+func openEngine(dataDir, name string) (*store.DB, error) {
+	cfg := lediscfg.NewConfigDefault()
+	cfg.DataDir = dataDir
+	cfg.Databases = 1
+	cfg.DBName = name
+	applyEngineOptions(cfg)
+	return store.Open(cfg)
+}
+
+// migrateEngine copies the keys of the store of a backend to the store of
+// another, in batches. The keys are copied as they are stored, with the
+// encodings of their types and their expirations, so the data reads the
+// same on the new backend. The node must be stopped and the target store
+// empty.
+func migrateEngine(dataDir, from, to string, batch int, verify bool) (migrateResult, error) {
+	var res migrateResult
+	src, err := openEngine(dataDir, from)
+	if err != nil {
+		return res, fmt.Errorf("opening %s: %w", from, err)
+	}
+	defer src.Close()
+	dst, err := openEngine(dataDir, to)
+	if err != nil {
+		return res, fmt.Errorf("opening %s: %w", to, err)
+	}
+	defer dst.Close()
+
+	it := dst.NewIterator()
+	it.SeekToFirst()
+	empty := !it.Valid()
+	it.Close()
+	if !empty {
+		return res, fmt.Errorf("the %s store of %s is not empty", to, dataDir)
+	}
+
+	it = src.NewIterator()
+	defer it.Close()
+	wb := dst.NewWriteBatch()
+	defer wb.Close()
+	n := 0
+	for it.SeekToFirst(); it.Valid(); it.Next() {
+		// the batch keeps the key and the value until it commits, Key and
+		// Value copy them
+		key, value := it.Key(), it.Value()
+		wb.Put(key, value)
+		res.keys++
+		res.bytes += int64(len(key) + len(value))
+		if n++; n == batch {
+			if err := wb.Commit(); err != nil {
+				return res, err
+			}
+			// goleveldb keeps the writes of a batch after its commit
+			_ = wb.Rollback()
+			n = 0
+		}
+	}
+	if err := wb.Commit(); err != nil {
+		return res, err
+	}
+	if !verify {
+		return res, nil
+	}
+	if err := verifyEngine(src, dst, res.keys); err != nil {
+		return res, fmt.Errorf("verifying %s: %w", to, err)
+	}
+	res.verified = true
+	return res, nil
+}
+
+// verifyEngine reads each key of the data back from the copy, and counts
+// the keys of the copy, whatever the order of the keys of the backends
+func verifyEngine(src, dst *store.DB, keys int64) error {
+	si := src.NewIterator()
+	defer si.Close()
+	for si.SeekToFirst(); si.Valid(); si.Next() {
+		v, err := dst.Get(si.RawKey())
+		if err != nil {
+			return err
+		}
+		if v == nil && len(si.RawValue()) > 0 {
+			return fmt.Errorf("key %q is missing", si.RawKey())
+		}
+		if !bytes.Equal(v, si.RawValue()) {
+			return fmt.Errorf("the value of %q differs", si.RawKey())
+		}
+	}
+	var n int64
+	di := dst.NewIterator()
+	defer di.Close()
+	for di.SeekToFirst(); di.Valid(); di.Next() {
+		n++
+	}
+	if n != keys {
+		return fmt.Errorf("the copy has %d keys, %d were copied", n, keys)
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+//go:build alltest
+// +build alltest
+
+package main
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ledisdb/ledisdb/config"
+	"github.com/ledisdb/ledisdb/ledis"
+)
+
+func TestMigrateEngine(t *testing.T) {
+	dir := t.TempDir()
+	dataDir := filepath.Join(dir, conf.Name, "1", "main.db")
+	cfg := config.NewConfigDefault()
+	cfg.DataDir = dataDir
+	cfg.Databases = 1
+	cfg.DBName = "goleveldb"
+	l, err := ledis.Open(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, _ := l.Select(0)
+	for _, k := range []string{"a", "b", "c"} {
+		d.Set([]byte(k), []byte("v-"+k))
+	}
+	d.Expire([]byte("a"), 3600)
+	d.HSet([]byte("h"), []byte("f"), []byte("1"))
+	d.SAdd([]byte("s"), []byte("m"))
+	l.Close()
+
+	var out bytes.Buffer
+	if code := runMigrateEngine([]string{"-d", dir, "--to", "badger", "--batch", "2"}, &out); code != 0 || !strings.Contains(out.String(), "verified") {
+		t.Fatalf("migrate-engine %d: %s", code, out.String())
+	}
+	cfg.DBName = "badger"
+	l, err = ledis.Open(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, _ = l.Select(0)
+	if v, _ := d.Get([]byte("b")); string(v) != "v-b" {
+		t.Errorf("GET b %q", v)
+	}
+	if ttl, _ := d.TTL([]byte("a")); ttl <= 0 {
+		t.Errorf("TTL a %d", ttl)
+	}
+	if v, _ := d.HGet([]byte("h"), []byte("f")); string(v) != "1" {
+		t.Errorf("HGET h f %q", v)
+	}
+	if n, _ := d.SIsMember([]byte("s"), []byte("m")); n != 1 {
+		t.Error("SISMEMBER s m")
+	}
+	l.Close()
+
+	// the copy is made once, to an empty store
+	out.Reset()
+	if code := runMigrateEngine([]string{"-d", dir, "--to", "badger"}, &out); code != 1 || !strings.Contains(out.String(), "not empty") {
+		t.Errorf("migrate-engine to a store with keys %d: %s", code, out.String())
+	}
+	if code := runMigrateEngine([]string{"-d", dir}, &out); code != 2 {
+		t.Errorf("migrate-engine without --to %d", code)
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	rafthub "github.com/tidwall/uhaha"
+)
+
+// The modes of a node, set by NODEMODE on each node and kept until the
+// node restarts, whatever its raft role
+const (
+	nodeModeNormal      = "normal"
+	nodeModeReadonly    = "readonly"
+	nodeModeMaintenance = "maintenance"
+)
+
+// nodeMode is the mode of this node, the reason given and since when
+var nodeMode = &nodeModeState{mode: nodeModeNormal}
+
+type nodeModeState struct {
+	mu     sync.RWMutex
+	mode   string
+	reason string
+	since  time.Time
+}
+
+func init() {
+	conf.AddIntermediateCommand("NODEMODE", cmdNODEMODE)
+}
+
+// get returns the mode of the node, its reason and since when
+func (n *nodeModeState) get() (mode, reason string, since time.Time) {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	return n.mode, n.reason, n.since
+}
+
+// set changes the mode of the node and publishes it
+func (n *nodeModeState) set(mode, reason string) error {
+	mode = strings.ToLower(mode)
+	switch mode {
+	case nodeModeNormal, nodeModeReadonly, nodeModeMaintenance:
+	default:
+		return fmt.Errorf("ERR unknown node mode '%s', expected normal, readonly or maintenance", mode)
+	}
+	if mode == nodeModeNormal {
+		reason = ""
+	}
+	// the reason is a line of NODEMODE
+	reason = strings.NewReplacer("\r", " ", "\n", " ").Replace(reason)
+	n.mu.Lock()
+	changed := n.mode != mode
+	n.mode, n.reason, n.since = mode, reason, time.Now()
+	n.mu.Unlock()
+	if changed {
+		events.Publish(eventNodeMode, map[string]interface{}{"mode": mode, "reason": reason})
+	}
+	return nil
+}
+
+// checkNodeMode refuses the writes of the clients of a node in read-only
+// mode, and every command but NODEMODE in maintenance
+func checkNodeMode(args []string) error {
+	mode, reason, _ := nodeMode.get()
+	if mode == nodeModeNormal || args[0] == "nodemode" {
+		return nil
+	}
+	if reason != "" {
+		reason = ": " + reason
+	}
+	if mode == nodeModeMaintenance {
+		return fmt.Errorf("MAINTENANCE node %s is in maintenance%s", conf.NodeID, reason)
+	}
+	cmd := args[0]
+	if cmd == "tracew" || writeCommands[cmd] != nil {
+		return fmt.Errorf("READONLY node %s is in read-only mode%s", conf.NodeID, reason)
+	}
+	return nil
+}
+
+// NODEMODE
+// NODEMODE SET normal|readonly|maintenance [reason]
+// Returns the mode of the node, or sets it. A node in read-only mode
+// refuses the writes of its clients, and in maintenance all their commands,
+// while it stays a member of the cluster. SET is only run by the users of
+// the admin role and the admin API.
+func cmdNODEMODE(m rafthub.Machine, args []string) (interface{}, error) {
+	if len(args) == 1 {
+		mode, reason, since := nodeMode.get()
+		var buf bytes.Buffer
+		buf.WriteString("# Nodemode\r\n")
+		fmt.Fprintf(&buf, "mode:%s\r\n", mode)
+		if !since.IsZero() {
+			fmt.Fprintf(&buf, "since:%d\r\n", since.Unix())
+		}
+		if reason != "" {
+			fmt.Fprintf(&buf, "reason:%s\r\n", reason)
+		}
+		return buf.String(), nil
+	}
+	if !strings.EqualFold(args[1], "set") {
+		return nil, fmt.Errorf("ERR unknown subcommand '%s'", args[1])
+	}
+	if len(args) != 3 && len(args) != 4 {
+		return nil, rafthub.ErrWrongNumArgs
+	}
+	if c, ok := m.Context().(*client); ok {
+		if cc := clients.get(c.id); cc == nil || !cc.isAdmin() {
+			return nil, errors.New("NOPERM NODEMODE SET needs the admin role")
+		}
+	}
+	reason := ""
+	if len(args) == 4 {
+		reason = args[3]
+	}
+	if err := nodeMode.set(args[2], reason); err != nil {
+		return nil, err
+	}
+	return "OK", nil
+}
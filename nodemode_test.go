@@ -0,0 +1,80 @@
+//go:build alltest
+// +build alltest
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/redis/go-redis/v9"
+)
+
+func TestNodeMode(t *testing.T) {
+	c := getTestConn()
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "acl.json")
+	writeACL(t, path, "pw", nil, aclUserConfig{Name: "ops", Roles: []string{"admin"}})
+	if err := acl.load(path); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		acl.mu.Lock()
+		acl.users, acl.roles, acl.providers, acl.failures = nil, nil, nil, make(map[string]*aclFailures)
+		acl.mu.Unlock()
+		_ = nodeMode.set(nodeModeNormal, "")
+	}()
+
+	if err := c.Do(ctx, "NODEMODE", "SET", "readonly").Err(); err == nil || !strings.HasPrefix(err.Error(), "NOPERM") {
+		t.Fatalf("NODEMODE SET of the default user: %v", err)
+	}
+	ops := redis.NewClient(&redis.Options{Addr: "127.0.0.1:11001", Username: "ops", Password: "pw", MaxRetries: -1, PoolSize: 1})
+	defer ops.Close()
+	if err := ops.Do(ctx, "NODEMODE", "SET", "offline").Err(); err == nil {
+		t.Errorf("unknown mode set")
+	}
+
+	if err := ops.Do(ctx, "NODEMODE", "SET", "readonly", "incident 42").Err(); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Set(ctx, "nodemode:k", "v", 0).Err(); err == nil || !strings.HasPrefix(err.Error(), "READONLY") {
+		t.Errorf("SET in read-only mode: %v", err)
+	}
+	if err := c.ZIncrBy(ctx, "nodemode:z", 1, "m").Err(); err == nil || !strings.HasPrefix(err.Error(), "READONLY") {
+		t.Errorf("ZINCRBY in read-only mode: %v", err)
+	}
+	if err := c.Get(ctx, "nodemode:k").Err(); err != redis.Nil {
+		t.Errorf("GET in read-only mode: %v", err)
+	}
+	info, err := c.Do(ctx, "NODEMODE").Text()
+	if err != nil || infoFields(info)["mode"] != "readonly" || infoFields(info)["reason"] != "incident 42" {
+		t.Errorf("NODEMODE %q %v", info, err)
+	}
+
+	if err := ops.Do(ctx, "NODEMODE", "SET", "maintenance").Err(); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Get(ctx, "nodemode:k").Err(); err == nil || !strings.HasPrefix(err.Error(), "MAINTENANCE") {
+		t.Errorf("GET in maintenance: %v", err)
+	}
+	if ready := checkReady(); ready.Ready || ready.Checks["mode"] != "maintenance" {
+		t.Errorf("a node in maintenance must not be ready, got %v", ready.Checks)
+	}
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPut, "/v1/mode", strings.NewReader(`{"mode":"normal"}`))
+	handleNodeMode(w, r)
+	var b nodeModeBody
+	if err := json.NewDecoder(w.Body).Decode(&b); err != nil || w.Code != http.StatusOK || b.Mode != nodeModeNormal {
+		t.Fatalf("PUT /v1/mode: %d %+v %v", w.Code, b, err)
+	}
+	if err := c.Set(ctx, "nodemode:k", "v", 0).Err(); err != nil {
+		t.Errorf("SET back in normal mode: %v", err)
+	}
+	c.Del(ctx, "nodemode:k")
+}
@@ -0,0 +1,301 @@
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// the data keys of the encrypted namespaces, as given to --namespace-keys:
+// ns=base64key,... The keys are never written to the storage, the values
+// of the namespaces are sealed before they are proposed to the log.
+var namespaceKeys string
+
+// nsCiphers are the AES-256-GCM ciphers of the encrypted namespaces by name
+var nsCiphers map[string]cipher.AEAD
+
+// the prefix of an encrypted value, followed by the nonce and the sealed
+// value. A value without it was written before its namespace was encrypted.
+var sealedPrefix = []byte("\x00ifenc1")
+
+var errSealedValue = errors.New("ERR cannot decrypt the value, wrong namespace key")
+
+// the commands whose values are encrypted, by the position of their first
+// value and the step to the next one, 0 for a single value
+var sealedArgs = map[string][2]int{
+	"set": {2, 0}, "setnx": {2, 0}, "getset": {2, 0},
+	"setex": {3, 0}, "setexat": {3, 0},
+	"mset": {2, 2}, "hset": {3, 2}, "hmset": {3, 2}, "hsetnx": {3, 0},
+	"lset": {3, 0}, "lpush": {2, 1}, "rpush": {2, 1},
+}
+
+// the commands returning the values of their key
+var sealedReplies = map[string]bool{
+	"get": true, "getset": true, "mget": true, "getat": true,
+	"hget": true, "hmget": true, "hvals": true, "hgetall": true,
+	"hscan": true, "xhscan": true,
+	"lindex": true, "lpop": true, "rpop": true, "lrange": true, "rpoplpush": true,
+}
+
+// the commands which do not read nor write the values of their keys
+var valuelessCommands = map[string]bool{
+	"del": true, "exists": true, "expire": true, "expireat": true, "ttl": true, "persist": true,
+	"hdel": true, "hexists": true, "hkeys": true, "hlen": true, "hkeyexists": true,
+	"hclear": true, "hmclear": true, "hexpire": true, "hexpireat": true, "hpersist": true, "httl": true,
+	"llen": true, "ltrim": true, "lkeyexists": true,
+	"lclear": true, "lmclear": true, "lexpire": true, "lexpireat": true, "lpersist": true, "lttl": true,
+	"xlen": true, "xack": true, "xgroup": true, "xpending": true, "xclear": true,
+	"watchprefix": true,
+}
+
+// loadNamespaceKeys parses --namespace-keys
+func loadNamespaceKeys() error {
+	nsCiphers = nil
+	if namespaceKeys == "" {
+		return nil
+	}
+	ciphers := make(map[string]cipher.AEAD)
+	for _, entry := range strings.Split(namespaceKeys, ",") {
+		ns, key, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		if !ok || !validNamespace(ns) || ns == defaultNamespace {
+			return fmt.Errorf("invalid namespace key '%s', expected ns=base64key", entry)
+		}
+		if _, ok := ciphers[ns]; ok {
+			return fmt.Errorf("namespace %s has two keys", ns)
+		}
+		raw, err := base64.StdEncoding.DecodeString(key)
+		if err != nil || len(raw) != 32 {
+			return fmt.Errorf("the key of namespace %s must be 32 bytes in base64", ns)
+		}
+		block, err := aes.NewCipher(raw)
+		if err != nil {
+			return err
+		}
+		if ciphers[ns], err = cipher.NewGCM(block); err != nil {
+			return err
+		}
+	}
+	nsCiphers = ciphers
+	return nil
+}
+
+// keyCipher returns the cipher of the namespace of a key, nil when it is
+// not encrypted
+func keyCipher(key string) cipher.AEAD {
+	if nsCiphers == nil {
+		return nil
+	}
+	return nsCiphers[keyNamespace(key)]
+}
+
+// sealValue encrypts a value of the namespace ns, which is authenticated
+// with it so a value cannot be moved to another namespace
+func sealValue(aead cipher.AEAD, ns, value string) (string, error) {
+	out := make([]byte, len(sealedPrefix)+aead.NonceSize(), len(sealedPrefix)+aead.NonceSize()+len(value)+aead.Overhead())
+	copy(out, sealedPrefix)
+	nonce := out[len(sealedPrefix):]
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	return string(aead.Seal(out, nonce, []byte(value), []byte(ns))), nil
+}
+
+// openValue decrypts a value of the namespace ns
+func openValue(aead cipher.AEAD, ns string, value []byte) ([]byte, error) {
+	if !bytes.HasPrefix(value, sealedPrefix) {
+		return value, nil
+	}
+	value = value[len(sealedPrefix):]
+	if len(value) < aead.NonceSize() {
+		return nil, errSealedValue
+	}
+	out, err := aead.Open(nil, value[:aead.NonceSize()], value[aead.NonceSize():], []byte(ns))
+	if err != nil {
+		return nil, errSealedValue
+	}
+	return out, nil
+}
+
+// sealedKeys returns the keys of a command, with the key of HSCAN and
+// XHSCAN, which are not counted in a namespace
+func sealedKeys(cmd string, args []string) []string {
+	if (cmd == "hscan" || cmd == "xhscan") && len(args) > 1 {
+		return args[1:2]
+	}
+	keys, _ := commandKeys(cmd, args)
+	return keys
+}
+
+// sealArgs encrypts in place the values of a command on the keys of the
+// encrypted namespaces. The commands which would read or change a value
+// without returning it, as APPEND or INCR, are refused on these keys, and
+// so are the sets and sorted sets, whose members are their keys.
+func sealArgs(args []string) error {
+	if nsCiphers == nil {
+		return nil
+	}
+	cmd := args[0]
+	if (cmd == "tracer" || cmd == "tracew") && len(args) > 2 {
+		return sealArgs(args[2:])
+	}
+	keys := sealedKeys(cmd, args)
+	encrypted := false
+	for _, key := range keys {
+		if keyCipher(key) != nil {
+			encrypted = true
+			break
+		}
+	}
+	if !encrypted || valuelessCommands[cmd] {
+		return nil
+	}
+	if cmd == "rpoplpush" && len(args) == 3 && keyNamespace(args[1]) != keyNamespace(args[2]) {
+		// the value popped is pushed as is, sealed with its namespace
+		return errors.New("ERR RPOPLPUSH cannot move a value out of an encrypted namespace")
+	}
+	if sealedReplies[cmd] && cmd != "getset" {
+		return nil
+	}
+	pos, ok := sealedArgs[cmd]
+	if !ok {
+		return fmt.Errorf("ERR '%s' is not supported on the keys of an encrypted namespace", cmd)
+	}
+	for i := pos[0]; i < len(args); i += pos[1] {
+		// the value of MSET follows its key, the others follow the first key
+		key := args[1]
+		if cmd == "mset" {
+			key = args[i-1]
+		}
+		if aead := keyCipher(key); aead != nil {
+			v, err := sealValue(aead, keyNamespace(key), args[i])
+			if err != nil {
+				return err
+			}
+			args[i] = v
+		}
+		if pos[1] == 0 {
+			break
+		}
+	}
+	return nil
+}
+
+// openReply decrypts the values of the reply of a command on the keys of
+// the encrypted namespaces
+func openReply(args []string, v interface{}) interface{} {
+	if nsCiphers == nil {
+		return v
+	}
+	cmd := args[0]
+	if (cmd == "tracer" || cmd == "tracew") && len(args) > 2 {
+		return openReply(args[2:], v)
+	}
+	if !sealedReplies[cmd] {
+		return v
+	}
+	keys := sealedKeys(cmd, args)
+	if len(keys) == 0 {
+		return v
+	}
+	key := keys[0]
+	aead := keyCipher(key)
+	if aead == nil && cmd != "mget" {
+		return v
+	}
+	ns := keyNamespace(key)
+	open := func(b []byte) ([]byte, error) {
+		if b == nil {
+			return nil, nil
+		}
+		return openValue(aead, ns, b)
+	}
+	switch v := v.(type) {
+	case []byte:
+		out, err := open(v)
+		if err != nil {
+			return err
+		}
+		return out
+	case string:
+		out, err := open([]byte(v))
+		if err != nil {
+			return err
+		}
+		return string(out)
+	case map[string]string:
+		out := make(map[string]string, len(v))
+		for f, b := range v {
+			p, err := open([]byte(b))
+			if err != nil {
+				return err
+			}
+			out[f] = string(p)
+		}
+		return out
+	case [][]byte:
+		out := make([][]byte, len(v))
+		for i, b := range v {
+			p, err := open(b)
+			if err != nil {
+				return err
+			}
+			out[i] = p
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		copy(out, v)
+		switch cmd {
+		case "hscan", "xhscan":
+			// cursor, [field value...]
+			if len(v) != 2 {
+				return v
+			}
+			pairs, ok := v[1].([][]byte)
+			if !ok {
+				return v
+			}
+			opened := make([][]byte, len(pairs))
+			copy(opened, pairs)
+			for i := 1; i < len(opened); i += 2 {
+				p, err := open(opened[i])
+				if err != nil {
+					return err
+				}
+				opened[i] = p
+			}
+			out[1] = opened
+		case "mget":
+			for i, e := range v {
+				b, ok := e.([]byte)
+				if !ok || i+1 >= len(args) {
+					continue
+				}
+				if aead := keyCipher(args[i+1]); aead != nil {
+					p, err := openValue(aead, keyNamespace(args[i+1]), b)
+					if err != nil {
+						return err
+					}
+					out[i] = p
+				}
+			}
+		default:
+			for i, e := range v {
+				if b, ok := e.([]byte); ok {
+					p, err := open(b)
+					if err != nil {
+						return err
+					}
+					out[i] = p
+				}
+			}
+		}
+		return out
+	}
+	return v
+}
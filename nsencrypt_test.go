@@ -0,0 +1,97 @@
+//go:build alltest
+// +build alltest
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+)
+
+const testNamespaceKey = "AAECAwQFBgcICQoLDA0ODxAREhMUFRYXGBkaGxwdHh8="
+
+func TestNamespaceKeys(t *testing.T) {
+	defer func() { namespaceKeys = ""; nsCiphers = nil }()
+	for _, v := range []string{"secret", "secret=short", "default=" + testNamespaceKey,
+		"a:b=" + testNamespaceKey, "s=" + testNamespaceKey + ",s=" + testNamespaceKey} {
+		namespaceKeys = v
+		if err := loadNamespaceKeys(); err == nil {
+			t.Errorf("--namespace-keys %q accepted", v)
+		}
+	}
+	namespaceKeys = "secret=" + testNamespaceKey
+	if err := loadNamespaceKeys(); err != nil {
+		t.Fatal(err)
+	}
+
+	args := []string{"mset", "secret:a", "v1", "plain:a", "v2"}
+	if err := sealArgs(args); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(args[2], string(sealedPrefix)) || args[4] != "v2" {
+		t.Fatalf("MSET sealed %q", args)
+	}
+	v := openReply([]string{"mget", "secret:a", "plain:a"}, []interface{}{[]byte(args[2]), []byte("v2")})
+	if got := v.([]interface{}); !bytes.Equal(got[0].([]byte), []byte("v1")) || !bytes.Equal(got[1].([]byte), []byte("v2")) {
+		t.Errorf("MGET opened %q", got)
+	}
+	// a value is bound to its namespace
+	nsCiphers["other"] = nsCiphers["secret"]
+	if err, ok := openReply([]string{"get", "other:a"}, []byte(args[2])).(error); !ok || err != errSealedValue {
+		t.Errorf("value opened in another namespace: %v", err)
+	}
+	// the values written before the key are returned as is
+	if v := openReply([]string{"get", "secret:a"}, []byte("old")); !bytes.Equal(v.([]byte), []byte("old")) {
+		t.Errorf("plain value %q", v)
+	}
+	for _, args := range [][]string{
+		{"append", "secret:a", "x"}, {"incr", "secret:n"}, {"sadd", "secret:s", "m"},
+		{"hincrby", "secret:h", "f", "1"}, {"rpoplpush", "secret:l", "plain:l"},
+	} {
+		if err := sealArgs(args); err == nil {
+			t.Errorf("%s accepted on an encrypted namespace", args[0])
+		}
+	}
+	if err := sealArgs([]string{"append", "plain:a", "x"}); err != nil {
+		t.Errorf("APPEND on a plain namespace: %v", err)
+	}
+}
+
+func TestNamespaceEncryption(t *testing.T) {
+	c := getTestConn()
+	ctx := context.Background()
+	namespaceKeys = "secret=" + testNamespaceKey
+	if err := loadNamespaceKeys(); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { namespaceKeys = ""; nsCiphers = nil }()
+
+	if err := c.Set(ctx, "secret:k", "value", 0).Err(); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := c.Get(ctx, "secret:k").Result(); err != nil || v != "value" {
+		t.Errorf("GET %q %v", v, err)
+	}
+	raw, err := ldb.Get([]byte("secret:k"))
+	if err != nil || bytes.Contains(raw, []byte("value")) || !bytes.HasPrefix(raw, sealedPrefix) {
+		t.Errorf("stored value %q %v", raw, err)
+	}
+	if err := c.HSet(ctx, "secret:h", "f1", "a", "f2", "b").Err(); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := c.HGetAll(ctx, "secret:h").Result(); err != nil || v["f1"] != "a" || v["f2"] != "b" {
+		t.Errorf("HGETALL %q %v", v, err)
+	}
+	if err := c.RPush(ctx, "secret:l", "x", "y").Err(); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := c.LRange(ctx, "secret:l", 0, -1).Result(); err != nil || strings.Join(v, ",") != "x,y" {
+		t.Errorf("LRANGE %q %v", v, err)
+	}
+	if err := c.Append(ctx, "secret:k", "x").Err(); err == nil {
+		t.Errorf("APPEND on an encrypted namespace")
+	}
+	c.Del(ctx, "secret:k", "secret:h", "secret:l")
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/salsa20/salsa"
+)
+
+// the scrypt cost of the new password hashes: 2^15 iterations of 1KiB
+// blocks, 32MiB and about 100ms per hash
+const (
+	scryptLogN   = 15
+	scryptR      = 8
+	scryptP      = 1
+	scryptSalt   = 16
+	scryptKeyLen = 32
+)
+
+var errPasswordHash = errors.New("invalid password hash, expected $scrypt$ln=N,r=R,p=P$salt$key")
+
+// hashPassword returns the scrypt hash of a password, in the format
+// $scrypt$ln=15,r=8,p=1$salt$key with the salt and the key in base64
+func hashPassword(password string) (string, error) {
+	salt := make([]byte, scryptSalt)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key, err := scryptKey([]byte(password), salt, 1<<scryptLogN, scryptR, scryptP, scryptKeyLen)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("$scrypt$ln=%d,r=%d,p=%d$%s$%s", scryptLogN, scryptR, scryptP,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+// passwordHash is a parsed scrypt hash
+type passwordHash struct {
+	logN, r, p int
+	salt, key  []byte
+}
+
+func parsePasswordHash(hash string) (*passwordHash, error) {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 5 || parts[0] != "" || parts[1] != "scrypt" {
+		return nil, errPasswordHash
+	}
+	h := &passwordHash{}
+	if _, err := fmt.Sscanf(parts[2], "ln=%d,r=%d,p=%d", &h.logN, &h.r, &h.p); err != nil {
+		return nil, errPasswordHash
+	}
+	// bounds the memory of a hash to 1GiB
+	if h.logN < 1 || h.logN > 20 || h.r < 1 || h.r > 32 || h.p < 1 || h.p > 16 {
+		return nil, fmt.Errorf("scrypt parameters out of range: %s", parts[2])
+	}
+	var err error
+	if h.salt, err = base64.RawStdEncoding.DecodeString(parts[3]); err != nil {
+		return nil, errPasswordHash
+	}
+	if h.key, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil || len(h.key) == 0 {
+		return nil, errPasswordHash
+	}
+	return h, nil
+}
+
+// verify reports whether the password matches the hash
+func (h *passwordHash) verify(password string) bool {
+	key, err := scryptKey([]byte(password), h.salt, 1<<h.logN, h.r, h.p, len(h.key))
+	return err == nil && subtle.ConstantTimeCompare(key, h.key) == 1
+}
+
+// scryptKey derives a key from a password as in RFC 7914
+func scryptKey(password, salt []byte, n, r, p, keyLen int) ([]byte, error) {
+	if n < 2 || n&(n-1) != 0 {
+		return nil, errors.New("scrypt: N must be a power of 2 over 1")
+	}
+	b, err := pbkdf2.Key(sha256.New, string(password), salt, 1, p*128*r)
+	if err != nil {
+		return nil, err
+	}
+	xy := make([]byte, 256*r)
+	v := make([]byte, 128*r*n)
+	for i := 0; i < p; i++ {
+		scryptROMix(b[i*128*r:(i+1)*128*r], r, n, v, xy)
+	}
+	return pbkdf2.Key(sha256.New, string(password), b, 1, keyLen)
+}
+
+// scryptROMix mixes the block b of 128*r bytes through n blocks of v
+func scryptROMix(b []byte, r, n int, v, xy []byte) {
+	size := 128 * r
+	x, y := xy[:size], xy[size:]
+	copy(x, b)
+	for i := 0; i < n; i++ {
+		copy(v[i*size:], x)
+		scryptBlockMix(x, y, r)
+	}
+	for i := 0; i < n; i++ {
+		j := int(binary.LittleEndian.Uint64(x[size-64:]) & uint64(n-1))
+		subtle.XORBytes(x, x, v[j*size:(j+1)*size])
+		scryptBlockMix(x, y, r)
+	}
+	copy(b, x)
+}
+
+// scryptBlockMix mixes the 2*r blocks of 64 bytes of b with Salsa20/8, y is
+// the scratch space
+func scryptBlockMix(b, y []byte, r int) {
+	var t [64]byte
+	copy(t[:], b[(2*r-1)*64:])
+	for i := 0; i < 2*r; i++ {
+		subtle.XORBytes(t[:], t[:], b[i*64:(i+1)*64])
+		salsa.Core208(&t, &t)
+		// the even blocks first, then the odd ones
+		copy(y[(i/2+(i%2)*r)*64:], t[:])
+	}
+	copy(b, y[:128*r])
+}
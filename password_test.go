@@ -0,0 +1,48 @@
+//go:build alltest
+// +build alltest
+
+package main
+
+import (
+	"encoding/hex"
+	"testing"
+)
+
+func TestScrypt(t *testing.T) {
+	// the test vectors of RFC 7914
+	for _, v := range []struct {
+		password, salt string
+		n, r, p        int
+		key            string
+	}{
+		{"", "", 16, 1, 1, "77d6576238657b203b19ca42c18a0497f16b4844e3074ae8dfdffa3fede21442fcd0069ded0948f8326a753a0fc81f17e8d3e0fb2e0d3628cf35e20c38d18906"},
+		{"password", "NaCl", 1024, 8, 16, "fdbabe1c9d3472007856e7190d01e9fe7c6ad7cbc8237830e77376634b3731622eaf30d92e22a3886ff109279d9830dac727afb94a83ee6d8360cbdfa2cc0640"},
+	} {
+		key, err := scryptKey([]byte(v.password), []byte(v.salt), v.n, v.r, v.p, 64)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := hex.EncodeToString(key); got != v.key {
+			t.Errorf("scrypt(%q, %q, %d, %d, %d) = %s", v.password, v.salt, v.n, v.r, v.p, got)
+		}
+	}
+}
+
+func TestPasswordHash(t *testing.T) {
+	hash, err := hashPassword("s3cret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	h, err := parsePasswordHash(hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !h.verify("s3cret") || h.verify("s3cret!") {
+		t.Errorf("verify of %s", hash)
+	}
+	for _, bad := range []string{"s3cret", "$2a$10$abc", "$scrypt$ln=15,r=8$c2FsdA$a2V5", "$scrypt$ln=40,r=8,p=1$c2FsdA$a2V5"} {
+		if _, err := parsePasswordHash(bad); err == nil {
+			t.Errorf("hash %q parsed", bad)
+		}
+	}
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	rafthub "github.com/tidwall/uhaha"
+)
+
+// the rate limits of the clients, 0 for no limit. The commands are counted
+// per second, the bandwidth in bytes per second read and written. A bucket
+// holds one second of its rate, so it absorbs bursts of that size.
+var (
+	userRateLimit      int64
+	userBandwidthLimit int64
+	ipRateLimit        int64
+	ipBandwidthLimit   int64
+)
+
+var (
+	errUserRateLimit      = errors.New("LIMIT too many commands for this user, retry later")
+	errUserBandwidthLimit = errors.New("LIMIT too much traffic for this user, retry later")
+	errIPRateLimit        = errors.New("LIMIT too many commands from this address, retry later")
+	errIPBandwidthLimit   = errors.New("LIMIT too much traffic from this address, retry later")
+)
+
+// the limits, by index in rateLimiter.limited
+const (
+	limitUserRate = iota
+	limitUserBandwidth
+	limitIPRate
+	limitIPBandwidth
+)
+
+// the buckets idle for longer are full again, and dropped
+const rateBucketIdle = time.Minute
+
+// tokenBucket holds the tokens left, refilled at the rate of its limit
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// refill adds the tokens of the time elapsed, up to one second of rate
+func (b *tokenBucket) refill(rate float64, now time.Time) {
+	if b.last.IsZero() {
+		b.tokens = rate
+	} else {
+		b.tokens = min(rate, b.tokens+now.Sub(b.last).Seconds()*rate)
+	}
+	b.last = now
+}
+
+// rateBucket holds the commands and bytes of a user or an address
+type rateBucket struct {
+	cmds, bytes tokenBucket
+}
+
+// rateLimiter holds the buckets of the users and addresses, and the commands
+// refused by each limit
+type rateLimiter struct {
+	mu      sync.Mutex
+	users   map[string]*rateBucket
+	ips     map[string]*rateBucket
+	limited [4]uint64
+}
+
+var limiter = &rateLimiter{users: make(map[string]*rateBucket), ips: make(map[string]*rateBucket)}
+
+// limitedKeys returns the user and the address a client is limited by, the
+// default user has no user limit. A gateway client is limited by the address
+// of its caller.
+func limitedKeys(c *clientConn) (user, ip string) {
+	if user = c.userName(); user == defaultUser {
+		user = ""
+	}
+	if c.gatewayIP != "" {
+		return user, c.gatewayIP
+	}
+	return user, addrIP(c.addr)
+}
+
+// addrIP returns the host of an address, the address without a port
+func addrIP(addr string) string {
+	ip, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	return ip
+}
+
+// gatewaySecret proves a client is a gateway of this node, which connect to
+// its port for their callers, so that they may pass the address of a caller
+// to the limits
+var gatewaySecret = newGatewaySecret()
+
+func newGatewaySecret() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// gatewayClient runs GATEWAYCLIENT secret ip, sent by a gateway for the
+// commands of a caller that follow. A client without the secret does not
+// know the command. The secret is left out of the command for MONITOR.
+func (c *clientConn) gatewayClient(args []string) error {
+	if len(args) != 3 || subtle.ConstantTimeCompare([]byte(args[1]), []byte(gatewaySecret)) != 1 {
+		return rafthub.ErrUnknownCommand
+	}
+	args[1] = "(redacted)"
+	c.gatewayIP = args[2]
+	return nil
+}
+
+// bucket returns the bucket of a key, nil without a key or a limit
+func (l *rateLimiter) bucket(m map[string]*rateBucket, key string, rate, bandwidth int64) *rateBucket {
+	if key == "" || (rate <= 0 && bandwidth <= 0) {
+		return nil
+	}
+	b := m[key]
+	if b == nil {
+		b = &rateBucket{}
+		m[key] = b
+	}
+	return b
+}
+
+// admit takes a command of size bytes read from a client, it returns the
+// error of the first limit it is over. A command is only refused once the
+// bytes are exhausted, so a command larger than a second of bandwidth
+// still runs, the client waits for the debt to be paid back.
+func (l *rateLimiter) admit(user, ip string, size int, now time.Time) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	ub := l.bucket(l.users, user, userRateLimit, userBandwidthLimit)
+	ib := l.bucket(l.ips, ip, ipRateLimit, ipBandwidthLimit)
+	type check struct {
+		b     *tokenBucket
+		limit int64
+		need  float64
+		index int
+		err   error
+	}
+	var checks []check
+	if ub != nil {
+		checks = append(checks,
+			check{&ub.cmds, userRateLimit, 1, limitUserRate, errUserRateLimit},
+			check{&ub.bytes, userBandwidthLimit, 0, limitUserBandwidth, errUserBandwidthLimit})
+	}
+	if ib != nil {
+		checks = append(checks,
+			check{&ib.cmds, ipRateLimit, 1, limitIPRate, errIPRateLimit},
+			check{&ib.bytes, ipBandwidthLimit, 0, limitIPBandwidth, errIPBandwidthLimit})
+	}
+	for _, c := range checks {
+		if c.limit <= 0 {
+			continue
+		}
+		c.b.refill(float64(c.limit), now)
+		if c.b.tokens < c.need || c.b.tokens <= 0 {
+			l.limited[c.index]++
+			return c.err
+		}
+	}
+	for _, c := range checks {
+		if c.limit <= 0 {
+			continue
+		}
+		if c.need > 0 {
+			c.b.tokens -= c.need
+		} else {
+			c.b.tokens -= float64(size)
+		}
+	}
+	return nil
+}
+
+// sent takes the bytes of a reply written to a client
+func (l *rateLimiter) sent(user, ip string, size int, now time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if b := l.users[user]; b != nil && userBandwidthLimit > 0 {
+		b.bytes.refill(float64(userBandwidthLimit), now)
+		b.bytes.tokens -= float64(size)
+	}
+	if b := l.ips[ip]; b != nil && ipBandwidthLimit > 0 {
+		b.bytes.refill(float64(ipBandwidthLimit), now)
+		b.bytes.tokens -= float64(size)
+	}
+}
+
+// prune drops the buckets idle for long enough to be full again
+func (l *rateLimiter) prune(now time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, m := range []map[string]*rateBucket{l.users, l.ips} {
+		for key, b := range m {
+			if now.Sub(b.cmds.last) > rateBucketIdle && now.Sub(b.bytes.last) > rateBucketIdle {
+				delete(m, key)
+			}
+		}
+	}
+}
+
+// stats returns the commands refused by each limit
+func (l *rateLimiter) stats() [4]uint64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.limited
+}
+
+// the CONFIG parameters of the limits
+var rateLimitParams = map[string]*int64{
+	"user-rate-limit":      &userRateLimit,
+	"user-bandwidth-limit": &userBandwidthLimit,
+	"ip-rate-limit":        &ipRateLimit,
+	"ip-bandwidth-limit":   &ipBandwidthLimit,
+}
+
+// limits returns the CONFIG parameters of the limits
+func (l *rateLimiter) limits() map[string]string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	params := make(map[string]string, len(rateLimitParams))
+	for name, v := range rateLimitParams {
+		params[name] = strconv.FormatInt(*v, 10)
+	}
+	return params
+}
+
+// setConfig sets a limit as in CONFIG SET, the buckets start over
+func (l *rateLimiter) setConfig(param, value string) error {
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || n < 0 {
+		return fmt.Errorf("ERR invalid %s '%s'", param, value)
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	*rateLimitParams[param] = n
+	l.users = make(map[string]*rateBucket)
+	l.ips = make(map[string]*rateBucket)
+	return nil
+}
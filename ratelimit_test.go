@@ -0,0 +1,104 @@
+//go:build alltest
+// +build alltest
+
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	rafthub "github.com/tidwall/uhaha"
+)
+
+func TestRateLimiter(t *testing.T) {
+	userRateLimit, ipBandwidthLimit = 2, 100
+	defer func() { userRateLimit, ipBandwidthLimit = 0, 0 }()
+	l := &rateLimiter{users: make(map[string]*rateBucket), ips: make(map[string]*rateBucket)}
+	now := time.Now()
+	for i := 0; i < 2; i++ {
+		if err := l.admit("alice", "10.0.0.1", 10, now); err != nil {
+			t.Fatalf("command %d: %v", i, err)
+		}
+	}
+	if err := l.admit("alice", "10.0.0.1", 10, now); err != errUserRateLimit {
+		t.Errorf("third command in a second: %v", err)
+	}
+	// the default user only has the address limit
+	if err := l.admit("", "10.0.0.1", 10, now); err != nil {
+		t.Errorf("default user: %v", err)
+	}
+	// the replies take the bandwidth, past it the address is refused
+	l.sent("", "10.0.0.1", 200, now)
+	if err := l.admit("", "10.0.0.1", 10, now); err != errIPBandwidthLimit {
+		t.Errorf("over the bandwidth: %v", err)
+	}
+	if err := l.admit("", "10.0.0.2", 10, now); err != nil {
+		t.Errorf("another address: %v", err)
+	}
+	// the debt is paid back at the rate of the limit
+	later := now.Add(2 * time.Second)
+	if err := l.admit("alice", "10.0.0.1", 10, later); err != nil {
+		t.Errorf("after 2s: %v", err)
+	}
+	if s := l.stats(); s[limitUserRate] != 1 || s[limitIPBandwidth] != 1 {
+		t.Errorf("stats: %v", s)
+	}
+	l.prune(later.Add(2 * rateBucketIdle))
+	if len(l.users) != 0 || len(l.ips) != 0 {
+		t.Errorf("buckets left after prune: %d %d", len(l.users), len(l.ips))
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	ctx := context.Background()
+	c := getTestConn()
+	if err := c.ConfigSet(ctx, "ip-rate-limit", "5").Err(); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := c.ConfigGet(ctx, "ip-rate-limit").Result(); err != nil || v["ip-rate-limit"] != "5" {
+		t.Errorf("CONFIG GET: %v %v", v, err)
+	}
+	limited := 0
+	for i := 0; i < 20; i++ {
+		err := c.Set(ctx, "ratelimit:a", "1", 0).Err()
+		if err != nil && strings.HasPrefix(err.Error(), "LIMIT ") {
+			limited++
+		} else if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if limited == 0 {
+		t.Error("no command refused over the rate limit")
+	}
+	// the bucket refills before the limit is lifted
+	time.Sleep(time.Second)
+	if err := c.ConfigSet(ctx, "ip-rate-limit", "0").Err(); err != nil {
+		t.Fatal(err)
+	}
+	info, err := c.Info(ctx, "clients").Result()
+	if err != nil || !strings.Contains(info, "rate_limited_ip_commands:") {
+		t.Errorf("INFO clients: %q %v", info, err)
+	}
+}
+
+func TestGatewayClient(t *testing.T) {
+	c := &clientConn{client: &client{addr: "127.0.0.1:5000"}}
+	if _, ip := limitedKeys(c); ip != "127.0.0.1" {
+		t.Errorf("limited by %q, expected the address of the client", ip)
+	}
+	if err := c.gatewayClient([]string{"gatewayclient", "wrong", "10.0.0.1"}); err != rafthub.ErrUnknownCommand {
+		t.Errorf("GATEWAYCLIENT without the secret: %v", err)
+	}
+	args := []string{"gatewayclient", gatewaySecret, "10.0.0.1"}
+	if err := c.gatewayClient(args); err != nil {
+		t.Fatal(err)
+	}
+	if args[1] == gatewaySecret {
+		t.Error("the secret is left in the command")
+	}
+	if _, ip := limitedKeys(c); ip != "10.0.0.1" {
+		t.Errorf("limited by %q, expected the address of the caller", ip)
+	}
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/tidwall/redcon"
+)
+
+// the remote namespaces, as given to --remote-namespaces:
+// ns=redis://[:password@]host:port[/db],... The commands on the keys of a
+// remote namespace run on its Redis or IceFireDB server instead of the log.
+var remoteNamespaces string
+
+// nsRemotes are the servers of the remote namespaces by name
+var nsRemotes map[string]*remoteNamespace
+
+// how long a remote namespace takes to connect and to answer a command
+const remoteTimeout = 5 * time.Second
+
+var errRemoteMixed = errors.New("ERR a command cannot mix the keys of a remote namespace with other keys")
+
+// the commands which are not sent to a remote namespace: WATCHPREFIX and
+// GETAT follow the log of the node
+var remoteUnsupported = map[string]bool{
+	"watchprefix": true, "getat": true,
+}
+
+// remoteNamespace is a namespace whose keys are on another server
+type remoteNamespace struct {
+	name string
+	// the URL of the server, without its password
+	addr string
+	pool *redis.Pool
+}
+
+// loadRemoteNamespaces parses --remote-namespaces. The servers are dialed
+// by the first command on their keys, a server down fails its commands
+// only.
+func loadRemoteNamespaces() error {
+	nsRemotes = nil
+	if remoteNamespaces == "" {
+		return nil
+	}
+	remotes := make(map[string]*remoteNamespace)
+	for _, entry := range strings.Split(remoteNamespaces, ",") {
+		ns, rawurl, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		if !ok || !validNamespace(ns) || ns == defaultNamespace {
+			return fmt.Errorf("invalid remote namespace '%s', expected ns=redis://host:port", entry)
+		}
+		if _, ok := remotes[ns]; ok {
+			return fmt.Errorf("namespace %s has two servers", ns)
+		}
+		if nsCiphers[ns] != nil {
+			return fmt.Errorf("namespace %s cannot be both encrypted and remote", ns)
+		}
+		u, err := url.Parse(rawurl)
+		if err != nil || (u.Scheme != "redis" && u.Scheme != "rediss") || u.Host == "" {
+			return fmt.Errorf("the server of namespace %s must be a redis:// or rediss:// URL", ns)
+		}
+		remotes[ns] = newRemoteNamespace(ns, rawurl)
+	}
+	nsRemotes = remotes
+	return nil
+}
+
+func newRemoteNamespace(ns, rawurl string) *remoteNamespace {
+	addr := rawurl
+	if u, err := url.Parse(rawurl); err == nil {
+		u.User = nil
+		addr = u.String()
+	}
+	return &remoteNamespace{
+		name: ns,
+		addr: addr,
+		pool: &redis.Pool{
+			MaxIdle:     16,
+			IdleTimeout: 4 * time.Minute,
+			Dial: func() (redis.Conn, error) {
+				return redis.DialURL(rawurl,
+					redis.DialConnectTimeout(remoteTimeout),
+					redis.DialReadTimeout(remoteTimeout),
+					redis.DialWriteTimeout(remoteTimeout))
+			},
+			TestOnBorrow: func(c redis.Conn, t time.Time) error {
+				if time.Since(t) < time.Minute {
+					return nil
+				}
+				_, err := c.Do("ping")
+				return err
+			},
+		},
+	}
+}
+
+// remoteOf returns the remote namespace of the keys of a command, nil when
+// they are local. The keys of a command are all in the same remote
+// namespace, or none is remote. The commands without a key, and those on
+// all the keys as KEYS, run on the node.
+func remoteOf(args []string) (*remoteNamespace, error) {
+	if nsRemotes == nil {
+		return nil, nil
+	}
+	keys, _ := commandKeys(args[0], args)
+	var remote *remoteNamespace
+	for i, key := range keys {
+		r := nsRemotes[keyNamespace(key)]
+		if i > 0 && r != remote {
+			return nil, errRemoteMixed
+		}
+		remote = r
+	}
+	if remote != nil && remoteUnsupported[args[0]] {
+		return nil, fmt.Errorf("ERR '%s' is not supported on the keys of a remote namespace", args[0])
+	}
+	return remote, nil
+}
+
+// remoteCall is the reply of a command sent to a remote namespace
+type remoteCall struct {
+	args    []string
+	done    chan struct{}
+	resp    interface{}
+	elapsed time.Duration
+	err     error
+}
+
+func (r *remoteCall) Args() []string {
+	return r.args
+}
+
+func (r *remoteCall) Recv() (interface{}, time.Duration, error) {
+	<-r.done
+	return r.resp, r.elapsed, r.err
+}
+
+// send runs a command on the server of the namespace, once the command of
+// the connection sent before it, prev, is answered, so that the commands of
+// a pipeline run in their order while the local ones do not wait for them
+func (ns *remoteNamespace) send(args []string, prev *remoteCall) *remoteCall {
+	r := &remoteCall{args: args, done: make(chan struct{})}
+	go func() {
+		defer close(r.done)
+		if prev != nil {
+			<-prev.done
+		}
+		start := time.Now()
+		r.resp, r.err = ns.do(args)
+		r.elapsed = time.Since(start)
+	}()
+	return r
+}
+
+// do runs a command on the server of the namespace and returns its reply
+// as the commands of the node return theirs
+func (ns *remoteNamespace) do(args []string) (interface{}, error) {
+	conn := ns.pool.Get()
+	defer conn.Close()
+	cmdArgs := make([]interface{}, len(args)-1)
+	for i, arg := range args[1:] {
+		cmdArgs[i] = arg
+	}
+	timeout := remoteTimeout
+	switch args[0] {
+	case "blpop":
+		// the server answers BLPOP at its timeout
+		secs, err := strconv.ParseFloat(args[len(args)-1], 64)
+		if err == nil && secs == 0 {
+			timeout = 0
+		} else if err == nil {
+			timeout += time.Duration(secs * float64(time.Second))
+		}
+	case "xread", "xreadgroup":
+		if r, err := parseStreamRead(args); err == nil && r.blocking && r.block == 0 {
+			timeout = 0
+		} else if err == nil && r.blocking {
+			timeout += r.block
+		}
+	}
+	v, err := redis.DoWithTimeout(conn, timeout, args[0], cmdArgs...)
+	if rerr, ok := err.(redis.Error); ok {
+		return nil, errors.New(string(rerr))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("ERR remote namespace %s at %s: %v", ns.name, ns.addr, err)
+	}
+	return remoteReply(v), nil
+}
+
+// remoteReply returns a reply of a server in the types of the replies of
+// the node: the status replies are simple strings, the integers simple
+// integers
+func remoteReply(v interface{}) interface{} {
+	switch v := v.(type) {
+	case string:
+		return redcon.SimpleString(v)
+	case int64:
+		return redcon.SimpleInt(v)
+	case redis.Error:
+		return errors.New(string(v))
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, e := range v {
+			out[i] = remoteReply(e)
+		}
+		return out
+	}
+	return v
+}
@@ -0,0 +1,139 @@
+//go:build alltest
+// +build alltest
+
+package main
+
+import (
+	"context"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/tidwall/redcon"
+)
+
+// serveRemote serves a Redis of strings on a random port
+func serveRemote(t *testing.T) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	var mu sync.Mutex
+	data := make(map[string]string)
+	go redcon.Serve(ln, func(conn redcon.Conn, cmd redcon.Command) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch strings.ToLower(string(cmd.Args[0])) {
+		case "set":
+			data[string(cmd.Args[1])] = string(cmd.Args[2])
+			conn.WriteString("OK")
+		case "get":
+			if v, ok := data[string(cmd.Args[1])]; ok {
+				conn.WriteBulkString(v)
+			} else {
+				conn.WriteNull()
+			}
+		case "del":
+			n := 0
+			for _, key := range cmd.Args[1:] {
+				if _, ok := data[string(key)]; ok {
+					delete(data, string(key))
+					n++
+				}
+			}
+			conn.WriteInt(n)
+		default:
+			conn.WriteError("ERR unknown command '" + string(cmd.Args[0]) + "'")
+		}
+	}, nil, nil)
+	return ln.Addr().String()
+}
+
+func TestLoadRemoteNamespaces(t *testing.T) {
+	defer func() { remoteNamespaces, nsRemotes = "", nil }()
+	for _, v := range []string{"fed", "fed=http://a", "default=redis://a", "fed=redis://a,fed=redis://b", "f*=redis://a"} {
+		remoteNamespaces = v
+		if err := loadRemoteNamespaces(); err == nil {
+			t.Errorf("%q must be refused", v)
+		}
+	}
+	remoteNamespaces = "fed=redis://:secret@127.0.0.1:6379/2, old=rediss://old:6380"
+	if err := loadRemoteNamespaces(); err != nil || len(nsRemotes) != 2 {
+		t.Fatalf("%v %v", nsRemotes, err)
+	}
+	if addr := nsRemotes["fed"].addr; strings.Contains(addr, "secret") {
+		t.Errorf("the password must not show in %s", addr)
+	}
+	for _, tc := range []struct {
+		args   []string
+		remote string
+		err    bool
+	}{
+		{[]string{"get", "fed:a"}, "fed", false},
+		{[]string{"mget", "fed:a", "fed:b"}, "fed", false},
+		{[]string{"get", "local"}, "", false},
+		{[]string{"ping"}, "", false},
+		{[]string{"keys", "fed:*"}, "", false},
+		{[]string{"mget", "fed:a", "local"}, "", true},
+		{[]string{"mset", "fed:a", "1", "old:a", "2"}, "", true},
+		{[]string{"watchprefix", "fed:"}, "", true},
+	} {
+		r, err := remoteOf(tc.args)
+		name := ""
+		if r != nil {
+			name = r.name
+		}
+		if name != tc.remote || (err != nil) != tc.err {
+			t.Errorf("%q: remote %q %v", tc.args, name, err)
+		}
+	}
+}
+
+func TestRemoteNamespace(t *testing.T) {
+	c := getTestConn()
+	ctx := context.Background()
+	defer func() { nsRemotes = nil }()
+	nsRemotes = map[string]*remoteNamespace{"fed": newRemoteNamespace("fed", "redis://"+serveRemote(t))}
+
+	if err := c.Set(ctx, "fed:a", "remote", 0).Err(); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := c.Get(ctx, "fed:a").Result(); err != nil || v != "remote" {
+		t.Errorf("GET %q %v", v, err)
+	}
+	if v, _ := localDo("get", "fed:a"); v.Data != nil {
+		t.Errorf("the key of a remote namespace is on the node: %s", v)
+	}
+	if err := c.Get(ctx, "fed:none").Err(); err != redis.Nil {
+		t.Errorf("GET of a missing key %v", err)
+	}
+	if err := c.Incr(ctx, "fed:a").Err(); err == nil || !strings.Contains(err.Error(), "unknown command") {
+		t.Errorf("the errors of the server are returned, got %v", err)
+	}
+	if err := c.MGet(ctx, "fed:a", "local").Err(); err == nil {
+		t.Error("a command on remote and local keys must fail")
+	}
+
+	// the commands of a pipeline run in their order
+	pipe := c.Pipeline()
+	pipe.Set(ctx, "local", "1", 0)
+	set := pipe.Set(ctx, "fed:b", "2", 0)
+	get := pipe.Get(ctx, "fed:b")
+	del := pipe.Del(ctx, "fed:a", "fed:b")
+	local := pipe.Get(ctx, "local")
+	if _, err := pipe.Exec(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if set.Val() != "OK" || get.Val() != "2" || del.Val() != 2 || local.Val() != "1" {
+		t.Errorf("pipeline %v %v %v %v", set, get, del, local)
+	}
+	c.Del(ctx, "local")
+
+	nsRemotes["fed"] = newRemoteNamespace("fed", "redis://127.0.0.1:1")
+	if err := c.Get(ctx, "fed:a").Err(); err == nil || !strings.Contains(err.Error(), "remote namespace fed") {
+		t.Errorf("a server down fails its commands, got %v", err)
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"errors"
+	"runtime"
+	deb "runtime/debug"
+	"runtime/metrics"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	// the memory budget of the process in MB, 0 for no limit. The garbage
+	// collector runs harder as the process nears it, and the node sheds
+	// its load over it.
+	memoryLimit int64
+	// the CPUs running the goroutines of the process at once, 0 for all
+	cpuLimit int
+	// the memory of the process at the last check, in bytes
+	memoryUsed atomic.Uint64
+	// whether the process is over its memory budget
+	memoryPressure atomic.Bool
+)
+
+const (
+	// the accepts slow down over this share of --memory-limit, and the
+	// pressure is lifted under it
+	memoryResumeRatio = 0.9
+	// how long each accept waits while slowed down
+	memoryAcceptDelay = 10 * time.Millisecond
+)
+
+var errMemoryPressure = errors.New("ERR the server is over its memory limit, try again later")
+
+// the runtime metrics of the memory mapped by the process, and of the part
+// of it given back to the system
+var memorySamples = []metrics.Sample{
+	{Name: "/memory/classes/total:bytes"},
+	{Name: "/memory/classes/heap/released:bytes"},
+}
+
+// applyResourceLimits sets --cpu-limit and --memory-limit on the runtime
+func applyResourceLimits() {
+	if cpuLimit > 0 {
+		runtime.GOMAXPROCS(cpuLimit)
+	}
+	if memoryLimit > 0 {
+		deb.SetMemoryLimit(memoryLimit << 20)
+	}
+}
+
+// processMemory returns the memory the process holds from the system
+func processMemory() uint64 {
+	samples := make([]metrics.Sample, len(memorySamples))
+	copy(samples, memorySamples)
+	metrics.Read(samples)
+	total, released := samples[0].Value.Uint64(), samples[1].Value.Uint64()
+	if released > total {
+		return 0
+	}
+	return total - released
+}
+
+// watchMemory checks the memory of the process every interval until stop
+// is closed
+func watchMemory(interval time.Duration, stop <-chan struct{}) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		checkMemory()
+		select {
+		case <-stop:
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// checkMemory puts the node under memory pressure over --memory-limit,
+// and lifts it under 90% of it
+func checkMemory() {
+	used := processMemory()
+	memoryUsed.Store(used)
+	limit := uint64(memoryLimit) << 20
+	data := map[string]interface{}{"used_bytes": used, "limit_bytes": limit}
+	switch pressure := memoryPressure.Load(); {
+	case !pressure && used >= limit:
+		memoryPressure.Store(true)
+		events.Publish(eventMemoryPressure, data)
+	case pressure && float64(used) < memoryResumeRatio*float64(limit):
+		memoryPressure.Store(false)
+		events.Publish(eventMemoryRecovered, data)
+	}
+}
+
+// acceptBackpressure slows the accepts of the clients over 90% of
+// --memory-limit and refuses them under memory pressure, before the system
+// kills the process
+func acceptBackpressure() error {
+	if memoryLimit <= 0 {
+		return nil
+	}
+	if memoryPressure.Load() {
+		return errMemoryPressure
+	}
+	if float64(memoryUsed.Load()) >= memoryResumeRatio*float64(memoryLimit<<20) {
+		time.Sleep(memoryAcceptDelay)
+	}
+	return nil
+}
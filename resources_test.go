@@ -0,0 +1,63 @@
+//go:build alltest
+// +build alltest
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+func TestMemoryPressure(t *testing.T) {
+	c := getTestConn()
+	ctx := context.Background()
+	defer func() {
+		memoryLimit = 0
+		memoryPressure.Store(false)
+	}()
+	if processMemory() == 0 {
+		t.Fatal("no memory used by the process")
+	}
+
+	memoryLimit = 1
+	checkMemory()
+	if !memoryPressure.Load() {
+		t.Fatalf("no pressure at %d bytes over a 1 MB limit", memoryUsed.Load())
+	}
+	// the new connections are shed with an error before their first command
+	nc, err := net.Dial("tcp", "127.0.0.1:11001")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = nc.SetDeadline(time.Now().Add(5 * time.Second))
+	_, _ = nc.Write([]byte("PING\r\n"))
+	line, _ := bufio.NewReader(nc).ReadString('\n')
+	nc.Close()
+	if line != "-"+errMemoryPressure.Error()+"\r\n" {
+		t.Errorf("client accepted under memory pressure: %q", line)
+	}
+	// the clients already connected are still served
+	if err := c.Ping(ctx).Err(); err != nil {
+		t.Errorf("PING of a connected client: %v", err)
+	}
+	if err := c.Do(ctx, "DIAGNOSTICS", "BUNDLE").Err(); err == nil || !strings.Contains(err.Error(), "memory limit") {
+		t.Errorf("DIAGNOSTICS BUNDLE under memory pressure: %v", err)
+	}
+
+	memoryLimit = 1 << 20
+	checkMemory()
+	if memoryPressure.Load() {
+		t.Fatal("pressure not lifted under the limit")
+	}
+	other := redis.NewClient(&redis.Options{Addr: "127.0.0.1:11001", MaxRetries: -1, PoolSize: 1})
+	defer other.Close()
+	if err := other.Ping(ctx).Err(); err != nil {
+		t.Errorf("client refused once the pressure is lifted: %v", err)
+	}
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"math"
+	"math/big"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/tidwall/redcon"
+)
+
+// The reply types of RESP3, spoken to the clients that sent HELLO 3. The
+// RESP2 clients get them in their RESP2 form: a map, a set or a push as an
+// array, a double as a bulk string.
+type (
+	// resp3Map is a map reply, its keys and values in turn
+	resp3Map []interface{}
+	// resp3Set is a set reply
+	resp3Set []interface{}
+	// resp3Push is a message sent out of the replies, as the changes of
+	// WATCHPREFIX
+	resp3Push []interface{}
+	// resp3Double is a floating point reply
+	resp3Double float64
+)
+
+// resp3Replies reshape the replies of the commands whose type is richer in
+// RESP3 than in RESP2
+var resp3Replies = map[string]func(args []string, v interface{}) interface{}{
+	"smembers":         resp3AsSet,
+	"sinter":           resp3AsSet,
+	"sunion":           resp3AsSet,
+	"sdiff":            resp3AsSet,
+	"zscore":           resp3AsDouble,
+	"zincrby":          resp3AsDouble,
+	"zrange":           resp3WithScores,
+	"zrevrange":        resp3WithScores,
+	"zrangebyscore":    resp3WithScores,
+	"zrevrangebyscore": resp3WithScores,
+	"xread":            resp3Streams,
+	"xreadgroup":       resp3Streams,
+	"config": func(args []string, v interface{}) interface{} {
+		if pairs, ok := v.([]interface{}); ok && strings.EqualFold(args[1], "get") {
+			return resp3Map(pairs)
+		}
+		return v
+	},
+}
+
+// resp3Reply returns the RESP3 form of the reply of a command
+func resp3Reply(args []string, v interface{}) interface{} {
+	if _, ok := v.(error); ok || v == nil {
+		return v
+	}
+	if reshape := resp3Replies[args[0]]; reshape != nil {
+		return reshape(args, v)
+	}
+	return v
+}
+
+// resp3Bulks returns the bulk strings of an array reply, as the node or a
+// remote namespace returns them
+func resp3Bulks(v interface{}) ([][]byte, bool) {
+	switch v := v.(type) {
+	case [][]byte:
+		return v, true
+	case []interface{}:
+		bulks := make([][]byte, len(v))
+		for i, e := range v {
+			b, ok := e.([]byte)
+			if !ok {
+				return nil, false
+			}
+			bulks[i] = b
+		}
+		return bulks, true
+	}
+	return nil, false
+}
+
+func resp3AsSet(_ []string, v interface{}) interface{} {
+	members, ok := resp3Bulks(v)
+	if !ok {
+		return v
+	}
+	set := make(resp3Set, len(members))
+	for i, m := range members {
+		set[i] = m
+	}
+	return set
+}
+
+func resp3AsDouble(_ []string, v interface{}) interface{} {
+	switch v := v.(type) {
+	case redcon.SimpleInt:
+		return resp3Double(v)
+	case []byte:
+		if f, err := strconv.ParseFloat(string(v), 64); err == nil {
+			return resp3Double(f)
+		}
+	}
+	return v
+}
+
+// resp3Streams replies the entries of XREAD and XREADGROUP as a map of
+// the streams, as Redis does in RESP3
+func resp3Streams(_ []string, v interface{}) interface{} {
+	streams, ok := v.([]interface{})
+	if !ok {
+		return v
+	}
+	m := make(resp3Map, 0, len(streams)*2)
+	for _, s := range streams {
+		kv, ok := s.([]interface{})
+		if !ok || len(kv) != 2 {
+			return v
+		}
+		m = append(m, kv...)
+	}
+	return m
+}
+
+// resp3WithScores replies the members of a sorted set WITHSCORES as pairs
+// of the member and its score, as Redis does in RESP3
+func resp3WithScores(args []string, v interface{}) interface{} {
+	flat, ok := resp3Bulks(v)
+	withScores := false
+	for _, arg := range args[2:] {
+		withScores = withScores || strings.EqualFold(arg, "withscores")
+	}
+	if !ok || !withScores || len(flat)%2 != 0 {
+		return v
+	}
+	pairs := make([]interface{}, 0, len(flat)/2)
+	for i := 0; i < len(flat); i += 2 {
+		score, err := strconv.ParseFloat(string(flat[i+1]), 64)
+		if err != nil {
+			return v
+		}
+		pairs = append(pairs, []interface{}{flat[i], resp3Double(score)})
+	}
+	return pairs
+}
+
+// appendRESP3 appends a reply in RESP3: the null, the booleans, the
+// doubles, the big numbers, the maps, the sets and the pushes have their
+// own types, the other replies are written as in RESP2
+func appendRESP3(b []byte, v interface{}) []byte {
+	switch v := v.(type) {
+	case nil:
+		return append(b, "_\r\n"...)
+	case bool:
+		if v {
+			return append(b, "#t\r\n"...)
+		}
+		return append(b, "#f\r\n"...)
+	case resp3Double:
+		return appendRESP3Double(b, float64(v))
+	case *big.Int:
+		b = append(b, '(')
+		b = v.Append(b, 10)
+		return append(b, "\r\n"...)
+	case resp3Map:
+		return appendRESP3Aggregate(b, '%', len(v)/2, v)
+	case resp3Set:
+		return appendRESP3Aggregate(b, '~', len(v), v)
+	case resp3Push:
+		return appendRESP3Aggregate(b, '>', len(v), v)
+	case []interface{}:
+		return appendRESP3Aggregate(b, '*', len(v), v)
+	case error, string, []byte, redcon.SimpleString, redcon.SimpleInt, redcon.SimpleError, redcon.Marshaler:
+		return redcon.AppendAny(b, v)
+	}
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Slice:
+		b = appendRESP3Header(b, '*', rv.Len())
+		for i := 0; i < rv.Len(); i++ {
+			b = appendRESP3(b, rv.Index(i).Interface())
+		}
+		return b
+	case reflect.Map:
+		// the keys in order, as redcon writes the maps in RESP2
+		keys := rv.MapKeys()
+		sort.Slice(keys, func(i, j int) bool {
+			return keys[i].String() < keys[j].String()
+		})
+		b = appendRESP3Header(b, '%', len(keys))
+		for _, k := range keys {
+			b = appendRESP3(b, k.Interface())
+			b = appendRESP3(b, rv.MapIndex(k).Interface())
+		}
+		return b
+	}
+	return redcon.AppendAny(b, v)
+}
+
+func appendRESP3Header(b []byte, typ byte, n int) []byte {
+	b = append(b, typ)
+	b = strconv.AppendInt(b, int64(n), 10)
+	return append(b, "\r\n"...)
+}
+
+func appendRESP3Aggregate(b []byte, typ byte, n int, items []interface{}) []byte {
+	b = appendRESP3Header(b, typ, n)
+	for _, item := range items {
+		b = appendRESP3(b, item)
+	}
+	return b
+}
+
+func appendRESP3Double(b []byte, f float64) []byte {
+	b = append(b, ',')
+	switch {
+	case math.IsInf(f, 1):
+		b = append(b, "inf"...)
+	case math.IsInf(f, -1):
+		b = append(b, "-inf"...)
+	case math.IsNaN(f):
+		b = append(b, "nan"...)
+	default:
+		b = strconv.AppendFloat(b, f, 'g', -1, 64)
+	}
+	return append(b, "\r\n"...)
+}
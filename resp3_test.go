@@ -0,0 +1,122 @@
+//go:build alltest
+// +build alltest
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"math"
+	"math/big"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/tidwall/redcon"
+)
+
+func TestAppendRESP3(t *testing.T) {
+	n, _ := new(big.Int).SetString("3492890328409238509324850943850943825024385", 10)
+	for _, tc := range []struct {
+		v    interface{}
+		resp string
+	}{
+		{nil, "_\r\n"},
+		{true, "#t\r\n"},
+		{resp3Double(1.5), ",1.5\r\n"},
+		{resp3Double(math.Inf(-1)), ",-inf\r\n"},
+		{n, "(3492890328409238509324850943850943825024385\r\n"},
+		{resp3Map{"a", redcon.SimpleInt(1)}, "%1\r\n$1\r\na\r\n:1\r\n"},
+		{resp3Set{"x"}, "~1\r\n$1\r\nx\r\n"},
+		{resp3Push{"change", "1"}, ">2\r\n$6\r\nchange\r\n$1\r\n1\r\n"},
+		{[]interface{}{[]byte("v"), nil}, "*2\r\n$1\r\nv\r\n_\r\n"},
+		{map[string]string{"b": "2", "a": "1"}, "%2\r\n$1\r\na\r\n$1\r\n1\r\n$1\r\nb\r\n$1\r\n2\r\n"},
+		{errors.New("ERR boom"), "-ERR boom\r\n"},
+		{redcon.SimpleString("OK"), "+OK\r\n"},
+	} {
+		if resp := string(appendRESP3(nil, tc.v)); resp != tc.resp {
+			t.Errorf("%#v: %q, expected %q", tc.v, resp, tc.resp)
+		}
+	}
+	// the RESP2 clients get the RESP2 form
+	if resp := string(redcon.AppendAny(nil, resp3Map{"a", "1"})); resp != "*2\r\n$1\r\na\r\n$1\r\n1\r\n" {
+		t.Errorf("RESP2 map %q", resp)
+	}
+}
+
+func TestRESP3(t *testing.T) {
+	getTestConn()
+	ctx := context.Background()
+	c := redis.NewClient(&redis.Options{Addr: "127.0.0.1:11001", Protocol: 3, MaxRetries: -1, PoolSize: 1})
+	defer c.Close()
+	defer c.Del(ctx, "resp3:h", "resp3:s", "resp3:z", "resp3:k")
+
+	hello, err := c.Do(ctx, "hello", "3").Result()
+	if m, ok := hello.(map[interface{}]interface{}); err != nil || !ok || m["proto"] != int64(3) {
+		t.Fatalf("HELLO 3 %#v %v", hello, err)
+	}
+	c.HSet(ctx, "resp3:h", "f", "v")
+	if v, err := c.Do(ctx, "hgetall", "resp3:h").Result(); err != nil || v.(map[interface{}]interface{})["f"] != "v" {
+		t.Errorf("HGETALL %#v %v", v, err)
+	}
+	c.SAdd(ctx, "resp3:s", "a", "b")
+	// go-redis reads a set as a slice
+	if v, err := c.Do(ctx, "smembers", "resp3:s").Result(); err != nil || len(v.([]interface{})) != 2 {
+		t.Errorf("SMEMBERS %#v %v", v, err)
+	}
+	c.ZAdd(ctx, "resp3:z", redis.Z{Score: 2, Member: "m"}, redis.Z{Score: 1, Member: "l"})
+	if score, err := c.ZScore(ctx, "resp3:z", "m").Result(); err != nil || score != 2 {
+		t.Errorf("ZSCORE %v %v", score, err)
+	}
+	if zs, err := c.ZRangeWithScores(ctx, "resp3:z", 0, -1).Result(); err != nil || len(zs) != 2 || zs[0].Member != "l" || zs[1].Score != 2 {
+		t.Errorf("ZRANGE WITHSCORES %v %v", zs, err)
+	}
+	c.Set(ctx, "resp3:k", "v", 0)
+	if vs, err := c.MGet(ctx, "resp3:k", "resp3:none").Result(); err != nil || vs[0] != "v" || vs[1] != nil {
+		t.Errorf("MGET %v %v", vs, err)
+	}
+	if v, err := c.Do(ctx, "get", "resp3:none").Result(); err != redis.Nil {
+		t.Errorf("GET of a missing key %v %v", v, err)
+	}
+	if v, err := c.Do(ctx, "hello", "4").Result(); err == nil || !strings.HasPrefix(err.Error(), "NOPROTO") {
+		t.Errorf("HELLO 4 %v %v", v, err)
+	}
+}
+
+func TestRESP3Push(t *testing.T) {
+	c := getTestConn()
+	ctx := context.Background()
+	conn, err := net.Dial("tcp", "127.0.0.1:11001")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	rd := bufio.NewReader(conn)
+	conn.Write([]byte("HELLO 3\r\n"))
+	if line, _ := rd.ReadString('\n'); !strings.HasPrefix(line, "%") {
+		t.Fatalf("HELLO 3 replied %q", line)
+	}
+	// skip the rest of the HELLO reply
+	conn.Write([]byte("PING\r\n"))
+	for line, _ := rd.ReadString('\n'); line != "+PONG\r\n"; line, _ = rd.ReadString('\n') {
+		if line == "" {
+			t.Fatal("no PONG")
+		}
+	}
+	conn.Write([]byte("WATCHPREFIX resp3push:\r\n"))
+	if line, _ := rd.ReadString('\n'); line != ">3\r\n" {
+		t.Fatalf("watching %q", line)
+	}
+	for i := 0; i < 6; i++ {
+		rd.ReadString('\n')
+	}
+	c.Set(ctx, "resp3push:a", "1", 0)
+	defer c.Del(ctx, "resp3push:a")
+	if line, _ := rd.ReadString('\n'); line != ">4\r\n" {
+		t.Errorf("change %q", line)
+	}
+}
@@ -0,0 +1,306 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var (
+	// the address of the REST API of the data, disabled when empty
+	restAddr string
+)
+
+const (
+	// the largest value of PUT /kv/{key}, the largest value of ledis
+	restMaxValue = 1 << 30
+	// the largest body of POST /batch
+	restMaxBatch = 64 << 20
+	// the keys of a range query by default, and at most
+	restDefaultLimit = 100
+	restMaxLimit     = 10000
+)
+
+// restBatch is the body of POST /batch, its commands run in this order
+type restBatch struct {
+	Set    map[string]string `json:"set,omitempty"`
+	Delete []string          `json:"delete,omitempty"`
+	Get    []string          `json:"get,omitempty"`
+}
+
+// restBatchResult is the reply of POST /batch, the values of the keys
+// missing are null
+type restBatchResult struct {
+	Deleted int64              `json:"deleted"`
+	Values  map[string]*string `json:"values,omitempty"`
+}
+
+// restRange is the reply of a range query, next is the after of the next
+// page, empty on the last one
+type restRange struct {
+	Keys   []string           `json:"keys"`
+	Values map[string]*string `json:"values,omitempty"`
+	Next   string             `json:"next,omitempty"`
+}
+
+// restHandler returns the REST API of the data. The requests authenticate
+// with HTTP Basic as a user of the ACL, or run as the default user, and
+// their commands run as those of a RESP client.
+func restHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /kv/{key...}", handleRESTGet)
+	mux.HandleFunc("PUT /kv/{key...}", handleRESTPut)
+	mux.HandleFunc("DELETE /kv/{key...}", handleRESTDelete)
+	mux.HandleFunc("GET /kv", handleRESTRange)
+	mux.HandleFunc("POST /batch", handleRESTBatch)
+	return mux
+}
+
+// restDo returns the function running the commands of a request as the
+// user of its credentials
+func restDo(r *http.Request) runFunc {
+	user, password, _ := r.BasicAuth()
+	return func(args ...string) (interface{}, error) {
+		return gatewayDo(r.Context(), r.RemoteAddr, user, password, args...)
+	}
+}
+
+// restError replies the error of a command with the HTTP status of its
+// gRPC code, a follower answers 409 with the address of the leader
+func restError(w http.ResponseWriter, err error) {
+	msg := err.Error()
+	if strings.HasPrefix(msg, "MOVED 0 ") || strings.HasPrefix(msg, "TRY ") {
+		writeError(w, err)
+		return
+	}
+	code := http.StatusInternalServerError
+	switch status.Code(grpcError(err)) {
+	case codes.Unauthenticated:
+		w.Header().Set("WWW-Authenticate", `Basic realm="icefiredb"`)
+		code = http.StatusUnauthorized
+	case codes.PermissionDenied:
+		code = http.StatusForbidden
+	case codes.ResourceExhausted:
+		code = http.StatusTooManyRequests
+	case codes.Unavailable:
+		code = http.StatusServiceUnavailable
+	case codes.InvalidArgument, codes.FailedPrecondition:
+		code = http.StatusBadRequest
+	case codes.Canceled, codes.DeadlineExceeded:
+		code = http.StatusGatewayTimeout
+	}
+	writeJSON(w, code, adminError{Error: msg})
+}
+
+// GET /kv/{key} replies the value of the key, 404 when it is missing
+func handleRESTGet(w http.ResponseWriter, r *http.Request) {
+	v, err := restDo(r)("get", r.PathValue("key"))
+	if err != nil {
+		restError(w, err)
+		return
+	}
+	if v == nil {
+		writeJSON(w, http.StatusNotFound, adminError{Error: "not found"})
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	_, _ = w.Write(replyBytes(v))
+}
+
+// PUT /kv/{key}[?ttl=seconds] sets the value of the key to the body
+func handleRESTPut(w http.ResponseWriter, r *http.Request) {
+	args := []string{"set", r.PathValue("key")}
+	if ttl := r.URL.Query().Get("ttl"); ttl != "" {
+		if n, err := strconv.ParseInt(ttl, 10, 64); err != nil || n <= 0 {
+			writeJSON(w, http.StatusBadRequest, adminError{Error: "invalid ttl"})
+			return
+		}
+		// SET has no EX here
+		args = []string{"setex", r.PathValue("key"), ttl}
+	}
+	value, err := io.ReadAll(http.MaxBytesReader(w, r.Body, restMaxValue))
+	if err != nil {
+		writeJSON(w, http.StatusRequestEntityTooLarge, adminError{Error: err.Error()})
+		return
+	}
+	args = append(args, string(value))
+	if _, err := restDo(r)(args...); err != nil {
+		restError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// DELETE /kv/{key} deletes the key, 404 when it is missing
+func handleRESTDelete(w http.ResponseWriter, r *http.Request) {
+	do := restDo(r)
+	key := r.PathValue("key")
+	v, err := do("exists", key)
+	if err == nil && v == int64(0) {
+		writeJSON(w, http.StatusNotFound, adminError{Error: "not found"})
+		return
+	}
+	if err == nil {
+		_, err = do("del", key)
+	}
+	if err != nil {
+		restError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GET /kv?prefix=p&after=k&limit=n[&values=1] replies the keys starting
+// with the prefix after the key, in order, with their values on demand
+func handleRESTRange(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	prefix, after := q.Get("prefix"), q.Get("after")
+	limit := restDefaultLimit
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > restMaxLimit {
+			writeJSON(w, http.StatusBadRequest, adminError{Error: "invalid limit, expected 1 to " + strconv.Itoa(restMaxLimit)})
+			return
+		}
+		limit = n
+	}
+	do := restDo(r)
+	keys, next, err := scanPrefix(do, prefix, after, limit)
+	if err != nil {
+		restError(w, err)
+		return
+	}
+	res := restRange{Keys: keys, Next: next}
+	if q.Get("values") == "1" && len(keys) > 0 {
+		if res.Values, err = mgetValues(do, keys); err != nil {
+			restError(w, err)
+			return
+		}
+	}
+	writeJSON(w, http.StatusOK, res)
+}
+
+// scanPrefix returns up to limit keys starting with the prefix after a key,
+// and the key to continue from when there may be more. The scan stops at
+// the first key past the prefix.
+func scanPrefix(do runFunc, prefix, after string, limit int) (keys []string, next string, err error) {
+	cursor := after
+	if cursor < prefix && prefix != "" {
+		// the keys up to the prefix less its last byte do not have it
+		cursor = prefix[:len(prefix)-1]
+	}
+	keys = []string{}
+	for {
+		v, err := do("xscan", "kv", cursor, "count", strconv.Itoa(limit))
+		if err != nil {
+			return nil, "", err
+		}
+		page, _ := v.([]interface{})
+		if len(page) != 2 {
+			return nil, "", errors.New("ERR unexpected XSCAN reply")
+		}
+		batch, _ := page[1].([]interface{})
+		for _, k := range batch {
+			key := string(replyBytes(k))
+			if !strings.HasPrefix(key, prefix) {
+				if key > prefix {
+					return keys, "", nil
+				}
+				continue
+			}
+			keys = append(keys, key)
+			if len(keys) == limit {
+				return keys, key, nil
+			}
+		}
+		cursor = string(replyBytes(page[0]))
+		if cursor == "" || len(batch) == 0 {
+			return keys, "", nil
+		}
+	}
+}
+
+// mgetValues returns the values of the keys, nil for the keys missing
+func mgetValues(do runFunc, keys []string) (map[string]*string, error) {
+	v, err := do(append([]string{"mget"}, keys...)...)
+	if err != nil {
+		return nil, err
+	}
+	values, _ := v.([]interface{})
+	m := make(map[string]*string, len(keys))
+	for i, key := range keys {
+		m[key] = nil
+		if i < len(values) && values[i] != nil {
+			s := string(replyBytes(values[i]))
+			m[key] = &s
+		}
+	}
+	return m, nil
+}
+
+// POST /batch sets, deletes then gets the keys of the body, each step is a
+// single command
+func handleRESTBatch(w http.ResponseWriter, r *http.Request) {
+	var b restBatch
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, restMaxBatch)).Decode(&b); err != nil {
+		writeJSON(w, http.StatusBadRequest, adminError{Error: err.Error()})
+		return
+	}
+	do := restDo(r)
+	var res restBatchResult
+	if len(b.Set) > 0 {
+		args := []string{"mset"}
+		for k, v := range b.Set {
+			args = append(args, k, v)
+		}
+		if _, err := do(args...); err != nil {
+			restError(w, err)
+			return
+		}
+	}
+	if len(b.Delete) > 0 {
+		// DEL counts the keys it is given, the missing ones too
+		v, err := do(append([]string{"exists"}, b.Delete...)...)
+		if err == nil {
+			res.Deleted, _ = v.(int64)
+			_, err = do(append([]string{"del"}, b.Delete...)...)
+		}
+		if err != nil {
+			restError(w, err)
+			return
+		}
+	}
+	if len(b.Get) > 0 {
+		var err error
+		if res.Values, err = mgetValues(do, b.Get); err != nil {
+			restError(w, err)
+			return
+		}
+	}
+	writeJSON(w, http.StatusOK, res)
+}
+
+// serveREST serves the REST API on --rest-addr, over TLS when the server has
+// --tls-cert and --tls-key
+func serveREST(addr string) {
+	srv := &http.Server{Addr: addr, Handler: restHandler()}
+	ln, err := net.Listen("tcp", addr)
+	if err == nil {
+		loggers[logServer].Info("serving the REST API", zap.String("addr", ln.Addr().String()))
+		fln := &filteredListener{Listener: ln, name: listenerREST}
+		if conf.TLSCertPath != "" {
+			err = srv.ServeTLS(fln, conf.TLSCertPath, conf.TLSKeyPath)
+		} else {
+			err = srv.Serve(fln)
+		}
+	}
+	loggers[logServer].Error("REST listen fail", zap.Error(err))
+}
@@ -0,0 +1,113 @@
+//go:build alltest
+// +build alltest
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestREST(t *testing.T) {
+	c := getTestConn()
+	ctx := context.Background()
+	h := restHandler()
+	do := func(method, target, body, user string) *httptest.ResponseRecorder {
+		t.Helper()
+		r := httptest.NewRequest(method, target, strings.NewReader(body))
+		if user != "" {
+			name, password, _ := strings.Cut(user, ":")
+			r.SetBasicAuth(name, password)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	if w := do("PUT", "/kv/rest/a", "1", ""); w.Code != http.StatusNoContent {
+		t.Fatalf("PUT %d %s", w.Code, w.Body)
+	}
+	if w := do("GET", "/kv/rest/a", "", ""); w.Code != http.StatusOK || w.Body.String() != "1" {
+		t.Errorf("GET %d %s", w.Code, w.Body)
+	}
+	if w := do("GET", "/kv/rest/missing", "", ""); w.Code != http.StatusNotFound {
+		t.Errorf("GET of a missing key %d", w.Code)
+	}
+	if w := do("PUT", "/kv/rest/ttl?ttl=100", "x", ""); w.Code != http.StatusNoContent {
+		t.Errorf("PUT with a ttl %d %s", w.Code, w.Body)
+	}
+	if ttl, err := c.TTL(ctx, "rest/ttl").Result(); err != nil || ttl <= 0 {
+		t.Errorf("TTL %v %v", ttl, err)
+	}
+	if w := do("PUT", "/kv/rest/ttl?ttl=x", "x", ""); w.Code != http.StatusBadRequest {
+		t.Errorf("PUT with a bad ttl %d", w.Code)
+	}
+	if w := do("DELETE", "/kv/rest/ttl", "", ""); w.Code != http.StatusNoContent {
+		t.Errorf("DELETE %d %s", w.Code, w.Body)
+	}
+	if w := do("DELETE", "/kv/rest/ttl", "", ""); w.Code != http.StatusNotFound {
+		t.Errorf("DELETE of a missing key %d", w.Code)
+	}
+
+	// the range stops at the prefix and pages with next
+	c.MSet(ctx, "rest/b", "2", "rest/c", "3", "rest0", "x", "res", "x")
+	var page restRange
+	w := do("GET", "/kv?prefix=rest/&limit=2&values=1", "", "")
+	if err := json.NewDecoder(w.Body).Decode(&page); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("range %d %v", w.Code, err)
+	}
+	if strings.Join(page.Keys, ",") != "rest/a,rest/b" || page.Next != "rest/b" || *page.Values["rest/b"] != "2" {
+		t.Errorf("first page %+v", page)
+	}
+	next := page.Next
+	page = restRange{}
+	w = do("GET", "/kv?prefix=rest/&limit=2&after="+next, "", "")
+	if err := json.NewDecoder(w.Body).Decode(&page); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("range %d %v", w.Code, err)
+	}
+	if strings.Join(page.Keys, ",") != "rest/c" || page.Next != "" || page.Values != nil {
+		t.Errorf("last page %+v", page)
+	}
+	if w := do("GET", "/kv?limit=0", "", ""); w.Code != http.StatusBadRequest {
+		t.Errorf("range with a bad limit %d", w.Code)
+	}
+
+	var res restBatchResult
+	w = do("POST", "/batch", `{"set":{"rest/d":"4"},"delete":["rest/a","rest/missing"],"get":["rest/d","rest/a"]}`, "")
+	if err := json.NewDecoder(w.Body).Decode(&res); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("batch %d %v", w.Code, err)
+	}
+	if res.Deleted != 1 || *res.Values["rest/d"] != "4" || res.Values["rest/a"] != nil {
+		t.Errorf("batch %+v", res)
+	}
+	if w := do("POST", "/batch", `{"set":`, ""); w.Code != http.StatusBadRequest {
+		t.Errorf("a truncated batch %d", w.Code)
+	}
+
+	// the requests run as the user of their credentials
+	path := filepath.Join(t.TempDir(), "acl.json")
+	writeACL(t, path, "pw", nil, aclUserConfig{Name: "reader", Roles: []string{"readonly"}})
+	if err := acl.load(path); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		acl.mu.Lock()
+		acl.users, acl.roles, acl.providers, acl.failures = nil, nil, nil, make(map[string]*aclFailures)
+		acl.mu.Unlock()
+	}()
+	if w := do("GET", "/kv/rest/b", "", "reader:wrong"); w.Code != http.StatusUnauthorized {
+		t.Errorf("GET with a wrong password %d %s", w.Code, w.Body)
+	}
+	if w := do("GET", "/kv/rest/b", "", "reader:pw"); w.Code != http.StatusOK {
+		t.Errorf("GET of the reader %d %s", w.Code, w.Body)
+	}
+	if w := do("PUT", "/kv/rest/b", "5", "reader:pw"); w.Code != http.StatusForbidden {
+		t.Errorf("PUT of the reader %d %s", w.Code, w.Body)
+	}
+	c.Del(ctx, "rest/b", "rest/c", "rest/d", "rest0", "res")
+}
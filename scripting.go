@@ -0,0 +1,548 @@
+package main
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	luajson "github.com/alicebob/gopher-json"
+	"github.com/ledisdb/ledisdb/store"
+	"github.com/tidwall/redcon"
+	rafthub "github.com/tidwall/uhaha"
+	lua "github.com/yuin/gopher-lua"
+	"github.com/yuin/gopher-lua/parse"
+)
+
+// how long a read-only script runs before it is stopped. The scripts which
+// write are stopped by their instructions instead: a time would not stop
+// them at the same point on all of the nodes.
+const scriptReadTimeout = 5 * time.Second
+
+var (
+	// the Lua instructions a script runs before it is stopped, the same on
+	// every node
+	scriptMaxInstructions = 50_000_000
+	// the instructions counted for a command called by a script
+	scriptCallInstructions = 1000
+)
+
+// the scripts loaded, by their SHA1, are raw keys of the store outside of
+// the key space, so that they are in the snapshots
+var scriptPrefix = []byte("__icefiredb_script_")
+
+var (
+	errScriptNotFound = errors.New("NOSCRIPT No matching script. Please use EVAL.")
+	errScriptKeys     = errors.New("ERR Number of keys can't be greater than number of args")
+	errScriptNegative = errors.New("ERR Number of keys can't be negative")
+	errScriptBudget   = errors.New("ERR the script ran out of its instructions")
+)
+
+// the commands running scripts, whose writes are those of the commands they
+// call
+var scriptCommands = map[string]bool{
+	"eval": true, "evalsha": true, "eval_ro": true, "evalsha_ro": true,
+}
+
+// the commands a script may not call
+var scriptRefused = map[string]bool{
+	"eval": true, "evalsha": true, "eval_ro": true, "evalsha_ro": true, "script": true,
+}
+
+// the scripts compiled by the node, by SHA1
+var scripts = &scriptCache{protos: make(map[string]*lua.FunctionProto)}
+
+func init() {
+	conf.AddWriteCommand("EVAL", cmdEVAL)
+	conf.AddWriteCommand("EVALSHA", cmdEVALSHA)
+	conf.AddReadCommand("EVAL_RO", cmdEVALRO)
+	conf.AddReadCommand("EVALSHA_RO", cmdEVALSHARO)
+	conf.AddWriteCommand("SCRIPT", cmdSCRIPT)
+}
+
+type scriptCache struct {
+	mu     sync.Mutex
+	protos map[string]*lua.FunctionProto
+}
+
+func scriptSHA(body string) string {
+	sum := sha1.Sum([]byte(body))
+	return hex.EncodeToString(sum[:])
+}
+
+func scriptKey(sha string) []byte {
+	return append(append([]byte(nil), scriptPrefix...), strings.ToLower(sha)...)
+}
+
+// compile returns the function of a script, compiled once by the node
+func (c *scriptCache) compile(sha, body string) (*lua.FunctionProto, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if proto, ok := c.protos[sha]; ok {
+		return proto, nil
+	}
+	chunk, err := parse.Parse(strings.NewReader(body), "user_script")
+	if err != nil {
+		return nil, fmt.Errorf("ERR Error compiling script (new function): %s", strings.TrimSpace(err.Error()))
+	}
+	proto, err := lua.Compile(chunk, "user_script")
+	if err != nil {
+		return nil, fmt.Errorf("ERR Error compiling script (new function): %v", err)
+	}
+	c.protos[sha] = proto
+	return proto, nil
+}
+
+// load returns the function of a script loaded, nil when it is not
+func (c *scriptCache) load(sha string) (*lua.FunctionProto, error) {
+	sha = strings.ToLower(sha)
+	body, err := ldb.GetSDB().Get(scriptKey(sha))
+	if err != nil || body == nil {
+		return nil, err
+	}
+	return c.compile(sha, string(body))
+}
+
+// store compiles a script and keeps it for EVALSHA
+func (c *scriptCache) store(body string) (string, *lua.FunctionProto, error) {
+	sha := scriptSHA(body)
+	proto, err := c.compile(sha, body)
+	if err != nil {
+		return "", nil, err
+	}
+	sdb := ldb.GetSDB()
+	if v, err := sdb.Get(scriptKey(sha)); err != nil {
+		return "", nil, err
+	} else if v == nil {
+		if err := sdb.Put(scriptKey(sha), []byte(body)); err != nil {
+			return "", nil, err
+		}
+	}
+	return sha, proto, nil
+}
+
+// flush deletes the scripts loaded
+func (c *scriptCache) flush() error {
+	sdb := ldb.GetSDB()
+	it := sdb.RangeLimitIterator(scriptPrefix, streamPrefixEnd(scriptPrefix), store.RangeClose, 0, -1)
+	defer it.Close()
+	wb := sdb.NewWriteBatch()
+	defer wb.Close()
+	for ; it.Valid(); it.Next() {
+		wb.Delete(it.Key())
+	}
+	if err := wb.Commit(); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	c.protos = make(map[string]*lua.FunctionProto)
+	c.mu.Unlock()
+	return nil
+}
+
+// scriptKeys splits the arguments of EVAL script numkeys key... arg...
+func scriptKeys(args []string) (keys, argv []string, err error) {
+	if len(args) < 3 {
+		return nil, nil, rafthub.ErrWrongNumArgs
+	}
+	n, err := strconv.Atoi(args[2])
+	if err != nil {
+		return nil, nil, rafthub.ErrInvalid
+	}
+	if n < 0 {
+		return nil, nil, errScriptNegative
+	}
+	if n > len(args)-3 {
+		return nil, nil, errScriptKeys
+	}
+	return args[3 : 3+n], args[3+n:], nil
+}
+
+// EVAL script numkeys key... arg...
+func cmdEVAL(m rafthub.Machine, args []string) (interface{}, error) {
+	return evalScript(m, args, false, false)
+}
+
+// EVALSHA sha1 numkeys key... arg...
+func cmdEVALSHA(m rafthub.Machine, args []string) (interface{}, error) {
+	return evalScript(m, args, true, false)
+}
+
+// EVAL_RO script numkeys key... arg...
+func cmdEVALRO(m rafthub.Machine, args []string) (interface{}, error) {
+	return evalScript(m, args, false, true)
+}
+
+// EVALSHA_RO sha1 numkeys key... arg...
+func cmdEVALSHARO(m rafthub.Machine, args []string) (interface{}, error) {
+	return evalScript(m, args, true, true)
+}
+
+func evalScript(m rafthub.Machine, args []string, bySHA, readOnly bool) (interface{}, error) {
+	keys, argv, err := scriptKeys(args)
+	if err != nil {
+		return nil, err
+	}
+	var proto *lua.FunctionProto
+	switch {
+	case bySHA:
+		if proto, err = scripts.load(args[1]); err == nil && proto == nil {
+			err = errScriptNotFound
+		}
+	case readOnly:
+		// a read keeps the script compiled, not loaded
+		proto, err = scripts.compile(scriptSHA(args[1]), args[1])
+	default:
+		_, proto, err = scripts.store(args[1])
+	}
+	if err != nil {
+		return nil, err
+	}
+	run := &scriptRun{m: m, keys: make(map[string]bool, len(keys)), readOnly: readOnly}
+	for _, key := range keys {
+		run.keys[key] = true
+	}
+	return run.exec(proto, keys, argv)
+}
+
+// scriptRun is a script running with the commands of the machine
+type scriptRun struct {
+	m rafthub.Machine
+	// the keys declared, the only ones the script accesses
+	keys     map[string]bool
+	readOnly bool
+	budget   *scriptBudget
+}
+
+// scriptBudget is the context of a script counting its instructions: the
+// Lua VM checks the context once per instruction, and the script stops
+// once it has run scriptMaxInstructions
+type scriptBudget struct {
+	context.Context
+	left int
+}
+
+var scriptBudgetDone = func() chan struct{} {
+	done := make(chan struct{})
+	close(done)
+	return done
+}()
+
+func (b *scriptBudget) Done() <-chan struct{} {
+	if b.left--; b.left < 0 {
+		return scriptBudgetDone
+	}
+	return b.Context.Done()
+}
+
+func (b *scriptBudget) Err() error {
+	if b.left < 0 {
+		return errScriptBudget
+	}
+	return b.Context.Err()
+}
+
+// charge counts the instructions of a command called by the script
+func (b *scriptBudget) charge(n int) error {
+	if b.left -= n; b.left < 0 {
+		return errScriptBudget
+	}
+	return nil
+}
+
+// exec runs a script in a new Lua state with the libraries of Redis: the
+// base, table, string and math libraries, cjson and redis. math.random
+// starts from the same seed on every node.
+func (r *scriptRun) exec(proto *lua.FunctionProto, keys, argv []string) (interface{}, error) {
+	L := lua.NewState(lua.Options{SkipOpenLibs: true})
+	defer L.Close()
+	for _, lib := range []struct {
+		name string
+		fn   lua.LGFunction
+	}{
+		{lua.BaseLibName, lua.OpenBase},
+		{lua.TabLibName, lua.OpenTable},
+		{lua.StringLibName, lua.OpenString},
+		{lua.MathLibName, lua.OpenMath},
+	} {
+		L.Push(L.NewFunction(lib.fn))
+		L.Push(lua.LString(lib.name))
+		L.Call(1, 0)
+	}
+	// no file nor output
+	for _, name := range []string{"dofile", "loadfile", "print", "_printregs"} {
+		L.SetGlobal(name, lua.LNil)
+	}
+	rnd := rand.New(rand.NewSource(0))
+	math := L.GetGlobal("math").(*lua.LTable)
+	L.SetField(math, "random", L.NewFunction(func(L *lua.LState) int {
+		switch L.GetTop() {
+		case 0:
+			L.Push(lua.LNumber(rnd.Float64()))
+		case 1:
+			n := L.CheckInt(1)
+			if n < 1 {
+				L.ArgError(1, "interval is empty")
+			}
+			L.Push(lua.LNumber(rnd.Intn(n) + 1))
+		default:
+			lo, hi := L.CheckInt(1), L.CheckInt(2)
+			if lo > hi {
+				L.ArgError(2, "interval is empty")
+			}
+			L.Push(lua.LNumber(lo + rnd.Intn(hi-lo+1)))
+		}
+		return 1
+	}))
+	L.SetField(math, "randomseed", L.NewFunction(func(L *lua.LState) int {
+		rnd.Seed(L.CheckInt64(1))
+		return 0
+	}))
+	L.Push(L.NewFunction(luajson.Loader))
+	L.Call(0, 1)
+	L.SetGlobal("cjson", L.Get(-1))
+	L.Pop(1)
+	L.SetGlobal("redis", L.SetFuncs(L.NewTable(), map[string]lua.LGFunction{
+		"call":  func(L *lua.LState) int { return r.call(L, false) },
+		"pcall": func(L *lua.LState) int { return r.call(L, true) },
+		"error_reply": func(L *lua.LState) int {
+			L.Push(replyTable(L, "err", L.CheckString(1)))
+			return 1
+		},
+		"status_reply": func(L *lua.LState) int {
+			L.Push(replyTable(L, "ok", L.CheckString(1)))
+			return 1
+		},
+		"sha1hex": func(L *lua.LState) int {
+			L.Push(lua.LString(scriptSHA(L.CheckString(1))))
+			return 1
+		},
+	}))
+	L.SetGlobal("KEYS", stringsTable(L, keys))
+	L.SetGlobal("ARGV", stringsTable(L, argv))
+	ctx := context.Background()
+	if r.readOnly {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, scriptReadTimeout)
+		defer cancel()
+	}
+	r.budget = &scriptBudget{Context: ctx, left: scriptMaxInstructions}
+	L.SetContext(r.budget)
+
+	L.Push(L.NewFunctionFromProto(proto))
+	if err := L.PCall(0, 1, nil); err != nil {
+		if r.budget.left < 0 {
+			return nil, errScriptBudget
+		}
+		if aerr, ok := err.(*lua.ApiError); ok {
+			if t, ok := aerr.Object.(*lua.LTable); ok {
+				if msg, ok := t.RawGetString("err").(lua.LString); ok {
+					return nil, errors.New(string(msg))
+				}
+			}
+			return nil, fmt.Errorf("ERR %s", aerr.Object.String())
+		}
+		return nil, fmt.Errorf("ERR %v", err)
+	}
+	return luaReply(L.Get(-1)), nil
+}
+
+// call is redis.call and redis.pcall: the errors of redis.pcall are
+// returned as a table {err=...}, those of redis.call are raised
+func (r *scriptRun) call(L *lua.LState, protected bool) int {
+	n := L.GetTop()
+	if n == 0 {
+		L.Error(replyTable(L, "err", "ERR Please specify at least one argument for this redis lib call"), 1)
+		return 0
+	}
+	args := make([]string, n)
+	for i := 1; i <= n; i++ {
+		switch v := L.Get(i).(type) {
+		case lua.LString, lua.LNumber:
+			args[i-1] = v.String()
+		default:
+			L.Error(replyTable(L, "err", "ERR Lua redis lib command arguments must be strings or integers"), 1)
+			return 0
+		}
+	}
+	if err := r.budget.charge(scriptCallInstructions); err != nil {
+		L.Error(luaValue(L, err), 1)
+		return 0
+	}
+	v, err := r.do(args)
+	if err != nil {
+		// the error as the clients get it, {err=...}
+		if protected {
+			L.Push(luaValue(L, err))
+			return 1
+		}
+		L.Error(luaValue(L, err), 1)
+		return 0
+	}
+	L.Push(luaValue(L, v))
+	return 1
+}
+
+// do runs a command of a script on the machine
+func (r *scriptRun) do(args []string) (interface{}, error) {
+	cmd := strings.ToLower(args[0])
+	if scriptRefused[cmd] || allKeysCommands[cmd] {
+		return nil, errors.New("ERR This Redis command is not allowed from script")
+	}
+	fn := readCommands[cmd]
+	if fn == nil {
+		if writeCommands[cmd] != nil && r.readOnly {
+			return nil, errors.New("ERR Write commands are not allowed from read-only scripts")
+		}
+		fn = writeCommands[cmd]
+	}
+	if fn == nil {
+		return nil, errors.New("ERR Unknown Redis command called from script")
+	}
+	args[0] = cmd
+	keys, _ := commandKeys(cmd, args)
+	for _, key := range keys {
+		if !r.keys[key] {
+			return nil, fmt.Errorf("ERR the script accessed the key '%s' which is not in KEYS", key)
+		}
+	}
+	return fn(r.m, args)
+}
+
+func stringsTable(L *lua.LState, vs []string) *lua.LTable {
+	t := L.CreateTable(len(vs), 0)
+	for _, v := range vs {
+		t.Append(lua.LString(v))
+	}
+	return t
+}
+
+func replyTable(L *lua.LState, field, msg string) *lua.LTable {
+	t := L.NewTable()
+	t.RawSetString(field, lua.LString(msg))
+	return t
+}
+
+// luaValue converts the reply of a command as Redis does: the integers are
+// numbers, the nulls false, the status replies {ok=...} and the errors
+// {err=...}
+func luaValue(L *lua.LState, v interface{}) lua.LValue {
+	_, resp := redcon.ReadNextRESP(redcon.AppendAny(nil, v))
+	return respValue(L, resp)
+}
+
+func respValue(L *lua.LState, resp redcon.RESP) lua.LValue {
+	switch resp.Type {
+	case redcon.Integer:
+		n, _ := strconv.ParseInt(string(resp.Data), 10, 64)
+		return lua.LNumber(n)
+	case redcon.String:
+		return replyTable(L, "ok", string(resp.Data))
+	case redcon.Error:
+		return replyTable(L, "err", string(resp.Data))
+	case redcon.Bulk:
+		if resp.Data == nil {
+			return lua.LFalse
+		}
+		return lua.LString(resp.Data)
+	case redcon.Array:
+		if resp.Count < 0 {
+			return lua.LFalse
+		}
+		t := L.CreateTable(resp.Count, 0)
+		resp.ForEach(func(e redcon.RESP) bool {
+			t.Append(respValue(L, e))
+			return true
+		})
+		return t
+	}
+	return lua.LFalse
+}
+
+// luaReply converts the value returned by a script as Redis does: the
+// numbers are integers, true is 1, false and nil are null, and a table is
+// an array up to its first nil, unless it is {ok=...} or {err=...}
+func luaReply(v lua.LValue) interface{} {
+	switch v := v.(type) {
+	case lua.LNumber:
+		return redcon.SimpleInt(int64(v))
+	case lua.LString:
+		return string(v)
+	case lua.LBool:
+		if v {
+			return redcon.SimpleInt(1)
+		}
+		return nil
+	case *lua.LTable:
+		if msg, ok := v.RawGetString("err").(lua.LString); ok {
+			return errors.New(string(msg))
+		}
+		if msg, ok := v.RawGetString("ok").(lua.LString); ok {
+			return redcon.SimpleString(msg)
+		}
+		var out []interface{}
+		for i := 1; ; i++ {
+			e := v.RawGetInt(i)
+			if e == lua.LNil {
+				break
+			}
+			out = append(out, luaReply(e))
+		}
+		if out == nil {
+			out = []interface{}{}
+		}
+		return out
+	}
+	return nil
+}
+
+// SCRIPT LOAD script
+// SCRIPT EXISTS sha1...
+// SCRIPT FLUSH [ASYNC|SYNC]
+func cmdSCRIPT(_ rafthub.Machine, args []string) (interface{}, error) {
+	if len(args) < 2 {
+		return nil, rafthub.ErrWrongNumArgs
+	}
+	switch strings.ToLower(args[1]) {
+	case "load":
+		if len(args) != 3 {
+			return nil, rafthub.ErrWrongNumArgs
+		}
+		sha, _, err := scripts.store(args[2])
+		if err != nil {
+			return nil, err
+		}
+		return sha, nil
+	case "exists":
+		if len(args) < 3 {
+			return nil, rafthub.ErrWrongNumArgs
+		}
+		out := make([]interface{}, len(args)-2)
+		for i, sha := range args[2:] {
+			v, err := ldb.GetSDB().Get(scriptKey(sha))
+			if err != nil {
+				return nil, err
+			}
+			out[i] = redcon.SimpleInt(0)
+			if v != nil {
+				out[i] = redcon.SimpleInt(1)
+			}
+		}
+		return out, nil
+	case "flush":
+		if len(args) > 3 || (len(args) == 3 && !strings.EqualFold(args[2], "async") && !strings.EqualFold(args[2], "sync")) {
+			return nil, rafthub.ErrSyntax
+		}
+		if err := scripts.flush(); err != nil {
+			return nil, err
+		}
+		return redcon.SimpleString("OK"), nil
+	}
+	return nil, fmt.Errorf("ERR unknown subcommand '%s'", args[1])
+}
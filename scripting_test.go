@@ -0,0 +1,110 @@
+//go:build alltest
+// +build alltest
+
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/redis/go-redis/v9"
+)
+
+func TestScriptKeys(t *testing.T) {
+	for _, tc := range []struct {
+		args       []string
+		keys, argv int
+		err        bool
+	}{
+		{[]string{"eval", "return 1", "0"}, 0, 0, false},
+		{[]string{"eval", "return 1", "2", "a", "b", "c"}, 2, 1, false},
+		{[]string{"eval", "return 1", "2", "a"}, 0, 0, true},
+		{[]string{"eval", "return 1", "-1"}, 0, 0, true},
+		{[]string{"eval", "return 1", "x"}, 0, 0, true},
+		{[]string{"eval", "return 1"}, 0, 0, true},
+	} {
+		keys, argv, err := scriptKeys(tc.args)
+		if len(keys) != tc.keys || len(argv) != tc.argv || (err != nil) != tc.err {
+			t.Errorf("%q: %q %q %v", tc.args, keys, argv, err)
+		}
+	}
+}
+
+func TestScripting(t *testing.T) {
+	c := getTestConn()
+	ctx := context.Background()
+	defer c.Del(ctx, "lua:a", "lua:n")
+	defer c.ScriptFlush(ctx)
+
+	if v, err := c.Eval(ctx, "redis.call('set', KEYS[1], ARGV[1]) return redis.call('get', KEYS[1])", []string{"lua:a"}, "v").Result(); err != nil || v != "v" {
+		t.Errorf("EVAL %v %v", v, err)
+	}
+	if v, err := c.Eval(ctx, "return {redis.call('incr', KEYS[1]), redis.call('get', KEYS[2]) == false, 'x', 1.5}", []string{"lua:n", "lua:none"}).Result(); err != nil ||
+		len(v.([]interface{})) != 4 || v.([]interface{})[0] != int64(1) || v.([]interface{})[1] != int64(1) || v.([]interface{})[3] != int64(1) {
+		t.Errorf("EVAL conversions %v %v", v, err)
+	}
+	if v, err := c.Eval(ctx, "return redis.status_reply('FINE')", nil).Result(); err != nil || v != "FINE" {
+		t.Errorf("status reply %v %v", v, err)
+	}
+	if err := c.Eval(ctx, "return redis.call('get', 'lua:other')", []string{"lua:a"}).Err(); err == nil || !strings.Contains(err.Error(), "not in KEYS") {
+		t.Errorf("a key not declared %v", err)
+	}
+	if err := c.Eval(ctx, "return redis.call('incr', KEYS[1])", []string{"lua:a"}).Err(); err == nil {
+		t.Error("the error of a command must be raised")
+	}
+	if v, err := c.Eval(ctx, "return redis.pcall('incr', KEYS[1])['err'] ~= nil", []string{"lua:a"}).Result(); err != nil || v != int64(1) {
+		t.Errorf("redis.pcall %v %v", v, err)
+	}
+	if err := c.Eval(ctx, "return redis.call('flushall')", nil).Err(); err == nil {
+		t.Error("FLUSHALL from a script must fail")
+	}
+	if err := c.Eval(ctx, "return 1 +", nil).Err(); err == nil || !strings.Contains(err.Error(), "compiling") {
+		t.Errorf("a syntax error %v", err)
+	}
+	if err := c.EvalRO(ctx, "return redis.call('set', KEYS[1], 'x')", []string{"lua:a"}).Err(); err == nil {
+		t.Error("EVAL_RO must not write")
+	}
+	if v, err := c.EvalRO(ctx, "return redis.call('get', KEYS[1])", []string{"lua:a"}).Result(); err != nil || v != "v" {
+		t.Errorf("EVAL_RO %v %v", v, err)
+	}
+
+	// the scripts stop after the same instructions on every node
+	defer func(n int) { scriptMaxInstructions = n }(scriptMaxInstructions)
+	scriptMaxInstructions = 100000
+	for _, body := range []string{
+		"while true do end",
+		"while true do pcall(function() while true do end end) end",
+		"for i = 1, 1000 do redis.pcall('incr', KEYS[1]) end",
+	} {
+		if err := c.Eval(ctx, body, []string{"lua:n"}).Err(); err == nil || err.Error() != errScriptBudget.Error() {
+			t.Errorf("%s: %v", body, err)
+		}
+	}
+	if v, err := c.Get(ctx, "lua:n").Int(); err != nil || v < 90 || v > 101 {
+		t.Errorf("the commands before the end of the instructions %v %v", v, err)
+	}
+
+	sha, err := c.ScriptLoad(ctx, "return ARGV[1] .. cjson.encode({a=1})").Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, err := c.EvalSha(ctx, sha, nil, "x").Result(); err != nil || v != `x{"a":1}` {
+		t.Errorf("EVALSHA %v %v", v, err)
+	}
+	if v, err := c.ScriptExists(ctx, sha, strings.Repeat("0", 40)).Result(); err != nil || !v[0] || v[1] {
+		t.Errorf("SCRIPT EXISTS %v %v", v, err)
+	}
+	c.ScriptFlush(ctx)
+	if err := c.EvalSha(ctx, sha, nil, "x").Err(); err == nil || !strings.HasPrefix(err.Error(), "NOSCRIPT") {
+		t.Errorf("EVALSHA after SCRIPT FLUSH %v", err)
+	}
+	// a script runs by its SHA1 once it ran with EVAL
+	script := redis.NewScript("return tonumber(ARGV[1]) * 2")
+	if v, err := script.Run(ctx, c, nil, 21).Result(); err != nil || v != int64(42) {
+		t.Errorf("script %v %v", v, err)
+	}
+	if err := c.EvalSha(ctx, script.Hash(), nil, 1).Err(); err != nil {
+		t.Errorf("EVALSHA of a script run by EVAL %v", err)
+	}
+}
@@ -0,0 +1,417 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/IceFireDB/IceFireDB/driver/oss"
+	"go.uber.org/zap"
+)
+
+var (
+	// the Vault server, VAULT_ADDR by default
+	vaultAddr string
+	// the file of the Vault token, VAULT_TOKEN when empty
+	vaultTokenFile string
+	// the CA of the Vault server, the system roots when empty
+	vaultCACert string
+	// the Vault namespace, Vault Enterprise only
+	vaultNamespace string
+	// how often the secrets written to files are read again, to pick up
+	// their rotation
+	secretRefresh = 5 * time.Minute
+)
+
+// how long a secret backend request may take
+const secretTimeout = 10 * time.Second
+
+// secret is a secret read from a backend: its fields, and the lease that
+// keeps it valid when it has one
+type secret struct {
+	data      map[string]string
+	leaseID   string
+	ttl       time.Duration
+	renewable bool
+}
+
+// secretBackend is a store of secrets, a reference scheme:path#field reads
+// the field of the secret at path from the backend of the scheme
+type secretBackend interface {
+	read(ctx context.Context, path string) (*secret, error)
+	// renew extends the lease of a secret, and returns its new ttl
+	renew(ctx context.Context, leaseID string, increment time.Duration) (time.Duration, error)
+}
+
+// the secret backends by reference scheme, vault is set up by the flags
+var secretBackends = map[string]secretBackend{
+	"env": envBackend{},
+}
+
+// the renewals of the leases and the token, started with the logging
+var secretKeepers []func(context.Context)
+
+// envBackend reads the secrets from the environment, env:NAME is the
+// variable NAME
+type envBackend struct{}
+
+func (envBackend) read(_ context.Context, name string) (*secret, error) {
+	v, ok := os.LookupEnv(name)
+	if !ok {
+		return nil, fmt.Errorf("no environment variable %s", name)
+	}
+	return &secret{data: map[string]string{"": v}}, nil
+}
+
+func (envBackend) renew(context.Context, string, time.Duration) (time.Duration, error) {
+	return 0, errors.New("the environment has no lease")
+}
+
+// secretRef is a reference to a secret: scheme:path#field
+type secretRef struct {
+	scheme, path, field string
+}
+
+// parseSecretRef parses a reference, false for a value that is not one
+func parseSecretRef(s string) (secretRef, bool) {
+	scheme, rest, ok := strings.Cut(s, ":")
+	if !ok || secretBackends[scheme] == nil {
+		return secretRef{}, false
+	}
+	path, field, _ := strings.Cut(rest, "#")
+	return secretRef{scheme: scheme, path: path, field: field}, true
+}
+
+func (r secretRef) String() string {
+	if r.field == "" {
+		return r.scheme + ":" + r.path
+	}
+	return r.scheme + ":" + r.path + "#" + r.field
+}
+
+// fetch reads the value of a reference, with the lease of its secret
+func (r secretRef) fetch() (string, *secret, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), secretTimeout)
+	defer cancel()
+	s, err := secretBackends[r.scheme].read(ctx, r.path)
+	if err != nil {
+		return "", nil, fmt.Errorf("%s: %w", r, err)
+	}
+	field := r.field
+	if field == "" && len(s.data) == 1 {
+		for k := range s.data {
+			field = k
+		}
+	}
+	v, ok := s.data[field]
+	if !ok {
+		return "", nil, fmt.Errorf("%s: no field '%s'", r, field)
+	}
+	return v, s, nil
+}
+
+// resolveSecret replaces a reference by its value, the other values are
+// kept
+func resolveSecret(v *string) error {
+	ref, ok := parseSecretRef(*v)
+	if !ok {
+		return nil
+	}
+	value, s, err := ref.fetch()
+	if err != nil {
+		return err
+	}
+	*v = value
+	if s.leaseID != "" {
+		secretKeepers = append(secretKeepers, func(ctx context.Context) { keepLease(ctx, ref, s, nil) })
+	}
+	return nil
+}
+
+// resolveSecretFile replaces a reference by a file of the data directory
+// holding its value, the file is written again as the secret rotates so
+// that the certificate reloaders pick it up
+func resolveSecretFile(path *string) error {
+	ref, ok := parseSecretRef(*path)
+	if !ok {
+		return nil
+	}
+	value, s, err := ref.fetch()
+	if err != nil {
+		return err
+	}
+	dir := filepath.Join(conf.DataDir, "secrets")
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return err
+	}
+	sum := sha256.Sum256([]byte(ref.String()))
+	file := filepath.Join(dir, hex.EncodeToString(sum[:8]))
+	if err := writeSecretFile(file, value); err != nil {
+		return err
+	}
+	*path = file
+	secretKeepers = append(secretKeepers, func(ctx context.Context) {
+		keepLease(ctx, ref, s, func(value string) {
+			if err := writeSecretFile(file, value); err != nil {
+				loggers[logServer].Error("secret file write failed", zap.String("secret", ref.String()), zap.Error(err))
+			}
+		})
+	})
+	return nil
+}
+
+// writeSecretFile replaces the content of a secret file, readable by the
+// process user only
+func writeSecretFile(file, value string) error {
+	if old, err := os.ReadFile(file); err == nil && string(old) == value {
+		return nil
+	}
+	tmp := file + ".tmp"
+	if err := os.WriteFile(tmp, []byte(value), 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, file)
+}
+
+// keepLease renews the lease of a secret at two thirds of its ttl. With
+// update, a secret is read again when its lease cannot be renewed any more,
+// or every --secret-refresh without lease, and its new values passed on,
+// until ctx is done.
+func keepLease(ctx context.Context, ref secretRef, s *secret, update func(string)) {
+	for {
+		leased := s.leaseID != "" && s.renewable && s.ttl > 0
+		if !leased && update == nil {
+			return
+		}
+		wait := secretRefresh
+		if leased {
+			wait = s.ttl * 2 / 3
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+		if leased {
+			rctx, cancel := context.WithTimeout(ctx, secretTimeout)
+			ttl, err := secretBackends[ref.scheme].renew(rctx, s.leaseID, s.ttl)
+			cancel()
+			if err != nil {
+				loggers[logServer].Warn("secret lease renewal failed", zap.String("secret", ref.String()), zap.Error(err))
+			}
+			// a lease at its max ttl is renewed for less
+			if err == nil && ttl >= s.ttl {
+				continue
+			}
+			if err == nil && ttl > 0 {
+				s.ttl = ttl
+			}
+		}
+		if update == nil {
+			continue
+		}
+		value, ns, err := ref.fetch()
+		if err != nil {
+			loggers[logServer].Error("secret read failed", zap.String("secret", ref.String()), zap.Error(err))
+			continue
+		}
+		s = ns
+		update(value)
+	}
+}
+
+// vaultBackend reads the secrets of a Vault server over its HTTP API, of
+// the KV engines and of the dynamic engines. Its token is renewed while it
+// is renewable.
+type vaultBackend struct {
+	addr, namespace string
+	client          *http.Client
+	token           string
+}
+
+func newVaultBackend(addr, tokenFile, caFile, namespace string) (*vaultBackend, error) {
+	v := &vaultBackend{addr: strings.TrimRight(addr, "/"), namespace: namespace, client: &http.Client{Timeout: secretTimeout}}
+	v.token = os.Getenv("VAULT_TOKEN")
+	if tokenFile != "" {
+		token, err := readSecret(tokenFile)
+		if err != nil {
+			return nil, err
+		}
+		v.token = token
+	}
+	if v.token == "" {
+		return nil, errors.New("no Vault token, set VAULT_TOKEN or --vault-token-file")
+	}
+	if caFile != "" {
+		data, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("no certificate found in %s", caFile)
+		}
+		v.client.Transport = &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}}
+	}
+	return v, nil
+}
+
+// vaultResponse is the part of the responses of Vault the backend reads
+type vaultResponse struct {
+	Data          map[string]interface{} `json:"data"`
+	LeaseID       string                 `json:"lease_id"`
+	LeaseDuration int                    `json:"lease_duration"`
+	Renewable     bool                   `json:"renewable"`
+	Auth          *struct {
+		LeaseDuration int  `json:"lease_duration"`
+		Renewable     bool `json:"renewable"`
+	} `json:"auth"`
+	Errors []string `json:"errors"`
+}
+
+func (v *vaultBackend) do(ctx context.Context, method, path string, body interface{}) (*vaultResponse, error) {
+	var rd io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		rd = strings.NewReader(string(data))
+	}
+	req, err := http.NewRequestWithContext(ctx, method, v.addr+"/v1/"+strings.TrimLeft(path, "/"), rd)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Vault-Token", v.token)
+	if v.namespace != "" {
+		req.Header.Set("X-Vault-Namespace", v.namespace)
+	}
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var vr vaultResponse
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&vr); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("vault: %s: %w", resp.Status, err)
+	}
+	if resp.StatusCode/100 != 2 {
+		return nil, fmt.Errorf("vault: %s: %s", resp.Status, strings.Join(vr.Errors, ", "))
+	}
+	return &vr, nil
+}
+
+func (v *vaultBackend) read(ctx context.Context, path string) (*secret, error) {
+	vr, err := v.do(ctx, http.MethodGet, path, nil)
+	if err != nil {
+		return nil, err
+	}
+	data := vr.Data
+	// the KV version 2 engine nests the secret with its metadata
+	if inner, ok := data["data"].(map[string]interface{}); ok {
+		if _, ok := data["metadata"]; ok {
+			data = inner
+		}
+	}
+	s := &secret{data: make(map[string]string, len(data)), leaseID: vr.LeaseID,
+		ttl: time.Duration(vr.LeaseDuration) * time.Second, renewable: vr.Renewable}
+	for k, val := range data {
+		switch val := val.(type) {
+		case string:
+			s.data[k] = val
+		case nil:
+		default:
+			b, _ := json.Marshal(val)
+			s.data[k] = string(b)
+		}
+	}
+	return s, nil
+}
+
+func (v *vaultBackend) renew(ctx context.Context, leaseID string, increment time.Duration) (time.Duration, error) {
+	vr, err := v.do(ctx, http.MethodPut, "sys/leases/renew",
+		map[string]interface{}{"lease_id": leaseID, "increment": int(increment.Seconds())})
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(vr.LeaseDuration) * time.Second, nil
+}
+
+// keepToken renews the token of the backend at two thirds of its ttl, for
+// as long as it is renewable and ctx is not done
+func (v *vaultBackend) keepToken(ctx context.Context) {
+	rctx, cancel := context.WithTimeout(ctx, secretTimeout)
+	vr, err := v.do(rctx, http.MethodGet, "auth/token/lookup-self", nil)
+	cancel()
+	if err != nil {
+		loggers[logServer].Warn("vault token lookup failed", zap.Error(err))
+		return
+	}
+	ttl, _ := vr.Data["ttl"].(float64)
+	renewable, _ := vr.Data["renewable"].(bool)
+	for renewable && ttl > 0 {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Duration(ttl) * time.Second * 2 / 3):
+		}
+		rctx, cancel := context.WithTimeout(ctx, secretTimeout)
+		vr, err := v.do(rctx, http.MethodPost, "auth/token/renew-self", map[string]interface{}{})
+		cancel()
+		if err != nil || vr.Auth == nil {
+			loggers[logServer].Error("vault token renewal failed", zap.Error(err))
+			// try again before the token expires
+			ttl /= 3
+			continue
+		}
+		ttl, renewable = float64(vr.Auth.LeaseDuration), vr.Auth.Renewable
+	}
+}
+
+// resolveSecrets sets up Vault, and replaces the secret references of the
+// flags by their values
+func resolveSecrets() error {
+	if vaultAddr == "" {
+		vaultAddr = os.Getenv("VAULT_ADDR")
+	}
+	if vaultAddr != "" {
+		v, err := newVaultBackend(vaultAddr, vaultTokenFile, vaultCACert, vaultNamespace)
+		if err != nil {
+			return err
+		}
+		secretBackends["vault"] = v
+		secretKeepers = append(secretKeepers, v.keepToken)
+	}
+	for _, v := range []*string{&conf.Auth, &adminToken, &webhookSecret, &joinSecret, &joinToken, &namespaceKeys,
+		&remoteNamespaces, &oss.OssDefaultConfig.AccessKey, &oss.OssDefaultConfig.Secretkey} {
+		if err := resolveSecret(v); err != nil {
+			return err
+		}
+	}
+	for _, path := range []*string{&conf.TLSCertPath, &conf.TLSKeyPath, &tlsPortCert, &tlsPortKey,
+		&tlsCACert, &clusterCACert} {
+		if err := resolveSecretFile(path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// keepSecrets starts renewing the leases of the secrets and the token,
+// until ctx is done
+func keepSecrets(ctx context.Context) {
+	for _, keep := range secretKeepers {
+		go keep(ctx)
+	}
+}
@@ -0,0 +1,142 @@
+//go:build alltest
+// +build alltest
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeVault serves a KV v2 secret, a KV v1 secret and a leased secret with
+// the token s.Token
+type fakeVault struct {
+	mu      sync.Mutex
+	key     string
+	renewed int64
+}
+
+func (v *fakeVault) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("X-Vault-Token") != "s.Token" {
+		w.WriteHeader(http.StatusForbidden)
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"errors": []string{"permission denied"}})
+		return
+	}
+	var resp map[string]interface{}
+	switch r.URL.Path {
+	case "/v1/secret/data/icefiredb":
+		v.mu.Lock()
+		resp = map[string]interface{}{"data": map[string]interface{}{
+			"data":     map[string]interface{}{"auth": "pw-from-vault", "key": v.key},
+			"metadata": map[string]interface{}{"version": 3},
+		}}
+		v.mu.Unlock()
+	case "/v1/kv/admin":
+		resp = map[string]interface{}{"data": map[string]interface{}{"token": "admin-from-vault"}}
+	case "/v1/database/creds/icefiredb":
+		resp = map[string]interface{}{"lease_id": "database/creds/icefiredb/abc", "lease_duration": 1, "renewable": true,
+			"data": map[string]interface{}{"username": "u", "password": "p"}}
+	case "/v1/sys/leases/renew":
+		atomic.AddInt64(&v.renewed, 1)
+		resp = map[string]interface{}{"lease_id": "database/creds/icefiredb/abc", "lease_duration": 1, "renewable": true}
+	case "/v1/auth/token/lookup-self":
+		resp = map[string]interface{}{"data": map[string]interface{}{"ttl": 3600, "renewable": true}}
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"errors": []string{}})
+		return
+	}
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+func TestSecrets(t *testing.T) {
+	vault := &fakeVault{key: "key-v1"}
+	srv := httptest.NewServer(vault)
+	defer srv.Close()
+	t.Setenv("VAULT_TOKEN", "s.Token")
+	t.Setenv("ICEFIREDB_TEST_SECRET", "from-env")
+	v, err := newVaultBackend(srv.URL, "", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	secretBackends["vault"] = v
+	defer delete(secretBackends, "vault")
+	// the renewals stop before the backend is removed
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	for ref, expected := range map[string]string{
+		"vault:secret/data/icefiredb#auth": "pw-from-vault",
+		"vault:kv/admin":                   "admin-from-vault",
+		"env:ICEFIREDB_TEST_SECRET":        "from-env",
+		"plain:value":                      "plain:value",
+	} {
+		value := ref
+		if err := resolveSecret(&value); err != nil || value != expected {
+			t.Errorf("%s: %q %v", ref, value, err)
+		}
+	}
+	for _, ref := range []string{"vault:secret/data/icefiredb#nofield", "vault:secret/data/missing#key", "env:ICEFIREDB_NO_SUCH_SECRET"} {
+		value := ref
+		if err := resolveSecret(&value); err == nil {
+			t.Errorf("%s resolved to %q", ref, value)
+		}
+	}
+
+	// the leases are renewed at two thirds of their ttl
+	value, s, err := secretRef{scheme: "vault", path: "database/creds/icefiredb", field: "password"}.fetch()
+	if err != nil || value != "p" || s.leaseID == "" || s.ttl != time.Second {
+		t.Fatalf("leased secret: %q %+v %v", value, s, err)
+	}
+	go keepLease(ctx, secretRef{scheme: "vault", path: "database/creds/icefiredb", field: "password"}, s, nil)
+	time.Sleep(1500 * time.Millisecond)
+	if n := atomic.LoadInt64(&vault.renewed); n < 1 {
+		t.Errorf("lease renewed %d times", n)
+	}
+
+	// a key is written to a private file of the data directory, and again
+	// as it rotates
+	dataDir := conf.DataDir
+	conf.DataDir = t.TempDir()
+	defer func() { conf.DataDir = dataDir }()
+	refresh := secretRefresh
+	secretRefresh = 100 * time.Millisecond
+	defer func() { secretRefresh = refresh }()
+	secretKeepers = nil
+	path := "vault:secret/data/icefiredb#key"
+	if err := resolveSecretFile(&path); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(path, conf.DataDir) {
+		t.Fatalf("secret file %s", path)
+	}
+	if data, err := os.ReadFile(path); err != nil || string(data) != "key-v1" {
+		t.Errorf("secret file: %q %v", data, err)
+	}
+	if fi, err := os.Stat(path); err != nil || fi.Mode().Perm() != 0o600 {
+		t.Errorf("secret file mode: %v %v", fi.Mode(), err)
+	}
+	keepSecrets(ctx)
+	vault.mu.Lock()
+	vault.key = "key-v2"
+	vault.mu.Unlock()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		data, _ := os.ReadFile(path)
+		if string(data) == "key-v2" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("rotated key not written: %q", data)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tidwall/redcon"
+	"go.uber.org/zap"
+)
+
+var (
+	// the address of the Sentinel port, disabled when empty
+	sentinelAddr string
+	// the name of the master the Sentinel clients ask for
+	sentinelMaster = "icefiredb"
+)
+
+// the channel of the leader changes of the Sentinel clients
+const sentinelSwitchChannel = "+switch-master"
+
+// the subscribers of the Sentinel port
+var sentinelPubSub redcon.PubSub
+
+// sentinelMasterInfo is the state of the master in SENTINEL MASTER and
+// SENTINEL MASTERS
+func sentinelMasterInfo(members []clusterMember) []string {
+	leader := leaderOf(members)
+	return []string{
+		"name", sentinelMaster,
+		"ip", leader.host,
+		"port", leader.port,
+		"runid", leader.id,
+		"flags", "master",
+		"num-slaves", strconv.Itoa(len(members) - 1),
+		"num-other-sentinels", "0",
+		"quorum", "1",
+	}
+}
+
+// sentinelReplicas is the state of the followers in SENTINEL REPLICAS
+func sentinelReplicas(members []clusterMember) []interface{} {
+	leader := leaderOf(members)
+	replicas := []interface{}{}
+	for _, m := range members {
+		if m.leader {
+			continue
+		}
+		info := []string{
+			"name", net.JoinHostPort(m.host, m.port),
+			"ip", m.host,
+			"port", m.port,
+			"runid", m.id,
+			"flags", "slave",
+			"master-link-status", "err",
+		}
+		if leader != nil {
+			info[len(info)-1] = "ok"
+			info = append(info, "master-host", leader.host, "master-port", leader.port)
+		}
+		replicas = append(replicas, info)
+	}
+	return replicas
+}
+
+// cmdSENTINEL runs the subset of SENTINEL the clients use to find the
+// master: GET-MASTER-ADDR-BY-NAME, MASTER, MASTERS, REPLICAS, SLAVES,
+// SENTINELS and MYID
+func cmdSENTINEL(args []string) (interface{}, error) {
+	if len(args) < 2 {
+		return nil, errors.New("ERR wrong number of arguments for 'sentinel' command")
+	}
+	sub := strings.ToLower(args[1])
+	switch sub {
+	case "myid":
+		return conf.NodeID, nil
+	case "masters":
+		members, err := clusterMembers()
+		if err != nil {
+			return nil, err
+		}
+		if leaderOf(members) == nil {
+			return []interface{}{}, nil
+		}
+		return []interface{}{sentinelMasterInfo(members)}, nil
+	case "get-master-addr-by-name", "master", "replicas", "slaves", "sentinels":
+		if len(args) != 3 {
+			return nil, fmt.Errorf("ERR wrong number of arguments for 'sentinel %s' command", sub)
+		}
+		if args[2] != sentinelMaster {
+			if sub == "get-master-addr-by-name" {
+				return nil, nil
+			}
+			return nil, errors.New("ERR No such master with that name")
+		}
+		if sub == "sentinels" {
+			return []interface{}{}, nil
+		}
+		members, err := clusterMembers()
+		if err != nil {
+			return nil, err
+		}
+		leader := leaderOf(members)
+		switch {
+		case sub == "replicas" || sub == "slaves":
+			return sentinelReplicas(members), nil
+		case leader == nil && sub == "master":
+			return nil, errors.New("ERR the cluster has no leader")
+		case leader == nil:
+			return nil, nil
+		case sub == "master":
+			return sentinelMasterInfo(members), nil
+		}
+		return []string{leader.host, leader.port}, nil
+	}
+	return nil, fmt.Errorf("ERR unknown sentinel subcommand '%s'", args[1])
+}
+
+// handleSentinel serves a command of the Sentinel port. The port has no
+// authentication, AUTH is accepted for the clients sending a password.
+func handleSentinel(conn redcon.Conn, cmd redcon.Command) {
+	args := make([]string, len(cmd.Args))
+	for i, arg := range cmd.Args {
+		args[i] = string(arg)
+	}
+	switch strings.ToLower(args[0]) {
+	case "ping":
+		conn.WriteString("PONG")
+	case "quit":
+		conn.WriteString("OK")
+		conn.Close()
+	case "auth":
+		conn.WriteString("OK")
+	case "client":
+		if len(args) > 1 && (strings.EqualFold(args[1], "setname") || strings.EqualFold(args[1], "setinfo")) {
+			conn.WriteString("OK")
+		} else {
+			conn.WriteError("ERR unknown CLIENT subcommand")
+		}
+	case "hello":
+		if len(args) > 1 && args[1] != "2" {
+			conn.WriteError("NOPROTO unsupported protocol version")
+			return
+		}
+		conn.WriteAny([]interface{}{
+			"server", "icefiredb",
+			"version", conf.Version,
+			"proto", redcon.SimpleInt(2),
+			"id", redcon.SimpleInt(0),
+			"mode", "sentinel",
+		})
+	case "role":
+		conn.WriteAny([]interface{}{"sentinel", []string{sentinelMaster}})
+	case "subscribe", "psubscribe":
+		if len(args) < 2 {
+			conn.WriteError(fmt.Sprintf("ERR wrong number of arguments for '%s' command", args[0]))
+			return
+		}
+		for _, ch := range args[1:] {
+			if strings.EqualFold(args[0], "psubscribe") {
+				sentinelPubSub.Psubscribe(conn, ch)
+			} else {
+				sentinelPubSub.Subscribe(conn, ch)
+			}
+		}
+	case "sentinel":
+		v, err := cmdSENTINEL(args)
+		if err != nil {
+			conn.WriteError(err.Error())
+			return
+		}
+		conn.WriteAny(v)
+	default:
+		conn.WriteError(fmt.Sprintf("ERR unknown command '%s', the Sentinel port only serves SENTINEL, SUBSCRIBE and PING", args[0]))
+	}
+}
+
+// sentinelWatcher publishes +switch-master as the leader changes
+type sentinelWatcher struct {
+	// the last leader seen, empty before the first one
+	leader string
+}
+
+// watchSentinel polls the leader every interval until stop is closed
+func watchSentinel(interval time.Duration, stop <-chan struct{}) {
+	w := &sentinelWatcher{}
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		if resp, err := localDo("raft", "leader"); err == nil {
+			w.switchTo(resp.String())
+		}
+		select {
+		case <-stop:
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// switchTo publishes the change to a new leader, "name old-ip old-port
+// new-ip new-port", the cluster without a leader keeps the last one
+func (w *sentinelWatcher) switchTo(leader string) {
+	if leader == "" || leader == w.leader {
+		return
+	}
+	old := w.leader
+	w.leader = leader
+	if old == "" {
+		return
+	}
+	oldIP, oldPort, err1 := net.SplitHostPort(old)
+	newIP, newPort, err2 := net.SplitHostPort(leader)
+	if err1 != nil || err2 != nil {
+		return
+	}
+	msg := strings.Join([]string{sentinelMaster, oldIP, oldPort, newIP, newPort}, " ")
+	loggers[logServer].Info("sentinel switch-master", zap.String("from", old), zap.String("to", leader))
+	sentinelPubSub.Publish(sentinelSwitchChannel, msg)
+}
+
+// serveSentinel serves the Sentinel port on --sentinel-addr, over TLS when
+// the server has --tls-cert and --tls-key
+func serveSentinel(addr string) {
+	ln, err := net.Listen("tcp", addr)
+	if err == nil {
+		loggers[logServer].Info("serving the Sentinel port", zap.String("addr", ln.Addr().String()))
+		var fln net.Listener = &filteredListener{Listener: ln, name: listenerSentinel}
+		if conf.TLSCertPath != "" {
+			var cert tls.Certificate
+			if cert, err = tls.LoadX509KeyPair(conf.TLSCertPath, conf.TLSKeyPath); err == nil {
+				fln = tls.NewListener(fln, &tls.Config{Certificates: []tls.Certificate{cert}})
+			}
+		}
+		if err == nil {
+			go watchSentinel(time.Second, nil)
+			err = redcon.Serve(fln, handleSentinel, nil, nil)
+		}
+	}
+	loggers[logServer].Error("Sentinel listen fail", zap.Error(err))
+}
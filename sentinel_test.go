@@ -0,0 +1,82 @@
+//go:build alltest
+// +build alltest
+
+package main
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	redigo "github.com/gomodule/redigo/redis"
+	"github.com/redis/go-redis/v9"
+	"github.com/tidwall/redcon"
+)
+
+func TestSentinel(t *testing.T) {
+	getTestConn()
+	ctx := context.Background()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go redcon.Serve(ln, handleSentinel, nil, nil)
+
+	conn, err := redigo.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	leader, _ := localDo("raft", "leader")
+	host, port, _ := net.SplitHostPort(leader.String())
+	addr, err := redigo.Strings(conn.Do("sentinel", "get-master-addr-by-name", sentinelMaster))
+	if err != nil || len(addr) != 2 || addr[0] != host || addr[1] != port {
+		t.Errorf("master %q %v, expected %s", addr, err, leader.String())
+	}
+	if v, err := conn.Do("sentinel", "get-master-addr-by-name", "other"); v != nil || err != nil {
+		t.Errorf("unknown master %v %v", v, err)
+	}
+	masters, err := redigo.Values(conn.Do("sentinel", "masters"))
+	if err != nil || len(masters) != 1 {
+		t.Fatalf("masters %v %v", masters, err)
+	}
+	if info, _ := redigo.StringMap(masters[0], nil); info["name"] != sentinelMaster || info["flags"] != "master" || info["port"] != port {
+		t.Errorf("master %v", info)
+	}
+	if replicas, err := redigo.Values(conn.Do("sentinel", "replicas", sentinelMaster)); err != nil || len(replicas) != 0 {
+		t.Errorf("replicas %v %v", replicas, err)
+	}
+	if _, err := conn.Do("get", "k"); err == nil {
+		t.Error("GET on the Sentinel port")
+	}
+
+	// a Sentinel client writes to the leader
+	fc := redis.NewFailoverClient(&redis.FailoverOptions{MasterName: sentinelMaster, SentinelAddrs: []string{ln.Addr().String()}})
+	defer fc.Close()
+	if err := fc.Set(ctx, "sentinel:a", "1", 0).Err(); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := fc.Get(ctx, "sentinel:a").Result(); err != nil || v != "1" {
+		t.Errorf("GET through Sentinel %q %v", v, err)
+	}
+	fc.Del(ctx, "sentinel:a")
+
+	// the subscribers see the leader changes
+	sub := redigo.PubSubConn{Conn: conn}
+	if err := sub.Subscribe(sentinelSwitchChannel); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := sub.ReceiveWithTimeout(5 * time.Second).(redigo.Subscription); !ok {
+		t.Fatal("subscription expected")
+	}
+	w := &sentinelWatcher{}
+	w.switchTo("10.0.0.1:11001")
+	w.switchTo("")
+	w.switchTo("10.0.0.2:11001")
+	msg, ok := sub.ReceiveWithTimeout(5 * time.Second).(redigo.Message)
+	if !ok || string(msg.Data) != sentinelMaster+" 10.0.0.1 11001 10.0.0.2 11001" {
+		t.Errorf("switch-master %+v", msg)
+	}
+}
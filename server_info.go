@@ -67,12 +67,24 @@ func (i *info) Dump(section string) []byte {
 		i.dumpAll(buf)
 	case "server":
 		i.dumpServer(buf)
+	case "clients":
+		i.dumpClients(buf)
 	case "mem":
 		i.dumpMem(buf)
 	case "gc":
 		i.dumpGC(buf)
 	case "store":
 		i.dumpStore(buf)
+	case "commandstats":
+		i.dumpCommandStats(buf)
+	case "latencystats":
+		i.dumpLatencyStats(buf)
+	case "all", "everything":
+		i.dumpAll(buf)
+		i.dumpCommandStats(buf)
+		buf.Write(Delims)
+		i.dumpLatencyStats(buf)
+		buf.Write(Delims)
 	default:
 		buf.WriteString(fmt.Sprintf("# %s\r\n", section))
 	}
@@ -104,6 +116,8 @@ type infoPair struct {
 func (i *info) dumpAll(buf *bytes.Buffer) {
 	i.dumpServer(buf)
 	buf.Write(Delims)
+	i.dumpClients(buf)
+	buf.Write(Delims)
 	i.dumpStore(buf)
 	buf.Write(Delims)
 	i.dumpMem(buf)
@@ -132,11 +146,39 @@ func (i *info) dumpServer(buf *bytes.Buffer) {
 	)
 }
 
+func (i *info) dumpClients(buf *bytes.Buffer) {
+	buf.WriteString("# Clients\r\n")
+
+	s := clients.stats()
+	limit, timeout := clients.limits()
+	limited := limiter.stats()
+	i.dumpPairs(buf,
+		infoPair{"connected_clients", s.Connected},
+		infoPair{"watchers", watches.count()},
+		infoPair{"maxclients", limit},
+		infoPair{"timeout", int(timeout.Seconds())},
+		infoPair{"client_max_input_buffer", s.MaxInput},
+		infoPair{"client_max_output_buffer", s.MaxOutput},
+		infoPair{"client_command_backlog", s.Backlog},
+		infoPair{"total_connections_received", s.Accepted},
+		infoPair{"rejected_connections", s.Rejected},
+		infoPair{"idle_disconnections", s.Idle},
+		infoPair{"rate_limited_user_commands", limited[limitUserRate]},
+		infoPair{"rate_limited_user_bandwidth", limited[limitUserBandwidth]},
+		infoPair{"rate_limited_ip_commands", limited[limitIPRate]},
+		infoPair{"rate_limited_ip_bandwidth", limited[limitIPBandwidth]},
+	)
+}
+
 func (i *info) dumpMem(buf *bytes.Buffer) {
 	buf.WriteString("# Mem\r\n")
 
 	var mem runtime.MemStats
 	runtime.ReadMemStats(&mem)
+	pressure := 0
+	if memoryPressure.Load() {
+		pressure = 1
+	}
 
 	i.dumpPairs(buf, infoPair{"mem_alloc", getMemoryHuman(mem.Alloc)},
 		infoPair{"mem_sys", getMemoryHuman(mem.Sys)},
@@ -150,6 +192,8 @@ func (i *info) dumpMem(buf *bytes.Buffer) {
 		infoPair{"mem_head_inuse", getMemoryHuman(mem.HeapInuse)},
 		infoPair{"mem_head_released", getMemoryHuman(mem.HeapReleased)},
 		infoPair{"mem_head_objects", mem.HeapObjects},
+		infoPair{"mem_limit", getMemoryHuman(uint64(memoryLimit) << 20)},
+		infoPair{"mem_pressure", pressure},
 	)
 }
 
@@ -12,10 +12,13 @@ func init() {
 }
 
 func cmdINFO(_ uhaha.Machine, args []string) (interface{}, error) {
-	if len(args) != 1 {
-		return nil, uhaha.ErrWrongNumArgs
+	switch len(args) {
+	case 1:
+		return serverInfo.Dump(""), nil
+	case 2:
+		return serverInfo.Dump(args[1]), nil
 	}
-	return serverInfo.Dump(""), nil
+	return nil, uhaha.ErrWrongNumArgs
 }
 
 func cmdFLUSHALL(_ uhaha.Machine, args []string) (interface{}, error) {
@@ -26,7 +29,11 @@ func cmdFLUSHALL(_ uhaha.Machine, args []string) (interface{}, error) {
 	if err != nil {
 		return nil, err
 	}
-	return redcon.SimpleInt(n), nil
+	streams, err := streamFlush()
+	if err != nil {
+		return nil, err
+	}
+	return redcon.SimpleInt(n + streams), nil
 }
 
 func cmdFLUSHDB(_ uhaha.Machine, args []string) (interface{}, error) {
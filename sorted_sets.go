@@ -15,6 +15,9 @@ func init() {
 	conf.AddWriteCommand("ZADD", cmdZADD)
 	conf.AddWriteCommand("ZREM", cmdZREM)
 	conf.AddWriteCommand("ZCLEAR", cmdZCLEAR)
+	conf.AddWriteCommand("ZINCRBY", cmdZINCRBY)
+	conf.AddWriteCommand("ZREMRANGEBYSCORE", cmdZREMRANGEBYSCORE)
+	conf.AddWriteCommand("ZREMRANGEBYRANK", cmdZREMRANGEBYRANK)
 
 	// Read command
 	conf.AddReadCommand("ZCARD", cmdZCARD)
@@ -23,12 +26,9 @@ func init() {
 	conf.AddReadCommand("ZRANGE", cmdZRANGE)
 	conf.AddReadCommand("ZREVRANGE", cmdZREVRANGE)
 	conf.AddReadCommand("ZSCORE", cmdZSCORE)
-	conf.AddReadCommand("ZINCRBY", cmdZINCRBY)
 	conf.AddReadCommand("ZREVRANK", cmdZREVRANK)
 	conf.AddReadCommand("ZRANGEBYSCORE", cmdZRANGEBYSCORE)
 	conf.AddReadCommand("ZREVRANGEBYSCORE", cmdZREVRANGEBYSCORE)
-	conf.AddReadCommand("ZREMRANGEBYSCORE", cmdZREMRANGEBYSCORE)
-	conf.AddReadCommand("ZREMRANGEBYRANK", cmdZREMRANGEBYRANK)
 }
 
 func zparseRange(a1 string, a2 string) (start int, stop int, err error) {
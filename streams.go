@@ -0,0 +1,1061 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ledisdb/ledisdb/store"
+	"github.com/tidwall/redcon"
+	"github.com/tidwall/uhaha"
+)
+
+func init() {
+	conf.AddWriteCommand("XADD", cmdXADD)
+	conf.AddWriteCommand("XGROUP", cmdXGROUP)
+	conf.AddWriteCommand("XREADGROUP", cmdXREADGROUP)
+	conf.AddWriteCommand("XACK", cmdXACK)
+	conf.AddWriteCommand("XCLEAR", cmdXCLEAR)
+
+	conf.AddReadCommand("XLEN", cmdXLEN)
+	conf.AddReadCommand("XRANGE", cmdXRANGE)
+	conf.AddReadCommand("XREVRANGE", cmdXREVRANGE)
+	conf.AddReadCommand("XREAD", cmdXREAD)
+	conf.AddReadCommand("XPENDING", cmdXPENDING)
+}
+
+// The streams are kept in the storage out of the ledis key space, as the
+// fences: a record of each stream with its length, its last ID and its
+// consumer groups, its entries by key and ID, and the entries pending in
+// its groups by key, group and ID. They are in the raft snapshots.
+var (
+	streamPrefix        = []byte("__icefiredb_stream_")
+	streamMetaPrefix    = []byte("__icefiredb_stream_m")
+	streamEntryPrefix   = []byte("__icefiredb_stream_e")
+	streamPendingPrefix = []byte("__icefiredb_stream_p")
+)
+
+var (
+	errStreamID       = errors.New("ERR Invalid stream ID specified as stream command argument")
+	errStreamXAddID   = errors.New("ERR The ID specified in XADD is equal or smaller than the target stream top item")
+	errStreamZeroID   = errors.New("ERR The ID specified in XADD must be greater than 0-0")
+	errStreamExhaust  = errors.New("ERR The stream has exhausted the last possible ID, unable to add more items")
+	errStreamGroupKey = errors.New("ERR The XGROUP subcommand requires the key to exist. Note that for CREATE you may want to use the MKSTREAM option to create an empty stream automatically.")
+	errStreamBusy     = errors.New("BUSYGROUP Consumer Group name already exists")
+	errStreamTimeout  = errors.New("ERR timeout is not an integer or out of range")
+)
+
+// streamID is the ID of an entry, its time in milliseconds and its
+// sequence number within the millisecond
+type streamID struct {
+	ms, seq uint64
+}
+
+var maxStreamID = streamID{math.MaxUint64, math.MaxUint64}
+
+func (id streamID) String() string {
+	return strconv.FormatUint(id.ms, 10) + "-" + strconv.FormatUint(id.seq, 10)
+}
+
+func (id streamID) MarshalText() ([]byte, error) {
+	return []byte(id.String()), nil
+}
+
+func (id *streamID) UnmarshalText(b []byte) error {
+	v, err := parseStreamID(string(b), 0)
+	*id = v
+	return err
+}
+
+func (id streamID) less(o streamID) bool {
+	return id.ms < o.ms || id.ms == o.ms && id.seq < o.seq
+}
+
+// next returns the ID following id, false for the last ID
+func (id streamID) next() (streamID, bool) {
+	switch {
+	case id.seq < math.MaxUint64:
+		return streamID{id.ms, id.seq + 1}, true
+	case id.ms < math.MaxUint64:
+		return streamID{id.ms + 1, 0}, true
+	}
+	return id, false
+}
+
+// prev returns the ID before id, false for 0-0
+func (id streamID) prev() (streamID, bool) {
+	switch {
+	case id.seq > 0:
+		return streamID{id.ms, id.seq - 1}, true
+	case id.ms > 0:
+		return streamID{id.ms - 1, math.MaxUint64}, true
+	}
+	return id, false
+}
+
+func (id streamID) appendBytes(b []byte) []byte {
+	b = binary.BigEndian.AppendUint64(b, id.ms)
+	return binary.BigEndian.AppendUint64(b, id.seq)
+}
+
+// parseStreamID parses ms-seq, or ms with the sequence number seq
+func parseStreamID(s string, seq uint64) (streamID, error) {
+	msPart, seqPart, full := strings.Cut(s, "-")
+	ms, err := strconv.ParseUint(msPart, 10, 64)
+	if err != nil {
+		return streamID{}, errStreamID
+	}
+	if full {
+		if seq, err = strconv.ParseUint(seqPart, 10, 64); err != nil {
+			return streamID{}, errStreamID
+		}
+	}
+	return streamID{ms, seq}, nil
+}
+
+// parseStreamRangeID parses a bound of XRANGE and XPENDING: - and + are
+// the first and the last IDs, a bound ms is the first or the last ID of the
+// millisecond, and a bound (id excludes id. ok is false when the range is
+// empty past an excluded bound.
+func parseStreamRangeID(s string, end bool) (id streamID, ok bool, err error) {
+	switch s {
+	case "-":
+		return streamID{}, true, nil
+	case "+":
+		return maxStreamID, true, nil
+	}
+	exclusive := strings.HasPrefix(s, "(")
+	s = strings.TrimPrefix(s, "(")
+	var seq uint64
+	if end {
+		seq = math.MaxUint64
+	}
+	if id, err = parseStreamID(s, seq); err != nil {
+		return id, false, err
+	}
+	if !exclusive {
+		return id, true, nil
+	}
+	if end {
+		id, ok = id.prev()
+	} else {
+		id, ok = id.next()
+	}
+	return id, ok, nil
+}
+
+// streamMeta is the record of a stream
+type streamMeta struct {
+	Last   streamID                `json:"last"`
+	Length int64                   `json:"length"`
+	Added  int64                   `json:"added"`
+	Groups map[string]*streamGroup `json:"groups,omitempty"`
+}
+
+// streamGroup is a consumer group of a stream
+type streamGroup struct {
+	// the last entry delivered to the group
+	Last streamID `json:"last"`
+	// the consumers, with the time they were last seen in milliseconds
+	Consumers map[string]int64 `json:"consumers,omitempty"`
+}
+
+// streamPending is an entry delivered to a consumer of a group and not
+// acknowledged yet
+type streamPending struct {
+	ID         streamID
+	Consumer   string
+	Delivered  int64
+	Deliveries int64
+}
+
+// streamKey returns the storage key of a prefix and its parts, each with
+// its length so that a part is never the prefix of another
+func streamKey(prefix []byte, parts ...string) []byte {
+	b := append([]byte(nil), prefix...)
+	for _, p := range parts {
+		b = binary.BigEndian.AppendUint32(b, uint32(len(p)))
+		b = append(b, p...)
+	}
+	return b
+}
+
+func streamEntryKey(key string, id streamID) []byte {
+	return id.appendBytes(streamKey(streamEntryPrefix, key))
+}
+
+func streamPendingKey(key, group string, id streamID) []byte {
+	return id.appendBytes(streamKey(streamPendingPrefix, key, group))
+}
+
+// streamKeyID returns the ID ending a storage key
+func streamKeyID(k []byte) streamID {
+	k = k[len(k)-16:]
+	return streamID{binary.BigEndian.Uint64(k), binary.BigEndian.Uint64(k[8:])}
+}
+
+func encodeStreamFields(fields []string) []byte {
+	var b []byte
+	for _, f := range fields {
+		b = binary.AppendUvarint(b, uint64(len(f)))
+		b = append(b, f...)
+	}
+	return b
+}
+
+func decodeStreamFields(b []byte) ([][]byte, error) {
+	var fields [][]byte
+	for len(b) > 0 {
+		n, size := binary.Uvarint(b)
+		if size <= 0 || uint64(len(b)-size) < n {
+			return nil, errors.New("ERR corrupted stream entry")
+		}
+		fields = append(fields, b[size:size+int(n)])
+		b = b[size+int(n):]
+	}
+	return fields, nil
+}
+
+func encodeStreamPending(p streamPending) []byte {
+	b := binary.BigEndian.AppendUint64(nil, uint64(p.Delivered))
+	b = binary.BigEndian.AppendUint64(b, uint64(p.Deliveries))
+	return append(b, p.Consumer...)
+}
+
+func decodeStreamPending(k, v []byte) streamPending {
+	if len(v) < 16 {
+		return streamPending{ID: streamKeyID(k)}
+	}
+	return streamPending{
+		ID:         streamKeyID(k),
+		Delivered:  int64(binary.BigEndian.Uint64(v)),
+		Deliveries: int64(binary.BigEndian.Uint64(v[8:])),
+		Consumer:   string(v[16:]),
+	}
+}
+
+// loadStream returns the record of a stream, nil when it does not exist
+func loadStream(key string) (*streamMeta, error) {
+	data, err := ldb.GetSDB().Get(streamKey(streamMetaPrefix, key))
+	if err != nil || data == nil {
+		return nil, err
+	}
+	meta := new(streamMeta)
+	if err := json.Unmarshal(data, meta); err != nil {
+		return nil, err
+	}
+	return meta, nil
+}
+
+func putStream(wb *store.WriteBatch, key string, meta *streamMeta) error {
+	data, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	wb.Put(streamKey(streamMetaPrefix, key), data)
+	return nil
+}
+
+// streamRange returns the entries of a stream from start to end included,
+// the last ones first with rev, up to count, all for -1
+func streamRange(key string, start, end streamID, rev bool, count int) ([]interface{}, error) {
+	entries := []interface{}{}
+	if end.less(start) {
+		return entries, nil
+	}
+	sdb := ldb.GetSDB()
+	min, max := streamEntryKey(key, start), streamEntryKey(key, end)
+	var it *store.RangeLimitIterator
+	if rev {
+		it = sdb.RevRangeLimitIterator(min, max, store.RangeClose, 0, count)
+	} else {
+		it = sdb.RangeLimitIterator(min, max, store.RangeClose, 0, count)
+	}
+	defer it.Close()
+	for ; it.Valid(); it.Next() {
+		fields, err := decodeStreamFields(it.Value())
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, []interface{}{streamKeyID(it.RawKey()).String(), fields})
+	}
+	return entries, nil
+}
+
+// streamPendings returns the entries pending in a group from start to end
+// included, up to count, all for -1
+func streamPendings(key, group string, start, end streamID, count int) []streamPending {
+	it := ldb.GetSDB().RangeLimitIterator(streamPendingKey(key, group, start),
+		streamPendingKey(key, group, end), store.RangeClose, 0, count)
+	defer it.Close()
+	var pending []streamPending
+	for ; it.Valid(); it.Next() {
+		pending = append(pending, decodeStreamPending(it.RawKey(), it.RawValue()))
+	}
+	return pending
+}
+
+// deleteStreamRange deletes the storage keys from min to max included
+func deleteStreamRange(wb *store.WriteBatch, min, max []byte) int {
+	it := ldb.GetSDB().RangeLimitIterator(min, max, store.RangeClose, 0, -1)
+	defer it.Close()
+	n := 0
+	for ; it.Valid(); it.Next() {
+		wb.Delete(it.Key())
+		n++
+	}
+	return n
+}
+
+// the last storage key of a prefix
+func streamPrefixEnd(prefix []byte) []byte {
+	return append(append([]byte(nil), prefix...), bytes.Repeat([]byte{0xff}, 32)...)
+}
+
+// streamFlush deletes every stream, for FLUSHALL, and returns their number
+func streamFlush() (int64, error) {
+	sdb := ldb.GetSDB()
+	it := sdb.RangeLimitIterator(streamPrefix, streamPrefixEnd(streamPrefix), store.RangeClose, 0, -1)
+	defer it.Close()
+	wb := sdb.NewWriteBatch()
+	defer wb.Close()
+	var n int64
+	for ; it.Valid(); it.Next() {
+		if bytes.HasPrefix(it.RawKey(), streamMetaPrefix) {
+			n++
+		}
+		wb.Delete(it.Key())
+	}
+	return n, wb.Commit()
+}
+
+// nextStreamID returns the ID of an entry added after last at now, for the
+// ID argument of XADD: *, ms-* or an ID
+func nextStreamID(last streamID, arg string, now time.Time) (streamID, error) {
+	if arg == "*" {
+		ms := uint64(now.UnixMilli())
+		if last.ms < ms {
+			return streamID{ms, 0}, nil
+		}
+		id, ok := last.next()
+		if !ok {
+			return id, errStreamExhaust
+		}
+		return id, nil
+	}
+	if msPart, ok := strings.CutSuffix(arg, "-*"); ok {
+		ms, err := strconv.ParseUint(msPart, 10, 64)
+		if err != nil {
+			return streamID{}, errStreamID
+		}
+		switch {
+		case ms > last.ms:
+			return streamID{ms, 0}, nil
+		case ms < last.ms || last.seq == math.MaxUint64:
+			return streamID{}, errStreamXAddID
+		}
+		return streamID{ms, last.seq + 1}, nil
+	}
+	id, err := parseStreamID(arg, 0)
+	if err != nil {
+		return id, err
+	}
+	if id == (streamID{}) {
+		return id, errStreamZeroID
+	}
+	if !last.less(id) {
+		return id, errStreamXAddID
+	}
+	return id, nil
+}
+
+// XADD key [NOMKSTREAM] [MAXLEN|MINID [=|~] threshold [LIMIT count]] *|id field value [field value ...]
+// Adds an entry to a stream and returns its ID. An ID * is the time of the
+// write in the log, the same on every node. MAXLEN and MINID trim the
+// stream exactly.
+func cmdXADD(m uhaha.Machine, args []string) (interface{}, error) {
+	if len(args) < 5 {
+		return nil, uhaha.ErrWrongNumArgs
+	}
+	key := args[1]
+	var noMkStream bool
+	maxLen := int64(-1)
+	var minID *streamID
+	i := 2
+options:
+	for ; i < len(args); i++ {
+		switch strings.ToLower(args[i]) {
+		case "nomkstream":
+			noMkStream = true
+			continue
+		case "maxlen", "minid":
+			opt := strings.ToLower(args[i])
+			if i+1 < len(args) && (args[i+1] == "=" || args[i+1] == "~") {
+				i++
+			}
+			if i+1 >= len(args) {
+				return nil, uhaha.ErrSyntax
+			}
+			i++
+			if opt == "maxlen" {
+				n, err := strconv.ParseInt(args[i], 10, 64)
+				if err != nil || n < 0 {
+					return nil, errors.New("ERR The MAXLEN argument must be >= 0.")
+				}
+				maxLen = n
+			} else {
+				id, err := parseStreamID(args[i], 0)
+				if err != nil {
+					return nil, err
+				}
+				minID = &id
+			}
+			continue
+		case "limit":
+			// the trimming is exact, the limit of ~ is not needed
+			if i+1 >= len(args) {
+				return nil, uhaha.ErrSyntax
+			}
+			i++
+			continue
+		}
+		break options
+	}
+	if i+1 >= len(args) || (len(args)-i-1)%2 != 0 {
+		return nil, uhaha.ErrWrongNumArgs
+	}
+	fields := args[i+1:]
+	meta, err := loadStream(key)
+	if err != nil {
+		return nil, err
+	}
+	if meta == nil {
+		if noMkStream {
+			return nil, nil
+		}
+		meta = &streamMeta{}
+	}
+	id, err := nextStreamID(meta.Last, args[i], m.Now())
+	if err != nil {
+		return nil, err
+	}
+	wb := ldb.GetSDB().NewWriteBatch()
+	defer wb.Close()
+	wb.Put(streamEntryKey(key, id), encodeStreamFields(fields))
+	meta.Last = id
+	meta.Length++
+	meta.Added++
+	if maxLen >= 0 || minID != nil {
+		trimStream(wb, key, meta, id, maxLen, minID)
+	}
+	if err := putStream(wb, key, meta); err != nil {
+		return nil, err
+	}
+	if err := wb.Commit(); err != nil {
+		return nil, err
+	}
+	return id.String(), nil
+}
+
+// trimStream deletes the first entries of a stream past maxLen, or before
+// minID, with the entry added just now
+func trimStream(wb *store.WriteBatch, key string, meta *streamMeta, added streamID, maxLen int64, minID *streamID) {
+	it := ldb.GetSDB().RangeLimitIterator(streamEntryKey(key, streamID{}), streamEntryKey(key, maxStreamID), store.RangeClose, 0, -1)
+	defer it.Close()
+	trimmed := func(id streamID) bool {
+		return maxLen >= 0 && meta.Length > maxLen || minID != nil && id.less(*minID)
+	}
+	for ; it.Valid(); it.Next() {
+		if !trimmed(streamKeyID(it.RawKey())) {
+			return
+		}
+		wb.Delete(it.Key())
+		meta.Length--
+	}
+	if trimmed(added) {
+		wb.Delete(streamEntryKey(key, added))
+		meta.Length--
+	}
+}
+
+// XLEN key
+func cmdXLEN(m uhaha.Machine, args []string) (interface{}, error) {
+	if len(args) != 2 {
+		return nil, uhaha.ErrWrongNumArgs
+	}
+	meta, err := loadStream(args[1])
+	if err != nil || meta == nil {
+		return redcon.SimpleInt(0), err
+	}
+	return redcon.SimpleInt(meta.Length), nil
+}
+
+// XRANGE key start end [COUNT count]
+func cmdXRANGE(m uhaha.Machine, args []string) (interface{}, error) {
+	return xrange(args, false)
+}
+
+// XREVRANGE key end start [COUNT count]
+func cmdXREVRANGE(m uhaha.Machine, args []string) (interface{}, error) {
+	return xrange(args, true)
+}
+
+func xrange(args []string, rev bool) (interface{}, error) {
+	if len(args) != 4 && len(args) != 6 {
+		return nil, uhaha.ErrWrongNumArgs
+	}
+	first, last := args[2], args[3]
+	if rev {
+		first, last = last, first
+	}
+	start, okStart, err := parseStreamRangeID(first, false)
+	if err != nil {
+		return nil, err
+	}
+	end, okEnd, err := parseStreamRangeID(last, true)
+	if err != nil {
+		return nil, err
+	}
+	count := -1
+	if len(args) == 6 {
+		if strings.ToLower(args[4]) != "count" {
+			return nil, uhaha.ErrSyntax
+		}
+		n, err := strconv.Atoi(args[5])
+		if err != nil {
+			return nil, errors.New("ERR value is not an integer or out of range")
+		}
+		count = max(n, 0)
+	}
+	if !okStart || !okEnd {
+		return []interface{}{}, nil
+	}
+	return streamRange(args[1], start, end, rev, count)
+}
+
+// streamRead are the options of XREAD and XREADGROUP
+type streamRead struct {
+	group, consumer string
+	count           int
+	// BLOCK, run by the connection of the client
+	block    time.Duration
+	blocking bool
+	noAck    bool
+	keys     []string
+	ids      []string
+}
+
+// parseStreamRead parses XREAD [COUNT count] [BLOCK ms] STREAMS key... id...
+// and XREADGROUP GROUP group consumer [COUNT count] [BLOCK ms] [NOACK]
+// STREAMS key... id...
+func parseStreamRead(args []string) (*streamRead, error) {
+	group := args[0] == "xreadgroup"
+	r := &streamRead{count: -1}
+	i := 1
+	for ; i < len(args); i++ {
+		opt := strings.ToLower(args[i])
+		if opt == "streams" {
+			break
+		}
+		switch {
+		case opt == "count" && i+1 < len(args):
+			n, err := strconv.Atoi(args[i+1])
+			if err != nil {
+				return nil, errors.New("ERR value is not an integer or out of range")
+			}
+			if n > 0 {
+				r.count = n
+			}
+			i++
+		case opt == "block" && i+1 < len(args):
+			ms, err := strconv.ParseInt(args[i+1], 10, 64)
+			if err != nil || ms < 0 {
+				return nil, errStreamTimeout
+			}
+			r.block, r.blocking = time.Duration(ms)*time.Millisecond, true
+			i++
+		case opt == "group" && group && i+2 < len(args):
+			r.group, r.consumer = args[i+1], args[i+2]
+			i += 2
+		case opt == "noack" && group:
+			r.noAck = true
+		default:
+			return nil, uhaha.ErrSyntax
+		}
+	}
+	rest := args[min(i+1, len(args)):]
+	if i == len(args) || len(rest) == 0 || len(rest)%2 != 0 {
+		return nil, fmt.Errorf("ERR Unbalanced '%s' list of streams: for each stream key an ID or '$' must be specified.", args[0])
+	}
+	if group && r.group == "" {
+		return nil, errors.New("ERR Missing GROUP option for XREADGROUP")
+	}
+	r.keys, r.ids = rest[:len(rest)/2], rest[len(rest)/2:]
+	return r, nil
+}
+
+// XREAD [COUNT count] [BLOCK ms] STREAMS key [key ...] id [id ...]
+// Returns the entries of each stream after its ID, $ for its last entry,
+// nil when none has any
+func cmdXREAD(m uhaha.Machine, args []string) (interface{}, error) {
+	r, err := parseStreamRead(args)
+	if err != nil {
+		return nil, err
+	}
+	var streams []interface{}
+	for i, key := range r.keys {
+		meta, err := loadStream(key)
+		if err != nil {
+			return nil, err
+		}
+		var after streamID
+		if r.ids[i] == "$" {
+			if meta != nil {
+				after = meta.Last
+			}
+		} else if after, err = parseStreamID(r.ids[i], 0); err != nil {
+			return nil, err
+		}
+		start, ok := after.next()
+		if meta == nil || !ok {
+			continue
+		}
+		entries, err := streamRange(key, start, maxStreamID, false, r.count)
+		if err != nil {
+			return nil, err
+		}
+		if len(entries) > 0 {
+			streams = append(streams, []interface{}{key, entries})
+		}
+	}
+	if len(streams) == 0 {
+		return nil, nil
+	}
+	return streams, nil
+}
+
+func errNoGroup(key, group string) error {
+	return fmt.Errorf("NOGROUP No such key '%s' or consumer group '%s'", key, group)
+}
+
+// XREADGROUP GROUP group consumer [COUNT count] [BLOCK ms] [NOACK] STREAMS key [key ...] id [id ...]
+// Delivers to the consumer the entries of each stream never delivered to
+// its group for the ID >, which are then pending until XACK unless NOACK,
+// or returns the entries pending for the consumer after another ID. The
+// time of the delivery is the time of the write in the log.
+func cmdXREADGROUP(m uhaha.Machine, args []string) (interface{}, error) {
+	r, err := parseStreamRead(args)
+	if err != nil {
+		return nil, err
+	}
+	now := m.Now().UnixMilli()
+	metas := make([]*streamMeta, len(r.keys))
+	for i, key := range r.keys {
+		if metas[i], err = loadStream(key); err != nil {
+			return nil, err
+		}
+		if metas[i] == nil || metas[i].Groups[r.group] == nil {
+			return nil, errNoGroup(key, r.group)
+		}
+	}
+	wb := ldb.GetSDB().NewWriteBatch()
+	defer wb.Close()
+	var streams []interface{}
+	for i, key := range r.keys {
+		meta := metas[i]
+		g := meta.Groups[r.group]
+		if g.Consumers == nil {
+			g.Consumers = make(map[string]int64)
+		}
+		g.Consumers[r.consumer] = now
+		if r.ids[i] != ">" {
+			after, err := parseStreamID(r.ids[i], 0)
+			if err != nil {
+				return nil, err
+			}
+			entries := []interface{}{}
+			if start, ok := after.next(); ok {
+				for _, p := range streamPendings(key, r.group, start, maxStreamID, -1) {
+					if r.count >= 0 && len(entries) == r.count {
+						break
+					}
+					if p.Consumer != r.consumer {
+						continue
+					}
+					entry, err := ldb.GetSDB().Get(streamEntryKey(key, p.ID))
+					if err != nil {
+						return nil, err
+					}
+					// an entry trimmed since its delivery has no fields
+					var fields interface{}
+					if entry != nil {
+						if fields, err = decodeStreamFields(entry); err != nil {
+							return nil, err
+						}
+					}
+					entries = append(entries, []interface{}{p.ID.String(), fields})
+				}
+			}
+			streams = append(streams, []interface{}{key, entries})
+		} else if start, ok := g.Last.next(); ok {
+			entries, err := streamRange(key, start, maxStreamID, false, r.count)
+			if err != nil {
+				return nil, err
+			}
+			for _, e := range entries {
+				id, _ := parseStreamID(e.([]interface{})[0].(string), 0)
+				g.Last = id
+				if !r.noAck {
+					wb.Put(streamPendingKey(key, r.group, id), encodeStreamPending(streamPending{
+						Consumer: r.consumer, Delivered: now, Deliveries: 1}))
+				}
+			}
+			if len(entries) > 0 {
+				streams = append(streams, []interface{}{key, entries})
+			}
+		}
+		if err := putStream(wb, key, meta); err != nil {
+			return nil, err
+		}
+	}
+	if err := wb.Commit(); err != nil {
+		return nil, err
+	}
+	if len(streams) == 0 {
+		return nil, nil
+	}
+	return streams, nil
+}
+
+// XACK key group id [id ...]
+// Acknowledges entries pending in a group, returns their number
+func cmdXACK(m uhaha.Machine, args []string) (interface{}, error) {
+	if len(args) < 4 {
+		return nil, uhaha.ErrWrongNumArgs
+	}
+	key, group := args[1], args[2]
+	ids := make([]streamID, len(args)-3)
+	for i, arg := range args[3:] {
+		id, err := parseStreamID(arg, 0)
+		if err != nil {
+			return nil, err
+		}
+		ids[i] = id
+	}
+	sdb := ldb.GetSDB()
+	wb := sdb.NewWriteBatch()
+	defer wb.Close()
+	acked := make(map[streamID]bool)
+	for _, id := range ids {
+		pk := streamPendingKey(key, group, id)
+		v, err := sdb.Get(pk)
+		if err != nil {
+			return nil, err
+		}
+		if v != nil && !acked[id] {
+			wb.Delete(pk)
+			acked[id] = true
+		}
+	}
+	if err := wb.Commit(); err != nil {
+		return nil, err
+	}
+	return redcon.SimpleInt(len(acked)), nil
+}
+
+// XGROUP CREATE key group id|$ [MKSTREAM] [ENTRIESREAD n]
+// XGROUP SETID key group id|$ [ENTRIESREAD n]
+// XGROUP DESTROY key group
+// XGROUP CREATECONSUMER key group consumer
+// XGROUP DELCONSUMER key group consumer
+// Manages the consumer groups of a stream. The entries pending for a group
+// or a consumer deleted are deleted with it.
+func cmdXGROUP(m uhaha.Machine, args []string) (interface{}, error) {
+	if len(args) < 4 {
+		return nil, uhaha.ErrWrongNumArgs
+	}
+	sub, key, group := strings.ToLower(args[1]), args[2], args[3]
+	meta, err := loadStream(key)
+	if err != nil {
+		return nil, err
+	}
+	var mkStream bool
+	opts := args[4:]
+	switch sub {
+	case "create", "setid":
+		if len(opts) == 0 {
+			return nil, uhaha.ErrWrongNumArgs
+		}
+		for i := 1; i < len(opts); i++ {
+			switch strings.ToLower(opts[i]) {
+			case "mkstream":
+				if sub != "create" {
+					return nil, uhaha.ErrSyntax
+				}
+				mkStream = true
+			case "entriesread":
+				// the lag of a group is not reported
+				if i+1 >= len(opts) {
+					return nil, uhaha.ErrSyntax
+				}
+				i++
+			default:
+				return nil, uhaha.ErrSyntax
+			}
+		}
+	case "destroy":
+		if len(opts) != 0 {
+			return nil, uhaha.ErrWrongNumArgs
+		}
+	case "createconsumer", "delconsumer":
+		if len(opts) != 1 {
+			return nil, uhaha.ErrWrongNumArgs
+		}
+	default:
+		return nil, fmt.Errorf("ERR unknown subcommand '%s'", args[1])
+	}
+	if meta == nil {
+		if !mkStream {
+			return nil, errStreamGroupKey
+		}
+		meta = &streamMeta{}
+	}
+	if meta.Groups == nil {
+		meta.Groups = make(map[string]*streamGroup)
+	}
+	g := meta.Groups[group]
+	if g == nil && sub != "create" {
+		if sub == "destroy" {
+			return redcon.SimpleInt(0), nil
+		}
+		return nil, fmt.Errorf("NOGROUP No such consumer group '%s' for key name '%s'", group, key)
+	}
+	wb := ldb.GetSDB().NewWriteBatch()
+	defer wb.Close()
+	var reply interface{} = redcon.SimpleString("OK")
+	switch sub {
+	case "create", "setid":
+		if sub == "create" && g != nil {
+			return nil, errStreamBusy
+		}
+		last := meta.Last
+		if opts[0] != "$" {
+			if last, err = parseStreamID(opts[0], 0); err != nil {
+				return nil, err
+			}
+		}
+		if g == nil {
+			g = &streamGroup{}
+			meta.Groups[group] = g
+		}
+		g.Last = last
+	case "destroy":
+		deleteStreamRange(wb, streamPendingKey(key, group, streamID{}), streamPendingKey(key, group, maxStreamID))
+		delete(meta.Groups, group)
+		reply = redcon.SimpleInt(1)
+	case "createconsumer":
+		if _, ok := g.Consumers[opts[0]]; ok {
+			return redcon.SimpleInt(0), nil
+		}
+		if g.Consumers == nil {
+			g.Consumers = make(map[string]int64)
+		}
+		g.Consumers[opts[0]] = m.Now().UnixMilli()
+		reply = redcon.SimpleInt(1)
+	case "delconsumer":
+		n := 0
+		for _, p := range streamPendings(key, group, streamID{}, maxStreamID, -1) {
+			if p.Consumer == opts[0] {
+				wb.Delete(streamPendingKey(key, group, p.ID))
+				n++
+			}
+		}
+		delete(g.Consumers, opts[0])
+		reply = redcon.SimpleInt(n)
+	}
+	if err := putStream(wb, key, meta); err != nil {
+		return nil, err
+	}
+	if err := wb.Commit(); err != nil {
+		return nil, err
+	}
+	return reply, nil
+}
+
+// XPENDING key group [[IDLE min-idle-time] start end count [consumer]]
+// Returns the number of entries pending in a group, their first and last
+// IDs and their number by consumer, or the entries pending from start to
+// end with their consumer, their idle time and their deliveries.
+func cmdXPENDING(m uhaha.Machine, args []string) (interface{}, error) {
+	if len(args) != 3 && len(args) < 6 {
+		return nil, uhaha.ErrWrongNumArgs
+	}
+	key, group := args[1], args[2]
+	meta, err := loadStream(key)
+	if err != nil {
+		return nil, err
+	}
+	if meta == nil || meta.Groups[group] == nil {
+		return nil, errNoGroup(key, group)
+	}
+	if len(args) == 3 {
+		pending := streamPendings(key, group, streamID{}, maxStreamID, -1)
+		if len(pending) == 0 {
+			return []interface{}{redcon.SimpleInt(0), nil, nil, nil}, nil
+		}
+		counts := make(map[string]int)
+		var names []string
+		for _, p := range pending {
+			if counts[p.Consumer] == 0 {
+				names = append(names, p.Consumer)
+			}
+			counts[p.Consumer]++
+		}
+		sort.Strings(names)
+		consumers := make([]interface{}, len(names))
+		for i, name := range names {
+			consumers[i] = []interface{}{name, strconv.Itoa(counts[name])}
+		}
+		return []interface{}{redcon.SimpleInt(len(pending)), pending[0].ID.String(),
+			pending[len(pending)-1].ID.String(), consumers}, nil
+	}
+	opts := args[3:]
+	var minIdle int64
+	if strings.ToLower(opts[0]) == "idle" {
+		if len(opts) < 5 {
+			return nil, uhaha.ErrSyntax
+		}
+		if minIdle, err = strconv.ParseInt(opts[1], 10, 64); err != nil {
+			return nil, errors.New("ERR value is not an integer or out of range")
+		}
+		opts = opts[2:]
+	}
+	if len(opts) != 3 && len(opts) != 4 {
+		return nil, uhaha.ErrSyntax
+	}
+	start, okStart, err := parseStreamRangeID(opts[0], false)
+	if err != nil {
+		return nil, err
+	}
+	end, okEnd, err := parseStreamRangeID(opts[1], true)
+	if err != nil {
+		return nil, err
+	}
+	count, err := strconv.Atoi(opts[2])
+	if err != nil {
+		return nil, errors.New("ERR value is not an integer or out of range")
+	}
+	entries := []interface{}{}
+	if !okStart || !okEnd || count <= 0 || end.less(start) {
+		return entries, nil
+	}
+	now := time.Now().UnixMilli()
+	for _, p := range streamPendings(key, group, start, end, -1) {
+		if len(entries) == count {
+			break
+		}
+		idle := max(now-p.Delivered, 0)
+		if idle < minIdle || len(opts) == 4 && p.Consumer != opts[3] {
+			continue
+		}
+		entries = append(entries, []interface{}{p.ID.String(), p.Consumer,
+			redcon.SimpleInt(idle), redcon.SimpleInt(p.Deliveries)})
+	}
+	return entries, nil
+}
+
+// XCLEAR key
+// Deletes a stream with its groups, as HCLEAR deletes a hash
+func cmdXCLEAR(m uhaha.Machine, args []string) (interface{}, error) {
+	if len(args) != 2 {
+		return nil, uhaha.ErrWrongNumArgs
+	}
+	key := args[1]
+	meta, err := loadStream(key)
+	if err != nil || meta == nil {
+		return redcon.SimpleInt(0), err
+	}
+	wb := ldb.GetSDB().NewWriteBatch()
+	defer wb.Close()
+	deleteStreamRange(wb, streamEntryKey(key, streamID{}), streamEntryKey(key, maxStreamID))
+	pending := streamKey(streamPendingPrefix, key)
+	deleteStreamRange(wb, pending, streamPrefixEnd(pending))
+	wb.Delete(streamKey(streamMetaPrefix, key))
+	if err := wb.Commit(); err != nil {
+		return nil, err
+	}
+	return redcon.SimpleInt(1), nil
+}
+
+// blockStream runs XREAD or XREADGROUP with BLOCK for a client: it runs
+// the command again at each entry added to one of its streams, until it
+// returns entries, the timeout passes or the client is closed. $ is the last
+// ID of each stream when the command is sent.
+func blockStream(s uhaha.Service, c *clientConn, args []string, timeout time.Duration) uhaha.Receiver {
+	keys, _ := commandKeys(args[0], args)
+	wake := make(chan struct{}, 1)
+	for _, key := range keys {
+		w, _, _, _ := watches.subscribe(key, 0, false)
+		defer watches.unsubscribe(w)
+		go func() {
+			for changes := range w.ch {
+				for _, ch := range changes {
+					if ch.All || ch.Op == "xadd" && ch.Key == key {
+						select {
+						case wake <- struct{}{}:
+						default:
+						}
+					}
+				}
+			}
+		}()
+	}
+	if args[0] == "xread" {
+		args = append([]string(nil), args...)
+		for i, id := range args[len(args)-len(keys):] {
+			if id != "$" {
+				continue
+			}
+			if meta, _ := loadStream(keys[i]); meta != nil {
+				args[len(args)-len(keys)+i] = meta.Last.String()
+			} else {
+				args[len(args)-len(keys)+i] = "0-0"
+			}
+		}
+	}
+	var deadline <-chan time.Time
+	if timeout > 0 {
+		t := time.NewTimer(timeout)
+		defer t.Stop()
+		deadline = t.C
+	}
+	tick := time.NewTicker(time.Second)
+	defer tick.Stop()
+	var elapsed time.Duration
+	for {
+		resp, d, err := s.Send(args, &c.opts).Recv()
+		elapsed += d
+		if err != nil || resp != nil {
+			return uhaha.Response(args, resp, elapsed, err)
+		}
+		for woken := false; !woken; {
+			select {
+			case <-wake:
+				woken = true
+			case <-deadline:
+				return uhaha.Response(args, nil, elapsed, nil)
+			case <-tick.C:
+				if c.closing.Load() {
+					return uhaha.Response(args, nil, elapsed, nil)
+				}
+			}
+		}
+	}
+}
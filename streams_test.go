@@ -0,0 +1,178 @@
+//go:build alltest
+// +build alltest
+
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+func TestStreamIDs(t *testing.T) {
+	last := streamID{5, 3}
+	for _, tc := range []struct {
+		arg string
+		id  string
+		err error
+	}{
+		{"*", "10-0", nil},
+		{"5-*", "5-4", nil},
+		{"6-*", "6-0", nil},
+		{"4-*", "", errStreamXAddID},
+		{"5-3", "", errStreamXAddID},
+		{"5", "", errStreamXAddID},
+		{"7", "7-0", nil},
+		{"0-0", "", errStreamZeroID},
+		{"x-1", "", errStreamID},
+	} {
+		id, err := nextStreamID(last, tc.arg, time.UnixMilli(10))
+		if err != tc.err || err == nil && id.String() != tc.id {
+			t.Errorf("%s: %s %v, expected %s %v", tc.arg, id, err, tc.id, tc.err)
+		}
+	}
+	if id, err := nextStreamID(streamID{20, 0}, "*", time.UnixMilli(10)); err != nil || id.String() != "20-1" {
+		t.Errorf("a clock behind the last ID: %s %v", id, err)
+	}
+	if id, ok, _ := parseStreamRangeID("(5", false); !ok || id.String() != "5-1" {
+		t.Errorf("(5 %s", id)
+	}
+	if id, ok, _ := parseStreamRangeID("5", true); !ok || id != (streamID{5, maxStreamID.seq}) {
+		t.Errorf("5 as an end %s", id)
+	}
+	if _, ok, _ := parseStreamRangeID("(0-0", true); ok {
+		t.Error("(0-0 as an end is empty")
+	}
+}
+
+func TestStreams(t *testing.T) {
+	c := getTestConn()
+	ctx := context.Background()
+	defer c.Do(ctx, "xclear", "events")
+
+	for i, v := range []string{"a", "b", "c"} {
+		id, err := c.XAdd(ctx, &redis.XAddArgs{Stream: "events", ID: "1-" + string(rune('1'+i)), Values: []string{"v", v}}).Result()
+		if err != nil || id != "1-"+string(rune('1'+i)) {
+			t.Fatalf("XADD %s %v", id, err)
+		}
+	}
+	if err := c.XAdd(ctx, &redis.XAddArgs{Stream: "events", ID: "1-1", Values: []string{"v", "x"}}).Err(); err == nil {
+		t.Error("XADD of an ID before the last one must fail")
+	}
+	id, err := c.XAdd(ctx, &redis.XAddArgs{Stream: "events", Values: map[string]interface{}{"v": "d"}}).Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, err := c.XLen(ctx, "events").Result(); err != nil || n != 4 {
+		t.Errorf("XLEN %d %v", n, err)
+	}
+	msgs, err := c.XRange(ctx, "events", "-", "+").Result()
+	if err != nil || len(msgs) != 4 || msgs[0].ID != "1-1" || msgs[0].Values["v"] != "a" || msgs[3].ID != id {
+		t.Errorf("XRANGE %v %v", msgs, err)
+	}
+	if msgs, err := c.XRangeN(ctx, "events", "(1-1", "+", 2).Result(); err != nil || len(msgs) != 2 || msgs[0].ID != "1-2" {
+		t.Errorf("XRANGE exclusive %v %v", msgs, err)
+	}
+	if msgs, err := c.XRevRangeN(ctx, "events", "+", "-", 1).Result(); err != nil || len(msgs) != 1 || msgs[0].ID != id {
+		t.Errorf("XREVRANGE %v %v", msgs, err)
+	}
+	streams, err := c.XRead(ctx, &redis.XReadArgs{Streams: []string{"events", "1-2"}, Count: 1, Block: -1}).Result()
+	if err != nil || len(streams) != 1 || streams[0].Stream != "events" || len(streams[0].Messages) != 1 || streams[0].Messages[0].ID != "1-3" {
+		t.Errorf("XREAD %v %v", streams, err)
+	}
+	if err := c.XRead(ctx, &redis.XReadArgs{Streams: []string{"events", "$"}, Block: -1}).Err(); err != redis.Nil {
+		t.Errorf("XREAD $ %v", err)
+	}
+
+	// a blocked XREAD returns the entry added
+	done := make(chan []redis.XStream)
+	go func() {
+		streams, _ := c.XRead(ctx, &redis.XReadArgs{Streams: []string{"events", "$"}, Block: 5 * time.Second}).Result()
+		done <- streams
+	}()
+	time.Sleep(200 * time.Millisecond)
+	c.XAdd(ctx, &redis.XAddArgs{Stream: "events", Values: []string{"v", "e"}})
+	select {
+	case streams := <-done:
+		if len(streams) != 1 || len(streams[0].Messages) != 1 || streams[0].Messages[0].Values["v"] != "e" {
+			t.Errorf("XREAD BLOCK %v", streams)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("XREAD BLOCK was not woken")
+	}
+	start := time.Now()
+	if err := c.XRead(ctx, &redis.XReadArgs{Streams: []string{"events", "$"}, Block: 100 * time.Millisecond}).Err(); err != redis.Nil || time.Since(start) < 100*time.Millisecond {
+		t.Errorf("XREAD BLOCK timeout %v after %s", err, time.Since(start))
+	}
+
+	// MAXLEN trims the first entries
+	c.XAdd(ctx, &redis.XAddArgs{Stream: "events", MaxLen: 3, Values: []string{"v", "f"}})
+	if msgs, _ := c.XRange(ctx, "events", "-", "+").Result(); len(msgs) != 3 || msgs[0].Values["v"] != "d" {
+		t.Errorf("MAXLEN %v", msgs)
+	}
+}
+
+func TestStreamGroups(t *testing.T) {
+	c := getTestConn()
+	ctx := context.Background()
+	defer c.Do(ctx, "xclear", "jobs")
+
+	if err := c.XGroupCreate(ctx, "jobs", "workers", "0").Err(); err == nil {
+		t.Error("XGROUP CREATE on a missing stream must fail")
+	}
+	if err := c.XGroupCreateMkStream(ctx, "jobs", "workers", "$").Err(); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.XGroupCreate(ctx, "jobs", "workers", "$").Err(); err == nil || err.Error()[:9] != "BUSYGROUP" {
+		t.Errorf("XGROUP CREATE twice %v", err)
+	}
+	for _, v := range []string{"a", "b", "c"} {
+		c.XAdd(ctx, &redis.XAddArgs{Stream: "jobs", Values: []string{"job", v}})
+	}
+	streams, err := c.XReadGroup(ctx, &redis.XReadGroupArgs{Group: "workers", Consumer: "w1", Streams: []string{"jobs", ">"}, Count: 2, Block: -1}).Result()
+	if err != nil || len(streams) != 1 || len(streams[0].Messages) != 2 || streams[0].Messages[0].Values["job"] != "a" {
+		t.Fatalf("XREADGROUP %v %v", streams, err)
+	}
+	first := streams[0].Messages[0].ID
+	streams, err = c.XReadGroup(ctx, &redis.XReadGroupArgs{Group: "workers", Consumer: "w2", Streams: []string{"jobs", ">"}, Block: -1}).Result()
+	if err != nil || len(streams[0].Messages) != 1 || streams[0].Messages[0].Values["job"] != "c" {
+		t.Errorf("XREADGROUP of the next consumer %v %v", streams, err)
+	}
+	if err := c.XReadGroup(ctx, &redis.XReadGroupArgs{Group: "workers", Consumer: "w2", Streams: []string{"jobs", ">"}, Block: -1}).Err(); err != redis.Nil {
+		t.Errorf("XREADGROUP with no new entry %v", err)
+	}
+	// the history of a consumer is its pending entries
+	if streams, err := c.XReadGroup(ctx, &redis.XReadGroupArgs{Group: "workers", Consumer: "w1", Streams: []string{"jobs", "0"}, Block: -1}).Result(); err != nil || len(streams[0].Messages) != 2 {
+		t.Errorf("XREADGROUP history %v %v", streams, err)
+	}
+
+	pending, err := c.XPending(ctx, "jobs", "workers").Result()
+	if err != nil || pending.Count != 3 || pending.Lower != first || pending.Consumers["w1"] != 2 || pending.Consumers["w2"] != 1 {
+		t.Errorf("XPENDING %+v %v", pending, err)
+	}
+	if n, err := c.XAck(ctx, "jobs", "workers", first, first, "9-9").Result(); err != nil || n != 1 {
+		t.Errorf("XACK %d %v", n, err)
+	}
+	ext, err := c.XPendingExt(ctx, &redis.XPendingExtArgs{Stream: "jobs", Group: "workers", Start: "-", End: "+", Count: 10, Consumer: "w1"}).Result()
+	if err != nil || len(ext) != 1 || ext[0].Consumer != "w1" || ext[0].RetryCount != 1 {
+		t.Errorf("XPENDING extended %v %v", ext, err)
+	}
+	if err := c.XPending(ctx, "jobs", "nobody").Err(); err == nil || err.Error()[:7] != "NOGROUP" {
+		t.Errorf("XPENDING of a missing group %v", err)
+	}
+
+	if n, err := c.XGroupDelConsumer(ctx, "jobs", "workers", "w2").Result(); err != nil || n != 1 {
+		t.Errorf("XGROUP DELCONSUMER %d %v", n, err)
+	}
+	if err := c.XGroupSetID(ctx, "jobs", "workers", "0").Err(); err != nil {
+		t.Error(err)
+	}
+	if n, err := c.XGroupDestroy(ctx, "jobs", "workers").Result(); err != nil || n != 1 {
+		t.Errorf("XGROUP DESTROY %d %v", n, err)
+	}
+	if err := c.XReadGroup(ctx, &redis.XReadGroupArgs{Group: "workers", Consumer: "w1", Streams: []string{"jobs", ">"}, Block: -1}).Err(); err == nil {
+		t.Error("XREADGROUP of a destroyed group must fail")
+	}
+}
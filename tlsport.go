@@ -0,0 +1,228 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+var (
+	// the port serving the clients over TLS, next to the port of the server,
+	// 0 disables it
+	tlsPort int
+	// the certificate and the private key of the TLS port
+	tlsPortCert, tlsPortKey string
+	// the CA verifying the certificates of the clients
+	tlsCACert string
+	// no, optional or yes: whether the clients present a certificate, yes
+	// by default with a CA
+	tlsAuthClients string
+	// how often the certificate files are checked for a change
+	tlsReloadInterval = 10 * time.Second
+)
+
+// the certificates of the TLS port, set once it is served
+var tlsCerts atomic.Pointer[certReloader]
+
+// tlsAddr returns the address of the TLS port, on the host of the client
+// port, or else of the server
+func tlsAddr() string {
+	addr := conf.Addr
+	if clientAddr != "" {
+		addr = clientAddr
+	}
+	host, _, _ := net.SplitHostPort(addr)
+	return net.JoinHostPort(host, strconv.Itoa(tlsPort))
+}
+
+// serveTLS serves the clients over TLS on addr. The certificates are
+// reloaded as their files change, the connections already open keep the
+// certificate of their handshake.
+func (srv *clientServer) serveTLS(addr string) error {
+	certs, err := newCertReloader(tlsPortCert, tlsPortKey, tlsCACert, tlsAuthClients)
+	if err != nil {
+		return err
+	}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	tlsCerts.Store(certs)
+	go certs.watch(tlsReloadInterval, nil)
+	loggers[logServer].Info("serving the clients over TLS", zap.String("addr", ln.Addr().String()))
+	return srv.serve(tls.NewListener(ln, certs.config()), listenerTLSPort)
+}
+
+// certReloader holds the TLS configuration of the TLS port and loads it
+// again when the certificate, the key or the CA file changes
+type certReloader struct {
+	certFile, keyFile, caFile string
+	clientAuth                tls.ClientAuthType
+
+	mu sync.RWMutex
+	// the configuration of the handshakes
+	conf *tls.Config
+	// the certificate and the CA pool of the configuration
+	cert *tls.Certificate
+	pool *x509.CertPool
+	// the modification time of the files loaded
+	modTime time.Time
+	// the end of validity of the certificate
+	notAfter time.Time
+}
+
+func newCertReloader(certFile, keyFile, caFile, authClients string) (*certReloader, error) {
+	r := &certReloader{certFile: certFile, keyFile: keyFile, caFile: caFile}
+	switch authClients {
+	case "no":
+		r.clientAuth = tls.NoClientCert
+	case "optional":
+		r.clientAuth = tls.VerifyClientCertIfGiven
+	case "yes":
+		r.clientAuth = tls.RequireAndVerifyClientCert
+	default:
+		return nil, fmt.Errorf("invalid tls-auth-clients '%s'", authClients)
+	}
+	if r.clientAuth != tls.NoClientCert && caFile == "" {
+		return nil, errors.New("the client certificates are verified with a CA")
+	}
+	modTime, err := r.lastModified()
+	if err != nil {
+		return nil, err
+	}
+	if err := r.load(modTime); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// lastModified returns the latest modification time of the files
+func (r *certReloader) lastModified() (time.Time, error) {
+	var last time.Time
+	for _, file := range []string{r.certFile, r.keyFile, r.caFile} {
+		if file == "" {
+			continue
+		}
+		fi, err := os.Stat(file)
+		if err != nil {
+			return last, err
+		}
+		if fi.ModTime().After(last) {
+			last = fi.ModTime()
+		}
+	}
+	return last, nil
+}
+
+// load reads the files, the configuration in use is kept when they are not
+// valid
+func (r *certReloader) load(modTime time.Time) error {
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return err
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return err
+	}
+	conf := &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{cert},
+		ClientAuth:   r.clientAuth,
+	}
+	if r.caFile != "" {
+		data, err := os.ReadFile(r.caFile)
+		if err != nil {
+			return err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return fmt.Errorf("no certificate found in %s", r.caFile)
+		}
+		conf.ClientCAs = pool
+	}
+	r.mu.Lock()
+	r.conf, r.modTime, r.notAfter = conf, modTime, leaf.NotAfter
+	r.cert, r.pool = &cert, conf.ClientCAs
+	r.mu.Unlock()
+	return nil
+}
+
+// reload loads the files again if they changed since they were loaded, it
+// reports whether they did
+func (r *certReloader) reload() (bool, error) {
+	modTime, err := r.lastModified()
+	if err != nil {
+		return false, err
+	}
+	r.mu.RLock()
+	changed := !modTime.Equal(r.modTime)
+	r.mu.RUnlock()
+	if !changed {
+		return false, nil
+	}
+	return true, r.load(modTime)
+}
+
+// watch reloads the files every interval until stop is closed
+func (r *certReloader) watch(interval time.Duration, stop <-chan struct{}) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-t.C:
+		}
+		changed, err := r.reload()
+		switch {
+		case err != nil:
+			loggers[logServer].Error("tls certificate reload fail, the previous one is kept", zap.Error(err))
+		case changed:
+			loggers[logServer].Info("tls certificate reloaded", zap.Time("not_after", r.expiry()))
+		}
+	}
+}
+
+// expiry returns the end of validity of the certificate in use
+func (r *certReloader) expiry() time.Time {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.notAfter
+}
+
+// certificate returns the certificate in use
+func (r *certReloader) certificate() *tls.Certificate {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cert
+}
+
+// roots returns the CA pool in use, nil without CA
+func (r *certReloader) roots() *x509.CertPool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.pool
+}
+
+// config returns the configuration of the listener, it hands the latest
+// configuration loaded to each handshake
+func (r *certReloader) config() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			r.mu.RLock()
+			defer r.mu.RUnlock()
+			return r.conf, nil
+		},
+	}
+}
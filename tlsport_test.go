@@ -0,0 +1,169 @@
+//go:build alltest
+// +build alltest
+
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// issueCert writes a certificate and its key signed by parent, self-signed
+// when parent is nil, with the URIs as SAN
+func issueCert(t *testing.T, dir, name string, serial int64, parent *x509.Certificate, parentKey *ecdsa.PrivateKey, uris ...string) (*x509.Certificate, *ecdsa.PrivateKey) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	for _, uri := range uris {
+		u, err := url.Parse(uri)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tmpl.URIs = append(tmpl.URIs, u)
+	}
+	if parent == nil {
+		tmpl.IsCA = true
+		tmpl.BasicConstraintsValid = true
+		tmpl.KeyUsage = x509.KeyUsageCertSign
+		parent, parentKey = tmpl, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	if err := os.WriteFile(filepath.Join(dir, name+".crt"), certPEM, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, name+".key"), keyPEM, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key
+}
+
+func TestTLSPort(t *testing.T) {
+	getTestConn()
+	ctx := context.Background()
+	dir := t.TempDir()
+	ca, caKey := issueCert(t, dir, "ca", 1, nil, nil)
+	issueCert(t, dir, "server", 2, ca, caKey)
+	issueCert(t, dir, "client", 3, ca, caKey)
+
+	tlsPortCert = filepath.Join(dir, "server.crt")
+	tlsPortKey = filepath.Join(dir, "server.key")
+	tlsCACert = filepath.Join(dir, "ca.crt")
+	tlsAuthClients = "yes"
+	addr := "127.0.0.1:11091"
+	go respServer.serveTLS(addr)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+	clientCert, err := tls.LoadX509KeyPair(filepath.Join(dir, "client.crt"), filepath.Join(dir, "client.key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := redis.NewClient(&redis.Options{
+		Addr:      addr,
+		TLSConfig: &tls.Config{RootCAs: roots, Certificates: []tls.Certificate{clientCert}},
+	})
+	defer c.Close()
+	var pong string
+	for i := 0; i < 50; i++ {
+		if pong, err = c.Ping(ctx).Result(); err == nil {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	if pong != "PONG" {
+		t.Fatalf("PING over TLS: %q %v", pong, err)
+	}
+	if err := c.Set(ctx, "tls:a", "1", 0).Err(); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := c.Get(ctx, "tls:a").Result(); err != nil || v != "1" {
+		t.Errorf("GET over TLS: %q %v", v, err)
+	}
+
+	// a client without certificate is refused
+	anon := redis.NewClient(&redis.Options{
+		Addr:       addr,
+		TLSConfig:  &tls.Config{RootCAs: roots},
+		MaxRetries: -1,
+	})
+	defer anon.Close()
+	if err := anon.Ping(ctx).Err(); err == nil {
+		t.Errorf("client without certificate served")
+	}
+
+	// a new certificate is served to the new connections once reloaded
+	issueCert(t, dir, "server", 4, ca, caKey)
+	future := time.Now().Add(time.Minute)
+	for _, name := range []string{"server.crt", "server.key"} {
+		if err := os.Chtimes(filepath.Join(dir, name), future, future); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if changed, err := tlsCerts.Load().reload(); !changed || err != nil {
+		t.Fatalf("reload: %v %v", changed, err)
+	}
+	conn, err := tls.Dial("tcp", addr, &tls.Config{RootCAs: roots, Certificates: []tls.Certificate{clientCert}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if serial := conn.ConnectionState().PeerCertificates[0].SerialNumber.Int64(); serial != 4 {
+		t.Errorf("certificate served after the reload: serial %d", serial)
+	}
+	// the connections opened before keep working
+	if err := c.Ping(ctx).Err(); err != nil {
+		t.Errorf("connection opened before the reload: %v", err)
+	}
+	// an invalid file keeps the certificate in use
+	if err := os.WriteFile(tlsPortKey, []byte("garbage"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	future = future.Add(time.Minute)
+	if err := os.Chtimes(tlsPortKey, future, future); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tlsCerts.Load().reload(); err == nil {
+		t.Errorf("invalid key loaded")
+	}
+	if err := c.Ping(ctx).Err(); err != nil {
+		t.Errorf("after an invalid reload: %v", err)
+	}
+}
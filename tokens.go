@@ -0,0 +1,296 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	rafthub "github.com/tidwall/uhaha"
+)
+
+var (
+	// the PEM file of the Ed25519 keys of the scoped tokens: a private key
+	// issues and verifies them, the public keys only verify them
+	tokenKeyPath string
+	// --issue-token namespace:scope:ttl, prints a token and exits
+	issueToken string
+)
+
+// the prefix of the scoped tokens, which tells them from the passwords
+const tokenPrefix = "ift1."
+
+// the scopes of the tokens, each one has the rights of the one before
+const (
+	scopeRead  = "read"
+	scopeWrite = "write"
+	scopeAdmin = "admin"
+)
+
+// the commands of a scope, on the keys of the namespace
+var scopeCommands = map[string][]string{
+	scopeRead:  {"+@read"},
+	scopeWrite: {"+@read", "+@write"},
+	scopeAdmin: {"+@read", "+@write", "+token|issue", "+token|inspect"},
+}
+
+var (
+	errTokenKey     = errors.New("ERR no token key, see --token-key")
+	errTokenExpired = errors.New("NOAUTH the token expired, authenticate again")
+)
+
+// scopedToken is the payload of a token: the namespace of the keys it
+// accesses, what it may do and until when
+type scopedToken struct {
+	ID        string `json:"id"`
+	Namespace string `json:"ns"`
+	Scope     string `json:"scope"`
+	Subject   string `json:"sub,omitempty"`
+	IssuedAt  int64  `json:"iat"`
+	Expires   int64  `json:"exp"`
+}
+
+// tokenKeys holds the key signing the tokens, nil on the nodes which only
+// verify them, and the keys verifying them
+type tokenKeys struct {
+	signer ed25519.PrivateKey
+	keys   []ed25519.PublicKey
+}
+
+// the token keys, nil without --token-key
+var tokens atomic.Pointer[tokenKeys]
+
+// loadTokenKeys reads the keys of a PEM file, the first private key signs
+// the new tokens and every key verifies them, to rotate the keys
+func loadTokenKeys(path string) (*tokenKeys, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	tk := &tokenKeys{}
+	for block, rest := pem.Decode(data); block != nil; block, rest = pem.Decode(rest) {
+		var key interface{}
+		switch block.Type {
+		case "PRIVATE KEY":
+			key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+		case "PUBLIC KEY":
+			key, err = x509.ParsePKIXPublicKey(block.Bytes)
+		default:
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch k := key.(type) {
+		case ed25519.PrivateKey:
+			if tk.signer == nil {
+				tk.signer = k
+			}
+			tk.keys = append(tk.keys, k.Public().(ed25519.PublicKey))
+		case ed25519.PublicKey:
+			tk.keys = append(tk.keys, k)
+		default:
+			return nil, fmt.Errorf("%s: not an Ed25519 key", path)
+		}
+	}
+	if len(tk.keys) == 0 {
+		return nil, fmt.Errorf("no Ed25519 key in %s", path)
+	}
+	return tk, nil
+}
+
+// validNamespace reports whether a namespace may be the prefix of a key
+// pattern
+func validNamespace(ns string) bool {
+	return ns != "" && !strings.ContainsAny(ns, `*?[]\`) &&
+		(namespaceSeparator == "" || !strings.Contains(ns, namespaceSeparator))
+}
+
+// newTokenID returns a random token ID
+func newTokenID() (string, error) {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(id), nil
+}
+
+// signToken returns a signed token, prefix.payload.signature: the claims in
+// JSON and the signature of the prefix and the payload, both in base64
+func signToken(prefix string, claims interface{}, sign func(msg []byte) []byte) (string, error) {
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	p := prefix + base64.RawURLEncoding.EncodeToString(payload)
+	return p + "." + base64.RawURLEncoding.EncodeToString(sign([]byte(p))), nil
+}
+
+// openToken checks the signature of a token of signToken, and decodes its
+// claims
+func openToken(prefix, token string, verify func(msg, sig []byte) bool, claims interface{}) error {
+	p, sig64, ok := strings.Cut(strings.TrimPrefix(token, prefix), ".")
+	if !ok || !strings.HasPrefix(token, prefix) {
+		return errors.New("not a token")
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(sig64)
+	if err != nil {
+		return err
+	}
+	if !verify([]byte(prefix+p), sig) {
+		return errors.New("invalid signature")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(p)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(payload, claims)
+}
+
+// issue signs a token of the namespace for ttl
+func (tk *tokenKeys) issue(ns, scope, subject string, ttl time.Duration, now time.Time) (string, error) {
+	if tk.signer == nil {
+		return "", errors.New("ERR the token key has no private key")
+	}
+	if !validNamespace(ns) {
+		return "", fmt.Errorf("ERR invalid namespace '%s'", ns)
+	}
+	if scopeCommands[scope] == nil {
+		return "", fmt.Errorf("ERR invalid scope '%s', expected read, write or admin", scope)
+	}
+	if ttl <= 0 {
+		return "", errors.New("ERR the ttl must be positive")
+	}
+	id, err := newTokenID()
+	if err != nil {
+		return "", err
+	}
+	return signToken(tokenPrefix, scopedToken{ID: id, Namespace: ns, Scope: scope,
+		Subject: subject, IssuedAt: now.Unix(), Expires: now.Add(ttl).Unix()},
+		func(msg []byte) []byte { return ed25519.Sign(tk.signer, msg) })
+}
+
+// verify returns the payload of a token signed by one of the keys and not
+// expired
+func (tk *tokenKeys) verify(token string, now time.Time) (*scopedToken, error) {
+	var st scopedToken
+	err := openToken(tokenPrefix, token, func(msg, sig []byte) bool {
+		for _, key := range tk.keys {
+			if ed25519.Verify(key, msg, sig) {
+				return true
+			}
+		}
+		return false
+	}, &st)
+	if err != nil {
+		return nil, err
+	}
+	if scopeCommands[st.Scope] == nil || !validNamespace(st.Namespace) {
+		return nil, errors.New("invalid token")
+	}
+	if !now.Before(time.Unix(st.Expires, 0)) {
+		return nil, errors.New("token expired")
+	}
+	return &st, nil
+}
+
+// user returns the ACL user of a token: the commands of its scope on the
+// keys of its namespace
+func (st *scopedToken) user() *aclUser {
+	role := &aclRole{name: st.Scope, keys: []string{st.Namespace + namespaceSeparator + "*"}}
+	for _, s := range scopeCommands[st.Scope] {
+		rule, _ := parseACLRule(s)
+		role.rules = append(role.rules, rule)
+	}
+	return &aclUser{name: st.userName(), roles: []*aclRole{role}}
+}
+
+// userName is the name of the connections authenticated with the token
+func (st *scopedToken) userName() string {
+	if st.Subject != "" {
+		return "token:" + st.Namespace + ":" + st.Subject
+	}
+	return "token:" + st.Namespace + ":" + st.ID
+}
+
+// parseIssueToken parses namespace:scope:ttl, the namespace may have colons
+func parseIssueToken(s string) (ns, scope string, ttl time.Duration, err error) {
+	i := strings.LastIndexByte(s, ':')
+	j := -1
+	if i > 0 {
+		j = strings.LastIndexByte(s[:i], ':')
+	}
+	if j < 0 {
+		return "", "", 0, fmt.Errorf("invalid '%s', expected namespace:scope:ttl", s)
+	}
+	ttl, err = time.ParseDuration(s[i+1:])
+	return s[:j], s[j+1 : i], ttl, err
+}
+
+func init() {
+	conf.AddIntermediateCommand("TOKEN", cmdTOKEN)
+}
+
+// cmdTOKEN is TOKEN ISSUE namespace scope ttl [subject] and TOKEN INSPECT
+// token. A connection of a token issues tokens of its namespace only, which
+// expire with it.
+func cmdTOKEN(m rafthub.Machine, args []string) (interface{}, error) {
+	if len(args) < 2 {
+		return nil, rafthub.ErrWrongNumArgs
+	}
+	tk := tokens.Load()
+	if tk == nil {
+		return nil, errTokenKey
+	}
+	now := time.Now()
+	switch strings.ToLower(args[1]) {
+	case "issue":
+		if len(args) != 5 && len(args) != 6 {
+			return nil, rafthub.ErrWrongNumArgs
+		}
+		ttl, err := time.ParseDuration(args[4])
+		if err != nil {
+			return nil, fmt.Errorf("ERR invalid ttl '%s'", args[4])
+		}
+		subject := ""
+		if len(args) == 6 {
+			subject = args[5]
+		}
+		if c, ok := m.Context().(*client); ok {
+			if cc := clients.get(c.id); cc != nil {
+				if st := cc.scopedToken(); st != nil {
+					if args[2] != st.Namespace {
+						return nil, errors.New("NOPERM this token has no permissions on the namespace")
+					}
+					if exp := time.Unix(st.Expires, 0); now.Add(ttl).After(exp) {
+						ttl = exp.Sub(now)
+					}
+				}
+			}
+		}
+		return tk.issue(args[2], strings.ToLower(args[3]), subject, ttl, now)
+	case "inspect":
+		if len(args) != 3 {
+			return nil, rafthub.ErrWrongNumArgs
+		}
+		st, err := tk.verify(args[2], now)
+		if err != nil {
+			return nil, fmt.Errorf("ERR %v", err)
+		}
+		return []interface{}{
+			"id", st.ID, "namespace", st.Namespace, "scope", st.Scope, "subject", st.Subject,
+			"issued", time.Unix(st.IssuedAt, 0).UTC().Format(time.RFC3339),
+			"expires", time.Unix(st.Expires, 0).UTC().Format(time.RFC3339),
+		}, nil
+	}
+	return nil, fmt.Errorf("ERR unknown subcommand '%s'", args[1])
+}
@@ -0,0 +1,177 @@
+//go:build alltest
+// +build alltest
+
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// writeTokenKey writes a PEM file of a new Ed25519 private key and of the
+// public keys
+func writeTokenKey(t *testing.T, path string, public ...ed25519.PublicKey) ed25519.PublicKey {
+	t.Helper()
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+	for _, key := range public {
+		der, err := x509.MarshalPKIXPublicKey(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data = append(data, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})...)
+	}
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return pub
+}
+
+func TestTokenKeys(t *testing.T) {
+	dir := t.TempDir()
+	oldPath, newPath := filepath.Join(dir, "old.pem"), filepath.Join(dir, "new.pem")
+	oldPub := writeTokenKey(t, oldPath)
+	writeTokenKey(t, newPath, oldPub)
+	old, err := loadTokenKeys(oldPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tk, err := loadTokenKeys(newPath)
+	if err != nil || len(tk.keys) != 2 {
+		t.Fatalf("loadTokenKeys: %v %v", tk, err)
+	}
+	now := time.Now()
+	token, err := old.issue("app", scopeWrite, "ci", time.Hour, now)
+	if err != nil || !strings.HasPrefix(token, tokenPrefix) {
+		t.Fatalf("issue: %q %v", token, err)
+	}
+	// the tokens of the old key verify with the new file
+	st, err := tk.verify(token, now)
+	if err != nil || st.Namespace != "app" || st.Scope != scopeWrite || st.userName() != "token:app:ci" {
+		t.Fatalf("verify: %+v %v", st, err)
+	}
+	if _, err := tk.verify(token, now.Add(time.Hour)); err == nil {
+		t.Errorf("expired token verified")
+	}
+	newToken, _ := tk.issue("app", scopeRead, "", time.Hour, now)
+	if _, err := old.verify(newToken, now); err == nil {
+		t.Errorf("token of an unknown key verified")
+	}
+	// a payload changed breaks the signature
+	p, sig, _ := strings.Cut(strings.TrimPrefix(token, tokenPrefix), ".")
+	if _, err := tk.verify(tokenPrefix+p+"x."+sig, now); err == nil {
+		t.Errorf("forged token verified")
+	}
+	for _, c := range []struct{ ns, scope string }{{"", scopeRead}, {"a:b", scopeRead}, {"a*", scopeRead}, {"app", "owner"}} {
+		if _, err := tk.issue(c.ns, c.scope, "", time.Hour, now); err == nil {
+			t.Errorf("issue %q %q: no error", c.ns, c.scope)
+		}
+	}
+	ns, scope, ttl, err := parseIssueToken("app:admin:24h")
+	if err != nil || ns != "app" || scope != scopeAdmin || ttl != 24*time.Hour {
+		t.Errorf("parseIssueToken: %q %q %v %v", ns, scope, ttl, err)
+	}
+	if _, _, _, err := parseIssueToken("app:24h"); err == nil {
+		t.Errorf("parseIssueToken without scope: no error")
+	}
+}
+
+func TestScopedTokens(t *testing.T) {
+	getTestConn()
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "tokens.pem")
+	writeTokenKey(t, path)
+	tk, err := loadTokenKeys(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokens.Store(tk)
+	defer tokens.Store(nil)
+
+	login := func(token string) *redis.Client {
+		c := redis.NewClient(&redis.Options{Addr: "127.0.0.1:11001", Password: token, MaxRetries: -1, PoolSize: 1})
+		t.Cleanup(func() { c.Close() })
+		return c
+	}
+	now := time.Now()
+	read, _ := tk.issue("app", scopeRead, "", time.Hour, now)
+	write, _ := tk.issue("app", scopeWrite, "", time.Hour, now)
+	admin, _ := tk.issue("app", scopeAdmin, "ops", time.Hour, now)
+
+	w := login(write)
+	if err := w.Set(ctx, "app:a", "1", 0).Err(); err != nil {
+		t.Errorf("write SET: %v", err)
+	}
+	if err := w.Set(ctx, "other:a", "1", 0).Err(); err == nil || !strings.HasPrefix(err.Error(), "NOPERM") {
+		t.Errorf("write SET of another namespace: %v", err)
+	}
+	if err := w.Do(ctx, "FLUSHALL").Err(); err == nil || !strings.HasPrefix(err.Error(), "NOPERM") {
+		t.Errorf("write FLUSHALL: %v", err)
+	}
+	if err := w.Do(ctx, "SCRIPT", "FLUSH").Err(); err == nil || !strings.HasPrefix(err.Error(), "NOPERM") {
+		t.Errorf("write SCRIPT FLUSH: %v", err)
+	}
+	r := login(read)
+	if v, err := r.Get(ctx, "app:a").Result(); err != nil || v != "1" {
+		t.Errorf("read GET: %q %v", v, err)
+	}
+	if err := r.Set(ctx, "app:a", "2", 0).Err(); err == nil || !strings.HasPrefix(err.Error(), "NOPERM") {
+		t.Errorf("read SET: %v", err)
+	}
+	if err := r.ZRemRangeByRank(ctx, "app:z", 0, -1).Err(); err == nil || !strings.HasPrefix(err.Error(), "NOPERM") {
+		t.Errorf("read ZREMRANGEBYRANK: %v", err)
+	}
+	if err := r.Do(ctx, "TOKEN", "ISSUE", "app", "read", "1h").Err(); err == nil || !strings.HasPrefix(err.Error(), "NOPERM") {
+		t.Errorf("read TOKEN ISSUE: %v", err)
+	}
+
+	a := login(admin)
+	if v, err := a.Do(ctx, "ACL", "WHOAMI").Text(); v != "token:app:ops" {
+		t.Errorf("ACL WHOAMI: %q %v", v, err)
+	}
+	if err := a.Do(ctx, "TOKEN", "ISSUE", "other", "read", "1h").Err(); err == nil || !strings.HasPrefix(err.Error(), "NOPERM") {
+		t.Errorf("admin TOKEN ISSUE of another namespace: %v", err)
+	}
+	// the tokens issued by a token expire with it
+	issued, err := a.Do(ctx, "TOKEN", "ISSUE", "app", "write", "48h").Text()
+	if err != nil {
+		t.Fatalf("admin TOKEN ISSUE: %v", err)
+	}
+	st, err := tk.verify(issued, now)
+	if err != nil || st.Expires > now.Add(time.Hour+time.Second).Unix() {
+		t.Errorf("issued token: %+v %v", st, err)
+	}
+	if v, err := a.Do(ctx, "TOKEN", "INSPECT", issued).StringSlice(); err != nil || len(v) != 12 || v[5] != "write" {
+		t.Errorf("TOKEN INSPECT: %q %v", v, err)
+	}
+
+	if err := login("ift1.bad.token").Ping(ctx).Err(); err == nil || !strings.HasPrefix(err.Error(), "WRONGPASS") {
+		t.Errorf("bad token: %v", err)
+	}
+	short, _ := tk.issue("app", scopeRead, "", 2*time.Second, now)
+	s := login(short)
+	if err := s.Get(ctx, "app:a").Err(); err != nil {
+		t.Errorf("short GET: %v", err)
+	}
+	time.Sleep(2 * time.Second)
+	if err := s.Get(ctx, "app:a").Err(); err == nil || !strings.HasPrefix(err.Error(), "NOAUTH") {
+		t.Errorf("expired token GET: %v", err)
+	}
+}
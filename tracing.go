@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/exporters/zipkin"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+
+	rafthub "github.com/tidwall/uhaha"
+)
+
+var (
+	// span exporter: otlp-grpc, otlp-http, zipkin or stdout, tracing is
+	// disabled when empty
+	traceExporter string
+	// collector endpoint of the exporter
+	traceEndpoint string
+	// fraction of the commands traced, when their client did not decide
+	traceSampleRatio float64
+)
+
+// tracer of the commands, nil when tracing is disabled
+var tracer trace.Tracer
+
+var errTraceCommand = errors.New("ERR wrong command after the trace context")
+
+type commandFunc func(m rafthub.Machine, args []string) (interface{}, error)
+
+// The commands by lower case name, dispatched by TRACER and TRACEW
+var (
+	readCommands  = make(map[string]commandFunc)
+	writeCommands = make(map[string]commandFunc)
+)
+
+// The start of the process, the writes proposed before were replayed from
+// the log and get no propose span
+var startTime = time.Now()
+
+func init() {
+	// TRACER traceparent command args... runs a read command in the trace of
+	// its client, TRACEW a write command on every node through the log
+	conf.Config.AddReadCommand("TRACER", func(m rafthub.Machine, args []string) (interface{}, error) {
+		return traceCommand(m, args, readCommands, false)
+	})
+	conf.Config.AddWriteCommand("TRACEW", func(m rafthub.Machine, args []string) (interface{}, error) {
+		return traceCommand(m, args, writeCommands, true)
+	})
+}
+
+func traceCommand(m rafthub.Machine, args []string, cmds map[string]commandFunc, write bool) (interface{}, error) {
+	if len(args) < 3 {
+		return nil, rafthub.ErrWrongNumArgs
+	}
+	fn, ok := cmds[strings.ToLower(args[2])]
+	if !ok {
+		return nil, errTraceCommand
+	}
+	carrier := propagation.MapCarrier{"traceparent": args[1]}
+	ctx := propagation.TraceContext{}.Extract(context.Background(), carrier)
+	return runTraced(ctx, m, args[2], write, fn, args[2:])
+}
+
+// traced runs a command in a span, the span of a write is made on every node
+// as the write is applied
+func traced(name string, write bool, fn commandFunc) commandFunc {
+	return func(m rafthub.Machine, args []string) (interface{}, error) {
+		return runTraced(context.Background(), m, name, write, fn, args)
+	}
+}
+
+func runTraced(ctx context.Context, m rafthub.Machine, name string, write bool, fn commandFunc, args []string) (interface{}, error) {
+	if tracer == nil {
+		return fn(m, args)
+	}
+	cmd := strings.ToUpper(name)
+	attrs := trace.WithAttributes(
+		semconv.DBSystemRedis,
+		semconv.DBOperationName(cmd),
+		attribute.String("icefiredb.node", conf.NodeID),
+	)
+	kind := "read "
+	if write {
+		kind = "apply "
+		// the leader stamps a write with its time when proposing it, the
+		// span lasts until the write is committed and applied here
+		if proposed := m.Now(); proposed.After(startTime) {
+			_, span := tracer.Start(ctx, "propose "+cmd, attrs, trace.WithTimestamp(proposed))
+			span.End()
+		}
+	}
+	ctx, span := tracer.Start(ctx, kind+cmd, attrs)
+	defer span.End()
+
+	var committed time.Duration
+	if write && le != nil {
+		committed = le.StoreStat().BatchCommitTotalTime.Get()
+	}
+	v, err := fn(m, args)
+	if write && le != nil {
+		// the writes are applied one at a time, the commit time spent since
+		// the command started is its own
+		if d := le.StoreStat().BatchCommitTotalTime.Get() - committed; d > 0 {
+			end := time.Now()
+			_, es := tracer.Start(ctx, "engine write", attrs, trace.WithTimestamp(end.Add(-d)))
+			es.SetAttributes(attribute.String("icefiredb.engine", storageBackend))
+			es.End(trace.WithTimestamp(end))
+		}
+	}
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	return v, err
+}
+
+// initTracing sets up the exporter of the spans
+func initTracing() error {
+	if traceExporter == "" {
+		return nil
+	}
+	ctx := context.Background()
+	var (
+		exporter sdktrace.SpanExporter
+		err      error
+	)
+	switch traceExporter {
+	case "otlp-grpc":
+		opts := []otlptracegrpc.Option{otlptracegrpc.WithInsecure()}
+		if traceEndpoint != "" {
+			opts = append(opts, otlptracegrpc.WithEndpoint(traceEndpoint))
+		}
+		exporter, err = otlptracegrpc.New(ctx, opts...)
+	case "otlp-http":
+		opts := []otlptracehttp.Option{otlptracehttp.WithInsecure()}
+		if traceEndpoint != "" {
+			opts = append(opts, otlptracehttp.WithEndpoint(traceEndpoint))
+		}
+		exporter, err = otlptracehttp.New(ctx, opts...)
+	case "zipkin":
+		exporter, err = zipkin.New(traceEndpoint)
+	case "stdout":
+		exporter, err = stdouttrace.New()
+	default:
+		return fmt.Errorf("invalid --trace-exporter: '%s'", traceExporter)
+	}
+	if err != nil {
+		return err
+	}
+	res := resource.NewSchemaless(
+		semconv.ServiceName(conf.Name),
+		semconv.ServiceVersion(conf.Version),
+		semconv.ServiceInstanceID(conf.NodeID),
+	)
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(traceSampleRatio))),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	tracer = provider.Tracer("github.com/IceFireDB/IceFireDB")
+	return nil
+}
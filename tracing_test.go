@@ -0,0 +1,61 @@
+//go:build alltest
+// +build alltest
+
+package main
+
+import (
+	"context"
+	"testing"
+
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestTracing(t *testing.T) {
+	c := getTestConn()
+	ctx := context.Background()
+
+	rec := tracetest.NewSpanRecorder()
+	tracer = sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(rec)).Tracer("test")
+	defer func() { tracer = nil }()
+
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	parent := "00-" + traceID + "-00f067aa0ba902b7-01"
+	if err := c.Do(ctx, "TRACEW", parent, "SET", "traced", "v").Err(); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := c.Do(ctx, "TRACER", parent, "GET", "traced").Text(); err != nil || v != "v" {
+		t.Fatalf("GET traced: %q %v", v, err)
+	}
+	if err := c.Do(ctx, "TRACER", parent, "SET", "traced", "w").Err(); err == nil {
+		t.Fatal("a write must not run as a read")
+	}
+	if err := c.Set(ctx, "untraced", "v", 0).Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the spans in the trace of the client by name
+	spans := make(map[string]sdktrace.ReadOnlySpan)
+	untraced := 0
+	for _, s := range rec.Ended() {
+		if s.SpanContext().TraceID().String() == traceID {
+			spans[s.Name()] = s
+		} else {
+			untraced++
+		}
+	}
+	for _, name := range []string{"propose SET", "apply SET", "engine write", "read GET"} {
+		if _, ok := spans[name]; !ok {
+			t.Errorf("span %s missing from the trace", name)
+		}
+	}
+	if untraced == 0 {
+		t.Error("the commands without a trace context must start their own trace")
+	}
+	if s, ok := spans["apply SET"]; ok && s.Parent().SpanID().String() != "00f067aa0ba902b7" {
+		t.Error("the apply span must be a child of the client span")
+	}
+	if s, ok := spans["engine write"]; ok && s.Parent().SpanID() != spans["apply SET"].SpanContext().SpanID() {
+		t.Error("the engine write must be a child of the apply span")
+	}
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/tidwall/redcon"
+	rafthub "github.com/tidwall/uhaha"
+)
+
+// the changes kept for the watchers to resume from
+var watchBacklog = 10000
+
+// the writes a watcher may have pending before it is dropped
+const watchBuffer = 4096
+
+var (
+	errWatchCursor  = errors.New("ERR invalid cursor")
+	errWatchExpired = errors.New("ERR the cursor is out of the backlog of the node, read the keys again and watch from now")
+	errWatchBehind  = errors.New("ERR the watcher fell behind the writes, watch again from the last cursor")
+)
+
+// the change feed of the writes applied by the node
+var watches = &watchHub{subs: make(map[*watcher]struct{})}
+
+func init() {
+	conf.AddIntermediateCommand("WATCHPREFIX", cmdWATCHPREFIX)
+}
+
+// watchChange is a key changed by a write. The cursor of a write is its
+// time in the log, the same on every node and across restarts: the writes
+// of a key are ordered by their cursor.
+type watchChange struct {
+	Cursor int64
+	Op     string
+	Key    string
+	// a write on every key, as FLUSHALL, has no key
+	All bool
+	// the arguments of the write, kept for the change data capture
+	Args []string
+}
+
+// watchHub records the changes applied and passes them to the watchers
+type watchHub struct {
+	mu sync.Mutex
+	// the last changes, by cursor
+	backlog []watchChange
+	// the cursor of the last write recorded, 0 before the first one
+	last int64
+	subs map[*watcher]struct{}
+}
+
+// watcher is a client watching a key prefix
+type watcher struct {
+	prefix string
+	// the changes up to the cursor were passed in the backlog
+	after int64
+	// the changes of each write, closed when the watcher falls behind
+	ch chan []watchChange
+}
+
+func (w *watcher) matches(c watchChange) bool {
+	return c.Cursor > w.after && (c.All || strings.HasPrefix(c.Key, w.prefix))
+}
+
+// watched records the changes of a write command once applied. Every node
+// runs it on the writes of the log, so their cursors agree. The writes of a
+// script are recorded by the commands it calls.
+func watched(name string, fn commandFunc) commandFunc {
+	cmd := strings.ToLower(name)
+	if scriptCommands[cmd] {
+		return fn
+	}
+	return func(m rafthub.Machine, args []string) (interface{}, error) {
+		cursor := m.Now().UnixNano()
+		v, err := fn(m, args)
+		if err == nil {
+			watches.record(cursor, cmd, args)
+		}
+		return v, err
+	}
+}
+
+// record adds the changes of a write to the backlog and passes them to the
+// watchers of their keys
+func (h *watchHub) record(cursor int64, cmd string, args []string) {
+	keys, all := commandKeys(cmd, args)
+	if !all && len(keys) == 0 {
+		return
+	}
+	var cmdArgs []string
+	if len(cdcSinks) > 0 {
+		cmdArgs = append([]string(nil), args[1:]...)
+	}
+	changes := make([]watchChange, 0, len(keys)+1)
+	if all {
+		changes = append(changes, watchChange{Cursor: cursor, Op: cmd, All: true, Args: cmdArgs})
+	}
+	for _, key := range keys {
+		changes = append(changes, watchChange{Cursor: cursor, Op: cmd, Key: key, Args: cmdArgs})
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.last = cursor
+	if watchBacklog > 0 {
+		h.backlog = append(h.backlog, changes...)
+		if n := len(h.backlog) - watchBacklog; n > 0 {
+			h.backlog = h.backlog[n:]
+		}
+	}
+	for w := range h.subs {
+		h.send(w, changes)
+	}
+}
+
+// send passes the changes of a write to a watcher at once, or drops the
+// watcher when it has no room for them
+func (h *watchHub) send(w *watcher, changes []watchChange) {
+	var matched []watchChange
+	for _, c := range changes {
+		if w.matches(c) {
+			matched = append(matched, c)
+		}
+	}
+	if len(matched) == 0 {
+		return
+	}
+	select {
+	case w.ch <- matched:
+	default:
+		delete(h.subs, w)
+		close(w.ch)
+	}
+}
+
+// subscribe starts a watcher of the prefix. With resume, it gets the
+// changes of the backlog after the cursor, the whole backlog for 0, and it
+// returns the cursor the feed starts after.
+func (h *watchHub) subscribe(prefix string, after int64, resume bool) (*watcher, []watchChange, int64, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	w := &watcher{prefix: prefix, after: h.last, ch: make(chan []watchChange, watchBuffer)}
+	var backlog []watchChange
+	if resume {
+		// the backlog has the changes after the cursor when it holds the
+		// cursor, or when the cursor is the last write
+		if after != 0 && (len(h.backlog) == 0 || after < h.backlog[0].Cursor) && (h.last == 0 || after < h.last) {
+			return nil, nil, 0, errWatchExpired
+		}
+		i := sort.Search(len(h.backlog), func(i int) bool { return h.backlog[i].Cursor > after })
+		for _, c := range h.backlog[i:] {
+			if c.All || strings.HasPrefix(c.Key, prefix) {
+				backlog = append(backlog, c)
+			}
+		}
+		// a lagging node passes the changes the client saw elsewhere once
+		if after > w.after {
+			w.after = after
+		}
+	}
+	h.subs[w] = struct{}{}
+	if resume {
+		return w, backlog, after, nil
+	}
+	return w, nil, w.after, nil
+}
+
+// unsubscribe ends a watcher
+func (h *watchHub) unsubscribe(w *watcher) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.subs[w]; ok {
+		delete(h.subs, w)
+		close(w.ch)
+	}
+}
+
+// count returns the watchers of the node
+func (h *watchHub) count() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.subs)
+}
+
+// WATCHPREFIX prefix [cursor]
+// Streams the changes of the keys starting with the prefix, in the order of
+// the log, from now or after a cursor of the feed. The connection replies
+// ["watching", prefix, cursor] then ["change", cursor, op, key] for each
+// key written, with an empty key for FLUSHALL and FLUSHDB, until it is
+// closed or QUIT. A watcher falling behind gets an error and is closed.
+func cmdWATCHPREFIX(m rafthub.Machine, args []string) (interface{}, error) {
+	if len(args) != 2 && len(args) != 3 {
+		return nil, rafthub.ErrWrongNumArgs
+	}
+	var after int64
+	resume := len(args) == 3
+	if resume {
+		var err error
+		if after, err = strconv.ParseInt(args[2], 10, 64); err != nil || after < 0 {
+			return nil, errWatchCursor
+		}
+	}
+	w, backlog, cursor, err := watches.subscribe(args[1], after, resume)
+	if err != nil {
+		return nil, err
+	}
+	return rafthub.Hijack(func(s rafthub.Service, conn rafthub.HijackedConn) {
+		serveWatch(conn, w, backlog, cursor)
+	}), nil
+}
+
+// serveWatch writes the changes of a watcher to its connection until the
+// client leaves
+func serveWatch(conn rafthub.HijackedConn, w *watcher, backlog []watchChange, cursor int64) {
+	defer conn.Close()
+	defer watches.unsubscribe(w)
+	var mu sync.Mutex
+	write := func(v interface{}) error {
+		mu.Lock()
+		defer mu.Unlock()
+		conn.WriteAny(v)
+		return conn.Flush()
+	}
+	change := func(c watchChange) error {
+		return write(resp3Push{"change", strconv.FormatInt(c.Cursor, 10), c.Op, c.Key})
+	}
+	if write(resp3Push{"watching", w.prefix, strconv.FormatInt(cursor, 10)}) != nil {
+		return
+	}
+	left := make(chan struct{})
+	go func() {
+		defer close(left)
+		for {
+			args, err := conn.ReadCommand()
+			if err != nil {
+				return
+			}
+			switch strings.ToLower(args[0]) {
+			case "ping":
+				err = write(redcon.SimpleString("PONG"))
+			case "quit":
+				_ = write(redcon.SimpleString("OK"))
+				return
+			default:
+				err = write(errors.New("ERR only PING and QUIT are allowed while watching"))
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+	for _, c := range backlog {
+		if change(c) != nil {
+			return
+		}
+	}
+	for {
+		select {
+		case <-left:
+			return
+		case changes, ok := <-w.ch:
+			if !ok {
+				_ = write(errWatchBehind)
+				return
+			}
+			for _, c := range changes {
+				if change(c) != nil {
+					return
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,145 @@
+//go:build alltest
+// +build alltest
+
+package main
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	rafthub "github.com/tidwall/uhaha"
+)
+
+func TestWatchHub(t *testing.T) {
+	getTestConn()
+	h := &watchHub{subs: make(map[*watcher]struct{})}
+	if _, _, _, err := h.subscribe("a", 5, true); err != errWatchExpired {
+		t.Errorf("resume on an empty feed: %v", err)
+	}
+	w, _, cursor, _ := h.subscribe("a", 0, false)
+	h.record(10, "set", []string{"set", "a1", "v"})
+	h.record(11, "set", []string{"set", "b1", "v"})
+	h.record(12, "del", []string{"del", "a1", "b1", "a2"})
+	h.record(13, "flushall", []string{"flushall"})
+	if cursor != 0 {
+		t.Errorf("cursor %d", cursor)
+	}
+	var got []watchChange
+	for len(w.ch) > 0 {
+		got = append(got, <-w.ch...)
+	}
+	want := []watchChange{
+		{Cursor: 10, Op: "set", Key: "a1"}, {Cursor: 12, Op: "del", Key: "a1"},
+		{Cursor: 12, Op: "del", Key: "a2"}, {Cursor: 13, Op: "flushall", All: true},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("changes %+v", got)
+	}
+	for i := range want {
+		if got[i].Cursor != want[i].Cursor || got[i].Op != want[i].Op || got[i].Key != want[i].Key || got[i].All != want[i].All {
+			t.Errorf("change %d: %+v, expected %+v", i, got[i], want[i])
+		}
+	}
+	h.unsubscribe(w)
+
+	// a watcher resumes from the backlog, then from the feed
+	w, backlog, cursor, err := h.subscribe("a", 10, true)
+	if err != nil || cursor != 10 || len(backlog) != 3 || backlog[0].Cursor != 12 {
+		t.Errorf("resume after 10: %+v %d %v", backlog, cursor, err)
+	}
+	h.record(14, "set", []string{"set", "a3", "v"})
+	if c := <-w.ch; len(c) != 1 || c[0].Key != "a3" {
+		t.Errorf("change %+v", c)
+	}
+	h.unsubscribe(w)
+	if _, backlog, _, err := h.subscribe("a", 14, true); err != nil || len(backlog) != 0 {
+		t.Errorf("resume after the last write: %+v %v", backlog, err)
+	}
+	if _, backlog, _, err := h.subscribe("b", 0, true); err != nil || len(backlog) != 3 {
+		t.Errorf("resume from the backlog: %+v %v", backlog, err)
+	}
+
+	// the backlog drops the oldest changes
+	defer func(n int) { watchBacklog = n }(watchBacklog)
+	watchBacklog = 2
+	h.record(15, "set", []string{"set", "a4", "v"})
+	if _, _, _, err := h.subscribe("a", 10, true); err != errWatchExpired {
+		t.Errorf("resume out of the backlog: %v", err)
+	}
+
+	// a watcher that falls behind is closed
+	w, _, _, _ = h.subscribe("", 0, false)
+	for i := 0; i <= watchBuffer; i++ {
+		h.record(int64(100+i), "set", []string{"set", "k", "v"})
+	}
+	for range w.ch {
+	}
+	if h.count() != 2 {
+		t.Errorf("watchers %d", h.count())
+	}
+}
+
+func TestWATCHPREFIX(t *testing.T) {
+	c := getTestConn()
+	ctx := context.Background()
+	watch := func(args ...interface{}) redis.Conn {
+		t.Helper()
+		conn, err := rafthub.RedisDial(conf.Addr, "", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.Send("watchprefix", args...)
+		conn.Flush()
+		return conn
+	}
+	receive := func(conn redis.Conn) []string {
+		t.Helper()
+		msg, err := redis.Strings(redis.ReceiveWithTimeout(conn, 5*time.Second))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return msg
+	}
+
+	conn := watch("watch:")
+	defer conn.Close()
+	if msg := receive(conn); len(msg) != 3 || msg[0] != "watching" || msg[1] != "watch:" {
+		t.Fatalf("watching %q", msg)
+	}
+	c.Set(ctx, "watch:a", "1", 0)
+	c.Set(ctx, "other", "1", 0)
+	c.Del(ctx, "watch:a", "other")
+	first := receive(conn)
+	if first[0] != "change" || first[2] != "set" || first[3] != "watch:a" {
+		t.Errorf("change %q", first)
+	}
+	second := receive(conn)
+	if second[2] != "del" || second[3] != "watch:a" || second[1] <= first[1] {
+		t.Errorf("change %q after %q", second, first)
+	}
+	if v, err := redis.String(conn.Do("ping")); err != nil || v != "PONG" {
+		t.Errorf("PING while watching %q %v", v, err)
+	}
+	if _, err := conn.Do("get", "watch:a"); err == nil {
+		t.Errorf("GET while watching")
+	}
+
+	// a watcher resumes after the cursor of a change
+	resumed := watch("watch:", first[1])
+	defer resumed.Close()
+	if msg := receive(resumed); msg[2] != first[1] {
+		t.Errorf("watching %q", msg)
+	}
+	if msg := receive(resumed); msg[1] != second[1] || msg[3] != "watch:a" {
+		t.Errorf("resumed change %q", msg)
+	}
+	if _, err := watch("watch:", "x").Receive(); err == nil || err.Error() != errWatchCursor.Error() {
+		t.Errorf("invalid cursor: %v", err)
+	}
+	if _, err := watch("watch:", strconv.Itoa(1)).Receive(); err == nil || err.Error() != errWatchExpired.Error() {
+		t.Errorf("cursor out of the backlog: %v", err)
+	}
+}
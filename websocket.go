@@ -0,0 +1,232 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/gorilla/websocket"
+	"go.uber.org/zap"
+)
+
+var (
+	// the address of the WebSocket gateway, disabled when empty
+	wsAddr string
+	// the origins of the browsers allowed besides the host of the gateway,
+	// * for any
+	wsOrigins stringList
+)
+
+// the subprotocols of the gateway: json by default, resp tunnels the RESP
+// bytes in binary messages
+const (
+	wsProtocolJSON = "json"
+	wsProtocolRESP = "resp"
+)
+
+// the largest message of a client, the largest value of ledis and its
+// command
+const wsMaxMessage = 1<<30 + 1<<20
+
+var errWSCommand = errors.New("expected a JSON array of strings")
+
+var wsUpgrader = websocket.Upgrader{
+	Subprotocols: []string{wsProtocolJSON, wsProtocolRESP},
+	CheckOrigin:  wsCheckOrigin,
+}
+
+// wsCheckOrigin accepts the clients without an origin, which are not
+// browsers, those of the host of the gateway and those of --ws-origin
+func wsCheckOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	for _, o := range wsOrigins {
+		if o == "*" || strings.EqualFold(o, origin) {
+			return true
+		}
+	}
+	u, err := url.Parse(origin)
+	return err == nil && strings.EqualFold(u.Host, r.Host)
+}
+
+// wsHandler returns the WebSocket gateway. A client is a RESP client of the
+// port of the node, it runs AUTH and meets the ACL as any other.
+func wsHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /", handleWS)
+	return mux
+}
+
+func handleWS(w http.ResponseWriter, r *http.Request) {
+	ws, err := wsUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// the upgrader replied the error
+		return
+	}
+	defer ws.Close()
+	ws.SetReadLimit(wsMaxMessage)
+	nc, err := wsDial(r.RemoteAddr)
+	if err != nil {
+		msg := websocket.FormatCloseMessage(websocket.CloseTryAgainLater, err.Error())
+		_ = ws.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second))
+		return
+	}
+	defer nc.Close()
+	if ws.Subprotocol() == wsProtocolRESP {
+		wsTunnelRESP(ws, nc)
+	} else {
+		wsTunnelJSON(ws, nc)
+	}
+}
+
+// wsDial connects to the port of the node as the RESP clients, for a
+// caller at addr whose address the commands are limited by
+func wsDial(addr string) (net.Conn, error) {
+	var nc net.Conn
+	var err error
+	if serverTLS != nil {
+		nc, err = tls.Dial("tcp", conf.Addr, serverTLS)
+	} else {
+		nc, err = net.Dial("tcp", conf.Addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if _, err := redis.NewConn(nc, 0, 0).Do("gatewayclient", gatewaySecret, addrIP(addr)); err != nil {
+		nc.Close()
+		return nil, err
+	}
+	return nc, nil
+}
+
+// wsTunnelRESP passes the bytes of the client to the node and back
+func wsTunnelRESP(ws *websocket.Conn, nc net.Conn) {
+	go func() {
+		defer ws.Close()
+		buf := make([]byte, 32<<10)
+		for {
+			n, err := nc.Read(buf)
+			if n > 0 && ws.WriteMessage(websocket.BinaryMessage, buf[:n]) != nil {
+				return
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+	for {
+		_, data, err := ws.ReadMessage()
+		if err != nil {
+			return
+		}
+		if _, err := nc.Write(data); err != nil {
+			return
+		}
+	}
+}
+
+// wsTunnelJSON runs the commands of the client, each a JSON array of
+// strings, and sends it each reply in order as {"result": v} or
+// {"error": "..."}. The replies of WATCHPREFIX follow as they come.
+func wsTunnelJSON(ws *websocket.Conn, nc net.Conn) {
+	conn := redis.NewConn(nc, 0, 0)
+	go func() {
+		defer ws.Close()
+		for {
+			v, err := conn.Receive()
+			var reply interface{}
+			if rerr, ok := err.(redis.Error); ok {
+				reply = map[string]string{"error": rerr.Error()}
+			} else if err != nil {
+				return
+			} else {
+				reply = map[string]interface{}{"result": wsValue(v)}
+			}
+			if ws.WriteJSON(reply) != nil {
+				return
+			}
+		}
+	}()
+	for {
+		_, data, err := ws.ReadMessage()
+		if err != nil {
+			return
+		}
+		cmd, args, err := wsCommand(data)
+		if err != nil {
+			msg := websocket.FormatCloseMessage(websocket.CloseInvalidFramePayloadData, err.Error())
+			_ = ws.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second))
+			return
+		}
+		if conn.Send(cmd, args...) != nil || conn.Flush() != nil {
+			return
+		}
+	}
+}
+
+// wsCommand decodes a command of the JSON subprotocol, whose numbers are
+// taken as strings
+func wsCommand(data []byte) (string, []interface{}, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var raw []interface{}
+	if err := dec.Decode(&raw); err != nil || len(raw) == 0 {
+		return "", nil, errWSCommand
+	}
+	args := make([]interface{}, len(raw))
+	for i, v := range raw {
+		switch v := v.(type) {
+		case string:
+			args[i] = v
+		case json.Number:
+			args[i] = v.String()
+		default:
+			return "", nil, errWSCommand
+		}
+	}
+	return args[0].(string), args[1:], nil
+}
+
+// wsValue returns a reply of the node as JSON: the bulk strings are strings
+// and the errors in an array {"error": "..."}
+func wsValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case []byte:
+		return string(v)
+	case redis.Error:
+		return map[string]string{"error": v.Error()}
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, e := range v {
+			out[i] = wsValue(e)
+		}
+		return out
+	}
+	return v
+}
+
+// serveWS serves the WebSocket gateway on --ws-addr, over TLS when the
+// server has --tls-cert and --tls-key
+func serveWS(addr string) {
+	srv := &http.Server{Addr: addr, Handler: wsHandler()}
+	ln, err := net.Listen("tcp", addr)
+	if err == nil {
+		loggers[logServer].Info("serving the WebSocket gateway", zap.String("addr", ln.Addr().String()))
+		fln := &filteredListener{Listener: ln, name: listenerWS}
+		if conf.TLSCertPath != "" {
+			err = srv.ServeTLS(fln, conf.TLSCertPath, conf.TLSKeyPath)
+		} else {
+			err = srv.Serve(fln)
+		}
+	}
+	loggers[logServer].Error("WebSocket listen fail", zap.Error(err))
+}
@@ -0,0 +1,103 @@
+//go:build alltest
+// +build alltest
+
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestWebSocket(t *testing.T) {
+	getTestConn()
+	srv := httptest.NewServer(wsHandler())
+	defer srv.Close()
+	url := "ws" + strings.TrimPrefix(srv.URL, "http")
+	dial := func(protocol string, header http.Header) *websocket.Conn {
+		t.Helper()
+		d := websocket.Dialer{Subprotocols: []string{protocol}}
+		ws, _, err := d.Dial(url, header)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ws
+	}
+	type reply struct {
+		Result interface{} `json:"result"`
+		Error  string      `json:"error"`
+	}
+	do := func(ws *websocket.Conn, cmd string) reply {
+		t.Helper()
+		if err := ws.WriteMessage(websocket.TextMessage, []byte(cmd)); err != nil {
+			t.Fatal(err)
+		}
+		var r reply
+		if err := ws.ReadJSON(&r); err != nil {
+			t.Fatal(err)
+		}
+		return r
+	}
+
+	ws := dial(wsProtocolJSON, nil)
+	defer ws.Close()
+	if r := do(ws, `["SET","ws:k","v"]`); r.Result != "OK" {
+		t.Errorf("SET %+v", r)
+	}
+	if r := do(ws, `["GET","ws:k"]`); r.Result != "v" {
+		t.Errorf("GET %+v", r)
+	}
+	if r := do(ws, `["INCRBY","ws:n",2]`); r.Result != float64(2) {
+		t.Errorf("INCRBY %+v", r)
+	}
+	if r := do(ws, `["NOPE"]`); r.Error == "" {
+		t.Errorf("an unknown command %+v", r)
+	}
+	ws.WriteMessage(websocket.TextMessage, []byte(`{"set":1}`))
+	if _, _, err := ws.ReadMessage(); !websocket.IsCloseError(err, websocket.CloseInvalidFramePayloadData) {
+		t.Errorf("a message that is not a command: %v", err)
+	}
+
+	// the resp subprotocol passes the bytes
+	raw := dial(wsProtocolRESP, nil)
+	defer raw.Close()
+	raw.WriteMessage(websocket.BinaryMessage, []byte("*2\r\n$3\r\nGET\r\n$4\r\nws:k\r\n"))
+	if _, data, err := raw.ReadMessage(); err != nil || string(data) != "$1\r\nv\r\n" {
+		t.Errorf("GET over resp %q %v", data, err)
+	}
+
+	// the browsers of other origins are refused
+	d := websocket.Dialer{}
+	if _, resp, err := d.Dial(url, http.Header{"Origin": {"http://example.com"}}); err == nil || resp.StatusCode != http.StatusForbidden {
+		t.Errorf("a foreign origin was accepted")
+	}
+	defer func() { wsOrigins = nil }()
+	wsOrigins = stringList{"http://example.com"}
+	dial(wsProtocolJSON, http.Header{"Origin": {"http://example.com"}}).Close()
+
+	// the clients authenticate as ACL users
+	path := filepath.Join(t.TempDir(), "acl.json")
+	writeACL(t, path, "pw", nil, aclUserConfig{Name: "reader", Roles: []string{"readonly"}})
+	if err := acl.load(path); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		acl.mu.Lock()
+		acl.users, acl.roles, acl.providers, acl.failures = nil, nil, nil, make(map[string]*aclFailures)
+		acl.mu.Unlock()
+	}()
+	user := dial(wsProtocolJSON, nil)
+	defer user.Close()
+	if r := do(user, `["AUTH","reader","pw"]`); r.Result != "OK" {
+		t.Errorf("AUTH %+v", r)
+	}
+	if r := do(user, `["SET","ws:k","w"]`); !strings.HasPrefix(r.Error, "NOPERM") {
+		t.Errorf("SET of the reader %+v", r)
+	}
+	getTestConn().Del(context.Background(), "ws:k", "ws:n")
+}